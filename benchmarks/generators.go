@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+// histogramBounds are the bucket bounds of the generated histograms, in ms
+var histogramBounds = []float64{5, 10, 25, 50, 100, 250, 500, 1000, 2500}
+
+// logTemplates are the shapes of the generated log bodies; each of the
+// -operations templates is one of them with its own message ID
+var logTemplates = []string{
+	"request %d handled in %dms",
+	"order %d processed, %d items",
+	"cache miss for key user:%d after %dms",
+	"retrying call %d, attempt %d",
+	"connection %d closed after %dms idle",
+}
+
+// generatorOptions set the cardinality of the generated data
+type generatorOptions struct {
+	services        int
+	operations      int
+	attrCardinality int
+	extraAttrs      int
+	metricNames     int
+	series          int
+	runID           string
+}
+
+// generator builds and sends batches of each signal
+type generator struct {
+	traces  coltracepb.TraceServiceClient
+	metrics colmetricspb.MetricsServiceClient
+	logs    collogspb.LogsServiceClient
+	opts    generatorOptions
+	start   time.Time
+	// counted is the running total of the counters, so cumulative sums
+	// only grow
+	counted atomic.Uint64
+}
+
+func newGenerator(conn *grpc.ClientConn, opts generatorOptions) *generator {
+	if opts.operations <= 0 {
+		opts.operations = 1
+	}
+	if opts.attrCardinality <= 0 {
+		opts.attrCardinality = 1
+	}
+	if opts.metricNames <= 0 {
+		opts.metricNames = 1
+	}
+	if opts.series <= 0 {
+		opts.series = 1
+	}
+	return &generator{
+		traces:  coltracepb.NewTraceServiceClient(conn),
+		metrics: colmetricspb.NewMetricsServiceClient(conn),
+		logs:    collogspb.NewLogsServiceClient(conn),
+		opts:    opts,
+		start:   time.Now(),
+	}
+}
+
+// send exports a batch of n items of signal
+func (g *generator) send(ctx context.Context, rng *rand.Rand, signal string, n, workerID int) error {
+	resource := g.resource(rng, workerID)
+	scope := &commonpb.InstrumentationScope{Name: "benchmark", Version: "1.0.0"}
+	var err error
+	switch signal {
+	case signalMetrics:
+		_, err = g.metrics.Export(ctx, &colmetricspb.ExportMetricsServiceRequest{
+			ResourceMetrics: []*metricspb.ResourceMetrics{{
+				Resource:     resource,
+				ScopeMetrics: []*metricspb.ScopeMetrics{{Scope: scope, Metrics: g.generateMetrics(rng, n)}},
+			}},
+		})
+	case signalLogs:
+		_, err = g.logs.Export(ctx, &collogspb.ExportLogsServiceRequest{
+			ResourceLogs: []*logspb.ResourceLogs{{
+				Resource:  resource,
+				ScopeLogs: []*logspb.ScopeLogs{{Scope: scope, LogRecords: g.generateLogs(rng, n)}},
+			}},
+		})
+	default:
+		_, err = g.traces.Export(ctx, &coltracepb.ExportTraceServiceRequest{
+			ResourceSpans: []*tracepb.ResourceSpans{{
+				Resource:   resource,
+				ScopeSpans: []*tracepb.ScopeSpans{{Scope: scope, Spans: g.generateSpans(rng, n)}},
+			}},
+		})
+	}
+	return err
+}
+
+// resource names the service of a batch: the worker's own, or one of
+// -services
+func (g *generator) resource(rng *rand.Rand, workerID int) *resourcepb.Resource {
+	service := workerID
+	if g.opts.services > 0 {
+		service = rng.Intn(g.opts.services)
+	}
+	return &resourcepb.Resource{
+		Attributes: []*commonpb.KeyValue{
+			stringAttr("service.name", fmt.Sprintf("benchmark-service-%d", service)),
+			stringAttr("service.namespace", "benchmark"),
+			stringAttr(runIDAttribute, g.opts.runID),
+		},
+	}
+}
+
+// attributes returns the high-cardinality attributes of one item, and
+// the -attrs extra ones
+func (g *generator) attributes(rng *rand.Rand, attrs ...*commonpb.KeyValue) []*commonpb.KeyValue {
+	attrs = append(attrs, stringAttr("user.id", fmt.Sprintf("user-%d", rng.Intn(g.opts.attrCardinality))))
+	for i := 0; i < g.opts.extraAttrs; i++ {
+		attrs = append(attrs, stringAttr(fmt.Sprintf("attr.%d", i), fmt.Sprintf("value-%d", rng.Intn(g.opts.attrCardinality))))
+	}
+	return attrs
+}
+
+func (g *generator) generateSpans(rng *rand.Rand, count int) []*tracepb.Span {
+	spans := make([]*tracepb.Span, count)
+	now := time.Now()
+
+	for i := 0; i < count; i++ {
+		duration := time.Duration(rng.Int63n(1000)) * time.Millisecond
+		status := tracepb.Status_STATUS_CODE_OK
+		code := int64(200)
+		if rng.Intn(100) == 0 {
+			status, code = tracepb.Status_STATUS_CODE_ERROR, 500
+		}
+
+		spans[i] = &tracepb.Span{
+			TraceId:           randomBytes(rng, 16),
+			SpanId:            randomBytes(rng, 8),
+			ParentSpanId:      randomBytes(rng, 8),
+			Name:              fmt.Sprintf("operation-%d", rng.Intn(g.opts.operations)),
+			Kind:              tracepb.Span_SPAN_KIND_INTERNAL,
+			StartTimeUnixNano: uint64(now.Add(-duration).UnixNano()),
+			EndTimeUnixNano:   uint64(now.UnixNano()),
+			Status:            &tracepb.Status{Code: status},
+			Attributes: g.attributes(rng,
+				stringAttr(runIDAttribute, g.opts.runID),
+				stringAttr("http.method", "GET"),
+				intAttr("http.status_code", code),
+			),
+		}
+	}
+
+	return spans
+}
+
+// generateMetrics returns count data points of the -metric-names metrics,
+// a third each gauges, monotonic cumulative sums and histograms, with
+// points of the same metric in one Metric
+func (g *generator) generateMetrics(rng *rand.Rand, count int) []*metricspb.Metric {
+	now := uint64(time.Now().UnixNano())
+	start := uint64(g.start.UnixNano())
+	byName := make(map[int]*metricspb.Metric)
+	var metrics []*metricspb.Metric
+
+	for i := 0; i < count; i++ {
+		id := rng.Intn(g.opts.metricNames)
+		attrs := g.attributes(rng, stringAttr("series.id", fmt.Sprintf("series-%d", rng.Intn(g.opts.series))))
+		m, ok := byName[id]
+		if !ok {
+			m = newMetric(id)
+			byName[id] = m
+			metrics = append(metrics, m)
+		}
+
+		switch data := m.Data.(type) {
+		case *metricspb.Metric_Gauge:
+			data.Gauge.DataPoints = append(data.Gauge.DataPoints, &metricspb.NumberDataPoint{
+				Attributes:   attrs,
+				TimeUnixNano: now,
+				Value:        &metricspb.NumberDataPoint_AsDouble{AsDouble: rng.Float64() * 100},
+			})
+		case *metricspb.Metric_Sum:
+			data.Sum.DataPoints = append(data.Sum.DataPoints, &metricspb.NumberDataPoint{
+				Attributes:        attrs,
+				StartTimeUnixNano: start,
+				TimeUnixNano:      now,
+				Value:             &metricspb.NumberDataPoint_AsInt{AsInt: int64(g.counted.Add(uint64(rng.Intn(10) + 1)))},
+			})
+		case *metricspb.Metric_Histogram:
+			data.Histogram.DataPoints = append(data.Histogram.DataPoints, g.histogramPoint(rng, attrs, start, now))
+		}
+	}
+
+	return metrics
+}
+
+// newMetric creates the metric with the given index, its kind chosen by
+// the index
+func newMetric(id int) *metricspb.Metric {
+	switch id % 3 {
+	case 1:
+		return &metricspb.Metric{
+			Name: fmt.Sprintf("benchmark.requests.%d", id),
+			Unit: "1",
+			Data: &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+				AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+				IsMonotonic:            true,
+			}},
+		}
+	case 2:
+		return &metricspb.Metric{
+			Name: fmt.Sprintf("benchmark.duration.%d", id),
+			Unit: "ms",
+			Data: &metricspb.Metric_Histogram{Histogram: &metricspb.Histogram{
+				AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA,
+			}},
+		}
+	}
+	return &metricspb.Metric{
+		Name: fmt.Sprintf("benchmark.utilization.%d", id),
+		Unit: "%",
+		Data: &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{}},
+	}
+}
+
+// histogramPoint summarizes 1 to 100 log-normally distributed durations
+func (g *generator) histogramPoint(rng *rand.Rand, attrs []*commonpb.KeyValue, start, now uint64) *metricspb.HistogramDataPoint {
+	counts := make([]uint64, len(histogramBounds)+1)
+	n := rng.Intn(100) + 1
+	sum, lo, hi := 0.0, math.Inf(1), 0.0
+	for j := 0; j < n; j++ {
+		v := math.Exp(rng.NormFloat64() + 4) // median about 55ms
+		sum += v
+		lo, hi = math.Min(lo, v), math.Max(hi, v)
+		bucket := len(histogramBounds)
+		for b, bound := range histogramBounds {
+			if v <= bound {
+				bucket = b
+				break
+			}
+		}
+		counts[bucket]++
+	}
+	return &metricspb.HistogramDataPoint{
+		Attributes:        attrs,
+		StartTimeUnixNano: start,
+		TimeUnixNano:      now,
+		Count:             uint64(n),
+		Sum:               &sum,
+		Min:               &lo,
+		Max:               &hi,
+		BucketCounts:      counts,
+		ExplicitBounds:    histogramBounds,
+	}
+}
+
+// generateLogs returns count log records, most of them INFO, with a body
+// from one of the -operations templates and a trace context on half of them
+func (g *generator) generateLogs(rng *rand.Rand, count int) []*logspb.LogRecord {
+	records := make([]*logspb.LogRecord, count)
+	now := uint64(time.Now().UnixNano())
+
+	for i := 0; i < count; i++ {
+		severity, text := logspb.SeverityNumber_SEVERITY_NUMBER_INFO, "INFO"
+		switch x := rng.Intn(100); {
+		case x < 2:
+			severity, text = logspb.SeverityNumber_SEVERITY_NUMBER_ERROR, "ERROR"
+		case x < 10:
+			severity, text = logspb.SeverityNumber_SEVERITY_NUMBER_WARN, "WARN"
+		case x < 20:
+			severity, text = logspb.SeverityNumber_SEVERITY_NUMBER_DEBUG, "DEBUG"
+		}
+		template := rng.Intn(g.opts.operations)
+		body := fmt.Sprintf("[msg-%d] ", template) + fmt.Sprintf(logTemplates[template%len(logTemplates)], rng.Intn(100000), rng.Intn(1000))
+
+		record := &logspb.LogRecord{
+			TimeUnixNano:         now,
+			ObservedTimeUnixNano: now,
+			SeverityNumber:       severity,
+			SeverityText:         text,
+			Body:                 &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: body}},
+			Attributes:           g.attributes(rng, stringAttr(runIDAttribute, g.opts.runID), stringAttr("code.function", fmt.Sprintf("handler%d", template))),
+		}
+		if rng.Intn(2) == 0 {
+			record.TraceId = randomBytes(rng, 16)
+			record.SpanId = randomBytes(rng, 8)
+		}
+		records[i] = record
+	}
+
+	return records
+}
+
+func stringAttr(key, value string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{Key: key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}}}
+}
+
+func intAttr(key string, value int64) *commonpb.KeyValue {
+	return &commonpb.KeyValue{Key: key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: value}}}
+}
+
+func randomBytes(rng *rand.Rand, n int) []byte {
+	b := make([]byte, n)
+	rng.Read(b)
+	return b
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"sync"
+	"time"
+)
+
+// Latencies are recorded in microseconds into log-linear buckets like an
+// HdrHistogram with 3 significant digits: values below subBuckets are
+// exact, and each further power of two is split into subBuckets/2 slots,
+// so a recorded value is off by at most 1/1024 of itself.
+const (
+	subBuckets     = 2048
+	subBucketShift = 11 // log2(subBuckets)
+	halfBuckets    = subBuckets / 2
+)
+
+// histogram is a latency histogram. It is safe for concurrent use.
+type histogram struct {
+	mu     sync.Mutex
+	counts []uint64
+	total  uint64
+	min    int64
+	max    int64
+	sum    float64 // of the values, for the mean
+	sumSq  float64 // of the values squared, for the standard deviation
+}
+
+// bucketIndex returns the bucket of a value in microseconds
+func bucketIndex(v int64) int {
+	if v < subBuckets {
+		return int(v)
+	}
+	shift := bits.Len64(uint64(v)) - subBucketShift
+	return subBuckets + (shift-1)*halfBuckets + int(v>>shift) - halfBuckets
+}
+
+// bucketValue returns the middle of the values of a bucket
+func bucketValue(index int) float64 {
+	if index < subBuckets {
+		return float64(index)
+	}
+	shift := (index-subBuckets)/halfBuckets + 1
+	lower := int64((index-subBuckets)%halfBuckets+halfBuckets) << shift
+	return float64(lower) + float64(int64(1)<<shift-1)/2
+}
+
+// record adds one latency
+func (h *histogram) record(d time.Duration) {
+	h.recordN(d, 1)
+}
+
+// recordN adds n equal latencies
+func (h *histogram) recordN(d time.Duration, n uint64) {
+	if n == 0 {
+		return
+	}
+	v := d.Microseconds()
+	if v < 0 {
+		v = 0
+	}
+	i := bucketIndex(v)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if i >= len(h.counts) {
+		counts := make([]uint64, i+halfBuckets)
+		copy(counts, h.counts)
+		h.counts = counts
+	}
+	h.counts[i] += n
+	if h.total == 0 || v < h.min {
+		h.min = v
+	}
+	if v > h.max {
+		h.max = v
+	}
+	h.total += n
+	h.sum += float64(v) * float64(n)
+	h.sumSq += float64(v) * float64(v) * float64(n)
+}
+
+// percentile returns the latency in ms below which q percent of the
+// recorded latencies fall
+func (h *histogram) percentile(q float64) float64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.percentileLocked(q)
+}
+
+func (h *histogram) percentileLocked(q float64) float64 {
+	if h.total == 0 {
+		return 0
+	}
+	target := uint64(math.Ceil(q / 100 * float64(h.total)))
+	if target == 0 {
+		target = 1
+	}
+	var seen uint64
+	for i, c := range h.counts {
+		if seen += c; seen >= target {
+			return math.Min(math.Max(bucketValue(i), float64(h.min)), float64(h.max)) / 1000
+		}
+	}
+	return float64(h.max) / 1000
+}
+
+// summary returns the latency statistics, in ms
+func (h *histogram) summary() LatencySummary {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.total == 0 {
+		return LatencySummary{}
+	}
+	return LatencySummary{
+		Min:  float64(h.min) / 1000,
+		Mean: h.sum / float64(h.total) / 1000,
+		P50:  h.percentileLocked(50),
+		P90:  h.percentileLocked(90),
+		P99:  h.percentileLocked(99),
+		P999: h.percentileLocked(99.9),
+		Max:  float64(h.max) / 1000,
+	}
+}
+
+// ticksPerHalf is how many percentiles writeDistribution reports between
+// 0 and 50%, 50 and 75%, 75 and 87.5% and so on
+const ticksPerHalf = 5
+
+// writeDistribution writes the percentile distribution in ms in the .hgrm
+// text format of HdrHistogram, which its plotter reads
+func (h *histogram) writeDistribution(w io.Writer) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, err := fmt.Fprintf(w, "%12s %14s %10s %14s\n\n", "Value", "Percentile", "TotalCount", "1/(1-Percentile)"); err != nil {
+		return err
+	}
+	if h.total > 0 {
+		for half := 0; ; half++ {
+			from := 100 * (1 - math.Pow(2, -float64(half)))
+			step := 100 * math.Pow(2, -float64(half+1)) / ticksPerHalf
+			if 1/(1-from/100) > float64(h.total) {
+				break
+			}
+			for tick := 0; tick < ticksPerHalf; tick++ {
+				q := from + float64(tick)*step
+				count := uint64(math.Ceil(q / 100 * float64(h.total)))
+				fmt.Fprintf(w, "%12.3f %2.12f %10d %14.2f\n", h.percentileLocked(q), q/100, count, 1/(1-q/100))
+			}
+		}
+		fmt.Fprintf(w, "%12.3f %2.12f %10d\n", float64(h.max)/1000, 1.0, h.total)
+	}
+	mean := 0.0
+	stddev := 0.0
+	if h.total > 0 {
+		mean = h.sum / float64(h.total)
+		stddev = math.Sqrt(math.Max(h.sumSq/float64(h.total)-mean*mean, 0))
+	}
+	fmt.Fprintf(w, "#[Mean    = %12.3f, StdDeviation   = %12.3f]\n", mean/1000, stddev/1000)
+	fmt.Fprintf(w, "#[Max     = %12.3f, Total count    = %12d]\n", float64(h.max)/1000, h.total)
+	buckets := 1
+	if n := len(h.counts); n > subBuckets {
+		buckets += (n - subBuckets + halfBuckets - 1) / halfBuckets
+	}
+	_, err := fmt.Fprintf(w, "#[Buckets = %12d, SubBuckets     = %12d]\n", buckets, subBuckets)
+	return err
+}
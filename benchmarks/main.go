@@ -6,44 +6,274 @@ import (
 	"fmt"
 	"log"
 	"math/rand"
+	"os"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
+)
 
-	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
-	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
-	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
-	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+// Signals the load test can send
+const (
+	signalTraces  = "traces"
+	signalMetrics = "metrics"
+	signalLogs    = "logs"
+	signalMixed   = "mixed"
 )
 
+var allSignals = []string{signalTraces, signalMetrics, signalLogs}
+
 var (
 	endpoint      = flag.String("endpoint", "localhost:4317", "OTLP gRPC endpoint")
 	duration      = flag.Duration("duration", 60*time.Second, "Test duration")
-	ratePerSecond = flag.Int("rate", 10000, "Target spans per second")
+	ratePerSecond = flag.Int("rate", 10000, "Target items (spans, data points or log records) per second")
 	numWorkers    = flag.Int("workers", 10, "Number of concurrent workers")
-	batchSize     = flag.Int("batch", 100, "Spans per batch")
+	batchSize     = flag.Int("batch", 100, "Items per batch")
+	signalFlag    = flag.String("signal", signalTraces, "Signal to send: traces, metrics, logs or mixed")
+	mixFlag       = flag.String("mix", "traces=60,metrics=20,logs=20", "Share of each signal in the items of -signal mixed")
+
+	// Load profile and soak test
+	profileFlag    = flag.String("profile", profileFlat, "Shape of the target rate: flat, step, spike or sine")
+	steps          = flag.Int("steps", 5, "Steps of -profile step, rising evenly to -rate over the duration")
+	spikeFactor    = flag.Float64("spike-factor", 5, "Multiple of -rate sent during a spike of -profile spike")
+	spikeEvery     = flag.Duration("spike-every", time.Minute, "Time from one spike to the next")
+	spikeLength    = flag.Duration("spike-length", 10*time.Second, "Length of a spike")
+	sinePeriod     = flag.Duration("sine-period", 5*time.Minute, "Period of -profile sine")
+	sineAmplitude  = flag.Float64("sine-amplitude", 0.5, "Swing of -profile sine as a fraction of -rate, below 1")
+	soak           = flag.Duration("soak", 0, "Run a soak test for this long instead of -duration, sampling the collector's resources")
+	metricsURL     = flag.String("collector-metrics", "", "Collector metrics endpoint to sample; -soak defaults it to port 9090 of the -endpoint host")
+	sampleInterval = flag.Duration("sample-interval", 10*time.Second, "How often the load and the collector's resources are sampled")
+	timelinePath   = flag.String("timeline", "", "Write the samples to this CSV file")
+
+	// Replay of collector captures
+	replayFiles = flag.String("replay", "", "Comma-separated capture files to send instead of generated data")
+	replaySpeed = flag.Float64("replay-speed", 1, "Pace of -replay relative to the capture: 2 is twice as fast, 0 as fast as the workers can send")
+	replayLoop  = flag.Bool("replay-loop", false, "Replay the captures again, with new trace IDs, until the duration ends")
+
+	// Cardinality of the generated data
+	services        = flag.Int("services", 0, "Distinct service names; 0 gives each worker its own")
+	operations      = flag.Int("operations", 100, "Distinct span names and log message templates")
+	attrCardinality = flag.Int("attr-cardinality", 1000, "Distinct values of the high-cardinality attributes (user.id and the -attrs attributes)")
+	extraAttrs      = flag.Int("attrs", 0, "Extra attributes attr.0 to attr.N-1 on each span, data point and log record")
+	metricNames     = flag.Int("metric-names", 20, "Distinct metric names, split between gauges, counters and histograms")
+	series          = flag.Int("series", 100, "Distinct attribute sets (series) per metric")
+
+	// Results
+	warmup     = flag.Duration("warmup", 0, "Load sent before the measured -duration and left out of the results")
+	outputPath = flag.String("output", "", "Write the results to this file, as CSV if it ends in .csv and as JSON otherwise")
+	hdrPrefix  = flag.String("hdr", "", "Write each signal's latency distribution to <prefix>-<signal>.hgrm in HdrHistogram format")
+	baseline   = flag.String("baseline", "", "Compare the results with those of an earlier -output file and exit with status 1 on a regression")
+	tolerance  = flag.Float64("tolerance", 10, "Percent by which throughput may drop or p50/p90/p99 latency rise before -baseline reports a regression")
+
+	// Closed-loop verification
+	runID          = flag.String("run-id", "", "Value of the benchmark.run_id attribute on every request, span and log record; empty generates one")
+	verifyURL      = flag.String("verify", "", "ClickHouse HTTP URL, e.g. http://localhost:8123, to check that every item sent was stored")
+	verifyDatabase = flag.String("verify-database", "otel", "ClickHouse database of the otel tables")
+	verifyUser     = flag.String("verify-user", "default", "ClickHouse user")
+	verifyPassword = flag.String("verify-password", os.Getenv("CLICKHOUSE_PASSWORD"), "ClickHouse password; defaults to $CLICKHOUSE_PASSWORD")
+	verifyInterval = flag.Duration("verify-interval", time.Second, "How often the stored items are counted")
+	verifyTimeout  = flag.Duration("verify-timeout", 2*time.Minute, "How long to wait after the load for the last items to be stored")
+	maxLoss        = flag.Float64("max-loss", 0, "Percent of the items that may be missing before -verify fails the run")
 )
 
+// signalStats counts the requests and items of one signal, and the
+// latencies of its successful requests
+type signalStats struct {
+	sent      atomic.Uint64
+	succeeded atomic.Uint64
+	failed    atomic.Uint64
+	requests  atomic.Uint64
+	latency   histogram
+	// delivered counts the items of successful requests, warm-up
+	// included, for -verify
+	delivered atomic.Uint64
+}
+
+// Stats counts what the workers sent once the warm-up is over, by signal
 type Stats struct {
-	spansSent     atomic.Uint64
-	spansSucceeded atomic.Uint64
-	spansFailed   atomic.Uint64
-	totalLatency  atomic.Uint64
-	requests      atomic.Uint64
+	signals   map[string]*signalStats
+	measuring atomic.Bool
+	startedAt time.Time // of the measurement, set before measuring
+}
+
+func newStats() *Stats {
+	s := &Stats{signals: make(map[string]*signalStats)}
+	for _, signal := range allSignals {
+		s.signals[signal] = &signalStats{}
+	}
+	return s
+}
+
+// start ends the warm-up
+func (s *Stats) start() {
+	s.startedAt = time.Now()
+	s.measuring.Store(true)
+}
+
+// elapsed returns the time since the warm-up ended
+func (s *Stats) elapsed() time.Duration {
+	if !s.measuring.Load() {
+		return 0
+	}
+	return time.Since(s.startedAt)
+}
+
+// record adds one Export request of n items, unless the warm-up is on
+func (s *Stats) record(signal string, n int, latency time.Duration, err error) {
+	st := s.signals[signal]
+	if err == nil {
+		st.delivered.Add(uint64(n))
+	}
+	if !s.measuring.Load() {
+		return
+	}
+	st.requests.Add(1)
+	st.sent.Add(uint64(n))
+	if err != nil {
+		st.failed.Add(uint64(n))
+	} else {
+		st.succeeded.Add(uint64(n))
+		st.latency.record(latency)
+	}
+}
+
+// results summarizes the signals that sent anything
+func (s *Stats) results(end time.Time) *Results {
+	if !s.measuring.Load() {
+		s.startedAt = end // the run ended during the warm-up
+	}
+	r := &Results{
+		StartedAt: s.startedAt,
+		Duration:  end.Sub(s.startedAt).Seconds(),
+		Endpoint:  *endpoint,
+		Signal:    *signalFlag,
+		Rate:      *ratePerSecond,
+		Workers:   *numWorkers,
+		Batch:     *batchSize,
+		Profile:   *profileFlag,
+	}
+	if *replayFiles != "" {
+		r.Profile = "replay"
+	}
+	for _, signal := range allSignals {
+		st := s.signals[signal]
+		if st.requests.Load() == 0 {
+			continue
+		}
+		r.Signals = append(r.Signals, Result{
+			Signal:         signal,
+			Requests:       st.requests.Load(),
+			Sent:           st.sent.Load(),
+			Succeeded:      st.succeeded.Load(),
+			Failed:         st.failed.Load(),
+			ItemsPerSecond: float64(st.succeeded.Load()) / r.Duration,
+			Latency:        st.latency.summary(),
+		})
+	}
+	return r
 }
 
 func main() {
 	flag.Parse()
 
+	mix, err := parseMix(*signalFlag, *mixFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if *numWorkers <= 0 || *batchSize <= 0 || *ratePerSecond <= 0 {
+		fmt.Fprintln(os.Stderr, "-rate, -workers and -batch must be positive")
+		os.Exit(2)
+	}
+	if *soak > 0 {
+		*duration = *soak
+		if *metricsURL == "" {
+			*metricsURL = defaultMetricsURL(*endpoint)
+		}
+	}
+	if *sampleInterval <= 0 {
+		fmt.Fprintln(os.Stderr, "-sample-interval must be positive")
+		os.Exit(2)
+	}
+	profile := &loadProfile{
+		name:          *profileFlag,
+		rate:          float64(*ratePerSecond),
+		duration:      *duration,
+		steps:         *steps,
+		spikeFactor:   *spikeFactor,
+		spikeEvery:    *spikeEvery,
+		spikeLength:   *spikeLength,
+		sinePeriod:    *sinePeriod,
+		sineAmplitude: *sineAmplitude,
+	}
+	if err := profile.validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	var replayPaths []string
+	if *replayFiles != "" {
+		if profile.name != profileFlat || *verifyURL != "" {
+			fmt.Fprintln(os.Stderr, "-replay cannot be combined with -profile or -verify")
+			os.Exit(2)
+		}
+		if *replaySpeed < 0 {
+			fmt.Fprintln(os.Stderr, "-replay-speed must not be negative")
+			os.Exit(2)
+		}
+		// Open each file up front, so a wrong path fails before the run
+		for _, path := range strings.Split(*replayFiles, ",") {
+			path = strings.TrimSpace(path)
+			c, err := openCapture(path)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(2)
+			}
+			c.Close()
+			replayPaths = append(replayPaths, path)
+		}
+	}
+
 	log.Printf("Starting load test:")
 	log.Printf("  Endpoint: %s", *endpoint)
 	log.Printf("  Duration: %s", *duration)
-	log.Printf("  Target rate: %d spans/sec", *ratePerSecond)
+	if replayPaths != nil {
+		log.Printf("  Replay: %s at %gx speed", strings.Join(replayPaths, ", "), *replaySpeed)
+	} else {
+		log.Printf("  Signal: %s", *signalFlag)
+		if *signalFlag == signalMixed {
+			log.Printf("  Mix: %s", mix)
+		}
+		log.Printf("  Target rate: %d items/sec", *ratePerSecond)
+		if profile.name != profileFlat {
+			log.Printf("  Profile: %s", profile)
+		}
+	}
+	if *metricsURL != "" {
+		log.Printf("  Sampling collector: %s every %s", *metricsURL, *sampleInterval)
+	}
 	log.Printf("  Workers: %d", *numWorkers)
-	log.Printf("  Batch size: %d", *batchSize)
+	if replayPaths == nil {
+		log.Printf("  Batch size: %d", *batchSize)
+	}
+	if *warmup > 0 {
+		log.Printf("  Warm-up: %s", *warmup)
+	}
+	if *runID == "" {
+		*runID = newRunID()
+	}
+	log.Printf("  Run ID: %s", *runID)
+
+	// Read the baseline first, so a wrong path fails before the run
+	var base *Results
+	if *baseline != "" {
+		if base, err = loadResults(*baseline); err != nil {
+			log.Fatalf("Failed to read baseline: %v", err)
+		}
+	}
 
 	// Connect to collector
 	conn, err := grpc.Dial(*endpoint, grpc.WithTransportCredentials(insecure.NewCredentials()))
@@ -52,161 +282,262 @@ func main() {
 	}
 	defer conn.Close()
 
-	client := coltracepb.NewTraceServiceClient(conn)
-
-	stats := &Stats{}
-	ctx, cancel := context.WithTimeout(context.Background(), *duration)
+	gen := newGenerator(conn, generatorOptions{
+		services:        *services,
+		operations:      *operations,
+		attrCardinality: *attrCardinality,
+		extraAttrs:      *extraAttrs,
+		metricNames:     *metricNames,
+		series:          *series,
+		runID:           *runID,
+	})
+
+	stats := newStats()
+	var ver *verifier
+	if *verifyURL != "" {
+		if ver, err = newVerifier(*verifyURL, *verifyDatabase, *verifyUser, *verifyPassword, *runID, *verifyInterval, mix, stats); err != nil {
+			log.Fatalf("Failed to set up verification: %v", err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *warmup+*duration)
 	defer cancel()
+	if *warmup > 0 {
+		time.AfterFunc(*warmup, func() {
+			log.Printf("Warm-up done, measuring for %s", *duration)
+			stats.start()
+		})
+	} else {
+		stats.start()
+	}
 
 	// Start stats reporter
-	go reportStats(ctx, stats)
+	go reportStats(ctx, stats, profile)
+	sampled := profile
+	if replayPaths != nil {
+		sampled = nil // a replay has no target rate
+	}
+	samples := newSampler(*metricsURL, *sampleInterval, sampled, stats)
+	go samples.run(ctx)
+	if ver != nil {
+		go ver.run(ctx)
+	}
 
-	// Start workers
-	var wg sync.WaitGroup
-	spansPerWorker := *ratePerSecond / *numWorkers
-	tickInterval := time.Second / time.Duration(spansPerWorker / *batchSize)
+	if replayPaths != nil {
+		r := &replayer{gen: gen, paths: replayPaths, speed: *replaySpeed, loop: *replayLoop, stats: stats}
+		if err := r.run(ctx, *numWorkers); err != nil {
+			log.Fatalf("Replay failed: %v", err)
+		}
+	} else {
+		// Start workers
+		var wg sync.WaitGroup
+		for i := 0; i < *numWorkers; i++ {
+			wg.Add(1)
+			go func(workerID int) {
+				defer wg.Done()
+				runWorker(ctx, gen, mix, workerID, profile, stats)
+			}(i)
+		}
+		wg.Wait()
+	}
 
-	for i := 0; i < *numWorkers; i++ {
-		wg.Add(1)
-		go func(workerID int) {
-			defer wg.Done()
-			runWorker(ctx, client, workerID, tickInterval, stats)
-		}(i)
+	// Print final stats
+	results := stats.results(time.Now())
+	results.Timeline = samples.timeline()
+	printFinalStats(results)
+	if profile.name != profileFlat || *metricsURL != "" {
+		printTimeline(results.Timeline)
 	}
 
-	wg.Wait()
+	failed := false
+	if ver != nil {
+		log.Printf("Waiting up to %s for the items to be stored", *verifyTimeout)
+		ver.drain(context.Background(), *verifyTimeout)
+		for i := range results.Signals {
+			results.Signals[i].Verification = ver.verification(results.Signals[i].Signal)
+		}
+		if printVerification(results, *maxLoss) {
+			failed = true
+		}
+	}
 
-	// Print final stats
-	printFinalStats(stats)
+	if *outputPath != "" {
+		if err := writeResults(*outputPath, results); err != nil {
+			log.Fatalf("Failed to write results: %v", err)
+		}
+		log.Printf("Results written to %s", *outputPath)
+	}
+	if *timelinePath != "" {
+		if err := writeTimeline(*timelinePath, results.Timeline); err != nil {
+			log.Fatalf("Failed to write timeline: %v", err)
+		}
+		log.Printf("Timeline written to %s", *timelinePath)
+	}
+	if *hdrPrefix != "" {
+		for _, signal := range allSignals {
+			if err := writeDistribution(*hdrPrefix+"-"+signal+".hgrm", &stats.signals[signal].latency); err != nil {
+				log.Fatalf("Failed to write latency distribution: %v", err)
+			}
+		}
+	}
+	if base != nil {
+		fmt.Printf("\n=== Comparison with %s ===\n", *baseline)
+		if compareResults(os.Stdout, base, results, *tolerance) {
+			fmt.Printf("\nPerformance regressed by more than %.0f%%\n", *tolerance)
+			failed = true
+		} else {
+			fmt.Printf("\nNo regression beyond %.0f%%\n", *tolerance)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
 }
 
-func runWorker(ctx context.Context, client coltracepb.TraceServiceClient, workerID int, tickInterval time.Duration, stats *Stats) {
-	ticker := time.NewTicker(tickInterval)
-	defer ticker.Stop()
+// printVerification prints what was stored of each signal and reports
+// whether more than maxLoss percent of any signal is missing
+func printVerification(results *Results, maxLoss float64) bool {
+	fmt.Printf("\n=== Verification (run %s) ===\n", *runID)
+	failed := false
+	for _, r := range results.Signals {
+		v := r.Verification
+		if v == nil {
+			continue
+		}
+		name := itemName(r.Signal)
+		fmt.Printf("\n%s:\n", r.Signal)
+		fmt.Printf("Delivered %s: %d\n", name, v.Delivered)
+		fmt.Printf("Stored: %d\n", v.Stored)
+		fmt.Printf("Lost: %d (%.3f%%)\n", v.Lost, v.LossPercent)
+		if v.Duplicates > 0 {
+			fmt.Printf("Duplicates: %d\n", v.Duplicates)
+		}
+		d := v.Delay
+		fmt.Printf("End-to-end delay (ms, estimated): p50 %.0f | p90 %.0f | p99 %.0f | max %.0f\n", d.P50, d.P90, d.P99, d.Max)
+		if v.LossPercent > maxLoss {
+			failed = true
+		}
+	}
+	if failed {
+		fmt.Printf("\nMore than %.3f%% of the items were not stored\n", maxLoss)
+	}
+	return failed
+}
+
+// writeDistribution writes a latency histogram with samples to path
+func writeDistribution(path string, h *histogram) error {
+	if h.summary() == (LatencySummary{}) {
+		return nil
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = h.writeDistribution(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// signalMix is the share of the items each signal gets
+type signalMix map[string]float64
+
+func (m signalMix) String() string {
+	var parts []string
+	for _, signal := range allSignals {
+		if m[signal] > 0 {
+			parts = append(parts, fmt.Sprintf("%s %.0f%%", signal, m[signal]*100))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// pick chooses the signal of the next batch
+func (m signalMix) pick(rng *rand.Rand) string {
+	x := rng.Float64()
+	for _, signal := range allSignals {
+		if x -= m[signal]; x < 0 {
+			return signal
+		}
+	}
+	for _, signal := range allSignals {
+		if m[signal] > 0 {
+			return signal
+		}
+	}
+	return signalTraces
+}
+
+// parseMix returns the mix of -signal, with the weights of -mix for mixed
+func parseMix(signal, weights string) (signalMix, error) {
+	switch signal {
+	case signalTraces, signalMetrics, signalLogs:
+		return signalMix{signal: 1}, nil
+	case signalMixed:
+	default:
+		return nil, fmt.Errorf("unknown signal %q: use traces, metrics, logs or mixed", signal)
+	}
+	mix := make(signalMix)
+	total := 0.0
+	for _, pair := range strings.Split(weights, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		w, err := strconv.ParseFloat(value, 64)
+		if !ok || err != nil || w < 0 {
+			return nil, fmt.Errorf("invalid -mix entry %q: use signal=weight", pair)
+		}
+		if name != signalTraces && name != signalMetrics && name != signalLogs {
+			return nil, fmt.Errorf("invalid -mix signal %q", name)
+		}
+		mix[name] += w
+		total += w
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("-mix gives no signal a weight")
+	}
+	for name := range mix {
+		mix[name] /= total
+	}
+	return mix, nil
+}
+
+// runWorker sends a batch every interval of the profile's current rate. A
+// worker that falls behind sends the next batch right away but does not
+// catch up on the ones it missed.
+func runWorker(ctx context.Context, gen *generator, mix signalMix, workerID int, profile *loadProfile, stats *Stats) {
+	wait := interval(profile.at(stats.elapsed()), *numWorkers, *batchSize)
+	next := time.Now().Add(wait)
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	rng := rand.New(rand.NewSource(time.Now().UnixNano() + int64(workerID)))
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case <-ticker.C:
-			sendBatch(ctx, client, workerID, stats)
-		}
-	}
-}
-
-func sendBatch(ctx context.Context, client coltracepb.TraceServiceClient, workerID int, stats *Stats) {
-	spans := generateSpans(*batchSize, workerID)
-
-	req := &coltracepb.ExportTraceServiceRequest{
-		ResourceSpans: []*tracepb.ResourceSpans{
-			{
-				Resource: &resourcepb.Resource{
-					Attributes: []*commonpb.KeyValue{
-						{
-							Key: "service.name",
-							Value: &commonpb.AnyValue{
-								Value: &commonpb.AnyValue_StringValue{
-									StringValue: fmt.Sprintf("benchmark-service-%d", workerID),
-								},
-							},
-						},
-						{
-							Key: "service.namespace",
-							Value: &commonpb.AnyValue{
-								Value: &commonpb.AnyValue_StringValue{
-									StringValue: "benchmark",
-								},
-							},
-						},
-					},
-				},
-				ScopeSpans: []*tracepb.ScopeSpans{
-					{
-						Scope: &commonpb.InstrumentationScope{
-							Name:    "benchmark",
-							Version: "1.0.0",
-						},
-						Spans: spans,
-					},
-				},
-			},
-		},
-	}
-
-	start := time.Now()
-	_, err := client.Export(ctx, req)
-	latency := time.Since(start)
-
-	stats.requests.Add(1)
-	stats.totalLatency.Add(uint64(latency.Milliseconds()))
-	stats.spansSent.Add(uint64(len(spans)))
+		case <-timer.C:
+			signal := mix.pick(rng)
+			start := time.Now()
+			err := gen.send(ctx, rng, signal, *batchSize, workerID)
+			if err != nil && ctx.Err() != nil {
+				return // cut off by the end of the test
+			}
+			stats.record(signal, *batchSize, time.Since(start), err)
 
-	if err != nil {
-		stats.spansFailed.Add(uint64(len(spans)))
-	} else {
-		stats.spansSucceeded.Add(uint64(len(spans)))
-	}
-}
-
-func generateSpans(count int, workerID int) []*tracepb.Span {
-	spans := make([]*tracepb.Span, count)
-	now := time.Now()
-
-	for i := 0; i < count; i++ {
-		traceID := randomBytes(16)
-		spanID := randomBytes(8)
-		parentSpanID := randomBytes(8)
-
-		duration := time.Duration(rand.Int63n(1000)) * time.Millisecond
-		startTime := now.Add(-duration)
-		endTime := now
-
-		spans[i] = &tracepb.Span{
-			TraceId:           traceID,
-			SpanId:            spanID,
-			ParentSpanId:      parentSpanID,
-			Name:              fmt.Sprintf("operation-%d", rand.Intn(100)),
-			Kind:              tracepb.Span_SPAN_KIND_INTERNAL,
-			StartTimeUnixNano: uint64(startTime.UnixNano()),
-			EndTimeUnixNano:   uint64(endTime.UnixNano()),
-			Status: &tracepb.Status{
-				Code: tracepb.Status_STATUS_CODE_OK,
-			},
-			Attributes: []*commonpb.KeyValue{
-				{
-					Key: "http.method",
-					Value: &commonpb.AnyValue{
-						Value: &commonpb.AnyValue_StringValue{
-							StringValue: "GET",
-						},
-					},
-				},
-				{
-					Key: "http.status_code",
-					Value: &commonpb.AnyValue{
-						Value: &commonpb.AnyValue_IntValue{
-							IntValue: 200,
-						},
-					},
-				},
-			},
-		}
-	}
-
-	return spans
-}
-
-func randomBytes(n int) []byte {
-	b := make([]byte, n)
-	rand.Read(b)
-	return b
-}
-
-func reportStats(ctx context.Context, stats *Stats) {
+			now := time.Now()
+			next = next.Add(interval(profile.at(stats.elapsed()), *numWorkers, *batchSize))
+			if next.Before(now) {
+				next = now
+			}
+			timer.Reset(next.Sub(now))
+		}
+	}
+}
+
+func reportStats(ctx context.Context, stats *Stats, profile *loadProfile) {
 	ticker := time.NewTicker(5 * time.Second)
 	defer ticker.Stop()
 
-	lastSpans := uint64(0)
+	last := make(map[string]uint64)
 	lastTime := time.Now()
 
 	for {
@@ -214,38 +545,62 @@ func reportStats(ctx context.Context, stats *Stats) {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			currentSpans := stats.spansSucceeded.Load()
 			currentTime := time.Now()
 			elapsed := currentTime.Sub(lastTime).Seconds()
-
-			rate := float64(currentSpans-lastSpans) / elapsed
-			avgLatency := float64(0)
-			if req := stats.requests.Load(); req > 0 {
-				avgLatency = float64(stats.totalLatency.Load()) / float64(req)
+			if profile.name != profileFlat {
+				log.Printf("Target rate: %.0f items/sec", profile.at(stats.elapsed()))
 			}
 
-			log.Printf("Rate: %.0f spans/sec | Succeeded: %d | Failed: %d | Avg Latency: %.2f ms",
-				rate,
-				stats.spansSucceeded.Load(),
-				stats.spansFailed.Load(),
-				avgLatency,
-			)
-
-			lastSpans = currentSpans
+			for _, signal := range allSignals {
+				st := stats.signals[signal]
+				req := st.requests.Load()
+				if req == 0 {
+					continue
+				}
+				current := st.succeeded.Load()
+				rate := float64(current-last[signal]) / elapsed
+
+				log.Printf("%-7s Rate: %.0f %s/sec | Succeeded: %d | Failed: %d | Latency p50: %.2f ms p99: %.2f ms",
+					signal,
+					rate,
+					itemName(signal),
+					current,
+					st.failed.Load(),
+					st.latency.percentile(50),
+					st.latency.percentile(99),
+				)
+				last[signal] = current
+			}
 			lastTime = currentTime
 		}
 	}
 }
 
-func printFinalStats(stats *Stats) {
+// itemName is what the items of a signal are called in the output
+func itemName(signal string) string {
+	switch signal {
+	case signalMetrics:
+		return "data points"
+	case signalLogs:
+		return "log records"
+	}
+	return "spans"
+}
+
+func printFinalStats(results *Results) {
 	fmt.Println("\n=== Final Statistics ===")
-	fmt.Printf("Total spans sent: %d\n", stats.spansSent.Load())
-	fmt.Printf("Spans succeeded: %d\n", stats.spansSucceeded.Load())
-	fmt.Printf("Spans failed: %d\n", stats.spansFailed.Load())
-	fmt.Printf("Success rate: %.2f%%\n", float64(stats.spansSucceeded.Load())/float64(stats.spansSent.Load())*100)
-
-	if req := stats.requests.Load(); req > 0 {
-		avgLatency := float64(stats.totalLatency.Load()) / float64(req)
-		fmt.Printf("Average request latency: %.2f ms\n", avgLatency)
+	fmt.Printf("Measured for %.1fs\n", results.Duration)
+	for _, r := range results.Signals {
+		name := itemName(r.Signal)
+		fmt.Printf("\n%s:\n", r.Signal)
+		fmt.Printf("Total %s sent: %d\n", name, r.Sent)
+		fmt.Printf("Succeeded: %d\n", r.Succeeded)
+		fmt.Printf("Failed: %d\n", r.Failed)
+		fmt.Printf("Success rate: %.2f%%\n", float64(r.Succeeded)/float64(r.Sent)*100)
+		fmt.Printf("Throughput: %.0f %s/sec\n", r.ItemsPerSecond, name)
+
+		l := r.Latency
+		fmt.Printf("Request latency (ms): min %.2f | mean %.2f | p50 %.2f | p90 %.2f | p99 %.2f | p99.9 %.2f | max %.2f\n",
+			l.Min, l.Mean, l.P50, l.P90, l.P99, l.P999, l.Max)
 	}
 }
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Load profiles, the shape of the target rate over the measured duration
+const (
+	profileFlat  = "flat"
+	profileStep  = "step"
+	profileSpike = "spike"
+	profileSine  = "sine"
+)
+
+// loadProfile is the target rate, in items per second, over time. rate
+// is -rate: the flat rate, the last step, the rate between spikes or the
+// middle of the sine wave.
+type loadProfile struct {
+	name     string
+	rate     float64
+	duration time.Duration
+
+	steps         int
+	spikeFactor   float64
+	spikeEvery    time.Duration
+	spikeLength   time.Duration
+	sinePeriod    time.Duration
+	sineAmplitude float64
+}
+
+func (p *loadProfile) validate() error {
+	switch p.name {
+	case profileFlat:
+	case profileStep:
+		if p.steps <= 0 {
+			return fmt.Errorf("-steps must be positive")
+		}
+	case profileSpike:
+		if p.spikeFactor <= 0 || p.spikeEvery <= 0 || p.spikeLength <= 0 {
+			return fmt.Errorf("-spike-factor, -spike-every and -spike-length must be positive")
+		}
+		if p.spikeLength >= p.spikeEvery {
+			return fmt.Errorf("-spike-length must be shorter than -spike-every")
+		}
+	case profileSine:
+		if p.sinePeriod <= 0 {
+			return fmt.Errorf("-sine-period must be positive")
+		}
+		if p.sineAmplitude < 0 || p.sineAmplitude >= 1 {
+			return fmt.Errorf("-sine-amplitude must be at least 0 and below 1")
+		}
+	default:
+		return fmt.Errorf("unknown profile %q: use flat, step, spike or sine", p.name)
+	}
+	return nil
+}
+
+// at returns the target rate t into the measurement. The warm-up runs at
+// the rate of t = 0.
+func (p *loadProfile) at(t time.Duration) float64 {
+	switch p.name {
+	case profileStep:
+		step := p.steps
+		if p.duration > 0 {
+			step = int(float64(t)/float64(p.duration)*float64(p.steps)) + 1
+		}
+		if step > p.steps {
+			step = p.steps
+		}
+		return p.rate * float64(step) / float64(p.steps)
+	case profileSpike:
+		if t >= p.spikeEvery && t%p.spikeEvery < p.spikeLength {
+			return p.rate * p.spikeFactor
+		}
+	case profileSine:
+		return p.rate * (1 + p.sineAmplitude*math.Sin(2*math.Pi*t.Seconds()/p.sinePeriod.Seconds()))
+	}
+	return p.rate
+}
+
+// mean returns the average target rate between from and to
+func (p *loadProfile) mean(from, to time.Duration) float64 {
+	const points = 20
+	if to <= from {
+		return p.at(to)
+	}
+	sum := 0.0
+	for i := 0; i < points; i++ {
+		sum += p.at(from + (to-from)*time.Duration(2*i+1)/(2*points))
+	}
+	return sum / points
+}
+
+func (p *loadProfile) String() string {
+	switch p.name {
+	case profileStep:
+		return fmt.Sprintf("step, %d steps of %s up to %.0f items/sec", p.steps, p.duration/time.Duration(p.steps), p.rate)
+	case profileSpike:
+		return fmt.Sprintf("spike, %.0f items/sec for %s every %s", p.rate*p.spikeFactor, p.spikeLength, p.spikeEvery)
+	case profileSine:
+		return fmt.Sprintf("sine, %.0f to %.0f items/sec over %s", p.rate*(1-p.sineAmplitude), p.rate*(1+p.sineAmplitude), p.sinePeriod)
+	}
+	return profileFlat
+}
+
+// interval returns how long each of workers waits between batches of
+// batch items to send rate items per second between them
+func interval(rate float64, workers, batch int) time.Duration {
+	return time.Duration(float64(time.Second) * float64(workers*batch) / rate)
+}
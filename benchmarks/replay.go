@@ -0,0 +1,371 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+)
+
+// captureMagic starts a capture file written by the collector: a gzip
+// stream of the magic, the start of the capture in Unix nanoseconds as 8
+// big-endian bytes, and one record per export request: the signal (1
+// traces, 2 metrics, 3 logs), the uvarint nanoseconds since the start, the
+// uvarint length of the request and the request as binary protobuf
+const captureMagic = "OTLPCAP1"
+
+var captureSignals = map[byte]string{1: signalTraces, 2: signalMetrics, 3: signalLogs}
+
+// maxCaptureRecord bounds the size of one request read from a capture file
+const maxCaptureRecord = 64 << 20
+
+// captureReader reads the records of a capture file
+type captureReader struct {
+	f       *os.File
+	gz      *gzip.Reader
+	r       *bufio.Reader
+	started time.Time
+}
+
+func openCapture(path string) (*captureReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	c := &captureReader{f: f, gz: gz, r: bufio.NewReaderSize(gz, 1<<20)}
+	header := make([]byte, len(captureMagic)+8)
+	if _, err := io.ReadFull(c.r, header); err != nil || string(header[:len(captureMagic)]) != captureMagic {
+		c.Close()
+		return nil, fmt.Errorf("%s: not a capture file", path)
+	}
+	c.started = time.Unix(0, int64(binary.BigEndian.Uint64(header[len(captureMagic):])))
+	return c, nil
+}
+
+// next returns the next request, its signal and its time since the start
+// of the capture, or io.EOF. A file cut off by a crash ends at its last
+// complete record.
+func (c *captureReader) next() (string, time.Duration, proto.Message, error) {
+	kind, err := c.r.ReadByte()
+	if err != nil {
+		return "", 0, nil, endOfCapture(err)
+	}
+	at, err := binary.ReadUvarint(c.r)
+	if err != nil {
+		return "", 0, nil, endOfCapture(err)
+	}
+	n, err := binary.ReadUvarint(c.r)
+	if err != nil {
+		return "", 0, nil, endOfCapture(err)
+	}
+	if n > maxCaptureRecord {
+		return "", 0, nil, fmt.Errorf("record of %d bytes is too large", n)
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return "", 0, nil, endOfCapture(err)
+	}
+	var req proto.Message
+	signal := captureSignals[kind]
+	switch signal {
+	case signalTraces:
+		req = &coltracepb.ExportTraceServiceRequest{}
+	case signalMetrics:
+		req = &colmetricspb.ExportMetricsServiceRequest{}
+	case signalLogs:
+		req = &collogspb.ExportLogsServiceRequest{}
+	default:
+		return "", 0, nil, fmt.Errorf("unknown signal %d", kind)
+	}
+	if err := proto.Unmarshal(payload, req); err != nil {
+		return "", 0, nil, err
+	}
+	return signal, time.Duration(at), req, nil
+}
+
+func endOfCapture(err error) error {
+	if err == io.ErrUnexpectedEOF {
+		return io.EOF
+	}
+	return err
+}
+
+func (c *captureReader) Close() error {
+	c.gz.Close()
+	return c.f.Close()
+}
+
+// replayJob is one request for the replay workers to send
+type replayJob struct {
+	signal string
+	req    proto.Message
+	items  int
+}
+
+// export sends a recorded export request
+func (g *generator) export(ctx context.Context, signal string, req proto.Message) error {
+	var err error
+	switch req := req.(type) {
+	case *colmetricspb.ExportMetricsServiceRequest:
+		_, err = g.metrics.Export(ctx, req)
+	case *collogspb.ExportLogsServiceRequest:
+		_, err = g.logs.Export(ctx, req)
+	case *coltracepb.ExportTraceServiceRequest:
+		_, err = g.traces.Export(ctx, req)
+	default:
+		err = fmt.Errorf("cannot send %s request %T", signal, req)
+	}
+	return err
+}
+
+// replayer sends the requests of capture files at their recorded pace,
+// scaled by speed, or as fast as the workers can with speed 0
+type replayer struct {
+	gen   *generator
+	paths []string
+	speed float64
+	loop  bool
+	stats *Stats
+}
+
+// run replays the files side by side, as captured by different collectors
+// at the same time, until they end or, with loop, until ctx is done
+func (p *replayer) run(ctx context.Context, workers int) error {
+	jobs := make(chan replayJob, workers*2)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				start := time.Now()
+				err := p.gen.export(ctx, job.signal, job.req)
+				if err != nil && ctx.Err() != nil {
+					continue // cut off by the end of the test
+				}
+				p.stats.record(job.signal, job.items, time.Since(start), err)
+			}
+		}()
+	}
+
+	var err error
+	for pass := 1; err == nil && ctx.Err() == nil; pass++ {
+		err = p.pass(ctx, jobs)
+		if !p.loop {
+			break
+		}
+		if err == nil && ctx.Err() == nil {
+			log.Printf("Replay pass %d done", pass)
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+// pass replays every file once. Each pass gives the traces new IDs, so
+// looping does not send the same spans again.
+func (p *replayer) pass(ctx context.Context, jobs chan<- replayJob) error {
+	var ids idMask
+	rand.Read(ids[:])
+	start := time.Now()
+	errs := make(chan error, len(p.paths))
+	for _, path := range p.paths {
+		go func(path string) {
+			err := p.play(ctx, path, start, ids, jobs)
+			if err != nil {
+				err = fmt.Errorf("%s: %w", path, err)
+			}
+			errs <- err
+		}(path)
+	}
+	var first error
+	for range p.paths {
+		if err := <-errs; err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// play sends the requests of one file, each when its time in the capture,
+// divided by the speed, has passed since start. Timestamps are moved so a
+// request's items are as old when they are sent as when they were received.
+func (p *replayer) play(ctx context.Context, path string, start time.Time, ids idMask, jobs chan<- replayJob) error {
+	c, err := openCapture(path)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	warned := false
+	for {
+		signal, at, req, err := c.next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if p.speed > 0 {
+			sendAt := start.Add(time.Duration(float64(at) / p.speed))
+			if wait := time.Until(sendAt); wait > 0 {
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-time.After(wait):
+				}
+			} else if wait < -time.Second && !warned {
+				log.Printf("Replay of %s is falling behind the capture; add -workers or lower -replay-speed", path)
+				warned = true
+			}
+		}
+		shift := time.Since(c.started.Add(at))
+		items := rewrite(req, int64(shift), ids)
+		select {
+		case <-ctx.Done():
+			return nil
+		case jobs <- replayJob{signal: signal, req: req, items: items}:
+		}
+	}
+}
+
+// idMask is XORed into the trace and span IDs of a replay pass, which
+// keeps the parent and link references of a trace intact
+type idMask [24]byte
+
+func (m *idMask) traceID(id []byte) {
+	if len(id) == 16 {
+		for i := range id {
+			id[i] ^= m[i]
+		}
+	}
+}
+
+func (m *idMask) spanID(id []byte) {
+	if len(id) == 8 {
+		for i := range id {
+			id[i] ^= m[16+i]
+		}
+	}
+}
+
+// shiftTime moves a timestamp by shift nanoseconds, leaving unset ones unset
+func shiftTime(t uint64, shift int64) uint64 {
+	if t == 0 {
+		return 0
+	}
+	return uint64(int64(t) + shift)
+}
+
+// rewrite moves the timestamps of a request by shift nanoseconds, masks its
+// IDs and returns its items: spans, data points or log records
+func rewrite(req proto.Message, shift int64, ids idMask) int {
+	items := 0
+	switch req := req.(type) {
+	case *coltracepb.ExportTraceServiceRequest:
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				for _, span := range ss.Spans {
+					items++
+					ids.traceID(span.TraceId)
+					ids.spanID(span.SpanId)
+					ids.spanID(span.ParentSpanId)
+					span.StartTimeUnixNano = shiftTime(span.StartTimeUnixNano, shift)
+					span.EndTimeUnixNano = shiftTime(span.EndTimeUnixNano, shift)
+					for _, e := range span.Events {
+						e.TimeUnixNano = shiftTime(e.TimeUnixNano, shift)
+					}
+					for _, l := range span.Links {
+						ids.traceID(l.TraceId)
+						ids.spanID(l.SpanId)
+					}
+				}
+			}
+		}
+	case *colmetricspb.ExportMetricsServiceRequest:
+		for _, rm := range req.ResourceMetrics {
+			for _, sm := range rm.ScopeMetrics {
+				for _, m := range sm.Metrics {
+					items += rewriteMetric(m, shift, ids)
+				}
+			}
+		}
+	case *collogspb.ExportLogsServiceRequest:
+		for _, rl := range req.ResourceLogs {
+			for _, sl := range rl.ScopeLogs {
+				for _, l := range sl.LogRecords {
+					items++
+					ids.traceID(l.TraceId)
+					ids.spanID(l.SpanId)
+					l.TimeUnixNano = shiftTime(l.TimeUnixNano, shift)
+					l.ObservedTimeUnixNano = shiftTime(l.ObservedTimeUnixNano, shift)
+				}
+			}
+		}
+	}
+	return items
+}
+
+func rewriteMetric(m *metricspb.Metric, shift int64, ids idMask) int {
+	exemplars := func(es []*metricspb.Exemplar) {
+		for _, e := range es {
+			e.TimeUnixNano = shiftTime(e.TimeUnixNano, shift)
+			ids.traceID(e.TraceId)
+			ids.spanID(e.SpanId)
+		}
+	}
+	switch d := m.Data.(type) {
+	case *metricspb.Metric_Gauge:
+		for _, p := range d.Gauge.GetDataPoints() {
+			p.StartTimeUnixNano, p.TimeUnixNano = shiftTime(p.StartTimeUnixNano, shift), shiftTime(p.TimeUnixNano, shift)
+			exemplars(p.Exemplars)
+		}
+		return len(d.Gauge.GetDataPoints())
+	case *metricspb.Metric_Sum:
+		for _, p := range d.Sum.GetDataPoints() {
+			p.StartTimeUnixNano, p.TimeUnixNano = shiftTime(p.StartTimeUnixNano, shift), shiftTime(p.TimeUnixNano, shift)
+			exemplars(p.Exemplars)
+		}
+		return len(d.Sum.GetDataPoints())
+	case *metricspb.Metric_Histogram:
+		for _, p := range d.Histogram.GetDataPoints() {
+			p.StartTimeUnixNano, p.TimeUnixNano = shiftTime(p.StartTimeUnixNano, shift), shiftTime(p.TimeUnixNano, shift)
+			exemplars(p.Exemplars)
+		}
+		return len(d.Histogram.GetDataPoints())
+	case *metricspb.Metric_ExponentialHistogram:
+		for _, p := range d.ExponentialHistogram.GetDataPoints() {
+			p.StartTimeUnixNano, p.TimeUnixNano = shiftTime(p.StartTimeUnixNano, shift), shiftTime(p.TimeUnixNano, shift)
+			exemplars(p.Exemplars)
+		}
+		return len(d.ExponentialHistogram.GetDataPoints())
+	case *metricspb.Metric_Summary:
+		for _, p := range d.Summary.GetDataPoints() {
+			p.StartTimeUnixNano, p.TimeUnixNano = shiftTime(p.StartTimeUnixNano, shift), shiftTime(p.TimeUnixNano, shift)
+		}
+		return len(d.Summary.GetDataPoints())
+	}
+	return 0
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// LatencySummary are the request latency statistics of one signal, in ms
+type LatencySummary struct {
+	Min  float64 `json:"min"`
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	P99  float64 `json:"p99"`
+	P999 float64 `json:"p999"`
+	Max  float64 `json:"max"`
+}
+
+// Result is what one signal achieved after the warm-up
+type Result struct {
+	Signal         string         `json:"signal"`
+	Requests       uint64         `json:"requests"`
+	Sent           uint64         `json:"sent"`
+	Succeeded      uint64         `json:"succeeded"`
+	Failed         uint64         `json:"failed"`
+	ItemsPerSecond float64        `json:"items_per_second"`
+	Latency        LatencySummary `json:"latency_ms"`
+	// Verification is set with -verify
+	Verification *Verification `json:"verification,omitempty"`
+}
+
+// Results is the outcome of a run, as written by -output and read by
+// -baseline
+type Results struct {
+	StartedAt time.Time `json:"started_at"`
+	Duration  float64   `json:"duration_seconds"` // measured, without the warm-up
+	Endpoint  string    `json:"endpoint"`
+	Signal    string    `json:"signal"`
+	Rate      int       `json:"rate"`
+	Workers   int       `json:"workers"`
+	Batch     int       `json:"batch"`
+	Profile   string    `json:"profile"`
+	Signals   []Result  `json:"signals"`
+	Timeline  []Sample  `json:"timeline,omitempty"`
+}
+
+var csvHeader = []string{"signal", "requests", "sent", "succeeded", "failed", "items_per_second",
+	"latency_min_ms", "latency_mean_ms", "latency_p50_ms", "latency_p90_ms", "latency_p99_ms", "latency_p999_ms", "latency_max_ms"}
+
+// writeResults writes the results to path, as CSV with one row per signal
+// when it ends in .csv and as JSON otherwise
+func writeResults(path string, results *Results) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		err = writeCSV(f, results)
+	} else {
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(results)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func writeCSV(w io.Writer, results *Results) error {
+	cw := csv.NewWriter(w)
+	cw.Write(csvHeader)
+	for _, r := range results.Signals {
+		l := r.Latency
+		row := []string{r.Signal, u64(r.Requests), u64(r.Sent), u64(r.Succeeded), u64(r.Failed), f64(r.ItemsPerSecond)}
+		for _, v := range []float64{l.Min, l.Mean, l.P50, l.P90, l.P99, l.P999, l.Max} {
+			row = append(row, f64(v))
+		}
+		cw.Write(row)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func u64(v uint64) string  { return strconv.FormatUint(v, 10) }
+func f64(v float64) string { return strconv.FormatFloat(v, 'f', 3, 64) }
+
+// loadResults reads results written by writeResults
+func loadResults(path string) (*Results, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(filepath.Ext(path), ".csv") {
+		var results Results
+		if err := json.Unmarshal(data, &results); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return &results, nil
+	}
+
+	rows, err := csv.NewReader(strings.NewReader(string(data))).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	results := &Results{}
+	for i, row := range rows {
+		if i == 0 && len(row) > 0 && row[0] == csvHeader[0] {
+			continue
+		}
+		if len(row) != len(csvHeader) {
+			return nil, fmt.Errorf("%s: row %d has %d columns, want %d", path, i+1, len(row), len(csvHeader))
+		}
+		var nums [12]float64
+		for j := range nums {
+			if nums[j], err = strconv.ParseFloat(row[j+1], 64); err != nil {
+				return nil, fmt.Errorf("%s: row %d: %s: %w", path, i+1, csvHeader[j+1], err)
+			}
+		}
+		results.Signals = append(results.Signals, Result{
+			Signal:         row[0],
+			Requests:       uint64(nums[0]),
+			Sent:           uint64(nums[1]),
+			Succeeded:      uint64(nums[2]),
+			Failed:         uint64(nums[3]),
+			ItemsPerSecond: nums[4],
+			Latency:        LatencySummary{Min: nums[5], Mean: nums[6], P50: nums[7], P90: nums[8], P99: nums[9], P999: nums[10], Max: nums[11]},
+		})
+	}
+	return results, nil
+}
+
+// compareResults prints, for every signal in both runs, the throughput and
+// latency percentiles of the baseline and the current run, and reports
+// whether any got worse by more than tolerance percent: lower throughput,
+// or a higher p50, p90 or p99. The p99.9 and max are shown but too noisy
+// to fail a run.
+func compareResults(w io.Writer, baseline, current *Results, tolerance float64) bool {
+	base := make(map[string]Result)
+	for _, r := range baseline.Signals {
+		base[r.Signal] = r
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "signal\tmetric\tbaseline\tcurrent\tchange\t\t")
+	regressed := false
+	for _, cur := range current.Signals {
+		old, ok := base[cur.Signal]
+		if !ok {
+			fmt.Fprintf(tw, "%s\t(not in baseline)\t\t\t\t\t\n", cur.Signal)
+			continue
+		}
+		rows := []struct {
+			name         string
+			old, cur     float64
+			higherBetter bool
+			gated        bool
+		}{
+			{itemName(cur.Signal) + "/sec", old.ItemsPerSecond, cur.ItemsPerSecond, true, true},
+			{"p50 ms", old.Latency.P50, cur.Latency.P50, false, true},
+			{"p90 ms", old.Latency.P90, cur.Latency.P90, false, true},
+			{"p99 ms", old.Latency.P99, cur.Latency.P99, false, true},
+			{"p99.9 ms", old.Latency.P999, cur.Latency.P999, false, false},
+			{"max ms", old.Latency.Max, cur.Latency.Max, false, false},
+		}
+		for _, row := range rows {
+			change := 0.0
+			if row.old != 0 {
+				change = (row.cur - row.old) / row.old * 100
+			}
+			worse := change < -tolerance
+			if !row.higherBetter {
+				worse = change > tolerance
+			}
+			verdict := ""
+			if worse && row.gated {
+				verdict = "REGRESSION"
+				regressed = true
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%.2f\t%.2f\t%+.1f%%\t%s\t\n", cur.Signal, row.name, row.old, row.cur, change, verdict)
+		}
+	}
+	tw.Flush()
+	return regressed
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Sample is the load and, with -collector-metrics, the resources of the
+// collector over one -sample-interval
+type Sample struct {
+	Elapsed         float64          `json:"elapsed_seconds"` // since the warm-up ended
+	TargetRate      float64          `json:"target_rate"`
+	ItemsPerSecond  float64          `json:"items_per_second"`
+	FailedPerSecond float64          `json:"failed_per_second"`
+	Collector       *CollectorSample `json:"collector,omitempty"`
+}
+
+// CollectorSample are the resources the collector reports on its metrics
+// endpoint
+type CollectorSample struct {
+	CPUCores        float64 `json:"cpu_cores"` // CPU seconds per second since the last sample
+	ResidentMB      float64 `json:"resident_mb"`
+	HeapInUseMB     float64 `json:"heap_inuse_mb"`
+	Goroutines      float64 `json:"goroutines"`
+	OpenFDs         float64 `json:"open_fds"`
+	ExportsInFlight float64 `json:"exports_in_flight"`
+}
+
+// collectorMetrics are the metrics a CollectorSample is made of
+var collectorMetrics = map[string]bool{
+	"process_cpu_seconds_total":     true,
+	"process_resident_memory_bytes": true,
+	"go_memstats_heap_inuse_bytes":  true,
+	"go_goroutines":                 true,
+	"process_open_fds":              true,
+	"otel_exports_in_flight":        true,
+}
+
+// sampler records a Sample every interval while the load is measured. A
+// replay has no profile and no target rate.
+type sampler struct {
+	url      string // collector metrics endpoint, empty for none
+	interval time.Duration
+	profile  *loadProfile
+	stats    *Stats
+	client   *http.Client
+
+	lastAt        time.Time
+	lastSucceeded uint64
+	lastFailed    uint64
+	lastCPU       float64
+	lastCPUAt     time.Time
+
+	mu      sync.Mutex
+	samples []Sample
+}
+
+func newSampler(url string, interval time.Duration, profile *loadProfile, stats *Stats) *sampler {
+	return &sampler{
+		url:      url,
+		interval: interval,
+		profile:  profile,
+		stats:    stats,
+		client:   &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// defaultMetricsURL is the metrics endpoint of the collector at the OTLP
+// endpoint, on the default monitoring port
+func defaultMetricsURL(endpoint string) string {
+	host, _, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		host = endpoint
+	}
+	return "http://" + net.JoinHostPort(host, "9090") + "/metrics"
+}
+
+// run samples every interval until ctx is done
+func (s *sampler) run(ctx context.Context) {
+	if s.url != "" {
+		// A first scrape, so the first sample has a CPU rate
+		s.scrapeCollector(ctx, time.Now())
+	}
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.sample(ctx, now)
+		}
+	}
+}
+
+func (s *sampler) sample(ctx context.Context, now time.Time) {
+	if !s.stats.measuring.Load() {
+		return
+	}
+	if s.lastAt.IsZero() {
+		s.lastAt = s.stats.startedAt
+	}
+	var succeeded, failed uint64
+	for _, st := range s.stats.signals {
+		succeeded += st.succeeded.Load()
+		failed += st.failed.Load()
+	}
+	// A tick right after the warm-up would sample next to nothing
+	if now.Sub(s.lastAt) < s.interval/2 {
+		return
+	}
+	seconds := now.Sub(s.lastAt).Seconds()
+	elapsed := now.Sub(s.stats.startedAt)
+	sample := Sample{
+		Elapsed:         elapsed.Seconds(),
+		ItemsPerSecond:  float64(succeeded-s.lastSucceeded) / seconds,
+		FailedPerSecond: float64(failed-s.lastFailed) / seconds,
+	}
+	if s.profile != nil {
+		sample.TargetRate = s.profile.mean(s.lastAt.Sub(s.stats.startedAt), elapsed)
+	}
+	s.lastAt, s.lastSucceeded, s.lastFailed = now, succeeded, failed
+	if s.url != "" {
+		sample.Collector = s.scrapeCollector(ctx, now)
+	}
+	s.mu.Lock()
+	s.samples = append(s.samples, sample)
+	s.mu.Unlock()
+}
+
+// scrapeCollector reads the collector's resources, or returns nil when its
+// metrics endpoint does not answer
+func (s *sampler) scrapeCollector(ctx context.Context, now time.Time) *CollectorSample {
+	values, err := s.scrape(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("Failed to sample collector metrics: %v", err)
+		}
+		return nil
+	}
+	c := &CollectorSample{
+		ResidentMB:      values["process_resident_memory_bytes"] / (1 << 20),
+		HeapInUseMB:     values["go_memstats_heap_inuse_bytes"] / (1 << 20),
+		Goroutines:      values["go_goroutines"],
+		OpenFDs:         values["process_open_fds"],
+		ExportsInFlight: values["otel_exports_in_flight"],
+	}
+	cpu := values["process_cpu_seconds_total"]
+	if !s.lastCPUAt.IsZero() && cpu >= s.lastCPU {
+		if seconds := now.Sub(s.lastCPUAt).Seconds(); seconds > 0 {
+			c.CPUCores = (cpu - s.lastCPU) / seconds
+		}
+	}
+	s.lastCPU, s.lastCPUAt = cpu, now
+	return c
+}
+
+func (s *sampler) scrape(ctx context.Context) (map[string]float64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/plain")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", s.url, resp.Status)
+	}
+	return parseMetrics(resp.Body, collectorMetrics)
+}
+
+// parseMetrics returns the named metrics of the Prometheus text format,
+// summed over their series
+func parseMetrics(r io.Reader, names map[string]bool) (map[string]float64, error) {
+	values := make(map[string]float64)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		end := strings.IndexAny(line, "{ ")
+		if end < 0 || !names[line[:end]] {
+			continue
+		}
+		rest := line[end:]
+		if rest[0] == '{' {
+			brace := strings.LastIndexByte(rest, '}')
+			if brace < 0 {
+				continue
+			}
+			rest = rest[brace+1:]
+		}
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			continue
+		}
+		v, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			continue
+		}
+		values[line[:end]] += v
+	}
+	return values, scanner.Err()
+}
+
+// timeline returns the samples so far
+func (s *sampler) timeline() []Sample {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Sample(nil), s.samples...)
+}
+
+var timelineHeader = []string{"elapsed_seconds", "target_rate", "items_per_second", "failed_per_second",
+	"cpu_cores", "resident_mb", "heap_inuse_mb", "goroutines", "open_fds", "exports_in_flight"}
+
+// writeTimeline writes the samples to path as CSV, leaving the collector
+// columns empty when it was not sampled
+func writeTimeline(path string, samples []Sample) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	cw := csv.NewWriter(f)
+	cw.Write(timelineHeader)
+	for _, s := range samples {
+		row := []string{f64(s.Elapsed), f64(s.TargetRate), f64(s.ItemsPerSecond), f64(s.FailedPerSecond)}
+		if c := s.Collector; c != nil {
+			for _, v := range []float64{c.CPUCores, c.ResidentMB, c.HeapInUseMB, c.Goroutines, c.OpenFDs, c.ExportsInFlight} {
+				row = append(row, f64(v))
+			}
+		} else {
+			row = append(row, "", "", "", "", "", "")
+		}
+		cw.Write(row)
+	}
+	cw.Flush()
+	err = cw.Error()
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// keptUp is the share of the target rate a sample must reach to count as
+// keeping up with the profile
+const keptUp = 0.95
+
+// printTimeline summarizes the samples: how often the load test kept up
+// with the target rate, and the range and trend of each collector resource.
+// A resource that keeps growing over a soak test, rather than levelling
+// off, points at a leak.
+func printTimeline(samples []Sample) {
+	if len(samples) == 0 {
+		return
+	}
+	fmt.Println("\n=== Timeline ===")
+	behind, targeted := 0, 0
+	for _, s := range samples {
+		if s.TargetRate > 0 {
+			targeted++
+		}
+		if s.ItemsPerSecond < s.TargetRate*keptUp {
+			behind++
+		}
+	}
+	if targeted > 0 {
+		fmt.Printf("Samples: %d, %d of them below %.0f%% of the target rate\n", len(samples), behind, keptUp*100)
+	} else {
+		fmt.Printf("Samples: %d\n", len(samples))
+	}
+
+	resources := []struct {
+		name  string
+		value func(*CollectorSample) float64
+	}{
+		{"cpu cores", func(c *CollectorSample) float64 { return c.CPUCores }},
+		{"resident MB", func(c *CollectorSample) float64 { return c.ResidentMB }},
+		{"heap in use MB", func(c *CollectorSample) float64 { return c.HeapInUseMB }},
+		{"goroutines", func(c *CollectorSample) float64 { return c.Goroutines }},
+		{"open fds", func(c *CollectorSample) float64 { return c.OpenFDs }},
+		{"exports in flight", func(c *CollectorSample) float64 { return c.ExportsInFlight }},
+	}
+	var times []float64
+	var collected []*CollectorSample
+	for _, s := range samples {
+		if s.Collector != nil {
+			times = append(times, s.Elapsed)
+			collected = append(collected, s.Collector)
+		}
+	}
+	if len(collected) == 0 {
+		return
+	}
+	fmt.Println("\nCollector:")
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "resource\tmin\tmean\tmax\tfirst\tlast\tper hour\t")
+	for _, r := range resources {
+		values := make([]float64, len(collected))
+		lo, hi, sum := math.Inf(1), math.Inf(-1), 0.0
+		for i, c := range collected {
+			v := r.value(c)
+			values[i] = v
+			lo, hi, sum = math.Min(lo, v), math.Max(hi, v), sum+v
+		}
+		fmt.Fprintf(tw, "%s\t%.2f\t%.2f\t%.2f\t%.2f\t%.2f\t%+.2f\t\n", r.name, lo, sum/float64(len(values)), hi,
+			values[0], values[len(values)-1], slope(times, values)*3600)
+	}
+	tw.Flush()
+}
+
+// slope returns the least-squares slope of ys over xs
+func slope(xs, ys []float64) float64 {
+	n := float64(len(xs))
+	if n < 2 {
+		return 0
+	}
+	var sx, sy, sxx, sxy float64
+	for i := range xs {
+		sx += xs[i]
+		sy += ys[i]
+		sxx += xs[i] * xs[i]
+		sxy += xs[i] * ys[i]
+	}
+	d := n*sxx - sx*sx
+	if d == 0 {
+		return 0
+	}
+	return (n*sxy - sx*sy) / d
+}
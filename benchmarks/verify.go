@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// runIDAttribute carries the run ID in the resource of every request and
+// the attributes of every span and log record the load test sends. The
+// collector stores item attributes but not OTLP resource attributes, so
+// the verifier filters on the item attribute.
+const runIDAttribute = "benchmark.run_id"
+
+// verifyChunks bounds how many send times the items stored between two
+// polls are spread over when estimating their delay
+const verifyChunks = 50
+
+var validRunID = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// verifyTables are the ClickHouse tables holding each signal's items, one
+// row per span or log record. Metrics cannot be verified: the collector
+// forwards OTLP metrics to its exporters without storing them.
+var verifyTables = map[string]string{
+	signalTraces: "otel_traces",
+	signalLogs:   "otel_logs",
+}
+
+// newRunID returns a random run ID
+func newRunID() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return time.Now().UTC().Format("20060102-150405") + "-" + hex.EncodeToString(b)
+}
+
+// Verification is how much of what a signal sent was stored, and how long
+// it took to become queryable
+type Verification struct {
+	Delivered   uint64  `json:"delivered"` // items of successful requests, warm-up included
+	Stored      uint64  `json:"stored"`
+	Lost        uint64  `json:"lost"`
+	LossPercent float64 `json:"loss_percent"`
+	// Duplicates are items stored more than once, e.g. after a retry
+	Duplicates uint64 `json:"duplicates"`
+	// Delay is the estimated time from the acknowledgement of an item to
+	// it being stored, in ms
+	Delay LatencySummary `json:"delay_ms"`
+}
+
+// curvePoint is how many items were delivered or stored by a time
+type curvePoint struct {
+	at    time.Time
+	count uint64
+}
+
+// verifySignal follows the delivered and stored items of one signal
+type verifySignal struct {
+	delivered []curvePoint // delivered count at each poll
+	stored    uint64
+	delay     histogram
+}
+
+// verifier counts the items of the run in ClickHouse while the load test
+// runs and after it, to find lost items and the end-to-end delay
+type verifier struct {
+	url      string
+	database string
+	user     string
+	password string
+	runID    string
+	since    time.Time // bounds the partitions searched
+	interval time.Duration
+	client   *http.Client
+	stats    *Stats
+
+	mu      sync.Mutex
+	signals map[string]*verifySignal
+}
+
+func newVerifier(rawURL, database, user, password, runID string, interval time.Duration, mix signalMix, stats *Stats) (*verifier, error) {
+	if !validRunID.MatchString(runID) {
+		return nil, fmt.Errorf("run ID %q must be 1 to 64 letters, digits, '.', '_' or '-'", runID)
+	}
+	if _, err := url.ParseRequestURI(rawURL); err != nil {
+		return nil, fmt.Errorf("invalid -verify URL: %w", err)
+	}
+	v := &verifier{
+		url:      rawURL,
+		database: database,
+		user:     user,
+		password: password,
+		runID:    runID,
+		since:    time.Now().Add(-time.Hour),
+		interval: interval,
+		client:   &http.Client{Timeout: 30 * time.Second},
+		stats:    stats,
+		signals:  make(map[string]*verifySignal),
+	}
+	start := curvePoint{at: time.Now()}
+	for _, signal := range allSignals {
+		if _, stored := verifyTables[signal]; stored && mix[signal] > 0 {
+			v.signals[signal] = &verifySignal{delivered: []curvePoint{start}}
+		}
+	}
+	if len(v.signals) == 0 {
+		return nil, fmt.Errorf("-verify checks traces and logs; the collector does not store metrics")
+	}
+	if mix[signalMetrics] > 0 {
+		log.Printf("Metrics are not stored by the collector and are not verified")
+	}
+	return v, nil
+}
+
+// run polls ClickHouse every interval until ctx is done
+func (v *verifier) run(ctx context.Context) {
+	ticker := time.NewTicker(v.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			v.poll(ctx)
+		}
+	}
+}
+
+// drain polls until every delivered item is stored or timeout passes
+func (v *verifier) drain(ctx context.Context, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	for {
+		if v.poll(ctx) {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(v.interval):
+		}
+	}
+}
+
+// poll counts the stored items of each signal and reports whether all
+// delivered items are stored
+func (v *verifier) poll(ctx context.Context) bool {
+	complete := true
+	for signal, vs := range v.signals {
+		// Read the delivered count first, so everything it counts was
+		// acknowledged before the stored count is read
+		now := time.Now()
+		delivered := v.stats.signals[signal].delivered.Load()
+		stored, err := v.count(ctx, signal)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Verify: failed to count %s: %v", signal, err)
+			}
+			complete = false
+			continue
+		}
+		v.mu.Lock()
+		vs.delivered = append(vs.delivered, curvePoint{at: now, count: delivered})
+		vs.observe(now, stored)
+		if stored < delivered {
+			complete = false
+		}
+		v.mu.Unlock()
+	}
+	return complete
+}
+
+// observe records the delay of the items stored since the last poll. Items
+// are assumed to be stored in the order they were delivered, so the n-th
+// stored item is the n-th delivered one, and its delivery time is
+// interpolated between the polls around it. The delay is an estimate with
+// the resolution of the poll interval.
+func (vs *verifySignal) observe(now time.Time, stored uint64) {
+	if stored <= vs.stored {
+		return
+	}
+	from := vs.stored
+	vs.stored = stored
+	last := vs.delivered[len(vs.delivered)-1].count
+	if from >= last {
+		return // duplicates
+	}
+	to := stored
+	if to > last {
+		to = last
+	}
+	chunk := (to - from + verifyChunks - 1) / verifyChunks
+	for n := from; n < to; n += chunk {
+		size := chunk
+		if n+size > to {
+			size = to - n
+		}
+		sent := vs.deliveredAt(n + size/2 + 1)
+		vs.delay.recordN(now.Sub(sent), size)
+	}
+}
+
+// deliveredAt returns when the n-th item was delivered
+func (vs *verifySignal) deliveredAt(n uint64) time.Time {
+	prev := curvePoint{}
+	for _, p := range vs.delivered {
+		if p.count >= n {
+			if prev.at.IsZero() || p.count == prev.count {
+				return p.at
+			}
+			frac := float64(n-prev.count) / float64(p.count-prev.count)
+			return prev.at.Add(time.Duration(frac * float64(p.at.Sub(prev.at))))
+		}
+		prev = p
+	}
+	return prev.at
+}
+
+// countQuery is the query counting the items of a run, with the run ID and
+// start time bound as the run_id and since parameters
+const countQuery = "SELECT count() FROM %s WHERE service_namespace = 'benchmark' AND timestamp >= fromUnixTimestamp64Nano({since:Int64}) AND attributes['" + runIDAttribute + "'] = {run_id:String}"
+
+// count returns how many items of the run a signal's table holds
+func (v *verifier) count(ctx context.Context, signal string) (uint64, error) {
+	query := fmt.Sprintf(countQuery, verifyTables[signal])
+	params := url.Values{
+		"database":     {v.database},
+		"param_run_id": {v.runID},
+		"param_since":  {strconv.FormatInt(v.since.UnixNano(), 10)},
+	}
+	u := v.url + "/?" + params.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, strings.NewReader(query))
+	if err != nil {
+		return 0, err
+	}
+	if v.user != "" {
+		req.Header.Set("X-ClickHouse-User", v.user)
+		req.Header.Set("X-ClickHouse-Key", v.password)
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(body)), 10, 64)
+}
+
+// verification returns the outcome of a signal
+func (v *verifier) verification(signal string) *Verification {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	vs, ok := v.signals[signal]
+	if !ok {
+		return nil
+	}
+	r := &Verification{
+		Delivered: v.stats.signals[signal].delivered.Load(),
+		Stored:    vs.stored,
+		Delay:     vs.delay.summary(),
+	}
+	if r.Stored < r.Delivered {
+		r.Lost = r.Delivered - r.Stored
+	} else {
+		r.Duplicates = r.Stored - r.Delivered
+	}
+	if r.Delivered > 0 {
+		r.LossPercent = float64(r.Lost) / float64(r.Delivered) * 100
+	}
+	return r
+}
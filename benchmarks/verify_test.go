@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifierCountBindsRunID(t *testing.T) {
+	const runID = "run-'1"
+	var query string
+	var params url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		query, params = string(body), r.URL.Query()
+		fmt.Fprintln(w, "42")
+	}))
+	defer srv.Close()
+
+	since := time.Unix(1700000000, 0)
+	v := &verifier{url: srv.URL, database: "otel", runID: runID, since: since, client: srv.Client()}
+	n, err := v.count(context.Background(), signalTraces)
+	if err != nil || n != 42 {
+		t.Fatalf("count() = %d, %v; want 42", n, err)
+	}
+	if !strings.Contains(query, "FROM otel_traces ") || strings.Contains(query, runID) {
+		t.Errorf("query = %q", query)
+	}
+	if params.Get("param_run_id") != runID || params.Get("param_since") != fmt.Sprint(since.UnixNano()) || params.Get("database") != "otel" {
+		t.Errorf("parameters = %v", params)
+	}
+}
+
+// TestVerifierCountFindsStoredItems inserts rows shaped like the collector's
+// and counts them with verifier.count
+func TestVerifierCountFindsStoredItems(t *testing.T) {
+	const clickhouseURL = "http://localhost:8123"
+	v := &verifier{
+		url:      clickhouseURL,
+		database: "otel",
+		user:     "default",
+		runID:    newRunID(),
+		since:    time.Now().Add(-time.Hour),
+		client:   &http.Client{Timeout: 5 * time.Second},
+	}
+	exec := func(query, body string) (string, error) {
+		u := clickhouseURL + "/?" + url.Values{"database": {v.database}, "query": {query}}.Encode()
+		resp, err := v.client.Post(u, "text/plain", strings.NewReader(body))
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		out, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("%s: %s", resp.Status, out)
+		}
+		return strings.TrimSpace(string(out)), nil
+	}
+	if out, err := exec("EXISTS TABLE otel_logs", ""); err != nil || out != "1" {
+		t.Skip("ClickHouse not available for test")
+	}
+
+	now := time.Now().UTC().Format("2006-01-02 15:04:05.000000000")
+	for signal, table := range verifyTables {
+		var rows strings.Builder
+		for _, id := range []string{v.runID, v.runID, "other-run"} {
+			fmt.Fprintf(&rows, `{"timestamp":%q,"service_namespace":"benchmark","attributes":{%q:%q}}`+"\n", now, runIDAttribute, id)
+		}
+		if _, err := exec("INSERT INTO "+table+" (timestamp, service_namespace, attributes) FORMAT JSONEachRow", rows.String()); err != nil {
+			t.Fatalf("%s: %v", table, err)
+		}
+		n, err := v.count(context.Background(), signal)
+		if err != nil || n != 2 {
+			t.Errorf("%s: count() = %d, %v; want 2", signal, n, err)
+		}
+	}
+}
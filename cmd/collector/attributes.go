@@ -0,0 +1,71 @@
+package main
+
+import (
+	"otelservices/internal/config"
+	"otelservices/internal/models"
+
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+)
+
+// attributeTiering splits the attributes of spans that carry more than
+// max_hot_attributes: configured hot keys stay in the attributes map used by
+// filters and group-bys, everything else is moved to attributes_cold
+type attributeTiering struct {
+	maxHot  int
+	hotKeys map[string]bool
+}
+
+func newAttributeTiering(cfg config.AttributesConfig) *attributeTiering {
+	if cfg.MaxHotAttributes <= 0 {
+		return nil
+	}
+	t := &attributeTiering{maxHot: cfg.MaxHotAttributes, hotKeys: make(map[string]bool, len(cfg.HotKeys))}
+	for _, key := range cfg.HotKeys {
+		t.hotKeys[key] = true
+	}
+	return t
+}
+
+// split returns the hot and cold attributes. Spans at or under the limit,
+// and every span when tiering is disabled, keep all attributes hot.
+func (t *attributeTiering) split(attrs map[string]string) (hot, cold map[string]string) {
+	if t == nil || len(attrs) <= t.maxHot {
+		return attrs, nil
+	}
+	hot = make(map[string]string, len(t.hotKeys))
+	cold = make(map[string]string, len(attrs))
+	for key, value := range attrs {
+		if t.hotKeys[key] {
+			hot[key] = value
+		} else {
+			cold[key] = value
+		}
+	}
+	return hot, cold
+}
+
+// typedAttributes returns the integer, double and boolean attributes with
+// their OTLP types; nil maps when there are none of a type
+func typedAttributes(attrs []*commonpb.KeyValue) models.TypedAttributes {
+	var typed models.TypedAttributes
+	for _, attr := range attrs {
+		switch val := attr.GetValue().GetValue().(type) {
+		case *commonpb.AnyValue_IntValue:
+			if typed.Int == nil {
+				typed.Int = make(map[string]int64)
+			}
+			typed.Int[attr.GetKey()] = val.IntValue
+		case *commonpb.AnyValue_DoubleValue:
+			if typed.Double == nil {
+				typed.Double = make(map[string]float64)
+			}
+			typed.Double[attr.GetKey()] = val.DoubleValue
+		case *commonpb.AnyValue_BoolValue:
+			if typed.Bool == nil {
+				typed.Bool = make(map[string]bool)
+			}
+			typed.Bool[attr.GetKey()] = val.BoolValue
+		}
+	}
+	return typed
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+
+	"otelservices/internal/config"
+
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+)
+
+func TestAttributeTieringDisabled(t *testing.T) {
+	tiering := newAttributeTiering(config.AttributesConfig{HotKeys: []string{"http.method"}})
+	if tiering != nil {
+		t.Fatal("expected no tiering without max_hot_attributes")
+	}
+	attrs := map[string]string{"a": "1", "b": "2"}
+	hot, cold := tiering.split(attrs)
+	if len(hot) != 2 || cold != nil {
+		t.Errorf("expected all attributes hot, got hot=%v cold=%v", hot, cold)
+	}
+}
+
+func TestAttributeTieringSplit(t *testing.T) {
+	tiering := newAttributeTiering(config.AttributesConfig{
+		MaxHotAttributes: 2,
+		HotKeys:          []string{"http.method", "http.route"},
+	})
+
+	small := map[string]string{"http.method": "GET", "custom": "x"}
+	if hot, cold := tiering.split(small); len(hot) != 2 || cold != nil {
+		t.Errorf("spans under the limit should not be tiered, got hot=%v cold=%v", hot, cold)
+	}
+
+	large := map[string]string{"http.method": "GET", "http.route": "/users", "custom.a": "1", "custom.b": "2"}
+	hot, cold := tiering.split(large)
+	if len(hot) != 2 || hot["http.method"] != "GET" || hot["http.route"] != "/users" {
+		t.Errorf("unexpected hot attributes: %v", hot)
+	}
+	if len(cold) != 2 || cold["custom.a"] != "1" || cold["custom.b"] != "2" {
+		t.Errorf("unexpected cold attributes: %v", cold)
+	}
+}
+
+func TestTypedAttributes(t *testing.T) {
+	typed := typedAttributes([]*commonpb.KeyValue{
+		{Key: "http.status_code", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: 503}}},
+		{Key: "retry.ratio", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: 0.25}}},
+		{Key: "cache.hit", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_BoolValue{BoolValue: true}}},
+		{Key: "http.method", Value: stringValue("GET")},
+	})
+	if typed.Int["http.status_code"] != 503 || typed.Double["retry.ratio"] != 0.25 || !typed.Bool["cache.hit"] {
+		t.Errorf("typed attributes = %+v", typed)
+	}
+	if len(typed.Int)+len(typed.Double)+len(typed.Bool) != 3 {
+		t.Errorf("string attributes should not be typed: %+v", typed)
+	}
+	if typed := typedAttributes([]*commonpb.KeyValue{{Key: "http.method", Value: stringValue("GET")}}); typed.Int != nil || typed.Double != nil || typed.Bool != nil {
+		t.Errorf("expected nil maps, got %+v", typed)
+	}
+}
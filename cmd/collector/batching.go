@@ -0,0 +1,136 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"otelservices/internal/config"
+	"otelservices/internal/monitoring"
+)
+
+const (
+	// latencyWindow is the number of recent inserts the p99 is taken over
+	latencyWindow = 20
+	// adjustEvery is the number of inserts between batch size changes, so
+	// each change is judged on latencies measured after it
+	adjustEvery = 5
+)
+
+// batchSizer adapts the flush size of one signal's batch processors to keep
+// p99 insert latency within performance.insert_latency_target. The size
+// shrinks by a quarter when the target is missed and grows by an eighth
+// while full batches finish in under half of it, staying between a tenth
+// and four times batch_size. Without a target the size stays at batch_size.
+// It also holds the signal's batch timeout and byte limit, which a config
+// reload can change along with the rest.
+type batchSizer struct {
+	signal string
+
+	mu        sync.Mutex
+	base      int // batch_size
+	target    time.Duration
+	min       int
+	max       int
+	timeout   time.Duration
+	maxBytes  int
+	size      int
+	latencies []time.Duration
+	observed  int
+}
+
+func newBatchSizer(signal string, perf config.PerformanceConfig) *batchSizer {
+	b := &batchSizer{signal: signal}
+	b.configure(perf)
+	return b
+}
+
+// configure applies the batch settings. A new batch_size restarts
+// adaptation from it; otherwise the adapted size is kept within the bounds.
+func (b *batchSizer) configure(perf config.PerformanceConfig) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if perf.BatchSize != b.base {
+		b.base = perf.BatchSize
+		b.size = perf.BatchSize
+		b.latencies = b.latencies[:0]
+	}
+	b.target = perf.InsertLatencyTarget
+	b.timeout = perf.BatchTimeout
+	b.maxBytes = perf.MaxBatchBytes
+	b.min = perf.BatchSize / 10
+	b.max = perf.BatchSize * 4
+	if b.min < 1 {
+		b.min = 1
+	}
+	if b.target <= 0 {
+		b.size = b.base
+	}
+	b.size = max(b.min, min(b.size, b.max))
+	monitoring.BatchTargetSize.WithLabelValues(b.signal).Set(float64(b.size))
+}
+
+// Size returns the number of items at which a batch is flushed
+func (b *batchSizer) Size() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.size
+}
+
+// Full reports whether a batch of n items and the given estimated size
+// should be flushed
+func (b *batchSizer) Full(n, bytes int) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return n >= b.size || (b.maxBytes > 0 && bytes >= b.maxBytes)
+}
+
+// Timeout returns the longest a partial batch waits before it is flushed
+func (b *batchSizer) Timeout() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.timeout
+}
+
+// Observe records the latency of inserting a batch of n items
+func (b *batchSizer) Observe(latency time.Duration, n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.target <= 0 {
+		return
+	}
+	if len(b.latencies) == latencyWindow {
+		b.latencies = b.latencies[1:]
+	}
+	b.latencies = append(b.latencies, latency)
+	b.observed++
+	if b.observed%adjustEvery != 0 {
+		return
+	}
+
+	p99 := percentile(b.latencies, 0.99)
+	switch {
+	case p99 > b.target:
+		b.size -= b.size / 4
+		// Latencies measured at the old size no longer apply
+		b.latencies = b.latencies[:0]
+	case p99 < b.target/2 && n >= b.size:
+		b.size += b.size/8 + 1
+	}
+	if b.size < b.min {
+		b.size = b.min
+	}
+	if b.size > b.max {
+		b.size = b.max
+	}
+	monitoring.BatchTargetSize.WithLabelValues(b.signal).Set(float64(b.size))
+}
+
+func percentile(latencies []time.Duration, p float64) time.Duration {
+	if len(latencies) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration(nil), latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[int(p*float64(len(sorted)-1)+0.5)]
+}
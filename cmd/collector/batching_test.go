@@ -0,0 +1,95 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"otelservices/internal/config"
+)
+
+func observeN(b *batchSizer, n int, latency time.Duration, items int) {
+	for i := 0; i < n; i++ {
+		b.Observe(latency, items)
+	}
+}
+
+func TestBatchSizerFixedWithoutTarget(t *testing.T) {
+	b := newBatchSizer(signalTraces, config.PerformanceConfig{BatchSize: 1000})
+	observeN(b, 50, 10*time.Second, 1000)
+	if b.Size() != 1000 {
+		t.Errorf("size = %d, want batch_size without a latency target", b.Size())
+	}
+}
+
+func TestBatchSizerShrinksWhenSlow(t *testing.T) {
+	b := newBatchSizer(signalTraces, config.PerformanceConfig{BatchSize: 1000, InsertLatencyTarget: time.Second})
+
+	observeN(b, adjustEvery, 3*time.Second, 1000)
+	if got := b.Size(); got != 750 {
+		t.Fatalf("size = %d after slow inserts, want 750", got)
+	}
+
+	observeN(b, 100, 3*time.Second, 100)
+	if got := b.Size(); got != 100 {
+		t.Errorf("size = %d, want floor of batch_size/10", got)
+	}
+}
+
+func TestBatchSizerGrowsWhenFast(t *testing.T) {
+	b := newBatchSizer(signalTraces, config.PerformanceConfig{BatchSize: 1000, InsertLatencyTarget: time.Second})
+
+	// Partial batches say nothing about larger ones
+	observeN(b, adjustEvery, 100*time.Millisecond, 10)
+	if got := b.Size(); got != 1000 {
+		t.Fatalf("size = %d after partial batches, want 1000", got)
+	}
+
+	observeN(b, adjustEvery, 100*time.Millisecond, 1000)
+	if got := b.Size(); got != 1126 {
+		t.Fatalf("size = %d after fast full batches, want 1126", got)
+	}
+
+	for i := 0; i < 200; i++ {
+		observeN(b, 1, 100*time.Millisecond, b.Size())
+	}
+	if got := b.Size(); got != 4000 {
+		t.Errorf("size = %d, want cap of 4x batch_size", got)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	var latencies []time.Duration
+	for i := 1; i <= 100; i++ {
+		latencies = append(latencies, time.Duration(i)*time.Millisecond)
+	}
+	if got := percentile(latencies, 0.99); got != 99*time.Millisecond {
+		t.Errorf("p99 = %v, want 99ms", got)
+	}
+	if got := percentile(nil, 0.99); got != 0 {
+		t.Errorf("p99 of nothing = %v, want 0", got)
+	}
+}
+
+func TestBatchSizerConfigure(t *testing.T) {
+	b := newBatchSizer(signalTraces, config.PerformanceConfig{BatchSize: 1000, InsertLatencyTarget: time.Second})
+	observeN(b, adjustEvery, 3*time.Second, 1000)
+
+	// A new latency target keeps the adapted size
+	b.configure(config.PerformanceConfig{BatchSize: 1000, InsertLatencyTarget: 2 * time.Second})
+	if got := b.Size(); got != 750 {
+		t.Errorf("size = %d, want adapted size kept", got)
+	}
+
+	// A new batch size restarts from it
+	b.configure(config.PerformanceConfig{BatchSize: 400, InsertLatencyTarget: 2 * time.Second})
+	if got := b.Size(); got != 400 {
+		t.Errorf("size = %d, want new batch_size", got)
+	}
+
+	// Dropping the target returns to batch_size
+	observeN(b, adjustEvery, 3*time.Second, 400)
+	b.configure(config.PerformanceConfig{BatchSize: 400})
+	if got := b.Size(); got != 400 {
+		t.Errorf("size = %d, want batch_size without a target", got)
+	}
+}
@@ -0,0 +1,524 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	mathrand "math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"otelservices/internal/config"
+
+	"google.golang.org/protobuf/proto"
+
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+)
+
+// A capture file is a gzip stream of captureMagic, the start of the capture
+// in Unix nanoseconds as 8 big-endian bytes, and one record per request: the
+// signal (1 traces, 2 metrics, 3 logs), the uvarint nanoseconds since the
+// start, the uvarint length of the request and the export request as binary
+// protobuf. The load test's -replay mode reads it.
+const captureMagic = "OTLPCAP1"
+
+// captureBuffer bounds the sampled requests waiting to be written; past it
+// requests are dropped from the capture rather than slowing down ingest
+const captureBuffer = 1024
+
+var captureSignals = map[string]byte{signalTraces: 1, signalMetrics: 2, signalLogs: 3}
+
+// Reasons a capture ended
+const (
+	captureStopped     = "stopped"
+	captureShutdown    = "shutdown"
+	captureMaxDuration = "max_duration"
+	captureMaxRequests = "max_requests"
+	captureMaxSize     = "max_size"
+)
+
+var errCaptureActive = errors.New("a capture is already running")
+
+// CaptureStatus describes the running or the last capture, as served by
+// /api/v1/admin/capture
+type CaptureStatus struct {
+	Active      bool      `json:"active"`
+	File        string    `json:"file"`
+	StartedAt   time.Time `json:"started_at"`
+	Until       time.Time `json:"until"` // when max_duration ends it
+	SampleRatio float64   `json:"sample_ratio"`
+	MaxRequests int       `json:"max_requests"`
+	Requests    int       `json:"requests"` // written to the file
+	Bytes       int64     `json:"bytes"`    // compressed
+	// Dropped are sampled requests left out because the file writer fell
+	// behind
+	Dropped    uint64     `json:"dropped"`
+	StoppedAt  *time.Time `json:"stopped_at,omitempty"`
+	StopReason string     `json:"stop_reason,omitempty"`
+	Error      string     `json:"error,omitempty"`
+}
+
+// captureOptions override the configured limits of one capture
+type captureOptions struct {
+	duration    time.Duration
+	sampleRatio float64
+	maxRequests int
+}
+
+// captureRecorder writes a sanitized sample of the received export requests
+// to a file while a capture runs. A nil recorder, without capture.directory,
+// records nothing.
+type captureRecorder struct {
+	cfg        config.CaptureConfig
+	instanceID string
+	active     atomic.Pointer[capture]
+
+	mu   sync.Mutex // serializes starting captures
+	last *capture
+}
+
+// capture is one running or finished capture
+type capture struct {
+	started time.Time
+	ratio   float64
+	max     uint64
+	queued  atomic.Uint64
+	dropped atomic.Uint64
+	records chan captureRecord // never closed; sends after the end are dropped
+	stop    chan string
+	done    chan struct{}
+
+	mu     sync.Mutex
+	status CaptureStatus
+}
+
+type captureRecord struct {
+	signal string
+	at     time.Duration
+	req    proto.Message
+}
+
+func newCaptureRecorder(cfg config.CaptureConfig, instanceID string) *captureRecorder {
+	if cfg.Directory == "" {
+		return nil
+	}
+	return &captureRecorder{cfg: cfg, instanceID: instanceID}
+}
+
+// record adds a sample of the export requests to the running capture.
+// Exports forwarded by a routing peer were captured where they arrived.
+func (r *captureRecorder) record(ctx context.Context, signal string, req proto.Message) {
+	if r == nil {
+		return
+	}
+	c := r.active.Load()
+	if c == nil || forwarded(ctx) || mathrand.Float64() >= c.ratio {
+		return
+	}
+	if c.queued.Add(1) > c.max {
+		return
+	}
+	select {
+	case c.records <- captureRecord{signal: signal, at: time.Since(c.started), req: proto.Clone(req)}:
+	default:
+		c.dropped.Add(1)
+	}
+}
+
+// start opens a capture file and starts recording to it
+func (r *captureRecorder) start(opts captureOptions) (CaptureStatus, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.active.Load() != nil {
+		return CaptureStatus{}, errCaptureActive
+	}
+	// The configured limits cap what a request asks for
+	if opts.duration <= 0 || opts.duration > r.cfg.MaxDuration {
+		opts.duration = r.cfg.MaxDuration
+	}
+	if opts.sampleRatio <= 0 {
+		opts.sampleRatio = r.cfg.SampleRatio
+	}
+	if opts.maxRequests <= 0 || opts.maxRequests > r.cfg.MaxRequests {
+		opts.maxRequests = r.cfg.MaxRequests
+	}
+	sanitizer, err := newCaptureSanitizer(r.cfg.KeepAttributes)
+	if err != nil {
+		return CaptureStatus{}, err
+	}
+
+	now := time.Now()
+	if err := os.MkdirAll(r.cfg.Directory, 0o755); err != nil {
+		return CaptureStatus{}, err
+	}
+	name := fmt.Sprintf("capture-%s-%s.otlpcap.gz", r.instanceID, now.UTC().Format("20060102T150405Z"))
+	path := filepath.Join(r.cfg.Directory, name)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return CaptureStatus{}, err
+	}
+	c := &capture{
+		started: now,
+		ratio:   opts.sampleRatio,
+		max:     uint64(opts.maxRequests),
+		records: make(chan captureRecord, captureBuffer),
+		stop:    make(chan string, 1),
+		done:    make(chan struct{}),
+		status: CaptureStatus{
+			Active:      true,
+			File:        path,
+			StartedAt:   now,
+			Until:       now.Add(opts.duration),
+			SampleRatio: opts.sampleRatio,
+			MaxRequests: opts.maxRequests,
+		},
+	}
+	r.last = c
+	r.active.Store(c)
+	go r.write(c, f, sanitizer, int64(r.cfg.MaxSizeMiB)<<20, opts.duration)
+	logger.Info("Capture started", "file", path, "sample_ratio", opts.sampleRatio, "max_duration", opts.duration, "max_requests", opts.maxRequests)
+	return c.snapshot(), nil
+}
+
+// stop ends the running capture and returns the status of the last one
+func (r *captureRecorder) stop(reason string) (CaptureStatus, bool) {
+	if r == nil {
+		return CaptureStatus{}, false
+	}
+	if c := r.active.Load(); c != nil {
+		select {
+		case c.stop <- reason:
+		default:
+		}
+		<-c.done
+	}
+	return r.status()
+}
+
+// status returns the status of the running or the last capture
+func (r *captureRecorder) status() (CaptureStatus, bool) {
+	r.mu.Lock()
+	c := r.last
+	r.mu.Unlock()
+	if c == nil {
+		return CaptureStatus{}, false
+	}
+	return c.snapshot(), true
+}
+
+func (c *capture) snapshot() CaptureStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.status
+	s.Dropped = c.dropped.Load()
+	return s
+}
+
+// write writes the records of a capture to f until it ends
+func (r *captureRecorder) write(c *capture, f *os.File, sanitizer *captureSanitizer, maxBytes int64, duration time.Duration) {
+	defer close(c.done)
+	out := &countingWriter{w: f}
+	gz := gzip.NewWriter(out)
+	header := binary.BigEndian.AppendUint64([]byte(captureMagic), uint64(c.started.UnixNano()))
+	_, err := gz.Write(header)
+
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	reason := ""
+	var buf []byte
+	for reason == "" && err == nil {
+		select {
+		case reason = <-c.stop:
+		case <-timer.C:
+			reason = captureMaxDuration
+		case rec := <-c.records:
+			sanitizer.sanitize(rec.req)
+			var payload []byte
+			if payload, err = proto.Marshal(rec.req); err != nil {
+				break
+			}
+			buf = append(buf[:0], captureSignals[rec.signal])
+			buf = binary.AppendUvarint(buf, uint64(rec.at))
+			buf = binary.AppendUvarint(buf, uint64(len(payload)))
+			buf = append(buf, payload...)
+			if _, err = gz.Write(buf); err != nil {
+				break
+			}
+			c.mu.Lock()
+			c.status.Requests++
+			c.status.Bytes = out.n
+			requests := c.status.Requests
+			c.mu.Unlock()
+			switch {
+			case uint64(requests) >= c.max:
+				reason = captureMaxRequests
+			case out.n >= maxBytes:
+				reason = captureMaxSize
+			}
+		}
+	}
+	r.active.CompareAndSwap(c, nil)
+	if closeErr := gz.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	now := time.Now()
+	c.mu.Lock()
+	c.status.Active = false
+	c.status.Bytes = out.n
+	c.status.StoppedAt = &now
+	c.status.StopReason = reason
+	if err != nil {
+		c.status.Error = err.Error()
+	}
+	status := c.status
+	c.mu.Unlock()
+	if err != nil {
+		logger.Error("Capture failed", "file", status.File, "error", err)
+		return
+	}
+	logger.Info("Capture finished", "file", status.File, "requests", status.Requests, "bytes", status.Bytes, "dropped", c.dropped.Load(), "reason", reason)
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// handle serves /api/v1/admin/capture: GET returns the status of the running
+// or last capture, POST starts one, with optional duration, sample_ratio and
+// max_requests parameters overriding the configured limits, and DELETE
+// stops it
+func (r *captureRecorder) handle(w http.ResponseWriter, req *http.Request) {
+	if r == nil {
+		http.Error(w, "captures are disabled: set capture.directory", http.StatusNotFound)
+		return
+	}
+	var status CaptureStatus
+	var ok bool
+	switch req.Method {
+	case http.MethodGet:
+		status, ok = r.status()
+	case http.MethodPost:
+		opts, err := parseCaptureOptions(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		status, err = r.start(opts)
+		if err == errCaptureActive {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		ok = true
+	case http.MethodDelete:
+		status, ok = r.stop(captureStopped)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !ok {
+		http.Error(w, "no capture has run", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(status)
+}
+
+func parseCaptureOptions(req *http.Request) (captureOptions, error) {
+	var opts captureOptions
+	q := req.URL.Query()
+	if v := q.Get("duration"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return opts, fmt.Errorf("invalid duration %q", v)
+		}
+		opts.duration = d
+	}
+	if v := q.Get("sample_ratio"); v != "" {
+		ratio, err := strconv.ParseFloat(v, 64)
+		if err != nil || ratio <= 0 || ratio > 1 {
+			return opts, fmt.Errorf("invalid sample_ratio %q: must be above 0 and at most 1", v)
+		}
+		opts.sampleRatio = ratio
+	}
+	if v := q.Get("max_requests"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return opts, fmt.Errorf("invalid max_requests %q", v)
+		}
+		opts.maxRequests = n
+	}
+	return opts, nil
+}
+
+// captureSanitizer replaces the values of captured requests with
+// pseudonyms: the HMAC of the value under a key drawn for the capture, hex
+// encoded and cut or repeated to the length of the value. Equal values get
+// equal pseudonyms within a capture, so the cardinality and the size of the
+// data are kept, but the values cannot be recovered. Names of spans,
+// metrics, events and scopes, IDs, timestamps and numbers are kept.
+type captureSanitizer struct {
+	key  []byte
+	keep map[string]bool
+}
+
+func newCaptureSanitizer(keep []string) (*captureSanitizer, error) {
+	s := &captureSanitizer{key: make([]byte, 32), keep: make(map[string]bool, len(keep))}
+	if _, err := rand.Read(s.key); err != nil {
+		return nil, err
+	}
+	for _, k := range keep {
+		s.keep[k] = true
+	}
+	return s, nil
+}
+
+func (s *captureSanitizer) pseudonym(v string) string {
+	if v == "" {
+		return v
+	}
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(v))
+	sum := hex.EncodeToString(mac.Sum(nil))
+	out := make([]byte, len(v))
+	for i := 0; i < len(out); i += len(sum) {
+		copy(out[i:], sum)
+	}
+	return string(out)
+}
+
+func (s *captureSanitizer) attributes(kvs []*commonpb.KeyValue) {
+	for _, kv := range kvs {
+		if !s.keep[kv.Key] {
+			s.value(kv.Value)
+		}
+	}
+}
+
+func (s *captureSanitizer) value(v *commonpb.AnyValue) {
+	switch x := v.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		x.StringValue = s.pseudonym(x.StringValue)
+	case *commonpb.AnyValue_BytesValue:
+		x.BytesValue = []byte(s.pseudonym(string(x.BytesValue)))
+	case *commonpb.AnyValue_ArrayValue:
+		for _, e := range x.ArrayValue.GetValues() {
+			s.value(e)
+		}
+	case *commonpb.AnyValue_KvlistValue:
+		s.attributes(x.KvlistValue.GetValues())
+	}
+}
+
+// sanitize replaces the values of an export request in place
+func (s *captureSanitizer) sanitize(req proto.Message) {
+	switch req := req.(type) {
+	case *coltracepb.ExportTraceServiceRequest:
+		for _, rs := range req.ResourceSpans {
+			s.attributes(rs.Resource.GetAttributes())
+			for _, ss := range rs.ScopeSpans {
+				s.attributes(ss.Scope.GetAttributes())
+				for _, span := range ss.Spans {
+					s.attributes(span.Attributes)
+					if span.Status != nil {
+						span.Status.Message = s.pseudonym(span.Status.Message)
+					}
+					for _, e := range span.Events {
+						s.attributes(e.Attributes)
+					}
+					for _, l := range span.Links {
+						s.attributes(l.Attributes)
+					}
+				}
+			}
+		}
+	case *colmetricspb.ExportMetricsServiceRequest:
+		for _, rm := range req.ResourceMetrics {
+			s.attributes(rm.Resource.GetAttributes())
+			for _, sm := range rm.ScopeMetrics {
+				s.attributes(sm.Scope.GetAttributes())
+				for _, m := range sm.Metrics {
+					s.metric(m)
+				}
+			}
+		}
+	case *collogspb.ExportLogsServiceRequest:
+		for _, rl := range req.ResourceLogs {
+			s.attributes(rl.Resource.GetAttributes())
+			for _, sl := range rl.ScopeLogs {
+				s.attributes(sl.Scope.GetAttributes())
+				for _, l := range sl.LogRecords {
+					s.attributes(l.Attributes)
+					s.value(l.Body)
+				}
+			}
+		}
+	}
+}
+
+func (s *captureSanitizer) metric(m *metricspb.Metric) {
+	exemplars := func(es []*metricspb.Exemplar) {
+		for _, e := range es {
+			s.attributes(e.FilteredAttributes)
+		}
+	}
+	switch d := m.Data.(type) {
+	case *metricspb.Metric_Gauge:
+		for _, p := range d.Gauge.GetDataPoints() {
+			s.attributes(p.Attributes)
+			exemplars(p.Exemplars)
+		}
+	case *metricspb.Metric_Sum:
+		for _, p := range d.Sum.GetDataPoints() {
+			s.attributes(p.Attributes)
+			exemplars(p.Exemplars)
+		}
+	case *metricspb.Metric_Histogram:
+		for _, p := range d.Histogram.GetDataPoints() {
+			s.attributes(p.Attributes)
+			exemplars(p.Exemplars)
+		}
+	case *metricspb.Metric_ExponentialHistogram:
+		for _, p := range d.ExponentialHistogram.GetDataPoints() {
+			s.attributes(p.Attributes)
+			exemplars(p.Exemplars)
+		}
+	case *metricspb.Metric_Summary:
+		for _, p := range d.Summary.GetDataPoints() {
+			s.attributes(p.Attributes)
+		}
+	}
+}
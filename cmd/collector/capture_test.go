@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"otelservices/internal/config"
+
+	"google.golang.org/protobuf/proto"
+
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+func testCaptureConfig(t *testing.T) config.CaptureConfig {
+	cfg := config.DefaultConfig().Capture
+	cfg.Directory = t.TempDir()
+	cfg.SampleRatio = 1
+	return cfg
+}
+
+func TestCaptureSanitizer(t *testing.T) {
+	s, err := newCaptureSanitizer([]string{"service.name"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := &coltracepb.ExportTraceServiceRequest{ResourceSpans: []*tracepb.ResourceSpans{{
+		Resource: serviceResource("checkout", "shop"),
+		ScopeSpans: []*tracepb.ScopeSpans{{Spans: []*tracepb.Span{{
+			Name: "GET /cart",
+			Attributes: []*commonpb.KeyValue{
+				{Key: "user.email", Value: stringValue("jane@example.com")},
+				{Key: "retries", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: 3}}},
+				{Key: "tags", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_ArrayValue{ArrayValue: &commonpb.ArrayValue{
+					Values: []*commonpb.AnyValue{stringValue("jane@example.com")},
+				}}}},
+			},
+			Status: &tracepb.Status{Message: "card declined"},
+		}}}},
+	}}}
+	s.sanitize(req)
+
+	rs := req.ResourceSpans[0]
+	if got := extractStringAttribute(rs.Resource, "service.name"); got != "checkout" {
+		t.Errorf("kept attribute = %q, want checkout", got)
+	}
+	if got := extractStringAttribute(rs.Resource, "service.namespace"); got == "shop" || len(got) != len("shop") {
+		t.Errorf("service.namespace = %q, want a pseudonym of 4 characters", got)
+	}
+	span := rs.ScopeSpans[0].Spans[0]
+	if span.Name != "GET /cart" {
+		t.Errorf("span name = %q, want it kept", span.Name)
+	}
+	email := span.Attributes[0].Value.GetStringValue()
+	if email == "jane@example.com" || len(email) != len("jane@example.com") {
+		t.Errorf("user.email = %q, want a pseudonym of the same length", email)
+	}
+	if got := span.Attributes[1].Value.GetIntValue(); got != 3 {
+		t.Errorf("retries = %d, want numbers kept", got)
+	}
+	if got := span.Attributes[2].Value.GetArrayValue().Values[0].GetStringValue(); got != email {
+		t.Errorf("array element = %q, want the same pseudonym %q as the equal value", got, email)
+	}
+	if got := span.Status.Message; got == "card declined" || len(got) != len("card declined") {
+		t.Errorf("status message = %q, want a pseudonym", got)
+	}
+
+	long := strings.Repeat("x", 200)
+	if got := s.pseudonym(long); len(got) != len(long) {
+		t.Errorf("pseudonym of %d characters has %d", len(long), len(got))
+	}
+
+	other, _ := newCaptureSanitizer(nil)
+	if other.pseudonym("jane@example.com") == email {
+		t.Error("captures share pseudonyms; want a key per capture")
+	}
+}
+
+// readCapture returns the signals and requests of a capture file
+func readCapture(t *testing.T, path string) ([]byte, [][]byte) {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := bufio.NewReader(gz)
+	header := make([]byte, len(captureMagic)+8)
+	if _, err := io.ReadFull(r, header); err != nil || string(header[:len(captureMagic)]) != captureMagic {
+		t.Fatalf("header = %q, %v", header, err)
+	}
+	var signals []byte
+	var payloads [][]byte
+	for {
+		signal, err := r.ReadByte()
+		if err == io.EOF {
+			return signals, payloads
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := binary.ReadUvarint(r); err != nil {
+			t.Fatal(err)
+		}
+		n, err := binary.ReadUvarint(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			t.Fatal(err)
+		}
+		signals = append(signals, signal)
+		payloads = append(payloads, payload)
+	}
+}
+
+func TestCaptureRecorder(t *testing.T) {
+	r := newCaptureRecorder(testCaptureConfig(t), "test")
+	ctx := context.Background()
+	traces := &coltracepb.ExportTraceServiceRequest{ResourceSpans: []*tracepb.ResourceSpans{{
+		Resource:   serviceResource("checkout", "shop"),
+		ScopeSpans: []*tracepb.ScopeSpans{{Spans: []*tracepb.Span{{Name: "GET /cart"}}}},
+	}}}
+	r.record(ctx, signalTraces, traces) // no capture running
+
+	status, err := r.start(captureOptions{maxRequests: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.start(captureOptions{}); err != errCaptureActive {
+		t.Errorf("second start = %v, want %v", err, errCaptureActive)
+	}
+	r.record(ctx, signalTraces, traces)
+	r.record(ctx, signalLogs, &collogspb.ExportLogsServiceRequest{ResourceLogs: []*logspb.ResourceLogs{{
+		ScopeLogs: []*logspb.ScopeLogs{{LogRecords: []*logspb.LogRecord{{Body: stringValue("secret")}}}},
+	}}})
+	select {
+	case <-r.last.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("capture did not stop after max_requests")
+	}
+	status, _ = r.status()
+	if status.Active || status.StopReason != captureMaxRequests || status.Requests != 2 {
+		t.Errorf("status = %+v, want stopped by max_requests after 2 requests", status)
+	}
+	if namespace := extractStringAttribute(traces.ResourceSpans[0].Resource, "service.namespace"); namespace != "shop" {
+		t.Errorf("received request changed to %q; want captures sanitized on a copy", namespace)
+	}
+
+	signals, payloads := readCapture(t, status.File)
+	if string(signals) != "\x01\x03" {
+		t.Fatalf("signals = %v, want traces then logs", signals)
+	}
+	logs := &collogspb.ExportLogsServiceRequest{}
+	if err := proto.Unmarshal(payloads[1], logs); err != nil {
+		t.Fatal(err)
+	}
+	if body := logs.ResourceLogs[0].ScopeLogs[0].LogRecords[0].Body.GetStringValue(); body == "secret" || len(body) != len("secret") {
+		t.Errorf("log body = %q, want a pseudonym", body)
+	}
+}
+
+func TestCaptureHandler(t *testing.T) {
+	var disabled *captureRecorder
+	rec := httptest.NewRecorder()
+	disabled.handle(rec, httptest.NewRequest(http.MethodPost, "/api/v1/admin/capture", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("disabled: status %d, want 404", rec.Code)
+	}
+
+	r := newCaptureRecorder(testCaptureConfig(t), "test")
+	for _, tc := range []struct {
+		method, query string
+		want          int
+	}{
+		{http.MethodGet, "", http.StatusNotFound},
+		{http.MethodPost, "?sample_ratio=2", http.StatusBadRequest},
+		{http.MethodPost, "?duration=1m&sample_ratio=0.5", http.StatusOK},
+		{http.MethodPost, "", http.StatusConflict},
+		{http.MethodGet, "", http.StatusOK},
+		{http.MethodDelete, "", http.StatusOK},
+		{http.MethodPut, "", http.StatusMethodNotAllowed},
+	} {
+		rec := httptest.NewRecorder()
+		r.handle(rec, httptest.NewRequest(tc.method, "/api/v1/admin/capture"+tc.query, nil))
+		if rec.Code != tc.want {
+			t.Errorf("%s %s: status %d, want %d: %s", tc.method, tc.query, rec.Code, tc.want, rec.Body)
+		}
+	}
+	status, _ := r.status()
+	if status.Active || status.StopReason != captureStopped || status.SampleRatio != 0.5 {
+		t.Errorf("status = %+v, want stopped with sample ratio 0.5", status)
+	}
+}
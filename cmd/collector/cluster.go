@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"otelservices/internal/monitoring"
+)
+
+// ingestRateInterval is how often the ingest rates of the cluster view are
+// updated
+const ingestRateInterval = 10 * time.Second
+
+// clusterPeerTimeout bounds the request for the ingest rates of one peer
+const clusterPeerTimeout = 2 * time.Second
+
+// SignalIngestRate is how fast one signal was received over the last
+// ingestRateInterval
+type SignalIngestRate struct {
+	Signal         string  `json:"signal"`
+	ItemsPerSecond float64 `json:"items_per_second"`
+	BytesPerSecond float64 `json:"bytes_per_second"`
+}
+
+// ClusterInstance is one collector of the cluster view
+type ClusterInstance struct {
+	InstanceID string `json:"instance_id,omitempty"`
+	// Address is the collector's trace routing address, empty without
+	// routing
+	Address string `json:"address,omitempty"`
+	Self    bool   `json:"self"`
+	// Error is why the rates of a peer could not be read
+	Error          string             `json:"error,omitempty"`
+	Rates          []SignalIngestRate `json:"rates"`
+	ItemsPerSecond float64            `json:"items_per_second"`
+	// Share is the instance's part of the items per second of the
+	// instances that answered, between 0 and 1
+	Share float64 `json:"share"`
+}
+
+// ClusterResponse is served on /api/v1/admin/cluster
+type ClusterResponse struct {
+	Routing        bool              `json:"routing"`
+	ItemsPerSecond float64           `json:"items_per_second"`
+	Instances      []ClusterInstance `json:"instances"`
+}
+
+// updateRates computes the rate of each signal since the last update
+func (s *ingestStats) updateRates(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	totals := make(map[string]signalTotals)
+	for key, st := range s.services {
+		t := totals[key.signal]
+		t.items += st.Received
+		t.bytes += st.Bytes
+		totals[key.signal] = t
+	}
+	elapsed := now.Sub(s.rateAt).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+	for _, signal := range []string{signalTraces, signalMetrics, signalLogs} {
+		cur, prev := totals[signal], s.rateTotals[signal]
+		s.rates[signal] = SignalIngestRate{
+			Signal:         signal,
+			ItemsPerSecond: float64(cur.items-prev.items) / elapsed,
+			BytesPerSecond: float64(cur.bytes-prev.bytes) / elapsed,
+		}
+	}
+	s.rateAt, s.rateTotals = now, totals
+}
+
+// trackRates updates the rates every ingestRateInterval until ctx is done
+func (s *ingestStats) trackRates(ctx context.Context) {
+	ticker := time.NewTicker(ingestRateInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.updateRates(now)
+		}
+	}
+}
+
+// ingestRates returns the rates of the last update, by signal
+func (s *ingestStats) ingestRates() []SignalIngestRate {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rates := make([]SignalIngestRate, 0, 3)
+	for _, signal := range []string{signalTraces, signalMetrics, signalLogs} {
+		rate, ok := s.rates[signal]
+		if !ok {
+			rate = SignalIngestRate{Signal: signal}
+		}
+		rates = append(rates, rate)
+	}
+	return rates
+}
+
+// localInstance describes this collector in the cluster view
+func (c *Collector) localInstance() ClusterInstance {
+	inst := ClusterInstance{
+		InstanceID: monitoring.InstanceID(c.config.Monitoring),
+		Self:       true,
+		Rates:      c.stats.ingestRates(),
+	}
+	if c.trace.router != nil {
+		inst.Address = c.trace.router.self
+	}
+	return inst
+}
+
+// handleCluster lists this collector and its trace routing peers with the
+// rate each of them ingests, and its share of the total, to check that a
+// load balancer spreads the traffic evenly. Peers are asked on the
+// server.port of this collector; with local=true only this collector is
+// listed.
+func (c *Collector) handleCluster(w http.ResponseWriter, r *http.Request) {
+	resp := ClusterResponse{Instances: []ClusterInstance{c.localInstance()}}
+	if router := c.trace.router; router != nil {
+		resp.Routing = true
+		if r.URL.Query().Get("local") != "true" {
+			resp.Instances = append(resp.Instances, c.peerInstances(r.Context(), router.peerAddrs())...)
+		}
+	}
+	for i := range resp.Instances {
+		inst := &resp.Instances[i]
+		inst.ItemsPerSecond = 0
+		for _, rate := range inst.Rates {
+			inst.ItemsPerSecond += rate.ItemsPerSecond
+		}
+		resp.ItemsPerSecond += inst.ItemsPerSecond
+	}
+	if resp.ItemsPerSecond > 0 {
+		for i := range resp.Instances {
+			resp.Instances[i].Share = resp.Instances[i].ItemsPerSecond / resp.ItemsPerSecond
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// peerInstances asks the peers at their routing addresses for their rates,
+// in parallel
+func (c *Collector) peerInstances(ctx context.Context, addrs []string) []ClusterInstance {
+	client := &http.Client{Timeout: clusterPeerTimeout}
+	instances := make([]ClusterInstance, len(addrs))
+	var wg sync.WaitGroup
+	for i, addr := range addrs {
+		wg.Add(1)
+		go func(i int, addr string) {
+			defer wg.Done()
+			inst, err := c.peerInstance(ctx, client, addr)
+			if err != nil {
+				inst = ClusterInstance{Error: err.Error(), Rates: []SignalIngestRate{}}
+			}
+			inst.Address, inst.Self = addr, false
+			instances[i] = inst
+		}(i, addr)
+	}
+	wg.Wait()
+	return instances
+}
+
+func (c *Collector) peerInstance(ctx context.Context, client *http.Client, addr string) (ClusterInstance, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return ClusterInstance{}, err
+	}
+	url := "http://" + net.JoinHostPort(host, strconv.Itoa(c.config.Server.Port)) + "/api/v1/admin/cluster?local=true"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return ClusterInstance{}, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return ClusterInstance{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return ClusterInstance{}, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	var peer ClusterResponse
+	if err := json.NewDecoder(resp.Body).Decode(&peer); err != nil {
+		return ClusterInstance{}, fmt.Errorf("%s: %w", url, err)
+	}
+	if len(peer.Instances) == 0 {
+		return ClusterInstance{}, fmt.Errorf("%s: no instance", url)
+	}
+	return peer.Instances[0], nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"otelservices/internal/config"
+)
+
+func TestIngestRates(t *testing.T) {
+	s := newIngestStats()
+	start := s.rateAt
+	s.record(signalTraces, "checkout", 1000, 40, 0, 0)
+	s.record(signalTraces, "cart", 500, 60, 0, 0)
+	s.record(signalLogs, "checkout", 100, 20, 0, 0)
+	s.updateRates(start.Add(10 * time.Second))
+	s.record(signalTraces, "checkout", 2000, 50, 0, 0)
+	s.updateRates(start.Add(20 * time.Second))
+
+	rates := s.ingestRates()
+	if len(rates) != 3 {
+		t.Fatalf("rates %+v", rates)
+	}
+	if tr := rates[0]; tr.Signal != signalTraces || tr.ItemsPerSecond != 5 || tr.BytesPerSecond != 200 {
+		t.Errorf("traces rate %+v", tr)
+	}
+	if logs := rates[2]; logs.Signal != signalLogs || logs.ItemsPerSecond != 0 {
+		t.Errorf("logs rate %+v", logs)
+	}
+}
+
+func TestHandleCluster(t *testing.T) {
+	// The peer answers on the server port of this collector
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/admin/cluster" || r.URL.Query().Get("local") != "true" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(ClusterResponse{Routing: true, Instances: []ClusterInstance{{
+			InstanceID: "collector-b",
+			Self:       true,
+			Rates:      []SignalIngestRate{{Signal: signalTraces, ItemsPerSecond: 300}},
+		}}})
+	}))
+	defer peer.Close()
+	_, port, _ := net.SplitHostPort(peer.Listener.Addr().String())
+
+	cfg := config.DefaultConfig()
+	cfg.Monitoring.InstanceID = "collector-a"
+	cfg.Server.Port, _ = strconv.Atoi(port)
+	c := NewCollector(cfg, nil)
+	s := c.stats
+	s.record(signalTraces, "checkout", 1000, 1000, 0, 0)
+	s.updateRates(s.rateAt.Add(10 * time.Second))
+
+	get := func(target string) ClusterResponse {
+		rec := httptest.NewRecorder()
+		c.handleCluster(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		var resp ClusterResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		return resp
+	}
+
+	// Without routing the collector knows no peers
+	if resp := get("/api/v1/admin/cluster"); resp.Routing || len(resp.Instances) != 1 || resp.Instances[0].Share != 1 || resp.Instances[0].InstanceID != "collector-a" {
+		t.Errorf("without routing %+v", resp)
+	}
+
+	c.trace.router = &traceRouter{self: "self:4317"}
+	c.trace.router.ring.Store(newHashRing([]string{"self:4317", "127.0.0.1:4317", "127.0.0.2:4317"}, defaultVirtualNodes))
+	resp := get("/api/v1/admin/cluster")
+	if !resp.Routing || len(resp.Instances) != 3 || resp.ItemsPerSecond != 400 {
+		t.Fatalf("cluster %+v", resp)
+	}
+	self, up, down := resp.Instances[0], resp.Instances[1], resp.Instances[2]
+	if !self.Self || self.Address != "self:4317" || self.ItemsPerSecond != 100 || self.Share != 0.25 {
+		t.Errorf("self %+v", self)
+	}
+	if up.Self || up.InstanceID != "collector-b" || up.Address != "127.0.0.1:4317" || up.Share != 0.75 {
+		t.Errorf("peer %+v", up)
+	}
+	if down.Error == "" || down.Address != "127.0.0.2:4317" || down.Share != 0 {
+		t.Errorf("unreachable peer %+v", down)
+	}
+
+	if resp := get("/api/v1/admin/cluster?local=true"); len(resp.Instances) != 1 {
+		t.Errorf("local %+v", resp)
+	}
+}
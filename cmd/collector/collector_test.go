@@ -5,9 +5,11 @@ import (
 	"testing"
 	"time"
 
-	"otelservices/internal/clickhouse"
 	"otelservices/internal/config"
+	"otelservices/internal/memstore"
+	"otelservices/internal/monitoring"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
 	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
 	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
@@ -18,15 +20,8 @@ import (
 
 func TestNewCollector(t *testing.T) {
 	cfg := config.DefaultConfig()
-	cfg.ClickHouse.Addresses = []string{"localhost:9000"}
 
-	chClient, err := clickhouse.NewClient(&cfg.ClickHouse)
-	if err != nil {
-		t.Skip("ClickHouse not available for test")
-	}
-	defer chClient.Close()
-
-	collector := NewCollector(cfg, chClient)
+	collector := NewCollector(cfg, nil)
 	if collector == nil {
 		t.Fatal("NewCollector() returned nil")
 	}
@@ -50,13 +45,7 @@ func TestNewCollector(t *testing.T) {
 
 func TestTraceCollectorExport(t *testing.T) {
 	cfg := config.DefaultConfig()
-	chClient, err := clickhouse.NewClient(&cfg.ClickHouse)
-	if err != nil {
-		t.Skip("ClickHouse not available for test")
-	}
-	defer chClient.Close()
-
-	collector := NewCollector(cfg, chClient)
+	collector, store, flush := newMemoryCollector(t, cfg)
 	ctx := context.Background()
 
 	req := &coltracepb.ExportTraceServiceRequest{
@@ -108,30 +97,23 @@ func TestTraceCollectorExport(t *testing.T) {
 		t.Error("Export() returned nil response")
 	}
 
-	// Give time for async processing
-	time.Sleep(100 * time.Millisecond)
+	flush()
 
-	// Check that span was added to channel
-	select {
-	case span := <-collector.trace.spanChan:
-		if span.SpanName != "test-span" {
-			t.Errorf("Expected span name 'test-span', got %s", span.SpanName)
-		}
-	default:
-		// Channel might be empty if attribute extraction failed
-		t.Log("No span in channel (attribute extraction may have issues)")
+	spans := store.Spans(memstore.Filter{ServiceName: "test-service"})
+	if len(spans) != 1 {
+		t.Fatalf("Expected 1 stored span, got %d", len(spans))
+	}
+	if spans[0].SpanName != "test-span" {
+		t.Errorf("Expected span name 'test-span', got %s", spans[0].SpanName)
+	}
+	if spans[0].InstrumentationScopeName != "test-scope" {
+		t.Errorf("Expected scope name 'test-scope', got %s", spans[0].InstrumentationScopeName)
 	}
 }
 
 func TestLogsCollectorExport(t *testing.T) {
 	cfg := config.DefaultConfig()
-	chClient, err := clickhouse.NewClient(&cfg.ClickHouse)
-	if err != nil {
-		t.Skip("ClickHouse not available for test")
-	}
-	defer chClient.Close()
-
-	collector := NewCollector(cfg, chClient)
+	collector, store, flush := newMemoryCollector(t, cfg)
 	ctx := context.Background()
 
 	req := &collogspb.ExportLogsServiceRequest{
@@ -183,31 +165,182 @@ func TestLogsCollectorExport(t *testing.T) {
 		t.Error("Export() returned nil response")
 	}
 
-	// Give time for async processing
-	time.Sleep(100 * time.Millisecond)
+	flush()
+
+	logs := store.Logs(memstore.Filter{ServiceName: "test-service"})
+	if len(logs) != 1 {
+		t.Fatalf("Expected 1 stored log, got %d", len(logs))
+	}
+	if logs[0].Body != "test log message" {
+		t.Errorf("Expected body 'test log message', got %s", logs[0].Body)
+	}
+	if logs[0].SeverityText != "INFO" {
+		t.Errorf("Expected severity 'INFO', got %s", logs[0].SeverityText)
+	}
+}
+
+func TestExtractStringAttribute(t *testing.T) {
+	resource := &resourcepb.Resource{
+		Attributes: []*commonpb.KeyValue{
+			{Key: "service.name", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: "checkout"}}},
+			{Key: "service.instance.id", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: 7}}},
+		},
+	}
 
-	// Check that log was added to channel
-	select {
-	case logRecord := <-collector.logs.logChan:
-		if logRecord.Body != "test log message" {
-			t.Errorf("Expected body 'test log message', got %s", logRecord.Body)
+	if got := extractStringAttribute(resource, "service.name"); got != "checkout" {
+		t.Errorf("Expected 'checkout', got %q", got)
+	}
+	if got := extractStringAttribute(resource, "service.instance.id"); got != "7" {
+		t.Errorf("Expected '7', got %q", got)
+	}
+	if got := extractStringAttribute(resource, "missing"); got != "" {
+		t.Errorf("Expected empty string for missing key, got %q", got)
+	}
+	if got := extractStringAttribute(nil, "service.name"); got != "" {
+		t.Errorf("Expected empty string for nil resource, got %q", got)
+	}
+}
+
+func TestConvertAttributes(t *testing.T) {
+	attrs := []*commonpb.KeyValue{
+		{Key: "str", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: "v"}}},
+		{Key: "int", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: 200}}},
+		{Key: "double", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: 1.5}}},
+		{Key: "bool", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_BoolValue{BoolValue: true}}},
+		{Key: "bytes", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_BytesValue{BytesValue: []byte{0xab, 0xcd}}}},
+		{Key: "array", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_ArrayValue{ArrayValue: &commonpb.ArrayValue{
+			Values: []*commonpb.AnyValue{
+				{Value: &commonpb.AnyValue_StringValue{StringValue: "a"}},
+				{Value: &commonpb.AnyValue_IntValue{IntValue: 1}},
+			},
+		}}}},
+	}
+
+	expected := map[string]string{
+		"str":    "v",
+		"int":    "200",
+		"double": "1.5",
+		"bool":   "true",
+		"bytes":  "abcd",
+		"array":  `["a","1"]`,
+	}
+
+	result := convertAttributes(attrs)
+	for key, want := range expected {
+		if result[key] != want {
+			t.Errorf("Attribute %s: expected %q, got %q", key, want, result[key])
 		}
-	default:
-		t.Log("No log in channel (attribute extraction may have issues)")
 	}
 }
 
-func TestCollectorChannelSizes(t *testing.T) {
-	cfg := config.DefaultConfig()
-	cfg.Performance.QueueSize = 5000
+func TestSpanKindName(t *testing.T) {
+	tests := map[tracepb.Span_SpanKind]string{
+		tracepb.Span_SPAN_KIND_UNSPECIFIED: "internal",
+		tracepb.Span_SPAN_KIND_INTERNAL:    "internal",
+		tracepb.Span_SPAN_KIND_SERVER:      "server",
+		tracepb.Span_SPAN_KIND_CLIENT:      "client",
+		tracepb.Span_SPAN_KIND_PRODUCER:    "producer",
+		tracepb.Span_SPAN_KIND_CONSUMER:    "consumer",
+	}
+	for kind, want := range tests {
+		if got := spanKindName(kind); got != want {
+			t.Errorf("spanKindName(%v) = %q, want %q", kind, got, want)
+		}
+	}
+}
 
-	chClient, err := clickhouse.NewClient(&cfg.ClickHouse)
+func TestStatusCodeName(t *testing.T) {
+	tests := map[tracepb.Status_StatusCode]string{
+		tracepb.Status_STATUS_CODE_UNSET: "unset",
+		tracepb.Status_STATUS_CODE_OK:    "ok",
+		tracepb.Status_STATUS_CODE_ERROR: "error",
+	}
+	for code, want := range tests {
+		if got := statusCodeName(code); got != want {
+			t.Errorf("statusCodeName(%v) = %q, want %q", code, got, want)
+		}
+	}
+}
+
+func TestErrorCounters(t *testing.T) {
+	c := NewCollector(config.DefaultConfig(), nil)
+	resource := &resourcepb.Resource{Attributes: []*commonpb.KeyValue{{
+		Key:   "service.name",
+		Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: "health-check"}},
+	}}}
+
+	spans := []*tracepb.Span{
+		{Name: "ok", Status: &tracepb.Status{Code: tracepb.Status_STATUS_CODE_OK}},
+		{Name: "failed", Status: &tracepb.Status{Code: tracepb.Status_STATUS_CODE_ERROR}},
+		{Name: "unset"},
+	}
+	_, err := c.trace.Export(context.Background(), &coltracepb.ExportTraceServiceRequest{
+		ResourceSpans: []*tracepb.ResourceSpans{{Resource: resource, ScopeSpans: []*tracepb.ScopeSpans{{Spans: spans}}}},
+	})
+	if err != nil {
+		t.Fatalf("trace Export() error = %v", err)
+	}
+	if got := testutil.ToFloat64(monitoring.ErrorSpans.WithLabelValues("health-check")); got != 1 {
+		t.Errorf("error spans = %v, want 1", got)
+	}
+
+	logs := []*logspb.LogRecord{
+		{SeverityNumber: logspb.SeverityNumber_SEVERITY_NUMBER_WARN},
+		{SeverityNumber: logspb.SeverityNumber_SEVERITY_NUMBER_ERROR},
+		{SeverityNumber: logspb.SeverityNumber_SEVERITY_NUMBER_ERROR3},
+		{SeverityText: "critical"},
+	}
+	_, err = c.logs.Export(context.Background(), &collogspb.ExportLogsServiceRequest{
+		ResourceLogs: []*logspb.ResourceLogs{{Resource: resource, ScopeLogs: []*logspb.ScopeLogs{{LogRecords: logs}}}},
+	})
 	if err != nil {
-		t.Skip("ClickHouse not available for test")
+		t.Fatalf("logs Export() error = %v", err)
+	}
+	if got := testutil.ToFloat64(monitoring.ErrorLogs.WithLabelValues("health-check", "ERROR")); got != 2 {
+		t.Errorf("ERROR logs = %v, want 2", got)
+	}
+	if got := testutil.ToFloat64(monitoring.ErrorLogs.WithLabelValues("health-check", "FATAL")); got != 1 {
+		t.Errorf("FATAL logs = %v, want 1", got)
+	}
+}
+
+func TestConvertSpanEventsAndLinks(t *testing.T) {
+	events := convertSpanEvents([]*tracepb.Span_Event{{
+		TimeUnixNano: 1700000000000000000,
+		Name:         "exception",
+		Attributes: []*commonpb.KeyValue{
+			{Key: "exception.type", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: "IOError"}}},
+		},
+	}})
+	if len(events) != 1 || events[0].Name != "exception" || events[0].Attributes["exception.type"] != "IOError" {
+		t.Fatalf("unexpected events: %+v", events)
+	}
+	if !events[0].Timestamp.Equal(time.Unix(0, 1700000000000000000)) {
+		t.Errorf("unexpected event timestamp: %v", events[0].Timestamp)
+	}
+
+	links := convertSpanLinks([]*tracepb.Span_Link{{
+		TraceId:    []byte{0xab, 0xcd},
+		SpanId:     []byte{0x01, 0x02},
+		TraceState: "vendor=1",
+	}})
+	if len(links) != 1 || links[0].TraceID != "abcd" || links[0].SpanID != "0102" || links[0].TraceState != "vendor=1" {
+		t.Fatalf("unexpected links: %+v", links)
 	}
-	defer chClient.Close()
+	if links[0].Attributes == nil {
+		t.Error("expected an empty attribute map, not nil")
+	}
+
+	if got := convertSpanEvents(nil); got == nil || len(got) != 0 {
+		t.Errorf("expected empty non-nil events, got %#v", got)
+	}
+}
+
+func TestCollectorChannelSizes(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Performance.QueueSize = 5000
 
-	collector := NewCollector(cfg, chClient)
+	collector := NewCollector(cfg, nil)
 
 	if cap(collector.trace.spanChan) != cfg.Performance.QueueSize {
 		t.Errorf("Expected span channel capacity %d, got %d", cfg.Performance.QueueSize, cap(collector.trace.spanChan))
@@ -227,13 +360,7 @@ func BenchmarkTraceExport(b *testing.B) {
 	// Use larger queue for benchmarks to prevent channel overflow
 	cfg.Performance.QueueSize = 1000000
 
-	chClient, err := clickhouse.NewClient(&cfg.ClickHouse)
-	if err != nil {
-		b.Skip("ClickHouse not available for benchmark")
-	}
-	defer chClient.Close()
-
-	collector := NewCollector(cfg, chClient)
+	collector, _, flush := newMemoryCollector(b, cfg)
 	ctx := context.Background()
 
 	req := &coltracepb.ExportTraceServiceRequest{
@@ -268,30 +395,67 @@ func BenchmarkTraceExport(b *testing.B) {
 		},
 	}
 
-	// Start a goroutine to drain the channel to prevent overflow
-	done := make(chan bool)
-	go func() {
-		for {
-			select {
-			case <-collector.trace.spanChan:
-				// Drain spans
-			case <-done:
-				return
-			}
-		}
-	}()
-
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		collector.trace.Export(ctx, req)
 	}
 	b.StopTimer()
 
-	// Signal drainer to stop
-	close(done)
+	flush()
+}
 
-	// Drain remaining
-	for len(collector.trace.spanChan) > 0 {
-		<-collector.trace.spanChan
+// TestBenchmarkRunIDIsStored checks that the spans and log records of the
+// load test keep the namespace and run ID its -verify query filters on,
+// which benchmarks/verify_test.go runs against rows of this shape. The run
+// ID is read from the item attributes, as OTLP resource attributes are not
+// stored.
+func TestBenchmarkRunIDIsStored(t *testing.T) {
+	const runID = "verify-test-run"
+	str := func(key, value string) *commonpb.KeyValue {
+		return &commonpb.KeyValue{Key: key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}}}
+	}
+	// Built like the requests of benchmarks/generators.go
+	resource := &resourcepb.Resource{Attributes: []*commonpb.KeyValue{
+		str("service.name", "benchmark-service-0"),
+		str("service.namespace", "benchmark"),
+		str("benchmark.run_id", runID),
+	}}
+	now := time.Now()
+	traces := &coltracepb.ExportTraceServiceRequest{ResourceSpans: []*tracepb.ResourceSpans{{
+		Resource: resource,
+		ScopeSpans: []*tracepb.ScopeSpans{{Spans: []*tracepb.Span{{
+			TraceId:           []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16},
+			SpanId:            []byte{1, 2, 3, 4, 5, 6, 7, 8},
+			Name:              "operation-1",
+			StartTimeUnixNano: uint64(now.Add(-time.Millisecond).UnixNano()),
+			EndTimeUnixNano:   uint64(now.UnixNano()),
+			Attributes:        []*commonpb.KeyValue{str("benchmark.run_id", runID), str("http.method", "GET")},
+		}}}},
+	}}}
+	logs := &collogspb.ExportLogsServiceRequest{ResourceLogs: []*logspb.ResourceLogs{{
+		Resource: resource,
+		ScopeLogs: []*logspb.ScopeLogs{{LogRecords: []*logspb.LogRecord{{
+			TimeUnixNano:   uint64(now.UnixNano()),
+			SeverityNumber: logspb.SeverityNumber_SEVERITY_NUMBER_INFO,
+			Body:           &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: "order 1 processed"}},
+			Attributes:     []*commonpb.KeyValue{str("benchmark.run_id", runID)},
+		}}}},
+	}}}
+
+	c := NewCollector(config.DefaultConfig(), nil)
+	ctx := context.Background()
+	if _, err := c.trace.Export(ctx, traces); err != nil {
+		t.Fatalf("trace Export() error = %v", err)
+	}
+	if _, err := c.logs.Export(ctx, logs); err != nil {
+		t.Fatalf("logs Export() error = %v", err)
+	}
+	span := <-c.trace.spanChan
+	logRecord := <-c.logs.logChan
+	if span.ServiceNamespace != "benchmark" || span.Attributes["benchmark.run_id"] != runID {
+		t.Errorf("span namespace %q, run ID %q; want benchmark, %s", span.ServiceNamespace, span.Attributes["benchmark.run_id"], runID)
+	}
+	if logRecord.ServiceNamespace != "benchmark" || logRecord.Attributes["benchmark.run_id"] != runID {
+		t.Errorf("log namespace %q, run ID %q; want benchmark, %s", logRecord.ServiceNamespace, logRecord.Attributes["benchmark.run_id"], runID)
 	}
 }
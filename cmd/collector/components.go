@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"otelservices/internal/config"
+	"otelservices/internal/pipeline"
+)
+
+// components returns the receivers, processors and exporters the
+// collector's pipelines are built from. New components are registered here.
+func (c *Collector) components() *pipeline.Registry {
+	r := &pipeline.Registry{}
+	r.RegisterReceiver("otlp", c.newOTLPReceiver)
+	r.RegisterReceiver("kafka", func([]string) (pipeline.Receiver, error) {
+		return &kafkaReceiver{cfg: &c.config.Kafka}, nil
+	})
+	r.RegisterExporter(storageClickHouse, func() (pipeline.Exporter, error) {
+		if c.chClient == nil {
+			return nil, errors.New("not connected to ClickHouse")
+		}
+		return c.storage, nil
+	})
+	r.RegisterExporter("kafka", c.newKafkaWriter)
+	r.RegisterExporter(storageMemory, func() (pipeline.Exporter, error) {
+		if c.memory == nil {
+			return nil, errors.New("no in-memory storage; run with -storage=memory")
+		}
+		return &memoryWriter{store: c.memory}, nil
+	})
+	return r
+}
+
+// pipelineSpecs describes the pipelines for cfg: those of its pipelines
+// section or, without one, pipelines for every signal derived from
+// kafka.mode. OTLP data is then published to Kafka when the collector is a
+// producer and written to storage, ClickHouse or memory, otherwise; a
+// consumer writes what it reads from Kafka to storage.
+func pipelineSpecs(cfg *config.Config, storage string) []pipeline.Spec {
+	if len(cfg.Pipelines) > 0 {
+		specs := make([]pipeline.Spec, 0, len(cfg.Pipelines))
+		for _, name := range cfg.Pipelines.Names() {
+			p := cfg.Pipelines[name]
+			specs = append(specs, pipeline.Spec{
+				Name:       name,
+				Signal:     config.PipelineSignal(name),
+				Receivers:  p.Receivers,
+				Processors: p.Processors,
+				Exporters:  p.Exporters,
+			})
+		}
+		return specs
+	}
+
+	otlp := pipeline.Spec{Name: "otlp", Receivers: []string{"otlp"}, Exporters: []string{storage}}
+	if cfg.Kafka.Produces() {
+		otlp.Exporters = []string{"kafka"}
+	}
+	specs := []pipeline.Spec{otlp}
+	if cfg.Kafka.Consumes() {
+		specs = append(specs, pipeline.Spec{Name: "kafka", Receivers: []string{"kafka"}, Exporters: []string{storage}})
+	}
+	return specs
+}
+
+// exportsTo reports whether one of the pipelines has the named exporter
+func exportsTo(specs []pipeline.Spec, exporter string) bool {
+	for _, spec := range specs {
+		if slices.Contains(spec.Exporters, exporter) {
+			return true
+		}
+	}
+	return false
+}
+
+// buildPipelines builds the collector's pipelines and points the batch
+// processors at those receiving OTLP
+func (c *Collector) buildPipelines(specs []pipeline.Spec) error {
+	pipelines, err := c.components().Build(specs)
+	if err != nil {
+		return err
+	}
+	c.pipelines = pipelines
+	var ingest pipeline.Fanout
+	for _, p := range pipelines {
+		if slices.Contains(c.ingest, p.Name) {
+			ingest = append(ingest, p)
+		}
+	}
+	switch len(ingest) {
+	case 0:
+		return fmt.Errorf("no pipeline has the otlp receiver")
+	case 1:
+		c.writer = ingest[0]
+	default:
+		c.writer = ingest
+	}
+	return nil
+}
+
+// startPipelines starts the receivers of every pipeline
+func (c *Collector) startPipelines(ctx context.Context) error {
+	for i, p := range c.pipelines {
+		if err := p.Start(ctx); err != nil {
+			c.shutdownPipelines(ctx, c.pipelines[:i])
+			return err
+		}
+	}
+	return nil
+}
+
+// shutdownPipelines stops the receivers of the given pipelines, so nothing
+// new is accepted while the batch processors drain
+func (c *Collector) shutdownPipelines(ctx context.Context, pipelines []*pipeline.Pipeline) {
+	for _, p := range pipelines {
+		if err := p.Shutdown(ctx); err != nil {
+			logger.Error("Pipeline shutdown error", "pipeline", p.Name, "error", err)
+		}
+	}
+}
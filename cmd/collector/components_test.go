@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"otelservices/internal/config"
+	"otelservices/internal/memstore"
+	"otelservices/internal/models"
+	"otelservices/internal/pipeline"
+)
+
+func TestPipelineSpecs(t *testing.T) {
+	exporters := func(specs []pipeline.Spec) map[string][]string {
+		out := make(map[string][]string)
+		for _, spec := range specs {
+			out[spec.Name] = spec.Exporters
+		}
+		return out
+	}
+	tests := []struct {
+		kafka config.KafkaConfig
+		want  map[string][]string
+	}{
+		{config.KafkaConfig{}, map[string][]string{"otlp": {"clickhouse"}}},
+		{config.KafkaConfig{Enabled: true, Mode: "producer"}, map[string][]string{"otlp": {"kafka"}}},
+		{config.KafkaConfig{Enabled: true, Mode: "consumer"}, map[string][]string{"otlp": {"clickhouse"}, "kafka": {"clickhouse"}}},
+	}
+	for _, tt := range tests {
+		cfg := config.DefaultConfig()
+		cfg.Kafka = tt.kafka
+		if got := exporters(pipelineSpecs(cfg, storageClickHouse)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("mode %q: pipelines %v, want %v", tt.kafka.Mode, got, tt.want)
+		}
+	}
+}
+
+func TestBuildPipelines(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Kafka = config.KafkaConfig{Enabled: true, Mode: "producer", Brokers: []string{"localhost:9092"}}
+	c := NewCollector(cfg, nil)
+	if err := c.buildPipelines(pipelineSpecs(cfg, storageClickHouse)); err != nil {
+		t.Fatal(err)
+	}
+	if c.writer != c.pipelines[0] || c.producer == nil {
+		t.Errorf("batch processors not writing to the otlp pipeline: %v", c.writer)
+	}
+
+	tests := []struct {
+		name  string
+		specs []pipeline.Spec
+		want  string
+	}{
+		{"no memory", []pipeline.Spec{{Name: "otlp", Receivers: []string{"otlp"}, Exporters: []string{"memory"}}}, "run with -storage=memory"},
+		{"no clickhouse", []pipeline.Spec{{Name: "otlp", Receivers: []string{"otlp"}, Exporters: []string{"clickhouse"}}}, "not connected to ClickHouse"},
+		{"no otlp", []pipeline.Spec{{Name: "kafka", Receivers: []string{"kafka"}, Exporters: []string{"kafka"}}}, "no pipeline has the otlp receiver"},
+	}
+	for _, tt := range tests {
+		c := NewCollector(cfg, nil)
+		if err := c.buildPipelines(tt.specs); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+// Configured pipelines each carry their signal; the batch processors write
+// to all pipelines with the otlp receiver
+func TestConfiguredPipelines(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Pipelines = config.PipelinesConfig{
+		"traces":         {Receivers: []string{"otlp"}, Exporters: []string{"memory"}},
+		"traces/archive": {Receivers: []string{"otlp"}, Exporters: []string{"memory"}},
+		"logs":           {Receivers: []string{"otlp"}, Exporters: []string{"memory"}},
+	}
+	specs := pipelineSpecs(cfg, storageClickHouse)
+	if len(specs) != 3 || specs[0].Name != "logs" || specs[0].Signal != "logs" || specs[2].Signal != "traces" {
+		t.Fatalf("specs %+v", specs)
+	}
+	if exportsTo(specs, storageClickHouse) || !exportsTo(specs, storageMemory) {
+		t.Error("exportsTo does not match the configured exporters")
+	}
+
+	c := NewCollector(cfg, nil)
+	c.memory = memstore.New(0)
+	if err := c.buildPipelines(specs); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	batch := pipeline.Batch{
+		Spans:   []models.Span{{SpanName: "checkout"}},
+		Metrics: []models.Metric{{MetricName: "requests"}},
+		Logs:    []models.LogRecord{{Body: "paid"}},
+	}
+	if err := c.writer.Consume(ctx, batch); err != nil {
+		t.Fatal(err)
+	}
+	// Both trace pipelines store the span; no pipeline carries metrics
+	if spans, metrics, logs := c.memory.Spans(memstore.Filter{}), c.memory.Metrics(memstore.Filter{}), c.memory.Logs(memstore.Filter{}); len(spans) != 2 || len(metrics) != 0 || len(logs) != 1 {
+		t.Errorf("stored %d spans, %d metrics, %d logs", len(spans), len(metrics), len(logs))
+	}
+}
+
+// Config validation knows the same components the collector registers
+func TestComponentNames(t *testing.T) {
+	r := NewCollector(config.DefaultConfig(), nil).components()
+	if !reflect.DeepEqual(r.Receivers(), config.PipelineReceivers) ||
+		!reflect.DeepEqual(r.Processors(), config.PipelineProcessors) ||
+		!reflect.DeepEqual(r.Exporters(), config.PipelineExporters) {
+		t.Errorf("registered %v, %v, %v", r.Receivers(), r.Processors(), r.Exporters())
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"otelservices/internal/diskqueue"
+	"otelservices/internal/monitoring"
+	"otelservices/internal/pipeline"
+)
+
+// queuedWriter persists batches to per-signal disk queues. Replay loops read
+// them back and pass them to the next consumer, committing a batch only
+// after it was written, so batches survive a crash or a storage outage.
+type queuedWriter struct {
+	queues map[string]*diskqueue.Queue
+	next   pipeline.Consumer
+}
+
+func (q *queuedWriter) Consume(ctx context.Context, batch pipeline.Batch) error {
+	var errs []error
+	if len(batch.Spans) > 0 {
+		errs = append(errs, appendBatch(q.queues[signalTraces], signalTraces, batch.Spans))
+	}
+	if len(batch.Metrics) > 0 {
+		errs = append(errs, appendBatch(q.queues[signalMetrics], signalMetrics, batch.Metrics))
+	}
+	if len(batch.Logs) > 0 {
+		errs = append(errs, appendBatch(q.queues[signalLogs], signalLogs, batch.Logs))
+	}
+	return errors.Join(errs...)
+}
+
+// appendBatch gob-encodes a batch and appends it to the signal's queue
+func appendBatch[T any](q *diskqueue.Queue, signal string, items []T) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(items); err != nil {
+		return fmt.Errorf("failed to encode %s batch: %w", signal, err)
+	}
+	if err := q.Append(buf.Bytes()); err != nil {
+		monitoring.DiskQueueBatches.WithLabelValues(signal, "rejected").Inc()
+		return fmt.Errorf("failed to queue %s batch: %w", signal, err)
+	}
+	monitoring.DiskQueueBatches.WithLabelValues(signal, "queued").Inc()
+	monitoring.DiskQueueBytes.WithLabelValues(signal).Set(float64(q.Size()))
+	return nil
+}
+
+// writeQueued decodes a queued batch and hands it to next
+func writeQueued(ctx context.Context, next pipeline.Consumer, signal string, data []byte) error {
+	dec := gob.NewDecoder(bytes.NewReader(data))
+	var batch pipeline.Batch
+	var err error
+	switch signal {
+	case signalTraces:
+		err = dec.Decode(&batch.Spans)
+	case signalMetrics:
+		err = dec.Decode(&batch.Metrics)
+	case signalLogs:
+		err = dec.Decode(&batch.Logs)
+	default:
+		err = fmt.Errorf("unknown signal %q", signal)
+	}
+	if err != nil {
+		return &permanentError{err}
+	}
+	return next.Consume(ctx, batch)
+}
+
+// initDiskQueue opens the disk queues and puts them in front of the pipeline
+// the batch processors write to. Batches left over from a previous run are replayed once the batch
+// processors start.
+func (c *Collector) initDiskQueue() error {
+	cfg := c.config.DiskQueue
+	if !cfg.Enabled {
+		return nil
+	}
+
+	queues := make(map[string]*diskqueue.Queue)
+	for _, signal := range []string{signalTraces, signalMetrics, signalLogs} {
+		q, err := diskqueue.Open(filepath.Join(cfg.Directory, signal), diskqueue.Options{
+			SegmentBytes: int64(cfg.SegmentSizeMiB) << 20,
+			MaxBytes:     int64(cfg.MaxSizeMiB) << 20,
+		})
+		if err != nil {
+			for _, opened := range queues {
+				opened.Close()
+			}
+			return fmt.Errorf("failed to open %s disk queue: %w", signal, err)
+		}
+		if size := q.Size(); size > 0 {
+			logger.Info("Replaying disk queue", "signal", signal, "bytes", size, "directory", cfg.Directory)
+		}
+		monitoring.DiskQueueBytes.WithLabelValues(signal).Set(float64(q.Size()))
+		queues[signal] = q
+	}
+
+	c.queue = &queuedWriter{queues: queues, next: c.writer}
+	c.writer = c.queue
+	return nil
+}
+
+// replayQueue writes batches from one signal's disk queue until ctx is
+// cancelled. Failed writes are retried with backoff; whatever is left is
+// replayed on the next start.
+func (c *Collector) replayQueue(ctx context.Context, signal string) {
+	defer c.wg.Done()
+	q := c.queue.queues[signal]
+	perf := c.config.Performance
+
+	for {
+		data, pos, err := q.Next(ctx)
+		if errors.Is(err, diskqueue.ErrCorrupt) {
+			logger.Warn("Skipping corrupt disk queue data", "signal", signal, "error", err)
+			monitoring.DiskQueueBatches.WithLabelValues(signal, "corrupt").Inc()
+			continue
+		}
+		if err != nil {
+			if ctx.Err() == nil {
+				logger.Error("Error reading disk queue", "signal", signal, "error", err)
+			}
+			return
+		}
+
+		backoff := perf.RetryInitialInterval
+		if backoff <= 0 {
+			backoff = time.Second
+		}
+		for {
+			err := writeQueued(ctx, c.queue.next, signal, data)
+			if err == nil {
+				monitoring.DiskQueueBatches.WithLabelValues(signal, "written").Inc()
+				break
+			}
+			var perm *permanentError
+			if errors.As(err, &perm) {
+				logger.Warn("Dropping undecodable batch from disk queue", "signal", signal, "error", err)
+				monitoring.DiskQueueBatches.WithLabelValues(signal, "corrupt").Inc()
+				break
+			}
+			logger.Error("Error writing queued batch", "signal", signal, "retry_in", backoff, "error", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+			if perf.RetryMaxInterval > 0 && backoff > perf.RetryMaxInterval {
+				backoff = perf.RetryMaxInterval
+			}
+		}
+
+		if err := q.Commit(pos); err != nil {
+			logger.Error("Error committing disk queue position", "signal", signal, "error", err)
+		}
+		monitoring.DiskQueueBytes.WithLabelValues(signal).Set(float64(q.Size()))
+	}
+}
+
+// closeDiskQueue closes the disk queues once the replay loops have stopped
+func (c *Collector) closeDiskQueue() {
+	if c.queue == nil {
+		return
+	}
+	for signal, q := range c.queue.queues {
+		if size := q.Size(); size > 0 {
+			logger.Info("Data left in the disk queue for the next start", "signal", signal, "bytes", size)
+		}
+		if err := q.Close(); err != nil {
+			logger.Error("Error closing disk queue", "signal", signal, "error", err)
+		}
+	}
+}
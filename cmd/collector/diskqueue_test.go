@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"otelservices/internal/config"
+	"otelservices/internal/models"
+	"otelservices/internal/pipeline"
+)
+
+// flakyWriter fails the first failures writes, then records like recordingWriter
+type flakyWriter struct {
+	recordingWriter
+	mu       sync.Mutex
+	failures int
+}
+
+func (w *flakyWriter) Consume(ctx context.Context, batch pipeline.Batch) error {
+	w.mu.Lock()
+	if w.failures > 0 {
+		w.failures--
+		w.mu.Unlock()
+		return errors.New("clickhouse unavailable")
+	}
+	w.mu.Unlock()
+	return w.recordingWriter.Consume(ctx, batch)
+}
+
+func newQueuedCollector(t *testing.T, dir string, next pipeline.Consumer) *Collector {
+	t.Helper()
+	cfg := config.DefaultConfig()
+	cfg.DiskQueue = config.DiskQueueConfig{Enabled: true, Directory: dir}
+	cfg.Performance.RetryInitialInterval = 10 * time.Millisecond
+	c := NewCollector(cfg, nil)
+	c.writer = next
+	if err := c.initDiskQueue(); err != nil {
+		t.Fatalf("initDiskQueue failed: %v", err)
+	}
+	return c
+}
+
+func waitForSpans(t *testing.T, w *recordingWriter, want int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		w.mu.Lock()
+		got := w.spans
+		w.mu.Unlock()
+		if got >= want {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %d spans", want)
+}
+
+func TestDiskQueueRetriesUntilWritten(t *testing.T) {
+	next := &flakyWriter{failures: 2}
+	c := newQueuedCollector(t, t.TempDir(), next)
+	defer c.closeDiskQueue()
+
+	if err := c.writer.Consume(context.Background(), pipeline.Batch{Spans: []models.Span{{SpanID: "a"}, {SpanID: "b"}}}); err != nil {
+		t.Fatalf("Consume failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c.wg.Add(1)
+	go c.replayQueue(ctx, signalTraces)
+	waitForSpans(t, &next.recordingWriter, 2)
+	cancel()
+	c.wg.Wait()
+
+	if size := c.queue.queues[signalTraces].Size(); size != 0 {
+		t.Errorf("queue size = %d after replay, want 0", size)
+	}
+}
+
+func TestDiskQueueReplaysAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+
+	// First run queues a batch but stops before it can be written
+	c := newQueuedCollector(t, dir, &flakyWriter{failures: 1 << 30})
+	if err := c.writer.Consume(context.Background(), pipeline.Batch{Spans: []models.Span{{SpanID: "a"}}}); err != nil {
+		t.Fatalf("Consume failed: %v", err)
+	}
+	c.closeDiskQueue()
+
+	next := &recordingWriter{}
+	c = newQueuedCollector(t, dir, next)
+	defer c.closeDiskQueue()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c.wg.Add(1)
+	go c.replayQueue(ctx, signalTraces)
+	waitForSpans(t, next, 1)
+	cancel()
+	c.wg.Wait()
+}
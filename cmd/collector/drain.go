@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// errShuttingDown is returned to clients whose Export arrives during drain
+var errShuttingDown = status.Error(codes.Unavailable, "collector is shutting down")
+
+// intake guards the signal channels so they can be closed while receivers
+// may still be calling Export. Export holds a read lock while it sends, so
+// closing waits for in-flight sends and no send can hit a closed channel.
+type intake struct {
+	mu     sync.RWMutex
+	closed bool
+}
+
+// acquire reports whether new data may be accepted. On true the caller must
+// call release when it is done sending.
+func (i *intake) acquire() bool {
+	i.mu.RLock()
+	if i.closed {
+		i.mu.RUnlock()
+		return false
+	}
+	return true
+}
+
+func (i *intake) release() {
+	i.mu.RUnlock()
+}
+
+// close stops intake and runs fn, once
+func (i *intake) close(fn func()) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if !i.closed {
+		i.closed = true
+		fn()
+	}
+}
+
+// drain stops accepting data, lets the batch workers empty the channels into
+// final batches and waits for them up to timeout. cancel is then called to
+// stop the remaining background work, which is waited for as well.
+func (c *Collector) drain(timeout time.Duration, cancel context.CancelFunc) {
+	start := time.Now()
+	c.intake.close(func() {
+		close(c.trace.spanChan)
+		close(c.metrics.metricChan)
+		close(c.logs.logChan)
+	})
+
+	done := make(chan struct{})
+	go func() {
+		c.batchWG.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		logger.Info("Drained collector queues", "duration", time.Since(start))
+	case <-time.After(timeout):
+		logger.Warn("Drain timed out", "timeout", timeout,
+			"queued_spans", len(c.trace.spanChan), "queued_metrics", len(c.metrics.metricChan), "queued_logs", len(c.logs.logChan))
+	}
+
+	cancel()
+	c.batchWG.Wait()
+	c.wg.Wait()
+}
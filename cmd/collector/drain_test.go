@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"otelservices/internal/config"
+	"otelservices/internal/models"
+	"otelservices/internal/pipeline"
+
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+)
+
+// recordingWriter collects the batches written by the batch processors
+type recordingWriter struct {
+	mu    sync.Mutex
+	spans int
+	logs  int
+}
+
+func (w *recordingWriter) Consume(ctx context.Context, batch pipeline.Batch) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.spans += len(batch.Spans)
+	w.logs += len(batch.Logs)
+	return nil
+}
+
+func TestIntake(t *testing.T) {
+	in := &intake{}
+	if !in.acquire() {
+		t.Fatal("expected acquire to succeed before close")
+	}
+	in.release()
+
+	calls := 0
+	in.close(func() { calls++ })
+	in.close(func() { calls++ })
+	if calls != 1 {
+		t.Errorf("close ran fn %d times, want 1", calls)
+	}
+	if in.acquire() {
+		t.Error("expected acquire to fail after close")
+	}
+}
+
+func TestDrainFlushesQueuedData(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Performance.WorkerCount = 2
+	cfg.Performance.BatchTimeout = time.Hour
+
+	c := NewCollector(cfg, nil)
+	writer := &recordingWriter{}
+	c.writer = writer
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c.startBatchProcessor(ctx)
+
+	for i := 0; i < 250; i++ {
+		c.trace.spanChan <- models.Span{SpanName: "op"}
+	}
+	for i := 0; i < 100; i++ {
+		c.logs.logChan <- models.LogRecord{Body: "line"}
+	}
+
+	c.drain(5*time.Second, cancel)
+
+	if writer.spans != 250 {
+		t.Errorf("flushed %d spans, want 250", writer.spans)
+	}
+	if writer.logs != 100 {
+		t.Errorf("flushed %d logs, want 100", writer.logs)
+	}
+	if ctx.Err() == nil {
+		t.Error("expected drain to cancel the context")
+	}
+}
+
+func TestExportRejectedAfterDrain(t *testing.T) {
+	cfg := config.DefaultConfig()
+	c := NewCollector(cfg, nil)
+	c.writer = &recordingWriter{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c.startBatchProcessor(ctx)
+	c.drain(time.Second, cancel)
+
+	_, err := c.trace.Export(context.Background(), &coltracepb.ExportTraceServiceRequest{})
+	if err != errShuttingDown {
+		t.Errorf("Export() error = %v, want errShuttingDown", err)
+	}
+}
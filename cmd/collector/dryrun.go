@@ -0,0 +1,378 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"otelservices/internal/config"
+	"otelservices/internal/models"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+)
+
+// Outcomes of a dry run stage and of a whole span or log
+const (
+	dryRunObserved = "observed" // counted without changing the item
+	dryRunKept     = "kept"
+	dryRunMutated  = "mutated"
+	dryRunDropped  = "dropped"
+)
+
+// DryRunStage is what one pipeline stage did to a span or log
+type DryRunStage struct {
+	Stage  string `json:"stage"`
+	Result string `json:"result"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// DryRunItem is the path of one span or log through the pipeline. Outcome
+// is dropped when a stage dropped it, mutated when a stage changed it and
+// kept otherwise.
+type DryRunItem struct {
+	Service string        `json:"service"`
+	TraceID string        `json:"trace_id,omitempty"`
+	SpanID  string        `json:"span_id,omitempty"`
+	Name    string        `json:"name"` // span name or log body
+	Outcome string        `json:"outcome"`
+	Stages  []DryRunStage `json:"stages"`
+}
+
+// DryRunReport is printed by the -dry-run mode. Exporters receive the
+// payload unchanged, before any stage runs. DerivedMetrics counts the series
+// span and log metrics would write, per metric name.
+type DryRunReport struct {
+	Signal         string         `json:"signal"`
+	Received       int            `json:"received"`
+	Kept           int            `json:"kept"`
+	Mutated        int            `json:"mutated"`
+	Dropped        int            `json:"dropped"`
+	Exporters      []string       `json:"exporters,omitempty"`
+	DerivedMetrics map[string]int `json:"derived_metrics,omitempty"`
+	Items          []DryRunItem   `json:"items"`
+}
+
+func (r *DryRunReport) add(item DryRunItem) {
+	item.Outcome = dryRunKept
+	for _, s := range item.Stages {
+		if s.Result == dryRunDropped {
+			item.Outcome = dryRunDropped
+			break
+		}
+		if s.Result == dryRunMutated {
+			item.Outcome = dryRunMutated
+		}
+	}
+	r.Received++
+	switch item.Outcome {
+	case dryRunDropped:
+		r.Dropped++
+	case dryRunMutated:
+		r.Mutated++
+	default:
+		r.Kept++
+	}
+	r.Items = append(r.Items, item)
+}
+
+// runDryRun runs the payload in path through the pipeline built from cfg
+// and writes the report to w as JSON. Nothing is stored, exported or
+// recorded in the sampling decision cache.
+func runDryRun(cfg *config.Config, path, signal string, w io.Writer) error {
+	msg, signal, err := loadDryRunPayload(path, signal)
+	if err != nil {
+		return err
+	}
+	c := NewCollector(cfg, nil)
+	var report *DryRunReport
+	switch req := msg.(type) {
+	case *coltracepb.ExportTraceServiceRequest:
+		report = c.dryRunTraces(req)
+	case *collogspb.ExportLogsServiceRequest:
+		report = c.dryRunLogs(req)
+	}
+	for _, e := range cfg.Exporters {
+		if e.ExportsSignal(signal) {
+			report.Exporters = append(report.Exporters, e.Name)
+		}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
+
+func (c *Collector) dryRunTraces(req *coltracepb.ExportTraceServiceRequest) *DryRunReport {
+	tc := c.trace
+	report := &DryRunReport{Signal: signalTraces}
+	for _, rs := range req.ResourceSpans {
+		serviceName := extractStringAttribute(rs.Resource, "service.name")
+		serviceNamespace := extractStringAttribute(rs.Resource, "service.namespace")
+		for _, ss := range rs.ScopeSpans {
+			for _, span := range ss.Spans {
+				ids := spanContext(serviceName, span)
+				spanName := tc.spanNames.normalize(serviceName, span.Name)
+				item := DryRunItem{
+					Service: serviceName,
+					TraceID: fmt.Sprintf("%x", ids.traceID),
+					SpanID:  fmt.Sprintf("%x", ids.spanID),
+					Name:    spanName,
+				}
+				if len(ids.repaired) > 0 {
+					item.Stages = append(item.Stages, dryRunTraceContext(ids))
+				}
+				if tc.spanNames != nil {
+					stage := DryRunStage{Stage: "span_name", Result: dryRunKept}
+					if spanName != span.Name {
+						stage.Result = dryRunMutated
+						stage.Detail = "renamed from " + span.Name
+					}
+					item.Stages = append(item.Stages, stage)
+				}
+				if tc.spanMetrics != nil {
+					tc.spanMetrics.observe(serviceName, serviceNamespace, spanName, span)
+					item.Stages = append(item.Stages, DryRunStage{Stage: "span_metrics", Result: dryRunObserved})
+				}
+				if s := tc.sampler.get(); s != nil {
+					stage := dryRunSampling(s.decideSpan, serviceName, ids.traceID)
+					item.Stages = append(item.Stages, stage)
+					if stage.Result == dryRunDropped {
+						report.add(item)
+						continue
+					}
+				}
+				if tc.tiering != nil {
+					item.Stages = append(item.Stages, dryRunTiering(tc.tiering, convertAttributes(span.Attributes)))
+				}
+				report.add(item)
+			}
+		}
+	}
+	if tc.spanMetrics != nil {
+		report.DerivedMetrics = countDerivedMetrics(tc.spanMetrics.drain(time.Now()))
+	}
+	return report
+}
+
+func (c *Collector) dryRunLogs(req *collogspb.ExportLogsServiceRequest) *DryRunReport {
+	lc := c.logs
+	report := &DryRunReport{Signal: signalLogs}
+	for _, rl := range req.ResourceLogs {
+		resource := lc.newLogResource(context.Background(), rl.Resource)
+		for _, sl := range rl.ScopeLogs {
+			scope := newInstrumentationScope(sl.Scope)
+			for _, logRecord := range sl.LogRecords {
+				ids := logContext(resource.serviceName, logRecord)
+				modelLog := resource.convert(scope, logRecord, ids)
+				item := DryRunItem{
+					Service: modelLog.ServiceName,
+					TraceID: modelLog.TraceID,
+					SpanID:  modelLog.SpanID,
+					Name:    modelLog.Body,
+				}
+				if len(ids.repaired) > 0 {
+					item.Stages = append(item.Stages, dryRunTraceContext(ids))
+				}
+				if lc.flatten != nil {
+					item.Stages = append(item.Stages, dryRunFlatten(logRecord.Attributes))
+				}
+				if lc.logMetrics != nil {
+					var matched []string
+					for i := range lc.logMetrics.rules {
+						if _, ok := lc.logMetrics.rules[i].match(modelLog); ok {
+							matched = append(matched, lc.logMetrics.rules[i].Name)
+						}
+					}
+					lc.logMetrics.observe(modelLog)
+					stage := DryRunStage{Stage: "log_metrics", Result: dryRunObserved, Detail: "no rule matches"}
+					if len(matched) > 0 {
+						stage.Detail = "matches " + strings.Join(matched, ", ")
+					}
+					item.Stages = append(item.Stages, stage)
+				}
+				if s := lc.sampler.get(); s != nil {
+					decide := func(serviceName string, traceID []byte) SamplingDecision {
+						d, _ := s.decideLog(serviceName, traceID)
+						return d
+					}
+					item.Stages = append(item.Stages, dryRunSampling(decide, modelLog.ServiceName, ids.traceID))
+				}
+				report.add(item)
+			}
+		}
+	}
+	if lc.logMetrics != nil {
+		report.DerivedMetrics = countDerivedMetrics(lc.logMetrics.drain(time.Now()))
+	}
+	return report
+}
+
+func dryRunTraceContext(ids traceContext) DryRunStage {
+	return DryRunStage{Stage: "trace_context", Result: dryRunMutated, Detail: "repaired " + strings.Join(ids.repaired, ", ")}
+}
+
+// dryRunSampling explains a sampling decision without recording it.
+// Items without a valid trace ID are always kept, as in KeepSpan and KeepLog.
+func dryRunSampling(decide func(string, []byte) SamplingDecision, serviceName string, traceID []byte) DryRunStage {
+	stage := DryRunStage{Stage: "sampling", Result: dryRunKept}
+	if len(traceID) != 16 {
+		stage.Detail = "no valid trace ID"
+		return stage
+	}
+	d := decide(serviceName, traceID)
+	if !d.Kept {
+		stage.Result = dryRunDropped
+	}
+	stage.Detail = d.Reason
+	if d.Policy != "" {
+		stage.Detail = fmt.Sprintf("policy %s: %s", d.Policy, d.Reason)
+	}
+	return stage
+}
+
+// dryRunTiering reports which attributes would move to attributes_cold
+func dryRunTiering(t *attributeTiering, attrs map[string]string) DryRunStage {
+	_, cold := t.split(attrs)
+	if len(cold) == 0 {
+		return DryRunStage{Stage: "attributes", Result: dryRunKept}
+	}
+	keys := make([]string, 0, len(cold))
+	for k := range cold {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return DryRunStage{
+		Stage:  "attributes",
+		Result: dryRunMutated,
+		Detail: fmt.Sprintf("%d of %d attributes moved to attributes_cold: %s", len(cold), len(attrs), strings.Join(keys, ", ")),
+	}
+}
+
+func dryRunFlatten(attrs []*commonpb.KeyValue) DryRunStage {
+	var nested []string
+	for _, attr := range attrs {
+		switch attr.GetValue().GetValue().(type) {
+		case *commonpb.AnyValue_KvlistValue, *commonpb.AnyValue_ArrayValue:
+			nested = append(nested, attr.GetKey())
+		}
+	}
+	if len(nested) == 0 {
+		return DryRunStage{Stage: "flatten", Result: dryRunKept}
+	}
+	sort.Strings(nested)
+	return DryRunStage{Stage: "flatten", Result: dryRunMutated, Detail: "expanded " + strings.Join(nested, ", ")}
+}
+
+func countDerivedMetrics(metrics []models.Metric) map[string]int {
+	if len(metrics) == 0 {
+		return nil
+	}
+	counts := make(map[string]int)
+	for _, m := range metrics {
+		counts[m.MetricName]++
+	}
+	return counts
+}
+
+// loadDryRunPayload reads an OTLP export request, either binary protobuf or
+// OTLP/JSON. The signal is detected from the top-level field of JSON
+// payloads when not given.
+func loadDryRunPayload(path, signal string) (proto.Message, string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read payload: %w", err)
+	}
+	isJSON := bytes.HasPrefix(bytes.TrimSpace(data), []byte("{"))
+	if signal == "" {
+		if !isJSON {
+			return nil, "", fmt.Errorf("-signal is required for protobuf payloads")
+		}
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return nil, "", fmt.Errorf("failed to parse payload: %w", err)
+		}
+		switch {
+		case fields["resourceSpans"] != nil || fields["resource_spans"] != nil:
+			signal = signalTraces
+		case fields["resourceLogs"] != nil || fields["resource_logs"] != nil:
+			signal = signalLogs
+		case fields["resourceMetrics"] != nil || fields["resource_metrics"] != nil:
+			signal = signalMetrics
+		default:
+			return nil, "", fmt.Errorf("payload is not an OTLP traces or logs export request")
+		}
+	}
+
+	var msg proto.Message
+	switch signal {
+	case signalTraces:
+		msg = &coltracepb.ExportTraceServiceRequest{}
+	case signalLogs:
+		msg = &collogspb.ExportLogsServiceRequest{}
+	case signalMetrics:
+		return nil, "", fmt.Errorf("metrics are not processed by any stage, only traces and logs can be dry run")
+	default:
+		return nil, "", fmt.Errorf("unknown signal %q", signal)
+	}
+
+	if !isJSON {
+		if err := proto.Unmarshal(data, msg); err != nil {
+			return nil, "", fmt.Errorf("failed to parse protobuf payload: %w", err)
+		}
+		return msg, signal, nil
+	}
+	data, err = hexIDsToBase64(data)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to parse payload: %w", err)
+	}
+	if err := protojson.Unmarshal(data, msg); err != nil {
+		return nil, "", fmt.Errorf("failed to parse OTLP/JSON payload: %w", err)
+	}
+	return msg, signal, nil
+}
+
+// hexIDsToBase64 rewrites trace and span IDs, which OTLP/JSON encodes as
+// hex, into the base64 protojson expects for bytes fields
+func hexIDsToBase64(data []byte) ([]byte, error) {
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			for key, value := range v {
+				switch key {
+				case "traceId", "trace_id", "spanId", "span_id", "parentSpanId", "parent_span_id":
+					if s, ok := value.(string); ok && (len(s) == 32 || len(s) == 16) {
+						if raw, err := hex.DecodeString(s); err == nil {
+							v[key] = base64.StdEncoding.EncodeToString(raw)
+						}
+					}
+				default:
+					walk(value)
+				}
+			}
+		case []interface{}:
+			for _, value := range v {
+				walk(value)
+			}
+		}
+	}
+	walk(doc)
+	return json.Marshal(doc)
+}
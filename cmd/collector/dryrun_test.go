@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"otelservices/internal/config"
+)
+
+const dryRunTracesPayload = `{"resourceSpans": [{
+	"resource": {"attributes": [{"key": "service.name", "value": {"stringValue": "checkout"}}]},
+	"scopeSpans": [{"spans": [{
+		"traceId": "4bf92f3577b34da6a3ce929d0e0e4736",
+		"spanId": "00f067aa0ba902b7",
+		"name": "POST /orders",
+		"kind": 2,
+		"startTimeUnixNano": "1704067200000000000",
+		"endTimeUnixNano": "1704067200250000000"
+	}]}]
+}, {
+	"resource": {"attributes": [{"key": "service.name", "value": {"stringValue": "api"}}]},
+	"scopeSpans": [{"spans": [{
+		"traceId": "5bf92f3577b34da6a3ce929d0e0e4736",
+		"spanId": "10f067aa0ba902b7",
+		"name": "GET /users",
+		"attributes": [
+			{"key": "http.route", "value": {"stringValue": "/users"}},
+			{"key": "user.agent", "value": {"stringValue": "curl"}}
+		]
+	}]}]
+}]}`
+
+func writePayload(t *testing.T, payload string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "payload.json")
+	if err := os.WriteFile(path, []byte(payload), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRunDryRunTraces(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Sampling = config.SamplingConfig{Enabled: true, Policies: []config.SamplingPolicy{
+		{Name: "checkout-off", ServiceName: "checkout", Rate: 0},
+	}}
+	cfg.Attributes = config.AttributesConfig{MaxHotAttributes: 1, HotKeys: []string{"http.route"}}
+	cfg.SpanMetrics.Enabled = true
+	cfg.Exporters = []config.ExporterConfig{{Name: "vendor", Signals: []string{"traces"}}, {Name: "logs-only", Signals: []string{"logs"}}}
+
+	var out bytes.Buffer
+	if err := runDryRun(cfg, writePayload(t, dryRunTracesPayload), "", &out); err != nil {
+		t.Fatalf("runDryRun() error = %v", err)
+	}
+	var report DryRunReport
+	if err := json.Unmarshal(out.Bytes(), &report); err != nil {
+		t.Fatalf("invalid report %s: %v", out.String(), err)
+	}
+
+	if report.Signal != "traces" || report.Received != 2 || report.Dropped != 1 || report.Mutated != 1 {
+		t.Errorf("unexpected summary %+v", report)
+	}
+	if len(report.Exporters) != 1 || report.Exporters[0] != "vendor" {
+		t.Errorf("exporters = %v", report.Exporters)
+	}
+	if len(report.DerivedMetrics) == 0 {
+		t.Error("span metrics should be derived from both spans")
+	}
+
+	dropped := report.Items[0]
+	if dropped.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || dropped.Outcome != "dropped" || len(dropped.Stages) != 2 {
+		t.Fatalf("checkout span = %+v", dropped)
+	}
+	if s := dropped.Stages[1]; s.Stage != "sampling" || !strings.Contains(s.Detail, "checkout-off") {
+		t.Errorf("sampling stage = %+v", s)
+	}
+	mutated := report.Items[1]
+	if s := mutated.Stages[len(mutated.Stages)-1]; s.Stage != "attributes" || s.Result != "mutated" || !strings.Contains(s.Detail, "user.agent") {
+		t.Errorf("attributes stage = %+v", s)
+	}
+}
+
+func TestRunDryRunLogs(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.LogMetrics.Rules = []config.LogMetricRule{{Name: "log.errors", MinSeverity: "ERROR"}}
+	payload := `{"resourceLogs": [{
+		"resource": {"attributes": [{"key": "service.name", "value": {"stringValue": "api"}}]},
+		"scopeLogs": [{"logRecords": [
+			{"severityNumber": 17, "body": {"stringValue": "connection refused"}},
+			{"severityNumber": 9, "body": {"stringValue": "request done"}}
+		]}]
+	}]}`
+
+	var out bytes.Buffer
+	if err := runDryRun(cfg, writePayload(t, payload), "", &out); err != nil {
+		t.Fatalf("runDryRun() error = %v", err)
+	}
+	var report DryRunReport
+	if err := json.Unmarshal(out.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Kept != 2 || report.DerivedMetrics["log.errors"] != 1 {
+		t.Errorf("unexpected report %+v", report)
+	}
+	if s := report.Items[0].Stages[0]; s.Stage != "log_metrics" || s.Detail != "matches log.errors" {
+		t.Errorf("log metrics stage = %+v", s)
+	}
+}
+
+func TestLoadDryRunPayloadErrors(t *testing.T) {
+	if _, _, err := loadDryRunPayload(writePayload(t, "\x0a\x00"), ""); err == nil {
+		t.Error("protobuf payload without -signal should fail")
+	}
+	if _, _, err := loadDryRunPayload(writePayload(t, `{"resourceMetrics": []}`), ""); err == nil {
+		t.Error("metrics payload should be rejected")
+	}
+	if _, _, err := loadDryRunPayload(writePayload(t, `{"spans": []}`), ""); err == nil {
+		t.Error("unknown payload should be rejected")
+	}
+}
+
+func TestHexIDsToBase64(t *testing.T) {
+	data, err := hexIDsToBase64([]byte(`{"spans": [{"traceId": "4bf92f3577b34da6a3ce929d0e0e4736", "name": "abcd"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"spans":[{"name":"abcd","traceId":"S/kvNXezTaajzpKdDg5HNg=="}]}`; string(data) != want {
+		t.Errorf("got %s, want %s", data, want)
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"sync"
+
+	"otelservices/internal/config"
+	"otelservices/internal/geoip"
+	"otelservices/internal/monitoring"
+)
+
+// maxUserAgentCache bounds the parsed user agents kept; the cache is
+// emptied when it fills up
+const maxUserAgentCache = 10000
+
+// attributeEnricher adds the country and city of client addresses and the
+// browser, OS and device of user agents to span and log attributes
+type attributeEnricher struct {
+	geo        *geoip.Reader
+	geoAttrs   []config.EnrichmentAttribute
+	userAgents []config.EnrichmentAttribute
+
+	mu      sync.Mutex
+	uaCache map[string]userAgent
+}
+
+func newAttributeEnricher(cfg config.EnrichmentConfig) (*attributeEnricher, error) {
+	if cfg.GeoIP.Database == "" && len(cfg.UserAgent) == 0 {
+		return nil, nil
+	}
+	e := &attributeEnricher{userAgents: cfg.UserAgent, uaCache: make(map[string]userAgent)}
+	if cfg.GeoIP.Database != "" {
+		geo, err := geoip.Open(cfg.GeoIP.Database)
+		if err != nil {
+			return nil, err
+		}
+		e.geo, e.geoAttrs = geo, cfg.GeoIP.Attributes
+	}
+	return e, nil
+}
+
+// apply adds the derived attributes to attrs and returns it
+func (e *attributeEnricher) apply(attrs map[string]string) map[string]string {
+	if e == nil {
+		return attrs
+	}
+	for _, a := range e.geoAttrs {
+		if value, ok := attrs[a.Attribute]; ok {
+			e.addLocation(attrs, enrichmentPrefix(a), value)
+		}
+	}
+	for _, a := range e.userAgents {
+		if value, ok := attrs[a.Attribute]; ok && value != "" {
+			ua := e.parseUserAgent(value)
+			prefix := enrichmentPrefix(a)
+			setAttribute(attrs, prefix+".name", ua.name)
+			setAttribute(attrs, prefix+".version", ua.version)
+			setAttribute(attrs, prefix+".os.name", ua.osName)
+			setAttribute(attrs, prefix+".device.type", ua.deviceType)
+			monitoring.EnrichmentLookups.WithLabelValues("user_agent", "matched").Inc()
+		}
+	}
+	return attrs
+}
+
+func (e *attributeEnricher) addLocation(attrs map[string]string, prefix, value string) {
+	ip := clientIP(value)
+	if ip == nil {
+		monitoring.EnrichmentLookups.WithLabelValues("geoip", "unmatched").Inc()
+		return
+	}
+	loc, ok, err := e.geo.Lookup(ip)
+	if err != nil {
+		monitoring.EnrichmentLookups.WithLabelValues("geoip", "error").Inc()
+		return
+	}
+	if !ok {
+		monitoring.EnrichmentLookups.WithLabelValues("geoip", "unmatched").Inc()
+		return
+	}
+	monitoring.EnrichmentLookups.WithLabelValues("geoip", "matched").Inc()
+	setAttribute(attrs, prefix+".geo.country_iso_code", loc.CountryISOCode)
+	setAttribute(attrs, prefix+".geo.country_name", loc.CountryName)
+	setAttribute(attrs, prefix+".geo.city_name", loc.CityName)
+}
+
+func (e *attributeEnricher) parseUserAgent(value string) userAgent {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if ua, ok := e.uaCache[value]; ok {
+		return ua
+	}
+	if len(e.uaCache) >= maxUserAgentCache {
+		e.uaCache = make(map[string]userAgent)
+	}
+	ua := parseUserAgent(value)
+	e.uaCache[value] = ua
+	return ua
+}
+
+func enrichmentPrefix(a config.EnrichmentAttribute) string {
+	if a.Prefix != "" {
+		return a.Prefix
+	}
+	return a.Attribute
+}
+
+// setAttribute sets non-empty values only, so unknown fields stay absent
+func setAttribute(attrs map[string]string, key, value string) {
+	if value != "" {
+		attrs[key] = value
+	}
+}
+
+// clientIP parses a client address attribute: an IP, an IP and port, or the
+// comma-separated list of an X-Forwarded-For header, whose first entry is
+// the client
+func clientIP(value string) net.IP {
+	value = strings.TrimSpace(strings.Split(value, ",")[0])
+	if host, _, err := net.SplitHostPort(value); err == nil {
+		value = host
+	}
+	return net.ParseIP(value)
+}
+
+// userAgent is what parseUserAgent recognizes in a User-Agent header
+type userAgent struct {
+	name       string
+	version    string
+	osName     string
+	deviceType string // desktop, mobile, tablet, bot or other
+}
+
+// uaBrowsers are matched in order, since most browsers also name the ones
+// they derive from, e.g. Edge claims to be Chrome and Safari
+var uaBrowsers = []struct {
+	token string
+	name  string
+}{
+	{"Edg/", "Edge"},
+	{"OPR/", "Opera"},
+	{"SamsungBrowser/", "Samsung Internet"},
+	{"Firefox/", "Firefox"},
+	{"FxiOS/", "Firefox"},
+	{"CriOS/", "Chrome"},
+	{"Chrome/", "Chrome"},
+	{"Version/", "Safari"}, // Safari reports its version here, not in Safari/
+	{"MSIE ", "Internet Explorer"},
+	{"Trident/", "Internet Explorer"},
+}
+
+var uaSystems = []struct {
+	token string
+	name  string
+}{
+	{"Windows", "Windows"},
+	{"iPhone", "iOS"},
+	{"iPad", "iOS"},
+	{"iPod", "iOS"},
+	{"Mac OS X", "macOS"},
+	{"Android", "Android"},
+	{"CrOS", "Chrome OS"},
+	{"Linux", "Linux"},
+}
+
+// parseUserAgent recognizes common browsers, operating systems, crawlers and
+// HTTP clients. A client that is not a browser, e.g. curl/8.4.0, is named
+// after its first product token.
+func parseUserAgent(s string) userAgent {
+	var ua userAgent
+	lower := strings.ToLower(s)
+	if strings.Contains(lower, "bot") || strings.Contains(lower, "crawler") || strings.Contains(lower, "spider") {
+		ua.deviceType = "bot"
+		for _, token := range strings.FieldsFunc(s, func(r rune) bool { return r == ' ' || r == ';' || r == '(' || r == ')' }) {
+			if t := strings.ToLower(token); strings.Contains(t, "bot") || strings.Contains(t, "crawler") || strings.Contains(t, "spider") {
+				ua.name, ua.version, _ = strings.Cut(token, "/")
+				break
+			}
+		}
+		return ua
+	}
+	if !strings.HasPrefix(s, "Mozilla/") {
+		product, _, _ := strings.Cut(s, " ")
+		ua.name, ua.version, _ = strings.Cut(product, "/")
+		ua.deviceType = "other"
+		return ua
+	}
+
+	for _, b := range uaBrowsers {
+		if i := strings.Index(s, b.token); i >= 0 {
+			ua.name = b.name
+			ua.version = uaVersion(s[i+len(b.token):])
+			break
+		}
+	}
+	for _, sys := range uaSystems {
+		if strings.Contains(s, sys.token) {
+			ua.osName = sys.name
+			break
+		}
+	}
+	switch {
+	case strings.Contains(s, "iPad") || (ua.osName == "Android" && !strings.Contains(s, "Mobile")):
+		ua.deviceType = "tablet"
+	case strings.Contains(s, "Mobi") || strings.Contains(s, "iPhone"):
+		ua.deviceType = "mobile"
+	default:
+		ua.deviceType = "desktop"
+	}
+	return ua
+}
+
+// uaVersion returns the dotted version number s starts with
+func uaVersion(s string) string {
+	end := strings.IndexFunc(s, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	if end < 0 {
+		return s
+	}
+	return s[:end]
+}
+
+// initEnrichment opens the GeoIP database and sets up the enrichment of
+// spans and logs when configured
+func (c *Collector) initEnrichment() error {
+	e, err := newAttributeEnricher(c.config.Enrichment)
+	if err != nil {
+		return err
+	}
+	c.trace.enrich = e
+	c.logs.enrich = e
+	return nil
+}
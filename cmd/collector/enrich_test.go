@@ -0,0 +1,112 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"otelservices/internal/config"
+)
+
+func TestParseUserAgent(t *testing.T) {
+	tests := []struct {
+		ua   string
+		want userAgent
+	}{
+		{
+			"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+			userAgent{name: "Chrome", version: "120.0.0.0", osName: "Windows", deviceType: "desktop"},
+		},
+		{
+			"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36 Edg/120.0.2210.91",
+			userAgent{name: "Edge", version: "120.0.2210.91", osName: "Windows", deviceType: "desktop"},
+		},
+		{
+			"Mozilla/5.0 (iPhone; CPU iPhone OS 17_2 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.2 Mobile/15E148 Safari/604.1",
+			userAgent{name: "Safari", version: "17.2", osName: "iOS", deviceType: "mobile"},
+		},
+		{
+			"Mozilla/5.0 (Linux; Android 13; SM-X700) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+			userAgent{name: "Chrome", version: "120.0.0.0", osName: "Android", deviceType: "tablet"},
+		},
+		{
+			"Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0",
+			userAgent{name: "Firefox", version: "121.0", osName: "Linux", deviceType: "desktop"},
+		},
+		{
+			"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+			userAgent{name: "Googlebot", version: "2.1", deviceType: "bot"},
+		},
+		{"curl/8.4.0", userAgent{name: "curl", version: "8.4.0", deviceType: "other"}},
+	}
+	for _, tt := range tests {
+		if got := parseUserAgent(tt.ua); got != tt.want {
+			t.Errorf("parseUserAgent(%q) = %+v, want %+v", tt.ua, got, tt.want)
+		}
+	}
+}
+
+func TestAttributeEnricherUserAgent(t *testing.T) {
+	e, err := newAttributeEnricher(config.EnrichmentConfig{UserAgent: []config.EnrichmentAttribute{
+		{Attribute: "http.user_agent", Prefix: "user_agent"},
+		{Attribute: "upstream.user_agent"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	attrs := e.apply(map[string]string{
+		"http.user_agent":     "curl/8.4.0",
+		"upstream.user_agent": "Mozilla/5.0 (compatible; bingbot/2.0)",
+	})
+	want := map[string]string{
+		"user_agent.name":                 "curl",
+		"user_agent.version":              "8.4.0",
+		"user_agent.device.type":          "other",
+		"upstream.user_agent.name":        "bingbot",
+		"upstream.user_agent.version":     "2.0",
+		"upstream.user_agent.device.type": "bot",
+	}
+	for k, v := range want {
+		if attrs[k] != v {
+			t.Errorf("%s = %q, want %q", k, attrs[k], v)
+		}
+	}
+	if _, ok := attrs["user_agent.os.name"]; ok {
+		t.Error("unknown fields should not be set")
+	}
+	if len(e.uaCache) != 2 {
+		t.Errorf("cached %d user agents", len(e.uaCache))
+	}
+}
+
+func TestAttributeEnricherDisabled(t *testing.T) {
+	e, err := newAttributeEnricher(config.EnrichmentConfig{})
+	if e != nil || err != nil {
+		t.Fatalf("newAttributeEnricher() = %v, %v", e, err)
+	}
+	if attrs := e.apply(map[string]string{"a": "b"}); len(attrs) != 1 {
+		t.Errorf("apply() = %v", attrs)
+	}
+	_, err = newAttributeEnricher(config.EnrichmentConfig{GeoIP: config.GeoIPConfig{
+		Database:   filepath.Join(t.TempDir(), "missing.mmdb"),
+		Attributes: []config.EnrichmentAttribute{{Attribute: "client.address"}},
+	}})
+	if err == nil {
+		t.Error("expected error for a missing database")
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	tests := map[string]string{
+		"81.2.69.142":           "81.2.69.142",
+		"81.2.69.142:51234":     "81.2.69.142",
+		"[2001:db8::1]:443":     "2001:db8::1",
+		"81.2.69.142, 10.0.0.1": "81.2.69.142",
+		"not an address":        "<nil>",
+		"":                      "<nil>",
+	}
+	for value, want := range tests {
+		if got := clientIP(value).String(); got != want {
+			t.Errorf("clientIP(%q) = %s, want %s", value, got, want)
+		}
+	}
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"otelservices/internal/config"
+	"otelservices/internal/monitoring"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+)
+
+const (
+	signalTraces  = "traces"
+	signalMetrics = "metrics"
+	signalLogs    = "logs"
+
+	exporterShutdownTimeout = 5 * time.Second
+)
+
+// exportRequest is a received OTLP request waiting to be forwarded
+type exportRequest struct {
+	signal  string
+	payload proto.Message
+}
+
+// exportSender delivers a single request to a downstream endpoint
+type exportSender interface {
+	Send(ctx context.Context, req exportRequest) error
+	Close() error
+}
+
+// permanentError marks a send failure that retrying will not fix
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// otlpExporter forwards OTLP requests to one downstream endpoint. It owns its
+// queue and retry loop so a slow endpoint only affects itself.
+type otlpExporter struct {
+	cfg    config.ExporterConfig
+	queue  chan exportRequest
+	sender exportSender
+}
+
+// newOTLPExporter creates an exporter, falling back to the performance
+// settings for queue size and retry policy when they are not set
+func newOTLPExporter(cfg config.ExporterConfig, perf config.PerformanceConfig) (*otlpExporter, error) {
+	cfg = cfg.WithDefaults(perf)
+
+	var sender exportSender
+	var err error
+	switch cfg.Protocol {
+	case "http":
+		sender = newHTTPSender(cfg)
+	default:
+		sender, err = newGRPCSender(cfg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create exporter %q: %w", cfg.Name, err)
+	}
+
+	return &otlpExporter{
+		cfg:    cfg,
+		queue:  make(chan exportRequest, cfg.QueueSize),
+		sender: sender,
+	}, nil
+}
+
+// Enqueue adds a request to the exporter queue without blocking
+func (e *otlpExporter) Enqueue(req exportRequest) {
+	if !e.cfg.ExportsSignal(req.signal) {
+		return
+	}
+	select {
+	case e.queue <- req:
+		monitoring.ExporterQueueSize.WithLabelValues(e.cfg.Name).Set(float64(len(e.queue)))
+	default:
+		monitoring.ExporterDropped.WithLabelValues(e.cfg.Name, req.signal, "queue_full").Inc()
+	}
+}
+
+// run sends queued requests until ctx is cancelled, then drains what is
+// left within exporterShutdownTimeout
+func (e *otlpExporter) run(ctx context.Context) {
+	defer func() {
+		if err := e.sender.Close(); err != nil {
+			logger.Error("Error closing exporter", "exporter", e.cfg.Name, "error", err)
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			drainCtx, cancel := context.WithTimeout(context.Background(), exporterShutdownTimeout)
+			defer cancel()
+			for {
+				select {
+				case req := <-e.queue:
+					e.export(drainCtx, req)
+				default:
+					return
+				}
+			}
+		case req := <-e.queue:
+			monitoring.ExporterQueueSize.WithLabelValues(e.cfg.Name).Set(float64(len(e.queue)))
+			e.export(ctx, req)
+		}
+	}
+}
+
+// export sends one request, retrying transient failures with exponential backoff
+func (e *otlpExporter) export(ctx context.Context, req exportRequest) {
+	backoff := e.cfg.RetryInitialInterval
+	for attempt := 1; ; attempt++ {
+		err := e.send(ctx, req)
+		if err == nil {
+			monitoring.ExporterRequests.WithLabelValues(e.cfg.Name, req.signal, "success").Inc()
+			monitoring.ExporterUp.WithLabelValues(e.cfg.Name).Set(1)
+			return
+		}
+		monitoring.ExporterRequests.WithLabelValues(e.cfg.Name, req.signal, "error").Inc()
+		monitoring.ExporterUp.WithLabelValues(e.cfg.Name).Set(0)
+
+		var perm *permanentError
+		if errors.As(err, &perm) || attempt >= e.cfg.RetryMaxAttempts {
+			logger.Warn("Exporter dropping request", "exporter", e.cfg.Name, "signal", req.signal, "attempts", attempt, "error", err)
+			monitoring.ExporterDropped.WithLabelValues(e.cfg.Name, req.signal, "send_failed").Inc()
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			monitoring.ExporterDropped.WithLabelValues(e.cfg.Name, req.signal, "shutdown").Inc()
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if e.cfg.RetryMaxInterval > 0 && backoff > e.cfg.RetryMaxInterval {
+			backoff = e.cfg.RetryMaxInterval
+		}
+	}
+}
+
+func (e *otlpExporter) send(ctx context.Context, req exportRequest) error {
+	start := time.Now()
+	defer func() {
+		monitoring.ExporterSendDuration.WithLabelValues(e.cfg.Name).Observe(time.Since(start).Seconds())
+	}()
+
+	sendCtx, cancel := context.WithTimeout(ctx, e.cfg.Timeout)
+	defer cancel()
+	return e.sender.Send(sendCtx, req)
+}
+
+// exporterSet fans a request out to every configured exporter
+type exporterSet []*otlpExporter
+
+// Enqueue hands the request to each exporter's queue
+func (s exporterSet) Enqueue(signal string, payload proto.Message) {
+	for _, e := range s {
+		e.Enqueue(exportRequest{signal: signal, payload: payload})
+	}
+}
+
+// grpcSender forwards requests with the OTLP gRPC services
+type grpcSender struct {
+	conn    *grpc.ClientConn
+	headers map[string]string
+	traces  coltracepb.TraceServiceClient
+	metrics colmetricspb.MetricsServiceClient
+	logs    collogspb.LogsServiceClient
+}
+
+func newGRPCSender(cfg config.ExporterConfig) (*grpcSender, error) {
+	creds := insecure.NewCredentials()
+	if !cfg.Insecure {
+		creds = credentials.NewTLS(&tls.Config{})
+	}
+	conn, err := grpc.Dial(cfg.Endpoint, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial %s: %w", cfg.Endpoint, err)
+	}
+	return &grpcSender{
+		conn:    conn,
+		headers: cfg.Headers,
+		traces:  coltracepb.NewTraceServiceClient(conn),
+		metrics: colmetricspb.NewMetricsServiceClient(conn),
+		logs:    collogspb.NewLogsServiceClient(conn),
+	}, nil
+}
+
+func (s *grpcSender) Send(ctx context.Context, req exportRequest) error {
+	if len(s.headers) > 0 {
+		ctx = metadata.NewOutgoingContext(ctx, metadata.New(s.headers))
+	}
+
+	var err error
+	switch payload := req.payload.(type) {
+	case *coltracepb.ExportTraceServiceRequest:
+		_, err = s.traces.Export(ctx, payload)
+	case *colmetricspb.ExportMetricsServiceRequest:
+		_, err = s.metrics.Export(ctx, payload)
+	case *collogspb.ExportLogsServiceRequest:
+		_, err = s.logs.Export(ctx, payload)
+	default:
+		return &permanentError{fmt.Errorf("unsupported payload type %T", req.payload)}
+	}
+	if err != nil && !retryableGRPCCode(status.Code(err)) {
+		return &permanentError{err}
+	}
+	return err
+}
+
+func (s *grpcSender) Close() error {
+	return s.conn.Close()
+}
+
+// retryableGRPCCode follows the OTLP specification's list of retryable codes
+func retryableGRPCCode(code codes.Code) bool {
+	switch code {
+	case codes.Canceled, codes.DeadlineExceeded, codes.Aborted, codes.OutOfRange,
+		codes.Unavailable, codes.DataLoss, codes.ResourceExhausted:
+		return true
+	}
+	return false
+}
+
+// httpSender forwards requests as OTLP/HTTP protobuf
+type httpSender struct {
+	client   *http.Client
+	endpoint string
+	headers  map[string]string
+}
+
+func newHTTPSender(cfg config.ExporterConfig) *httpSender {
+	return &httpSender{
+		client:   &http.Client{},
+		endpoint: strings.TrimSuffix(cfg.Endpoint, "/"),
+		headers:  cfg.Headers,
+	}
+}
+
+func (s *httpSender) Send(ctx context.Context, req exportRequest) error {
+	body, err := proto.Marshal(req.payload)
+	if err != nil {
+		return &permanentError{fmt.Errorf("failed to marshal %s request: %w", req.signal, err)}
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint+"/v1/"+req.signal, bytes.NewReader(body))
+	if err != nil {
+		return &permanentError{err}
+	}
+	httpReq.Header.Set("Content-Type", "application/x-protobuf")
+	for k, v := range s.headers {
+		httpReq.Header.Set(k, v)
+	}
+
+	resp, err := s.client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	err = fmt.Errorf("%s returned status %d", httpReq.URL, resp.StatusCode)
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return err
+	}
+	return &permanentError{err}
+}
+
+func (s *httpSender) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
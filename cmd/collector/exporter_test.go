@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"otelservices/internal/config"
+
+	"google.golang.org/protobuf/proto"
+
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+// fakeSender returns err for the first `failures` sends
+type fakeSender struct {
+	mu       sync.Mutex
+	failures int
+	err      error
+	sent     []exportRequest
+	attempts int
+}
+
+func (f *fakeSender) Send(ctx context.Context, req exportRequest) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.attempts++
+	if f.attempts <= f.failures {
+		return f.err
+	}
+	f.sent = append(f.sent, req)
+	return nil
+}
+
+func (f *fakeSender) Close() error { return nil }
+
+func newTestExporter(sender exportSender, cfg config.ExporterConfig) *otlpExporter {
+	cfg.Name = "test"
+	cfg.Timeout = time.Second
+	if cfg.RetryMaxAttempts == 0 {
+		cfg.RetryMaxAttempts = 3
+	}
+	cfg.RetryInitialInterval = time.Millisecond
+	cfg.RetryMaxInterval = 5 * time.Millisecond
+	if cfg.QueueSize == 0 {
+		cfg.QueueSize = 10
+	}
+	return &otlpExporter{cfg: cfg, queue: make(chan exportRequest, cfg.QueueSize), sender: sender}
+}
+
+func TestExporterRetriesTransientErrors(t *testing.T) {
+	sender := &fakeSender{failures: 2, err: errors.New("unavailable")}
+	e := newTestExporter(sender, config.ExporterConfig{})
+
+	e.export(context.Background(), exportRequest{signal: signalTraces})
+
+	if sender.attempts != 3 {
+		t.Errorf("attempts = %d, want 3", sender.attempts)
+	}
+	if len(sender.sent) != 1 {
+		t.Errorf("sent = %d, want 1", len(sender.sent))
+	}
+}
+
+func TestExporterGivesUpAfterMaxAttempts(t *testing.T) {
+	sender := &fakeSender{failures: 10, err: errors.New("unavailable")}
+	e := newTestExporter(sender, config.ExporterConfig{RetryMaxAttempts: 2})
+
+	e.export(context.Background(), exportRequest{signal: signalTraces})
+
+	if sender.attempts != 2 {
+		t.Errorf("attempts = %d, want 2", sender.attempts)
+	}
+}
+
+func TestExporterDoesNotRetryPermanentErrors(t *testing.T) {
+	sender := &fakeSender{failures: 10, err: &permanentError{errors.New("bad request")}}
+	e := newTestExporter(sender, config.ExporterConfig{})
+
+	e.export(context.Background(), exportRequest{signal: signalTraces})
+
+	if sender.attempts != 1 {
+		t.Errorf("attempts = %d, want 1", sender.attempts)
+	}
+}
+
+func TestExporterEnqueueFiltersSignalsAndDropsWhenFull(t *testing.T) {
+	e := newTestExporter(&fakeSender{}, config.ExporterConfig{QueueSize: 1, Signals: []string{"traces"}})
+
+	e.Enqueue(exportRequest{signal: signalLogs})
+	if len(e.queue) != 0 {
+		t.Fatal("logs should not be queued for a traces-only exporter")
+	}
+
+	e.Enqueue(exportRequest{signal: signalTraces})
+	e.Enqueue(exportRequest{signal: signalTraces})
+	if len(e.queue) != 1 {
+		t.Errorf("queue length = %d, want 1", len(e.queue))
+	}
+}
+
+func TestExporterRunDrainsOnShutdown(t *testing.T) {
+	sender := &fakeSender{}
+	e := newTestExporter(sender, config.ExporterConfig{})
+	for i := 0; i < 5; i++ {
+		e.Enqueue(exportRequest{signal: signalMetrics})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	e.run(ctx)
+
+	if len(sender.sent) != 5 {
+		t.Errorf("sent = %d, want 5", len(sender.sent))
+	}
+}
+
+func TestHTTPSender(t *testing.T) {
+	var gotPath, gotContentType, gotHeader string
+	var gotReq coltracepb.ExportTraceServiceRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotContentType = r.Header.Get("Content-Type")
+		gotHeader = r.Header.Get("X-Api-Key")
+		body, _ := io.ReadAll(r.Body)
+		proto.Unmarshal(body, &gotReq)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	sender := newHTTPSender(config.ExporterConfig{Endpoint: server.URL + "/", Headers: map[string]string{"X-Api-Key": "secret"}})
+	req := &coltracepb.ExportTraceServiceRequest{
+		ResourceSpans: []*tracepb.ResourceSpans{{}},
+	}
+	if err := sender.Send(context.Background(), exportRequest{signal: signalTraces, payload: req}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	if gotPath != "/v1/traces" {
+		t.Errorf("path = %q, want /v1/traces", gotPath)
+	}
+	if gotContentType != "application/x-protobuf" {
+		t.Errorf("content type = %q", gotContentType)
+	}
+	if gotHeader != "secret" {
+		t.Errorf("header = %q, want secret", gotHeader)
+	}
+	if len(gotReq.ResourceSpans) != 1 {
+		t.Errorf("resource spans = %d, want 1", len(gotReq.ResourceSpans))
+	}
+}
+
+func TestHTTPSenderClassifiesErrors(t *testing.T) {
+	tests := []struct {
+		status    int
+		permanent bool
+	}{
+		{http.StatusServiceUnavailable, false},
+		{http.StatusTooManyRequests, false},
+		{http.StatusBadRequest, true},
+		{http.StatusUnauthorized, true},
+	}
+
+	for _, tt := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tt.status)
+		}))
+		sender := newHTTPSender(config.ExporterConfig{Endpoint: server.URL})
+		err := sender.Send(context.Background(), exportRequest{signal: signalLogs, payload: &coltracepb.ExportTraceServiceRequest{}})
+		server.Close()
+
+		if err == nil {
+			t.Fatalf("status %d: expected error", tt.status)
+		}
+		var perm *permanentError
+		if got := errors.As(err, &perm); got != tt.permanent {
+			t.Errorf("status %d: permanent = %v, want %v", tt.status, got, tt.permanent)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"strconv"
+	"unicode/utf8"
+
+	"otelservices/internal/config"
+
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+)
+
+// attributeFlattener expands map and array log attribute values into one
+// attribute per element, so {"k8s.pod.labels": {"app": "web"}} is stored as
+// k8s.pod.labels.app=web instead of a JSON string that cannot be filtered on
+type attributeFlattener struct {
+	maxDepth       int
+	maxArrayLength int
+	maxValueLength int
+}
+
+func newAttributeFlattener(cfg config.FlattenConfig) *attributeFlattener {
+	if !cfg.Enabled {
+		return nil
+	}
+	return &attributeFlattener{maxDepth: cfg.MaxDepth, maxArrayLength: cfg.MaxArrayLength, maxValueLength: cfg.MaxValueLength}
+}
+
+// convert is convertAttributes with nested values expanded. A disabled
+// flattener stores them as JSON, like convertAttributes.
+func (f *attributeFlattener) convert(attrs []*commonpb.KeyValue) map[string]string {
+	if f == nil {
+		return convertAttributes(attrs)
+	}
+	result := make(map[string]string, len(attrs))
+	for _, attr := range attrs {
+		f.add(result, attr.GetKey(), attr.GetValue(), 0)
+	}
+	return result
+}
+
+// add stores v under key, expanding maps and arrays until maxDepth levels
+// deep. Empty maps and arrays, and anything deeper, are stored as JSON.
+func (f *attributeFlattener) add(dst map[string]string, key string, v *commonpb.AnyValue, depth int) {
+	if depth < f.maxDepth {
+		switch val := v.GetValue().(type) {
+		case *commonpb.AnyValue_KvlistValue:
+			if kvs := val.KvlistValue.GetValues(); len(kvs) > 0 {
+				for _, kv := range kvs {
+					f.add(dst, key+"."+kv.GetKey(), kv.GetValue(), depth+1)
+				}
+				return
+			}
+		case *commonpb.AnyValue_ArrayValue:
+			if items := val.ArrayValue.GetValues(); len(items) > 0 {
+				for i, item := range items {
+					if i == f.maxArrayLength {
+						break
+					}
+					f.add(dst, key+"."+strconv.Itoa(i), item, depth+1)
+				}
+				return
+			}
+		}
+	}
+	dst[key] = f.truncate(anyValueToString(v))
+}
+
+// truncate cuts s to maxValueLength bytes without splitting a character
+func (f *attributeFlattener) truncate(s string) string {
+	if f.maxValueLength == 0 || len(s) <= f.maxValueLength {
+		return s
+	}
+	cut := f.maxValueLength
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut]
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"testing"
+
+	"otelservices/internal/config"
+
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+)
+
+func stringValue(s string) *commonpb.AnyValue {
+	return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: s}}
+}
+
+func kvlistValue(kvs ...*commonpb.KeyValue) *commonpb.AnyValue {
+	return &commonpb.AnyValue{Value: &commonpb.AnyValue_KvlistValue{KvlistValue: &commonpb.KeyValueList{Values: kvs}}}
+}
+
+func arrayValue(items ...*commonpb.AnyValue) *commonpb.AnyValue {
+	return &commonpb.AnyValue{Value: &commonpb.AnyValue_ArrayValue{ArrayValue: &commonpb.ArrayValue{Values: items}}}
+}
+
+func TestAttributeFlattener(t *testing.T) {
+	attrs := []*commonpb.KeyValue{
+		{Key: "k8s.pod.labels", Value: kvlistValue(
+			&commonpb.KeyValue{Key: "app", Value: stringValue("web")},
+			&commonpb.KeyValue{Key: "team", Value: kvlistValue(&commonpb.KeyValue{Key: "name", Value: stringValue("payments")})},
+		)},
+		{Key: "hosts", Value: arrayValue(stringValue("a"), stringValue("b"), stringValue("c"))},
+		{Key: "empty", Value: kvlistValue()},
+		{Key: "message", Value: stringValue("héllo")},
+	}
+
+	f := newAttributeFlattener(config.FlattenConfig{Enabled: true, MaxDepth: 1, MaxArrayLength: 2, MaxValueLength: 2})
+	got := f.convert(attrs)
+	want := map[string]string{
+		"k8s.pod.labels.app":  "we",
+		"k8s.pod.labels.team": `{"`,
+		"hosts.0":             "a",
+		"hosts.1":             "b",
+		"empty":               "{}",
+		"message":             "h", // not cut inside é
+	}
+	if len(got) != len(want) {
+		t.Errorf("convert() = %v, want %v", got, want)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s = %q, want %q", k, got[k], v)
+		}
+	}
+
+	f = newAttributeFlattener(config.FlattenConfig{Enabled: true, MaxDepth: 5, MaxArrayLength: 32})
+	if got := f.convert(attrs); got["k8s.pod.labels.team.name"] != "payments" || got["hosts.2"] != "c" {
+		t.Errorf("deep convert() = %v", got)
+	}
+
+	// Disabled flattening keeps nested values as JSON
+	f = newAttributeFlattener(config.FlattenConfig{MaxDepth: 5})
+	if f != nil {
+		t.Fatal("disabled flattener should be nil")
+	}
+	if got := f.convert(attrs); got["hosts"] != `["a","b","c"]` || got["k8s.pod.labels.app"] != "" {
+		t.Errorf("disabled convert() = %v", got)
+	}
+}
+
+func TestLogBody(t *testing.T) {
+	tests := []struct {
+		value    *commonpb.AnyValue
+		body     string
+		bodyType string
+	}{
+		{nil, "", "string"},
+		{stringValue("request done"), "request done", "string"},
+		{&commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: 42}}, "42", "string"},
+		{&commonpb.AnyValue{Value: &commonpb.AnyValue_BytesValue{BytesValue: []byte{0xca, 0xfe}}}, "cafe", "bytes"},
+		{
+			kvlistValue(
+				&commonpb.KeyValue{Key: "status", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: 500}}},
+				&commonpb.KeyValue{Key: "tags", Value: arrayValue(stringValue("a"), &commonpb.AnyValue{Value: &commonpb.AnyValue_BoolValue{BoolValue: true}})},
+			),
+			`{"status":500,"tags":["a",true]}`, "json",
+		},
+	}
+	for _, tt := range tests {
+		body, bodyType := logBody(tt.value)
+		if body != tt.body || bodyType != tt.bodyType {
+			t.Errorf("logBody(%v) = %q, %q, want %q, %q", tt.value, body, bodyType, tt.body, tt.bodyType)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"otelservices/internal/monitoring"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// errTooManyExports is returned to clients while max_concurrent_exports
+// Export requests are already in flight. Unavailable is retryable under the
+// OTLP specification.
+var errTooManyExports = status.Error(codes.Unavailable, "too many concurrent export requests")
+
+// exportLimiter bounds the number of Export requests handled at once, so a
+// burst of clients cannot pile up blocked handlers faster than the memory
+// limiter notices the heap growing. Requests over the limit are rejected
+// immediately rather than queued.
+type exportLimiter struct {
+	slots chan struct{}
+}
+
+// newExportLimiter returns nil when max is 0; a nil exportLimiter admits
+// every request
+func newExportLimiter(max int) *exportLimiter {
+	if max <= 0 {
+		return nil
+	}
+	return &exportLimiter{slots: make(chan struct{}, max)}
+}
+
+// acquire reports whether a request may proceed. On true the caller must
+// call release when it is done.
+func (l *exportLimiter) acquire() bool {
+	if l == nil {
+		return true
+	}
+	select {
+	case l.slots <- struct{}{}:
+		monitoring.ExportsInFlight.Inc()
+		return true
+	default:
+		return false
+	}
+}
+
+func (l *exportLimiter) release() {
+	if l == nil {
+		return
+	}
+	<-l.slots
+	monitoring.ExportsInFlight.Dec()
+}
+
+// unaryInterceptor rejects gRPC calls over the limit with UNAVAILABLE
+func (l *exportLimiter) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if !l.acquire() {
+		monitoring.ExportsRejected.WithLabelValues("grpc").Inc()
+		return nil, errTooManyExports
+	}
+	defer l.release()
+	return handler(ctx, req)
+}
+
+// httpHandler rejects OTLP/HTTP requests over the limit with 503, before
+// their body is read
+func (l *exportLimiter) httpHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !l.acquire() {
+			monitoring.ExportsRejected.WithLabelValues("http").Inc()
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Too many concurrent export requests", http.StatusServiceUnavailable)
+			return
+		}
+		defer l.release()
+		next.ServeHTTP(w, r)
+	})
+}
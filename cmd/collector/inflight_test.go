@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestExportLimiterInterceptor(t *testing.T) {
+	l := newExportLimiter(1)
+	info := &grpc.UnaryServerInfo{FullMethod: "/opentelemetry.proto.collector.trace.v1.TraceService/Export"}
+
+	// Hold the only slot from inside a handler and try a second call
+	var nested error
+	_, err := l.unaryInterceptor(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		_, nested = l.unaryInterceptor(ctx, req, info, func(context.Context, interface{}) (interface{}, error) {
+			return nil, nil
+		})
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatalf("first call error = %v", err)
+	}
+	if status.Code(nested) != codes.Unavailable {
+		t.Errorf("call over the limit error = %v, want Unavailable", nested)
+	}
+
+	if _, err := l.unaryInterceptor(context.Background(), nil, info, func(context.Context, interface{}) (interface{}, error) {
+		return nil, nil
+	}); err != nil {
+		t.Errorf("slot should be released after the call, got %v", err)
+	}
+
+	var unlimited *exportLimiter
+	if !unlimited.acquire() {
+		t.Error("nil limiter should admit every request")
+	}
+	unlimited.release()
+}
+
+func TestExportLimiterHTTP(t *testing.T) {
+	l := newExportLimiter(1)
+	var inner int
+	h := l.httpHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := httptest.NewRecorder()
+		l.httpHandler(http.NotFoundHandler()).ServeHTTP(rec, r)
+		inner = rec.Code
+		if rec.Header().Get("Retry-After") == "" {
+			t.Error("rejected request should carry Retry-After")
+		}
+	}))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/traces", nil))
+	if rec.Code != http.StatusOK || inner != http.StatusServiceUnavailable {
+		t.Errorf("outer status = %d, inner status = %d; want 200 and 503", rec.Code, inner)
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc/stats"
+)
+
+// ServiceIngestStats counts what one service sent for one signal since the
+// collector started
+type ServiceIngestStats struct {
+	ServiceName string `json:"service_name"`
+	Signal      string `json:"signal"`
+	// Received counts spans, metrics or log records, Bytes their OTLP
+	// protobuf size
+	Received uint64 `json:"received"`
+	Bytes    uint64 `json:"bytes"`
+	// Sampled counts items dropped by a sampling policy, Dropped those
+	// dropped because the signal's queue stayed full and Rejected those
+	// refused because the service was over its ingest quota
+	Sampled      uint64    `json:"sampled"`
+	Dropped      uint64    `json:"dropped"`
+	Rejected     uint64    `json:"rejected"`
+	LastReceived time.Time `json:"last_received"`
+}
+
+// SignalIngestStats is the state of one signal's queue and batch writers
+type SignalIngestStats struct {
+	Signal        string     `json:"signal"`
+	QueueDepth    int        `json:"queue_depth"`
+	QueueCapacity int        `json:"queue_capacity"`
+	Flushes       uint64     `json:"flushes"`
+	FlushFailures uint64     `json:"flush_failures"`
+	LastFlush     *time.Time `json:"last_flush,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+}
+
+// IngestStatsResponse is served on /api/v1/admin/ingest/stats
+type IngestStatsResponse struct {
+	StartedAt       time.Time            `json:"started_at"`
+	GRPCConnections int64                `json:"grpc_connections"`
+	Signals         []SignalIngestStats  `json:"signals"`
+	Services        []ServiceIngestStats `json:"services"`
+}
+
+// ingestKey identifies the stats of one service's signal
+type ingestKey struct {
+	service string
+	signal  string
+}
+
+// ingestStats keeps per-service counts of received, sampled and dropped
+// items, the outcome of each signal's flushes and the number of open gRPC
+// connections, to answer "why is my data not showing up" without a query.
+// It implements grpc's stats.Handler to follow connections. A nil
+// ingestStats records nothing.
+type ingestStats struct {
+	started     time.Time
+	connections atomic.Int64
+
+	mu       sync.Mutex
+	services map[ingestKey]*ServiceIngestStats
+	flushes  map[string]*SignalIngestStats
+
+	// The per-signal totals at rateAt, and the rates since the update
+	// before, for the cluster view
+	rateAt     time.Time
+	rateTotals map[string]signalTotals
+	rates      map[string]SignalIngestRate
+}
+
+// signalTotals are the items and bytes received of one signal
+type signalTotals struct {
+	items uint64
+	bytes uint64
+}
+
+func newIngestStats() *ingestStats {
+	now := time.Now()
+	return &ingestStats{
+		started:    now,
+		services:   make(map[ingestKey]*ServiceIngestStats),
+		flushes:    make(map[string]*SignalIngestStats),
+		rateAt:     now,
+		rateTotals: make(map[string]signalTotals),
+		rates:      make(map[string]SignalIngestRate),
+	}
+}
+
+// record adds the outcome of one resource's items in an Export request
+func (s *ingestStats) record(signal, service string, bytes int, received, sampled, dropped uint64) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.service(signal, service)
+	st.Received += received
+	st.Bytes += uint64(bytes)
+	st.Sampled += sampled
+	st.Dropped += dropped
+	st.LastReceived = time.Now()
+}
+
+// rejected records a resource's items refused by an ingest quota
+func (s *ingestStats) rejected(signal, service string, bytes int, items uint64) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.service(signal, service)
+	st.Received += items
+	st.Bytes += uint64(bytes)
+	st.Rejected += items
+	st.LastReceived = time.Now()
+}
+
+// service returns the stats of one service's signal. The caller holds mu.
+func (s *ingestStats) service(signal, service string) *ServiceIngestStats {
+	key := ingestKey{service: service, signal: signal}
+	st, ok := s.services[key]
+	if !ok {
+		st = &ServiceIngestStats{ServiceName: service, Signal: signal}
+		s.services[key] = st
+	}
+	return st
+}
+
+// flushed records a batch write of the signal and its error, if any
+func (s *ingestStats) flushed(signal string, err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, ok := s.flushes[signal]
+	if !ok {
+		st = &SignalIngestStats{Signal: signal}
+		s.flushes[signal] = st
+	}
+	now := time.Now()
+	st.Flushes++
+	st.LastFlush = &now
+	st.LastError = ""
+	if err != nil {
+		st.FlushFailures++
+		st.LastError = err.Error()
+	}
+}
+
+// snapshot copies the stats, services sorted by name and signal
+func (s *ingestStats) snapshot() IngestStatsResponse {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	resp := IngestStatsResponse{
+		StartedAt:       s.started,
+		GRPCConnections: s.connections.Load(),
+		Signals:         []SignalIngestStats{},
+		Services:        make([]ServiceIngestStats, 0, len(s.services)),
+	}
+	for _, signal := range []string{signalTraces, signalMetrics, signalLogs} {
+		st := SignalIngestStats{Signal: signal}
+		if flushed, ok := s.flushes[signal]; ok {
+			st = *flushed
+		}
+		resp.Signals = append(resp.Signals, st)
+	}
+	for _, st := range s.services {
+		resp.Services = append(resp.Services, *st)
+	}
+	sort.Slice(resp.Services, func(i, j int) bool {
+		if resp.Services[i].ServiceName != resp.Services[j].ServiceName {
+			return resp.Services[i].ServiceName < resp.Services[j].ServiceName
+		}
+		return resp.Services[i].Signal < resp.Services[j].Signal
+	})
+	return resp
+}
+
+// TagRPC implements stats.Handler
+func (s *ingestStats) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	return ctx
+}
+
+// HandleRPC implements stats.Handler
+func (s *ingestStats) HandleRPC(context.Context, stats.RPCStats) {}
+
+// TagConn implements stats.Handler
+func (s *ingestStats) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+// HandleConn implements stats.Handler and counts open connections
+func (s *ingestStats) HandleConn(_ context.Context, st stats.ConnStats) {
+	switch st.(type) {
+	case *stats.ConnBegin:
+		s.connections.Add(1)
+	case *stats.ConnEnd:
+		s.connections.Add(-1)
+	}
+}
+
+// handleIngestStats serves the ingest stats with the current queue depths
+func (c *Collector) handleIngestStats(w http.ResponseWriter, r *http.Request) {
+	resp := c.stats.snapshot()
+	queues := map[string][2]int{
+		signalTraces:  {len(c.trace.spanChan), cap(c.trace.spanChan)},
+		signalMetrics: {len(c.metrics.metricChan), cap(c.metrics.metricChan)},
+		signalLogs:    {len(c.logs.logChan), cap(c.logs.logChan)},
+	}
+	for i := range resp.Signals {
+		q := queues[resp.Signals[i].Signal]
+		resp.Signals[i].QueueDepth, resp.Signals[i].QueueCapacity = q[0], q[1]
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
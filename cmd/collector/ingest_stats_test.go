@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"otelservices/internal/config"
+
+	"google.golang.org/grpc/stats"
+
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+func TestIngestStatsExport(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Sampling.Enabled = true
+	cfg.Sampling.Policies = []config.SamplingPolicy{{Name: "drop-batch", ServiceName: "batch", Rate: 0}}
+	c := NewCollector(cfg, nil)
+	resource := func(service string) *resourcepb.Resource {
+		return &resourcepb.Resource{Attributes: []*commonpb.KeyValue{{
+			Key:   "service.name",
+			Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: service}},
+		}}}
+	}
+	spans := []*tracepb.Span{{Name: "a", TraceId: make([]byte, 16)}, {Name: "b", TraceId: make([]byte, 16)}}
+	req := &coltracepb.ExportTraceServiceRequest{ResourceSpans: []*tracepb.ResourceSpans{
+		{Resource: resource("checkout"), ScopeSpans: []*tracepb.ScopeSpans{{Spans: spans}}},
+		{Resource: resource("batch"), ScopeSpans: []*tracepb.ScopeSpans{{Spans: spans[:1]}}},
+	}}
+	if _, err := c.trace.Export(context.Background(), req); err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+	c.stats.flushed(signalTraces, errors.New("clickhouse unavailable"))
+	c.stats.HandleConn(context.Background(), &stats.ConnBegin{})
+
+	rec := httptest.NewRecorder()
+	c.handleIngestStats(rec, httptest.NewRequest(http.MethodGet, "/api/v1/admin/ingest/stats", nil))
+	var resp IngestStatsResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+
+	if len(resp.Services) != 2 || resp.GRPCConnections != 1 {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+	batch, checkout := resp.Services[0], resp.Services[1]
+	if checkout.ServiceName != "checkout" || checkout.Received != 2 || checkout.Sampled != 0 || checkout.Bytes == 0 {
+		t.Errorf("checkout stats = %+v", checkout)
+	}
+	if batch.ServiceName != "batch" || batch.Received != 1 || batch.Sampled != 1 {
+		t.Errorf("batch stats = %+v", batch)
+	}
+
+	traces := resp.Signals[0]
+	if traces.Signal != signalTraces || traces.QueueDepth != 2 || traces.QueueCapacity != cap(c.trace.spanChan) {
+		t.Errorf("trace queue = %+v", traces)
+	}
+	if traces.FlushFailures != 1 || traces.LastError != "clickhouse unavailable" || traces.LastFlush == nil {
+		t.Errorf("trace flushes = %+v", traces)
+	}
+	if resp.Signals[2].LastFlush != nil {
+		t.Errorf("logs never flushed but report %+v", resp.Signals[2])
+	}
+
+	// A successful flush clears the last error
+	c.stats.flushed(signalTraces, nil)
+	if st := c.stats.snapshot().Signals[0]; st.LastError != "" || st.Flushes != 2 {
+		t.Errorf("after a successful flush = %+v", st)
+	}
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"otelservices/internal/config"
+	"otelservices/internal/monitoring"
+
+	"google.golang.org/grpc/peer"
+
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+)
+
+// In-cluster service account credentials, variables for tests
+var (
+	k8sTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	k8sCAFile    = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+)
+
+// k8sRetryInterval is the wait before listing pods again after a failed
+// list or watch
+var k8sRetryInterval = 5 * time.Second
+
+// k8sWatchTimeout bounds each watch request; the next one resumes from the
+// last resource version seen
+const k8sWatchTimeout = 5 * time.Minute
+
+// k8sPodObject is the part of a Kubernetes Pod the enricher reads
+type k8sPodObject struct {
+	Metadata struct {
+		Name            string            `json:"name"`
+		Namespace       string            `json:"namespace"`
+		UID             string            `json:"uid"`
+		ResourceVersion string            `json:"resourceVersion"`
+		Labels          map[string]string `json:"labels"`
+		OwnerReferences []struct {
+			Kind string `json:"kind"`
+			Name string `json:"name"`
+		} `json:"ownerReferences"`
+	} `json:"metadata"`
+	Spec struct {
+		NodeName    string `json:"nodeName"`
+		HostNetwork bool   `json:"hostNetwork"`
+	} `json:"spec"`
+	Status struct {
+		PodIP string `json:"podIP"`
+	} `json:"status"`
+}
+
+type k8sPodList struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Items []k8sPodObject `json:"items"`
+}
+
+type k8sWatchEvent struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+// k8sPod is a known pod and the resource attributes stored for its telemetry
+type k8sPod struct {
+	uid        string
+	ip         string // empty for host network pods, which share the node's IP
+	attributes map[string]string
+}
+
+// k8sAPI sends requests to the Kubernetes API server
+type k8sAPI struct {
+	client    *http.Client
+	baseURL   string
+	tokenFile string // empty when the API server needs no token
+}
+
+// newK8sAPI connects to apiServer, or with the in-cluster service account
+// when it is empty. setting names the api_server setting for errors.
+func newK8sAPI(apiServer, setting string) (*k8sAPI, error) {
+	a := &k8sAPI{client: &http.Client{}, baseURL: strings.TrimRight(apiServer, "/")}
+	if a.baseURL != "" {
+		return a, nil
+	}
+
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("not running in a Kubernetes cluster; set %s", setting)
+	}
+	ca, err := os.ReadFile(k8sCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read service account CA: %w", err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificates in %s", k8sCAFile)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig.RootCAs = roots
+	a.client.Transport = transport
+	a.baseURL = "https://" + net.JoinHostPort(host, port)
+	a.tokenFile = k8sTokenFile
+	return a, nil
+}
+
+// get requests an API path, failing unless the response is 200 OK
+func (a *k8sAPI) get(ctx context.Context, path string, query url.Values) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.baseURL+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if a.tokenFile != "" {
+		// Read on every request: projected service account tokens are rotated
+		token, err := os.ReadFile(a.tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read service account token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		return nil, fmt.Errorf("kubernetes API returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return resp, nil
+}
+
+// k8sEnricher keeps the pods of the cluster, listed and then watched through
+// the Kubernetes API, and looks up the pod telemetry was sent from
+type k8sEnricher struct {
+	api       *k8sAPI
+	namespace string
+	labels    map[string]bool // nil copies every label
+
+	mu    sync.RWMutex
+	byUID map[string]*k8sPod
+	byIP  map[string]*k8sPod
+}
+
+func newK8sEnricher(cfg config.K8sAttributesConfig) (*k8sEnricher, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	api, err := newK8sAPI(cfg.APIServer, "k8s_attributes.api_server")
+	if err != nil {
+		return nil, err
+	}
+	e := &k8sEnricher{
+		api:       api,
+		namespace: cfg.Namespace,
+		byUID:     make(map[string]*k8sPod),
+		byIP:      make(map[string]*k8sPod),
+	}
+	if len(cfg.Labels) > 0 {
+		e.labels = make(map[string]bool, len(cfg.Labels))
+		for _, label := range cfg.Labels {
+			e.labels[label] = true
+		}
+	}
+	return e, nil
+}
+
+// attributes returns the resource attributes stored for a resource: the
+// metadata of the pod it was sent from, found by its k8s.pod.uid or
+// k8s.pod.ip attribute or else the address it connected from. Unknown
+// senders, and every sender when enrichment is disabled, get an empty map.
+func (e *k8sEnricher) attributes(ctx context.Context, resource *resourcepb.Resource) map[string]string {
+	if e == nil {
+		return make(map[string]string)
+	}
+	pod := e.lookup(extractStringAttribute(resource, "k8s.pod.uid"), extractStringAttribute(resource, "k8s.pod.ip"), peerIP(ctx))
+	if pod == nil {
+		monitoring.K8sEnrichedResources.WithLabelValues("unmatched").Inc()
+		return make(map[string]string)
+	}
+	monitoring.K8sEnrichedResources.WithLabelValues("matched").Inc()
+	// Shared by every record of the pod; a change to the pod replaces the map
+	return pod.attributes
+}
+
+func (e *k8sEnricher) lookup(uid, ip, peerAddr string) *k8sPod {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if pod := e.byUID[uid]; uid != "" && pod != nil {
+		return pod
+	}
+	for _, addr := range []string{ip, peerAddr} {
+		if pod := e.byIP[addr]; addr != "" && pod != nil {
+			return pod
+		}
+	}
+	return nil
+}
+
+// newK8sPod builds the attributes of a pod. Deployments are not read from
+// the API: the name of a ReplicaSet created by one is the deployment's name
+// followed by the pod-template-hash label.
+func (e *k8sEnricher) newK8sPod(obj *k8sPodObject) *k8sPod {
+	meta := obj.Metadata
+	attrs := map[string]string{
+		"k8s.namespace.name": meta.Namespace,
+		"k8s.pod.name":       meta.Name,
+		"k8s.pod.uid":        meta.UID,
+	}
+	if obj.Spec.NodeName != "" {
+		attrs["k8s.node.name"] = obj.Spec.NodeName
+	}
+	for _, owner := range meta.OwnerReferences {
+		switch owner.Kind {
+		case "ReplicaSet":
+			attrs["k8s.replicaset.name"] = owner.Name
+			if hash := meta.Labels["pod-template-hash"]; hash != "" && strings.HasSuffix(owner.Name, "-"+hash) {
+				attrs["k8s.deployment.name"] = strings.TrimSuffix(owner.Name, "-"+hash)
+			}
+		case "StatefulSet":
+			attrs["k8s.statefulset.name"] = owner.Name
+		case "DaemonSet":
+			attrs["k8s.daemonset.name"] = owner.Name
+		case "Job":
+			attrs["k8s.job.name"] = owner.Name
+		}
+	}
+	for key, value := range meta.Labels {
+		if e.labels == nil || e.labels[key] {
+			attrs["k8s.pod.labels."+key] = value
+		}
+	}
+	pod := &k8sPod{uid: meta.UID, attributes: attrs}
+	if !obj.Spec.HostNetwork {
+		pod.ip = obj.Status.PodIP
+	}
+	return pod
+}
+
+// put adds or replaces a pod; the caller holds mu
+func (e *k8sEnricher) put(pod *k8sPod) {
+	e.remove(pod.uid)
+	e.byUID[pod.uid] = pod
+	if pod.ip != "" {
+		e.byIP[pod.ip] = pod
+	}
+}
+
+// remove forgets a pod, keeping its IP for a newer pod that reuses it; the
+// caller holds mu
+func (e *k8sEnricher) remove(uid string) {
+	old := e.byUID[uid]
+	if old == nil {
+		return
+	}
+	delete(e.byUID, uid)
+	if old.ip != "" && e.byIP[old.ip] == old {
+		delete(e.byIP, old.ip)
+	}
+}
+
+// run lists the pods, then watches them for changes until ctx is done,
+// listing again after a failure
+func (e *k8sEnricher) run(ctx context.Context) {
+	if e == nil {
+		return
+	}
+	var resourceVersion string
+	for ctx.Err() == nil {
+		var err error
+		if resourceVersion == "" {
+			resourceVersion, err = e.list(ctx)
+		} else {
+			resourceVersion, err = e.watch(ctx, resourceVersion)
+		}
+		if err == nil || ctx.Err() != nil {
+			continue
+		}
+		monitoring.K8sWatchErrors.Inc()
+		logger.Warn("Kubernetes pod watch failed", "error", err)
+		resourceVersion = ""
+		select {
+		case <-ctx.Done():
+		case <-time.After(k8sRetryInterval):
+		}
+	}
+}
+
+// list replaces the known pods and returns the resource version to watch from
+func (e *k8sEnricher) list(ctx context.Context) (string, error) {
+	resp, err := e.get(ctx, url.Values{})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var pods k8sPodList
+	if err := json.NewDecoder(resp.Body).Decode(&pods); err != nil {
+		return "", fmt.Errorf("failed to decode pod list: %w", err)
+	}
+
+	e.mu.Lock()
+	e.byUID = make(map[string]*k8sPod, len(pods.Items))
+	e.byIP = make(map[string]*k8sPod, len(pods.Items))
+	for i := range pods.Items {
+		e.put(e.newK8sPod(&pods.Items[i]))
+	}
+	monitoring.K8sPods.Set(float64(len(e.byUID)))
+	e.mu.Unlock()
+	return pods.Metadata.ResourceVersion, nil
+}
+
+// watch applies pod changes from resourceVersion on until the API server
+// ends the watch, and returns the last resource version seen
+func (e *k8sEnricher) watch(ctx context.Context, resourceVersion string) (string, error) {
+	resp, err := e.get(ctx, url.Values{
+		"watch":               {"1"},
+		"resourceVersion":     {resourceVersion},
+		"allowWatchBookmarks": {"true"},
+		"timeoutSeconds":      {fmt.Sprint(int(k8sWatchTimeout.Seconds()))},
+	})
+	if err != nil {
+		return resourceVersion, err
+	}
+	defer resp.Body.Close()
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var event k8sWatchEvent
+		if err := decoder.Decode(&event); err != nil {
+			if errors.Is(err, io.EOF) {
+				return resourceVersion, nil
+			}
+			return resourceVersion, fmt.Errorf("failed to decode watch event: %w", err)
+		}
+		if event.Type == "ERROR" {
+			// Typically 410 Gone: the resource version is too old to resume from
+			var status struct {
+				Code    int    `json:"code"`
+				Message string `json:"message"`
+			}
+			json.Unmarshal(event.Object, &status)
+			return resourceVersion, fmt.Errorf("watch error %d: %s", status.Code, status.Message)
+		}
+		var obj k8sPodObject
+		if err := json.Unmarshal(event.Object, &obj); err != nil {
+			return resourceVersion, fmt.Errorf("failed to decode watched pod: %w", err)
+		}
+		resourceVersion = obj.Metadata.ResourceVersion
+
+		e.mu.Lock()
+		switch event.Type {
+		case "ADDED", "MODIFIED":
+			e.put(e.newK8sPod(&obj))
+		case "DELETED":
+			e.remove(obj.Metadata.UID)
+		}
+		monitoring.K8sPods.Set(float64(len(e.byUID)))
+		e.mu.Unlock()
+	}
+}
+
+// get requests the pods of the watched namespace, or of all namespaces
+func (e *k8sEnricher) get(ctx context.Context, query url.Values) (*http.Response, error) {
+	path := "/api/v1/pods"
+	if e.namespace != "" {
+		path = "/api/v1/namespaces/" + url.PathEscape(e.namespace) + "/pods"
+	}
+	return e.api.get(ctx, path, query)
+}
+
+// peerIP returns the IP address the current gRPC call, or an OTLP/HTTP
+// request passed through withPeer, came from
+func peerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
+
+// withPeer records the client address of an OTLP/HTTP request the way gRPC
+// does for its calls
+func withPeer(r *http.Request) context.Context {
+	addr, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return r.Context()
+	}
+	return peer.NewContext(r.Context(), &peer.Peer{Addr: net.TCPAddrFromAddrPort(addr)})
+}
+
+// initK8sAttributes sets up Kubernetes attribute enrichment of spans and
+// logs when enabled; run keeps the pods up to date
+func (c *Collector) initK8sAttributes() error {
+	e, err := newK8sEnricher(c.config.K8sAttributes)
+	if err != nil {
+		return err
+	}
+	c.k8s = e
+	c.trace.k8s = e
+	c.logs.k8s = e
+	return nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"otelservices/internal/config"
+
+	"google.golang.org/grpc/peer"
+
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+func k8sPodJSON(uid, ip, resourceVersion string) string {
+	return fmt.Sprintf(`{
+		"metadata": {
+			"name": "checkout-7d9f8b6c5-x2x9k", "namespace": "shop", "uid": %q, "resourceVersion": %q,
+			"labels": {"app": "checkout", "pod-template-hash": "7d9f8b6c5"},
+			"ownerReferences": [{"kind": "ReplicaSet", "name": "checkout-7d9f8b6c5"}]
+		},
+		"spec": {"nodeName": "node-1"},
+		"status": {"podIP": %q}
+	}`, uid, resourceVersion, ip)
+}
+
+func newTestK8sEnricher(t *testing.T) *k8sEnricher {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/namespaces/shop/pods" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.URL.Query().Get("watch") == "" {
+			fmt.Fprintf(w, `{"metadata": {"resourceVersion": "10"}, "items": [%s]}`, k8sPodJSON("uid-1", "10.0.0.5", "9"))
+			return
+		}
+		if r.URL.Query().Get("resourceVersion") != "10" {
+			t.Errorf("watch from %q", r.URL.Query().Get("resourceVersion"))
+		}
+		fmt.Fprintf(w, `{"type": "MODIFIED", "object": %s}`+"\n", k8sPodJSON("uid-1", "10.0.0.6", "11"))
+		fmt.Fprintf(w, `{"type": "ADDED", "object": %s}`+"\n", k8sPodJSON("uid-2", "10.0.0.7", "12"))
+		fmt.Fprintf(w, `{"type": "DELETED", "object": %s}`+"\n", k8sPodJSON("uid-2", "10.0.0.7", "13"))
+		fmt.Fprint(w, `{"type": "BOOKMARK", "object": {"metadata": {"resourceVersion": "14"}}}`+"\n")
+	}))
+	t.Cleanup(srv.Close)
+
+	e, err := newK8sEnricher(config.K8sAttributesConfig{Enabled: true, APIServer: srv.URL, Namespace: "shop", Labels: []string{"app"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func podResource(key, value string) *resourcepb.Resource {
+	return &resourcepb.Resource{Attributes: []*commonpb.KeyValue{{Key: key, Value: stringValue(value)}}}
+}
+
+func TestK8sEnricher(t *testing.T) {
+	e := newTestK8sEnricher(t)
+	ctx := context.Background()
+	rv, err := e.list(ctx)
+	if err != nil || rv != "10" {
+		t.Fatalf("list() = %q, %v", rv, err)
+	}
+
+	attrs := e.attributes(ctx, podResource("k8s.pod.uid", "uid-1"))
+	want := map[string]string{
+		"k8s.namespace.name":  "shop",
+		"k8s.pod.name":        "checkout-7d9f8b6c5-x2x9k",
+		"k8s.pod.uid":         "uid-1",
+		"k8s.node.name":       "node-1",
+		"k8s.replicaset.name": "checkout-7d9f8b6c5",
+		"k8s.deployment.name": "checkout",
+		"k8s.pod.labels.app":  "checkout",
+	}
+	if len(attrs) != len(want) {
+		t.Errorf("attributes() = %v, want %v", attrs, want)
+	}
+	for k, v := range want {
+		if attrs[k] != v {
+			t.Errorf("%s = %q, want %q", k, attrs[k], v)
+		}
+	}
+	if got := e.attributes(ctx, podResource("k8s.pod.ip", "10.0.0.5")); got["k8s.pod.uid"] != "uid-1" {
+		t.Errorf("lookup by pod IP = %v", got)
+	}
+
+	if rv, err = e.watch(ctx, rv); err != nil || rv != "14" {
+		t.Fatalf("watch() = %q, %v", rv, err)
+	}
+	// The pod moved to a new IP and the added pod is gone again
+	peerCtx := peer.NewContext(ctx, &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.6"), Port: 43122}})
+	if got := e.attributes(peerCtx, &resourcepb.Resource{}); got["k8s.pod.uid"] != "uid-1" {
+		t.Errorf("lookup by peer address = %v", got)
+	}
+	for _, ip := range []string{"10.0.0.5", "10.0.0.7"} {
+		if got := e.attributes(ctx, podResource("k8s.pod.ip", ip)); len(got) != 0 {
+			t.Errorf("%s should not match a pod: %v", ip, got)
+		}
+	}
+}
+
+func TestK8sEnricherDisabled(t *testing.T) {
+	e, err := newK8sEnricher(config.K8sAttributesConfig{})
+	if e != nil || err != nil {
+		t.Fatalf("newK8sEnricher() = %v, %v", e, err)
+	}
+	if attrs := e.attributes(context.Background(), podResource("k8s.pod.uid", "uid-1")); attrs == nil || len(attrs) != 0 {
+		t.Errorf("disabled attributes() = %v", attrs)
+	}
+
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+	if _, err := newK8sEnricher(config.K8sAttributesConfig{Enabled: true}); err == nil {
+		t.Error("expected error outside a cluster without api_server")
+	}
+}
+
+func TestK8sAttributesOnSpans(t *testing.T) {
+	c := NewCollector(config.DefaultConfig(), nil)
+	c.trace.k8s = newTestK8sEnricher(t)
+	if _, err := c.trace.k8s.list(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	req := &coltracepb.ExportTraceServiceRequest{ResourceSpans: []*tracepb.ResourceSpans{{
+		Resource:   podResource("k8s.pod.ip", "10.0.0.5"),
+		ScopeSpans: []*tracepb.ScopeSpans{{Spans: []*tracepb.Span{{Name: "GET /cart", TraceId: make([]byte, 16)}}}},
+	}}}
+	if _, err := c.trace.Export(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	span := <-c.trace.spanChan
+	if span.ResourceAttributes["k8s.deployment.name"] != "checkout" {
+		t.Errorf("span resource attributes = %v", span.ResourceAttributes)
+	}
+}
+
+func TestWithPeer(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/v1/logs", nil)
+	r.RemoteAddr = "10.0.0.6:51234"
+	if got := peerIP(withPeer(r)); got != "10.0.0.6" {
+		t.Errorf("peerIP() = %q", got)
+	}
+	if got := peerIP(context.Background()); got != "" {
+		t.Errorf("peerIP() without a peer = %q", got)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"otelservices/internal/clickhouse"
+	"otelservices/internal/config"
+	"otelservices/internal/kafka"
+	"otelservices/internal/models"
+	"otelservices/internal/pipeline"
+)
+
+// kafkaRestartDelay is how long a consumer waits before reconnecting after a
+// fetch or commit error
+const kafkaRestartDelay = 5 * time.Second
+
+// storageWriter writes batches to ClickHouse and records service operations
+type storageWriter struct {
+	chClient   *clickhouse.Client
+	operations *operationTracker
+}
+
+func (s *storageWriter) Export(ctx context.Context, batch pipeline.Batch) error {
+	var errs []error
+	if len(batch.Spans) > 0 {
+		s.operations.Record(batch.Spans)
+		errs = append(errs, s.chClient.InsertSpans(ctx, batch.Spans))
+	}
+	if len(batch.Metrics) > 0 {
+		errs = append(errs, s.chClient.InsertMetrics(ctx, batch.Metrics))
+	}
+	if len(batch.Logs) > 0 {
+		errs = append(errs, s.chClient.InsertLogs(ctx, batch.Logs))
+	}
+	return errors.Join(errs...)
+}
+
+// kafkaWriter publishes batches to Kafka instead of writing them to ClickHouse
+type kafkaWriter struct {
+	producer *kafka.Producer
+}
+
+func (k *kafkaWriter) Export(ctx context.Context, batch pipeline.Batch) error {
+	var errs []error
+	if len(batch.Spans) > 0 {
+		errs = append(errs, k.producer.PublishSpans(ctx, batch.Spans))
+	}
+	if len(batch.Metrics) > 0 {
+		errs = append(errs, k.producer.PublishMetrics(ctx, batch.Metrics))
+	}
+	if len(batch.Logs) > 0 {
+		errs = append(errs, k.producer.PublishLogs(ctx, batch.Logs))
+	}
+	return errors.Join(errs...)
+}
+
+// newKafkaWriter connects the producer the collector publishes batches with.
+// The collector closes it once the batch processors have drained.
+func (c *Collector) newKafkaWriter() (pipeline.Exporter, error) {
+	if c.producer == nil {
+		producer, err := kafka.NewProducer(&c.config.Kafka)
+		if err != nil {
+			return nil, err
+		}
+		c.producer = producer
+		logger.Info("Publishing batches to Kafka", "brokers", c.config.Kafka.Brokers)
+	}
+	return &kafkaWriter{producer: c.producer}, nil
+}
+
+// kafkaReceiver reads the batches published by producer collectors, one
+// consumer per signal, and hands them to the next consumer. A batch is
+// committed once it was consumed, so one that fails is read again.
+type kafkaReceiver struct {
+	cfg    *config.KafkaConfig
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func (r *kafkaReceiver) Start(ctx context.Context, next pipeline.Consumer) error {
+	ctx, r.cancel = context.WithCancel(ctx)
+	for _, signal := range []string{kafka.SignalTraces, kafka.SignalMetrics, kafka.SignalLogs} {
+		r.wg.Add(1)
+		go r.consume(ctx, signal, next)
+	}
+	return nil
+}
+
+// Shutdown stops the consumers. A batch being written when they stop is
+// not committed and is read again on the next start.
+func (r *kafkaReceiver) Shutdown(ctx context.Context) error {
+	r.cancel()
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// consume reads batches for one signal until ctx is cancelled
+func (r *kafkaReceiver) consume(ctx context.Context, signal string, next pipeline.Consumer) {
+	defer r.wg.Done()
+
+	handle := func(ctx context.Context, value []byte) error {
+		var err error
+		switch signal {
+		case kafka.SignalTraces:
+			var spans []models.Span
+			if spans, err = kafka.DecodeSpans(value); err == nil {
+				return next.Consume(ctx, pipeline.Batch{Spans: spans})
+			}
+		case kafka.SignalMetrics:
+			var metrics []models.Metric
+			if metrics, err = kafka.DecodeMetrics(value); err == nil {
+				return next.Consume(ctx, pipeline.Batch{Metrics: metrics})
+			}
+		case kafka.SignalLogs:
+			var logs []models.LogRecord
+			if logs, err = kafka.DecodeLogs(value); err == nil {
+				return next.Consume(ctx, pipeline.Batch{Logs: logs})
+			}
+		}
+		// Undecodable messages would block the partition forever, skip them
+		logger.Warn("Skipping undecodable Kafka message", "signal", signal, "error", err)
+		return nil
+	}
+
+	for ctx.Err() == nil {
+		consumer := kafka.NewConsumer(r.cfg, signal)
+		err := consumer.Run(ctx, handle)
+		consumer.Close()
+		if err == nil {
+			return
+		}
+		logger.Error("Kafka consumer error", "signal", signal, "error", err)
+		select {
+		case <-ctx.Done():
+		case <-time.After(kafkaRestartDelay):
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"otelservices/internal/config"
+	"otelservices/internal/models"
+)
+
+// logMetricRule is a compiled config.LogMetricRule
+type logMetricRule struct {
+	config.LogMetricRule
+	minSeverity uint8
+	body        *regexp.Regexp
+	valueGroup  int // submatch index of Value in body, or -1 to read an attribute
+}
+
+// logMetricsKey identifies one series of a rule
+type logMetricsKey struct {
+	rule      int
+	service   string
+	namespace string
+	labels    string // label values joined by a NUL byte
+}
+
+// logMetricsSeries accumulates the matching logs of one series since the
+// last flush
+type logMetricsSeries struct {
+	count uint64
+	sum   float64
+	min   float64
+	max   float64
+}
+
+// logMetrics derives metrics from log records matching the configured rules.
+// Logs are matched as they are received, before sampling, and each flush
+// writes the series accumulated since the previous one to otel_metrics.
+type logMetrics struct {
+	rules []logMetricRule
+
+	mu     sync.Mutex
+	series map[logMetricsKey]*logMetricsSeries
+}
+
+// newLogMetrics returns nil when no rules are configured; a nil logMetrics
+// ignores logs. The rules must have passed config validation.
+func newLogMetrics(cfg config.LogMetricsConfig) *logMetrics {
+	if len(cfg.Rules) == 0 {
+		return nil
+	}
+	m := &logMetrics{series: make(map[logMetricsKey]*logMetricsSeries)}
+	for _, r := range cfg.Rules {
+		rule := logMetricRule{LogMetricRule: r, valueGroup: -1}
+		if r.MinSeverity != "" {
+			rule.minSeverity, _ = models.ParseSeverityText(r.MinSeverity)
+		}
+		if r.BodyRegex != "" {
+			rule.body = regexp.MustCompile(r.BodyRegex)
+			if r.Value != "" {
+				rule.valueGroup = rule.body.SubexpIndex(r.Value)
+			}
+		}
+		m.rules = append(m.rules, rule)
+	}
+	return m
+}
+
+// match reports whether the log satisfies the rule and returns the value to
+// record: 1 for counting rules, the extracted number otherwise
+func (r *logMetricRule) match(record models.LogRecord) (float64, bool) {
+	if r.ServiceName != "" && r.ServiceName != record.ServiceName {
+		return 0, false
+	}
+	if record.SeverityNumber < r.minSeverity {
+		return 0, false
+	}
+	for k, v := range r.Attributes {
+		if record.Attributes[k] != v {
+			return 0, false
+		}
+	}
+	var submatches []string
+	if r.body != nil {
+		if submatches = r.body.FindStringSubmatch(record.Body); submatches == nil {
+			return 0, false
+		}
+	}
+	if r.Value == "" {
+		return 1, true
+	}
+	raw, ok := record.Attributes[r.Value]
+	if r.valueGroup >= 0 {
+		raw, ok = submatches[r.valueGroup], true
+	}
+	if !ok {
+		return 0, false
+	}
+	value, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, false
+	}
+	return value, true
+}
+
+// observe matches a received log against every rule
+func (m *logMetrics) observe(record models.LogRecord) {
+	if m == nil {
+		return
+	}
+	for i := range m.rules {
+		rule := &m.rules[i]
+		value, ok := rule.match(record)
+		if !ok {
+			continue
+		}
+		key := logMetricsKey{rule: i, service: record.ServiceName, namespace: record.ServiceNamespace}
+		if len(rule.Labels) > 0 {
+			values := make([]string, len(rule.Labels))
+			for j, label := range rule.Labels {
+				values[j] = record.Attributes[label]
+			}
+			key.labels = strings.Join(values, "\x00")
+		}
+
+		m.mu.Lock()
+		s, ok := m.series[key]
+		if !ok {
+			s = &logMetricsSeries{min: value, max: value}
+			m.series[key] = s
+		}
+		s.count++
+		s.sum += value
+		s.min = math.Min(s.min, value)
+		s.max = math.Max(s.max, value)
+		m.mu.Unlock()
+	}
+}
+
+// drain returns the metrics accumulated since the last call, stamped with
+// now. Counting rules produce delta counters, value rules gauges holding the
+// configured aggregation of the extracted values.
+func (m *logMetrics) drain(now time.Time) []models.Metric {
+	m.mu.Lock()
+	series := m.series
+	m.series = make(map[logMetricsKey]*logMetricsSeries, len(series))
+	m.mu.Unlock()
+
+	metrics := make([]models.Metric, 0, len(series))
+	for key, s := range series {
+		rule := m.rules[key.rule]
+		attrs := make(map[string]string)
+		if len(rule.Labels) > 0 {
+			for i, v := range strings.Split(key.labels, "\x00") {
+				if v != "" {
+					attrs[rule.Labels[i]] = v
+				}
+			}
+		}
+		metric := models.Metric{
+			Timestamp:                now,
+			MetricName:               rule.Name,
+			ServiceName:              key.service,
+			ServiceNamespace:         key.namespace,
+			Attributes:               attrs,
+			InstrumentationScopeName: "logmetrics",
+		}
+		if rule.Value == "" {
+			metric.MetricType = "counter"
+			metric.Value = float64(s.count)
+		} else {
+			metric.MetricType = "gauge"
+			switch rule.Aggregation {
+			case "sum":
+				metric.Value = s.sum
+			case "min":
+				metric.Value = s.min
+			case "max":
+				metric.Value = s.max
+			default:
+				metric.Value = s.sum / float64(s.count)
+			}
+		}
+		metrics = append(metrics, metric)
+	}
+	sort.Slice(metrics, func(i, j int) bool {
+		if metrics[i].ServiceName != metrics[j].ServiceName {
+			return metrics[i].ServiceName < metrics[j].ServiceName
+		}
+		return metrics[i].MetricName < metrics[j].MetricName
+	})
+	return metrics
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"otelservices/internal/config"
+	"otelservices/internal/models"
+
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+)
+
+func TestLogMetricsDrain(t *testing.T) {
+	m := newLogMetrics(config.LogMetricsConfig{Rules: []config.LogMetricRule{
+		{Name: "log.errors", MinSeverity: "ERROR", Labels: []string{"error.type"}},
+		{Name: "checkout.duration", ServiceName: "checkout", BodyRegex: `took (?P<ms>\d+(\.\d+)?)ms`, Value: "ms", Aggregation: "max"},
+		{Name: "queue.depth", Attributes: map[string]string{"event": "queue"}, Value: "depth"},
+	}})
+
+	logs := []models.LogRecord{
+		{ServiceName: "api", SeverityNumber: models.SeverityError, Attributes: map[string]string{"error.type": "timeout"}},
+		{ServiceName: "api", SeverityNumber: models.SeverityFatal, Attributes: map[string]string{"error.type": "timeout"}},
+		{ServiceName: "api", SeverityNumber: models.SeverityError},
+		{ServiceName: "api", SeverityNumber: models.SeverityWarn},
+		{ServiceName: "checkout", ServiceNamespace: "shop", Body: "order took 120ms"},
+		{ServiceName: "checkout", ServiceNamespace: "shop", Body: "order took 87.5ms"},
+		{ServiceName: "checkout", ServiceNamespace: "shop", Body: "order failed"},
+		{ServiceName: "api", Body: "payment took 900ms"},
+		{ServiceName: "worker", Attributes: map[string]string{"event": "queue", "depth": "4"}},
+		{ServiceName: "worker", Attributes: map[string]string{"event": "queue", "depth": "8"}},
+		{ServiceName: "worker", Attributes: map[string]string{"event": "queue", "depth": "full"}},
+	}
+	for _, l := range logs {
+		m.observe(l)
+	}
+
+	now := time.Date(2024, 3, 1, 12, 1, 0, 0, time.UTC)
+	metrics := m.drain(now)
+	if len(metrics) != 4 {
+		t.Fatalf("got %d metrics, want 4: %+v", len(metrics), metrics)
+	}
+
+	errors := make(map[string]float64)
+	for _, metric := range metrics[:2] {
+		if metric.MetricName != "log.errors" || metric.MetricType != "counter" || !metric.Timestamp.Equal(now) {
+			t.Errorf("unexpected metric %+v", metric)
+		}
+		errors[metric.Attributes["error.type"]] = metric.Value
+	}
+	if errors["timeout"] != 2 || errors[""] != 1 {
+		t.Errorf("error counts by type = %v", errors)
+	}
+	if d := metrics[2]; d.MetricName != "checkout.duration" || d.MetricType != "gauge" || d.Value != 120 || d.ServiceNamespace != "shop" {
+		t.Errorf("checkout duration = %+v", d)
+	}
+	if q := metrics[3]; q.MetricName != "queue.depth" || q.Value != 6 {
+		t.Errorf("queue depth = %+v", q)
+	}
+
+	if metrics := m.drain(now); len(metrics) != 0 {
+		t.Errorf("second drain returned %d metrics, want 0", len(metrics))
+	}
+
+	var disabled *logMetrics
+	disabled.observe(logs[0])
+}
+
+func TestLogMetricsIgnoreSampling(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.LogMetrics.Rules = []config.LogMetricRule{{Name: "log.lines"}}
+	cfg.Sampling = config.SamplingConfig{Enabled: true, Policies: []config.SamplingPolicy{{
+		Name: "none", Rate: 0, Logs: config.LogSamplingConfig{Action: "drop"},
+	}}}
+	c := NewCollector(cfg, nil)
+	writer := &metricsRecorder{}
+	c.writer = writer
+
+	resource := &resourcepb.Resource{Attributes: []*commonpb.KeyValue{
+		{Key: "service.name", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: "api"}}},
+	}}
+	_, err := c.logs.Export(context.Background(), &collogspb.ExportLogsServiceRequest{
+		ResourceLogs: []*logspb.ResourceLogs{{Resource: resource, ScopeLogs: []*logspb.ScopeLogs{{
+			LogRecords: []*logspb.LogRecord{{TraceId: traceIDWithValue(1)}, {TraceId: traceIDWithValue(2)}},
+		}}}},
+	})
+	if err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+	if len(c.logs.logChan) != 0 {
+		t.Fatal("logs should have been sampled out")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c.wg.Add(1)
+	go c.processDerivedMetrics(ctx, "log", time.Hour, c.logMetrics.drain)
+	cancel()
+	c.wg.Wait()
+
+	if len(writer.metrics) != 1 || writer.metrics[0].Value != 2 || writer.metrics[0].ServiceName != "api" {
+		t.Errorf("unexpected log metrics %+v", writer.metrics)
+	}
+}
@@ -2,29 +2,37 @@ package main
 
 import (
 	"context"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
 	"fmt"
-	"io"
 	"log"
-	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"sync"
 	"syscall"
 	"time"
 
 	"otelservices/internal/clickhouse"
 	"otelservices/internal/config"
+	"otelservices/internal/kafka"
+	"otelservices/internal/logging"
+	"otelservices/internal/memstore"
 	"otelservices/internal/models"
 	"otelservices/internal/monitoring"
+	"otelservices/internal/pipeline"
 
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/reflection"
 	"google.golang.org/protobuf/proto"
 
 	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
 	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
 	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
 )
 
 const (
@@ -32,12 +40,30 @@ const (
 	serviceVersion = "1.0.0"
 )
 
+// logger is the component logger of the collector
+var logger = logging.For("collector")
+
 // TraceCollector handles trace data
 type TraceCollector struct {
 	coltracepb.UnimplementedTraceServiceServer
-	spanChan chan models.Span
-	config   *config.Config
-	chClient *clickhouse.Client
+	spanChan    chan models.Span
+	config      *config.Config
+	chClient    *clickhouse.Client
+	exporters   exporterSet
+	router      *traceRouter // nil unless routing is enabled
+	sampler     *samplerSwitch
+	intake      *intake
+	limiter     *memoryLimiter
+	stats       *ingestStats
+	tiering     *attributeTiering
+	spanNames   *spanNamer
+	spanMetrics *spanMetrics // sees every span, including those dropped by sampling
+	k8s         *k8sEnricher
+	enrich      *attributeEnricher
+	quotas      *quotaTracker
+	capture     *captureRecorder
+	// precision of the otel_traces time columns, found at startup
+	precision int
 }
 
 // MetricsCollector handles metrics data
@@ -46,431 +72,882 @@ type MetricsCollector struct {
 	metricChan chan models.Metric
 	config     *config.Config
 	chClient   *clickhouse.Client
+	exporters  exporterSet
+	intake     *intake
+	limiter    *memoryLimiter
+	stats      *ingestStats
+	quotas     *quotaTracker
+	capture    *captureRecorder
 }
 
 // LogsCollector handles log data
 type LogsCollector struct {
 	collogspb.UnimplementedLogsServiceServer
-	logChan  chan models.LogRecord
-	config   *config.Config
-	chClient *clickhouse.Client
+	logChan    chan models.LogRecord
+	config     *config.Config
+	chClient   *clickhouse.Client
+	exporters  exporterSet
+	sampler    *samplerSwitch
+	intake     *intake
+	limiter    *memoryLimiter
+	stats      *ingestStats
+	logMetrics *logMetrics // sees every log, including those dropped by sampling
+	flatten    *attributeFlattener
+	k8s        *k8sEnricher
+	enrich     *attributeEnricher
+	quotas     *quotaTracker
+	capture    *captureRecorder
 }
 
 // Collector wraps all three collectors
 type Collector struct {
-	trace      *TraceCollector
-	metrics    *MetricsCollector
-	logs       *LogsCollector
-	config     *config.Config
-	chClient   *clickhouse.Client
+	trace       *TraceCollector
+	metrics     *MetricsCollector
+	logs        *LogsCollector
+	config      *config.Config
+	chClient    *clickhouse.Client
 	healthCheck *monitoring.HealthCheck
-	wg         sync.WaitGroup
+	operations  *operationTracker
+	spanMetrics *spanMetrics
+	logMetrics  *logMetrics
+	exporters   exporterSet
+	storage     *storageWriter
+	memory      *memstore.Store   // when a pipeline exports to memory
+	writer      pipeline.Consumer // the pipelines the batch processors write to
+	pipelines   []*pipeline.Pipeline
+	ingest      []string // names of the pipelines with the OTLP receiver
+	producer    *kafka.Producer
+	queue       *queuedWriter
+	limiter     *memoryLimiter
+	inFlight    *exportLimiter
+	flushCh     chan struct{}
+	batchSizers map[string]*batchSizer
+	intake      *intake
+	stats       *ingestStats
+	k8s         *k8sEnricher
+	quotas      *quotaTracker
+	capture     *captureRecorder
+	batchWG     sync.WaitGroup
+	wg          sync.WaitGroup
 }
 
 // NewCollector creates a new collector instance
 func NewCollector(cfg *config.Config, chClient *clickhouse.Client) *Collector {
-	return &Collector{
+	traceSampler := newSamplerSwitch(cfg.Sampling)
+	derived := newSpanMetrics(cfg.SpanMetrics)
+	fromLogs := newLogMetrics(cfg.LogMetrics)
+	in := &intake{}
+	ingest := newIngestStats()
+	quotas := newQuotaTracker(cfg.Quotas, chClient)
+	perf := cfg.Performance
+	workers := perf.ForSignal(signalTraces).WorkerCount + perf.ForSignal(signalMetrics).WorkerCount + perf.ForSignal(signalLogs).WorkerCount
+	flushCh := make(chan struct{}, workers)
+	limiter := newMemoryLimiter(cfg.Performance, flushCh)
+	capture := newCaptureRecorder(cfg.Capture, monitoring.InstanceID(cfg.Monitoring))
+	c := &Collector{
 		trace: &TraceCollector{
-			spanChan: make(chan models.Span, cfg.Performance.QueueSize),
-			config:   cfg,
-			chClient: chClient,
+			spanChan:    make(chan models.Span, perf.ForSignal(signalTraces).QueueSize),
+			config:      cfg,
+			chClient:    chClient,
+			sampler:     traceSampler,
+			intake:      in,
+			limiter:     limiter,
+			stats:       ingest,
+			tiering:     newAttributeTiering(cfg.Attributes),
+			spanNames:   newSpanNamer(cfg.SpanNames),
+			spanMetrics: derived,
+			quotas:      quotas,
+			capture:     capture,
+			precision:   models.TimestampPrecision,
 		},
 		metrics: &MetricsCollector{
-			metricChan: make(chan models.Metric, cfg.Performance.QueueSize),
+			metricChan: make(chan models.Metric, perf.ForSignal(signalMetrics).QueueSize),
 			config:     cfg,
 			chClient:   chClient,
+			intake:     in,
+			limiter:    limiter,
+			stats:      ingest,
+			quotas:     quotas,
+			capture:    capture,
 		},
 		logs: &LogsCollector{
-			logChan:  make(chan models.LogRecord, cfg.Performance.QueueSize),
-			config:   cfg,
-			chClient: chClient,
+			logChan:    make(chan models.LogRecord, perf.ForSignal(signalLogs).QueueSize),
+			config:     cfg,
+			chClient:   chClient,
+			sampler:    traceSampler,
+			intake:     in,
+			limiter:    limiter,
+			stats:      ingest,
+			logMetrics: fromLogs,
+			flatten:    newAttributeFlattener(cfg.Attributes.FlattenLogs),
+			quotas:     quotas,
+			capture:    capture,
+		},
+		intake:   in,
+		stats:    ingest,
+		quotas:   quotas,
+		capture:  capture,
+		limiter:  limiter,
+		inFlight: newExportLimiter(cfg.OTLP.MaxConcurrentExports),
+		flushCh:  flushCh,
+		batchSizers: map[string]*batchSizer{
+			signalTraces:  newBatchSizer(signalTraces, perf.ForSignal(signalTraces)),
+			signalMetrics: newBatchSizer(signalMetrics, perf.ForSignal(signalMetrics)),
+			signalLogs:    newBatchSizer(signalLogs, perf.ForSignal(signalLogs)),
 		},
 		config:      cfg,
 		chClient:    chClient,
 		healthCheck: monitoring.NewHealthCheck(),
+		operations:  newOperationTracker(),
+		spanMetrics: derived,
+		logMetrics:  fromLogs,
+	}
+	c.storage = &storageWriter{chClient: chClient, operations: c.operations}
+	return c
+}
+
+// initExporters creates the configured downstream exporters and attaches
+// them to each signal collector
+func (c *Collector) initExporters() error {
+	for _, exporterCfg := range c.config.Exporters {
+		e, err := newOTLPExporter(exporterCfg, c.config.Performance)
+		if err != nil {
+			return err
+		}
+		c.exporters = append(c.exporters, e)
+		logger.Info("Forwarding OTLP data", "exporter", exporterCfg.Name, "endpoint", exporterCfg.Endpoint)
 	}
+	c.trace.exporters = c.exporters
+	c.metrics.exporters = c.exporters
+	c.logs.exporters = c.exporters
+	return nil
 }
 
 // Export implements TraceServiceServer
 func (tc *TraceCollector) Export(ctx context.Context, req *coltracepb.ExportTraceServiceRequest) (*coltracepb.ExportTraceServiceResponse, error) {
+	if tc.limiter.Refusing() {
+		monitoring.MemoryLimiterRefused.WithLabelValues(signalTraces).Inc()
+		return nil, errMemoryLimit
+	}
+	if !tc.intake.acquire() {
+		return nil, errShuttingDown
+	}
+	defer tc.intake.release()
+	tc.capture.record(ctx, signalTraces, req)
+	if tc.router != nil {
+		if req = tc.router.route(ctx, req); len(req.ResourceSpans) == 0 {
+			return &coltracepb.ExportTraceServiceResponse{}, nil
+		}
+	}
+	req, rejected := tc.admitTraces(req)
+	tc.exporters.Enqueue(signalTraces, req)
 	for _, rs := range req.ResourceSpans {
 		serviceName := extractStringAttribute(rs.Resource, "service.name")
 		serviceNamespace := extractStringAttribute(rs.Resource, "service.namespace")
 		serviceInstanceID := extractStringAttribute(rs.Resource, "service.instance.id")
 		deploymentEnv := extractStringAttribute(rs.Resource, "deployment.environment")
+		// Shared by every span of the resource rather than allocated per span
+		resourceAttrs := tc.k8s.attributes(ctx, rs.Resource)
+		var received, sampled, dropped uint64
 
 		for _, ss := range rs.ScopeSpans {
+			scope := newInstrumentationScope(ss.Scope)
 			for _, span := range ss.Spans {
+				received++
+				ids := spanContext(serviceName, span)
+				spanName := tc.spanNames.normalize(serviceName, span.Name)
+				tc.spanMetrics.observe(serviceName, serviceNamespace, spanName, span)
+				if !tc.sampler.get().KeepSpan(serviceName, ids.traceID) {
+					sampled++
+					continue
+				}
+				attributes := tc.enrich.apply(convertAttributes(span.Attributes))
+				tc.spanNames.keepRaw(attributes, span.Name, spanName)
+				ids.flag(attributes)
+				startTime, endTime := tc.spanTimes(span, attributes)
+				attrs, coldAttrs := tc.tiering.split(attributes)
 				modelSpan := models.Span{
-					Timestamp:             time.Unix(0, int64(span.StartTimeUnixNano)),
-					TraceID:               fmt.Sprintf("%x", span.TraceId),
-					SpanID:                fmt.Sprintf("%x", span.SpanId),
-					ParentSpanID:          fmt.Sprintf("%x", span.ParentSpanId),
-					SpanName:              span.Name,
-					SpanKind:              span.Kind.String(),
-					StartTime:             time.Unix(0, int64(span.StartTimeUnixNano)),
-					EndTime:               time.Unix(0, int64(span.EndTimeUnixNano)),
-					DurationNs:            span.EndTimeUnixNano - span.StartTimeUnixNano,
-					StatusCode:            span.Status.GetCode().String(),
-					StatusMessage:         span.Status.GetMessage(),
-					ServiceName:           serviceName,
-					ServiceNamespace:      serviceNamespace,
-					ServiceInstanceID:     serviceInstanceID,
-					DeploymentEnvironment: deploymentEnv,
-					Attributes:            convertAttributes(span.Attributes),
-					ResourceAttributes:    make(map[string]string),
-					Events:                []models.SpanEvent{},
-					Links:                 []models.SpanLink{},
+					Timestamp:                   startTime,
+					TraceID:                     hex.EncodeToString(ids.traceID),
+					SpanID:                      hex.EncodeToString(ids.spanID),
+					ParentSpanID:                hex.EncodeToString(ids.parentSpanID),
+					TraceState:                  span.TraceState,
+					SpanName:                    spanName,
+					SpanKind:                    spanKindName(span.Kind),
+					StartTime:                   startTime,
+					EndTime:                     endTime,
+					DurationNs:                  span.EndTimeUnixNano - span.StartTimeUnixNano,
+					StatusCode:                  statusCodeName(span.Status.GetCode()),
+					StatusMessage:               span.Status.GetMessage(),
+					ServiceName:                 serviceName,
+					ServiceNamespace:            serviceNamespace,
+					ServiceInstanceID:           serviceInstanceID,
+					DeploymentEnvironment:       deploymentEnv,
+					Attributes:                  attrs,
+					ColdAttributes:              coldAttrs,
+					TypedAttributes:             typedAttributes(span.Attributes),
+					ResourceAttributes:          resourceAttrs,
+					Events:                      convertSpanEvents(span.Events),
+					Links:                       convertSpanLinks(span.Links),
+					DroppedAttributesCount:      span.DroppedAttributesCount,
+					DroppedEventsCount:          span.DroppedEventsCount,
+					DroppedLinksCount:           span.DroppedLinksCount,
+					InstrumentationScopeName:    scope.name,
+					InstrumentationScopeVersion: scope.version,
+					ScopeAttributes:             scope.attributes,
 				}
 
 				select {
 				case tc.spanChan <- modelSpan:
 					monitoring.ReceivedSpans.WithLabelValues(serviceName).Inc()
+					if modelSpan.StatusCode == "error" {
+						monitoring.ErrorSpans.WithLabelValues(serviceName).Inc()
+					}
 				case <-time.After(100 * time.Millisecond):
-					log.Printf("Warning: span channel full")
+					logger.Warn("Span channel full")
+					dropped++
 				}
 			}
 		}
+		tc.stats.record(signalTraces, serviceName, proto.Size(rs), received, sampled, dropped)
+	}
+	resp := &coltracepb.ExportTraceServiceResponse{}
+	if rejected > 0 {
+		resp.PartialSuccess = &coltracepb.ExportTracePartialSuccess{RejectedSpans: rejected, ErrorMessage: quotaExceededMessage}
 	}
-	return &coltracepb.ExportTraceServiceResponse{}, nil
+	return resp, nil
 }
 
 // Export implements MetricsServiceServer
 func (mc *MetricsCollector) Export(ctx context.Context, req *colmetricspb.ExportMetricsServiceRequest) (*colmetricspb.ExportMetricsServiceResponse, error) {
+	if mc.limiter.Refusing() {
+		monitoring.MemoryLimiterRefused.WithLabelValues(signalMetrics).Inc()
+		return nil, errMemoryLimit
+	}
+	if !mc.intake.acquire() {
+		return nil, errShuttingDown
+	}
+	defer mc.intake.release()
+	mc.capture.record(ctx, signalMetrics, req)
+	req, rejected := mc.admitMetrics(req)
+	mc.exporters.Enqueue(signalMetrics, req)
 	for _, rm := range req.ResourceMetrics {
 		serviceName := extractStringAttribute(rm.Resource, "service.name")
-		for range rm.ScopeMetrics {
+		var received uint64
+		for _, sm := range rm.ScopeMetrics {
 			monitoring.ReceivedMetrics.WithLabelValues(serviceName).Inc()
+			received += uint64(len(sm.Metrics))
 		}
+		mc.stats.record(signalMetrics, serviceName, proto.Size(rm), received, 0, 0)
+	}
+	resp := &colmetricspb.ExportMetricsServiceResponse{}
+	if rejected > 0 {
+		resp.PartialSuccess = &colmetricspb.ExportMetricsPartialSuccess{RejectedDataPoints: rejected, ErrorMessage: quotaExceededMessage}
 	}
-	return &colmetricspb.ExportMetricsServiceResponse{}, nil
+	return resp, nil
 }
 
 // Export implements LogsServiceServer
 func (lc *LogsCollector) Export(ctx context.Context, req *collogspb.ExportLogsServiceRequest) (*collogspb.ExportLogsServiceResponse, error) {
+	if lc.limiter.Refusing() {
+		monitoring.MemoryLimiterRefused.WithLabelValues(signalLogs).Inc()
+		return nil, errMemoryLimit
+	}
+	if !lc.intake.acquire() {
+		return nil, errShuttingDown
+	}
+	defer lc.intake.release()
+	lc.capture.record(ctx, signalLogs, req)
+	req, rejected := lc.admitLogs(req)
+	lc.exporters.Enqueue(signalLogs, req)
 	for _, rl := range req.ResourceLogs {
-		serviceName := extractStringAttribute(rl.Resource, "service.name")
-		serviceNamespace := extractStringAttribute(rl.Resource, "service.namespace")
-		serviceInstanceID := extractStringAttribute(rl.Resource, "service.instance.id")
-		deploymentEnv := extractStringAttribute(rl.Resource, "deployment.environment")
-		hostName := extractStringAttribute(rl.Resource, "host.name")
+		resource := lc.newLogResource(ctx, rl.Resource)
+		serviceName := resource.serviceName
+		var received, sampled, dropped uint64
 
 		for _, sl := range rl.ScopeLogs {
+			scope := newInstrumentationScope(sl.Scope)
 			for _, logRecord := range sl.LogRecords {
-				modelLog := models.LogRecord{
-					Timestamp:             time.Unix(0, int64(logRecord.TimeUnixNano)),
-					ObservedTimestamp:     time.Unix(0, int64(logRecord.ObservedTimeUnixNano)),
-					SeverityNumber:        uint8(logRecord.SeverityNumber),
-					SeverityText:          logRecord.SeverityText,
-					Body:                  logRecord.Body.GetStringValue(),
-					BodyType:              "string",
-					ServiceName:           serviceName,
-					ServiceNamespace:      serviceNamespace,
-					ServiceInstanceID:     serviceInstanceID,
-					DeploymentEnvironment: deploymentEnv,
-					HostName:              hostName,
-					TraceID:               fmt.Sprintf("%x", logRecord.TraceId),
-					SpanID:                fmt.Sprintf("%x", logRecord.SpanId),
-					TraceFlags:            uint8(logRecord.Flags),
-					Attributes:            convertAttributes(logRecord.Attributes),
-					ResourceAttributes:    make(map[string]string),
+				received++
+				ids := logContext(serviceName, logRecord)
+				modelLog := resource.convert(scope, logRecord, ids)
+				lc.logMetrics.observe(modelLog)
+				if !lc.sampler.get().KeepLog(serviceName, ids.traceID) {
+					sampled++
+					continue
 				}
 
 				select {
 				case lc.logChan <- modelLog:
 					monitoring.ReceivedLogs.WithLabelValues(serviceName).Inc()
+					if modelLog.SeverityNumber >= models.SeverityError {
+						monitoring.ErrorLogs.WithLabelValues(serviceName, models.SeverityText(modelLog.SeverityNumber)).Inc()
+					}
 				case <-time.After(100 * time.Millisecond):
-					log.Printf("Warning: log channel full")
+					logger.Warn("Log channel full")
+					dropped++
 				}
 			}
 		}
+		lc.stats.record(signalLogs, serviceName, proto.Size(rl), received, sampled, dropped)
+	}
+	resp := &collogspb.ExportLogsServiceResponse{}
+	if rejected > 0 {
+		resp.PartialSuccess = &collogspb.ExportLogsPartialSuccess{RejectedLogRecords: rejected, ErrorMessage: quotaExceededMessage}
+	}
+	return resp, nil
+}
+
+// logResource holds the resource attributes copied onto every log record
+type logResource struct {
+	serviceName       string
+	serviceNamespace  string
+	serviceInstanceID string
+	deploymentEnv     string
+	hostName          string
+	attributes        map[string]string
+	flatten           *attributeFlattener
+	enrich            *attributeEnricher
+}
+
+func (lc *LogsCollector) newLogResource(ctx context.Context, resource *resourcepb.Resource) logResource {
+	return logResource{
+		serviceName:       extractStringAttribute(resource, "service.name"),
+		serviceNamespace:  extractStringAttribute(resource, "service.namespace"),
+		serviceInstanceID: extractStringAttribute(resource, "service.instance.id"),
+		deploymentEnv:     extractStringAttribute(resource, "deployment.environment"),
+		hostName:          extractStringAttribute(resource, "host.name"),
+		attributes:        lc.k8s.attributes(ctx, resource),
+		flatten:           lc.flatten,
+		enrich:            lc.enrich,
+	}
+}
+
+// convert builds the stored form of an OTLP log record of scope, with the
+// IDs validated by logContext
+func (r logResource) convert(scope instrumentationScope, logRecord *logspb.LogRecord, ids traceContext) models.LogRecord {
+	severityNumber, severityText := models.NormalizeSeverity(uint8(logRecord.SeverityNumber), logRecord.SeverityText)
+	body, bodyType := logBody(logRecord.Body)
+	attributes := r.enrich.apply(r.flatten.convert(logRecord.Attributes))
+	ids.flag(attributes)
+	return models.LogRecord{
+		Timestamp:                   time.Unix(0, int64(logRecord.TimeUnixNano)),
+		ObservedTimestamp:           time.Unix(0, int64(logRecord.ObservedTimeUnixNano)),
+		SeverityNumber:              severityNumber,
+		SeverityText:                severityText,
+		Body:                        body,
+		BodyType:                    bodyType,
+		ServiceName:                 r.serviceName,
+		ServiceNamespace:            r.serviceNamespace,
+		ServiceInstanceID:           r.serviceInstanceID,
+		DeploymentEnvironment:       r.deploymentEnv,
+		HostName:                    r.hostName,
+		TraceID:                     hex.EncodeToString(ids.traceID),
+		SpanID:                      hex.EncodeToString(ids.spanID),
+		TraceFlags:                  uint8(logRecord.Flags),
+		Attributes:                  attributes,
+		TypedAttributes:             typedAttributes(logRecord.Attributes),
+		DroppedAttributesCount:      logRecord.DroppedAttributesCount,
+		ResourceAttributes:          r.attributes,
+		InstrumentationScopeName:    scope.name,
+		InstrumentationScopeVersion: scope.version,
+		ScopeAttributes:             scope.attributes,
 	}
-	return &collogspb.ExportLogsServiceResponse{}, nil
 }
 
 // Helper functions
-func extractStringAttribute(resource interface{}, key string) string {
-	type resourceWithAttrs interface {
-		GetAttributes() interface{}
-	}
-	if r, ok := resource.(resourceWithAttrs); ok {
-		if attrs, ok := r.GetAttributes().([]*interface{}); ok {
-			for _, attr := range attrs {
-				// Simplified - would need proper type assertion
-				_ = attr
-			}
+func extractStringAttribute(resource *resourcepb.Resource, key string) string {
+	for _, attr := range resource.GetAttributes() {
+		if attr.GetKey() == key {
+			return anyValueToString(attr.GetValue())
 		}
 	}
 	return ""
 }
 
-func convertAttributes(attrs interface{}) map[string]string {
-	result := make(map[string]string)
-	// Simplified conversion
+func convertAttributes(attrs []*commonpb.KeyValue) map[string]string {
+	result := make(map[string]string, len(attrs))
+	for _, attr := range attrs {
+		result[attr.GetKey()] = anyValueToString(attr.GetValue())
+	}
+	return result
+}
+
+// anyValueToString renders an OTLP AnyValue as the string stored in attribute maps
+func anyValueToString(v *commonpb.AnyValue) string {
+	switch val := v.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return val.StringValue
+	case *commonpb.AnyValue_BoolValue:
+		return strconv.FormatBool(val.BoolValue)
+	case *commonpb.AnyValue_IntValue:
+		return strconv.FormatInt(val.IntValue, 10)
+	case *commonpb.AnyValue_DoubleValue:
+		return strconv.FormatFloat(val.DoubleValue, 'g', -1, 64)
+	case *commonpb.AnyValue_BytesValue:
+		return hex.EncodeToString(val.BytesValue)
+	case *commonpb.AnyValue_ArrayValue:
+		items := make([]string, len(val.ArrayValue.GetValues()))
+		for i, item := range val.ArrayValue.GetValues() {
+			items[i] = anyValueToString(item)
+		}
+		data, _ := json.Marshal(items)
+		return string(data)
+	case *commonpb.AnyValue_KvlistValue:
+		data, _ := json.Marshal(convertAttributes(val.KvlistValue.GetValues()))
+		return string(data)
+	}
+	return ""
+}
+
+// logBody renders a log body for the body column along with its body_type:
+// maps and arrays as JSON, bytes as hex and other values as strings
+func logBody(v *commonpb.AnyValue) (body, bodyType string) {
+	switch v.GetValue().(type) {
+	case *commonpb.AnyValue_KvlistValue, *commonpb.AnyValue_ArrayValue:
+		data, _ := json.Marshal(anyValueJSON(v))
+		return string(data), "json"
+	case *commonpb.AnyValue_BytesValue:
+		return anyValueToString(v), "bytes"
+	}
+	return anyValueToString(v), "string"
+}
+
+// anyValueJSON converts an OTLP AnyValue for JSON encoding, keeping the
+// nesting and scalar types that anyValueToString turns into strings
+func anyValueJSON(v *commonpb.AnyValue) interface{} {
+	switch val := v.GetValue().(type) {
+	case *commonpb.AnyValue_BoolValue:
+		return val.BoolValue
+	case *commonpb.AnyValue_IntValue:
+		return val.IntValue
+	case *commonpb.AnyValue_DoubleValue:
+		return val.DoubleValue
+	case *commonpb.AnyValue_ArrayValue:
+		items := make([]interface{}, len(val.ArrayValue.GetValues()))
+		for i, item := range val.ArrayValue.GetValues() {
+			items[i] = anyValueJSON(item)
+		}
+		return items
+	case *commonpb.AnyValue_KvlistValue:
+		fields := make(map[string]interface{}, len(val.KvlistValue.GetValues()))
+		for _, kv := range val.KvlistValue.GetValues() {
+			fields[kv.GetKey()] = anyValueJSON(kv.GetValue())
+		}
+		return fields
+	}
+	return anyValueToString(v)
+}
+
+// spanKindName maps an OTLP span kind to the span_kind enum values of otel_traces
+func spanKindName(kind tracepb.Span_SpanKind) string {
+	switch kind {
+	case tracepb.Span_SPAN_KIND_SERVER:
+		return "server"
+	case tracepb.Span_SPAN_KIND_CLIENT:
+		return "client"
+	case tracepb.Span_SPAN_KIND_PRODUCER:
+		return "producer"
+	case tracepb.Span_SPAN_KIND_CONSUMER:
+		return "consumer"
+	}
+	return "internal"
+}
+
+// statusCodeName maps an OTLP status code to the status_code enum value
+func statusCodeName(code tracepb.Status_StatusCode) string {
+	switch code {
+	case tracepb.Status_STATUS_CODE_OK:
+		return "ok"
+	case tracepb.Status_STATUS_CODE_ERROR:
+		return "error"
+	}
+	return "unset"
+}
+
+// convertSpanEvents converts OTLP span events to the events column tuples
+func convertSpanEvents(events []*tracepb.Span_Event) []models.SpanEvent {
+	result := make([]models.SpanEvent, len(events))
+	for i, e := range events {
+		result[i] = models.SpanEvent{
+			Timestamp:  time.Unix(0, int64(e.GetTimeUnixNano())),
+			Name:       e.GetName(),
+			Attributes: convertAttributes(e.GetAttributes()),
+		}
+	}
+	return result
+}
+
+// convertSpanLinks converts OTLP span links to the links column tuples, with
+// IDs hex encoded like the span's own
+func convertSpanLinks(links []*tracepb.Span_Link) []models.SpanLink {
+	result := make([]models.SpanLink, len(links))
+	for i, l := range links {
+		result[i] = models.SpanLink{
+			TraceID:    hex.EncodeToString(l.GetTraceId()),
+			SpanID:     hex.EncodeToString(l.GetSpanId()),
+			TraceState: l.GetTraceState(),
+			Attributes: convertAttributes(l.GetAttributes()),
+		}
+	}
 	return result
 }
 
 // startBatchProcessor starts background workers
 func (c *Collector) startBatchProcessor(ctx context.Context) {
-	for i := 0; i < c.config.Performance.WorkerCount; i++ {
-		c.wg.Add(3)
-		go c.processSpans(ctx)
-		go c.processMetrics(ctx)
-		go c.processLogs(ctx)
+	for signal, process := range map[string]func(context.Context){
+		signalTraces:  c.processSpans,
+		signalMetrics: c.processMetrics,
+		signalLogs:    c.processLogs,
+	} {
+		for i := 0; i < c.config.Performance.ForSignal(signal).WorkerCount; i++ {
+			c.batchWG.Add(1)
+			go process(ctx)
+		}
+	}
+	if c.chClient != nil {
+		c.wg.Add(1)
+		go c.processOperations(ctx)
+	}
+	if c.quotas != nil && c.quotas.store != nil {
+		c.wg.Add(1)
+		go c.processUsage(ctx)
+	}
+	if c.spanMetrics != nil {
+		c.wg.Add(1)
+		go c.processDerivedMetrics(ctx, "span", c.config.SpanMetrics.FlushInterval, c.spanMetrics.drain)
+	}
+	if c.logMetrics != nil {
+		c.wg.Add(1)
+		go c.processDerivedMetrics(ctx, "log", c.config.LogMetrics.FlushInterval, c.logMetrics.drain)
+	}
+	if c.limiter != nil {
+		c.wg.Add(1)
+		go func() {
+			defer c.wg.Done()
+			c.limiter.run(ctx)
+		}()
+	}
+	if c.queue != nil {
+		for signal := range c.queue.queues {
+			c.wg.Add(1)
+			go c.replayQueue(ctx, signal)
+		}
+	}
+	for _, e := range c.exporters {
+		c.wg.Add(1)
+		go func(e *otlpExporter) {
+			defer c.wg.Done()
+			e.run(ctx)
+		}(e)
 	}
 }
 
 func (c *Collector) processSpans(ctx context.Context) {
-	defer c.wg.Done()
-	batch := make([]models.Span, 0, c.config.Performance.BatchSize)
-	ticker := time.NewTicker(c.config.Performance.BatchTimeout)
+	defer c.batchWG.Done()
+	sizer := c.batchSizers[signalTraces]
+	batch := make([]models.Span, 0, sizer.Size())
+	batchBytes := 0
+	ticker := time.NewTicker(sizer.Timeout())
 	defer ticker.Stop()
 
 	flush := func() {
 		if len(batch) == 0 {
 			return
 		}
-		if err := c.chClient.InsertSpans(ctx, batch); err != nil {
-			log.Printf("Error inserting spans: %v", err)
+		start := time.Now()
+		err := c.writer.Consume(ctx, pipeline.Batch{Spans: batch})
+		if err != nil {
+			logger.Error("Error inserting spans", "error", err)
 		}
+		c.stats.flushed(signalTraces, err)
+		sizer.Observe(time.Since(start), len(batch))
 		batch = batch[:0]
+		batchBytes = 0
 	}
 
 	for {
 		select {
 		case <-ctx.Done():
-			flush()
 			return
-		case span := <-c.trace.spanChan:
+		case span, ok := <-c.trace.spanChan:
+			if !ok {
+				// Channel closed by drain, write what is left
+				flush()
+				return
+			}
 			batch = append(batch, span)
-			if len(batch) >= c.config.Performance.BatchSize {
+			batchBytes += span.EstimatedSize()
+			if sizer.Full(len(batch), batchBytes) {
 				flush()
 			}
 		case <-ticker.C:
 			flush()
+			ticker.Reset(sizer.Timeout())
+		case <-c.flushCh:
+			flush()
 		}
 	}
 }
 
 func (c *Collector) processMetrics(ctx context.Context) {
-	defer c.wg.Done()
-	batch := make([]models.Metric, 0, c.config.Performance.BatchSize)
-	ticker := time.NewTicker(c.config.Performance.BatchTimeout)
+	defer c.batchWG.Done()
+	sizer := c.batchSizers[signalMetrics]
+	batch := make([]models.Metric, 0, sizer.Size())
+	batchBytes := 0
+	ticker := time.NewTicker(sizer.Timeout())
 	defer ticker.Stop()
 
 	flush := func() {
 		if len(batch) == 0 {
 			return
 		}
-		if err := c.chClient.InsertMetrics(ctx, batch); err != nil {
-			log.Printf("Error inserting metrics: %v", err)
+		start := time.Now()
+		err := c.writer.Consume(ctx, pipeline.Batch{Metrics: batch})
+		if err != nil {
+			logger.Error("Error inserting metrics", "error", err)
 		}
+		c.stats.flushed(signalMetrics, err)
+		sizer.Observe(time.Since(start), len(batch))
 		batch = batch[:0]
+		batchBytes = 0
 	}
 
 	for {
 		select {
 		case <-ctx.Done():
-			flush()
 			return
-		case metric := <-c.metrics.metricChan:
+		case metric, ok := <-c.metrics.metricChan:
+			if !ok {
+				// Channel closed by drain, write what is left
+				flush()
+				return
+			}
 			batch = append(batch, metric)
-			if len(batch) >= c.config.Performance.BatchSize {
+			batchBytes += metric.EstimatedSize()
+			if sizer.Full(len(batch), batchBytes) {
 				flush()
 			}
 		case <-ticker.C:
 			flush()
+			ticker.Reset(sizer.Timeout())
+		case <-c.flushCh:
+			flush()
 		}
 	}
 }
 
 func (c *Collector) processLogs(ctx context.Context) {
-	defer c.wg.Done()
-	batch := make([]models.LogRecord, 0, c.config.Performance.BatchSize)
-	ticker := time.NewTicker(c.config.Performance.BatchTimeout)
+	defer c.batchWG.Done()
+	sizer := c.batchSizers[signalLogs]
+	batch := make([]models.LogRecord, 0, sizer.Size())
+	batchBytes := 0
+	ticker := time.NewTicker(sizer.Timeout())
 	defer ticker.Stop()
 
 	flush := func() {
 		if len(batch) == 0 {
 			return
 		}
-		if err := c.chClient.InsertLogs(ctx, batch); err != nil {
-			log.Printf("Error inserting logs: %v", err)
+		start := time.Now()
+		err := c.writer.Consume(ctx, pipeline.Batch{Logs: batch})
+		if err != nil {
+			logger.Error("Error inserting logs", "error", err)
 		}
+		c.stats.flushed(signalLogs, err)
+		sizer.Observe(time.Since(start), len(batch))
 		batch = batch[:0]
+		batchBytes = 0
 	}
 
 	for {
 		select {
 		case <-ctx.Done():
-			flush()
 			return
-		case logRecord := <-c.logs.logChan:
+		case logRecord, ok := <-c.logs.logChan:
+			if !ok {
+				// Channel closed by drain, write what is left
+				flush()
+				return
+			}
 			batch = append(batch, logRecord)
-			if len(batch) >= c.config.Performance.BatchSize {
+			batchBytes += logRecord.EstimatedSize()
+			if sizer.Full(len(batch), batchBytes) {
 				flush()
 			}
 		case <-ticker.C:
 			flush()
+			ticker.Reset(sizer.Timeout())
+		case <-c.flushCh:
+			flush()
 		}
 	}
 }
 
-// HTTP handlers for OTLP over HTTP
-func (c *Collector) handleHTTPTraces(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		http.Error(w, "Failed to read request body", http.StatusBadRequest)
-		return
+func main() {
+	dryRun := flag.String("dry-run", "", "run a captured OTLP payload file through the configured pipeline, print what each stage does and exit")
+	dryRunSignal := flag.String("signal", "", "signal of the -dry-run payload (traces or logs); detected for JSON payloads")
+	schema := flag.String("schema", "", "print the schema files in this directory rewritten for the configured ClickHouse deployment and exit")
+	clusterSchema := flag.String("cluster-schema", "", "like -schema, but fail unless a ClickHouse cluster is configured")
+	validateConfig := flag.Bool("validate-config", false, "check the config file, print it with its defaults filled in and exit, with status 1 if it is invalid")
+	dial := flag.Bool("dial", false, "with -validate-config, also check that every ClickHouse address accepts connections")
+	storage := flag.String("storage", storageClickHouse, "where the default pipelines store received data: clickhouse, or memory to keep it in memory for demos, served under /api/v1/admin/memory")
+	flag.Parse()
+	if *storage != storageClickHouse && *storage != storageMemory {
+		log.Fatalf("Unknown storage %q", *storage)
 	}
-	defer r.Body.Close()
 
-	req := &coltracepb.ExportTraceServiceRequest{}
-	if err := proto.Unmarshal(body, req); err != nil {
-		http.Error(w, "Failed to parse protobuf request", http.StatusBadRequest)
-		return
+	configPath := os.Getenv("CONFIG_PATH")
+	if configPath == "" {
+		configPath = "configs/collector.yaml"
 	}
-
-	resp, err := c.trace.Export(r.Context(), req)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Export failed: %v", err), http.StatusInternalServerError)
+	if *validateConfig {
+		result := config.CheckConfig(context.Background(), configPath, config.CheckOptions{Service: "collector", Dial: *dial})
+		if err := result.Write(os.Stdout, os.Stderr); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
 		return
 	}
 
-	respBytes, err := proto.Marshal(resp)
+	cfg, err := config.LoadConfig(configPath)
 	if err != nil {
-		http.Error(w, "Failed to marshal response", http.StatusInternalServerError)
-		return
+		log.Fatalf("Failed to load config: %v", err)
 	}
-
-	w.Header().Set("Content-Type", "application/x-protobuf")
-	w.WriteHeader(http.StatusOK)
-	w.Write(respBytes)
-}
-
-func (c *Collector) handleHTTPMetrics(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+	if err := logging.Setup(cfg.Monitoring.LogLevel, cfg.Monitoring.LogFormat); err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
 	}
 
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		http.Error(w, "Failed to read request body", http.StatusBadRequest)
-		return
+	if *clusterSchema != "" {
+		if !cfg.ClickHouse.Cluster.Enabled() {
+			logging.Fatal(logger, "No ClickHouse cluster configured")
+		}
+		*schema = *clusterSchema
 	}
-	defer r.Body.Close()
-
-	req := &colmetricspb.ExportMetricsServiceRequest{}
-	if err := proto.Unmarshal(body, req); err != nil {
-		http.Error(w, "Failed to parse protobuf request", http.StatusBadRequest)
+	if *schema != "" {
+		if err := writeSchema(*schema, &cfg.ClickHouse, os.Stdout); err != nil {
+			logging.Fatal(logger, "Failed to write schema", "error", err)
+		}
 		return
 	}
 
-	resp, err := c.metrics.Export(r.Context(), req)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Export failed: %v", err), http.StatusInternalServerError)
+	if *dryRun != "" {
+		if err := runDryRun(cfg, *dryRun, *dryRunSignal, os.Stdout); err != nil {
+			logging.Fatal(logger, "Dry run failed", "error", err)
+		}
 		return
 	}
 
-	respBytes, err := proto.Marshal(resp)
-	if err != nil {
-		http.Error(w, "Failed to marshal response", http.StatusInternalServerError)
-		return
+	// With self-ingestion tracing starts once the pipeline exists
+	if !cfg.Monitoring.SelfIngest {
+		shutdown, err := monitoring.InitTracing(serviceName, serviceVersion, cfg.Monitoring)
+		if err != nil {
+			logging.Fatal(logger, "Failed to initialize tracing", "error", err)
+		}
+		defer shutdown(context.Background())
 	}
 
-	w.Header().Set("Content-Type", "application/x-protobuf")
-	w.WriteHeader(http.StatusOK)
-	w.Write(respBytes)
-}
+	metricsServer := monitoring.StartMetricsServer(cfg.Monitoring.MetricsPort, cfg.Monitoring.MetricsPath, monitoring.InstanceID(cfg.Monitoring))
+	defer metricsServer.Shutdown(context.Background())
 
-func (c *Collector) handleHTTPLogs(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+	// A collector whose pipelines do not export to ClickHouse, such as a
+	// Kafka producer, never touches it, so it keeps accepting data while
+	// ClickHouse is unavailable
+	specs := pipelineSpecs(cfg, *storage)
+	var chClient *clickhouse.Client
+	if exportsTo(specs, storageClickHouse) {
+		chClient, err = clickhouse.NewClient(&cfg.ClickHouse)
+		if err != nil {
+			logging.Fatal(logger, "Failed to connect to ClickHouse", "error", err)
+		}
+		defer chClient.Close()
 	}
 
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		http.Error(w, "Failed to read request body", http.StatusBadRequest)
-		return
+	collector := NewCollector(cfg, chClient)
+	if exportsTo(specs, storageMemory) {
+		collector.memory = memstore.New(0)
+		logger.Warn("Keeping received data in memory; it is lost on restart")
 	}
-	defer r.Body.Close()
-
-	req := &collogspb.ExportLogsServiceRequest{}
-	if err := proto.Unmarshal(body, req); err != nil {
-		http.Error(w, "Failed to parse protobuf request", http.StatusBadRequest)
-		return
+	if err := collector.initExporters(); err != nil {
+		logging.Fatal(logger, "Failed to initialize exporters", "error", err)
 	}
-
-	resp, err := c.logs.Export(r.Context(), req)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Export failed: %v", err), http.StatusInternalServerError)
-		return
+	if err := collector.buildPipelines(specs); err != nil {
+		logging.Fatal(logger, "Failed to build pipelines", "error", err)
 	}
-
-	respBytes, err := proto.Marshal(resp)
-	if err != nil {
-		http.Error(w, "Failed to marshal response", http.StatusInternalServerError)
-		return
+	if err := collector.initDiskQueue(); err != nil {
+		logging.Fatal(logger, "Failed to initialize disk queue", "error", err)
 	}
-
-	w.Header().Set("Content-Type", "application/x-protobuf")
-	w.WriteHeader(http.StatusOK)
-	w.Write(respBytes)
-}
-
-func main() {
-	configPath := os.Getenv("CONFIG_PATH")
-	if configPath == "" {
-		configPath = "configs/collector.yaml"
+	if err := collector.initK8sAttributes(); err != nil {
+		logging.Fatal(logger, "Failed to initialize Kubernetes attributes", "error", err)
 	}
-
-	cfg, err := config.LoadConfig(configPath)
-	if err != nil {
-		log.Fatalf("Failed to load config: %v", err)
+	if err := collector.initRouting(); err != nil {
+		logging.Fatal(logger, "Failed to initialize trace routing", "error", err)
 	}
-
-	shutdown, err := monitoring.InitTracing(serviceName, serviceVersion, cfg.Monitoring.TraceSampleRate)
-	if err != nil {
-		log.Fatalf("Failed to initialize tracing: %v", err)
+	if err := collector.initEnrichment(); err != nil {
+		logging.Fatal(logger, "Failed to initialize enrichment", "error", err)
 	}
-	defer shutdown(context.Background())
-
-	metricsServer := monitoring.StartMetricsServer(cfg.Monitoring.MetricsPort, cfg.Monitoring.MetricsPath)
-	defer metricsServer.Shutdown(context.Background())
-
-	chClient, err := clickhouse.NewClient(&cfg.ClickHouse)
-	if err != nil {
-		log.Fatalf("Failed to connect to ClickHouse: %v", err)
+	var stopSelfIngest func(context.Context) error
+	if cfg.Monitoring.SelfIngest {
+		if stopSelfIngest, err = collector.initSelfIngest(); err != nil {
+			logging.Fatal(logger, "Failed to initialize self-ingestion", "error", err)
+		}
 	}
-	defer chClient.Close()
-
-	collector := NewCollector(cfg, chClient)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-	collector.startBatchProcessor(ctx)
 
-	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", cfg.OTLP.GRPCPort))
-	if err != nil {
-		log.Fatalf("Failed to listen: %v", err)
+	reloader := config.NewReloader(cfg, collector.applyConfig)
+	go reloader.WatchSignals(ctx)
+	go collector.k8s.run(ctx)
+	collector.trace.router.start(ctx)
+	go collector.stats.trackRates(ctx)
+
+	// With a disk queue the collector keeps accepting data while ClickHouse
+	// is down, so it stays ready
+	if chClient != nil && !cfg.DiskQueue.Enabled {
+		chClient.SetHealthCheck(collector.healthCheck)
+	}
+	collector.addDependencies()
+	var rollupMgr *rollupManager
+	if chClient != nil {
+		if err := collector.checkTimestamps(ctx); err != nil {
+			if cfg.ClickHouse.TimestampMode == config.TimestampStrict {
+				logging.Fatal(logger, "Failed to check timestamp precision", "error", err)
+			}
+			logger.Warn("Failed to check timestamp precision", "error", err)
+		}
+		rollupMgr = newRollupManager(chClient, cfg.Rollups.LagInterval)
+		if cfg.Rollups.Manage {
+			if err := rollupMgr.ensure(ctx); err != nil {
+				logging.Fatal(logger, "Failed to verify metric rollups", "error", err)
+			}
+		}
+		go rollupMgr.run(ctx)
+		if cfg.ClickHouse.InsertDeduplication.Enabled {
+			if err := chClient.EnableDeduplication(ctx); err != nil {
+				logger.Warn("Inserts are not deduplicated on tables without a deduplication window", "error", err)
+			}
+		}
 	}
-
-	grpcServer := grpc.NewServer()
-	coltracepb.RegisterTraceServiceServer(grpcServer, collector.trace)
-	colmetricspb.RegisterMetricsServiceServer(grpcServer, collector.metrics)
-	collogspb.RegisterLogsServiceServer(grpcServer, collector.logs)
-	reflection.Register(grpcServer)
+	collector.startBatchProcessor(ctx)
 
 	healthMux := http.NewServeMux()
 	healthMux.HandleFunc(cfg.Monitoring.HealthCheckPath, collector.healthCheck.LivenessHandler)
 	healthMux.HandleFunc(cfg.Monitoring.ReadyCheckPath, collector.healthCheck.ReadinessHandler)
+	healthMux.HandleFunc("/api/v1/admin/sampling/decision", func(w http.ResponseWriter, r *http.Request) {
+		collector.trace.sampler.get().handleDecision(w, r)
+	})
+	healthMux.HandleFunc("/api/v1/admin/ingest/stats", collector.handleIngestStats)
+	healthMux.HandleFunc("/api/v1/admin/cluster", collector.handleCluster)
+	healthMux.HandleFunc("/api/v1/admin/config", reloader.HandleConfig)
+	healthMux.HandleFunc("/api/v1/admin/config/reload", reloader.HandleReload)
+	healthMux.HandleFunc("/api/v1/admin/capture", collector.capture.handle)
+	if collector.memory != nil {
+		healthMux.HandleFunc("/api/v1/admin/memory", collector.handleMemory)
+	}
+	if rollupMgr != nil {
+		healthMux.HandleFunc("/api/v1/admin/rollups/ensure", rollupMgr.handleEnsure)
+	}
 	healthServer := &http.Server{
 		Addr:    fmt.Sprintf(":%d", cfg.Server.Port),
 		Handler: healthMux,
@@ -478,61 +955,42 @@ func main() {
 
 	go func() {
 		if err := healthServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Printf("Health server error: %v", err)
+			logger.Error("Health server error", "error", err)
 		}
 	}()
 
-	// Start OTLP HTTP server if enabled
-	log.Printf("HTTP endpoint enabled: %v, port: %d", cfg.OTLP.EnableHTTP, cfg.OTLP.HTTPPort)
-	var httpServer *http.Server
-	if cfg.OTLP.EnableHTTP {
-		httpMux := http.NewServeMux()
-		httpMux.HandleFunc("/v1/traces", collector.handleHTTPTraces)
-		httpMux.HandleFunc("/v1/metrics", collector.handleHTTPMetrics)
-		httpMux.HandleFunc("/v1/logs", collector.handleHTTPLogs)
-
-		httpServer = &http.Server{
-			Addr:         fmt.Sprintf(":%d", cfg.OTLP.HTTPPort),
-			Handler:      httpMux,
-			ReadTimeout:  cfg.Server.ReadTimeout,
-			WriteTimeout: cfg.Server.WriteTimeout,
-		}
-
-		go func() {
-			log.Printf("OTLP HTTP server started on port %d", cfg.OTLP.HTTPPort)
-			if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-				log.Printf("HTTP server error: %v", err)
-			}
-		}()
+	if err := collector.startPipelines(ctx); err != nil {
+		logging.Fatal(logger, "Failed to start pipelines", "error", err)
 	}
-
 	collector.healthCheck.SetReady(true)
-	log.Printf("OTLP Collector started on port %d (gRPC)", cfg.OTLP.GRPCPort)
-
-	go func() {
-		if err := grpcServer.Serve(lis); err != nil {
-			log.Fatalf("Failed to serve: %v", err)
-		}
-	}()
+	logger.Info("OTLP Collector started", "pipelines", len(collector.pipelines))
 
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 	<-sigCh
 
-	log.Println("Shutting down gracefully...")
-	collector.healthCheck.SetReady(false)
-	cancel()
+	logger.Info("Shutting down gracefully")
+	collector.healthCheck.SetState(monitoring.StateDraining, "shutting down")
+
+	// Stop the receivers first so nothing new is queued, then drain what is
+	// already buffered before cancelling the background workers
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer shutdownCancel()
+	collector.shutdownPipelines(shutdownCtx, collector.pipelines)
+	collector.capture.stop(captureShutdown)
 
-	// Shutdown HTTP server if running
-	if httpServer != nil {
-		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
-		defer shutdownCancel()
-		if err := httpServer.Shutdown(shutdownCtx); err != nil {
-			log.Printf("HTTP server shutdown error: %v", err)
+	// Flush the collector's own spans while the pipeline still accepts them
+	if stopSelfIngest != nil {
+		if err := stopSelfIngest(context.Background()); err != nil {
+			logger.Error("Self-ingestion shutdown error", "error", err)
 		}
 	}
-
-	grpcServer.GracefulStop()
-	collector.wg.Wait()
-	log.Println("Shutdown complete")
+	collector.drain(cfg.Server.DrainTimeout, cancel)
+	collector.closeDiskQueue()
+	if collector.producer != nil {
+		if err := collector.producer.Close(); err != nil {
+			logger.Error("Kafka producer close error", "error", err)
+		}
+	}
+	logger.Info("Shutdown complete")
 }
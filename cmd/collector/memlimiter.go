@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"runtime"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+
+	"otelservices/internal/config"
+	"otelservices/internal/monitoring"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const memoryCheckInterval = time.Second
+
+// errMemoryLimit is returned to clients while the collector is over its soft
+// memory limit. ResourceExhausted is retryable under the OTLP specification.
+var errMemoryLimit = status.Error(codes.ResourceExhausted, "collector memory limit exceeded")
+
+// Memory limiter states, exported as otel_memory_limiter_state
+const (
+	memoryStateNormal = iota
+	memoryStateSoft
+	memoryStateHard
+)
+
+// memoryLimiter enforces the performance memory limits. memory_limit_mib is
+// the soft limit: above it new data is refused so clients back off and retry.
+// memory_limit_mib + memory_spike_limit is the hard limit: above it the batch
+// processors are told to flush early and a GC is forced.
+type memoryLimiter struct {
+	soft     uint64
+	hard     uint64
+	refusing atomic.Bool
+	state    int
+	flush    chan struct{}
+	readHeap func() uint64
+}
+
+// newMemoryLimiter returns nil when no memory limit is configured. flush is
+// signalled once per batch processor when the hard limit is crossed.
+func newMemoryLimiter(perf config.PerformanceConfig, flush chan struct{}) *memoryLimiter {
+	if perf.MemoryLimitMiB <= 0 {
+		return nil
+	}
+	soft := uint64(perf.MemoryLimitMiB) << 20
+	return &memoryLimiter{
+		soft:     soft,
+		hard:     soft + uint64(perf.MemorySpikeLimit)<<20,
+		flush:    flush,
+		readHeap: heapInUse,
+	}
+}
+
+func heapInUse() uint64 {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return ms.HeapAlloc
+}
+
+// Refusing reports whether incoming data should be rejected
+func (m *memoryLimiter) Refusing() bool {
+	return m != nil && m.refusing.Load()
+}
+
+// run checks heap usage every memoryCheckInterval until ctx is cancelled
+func (m *memoryLimiter) run(ctx context.Context) {
+	ticker := time.NewTicker(memoryCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.check()
+		}
+	}
+}
+
+func (m *memoryLimiter) check() {
+	heap := m.readHeap()
+	if heap >= m.hard {
+		for i := 0; i < cap(m.flush); i++ {
+			select {
+			case m.flush <- struct{}{}:
+			default:
+			}
+		}
+		debug.FreeOSMemory()
+		monitoring.MemoryLimiterForcedGC.Inc()
+		heap = m.readHeap()
+	}
+	monitoring.MemoryUsage.Set(float64(heap))
+
+	state := memoryStateNormal
+	switch {
+	case heap >= m.hard:
+		state = memoryStateHard
+	case heap >= m.soft:
+		state = memoryStateSoft
+	}
+	if state != m.state {
+		switch state {
+		case memoryStateNormal:
+			logger.Info("Heap usage back under the memory limit, accepting data", "heap_mib", heap>>20)
+		case memoryStateSoft:
+			logger.Warn("Heap usage over the soft memory limit, refusing data", "heap_mib", heap>>20, "limit_mib", m.soft>>20)
+		case memoryStateHard:
+			logger.Warn("Heap usage over the hard memory limit after GC, refusing data", "heap_mib", heap>>20, "limit_mib", m.hard>>20)
+		}
+		m.state = state
+	}
+	m.refusing.Store(state != memoryStateNormal)
+	monitoring.MemoryLimiterState.Set(float64(state))
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+
+	"otelservices/internal/config"
+)
+
+func TestMemoryLimiterDisabled(t *testing.T) {
+	m := newMemoryLimiter(config.PerformanceConfig{}, nil)
+	if m != nil {
+		t.Fatal("expected no limiter without memory_limit_mib")
+	}
+	if m.Refusing() {
+		t.Error("nil limiter should never refuse")
+	}
+}
+
+func TestMemoryLimiterThresholds(t *testing.T) {
+	flush := make(chan struct{}, 3)
+	m := newMemoryLimiter(config.PerformanceConfig{MemoryLimitMiB: 100, MemorySpikeLimit: 20}, flush)
+
+	var heap uint64
+	m.readHeap = func() uint64 { return heap }
+
+	heap = 50 << 20
+	m.check()
+	if m.Refusing() {
+		t.Error("should accept data under the soft limit")
+	}
+
+	heap = 110 << 20
+	m.check()
+	if !m.Refusing() {
+		t.Error("should refuse data over the soft limit")
+	}
+	if len(flush) != 0 {
+		t.Error("should not flush under the hard limit")
+	}
+
+	heap = 130 << 20
+	m.check()
+	if !m.Refusing() || m.state != memoryStateHard {
+		t.Errorf("expected hard state, got refusing=%v state=%d", m.Refusing(), m.state)
+	}
+	if len(flush) != cap(flush) {
+		t.Errorf("expected a flush signal per batch processor, got %d", len(flush))
+	}
+
+	heap = 60 << 20
+	m.check()
+	if m.Refusing() || m.state != memoryStateNormal {
+		t.Errorf("expected recovery, got refusing=%v state=%d", m.Refusing(), m.state)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"otelservices/internal/memstore"
+	"otelservices/internal/pipeline"
+)
+
+// Storage backends selected with -storage
+const (
+	storageClickHouse = "clickhouse"
+	storageMemory     = "memory"
+)
+
+// memoryWriter keeps batches in the in-memory store of -storage=memory
+type memoryWriter struct {
+	store *memstore.Store
+}
+
+func (m *memoryWriter) Export(ctx context.Context, batch pipeline.Batch) error {
+	m.store.InsertSpans(ctx, batch.Spans)
+	m.store.InsertMetrics(ctx, batch.Metrics)
+	return m.store.InsertLogs(ctx, batch.Logs)
+}
+
+// MemoryResponse is the response of the in-memory storage endpoint
+type MemoryResponse struct {
+	Services []string    `json:"services"`
+	Items    interface{} `json:"items"`
+	Total    int         `json:"total"`
+}
+
+// handleMemory serves what -storage=memory holds for a signal, newest
+// first, filtered by service, trace_id, name and a start and end time
+func (c *Collector) handleMemory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	filter, err := memoryFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp := MemoryResponse{Services: c.memory.Services()}
+	switch signal := r.URL.Query().Get("signal"); signal {
+	case signalTraces, "":
+		spans := c.memory.Spans(filter)
+		resp.Items, resp.Total = spans, len(spans)
+	case signalMetrics:
+		metrics := c.memory.Metrics(filter)
+		resp.Items, resp.Total = metrics, len(metrics)
+	case signalLogs:
+		logs := c.memory.Logs(filter)
+		resp.Items, resp.Total = logs, len(logs)
+	default:
+		http.Error(w, fmt.Sprintf("unknown signal %q", signal), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// memoryFilter reads the filter of a memory request. limit defaults to 100.
+func memoryFilter(r *http.Request) (memstore.Filter, error) {
+	q := r.URL.Query()
+	f := memstore.Filter{
+		ServiceName: q.Get("service"),
+		TraceID:     q.Get("trace_id"),
+		Name:        q.Get("name"),
+		Limit:       100,
+	}
+	for param, dest := range map[string]*time.Time{"start": &f.Start, "end": &f.End} {
+		if val := q.Get(param); val != "" {
+			t, err := time.Parse(time.RFC3339Nano, val)
+			if err != nil {
+				return f, fmt.Errorf("invalid %s: %w", param, err)
+			}
+			*dest = t
+		}
+	}
+	if val := q.Get("limit"); val != "" {
+		limit, err := strconv.Atoi(val)
+		if err != nil || limit < 0 {
+			return f, fmt.Errorf("invalid limit %q", val)
+		}
+		f.Limit = limit
+	}
+	return f, nil
+}
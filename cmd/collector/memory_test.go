@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"otelservices/internal/config"
+	"otelservices/internal/memstore"
+
+	"google.golang.org/protobuf/proto"
+
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+// newMemoryCollector starts a collector whose pipelines write to an
+// in-memory store in place of ClickHouse. flush stops the collector once
+// everything exported so far is in the store.
+func newMemoryCollector(tb testing.TB, cfg *config.Config) (c *Collector, store *memstore.Store, flush func()) {
+	tb.Helper()
+	c = NewCollector(cfg, nil)
+	c.memory = memstore.New(0)
+	if err := c.buildPipelines(pipelineSpecs(cfg, storageMemory)); err != nil {
+		tb.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	c.startBatchProcessor(ctx)
+	return c, c.memory, func() { c.drain(5*time.Second, cancel) }
+}
+
+// TestMemoryStorage runs OTLP over HTTP through the whole pipeline into the
+// in-memory store, without ClickHouse
+func TestMemoryStorage(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Performance.BatchTimeout = time.Hour
+	c, _, flush := newMemoryCollector(t, cfg)
+
+	span := testSpan("checkout", 20*time.Millisecond, tracepb.Status_STATUS_CODE_OK, "POST")
+	span.TraceId = traceIDWithValue(1)
+	body, err := proto.Marshal(&coltracepb.ExportTraceServiceRequest{ResourceSpans: []*tracepb.ResourceSpans{{
+		Resource: &resourcepb.Resource{Attributes: []*commonpb.KeyValue{
+			{Key: "service.name", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: "shop"}}},
+		}},
+		ScopeSpans: []*tracepb.ScopeSpans{{Spans: []*tracepb.Span{span}}},
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	c.handleHTTPTraces(rec, httptest.NewRequest(http.MethodPost, "/v1/traces", bytes.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("export status %d: %s", rec.Code, rec.Body.String())
+	}
+	flush()
+
+	rec = httptest.NewRecorder()
+	c.handleMemory(rec, httptest.NewRequest(http.MethodGet, "/api/v1/admin/memory?signal=traces&service=shop", nil))
+	var resp struct {
+		Services []string
+		Items    []struct{ SpanName, ServiceName string }
+		Total    int
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Total != 1 || resp.Items[0].SpanName != "checkout" || len(resp.Services) != 1 {
+		t.Errorf("memory = %+v", resp)
+	}
+
+	for _, query := range []string{"signal=profiles", "limit=-1", "start=yesterday"} {
+		rec = httptest.NewRecorder()
+		c.handleMemory(rec, httptest.NewRequest(http.MethodGet, "/api/v1/admin/memory?"+query, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d", query, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"otelservices/internal/models"
+)
+
+// operationsFlushInterval is how often newly seen service/operation pairs are
+// written to the otel_service_operations dictionary table
+const operationsFlushInterval = 1 * time.Minute
+
+// operationTracker collects the distinct service/operation pairs seen per hour
+// so the query service can list services without scanning otel_traces. Only
+// pairs not yet written are kept in memory; repeated writes of the same pair
+// within an hour are collapsed by the table's ReplacingMergeTree engine.
+type operationTracker struct {
+	mu      sync.Mutex
+	pending map[models.ServiceOperation]struct{}
+}
+
+func newOperationTracker() *operationTracker {
+	return &operationTracker{
+		pending: make(map[models.ServiceOperation]struct{}),
+	}
+}
+
+// Record adds the operations of a span batch to the pending set
+func (t *operationTracker) Record(spans []models.Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for i := range spans {
+		op := models.ServiceOperation{
+			Timestamp:   spans[i].StartTime.UTC().Truncate(time.Hour),
+			ServiceName: spans[i].ServiceName,
+			SpanName:    spans[i].SpanName,
+			SpanKind:    spans[i].SpanKind,
+		}
+		t.pending[op] = struct{}{}
+	}
+}
+
+// Drain returns the pending operations and resets the set
+func (t *operationTracker) Drain() []models.ServiceOperation {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.pending) == 0 {
+		return nil
+	}
+	ops := make([]models.ServiceOperation, 0, len(t.pending))
+	for op := range t.pending {
+		ops = append(ops, op)
+	}
+	t.pending = make(map[models.ServiceOperation]struct{})
+	return ops
+}
+
+// processOperations periodically flushes the operation dictionary
+func (c *Collector) processOperations(ctx context.Context) {
+	defer c.wg.Done()
+	ticker := time.NewTicker(operationsFlushInterval)
+	defer ticker.Stop()
+
+	flush := func(ctx context.Context) {
+		ops := c.operations.Drain()
+		if len(ops) == 0 {
+			return
+		}
+		if err := c.chClient.InsertServiceOperations(ctx, ops); err != nil {
+			logger.Error("Error inserting service operations", "error", err)
+			// Put them back so the next flush retries
+			c.operations.mu.Lock()
+			for _, op := range ops {
+				c.operations.pending[op] = struct{}{}
+			}
+			c.operations.mu.Unlock()
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			// ctx is already cancelled, use a short-lived one for the final write
+			finalCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			flush(finalCtx)
+			cancel()
+			return
+		case <-ticker.C:
+			flush(ctx)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"otelservices/internal/models"
+)
+
+func TestOperationTrackerRecordAndDrain(t *testing.T) {
+	tracker := newOperationTracker()
+	start := time.Date(2024, 1, 1, 10, 15, 0, 0, time.UTC)
+
+	spans := []models.Span{
+		{ServiceName: "api", SpanName: "GET /users", SpanKind: "server", StartTime: start},
+		{ServiceName: "api", SpanName: "GET /users", SpanKind: "server", StartTime: start.Add(10 * time.Minute)},
+		{ServiceName: "api", SpanName: "SELECT users", SpanKind: "client", StartTime: start},
+		{ServiceName: "api", SpanName: "GET /users", SpanKind: "server", StartTime: start.Add(1 * time.Hour)},
+	}
+	tracker.Record(spans)
+
+	ops := tracker.Drain()
+	// Same operation within one hour is collapsed, the next hour is a new entry
+	if len(ops) != 3 {
+		t.Fatalf("Expected 3 operations, got %d: %v", len(ops), ops)
+	}
+	for _, op := range ops {
+		if op.Timestamp.Minute() != 0 || op.Timestamp.Second() != 0 {
+			t.Errorf("Expected hour-aligned timestamp, got %v", op.Timestamp)
+		}
+	}
+
+	if ops := tracker.Drain(); ops != nil {
+		t.Errorf("Expected empty drain after reset, got %v", ops)
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+
+	"otelservices/internal/logging"
+	"otelservices/internal/monitoring"
+	"otelservices/internal/pipeline"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/protobuf/proto"
+
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+)
+
+// otlpReceiver serves OTLP over gRPC and, with otlp.enable_http, over HTTP.
+// Requests go through the collector's admission, conversion and sampling
+// into the batch processors, which write to the pipelines the receiver was
+// built in.
+type otlpReceiver struct {
+	c    *Collector
+	grpc *grpc.Server
+	http *http.Server
+}
+
+// newOTLPReceiver makes the batch processors write to the named pipelines
+func (c *Collector) newOTLPReceiver(pipelines []string) (pipeline.Receiver, error) {
+	c.ingest = pipelines
+	return &otlpReceiver{c: c}, nil
+}
+
+func (r *otlpReceiver) Start(ctx context.Context, _ pipeline.Consumer) error {
+	c := r.c
+	cfg := c.config
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", cfg.OTLP.GRPCPort))
+	if err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+
+	r.grpc = grpc.NewServer(
+		grpc.ChainUnaryInterceptor(monitoring.GRPCUnaryTracing(), c.inFlight.unaryInterceptor),
+		grpc.StatsHandler(c.stats),
+	)
+	coltracepb.RegisterTraceServiceServer(r.grpc, c.trace)
+	colmetricspb.RegisterMetricsServiceServer(r.grpc, c.metrics)
+	collogspb.RegisterLogsServiceServer(r.grpc, c.logs)
+	reflection.Register(r.grpc)
+
+	logger.Info("OTLP HTTP endpoint", "enabled", cfg.OTLP.EnableHTTP, "port", cfg.OTLP.HTTPPort)
+	if cfg.OTLP.EnableHTTP {
+		httpMux := http.NewServeMux()
+		httpMux.HandleFunc("/v1/traces", c.handleHTTPTraces)
+		httpMux.HandleFunc("/v1/metrics", c.handleHTTPMetrics)
+		httpMux.HandleFunc("/v1/logs", c.handleHTTPLogs)
+
+		r.http = &http.Server{
+			Addr:           fmt.Sprintf(":%d", cfg.OTLP.HTTPPort),
+			Handler:        monitoring.HTTPTracing(monitoring.RequestLogger(c.inFlight.httpHandler(httpMux))),
+			ReadTimeout:    cfg.Server.ReadTimeout,
+			WriteTimeout:   cfg.Server.WriteTimeout,
+			IdleTimeout:    cfg.Server.IdleTimeout,
+			MaxHeaderBytes: cfg.Server.MaxHeaderBytes,
+		}
+
+		go func() {
+			logger.Info("OTLP HTTP server started", "port", cfg.OTLP.HTTPPort)
+			if err := r.http.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				logger.Error("HTTP server error", "error", err)
+			}
+		}()
+	}
+
+	go func() {
+		if err := r.grpc.Serve(lis); err != nil {
+			logging.Fatal(logger, "Failed to serve", "error", err)
+		}
+	}()
+	logger.Info("OTLP receiver started", "grpc_port", cfg.OTLP.GRPCPort)
+	return nil
+}
+
+// Shutdown stops accepting requests and waits for those in progress, which
+// leaves what they accepted in the batch processors
+func (r *otlpReceiver) Shutdown(ctx context.Context) error {
+	var err error
+	if r.http != nil {
+		if err = r.http.Shutdown(ctx); err != nil {
+			logger.Error("HTTP server shutdown error", "error", err)
+		}
+	}
+	r.grpc.GracefulStop()
+	return err
+}
+
+// HTTP handlers for OTLP over HTTP
+func (c *Collector) handleHTTPTraces(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	req := &coltracepb.ExportTraceServiceRequest{}
+	if err := proto.Unmarshal(body, req); err != nil {
+		http.Error(w, "Failed to parse protobuf request", http.StatusBadRequest)
+		return
+	}
+
+	resp, err := c.trace.Export(withPeer(r), req)
+	if err == errShuttingDown {
+		http.Error(w, "Collector is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	if err == errMemoryLimit {
+		http.Error(w, "Collector memory limit exceeded", http.StatusTooManyRequests)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Export failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	respBytes, err := proto.Marshal(resp)
+	if err != nil {
+		http.Error(w, "Failed to marshal response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.WriteHeader(http.StatusOK)
+	w.Write(respBytes)
+}
+
+func (c *Collector) handleHTTPMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	req := &colmetricspb.ExportMetricsServiceRequest{}
+	if err := proto.Unmarshal(body, req); err != nil {
+		http.Error(w, "Failed to parse protobuf request", http.StatusBadRequest)
+		return
+	}
+
+	resp, err := c.metrics.Export(r.Context(), req)
+	if err == errShuttingDown {
+		http.Error(w, "Collector is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	if err == errMemoryLimit {
+		http.Error(w, "Collector memory limit exceeded", http.StatusTooManyRequests)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Export failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	respBytes, err := proto.Marshal(resp)
+	if err != nil {
+		http.Error(w, "Failed to marshal response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.WriteHeader(http.StatusOK)
+	w.Write(respBytes)
+}
+
+func (c *Collector) handleHTTPLogs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	req := &collogspb.ExportLogsServiceRequest{}
+	if err := proto.Unmarshal(body, req); err != nil {
+		http.Error(w, "Failed to parse protobuf request", http.StatusBadRequest)
+		return
+	}
+
+	resp, err := c.logs.Export(withPeer(r), req)
+	if err == errShuttingDown {
+		http.Error(w, "Collector is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	if err == errMemoryLimit {
+		http.Error(w, "Collector memory limit exceeded", http.StatusTooManyRequests)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Export failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	respBytes, err := proto.Marshal(resp)
+	if err != nil {
+		http.Error(w, "Failed to marshal response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.WriteHeader(http.StatusOK)
+	w.Write(respBytes)
+}
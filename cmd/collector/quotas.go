@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"otelservices/internal/clickhouse"
+	"otelservices/internal/config"
+	"otelservices/internal/models"
+	"otelservices/internal/monitoring"
+
+	"google.golang.org/protobuf/proto"
+
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+// quotaExceededMessage is the error message of the partial success returned
+// for data rejected by a quota
+const quotaExceededMessage = "ingest quota exceeded"
+
+// usageStore persists ingest usage; *clickhouse.Client implements it
+type usageStore interface {
+	InsertIngestUsage(ctx context.Context, usage []models.IngestUsage) error
+	IngestUsageSince(ctx context.Context, since time.Time, serviceName, serviceNamespace string) (bytes, items uint64, err error)
+}
+
+// usageKey identifies the usage of one service's signal during one hour
+type usageKey struct {
+	hour      time.Time
+	service   string
+	namespace string
+	signal    string
+}
+
+type usageCount struct {
+	bytes uint64
+	items uint64
+}
+
+// quotaUsage is what a quota's services used in the period starting at period
+type quotaUsage struct {
+	period time.Time
+	usageCount
+}
+
+// quotaTracker enforces per-service and per-tenant ingest quotas. Accepted
+// usage is kept per hour until the next flush writes it to the
+// otel_ingest_usage table; each flush then reloads the period totals, which
+// include the usage of every other collector instance. Without a store,
+// quotas are enforced on this instance's usage alone. A nil quotaTracker
+// admits everything.
+type quotaTracker struct {
+	limits []config.Quota
+	store  usageStore
+	now    func() time.Time
+
+	mu      sync.Mutex
+	pending map[usageKey]usageCount
+	used    []quotaUsage // per limit
+}
+
+func newQuotaTracker(cfg config.QuotasConfig, chClient *clickhouse.Client) *quotaTracker {
+	if !cfg.Enabled {
+		return nil
+	}
+	q := &quotaTracker{
+		limits:  cfg.Limits,
+		now:     time.Now,
+		pending: make(map[usageKey]usageCount),
+		used:    make([]quotaUsage, len(cfg.Limits)),
+	}
+	if chClient != nil {
+		q.store = chClient
+	}
+	return q
+}
+
+// admit charges a resource's items to the quotas of its service and reports
+// whether they fit. A resource that would exceed any quota is rejected as a
+// whole and counted in stats.
+func (q *quotaTracker) admit(signal string, resource *resourcepb.Resource, bytes int, items uint64, stats *ingestStats) bool {
+	if q == nil {
+		return true
+	}
+	service := extractStringAttribute(resource, "service.name")
+	namespace := extractStringAttribute(resource, "service.namespace")
+	if q.charge(signal, service, namespace, usageCount{bytes: uint64(bytes), items: items}) {
+		return true
+	}
+	monitoring.QuotaRejectedItems.WithLabelValues(signal, service).Add(float64(items))
+	stats.rejected(signal, service, bytes, items)
+	return false
+}
+
+func (q *quotaTracker) charge(signal, service, namespace string, c usageCount) bool {
+	now := q.now().UTC()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i := range q.limits {
+		l := &q.limits[i]
+		if !l.Matches(service, namespace) {
+			continue
+		}
+		u := q.current(i, now)
+		if (l.MaxBytes > 0 && u.bytes+c.bytes > uint64(l.MaxBytes)) || (l.MaxItems > 0 && u.items+c.items > uint64(l.MaxItems)) {
+			return false
+		}
+	}
+	for i := range q.limits {
+		if q.limits[i].Matches(service, namespace) {
+			u := &q.used[i]
+			u.bytes += c.bytes
+			u.items += c.items
+		}
+	}
+	if q.store != nil {
+		key := usageKey{hour: now.Truncate(time.Hour), service: service, namespace: namespace, signal: signal}
+		p := q.pending[key]
+		p.bytes += c.bytes
+		p.items += c.items
+		q.pending[key] = p
+	}
+	return true
+}
+
+// current returns the usage of limit i, starting over when a new period
+// began. The caller holds mu.
+func (q *quotaTracker) current(i int, now time.Time) *quotaUsage {
+	u := &q.used[i]
+	if start := q.limits[i].PeriodStart(now); !u.period.Equal(start) {
+		*u = quotaUsage{period: start}
+	}
+	return u
+}
+
+// flush writes the pending usage and reloads the usage of every quota's
+// current period
+func (q *quotaTracker) flush(ctx context.Context) {
+	q.mu.Lock()
+	pending := q.pending
+	q.pending = make(map[usageKey]usageCount)
+	q.mu.Unlock()
+
+	if len(pending) > 0 {
+		usage := make([]models.IngestUsage, 0, len(pending))
+		for k, c := range pending {
+			usage = append(usage, models.IngestUsage{
+				Timestamp:        k.hour,
+				ServiceName:      k.service,
+				ServiceNamespace: k.namespace,
+				Signal:           k.signal,
+				Bytes:            c.bytes,
+				Items:            c.items,
+			})
+		}
+		if err := q.store.InsertIngestUsage(ctx, usage); err != nil {
+			logger.Error("Error inserting ingest usage", "error", err)
+			monitoring.QuotaUsageErrors.Inc()
+			// Put it back so the next flush retries
+			q.mu.Lock()
+			for k, c := range pending {
+				p := q.pending[k]
+				p.bytes += c.bytes
+				p.items += c.items
+				q.pending[k] = p
+			}
+			q.mu.Unlock()
+		}
+	}
+
+	now := q.now().UTC()
+	for i := range q.limits {
+		l := &q.limits[i]
+		start := l.PeriodStart(now)
+		bytes, items, err := q.store.IngestUsageSince(ctx, start, l.ServiceName, l.ServiceNamespace)
+		if err != nil {
+			logger.Error("Error reading ingest usage", "error", err)
+			monitoring.QuotaUsageErrors.Inc()
+			continue
+		}
+		// Usage not written yet is not in the table
+		q.mu.Lock()
+		for k, c := range q.pending {
+			if !k.hour.Before(start) && l.Matches(k.service, k.namespace) {
+				bytes += c.bytes
+				items += c.items
+			}
+		}
+		q.used[i] = quotaUsage{period: start, usageCount: usageCount{bytes: bytes, items: items}}
+		q.mu.Unlock()
+	}
+}
+
+// processUsage loads the quota usage at startup and then periodically
+// flushes it
+func (c *Collector) processUsage(ctx context.Context) {
+	defer c.wg.Done()
+	c.quotas.flush(ctx)
+	ticker := time.NewTicker(c.config.Quotas.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// ctx is already cancelled, use a short-lived one for the final write
+			finalCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			c.quotas.flush(finalCtx)
+			cancel()
+			return
+		case <-ticker.C:
+			c.quotas.flush(ctx)
+		}
+	}
+}
+
+// admitTraces returns req without the resources over quota and the number
+// of spans rejected
+func (tc *TraceCollector) admitTraces(req *coltracepb.ExportTraceServiceRequest) (*coltracepb.ExportTraceServiceRequest, int64) {
+	if tc.quotas == nil {
+		return req, 0
+	}
+	var rejected int64
+	kept := make([]*tracepb.ResourceSpans, 0, len(req.ResourceSpans))
+	for _, rs := range req.ResourceSpans {
+		var spans int
+		for _, ss := range rs.ScopeSpans {
+			spans += len(ss.Spans)
+		}
+		if tc.quotas.admit(signalTraces, rs.Resource, proto.Size(rs), uint64(spans), tc.stats) {
+			kept = append(kept, rs)
+			continue
+		}
+		rejected += int64(spans)
+	}
+	if len(kept) == len(req.ResourceSpans) {
+		return req, 0
+	}
+	return &coltracepb.ExportTraceServiceRequest{ResourceSpans: kept}, rejected
+}
+
+// admitMetrics returns req without the resources over quota and the number
+// of data points rejected. Quotas count metrics, like the ingest stats.
+func (mc *MetricsCollector) admitMetrics(req *colmetricspb.ExportMetricsServiceRequest) (*colmetricspb.ExportMetricsServiceRequest, int64) {
+	if mc.quotas == nil {
+		return req, 0
+	}
+	var rejected int64
+	kept := make([]*metricspb.ResourceMetrics, 0, len(req.ResourceMetrics))
+	for _, rm := range req.ResourceMetrics {
+		var metrics int
+		for _, sm := range rm.ScopeMetrics {
+			metrics += len(sm.Metrics)
+		}
+		if mc.quotas.admit(signalMetrics, rm.Resource, proto.Size(rm), uint64(metrics), mc.stats) {
+			kept = append(kept, rm)
+			continue
+		}
+		for _, sm := range rm.ScopeMetrics {
+			for _, m := range sm.Metrics {
+				rejected += int64(dataPointCount(m))
+			}
+		}
+	}
+	if len(kept) == len(req.ResourceMetrics) {
+		return req, 0
+	}
+	return &colmetricspb.ExportMetricsServiceRequest{ResourceMetrics: kept}, rejected
+}
+
+// dataPointCount returns the number of data points of a metric
+func dataPointCount(m *metricspb.Metric) int {
+	switch data := m.Data.(type) {
+	case *metricspb.Metric_Gauge:
+		return len(data.Gauge.DataPoints)
+	case *metricspb.Metric_Sum:
+		return len(data.Sum.DataPoints)
+	case *metricspb.Metric_Histogram:
+		return len(data.Histogram.DataPoints)
+	case *metricspb.Metric_ExponentialHistogram:
+		return len(data.ExponentialHistogram.DataPoints)
+	case *metricspb.Metric_Summary:
+		return len(data.Summary.DataPoints)
+	}
+	return 0
+}
+
+// admitLogs returns req without the resources over quota and the number of
+// log records rejected
+func (lc *LogsCollector) admitLogs(req *collogspb.ExportLogsServiceRequest) (*collogspb.ExportLogsServiceRequest, int64) {
+	if lc.quotas == nil {
+		return req, 0
+	}
+	var rejected int64
+	kept := make([]*logspb.ResourceLogs, 0, len(req.ResourceLogs))
+	for _, rl := range req.ResourceLogs {
+		var records int
+		for _, sl := range rl.ScopeLogs {
+			records += len(sl.LogRecords)
+		}
+		if lc.quotas.admit(signalLogs, rl.Resource, proto.Size(rl), uint64(records), lc.stats) {
+			kept = append(kept, rl)
+			continue
+		}
+		rejected += int64(records)
+	}
+	if len(kept) == len(req.ResourceLogs) {
+		return req, 0
+	}
+	return &collogspb.ExportLogsServiceRequest{ResourceLogs: kept}, rejected
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"otelservices/internal/config"
+	"otelservices/internal/models"
+
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+// fakeUsageStore keeps inserted usage in memory
+type fakeUsageStore struct {
+	rows      []models.IngestUsage
+	insertErr error
+}
+
+func (s *fakeUsageStore) InsertIngestUsage(ctx context.Context, usage []models.IngestUsage) error {
+	if s.insertErr != nil {
+		return s.insertErr
+	}
+	s.rows = append(s.rows, usage...)
+	return nil
+}
+
+func (s *fakeUsageStore) IngestUsageSince(ctx context.Context, since time.Time, serviceName, serviceNamespace string) (bytes, items uint64, err error) {
+	for _, r := range s.rows {
+		if r.Timestamp.Before(since) {
+			continue
+		}
+		if (serviceName != "" && r.ServiceName == serviceName) || (serviceName == "" && r.ServiceNamespace == serviceNamespace) {
+			bytes += r.Bytes
+			items += r.Items
+		}
+	}
+	return bytes, items, nil
+}
+
+func newTestQuotaTracker(store usageStore, now *time.Time, limits ...config.Quota) *quotaTracker {
+	q := newQuotaTracker(config.QuotasConfig{Enabled: true, FlushInterval: time.Minute, Limits: limits}, nil)
+	q.store = store
+	q.now = func() time.Time { return *now }
+	return q
+}
+
+func serviceResource(service, namespace string) *resourcepb.Resource {
+	return &resourcepb.Resource{Attributes: []*commonpb.KeyValue{
+		{Key: "service.name", Value: stringValue(service)},
+		{Key: "service.namespace", Value: stringValue(namespace)},
+	}}
+}
+
+func TestQuotaTrackerAdmit(t *testing.T) {
+	now := time.Date(2024, 3, 15, 10, 0, 0, 0, time.UTC)
+	q := newTestQuotaTracker(nil, &now,
+		config.Quota{ServiceName: "checkout", Period: config.QuotaDaily, MaxItems: 10},
+		config.Quota{ServiceNamespace: "shop", Period: config.QuotaMonthly, MaxBytes: 1000},
+	)
+	stats := newIngestStats()
+	checkout := serviceResource("checkout", "shop")
+
+	if !q.admit(signalTraces, checkout, 100, 6, stats) {
+		t.Fatal("first batch should fit")
+	}
+	if q.admit(signalTraces, checkout, 100, 6, stats) {
+		t.Error("batch over the item quota should be rejected")
+	}
+	if !q.admit(signalLogs, serviceResource("cart", "shop"), 850, 1, stats) {
+		t.Error("cart only has the tenant quota")
+	}
+	if q.admit(signalTraces, checkout, 100, 1, stats) {
+		t.Error("batch over the tenant byte quota should be rejected")
+	}
+	if !q.admit(signalTraces, serviceResource("search", "other"), 1<<20, 1000, stats) {
+		t.Error("services without a quota are not limited")
+	}
+
+	// The daily quota starts over, the monthly one does not
+	now = now.Add(24 * time.Hour)
+	if q.admit(signalTraces, checkout, 100, 1, stats) {
+		t.Error("tenant byte quota should still be exhausted")
+	}
+	if !q.admit(signalTraces, checkout, 10, 10, stats) {
+		t.Error("daily item quota should have started over")
+	}
+
+	snap := stats.snapshot()
+	for _, s := range snap.Services {
+		if s.ServiceName == "checkout" && (s.Rejected != 8 || s.Received != 8) {
+			t.Errorf("checkout stats = %+v", s)
+		}
+	}
+}
+
+func TestQuotaTrackerFlush(t *testing.T) {
+	now := time.Date(2024, 3, 15, 10, 30, 0, 0, time.UTC)
+	store := &fakeUsageStore{rows: []models.IngestUsage{
+		// Written by another collector instance
+		{Timestamp: time.Date(2024, 3, 15, 9, 0, 0, 0, time.UTC), ServiceName: "checkout", Signal: signalLogs, Bytes: 500, Items: 5},
+		// Yesterday
+		{Timestamp: time.Date(2024, 3, 14, 9, 0, 0, 0, time.UTC), ServiceName: "checkout", Signal: signalLogs, Bytes: 500, Items: 5},
+	}}
+	q := newTestQuotaTracker(store, &now, config.Quota{ServiceName: "checkout", Period: config.QuotaDaily, MaxItems: 10})
+	stats := newIngestStats()
+	checkout := serviceResource("checkout", "shop")
+
+	q.flush(context.Background())
+	if q.admit(signalTraces, checkout, 100, 6, stats) {
+		t.Error("usage of other instances should count")
+	}
+	if !q.admit(signalTraces, checkout, 100, 3, stats) {
+		t.Fatal("batch within the quota should fit")
+	}
+
+	store.insertErr = errors.New("clickhouse unavailable")
+	q.flush(context.Background())
+	if len(q.pending) != 1 {
+		t.Fatalf("failed write should keep the usage pending: %v", q.pending)
+	}
+	if u := q.used[0]; u.items != 8 {
+		t.Errorf("reloaded usage = %d items, want the 5 stored plus 3 pending", u.items)
+	}
+
+	store.insertErr = nil
+	q.flush(context.Background())
+	want := models.IngestUsage{
+		Timestamp: time.Date(2024, 3, 15, 10, 0, 0, 0, time.UTC), ServiceName: "checkout", ServiceNamespace: "shop",
+		Signal: signalTraces, Bytes: 100, Items: 3,
+	}
+	if len(store.rows) != 3 || store.rows[2] != want {
+		t.Errorf("stored usage = %+v, want %+v last", store.rows, want)
+	}
+	if len(q.pending) != 0 || q.used[0].items != 8 {
+		t.Errorf("after flush: pending %v, used %+v", q.pending, q.used[0])
+	}
+}
+
+func TestExportRejectsOverQuota(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Quotas = config.QuotasConfig{Enabled: true, FlushInterval: time.Minute, Limits: []config.Quota{
+		{ServiceName: "checkout", Period: config.QuotaDaily, MaxItems: 1},
+	}}
+	c := NewCollector(cfg, nil)
+
+	spans := func(service string, n int) *tracepb.ResourceSpans {
+		ss := &tracepb.ScopeSpans{}
+		for i := 0; i < n; i++ {
+			ss.Spans = append(ss.Spans, &tracepb.Span{Name: "GET /cart", TraceId: make([]byte, 16)})
+		}
+		return &tracepb.ResourceSpans{Resource: serviceResource(service, ""), ScopeSpans: []*tracepb.ScopeSpans{ss}}
+	}
+	resp, err := c.trace.Export(context.Background(), &coltracepb.ExportTraceServiceRequest{
+		ResourceSpans: []*tracepb.ResourceSpans{spans("checkout", 2), spans("cart", 1)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ps := resp.GetPartialSuccess(); ps.GetRejectedSpans() != 2 || ps.GetErrorMessage() != quotaExceededMessage {
+		t.Errorf("partial success = %v", ps)
+	}
+	if span := <-c.trace.spanChan; span.ServiceName != "cart" || len(c.trace.spanChan) != 0 {
+		t.Errorf("stored span of %q, %d more queued", span.ServiceName, len(c.trace.spanChan))
+	}
+
+	logs := &collogspb.ExportLogsServiceRequest{ResourceLogs: []*logspb.ResourceLogs{{
+		Resource:  serviceResource("checkout", ""),
+		ScopeLogs: []*logspb.ScopeLogs{{LogRecords: []*logspb.LogRecord{{}, {}}}},
+	}}}
+	resp2, err := c.logs.Export(context.Background(), logs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ps := resp2.GetPartialSuccess(); ps.GetRejectedLogRecords() != 2 {
+		t.Errorf("logs partial success = %v", ps)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"otelservices/internal/kafka"
+	"otelservices/internal/monitoring"
+)
+
+// queueSaturation is the fill ratio of a batch queue from which the
+// collector reports itself degraded
+const queueSaturation = 0.9
+
+// addDependencies registers what the readiness probe checks. ClickHouse is
+// not critical with a disk queue, which holds batches while it is down;
+// Kafka is critical when batches are published to it.
+func (c *Collector) addDependencies() {
+	if c.chClient != nil {
+		c.healthCheck.AddDependency(monitoring.Dependency{
+			Name:     "clickhouse",
+			Critical: !c.config.DiskQueue.Enabled,
+			Check:    c.chClient.Ping,
+		})
+	}
+	if c.config.Kafka.Enabled {
+		brokers := c.config.Kafka.Brokers
+		c.healthCheck.AddDependency(monitoring.Dependency{
+			Name:     "kafka",
+			Critical: c.producer != nil && !c.config.DiskQueue.Enabled,
+			Check:    func(ctx context.Context) error { return kafka.Ping(ctx, brokers) },
+		})
+	}
+	c.healthCheck.AddDependency(monitoring.Dependency{Name: "queues", Check: c.checkQueues})
+}
+
+// checkQueues fails when a batch queue is at least queueSaturation full
+func (c *Collector) checkQueues(ctx context.Context) error {
+	queues := []struct {
+		signal           string
+		length, capacity int
+	}{
+		{signalTraces, len(c.trace.spanChan), cap(c.trace.spanChan)},
+		{signalMetrics, len(c.metrics.metricChan), cap(c.metrics.metricChan)},
+		{signalLogs, len(c.logs.logChan), cap(c.logs.logChan)},
+	}
+	var full []string
+	for _, q := range queues {
+		if q.capacity > 0 && float64(q.length) >= queueSaturation*float64(q.capacity) {
+			full = append(full, fmt.Sprintf("%s queue %d%% full", q.signal, q.length*100/q.capacity))
+		}
+	}
+	if len(full) > 0 {
+		return errors.New(strings.Join(full, ", "))
+	}
+	return nil
+}
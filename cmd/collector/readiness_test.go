@@ -0,0 +1,27 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"otelservices/internal/models"
+)
+
+func TestCheckQueues(t *testing.T) {
+	c := &Collector{
+		trace:   &TraceCollector{spanChan: make(chan models.Span, 10)},
+		metrics: &MetricsCollector{metricChan: make(chan models.Metric, 10)},
+		logs:    &LogsCollector{logChan: make(chan models.LogRecord, 10)},
+	}
+	for i := 0; i < 8; i++ {
+		c.logs.logChan <- models.LogRecord{}
+	}
+	if err := c.checkQueues(context.Background()); err != nil {
+		t.Errorf("80%% full queue reported: %v", err)
+	}
+	c.logs.logChan <- models.LogRecord{}
+	err := c.checkQueues(context.Background())
+	if err == nil || err.Error() != "logs queue 90% full" {
+		t.Errorf("err = %v, want logs queue 90%% full", err)
+	}
+}
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"otelservices/internal/config"
+	"otelservices/internal/logging"
+)
+
+// applyConfig applies the dynamic settings of a reloaded config: log level
+// and format, sampling policies and batch sizing
+func (c *Collector) applyConfig(cfg *config.Config) {
+	if err := logging.Setup(cfg.Monitoring.LogLevel, cfg.Monitoring.LogFormat); err != nil {
+		logger.Error("Failed to apply log settings", "error", err)
+	}
+	c.trace.sampler.set(cfg.Sampling)
+	for signal, sizer := range c.batchSizers {
+		sizer.configure(cfg.Performance.ForSignal(signal))
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"otelservices/internal/config"
+)
+
+func TestApplyConfig(t *testing.T) {
+	cfg := config.DefaultConfig()
+	c := NewCollector(cfg, nil)
+	if c.trace.sampler.get() != nil {
+		t.Fatal("sampling should start disabled")
+	}
+
+	next := *cfg
+	next.Sampling = config.SamplingConfig{
+		Enabled:  true,
+		Policies: []config.SamplingPolicy{{Name: "default", Rate: 0.1}},
+	}
+	next.Performance.BatchSize = 200
+	next.Performance.BatchTimeout = time.Second
+	next.Performance.MaxBatchBytes = 1 << 20
+	c.applyConfig(&next)
+
+	s := c.logs.sampler.get()
+	if s == nil || s.policies[0].Rate != 0.1 {
+		t.Fatalf("reloaded sampling policies not in use: %+v", s)
+	}
+	sizer := c.batchSizers[signalLogs]
+	if sizer.Size() != 200 || sizer.Timeout() != time.Second {
+		t.Errorf("batch settings not applied: size %d, timeout %v", sizer.Size(), sizer.Timeout())
+	}
+	if !sizer.Full(10, 1<<20) || sizer.Full(10, 100) {
+		t.Error("max_batch_bytes not applied")
+	}
+
+	// An unchanged sampling config keeps the sampler and its decisions
+	c.applyConfig(&next)
+	if c.trace.sampler.get() != s {
+		t.Error("sampler replaced although its config did not change")
+	}
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"otelservices/internal/clickhouse"
+	"otelservices/internal/monitoring"
+)
+
+// rollupLagLookback limits how far back the lag queries scan; a rollup with
+// no window in this range reports the whole lookback as lag
+const rollupLagLookback = 24 * time.Hour
+
+// rollup describes one aggregated copy of otel_metrics and the materialized
+// view that fills it. The DDL matches schema/001_create_otel_metrics.sql.
+type rollup struct {
+	Table  string
+	View   string
+	Bucket string // ClickHouse function truncating a timestamp to the window
+	Window time.Duration
+	TTL    string
+}
+
+var rollups = []rollup{
+	{Table: "otel_metrics_5m", View: "otel_metrics_5m_mv", Bucket: "toStartOfFiveMinutes", Window: 5 * time.Minute, TTL: "90 DAY"},
+	{Table: "otel_metrics_1h", View: "otel_metrics_1h_mv", Bucket: "toStartOfHour", Window: time.Hour, TTL: "1 YEAR"},
+}
+
+// rollupColumns are the columns the query service reads from a rollup table
+var rollupColumns = []string{
+	"timestamp", "metric_name", "service_name", "metric_type", "attributes_hash",
+	"value_min", "value_max", "value_sum", "value_count", "attributes",
+}
+
+func (r rollup) tableDDL() string {
+	return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+    timestamp DateTime CODEC(Delta, ZSTD(3)),
+    metric_name LowCardinality(String) CODEC(ZSTD(3)),
+    service_name LowCardinality(String) CODEC(ZSTD(3)),
+    metric_type Enum8('gauge' = 1, 'counter' = 2, 'histogram' = 3, 'summary' = 4) CODEC(ZSTD(3)),
+    attributes_hash UInt64 CODEC(ZSTD(3)),
+    value_min SimpleAggregateFunction(min, Float64) CODEC(ZSTD(3)),
+    value_max SimpleAggregateFunction(max, Float64) CODEC(ZSTD(3)),
+    value_sum SimpleAggregateFunction(sum, Float64) CODEC(ZSTD(3)),
+    value_count SimpleAggregateFunction(sum, UInt64) CODEC(ZSTD(3)),
+    attributes SimpleAggregateFunction(any, Map(String, String)) CODEC(ZSTD(3))
+)
+ENGINE = AggregatingMergeTree()
+PARTITION BY toYYYYMM(timestamp)
+ORDER BY (timestamp, metric_name, service_name, metric_type, attributes_hash)
+TTL timestamp + INTERVAL %s
+SETTINGS index_granularity = 8192`, r.Table, r.TTL)
+}
+
+func (r rollup) viewDDL() string {
+	return fmt.Sprintf(`CREATE MATERIALIZED VIEW IF NOT EXISTS %s
+TO %s
+AS SELECT
+    %s(timestamp) AS timestamp,
+    metric_name,
+    service_name,
+    metric_type,
+    cityHash64(mapKeys(attributes), mapValues(attributes)) AS attributes_hash,
+    min(value) AS value_min,
+    max(value) AS value_max,
+    sum(value) AS value_sum,
+    count() AS value_count,
+    any(attributes) AS attributes
+FROM otel_metrics
+GROUP BY timestamp, metric_name, service_name, metric_type, attributes_hash`, r.View, r.Table, r.Bucket)
+}
+
+// schemaObject is a table or view as reported by system.tables
+type schemaObject struct {
+	Engine      string
+	CreateQuery string
+	Columns     map[string]bool
+}
+
+// verify returns what is wrong with the rollup in the given schema, or nil
+// when its table and view are usable. local is the table holding the rows,
+// which on a cluster sits behind a Distributed table named r.Table.
+func (r rollup) verify(objects map[string]*schemaObject, local string) []string {
+	var problems []string
+	table, ok := objects[local]
+	switch {
+	case !ok:
+		problems = append(problems, fmt.Sprintf("table %s does not exist", local))
+	case !strings.HasSuffix(table.Engine, "AggregatingMergeTree"):
+		problems = append(problems, fmt.Sprintf("table %s has engine %s, expected AggregatingMergeTree", local, table.Engine))
+	default:
+		for _, col := range rollupColumns {
+			if !table.Columns[col] {
+				problems = append(problems, fmt.Sprintf("table %s is missing column %s", local, col))
+			}
+		}
+	}
+	if local != r.Table {
+		if dist, ok := objects[r.Table]; !ok {
+			problems = append(problems, fmt.Sprintf("table %s does not exist", r.Table))
+		} else if dist.Engine != "Distributed" {
+			problems = append(problems, fmt.Sprintf("table %s has engine %s, expected Distributed", r.Table, dist.Engine))
+		}
+	}
+
+	view, ok := objects[r.View]
+	switch {
+	case !ok:
+		problems = append(problems, fmt.Sprintf("view %s does not exist", r.View))
+	case view.Engine != "MaterializedView":
+		problems = append(problems, fmt.Sprintf("%s has engine %s, expected MaterializedView", r.View, view.Engine))
+	case !viewTargets(view.CreateQuery, local):
+		problems = append(problems, fmt.Sprintf("view %s does not write to %s", r.View, local))
+	}
+	return problems
+}
+
+// viewTargets reports whether a CREATE MATERIALIZED VIEW statement writes to
+// table, with or without a database prefix
+func viewTargets(createQuery, table string) bool {
+	fields := strings.Fields(createQuery)
+	for i := 0; i+1 < len(fields); i++ {
+		if !strings.EqualFold(fields[i], "TO") {
+			continue
+		}
+		target := strings.Trim(fields[i+1], "`")
+		if target == table || strings.HasSuffix(target, "."+table) || strings.HasSuffix(target, ".`"+table) {
+			return true
+		}
+	}
+	return false
+}
+
+// rollupLag is how far the newest rollup window trails the window holding the
+// newest raw metric. max() over no rows yields the zero time or the epoch, so
+// anything before since counts as empty.
+func rollupLag(source, latest, since time.Time, window time.Duration) time.Duration {
+	if !source.After(since) {
+		return 0
+	}
+	if !latest.After(since) {
+		latest = since
+	}
+	lag := source.UTC().Truncate(window).Sub(latest)
+	if lag < 0 {
+		return 0
+	}
+	return lag
+}
+
+// rollupManager creates the rollup tables and views, checks them at startup
+// and reports how far behind the raw metrics they are
+type rollupManager struct {
+	chClient *clickhouse.Client
+	interval time.Duration
+}
+
+func newRollupManager(chClient *clickhouse.Client, interval time.Duration) *rollupManager {
+	return &rollupManager{chClient: chClient, interval: interval}
+}
+
+// inspect loads the rollup tables and views present in the current database
+func (m *rollupManager) inspect(ctx context.Context) (map[string]*schemaObject, error) {
+	var names []string
+	for _, r := range rollups {
+		names = append(names, r.Table, m.chClient.LocalTable(r.Table), r.View)
+	}
+
+	rows, err := m.chClient.Query(ctx, `
+		SELECT name, engine, create_table_query
+		FROM system.tables
+		WHERE database = currentDatabase() AND name IN ?`, names)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list rollup tables: %w", err)
+	}
+	defer rows.Close()
+
+	objects := make(map[string]*schemaObject)
+	for rows.Next() {
+		var name string
+		obj := &schemaObject{Columns: make(map[string]bool)}
+		if err := rows.Scan(&name, &obj.Engine, &obj.CreateQuery); err != nil {
+			return nil, fmt.Errorf("failed to scan rollup table: %w", err)
+		}
+		objects[name] = obj
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	cols, err := m.chClient.Query(ctx, `
+		SELECT table, name
+		FROM system.columns
+		WHERE database = currentDatabase() AND table IN ?`, names)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list rollup columns: %w", err)
+	}
+	defer cols.Close()
+	for cols.Next() {
+		var table, name string
+		if err := cols.Scan(&table, &name); err != nil {
+			return nil, fmt.Errorf("failed to scan rollup column: %w", err)
+		}
+		if obj, ok := objects[table]; ok {
+			obj.Columns[name] = true
+		}
+	}
+	return objects, cols.Err()
+}
+
+// ensure creates missing rollup tables and views, then verifies all of them.
+// Views created here only aggregate metrics inserted from now on.
+func (m *rollupManager) ensure(ctx context.Context) error {
+	objects, err := m.inspect(ctx)
+	if err != nil {
+		return err
+	}
+	for _, r := range rollups {
+		if _, ok := objects[m.chClient.LocalTable(r.Table)]; !ok {
+			if err := m.create(ctx, r.tableDDL()); err != nil {
+				return fmt.Errorf("failed to create %s: %w", r.Table, err)
+			}
+			logger.Info("Created rollup table", "table", r.Table)
+		}
+		if _, ok := objects[r.View]; !ok {
+			if err := m.create(ctx, r.viewDDL()); err != nil {
+				return fmt.Errorf("failed to create %s: %w", r.View, err)
+			}
+			logger.Info("Created rollup view", "view", r.View)
+		}
+	}
+
+	objects, err = m.inspect(ctx)
+	if err != nil {
+		return err
+	}
+	var problems []string
+	for _, r := range rollups {
+		problems = append(problems, r.verify(objects, m.chClient.LocalTable(r.Table))...)
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("rollup schema mismatch: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// handleEnsure creates and verifies the rollups on request, for deployments
+// that leave rollups.manage off
+func (m *rollupManager) handleEnsure(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := m.ensure(r.Context()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	tables := make([]string, 0, len(rollups))
+	for _, rollup := range rollups {
+		tables = append(tables, rollup.Table)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]string{"rollups": tables})
+}
+
+// create runs the statements creating a schema object on the configured
+// deployment. They all use IF NOT EXISTS, so a partly created cluster
+// object is completed.
+func (m *rollupManager) create(ctx context.Context, ddl string) error {
+	stmts, err := m.chClient.SchemaDDL(ddl)
+	if err != nil {
+		return err
+	}
+	for _, stmt := range stmts {
+		if err := m.chClient.Exec(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// measure updates the rollup lag gauges
+func (m *rollupManager) measure(ctx context.Context, now time.Time) {
+	since := now.Add(-rollupLagLookback)
+	var source time.Time
+	if err := m.chClient.QueryRow(ctx, "SELECT max(timestamp) FROM otel_metrics WHERE timestamp >= ?", since).Scan(&source); err != nil {
+		logger.Error("Error measuring rollup lag", "error", err)
+		for _, r := range rollups {
+			monitoring.RollupErrors.WithLabelValues(r.Table).Inc()
+		}
+		return
+	}
+	for _, r := range rollups {
+		var latest time.Time
+		query := fmt.Sprintf("SELECT max(timestamp) FROM %s WHERE timestamp >= ?", r.Table)
+		if err := m.chClient.QueryRow(ctx, query, since).Scan(&latest); err != nil {
+			logger.Error("Error measuring rollup lag", "table", r.Table, "error", err)
+			monitoring.RollupErrors.WithLabelValues(r.Table).Inc()
+			continue
+		}
+		monitoring.RollupLag.WithLabelValues(r.Table).Set(rollupLag(source, latest, since, r.Window).Seconds())
+	}
+}
+
+// run measures rollup lag every interval until ctx is cancelled
+func (m *rollupManager) run(ctx context.Context) {
+	if m.interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			m.measure(ctx, now)
+		}
+	}
+}
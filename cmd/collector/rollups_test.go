@@ -0,0 +1,145 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func healthyRollupSchema() map[string]*schemaObject {
+	objects := make(map[string]*schemaObject)
+	for _, r := range rollups {
+		cols := make(map[string]bool)
+		for _, c := range rollupColumns {
+			cols[c] = true
+		}
+		objects[r.Table] = &schemaObject{Engine: "AggregatingMergeTree", Columns: cols}
+		objects[r.View] = &schemaObject{
+			Engine:      "MaterializedView",
+			CreateQuery: "CREATE MATERIALIZED VIEW otel." + r.View + " TO otel." + r.Table + " AS SELECT 1",
+		}
+	}
+	return objects
+}
+
+func TestRollupDDL(t *testing.T) {
+	for _, r := range rollups {
+		table := r.tableDDL()
+		for _, col := range rollupColumns {
+			if !strings.Contains(table, "\n    "+col+" ") {
+				t.Errorf("%s DDL is missing column %s", r.Table, col)
+			}
+		}
+		if !strings.Contains(table, "ENGINE = AggregatingMergeTree()") {
+			t.Errorf("%s DDL does not use AggregatingMergeTree", r.Table)
+		}
+
+		view := r.viewDDL()
+		if !strings.Contains(view, "TO "+r.Table+"\n") || !strings.Contains(view, r.Bucket+"(timestamp)") {
+			t.Errorf("unexpected %s DDL:\n%s", r.View, view)
+		}
+		if !viewTargets(view, r.Table) {
+			t.Errorf("viewTargets does not recognise the generated %s", r.View)
+		}
+	}
+}
+
+func TestRollupVerify(t *testing.T) {
+	objects := healthyRollupSchema()
+	for _, r := range rollups {
+		if problems := r.verify(objects, r.Table); len(problems) > 0 {
+			t.Errorf("%s: unexpected problems %v", r.Table, problems)
+		}
+	}
+
+	r := rollups[0]
+	objects[r.Table].Engine = "ReplicatedAggregatingMergeTree"
+	if problems := r.verify(objects, r.Table); len(problems) > 0 {
+		t.Errorf("replicated engine rejected: %v", problems)
+	}
+
+	// A table created before the switch to mergeable partials
+	objects[r.Table].Engine = "SummingMergeTree"
+	delete(objects[r.Table].Columns, "attributes_hash")
+	objects[r.View].CreateQuery = "CREATE MATERIALIZED VIEW otel.x TO otel.otel_metrics_1h AS SELECT 1"
+	problems := r.verify(objects, r.Table)
+	if len(problems) != 2 {
+		t.Fatalf("expected 2 problems, got %v", problems)
+	}
+	if !strings.Contains(problems[0], "SummingMergeTree") || !strings.Contains(problems[1], "does not write to") {
+		t.Errorf("unexpected problems %v", problems)
+	}
+
+	objects[r.Table].Engine = "AggregatingMergeTree"
+	delete(objects[r.Table].Columns, "value_count")
+	delete(objects, r.View)
+	problems = r.verify(objects, r.Table)
+	if len(problems) != 3 || !strings.Contains(problems[0], "attributes_hash") || !strings.Contains(problems[1], "value_count") || !strings.Contains(problems[2], "does not exist") {
+		t.Errorf("unexpected problems %v", problems)
+	}
+}
+
+func TestRollupVerifyCluster(t *testing.T) {
+	r := rollups[0]
+	local := r.Table + "_local"
+	objects := healthyRollupSchema()
+	objects[local] = objects[r.Table]
+	objects[local].Engine = "ReplicatedAggregatingMergeTree"
+	objects[r.Table] = &schemaObject{Engine: "Distributed"}
+	objects[r.View].CreateQuery = "CREATE MATERIALIZED VIEW otel." + r.View + " TO otel." + local + " AS SELECT 1"
+	if problems := r.verify(objects, local); len(problems) > 0 {
+		t.Errorf("unexpected problems %v", problems)
+	}
+
+	// A single-node rollup left behind on a cluster
+	objects[r.Table] = objects[local]
+	objects[r.View].CreateQuery = "CREATE MATERIALIZED VIEW otel." + r.View + " TO otel." + r.Table + " AS SELECT 1"
+	problems := r.verify(objects, local)
+	if len(problems) != 2 || !strings.Contains(problems[0], "expected Distributed") || !strings.Contains(problems[1], "does not write to "+local) {
+		t.Errorf("unexpected problems %v", problems)
+	}
+}
+
+func TestViewTargets(t *testing.T) {
+	tests := []struct {
+		query string
+		want  bool
+	}{
+		{"CREATE MATERIALIZED VIEW v TO otel_metrics_5m AS SELECT 1", true},
+		{"CREATE MATERIALIZED VIEW otel.v TO otel.otel_metrics_5m (`timestamp` DateTime) AS SELECT 1", true},
+		{"CREATE MATERIALIZED VIEW `otel`.`v` TO `otel`.`otel_metrics_5m` AS SELECT 1", true},
+		{"CREATE MATERIALIZED VIEW v TO otel.otel_metrics_5m_old AS SELECT 1", false},
+		{"CREATE MATERIALIZED VIEW v ENGINE = MergeTree AS SELECT 1", false},
+	}
+	for _, tt := range tests {
+		if got := viewTargets(tt.query, "otel_metrics_5m"); got != tt.want {
+			t.Errorf("viewTargets(%q) = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestRollupLag(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	since := now.Add(-rollupLagLookback)
+	source := time.Date(2024, 3, 1, 11, 58, 30, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		source time.Time
+		latest time.Time
+		window time.Duration
+		want   time.Duration
+	}{
+		{"caught up", source, time.Date(2024, 3, 1, 11, 55, 0, 0, time.UTC), 5 * time.Minute, 0},
+		{"behind", source, time.Date(2024, 3, 1, 11, 40, 0, 0, time.UTC), 5 * time.Minute, 15 * time.Minute},
+		{"hourly caught up", source, time.Date(2024, 3, 1, 11, 0, 0, 0, time.UTC), time.Hour, 0},
+		{"empty rollup", source, time.Unix(0, 0), 5 * time.Minute, source.Truncate(5 * time.Minute).Sub(since)},
+		{"no raw metrics", time.Unix(0, 0), time.Unix(0, 0), 5 * time.Minute, 0},
+		{"rollup ahead", source, now, 5 * time.Minute, 0},
+	}
+	for _, tt := range tests {
+		if got := rollupLag(tt.source, tt.latest, since, tt.window); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
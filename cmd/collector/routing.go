@@ -0,0 +1,418 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"otelservices/internal/config"
+	"otelservices/internal/monitoring"
+
+	"google.golang.org/grpc/metadata"
+
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+// forwardedHeader marks a trace export forwarded by a peer, which is
+// processed where it arrives so peers with different views of the ring
+// cannot forward spans in a loop
+const forwardedHeader = "x-otel-forwarded-by"
+
+// Routing defaults for unset settings
+const (
+	defaultVirtualNodes    = 100
+	defaultRoutingInterval = 30 * time.Second
+)
+
+// k8sNamespaceFile holds the namespace of the collector's pod, a variable
+// for tests
+var k8sNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// hashRing assigns trace IDs to peers by consistent hashing, so a peer
+// joining or leaving moves only the traces of its share of the ring
+type hashRing struct {
+	members []string // sorted
+	points  []uint64 // sorted hashes of the virtual nodes
+	owners  []string // owner of each point
+}
+
+func newHashRing(members []string, virtualNodes int) *hashRing {
+	r := &hashRing{members: append([]string(nil), members...)}
+	sort.Strings(r.members)
+	type point struct {
+		hash  uint64
+		owner string
+	}
+	points := make([]point, 0, len(members)*virtualNodes)
+	for _, m := range r.members {
+		for i := 0; i < virtualNodes; i++ {
+			points = append(points, point{hashKey([]byte(m + "#" + strconv.Itoa(i))), m})
+		}
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].hash < points[j].hash })
+	for _, p := range points {
+		r.points = append(r.points, p.hash)
+		r.owners = append(r.owners, p.owner)
+	}
+	return r
+}
+
+// hashKey hashes a ring key. Trace IDs are hashed rather than used as they
+// are because head sampling keeps the traces with the lowest IDs, which
+// would otherwise all land on the same peers. FNV leaves the last bytes of
+// a key in the low bits only, so its hash is mixed with the MurmurHash3
+// finalizer to spread keys that differ there around the ring.
+func hashKey(key []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(key)
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// owner returns the member owning a trace ID: the first point at or after
+// its hash, wrapping around
+func (r *hashRing) owner(traceID []byte) string {
+	h := hashKey(traceID)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[i]
+}
+
+func (r *hashRing) sameMembers(members []string) bool {
+	if len(members) != len(r.members) {
+		return false
+	}
+	for i := range members {
+		if members[i] != r.members[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// routedPeer is the exporter forwarding spans to one peer
+type routedPeer struct {
+	exporter *otlpExporter
+	cancel   context.CancelFunc
+}
+
+// traceRouter forwards the spans of traces owned by other collectors to
+// them over OTLP gRPC, each peer with its own queue and retries like a
+// downstream exporter
+type traceRouter struct {
+	cfg  config.RoutingConfig
+	perf config.PerformanceConfig
+	self string
+	port int // OTLP gRPC port of discovered endpoints
+
+	k8s       *k8sAPI // nil without Kubernetes discovery
+	namespace string
+
+	ring atomic.Pointer[hashRing] // nil until start
+
+	mu    sync.Mutex
+	ctx   context.Context
+	peers map[string]*routedPeer
+}
+
+// newTraceRouter returns nil when routing is disabled
+func newTraceRouter(cfg *config.Config) (*traceRouter, error) {
+	rc := cfg.Routing
+	if !rc.Enabled {
+		return nil, nil
+	}
+	if rc.VirtualNodes == 0 {
+		rc.VirtualNodes = defaultVirtualNodes
+	}
+	if rc.RefreshInterval == 0 {
+		rc.RefreshInterval = defaultRoutingInterval
+	}
+	r := &traceRouter{cfg: rc, perf: cfg.Performance, self: rc.Self, port: rc.Kubernetes.Port, peers: make(map[string]*routedPeer)}
+	if r.port == 0 {
+		r.port = cfg.OTLP.GRPCPort
+	}
+	if r.self == "" {
+		host := os.Getenv("POD_IP")
+		if host == "" {
+			var err error
+			if host, err = os.Hostname(); err != nil {
+				return nil, fmt.Errorf("failed to find the routing address of this collector; set routing.self: %w", err)
+			}
+		}
+		r.self = net.JoinHostPort(host, strconv.Itoa(cfg.OTLP.GRPCPort))
+	}
+	if rc.Kubernetes.Service != "" {
+		api, err := newK8sAPI(rc.Kubernetes.APIServer, "routing.kubernetes.api_server")
+		if err != nil {
+			return nil, err
+		}
+		r.k8s = api
+		r.namespace = rc.Kubernetes.Namespace
+		if r.namespace == "" {
+			r.namespace = os.Getenv("POD_NAMESPACE")
+		}
+		if r.namespace == "" {
+			ns, err := os.ReadFile(k8sNamespaceFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to find the collector's namespace; set routing.kubernetes.namespace: %w", err)
+			}
+			r.namespace = strings.TrimSpace(string(ns))
+		}
+	}
+	return r, nil
+}
+
+// start puts the static peers on the ring and, with Kubernetes discovery,
+// reads the endpoints now and every refresh interval until ctx is done.
+// Until start every span is processed locally.
+func (r *traceRouter) start(ctx context.Context) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.ctx = ctx
+	r.mu.Unlock()
+	if r.k8s == nil {
+		r.setPeers(r.cfg.Peers)
+		return
+	}
+	r.refresh(ctx)
+	go func() {
+		ticker := time.NewTicker(r.cfg.RefreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				r.refresh(ctx)
+			}
+		}
+	}()
+}
+
+// refresh reads the ready endpoints of the service and puts them on the
+// ring with the static peers. A failed read keeps the ring as it is.
+func (r *traceRouter) refresh(ctx context.Context) {
+	discovered, err := r.endpoints(ctx)
+	if err != nil {
+		monitoring.RoutingDiscoveryErrors.Inc()
+		logger.Warn("Failed to read the routing peers", "service", r.cfg.Kubernetes.Service, "error", err)
+		if r.ring.Load() == nil {
+			r.setPeers(r.cfg.Peers)
+		}
+		return
+	}
+	r.setPeers(append(append([]string(nil), r.cfg.Peers...), discovered...))
+}
+
+// endpoints returns the addresses of the ready endpoints of the service
+func (r *traceRouter) endpoints(ctx context.Context) ([]string, error) {
+	path := "/api/v1/namespaces/" + url.PathEscape(r.namespace) + "/endpoints/" + url.PathEscape(r.cfg.Kubernetes.Service)
+	resp, err := r.k8s.get(ctx, path, url.Values{})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var endpoints struct {
+		Subsets []struct {
+			Addresses []struct {
+				IP string `json:"ip"`
+			} `json:"addresses"`
+		} `json:"subsets"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&endpoints); err != nil {
+		return nil, fmt.Errorf("failed to decode endpoints: %w", err)
+	}
+	var addrs []string
+	for _, subset := range endpoints.Subsets {
+		for _, a := range subset.Addresses {
+			addrs = append(addrs, net.JoinHostPort(a.IP, strconv.Itoa(r.port)))
+		}
+	}
+	return addrs, nil
+}
+
+// setPeers rebuilds the ring from peers and this collector, starting
+// exporters for new peers and stopping those of departed ones, which send
+// what they have queued first
+func (r *traceRouter) setPeers(peers []string) {
+	members := []string{r.self}
+	seen := map[string]bool{r.self: true}
+	for _, p := range peers {
+		if !seen[p] {
+			seen[p] = true
+			members = append(members, p)
+		}
+	}
+	sort.Strings(members)
+	if current := r.ring.Load(); current != nil && current.sameMembers(members) {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, m := range members {
+		if m == r.self || r.peers[m] != nil {
+			continue
+		}
+		e, err := newOTLPExporter(config.ExporterConfig{
+			Name:     "peer " + m,
+			Endpoint: m,
+			Insecure: r.cfg.Insecure,
+			Headers:  map[string]string{forwardedHeader: r.self},
+		}, r.perf)
+		if err != nil {
+			logger.Warn("Failed to connect to routing peer", "peer", m, "error", err)
+			continue
+		}
+		ctx, cancel := context.WithCancel(r.ctx)
+		r.peers[m] = &routedPeer{exporter: e, cancel: cancel}
+		go e.run(ctx)
+	}
+	for addr, p := range r.peers {
+		if !seen[addr] {
+			p.cancel()
+			delete(r.peers, addr)
+		}
+	}
+	r.ring.Store(newHashRing(members, r.cfg.VirtualNodes))
+	monitoring.RoutingPeers.Set(float64(len(members)))
+	logger.Info("Trace routing peers changed", "self", r.self, "peers", members)
+}
+
+// peerAddrs returns the addresses of the other collectors on the ring
+func (r *traceRouter) peerAddrs() []string {
+	ring := r.ring.Load()
+	if ring == nil {
+		return nil
+	}
+	var addrs []string
+	for _, m := range ring.members {
+		if m != r.self {
+			addrs = append(addrs, m)
+		}
+	}
+	return addrs
+}
+
+// route forwards the spans of req owned by peers and returns the rest,
+// which are processed here. Exports forwarded by a peer stay here.
+func (r *traceRouter) route(ctx context.Context, req *coltracepb.ExportTraceServiceRequest) *coltracepb.ExportTraceServiceRequest {
+	if r == nil {
+		return req
+	}
+	ring := r.ring.Load()
+	if ring == nil || forwarded(ctx) {
+		return req
+	}
+	parts := splitByOwner(req, func(traceID []byte) string {
+		if len(traceID) != 16 {
+			return r.self // repaired or cleared locally
+		}
+		return ring.owner(traceID)
+	})
+	local := parts[r.self]
+	if local == nil {
+		local = &coltracepb.ExportTraceServiceRequest{}
+	}
+	if len(parts) == 1 && parts[r.self] != nil {
+		local = req
+	}
+	monitoring.RoutedSpans.WithLabelValues("local").Add(float64(countSpans(local)))
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for owner, part := range parts {
+		if owner == r.self {
+			continue
+		}
+		monitoring.RoutedSpans.WithLabelValues("forwarded").Add(float64(countSpans(part)))
+		if p := r.peers[owner]; p != nil {
+			p.exporter.Enqueue(exportRequest{signal: signalTraces, payload: part})
+			continue
+		}
+		// A peer that could not be connected to is processed here
+		local.ResourceSpans = append(local.ResourceSpans, part.ResourceSpans...)
+	}
+	return local
+}
+
+// forwarded reports whether a gRPC export came from a routing peer
+func forwarded(ctx context.Context) bool {
+	md, ok := metadata.FromIncomingContext(ctx)
+	return ok && len(md.Get(forwardedHeader)) > 0
+}
+
+// splitByOwner divides the spans of req by the owner of their trace ID,
+// keeping their resource and scope
+func splitByOwner(req *coltracepb.ExportTraceServiceRequest, owner func(traceID []byte) string) map[string]*coltracepb.ExportTraceServiceRequest {
+	parts := make(map[string]*coltracepb.ExportTraceServiceRequest)
+	for _, rs := range req.ResourceSpans {
+		resources := make(map[string]*tracepb.ResourceSpans)
+		for _, ss := range rs.ScopeSpans {
+			scopes := make(map[string]*tracepb.ScopeSpans)
+			for _, span := range ss.Spans {
+				o := owner(span.TraceId)
+				scope := scopes[o]
+				if scope == nil {
+					resource := resources[o]
+					if resource == nil {
+						resource = &tracepb.ResourceSpans{Resource: rs.Resource, SchemaUrl: rs.SchemaUrl}
+						resources[o] = resource
+						part := parts[o]
+						if part == nil {
+							part = &coltracepb.ExportTraceServiceRequest{}
+							parts[o] = part
+						}
+						part.ResourceSpans = append(part.ResourceSpans, resource)
+					}
+					scope = &tracepb.ScopeSpans{Scope: ss.Scope, SchemaUrl: ss.SchemaUrl}
+					resource.ScopeSpans = append(resource.ScopeSpans, scope)
+					scopes[o] = scope
+				}
+				scope.Spans = append(scope.Spans, span)
+			}
+		}
+	}
+	return parts
+}
+
+func countSpans(req *coltracepb.ExportTraceServiceRequest) int {
+	n := 0
+	for _, rs := range req.ResourceSpans {
+		for _, ss := range rs.ScopeSpans {
+			n += len(ss.Spans)
+		}
+	}
+	return n
+}
+
+// initRouting sets up forwarding of spans to the collectors owning their
+// traces when routing is enabled; start puts the peers on the ring
+func (c *Collector) initRouting() error {
+	r, err := newTraceRouter(c.config)
+	if err != nil {
+		return err
+	}
+	c.trace.router = r
+	return nil
+}
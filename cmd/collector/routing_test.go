@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"otelservices/internal/config"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+func testTraceID(i int) []byte {
+	id := make([]byte, 16)
+	binary.BigEndian.PutUint64(id[8:], uint64(i))
+	return id
+}
+
+func TestHashRing(t *testing.T) {
+	members := []string{"a:4317", "b:4317", "c:4317"}
+	ring := newHashRing(members, defaultVirtualNodes)
+	owners := make(map[int]string)
+	counts := make(map[string]int)
+	const traces = 30000
+	for i := 0; i < traces; i++ {
+		owners[i] = ring.owner(testTraceID(i))
+		counts[owners[i]]++
+	}
+	for _, m := range members {
+		if share := float64(counts[m]) / traces; share < 0.2 || share > 0.47 {
+			t.Errorf("%s owns %.0f%% of the traces", m, share*100)
+		}
+	}
+
+	// A new peer takes traces only from the others, about its share of them
+	grown := newHashRing(append(members, "d:4317"), defaultVirtualNodes)
+	moved := 0
+	for i := 0; i < traces; i++ {
+		if o := grown.owner(testTraceID(i)); o != owners[i] {
+			if o != "d:4317" {
+				t.Fatalf("trace %d moved from %s to %s", i, owners[i], o)
+			}
+			moved++
+		}
+	}
+	if share := float64(moved) / traces; share < 0.15 || share > 0.35 {
+		t.Errorf("%.0f%% of the traces moved", share*100)
+	}
+}
+
+// routingRequest has one resource with two scopes, with spans of traces
+// 0 to n-1 in each
+func routingRequest(n int) *coltracepb.ExportTraceServiceRequest {
+	resource := &tracepb.ResourceSpans{
+		Resource: &resourcepb.Resource{Attributes: []*commonpb.KeyValue{{Key: "service.name", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: "shop"}}}}},
+	}
+	for _, scope := range []string{"http", "db"} {
+		ss := &tracepb.ScopeSpans{Scope: &commonpb.InstrumentationScope{Name: scope}}
+		for i := 0; i < n; i++ {
+			ss.Spans = append(ss.Spans, &tracepb.Span{TraceId: testTraceID(i), SpanId: []byte{1, 2, 3, 4, 5, 6, 7, byte(i)}, Name: scope})
+		}
+		resource.ScopeSpans = append(resource.ScopeSpans, ss)
+	}
+	return &coltracepb.ExportTraceServiceRequest{ResourceSpans: []*tracepb.ResourceSpans{resource}}
+}
+
+func TestSplitByOwner(t *testing.T) {
+	parts := splitByOwner(routingRequest(10), func(traceID []byte) string {
+		return fmt.Sprint(traceID[15] % 2)
+	})
+	if len(parts) != 2 {
+		t.Fatalf("%d parts", len(parts))
+	}
+	for owner, part := range parts {
+		if countSpans(part) != 10 || len(part.ResourceSpans) != 1 || len(part.ResourceSpans[0].ScopeSpans) != 2 {
+			t.Errorf("part %s: %v", owner, part)
+			continue
+		}
+		rs := part.ResourceSpans[0]
+		if extractStringAttribute(rs.Resource, "service.name") != "shop" || rs.ScopeSpans[1].Scope.Name != "db" {
+			t.Errorf("part %s lost its resource or scope", owner)
+		}
+		for _, span := range rs.ScopeSpans[0].Spans {
+			if fmt.Sprint(span.TraceId[15]%2) != owner {
+				t.Errorf("span of trace %x in part %s", span.TraceId, owner)
+			}
+		}
+	}
+}
+
+// fakePeer is a collector receiving forwarded trace exports
+type fakePeer struct {
+	coltracepb.UnimplementedTraceServiceServer
+	mu        sync.Mutex
+	spans     int
+	forwarder []string
+}
+
+func (p *fakePeer) Export(ctx context.Context, req *coltracepb.ExportTraceServiceRequest) (*coltracepb.ExportTraceServiceResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.spans += countSpans(req)
+	p.forwarder = append(p.forwarder, md.Get(forwardedHeader)...)
+	return &coltracepb.ExportTraceServiceResponse{}, nil
+}
+
+func (p *fakePeer) received() (int, []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.spans, p.forwarder
+}
+
+func TestTraceRouterForwards(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer := &fakePeer{}
+	srv := grpc.NewServer()
+	coltracepb.RegisterTraceServiceServer(srv, peer)
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	cfg := config.DefaultConfig()
+	cfg.Routing = config.RoutingConfig{Enabled: true, Self: "self:4317", Peers: []string{lis.Addr().String()}, Insecure: true}
+	r, err := newTraceRouter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Before start every span stays here
+	req := routingRequest(100)
+	if got := r.route(ctx, req); got != req {
+		t.Error("spans routed before start")
+	}
+	r.start(ctx)
+
+	local := r.route(ctx, req)
+	kept := countSpans(local)
+	if kept == 0 || kept == 200 {
+		t.Fatalf("kept %d of 200 spans", kept)
+	}
+	ring := r.ring.Load()
+	for _, rs := range local.ResourceSpans {
+		for _, ss := range rs.ScopeSpans {
+			for _, span := range ss.Spans {
+				if ring.owner(span.TraceId) != "self:4317" {
+					t.Errorf("kept a span of trace %x owned by a peer", span.TraceId)
+				}
+			}
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		spans, forwarders := peer.received()
+		if spans == 200-kept {
+			if len(forwarders) == 0 || forwarders[0] != "self:4317" {
+				t.Errorf("forwarded by %v", forwarders)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("peer received %d spans, want %d", spans, 200-kept)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// An export a peer forwarded is processed here
+	fromPeer := metadata.NewIncomingContext(ctx, metadata.Pairs(forwardedHeader, "other:4317"))
+	if got := r.route(fromPeer, req); got != req {
+		t.Error("forwarded export routed again")
+	}
+}
+
+func TestTraceRouterKubernetesDiscovery(t *testing.T) {
+	var mu sync.Mutex
+	ips := []string{"10.0.0.1", "10.0.0.2"}
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/namespaces/otel-system/endpoints/otel-collector-peers" {
+			http.NotFound(w, r)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		fmt.Fprint(w, `{"subsets":[{"addresses":[`)
+		for i, ip := range ips {
+			if i > 0 {
+				fmt.Fprint(w, ",")
+			}
+			fmt.Fprintf(w, `{"ip":%q}`, ip)
+		}
+		fmt.Fprint(w, `],"notReadyAddresses":[{"ip":"10.0.0.9"}]}]}`)
+	}))
+	defer api.Close()
+
+	cfg := config.DefaultConfig()
+	cfg.Routing = config.RoutingConfig{
+		Enabled:    true,
+		Self:       "10.0.0.1:4317",
+		Insecure:   true,
+		Kubernetes: config.RoutingKubernetesConfig{Service: "otel-collector-peers", Namespace: "otel-system", APIServer: api.URL},
+	}
+	r, err := newTraceRouter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r.start(ctx)
+	if members := r.ring.Load().members; fmt.Sprint(members) != "[10.0.0.1:4317 10.0.0.2:4317]" {
+		t.Errorf("ring %v", members)
+	}
+
+	mu.Lock()
+	ips = []string{"10.0.0.1", "10.0.0.3"}
+	mu.Unlock()
+	r.refresh(ctx)
+	if members := r.ring.Load().members; fmt.Sprint(members) != "[10.0.0.1:4317 10.0.0.3:4317]" {
+		t.Errorf("ring after a change %v", members)
+	}
+	r.mu.Lock()
+	_, departed := r.peers["10.0.0.2:4317"]
+	r.mu.Unlock()
+	if departed {
+		t.Error("departed peer still has an exporter")
+	}
+
+	// A failed read keeps the peers
+	api.Close()
+	r.refresh(ctx)
+	if members := r.ring.Load().members; len(members) != 2 {
+		t.Errorf("ring after a failed read %v", members)
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"sync/atomic"
+	"time"
+
+	"otelservices/internal/config"
+	"otelservices/internal/monitoring"
+)
+
+// sampler makes head sampling decisions from the trace ID. Decisions are
+// deterministic, so every span of a trace, and the logs that reference it,
+// get the same answer on every collector without sharing state.
+type sampler struct {
+	policies  []config.SamplingPolicy
+	decisions *decisionCache
+}
+
+// newSampler returns nil when sampling is disabled; a nil sampler keeps everything
+func newSampler(cfg config.SamplingConfig) *sampler {
+	if !cfg.Enabled || len(cfg.Policies) == 0 {
+		return nil
+	}
+	return &sampler{
+		policies:  cfg.Policies,
+		decisions: newDecisionCache(cfg.DecisionCacheSize, cfg.DecisionCacheTTL),
+	}
+}
+
+// samplerSwitch holds the sampler in use, so a config reload can replace it
+// while exports are running. It holds nil while sampling is disabled.
+type samplerSwitch struct {
+	current atomic.Pointer[sampler]
+	cfg     config.SamplingConfig // only changed by set, which reloads serialize
+}
+
+func newSamplerSwitch(cfg config.SamplingConfig) *samplerSwitch {
+	s := &samplerSwitch{cfg: cfg}
+	s.current.Store(newSampler(cfg))
+	return s
+}
+
+// get returns the sampler in use; nil keeps everything
+func (s *samplerSwitch) get() *sampler {
+	return s.current.Load()
+}
+
+// set replaces the sampler when the sampling config changed. Decisions
+// remembered for debugging start over.
+func (s *samplerSwitch) set(cfg config.SamplingConfig) {
+	if reflect.DeepEqual(cfg, s.cfg) {
+		return
+	}
+	s.cfg = cfg
+	s.current.Store(newSampler(cfg))
+}
+
+// policyFor returns the first policy matching the service, or nil
+func (s *sampler) policyFor(serviceName string) *config.SamplingPolicy {
+	for i := range s.policies {
+		if s.policies[i].ServiceName == "" || s.policies[i].ServiceName == serviceName {
+			return &s.policies[i]
+		}
+	}
+	return nil
+}
+
+// traceIDBelow reports whether the trace ID falls under rate. Like the
+// OpenTelemetry TraceIDRatioBased sampler it compares the low 63 bits of the
+// last 8 bytes, so nested rates keep nested sets of traces. The first 8 bytes
+// are used when second is true, giving an independent decision.
+func traceIDBelow(traceID []byte, rate float64, second bool) bool {
+	if rate >= 1 {
+		return true
+	}
+	if rate <= 0 {
+		return false
+	}
+	part := traceID[8:16]
+	if second {
+		part = traceID[0:8]
+	}
+	bound := uint64(rate * (1 << 63))
+	return binary.BigEndian.Uint64(part)>>1 < bound
+}
+
+// SamplingDecision explains the outcome of sampling for one signal of one
+// service. Count is the number of spans or logs that got it.
+type SamplingDecision struct {
+	Signal    string    `json:"signal"`
+	Service   string    `json:"service"`
+	Policy    string    `json:"policy,omitempty"`
+	Rate      float64   `json:"rate"`
+	Kept      bool      `json:"kept"`
+	Reason    string    `json:"reason"`
+	Count     int       `json:"count,omitempty"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// decideSpan evaluates the span sampling policy for a service and trace
+func (s *sampler) decideSpan(serviceName string, traceID []byte) SamplingDecision {
+	d := SamplingDecision{Signal: "traces", Service: serviceName, Rate: 1, Kept: true, Reason: "no policy matches the service"}
+	policy := s.policyFor(serviceName)
+	if policy == nil {
+		return d
+	}
+	d.Policy, d.Rate = policy.Name, policy.Rate
+	d.Kept = traceIDBelow(traceID, policy.Rate, false)
+	if d.Kept {
+		d.Reason = fmt.Sprintf("trace ID falls within rate %g", policy.Rate)
+	} else {
+		d.Reason = fmt.Sprintf("trace ID falls outside rate %g", policy.Rate)
+	}
+	return d
+}
+
+// decideLog evaluates the log sampling policy for a service and trace.
+// sampledOut reports whether the trace itself was dropped, so the policy's
+// logs action decided.
+func (s *sampler) decideLog(serviceName string, traceID []byte) (d SamplingDecision, sampledOut bool) {
+	d = SamplingDecision{Signal: "logs", Service: serviceName, Rate: 1, Kept: true, Reason: "no policy matches the service"}
+	policy := s.policyFor(serviceName)
+	if policy == nil {
+		return d, false
+	}
+	d.Policy, d.Rate = policy.Name, policy.Rate
+	if traceIDBelow(traceID, policy.Rate, false) {
+		d.Reason = "trace was sampled in"
+		return d, false
+	}
+
+	switch policy.Logs.Action {
+	case "drop":
+		d.Kept = false
+		d.Reason = "trace was sampled out and the policy drops its logs"
+	case "downsample":
+		d.Kept = traceIDBelow(traceID, policy.Logs.Rate, true)
+		d.Reason = fmt.Sprintf("trace was sampled out and its logs are downsampled to rate %g", policy.Logs.Rate)
+	default:
+		d.Reason = "trace was sampled out and the policy keeps its logs"
+	}
+	return d, true
+}
+
+// KeepSpan decides whether a span is kept
+func (s *sampler) KeepSpan(serviceName string, traceID []byte) bool {
+	if s == nil || len(traceID) != 16 {
+		return true
+	}
+	d := s.decideSpan(serviceName, traceID)
+	if d.Policy != "" {
+		recordSamplingDecision("traces", d.Policy, d.Kept)
+	}
+	s.decisions.record(traceID, d, time.Now())
+	return d.Kept
+}
+
+// KeepLog decides whether a log record is kept. Logs without a trace ID, or
+// whose trace was sampled in, are always kept; otherwise the policy's logs
+// action applies.
+func (s *sampler) KeepLog(serviceName string, traceID []byte) bool {
+	if s == nil || len(traceID) != 16 {
+		return true
+	}
+	d, sampledOut := s.decideLog(serviceName, traceID)
+	if sampledOut {
+		recordSamplingDecision("logs", d.Policy, d.Kept)
+	}
+	s.decisions.record(traceID, d, time.Now())
+	return d.Kept
+}
+
+func recordSamplingDecision(signal, policy string, keep bool) {
+	decision := "dropped"
+	if keep {
+		decision = "kept"
+	}
+	monitoring.SamplingDecisions.WithLabelValues(signal, policy, decision).Inc()
+}
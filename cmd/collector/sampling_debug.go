@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxDecisionsPerTrace bounds the decisions remembered for one trace; a trace
+// crossing more services than this only keeps the first ones
+const maxDecisionsPerTrace = 32
+
+// decisionCache remembers recent sampling decisions per trace ID so operators
+// can find out why a trace is missing. Traces are evicted oldest first once
+// the cache is full or they are older than the TTL.
+type decisionCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	entries map[string]*cachedDecisions
+	order   []string // trace IDs in insertion order
+}
+
+type cachedDecisions struct {
+	created   time.Time
+	decisions []SamplingDecision
+}
+
+// newDecisionCache returns nil when size or ttl is zero; a nil cache records nothing
+func newDecisionCache(size int, ttl time.Duration) *decisionCache {
+	if size <= 0 || ttl <= 0 {
+		return nil
+	}
+	return &decisionCache{size: size, ttl: ttl, entries: make(map[string]*cachedDecisions)}
+}
+
+// record adds a decision for a trace, merging it with an identical earlier one
+func (c *decisionCache) record(traceID []byte, d SamplingDecision, now time.Time) {
+	if c == nil {
+		return
+	}
+	key := hex.EncodeToString(traceID)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.evict(now)
+
+	entry, ok := c.entries[key]
+	if !ok {
+		entry = &cachedDecisions{created: now}
+		c.entries[key] = entry
+		c.order = append(c.order, key)
+		c.evict(now)
+	}
+	for i := range entry.decisions {
+		e := &entry.decisions[i]
+		if e.Signal == d.Signal && e.Service == d.Service && e.Policy == d.Policy && e.Kept == d.Kept {
+			e.Count++
+			e.LastSeen = now
+			return
+		}
+	}
+	if len(entry.decisions) < maxDecisionsPerTrace {
+		d.Count, d.FirstSeen, d.LastSeen = 1, now, now
+		entry.decisions = append(entry.decisions, d)
+	}
+}
+
+// evict drops expired traces and, past the size limit, the oldest ones.
+// The caller must hold c.mu.
+func (c *decisionCache) evict(now time.Time) {
+	for len(c.order) > 0 {
+		oldest := c.order[0]
+		if len(c.entries) <= c.size && now.Sub(c.entries[oldest].created) < c.ttl {
+			return
+		}
+		delete(c.entries, oldest)
+		c.order[0] = ""
+		c.order = c.order[1:]
+	}
+}
+
+// lookup returns a copy of the decisions remembered for a trace
+func (c *decisionCache) lookup(traceID string, now time.Time) ([]SamplingDecision, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[traceID]
+	if !ok || now.Sub(entry.created) >= c.ttl {
+		return nil, false
+	}
+	return append([]SamplingDecision(nil), entry.decisions...), true
+}
+
+// SamplingDecisionResponse is returned by the sampling decision endpoint.
+// Decisions come from the cache; Evaluation is the decision the current
+// policies give the trace for the requested service.
+type SamplingDecisionResponse struct {
+	TraceID         string             `json:"trace_id"`
+	SamplingEnabled bool               `json:"sampling_enabled"`
+	Found           bool               `json:"found"`
+	Decisions       []SamplingDecision `json:"decisions"`
+	Evaluation      []SamplingDecision `json:"evaluation,omitempty"`
+}
+
+// parseTraceID decodes a 32 character hex trace ID
+func parseTraceID(s string) ([]byte, error) {
+	id, err := hex.DecodeString(strings.ToLower(strings.TrimSpace(s)))
+	if err != nil || len(id) != 16 {
+		return nil, fmt.Errorf("invalid trace_id %q: expected 32 hex characters", s)
+	}
+	return id, nil
+}
+
+// handleDecision reports which sampling policies evaluated a trace and why
+// it was kept or dropped. With service set, the trace is also evaluated
+// against the current policies, which works after the cache has forgotten it.
+func (s *sampler) handleDecision(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	traceID, err := parseTraceID(r.URL.Query().Get("trace_id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := SamplingDecisionResponse{
+		TraceID:         hex.EncodeToString(traceID),
+		SamplingEnabled: s != nil,
+		Decisions:       []SamplingDecision{},
+	}
+	if s != nil {
+		if decisions, ok := s.decisions.lookup(response.TraceID, time.Now()); ok {
+			response.Found = true
+			response.Decisions = decisions
+		}
+		if service := r.URL.Query().Get("service"); service != "" {
+			logs, _ := s.decideLog(service, traceID)
+			response.Evaluation = []SamplingDecision{s.decideSpan(service, traceID), logs}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"otelservices/internal/config"
+)
+
+func TestDecisionCacheMergesAndEvicts(t *testing.T) {
+	c := newDecisionCache(2, time.Minute)
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	first, second, third := traceIDWithValue(1), traceIDWithValue(2), traceIDWithValue(3)
+
+	d := SamplingDecision{Signal: "traces", Service: "api", Policy: "default", Kept: true}
+	c.record(first, d, now)
+	c.record(first, d, now.Add(time.Second))
+	decisions, ok := c.lookup(hex.EncodeToString(first), now)
+	if !ok || len(decisions) != 1 || decisions[0].Count != 2 || !decisions[0].LastSeen.Equal(now.Add(time.Second)) {
+		t.Fatalf("unexpected decisions %+v", decisions)
+	}
+
+	c.record(second, d, now.Add(2*time.Second))
+	c.record(third, d, now.Add(3*time.Second))
+	if _, ok := c.lookup(hex.EncodeToString(first), now); ok {
+		t.Error("oldest trace should be evicted past the size limit")
+	}
+	if _, ok := c.lookup(hex.EncodeToString(third), now.Add(2*time.Minute)); ok {
+		t.Error("expired trace should not be returned")
+	}
+
+	var nilCache *decisionCache
+	nilCache.record(first, d, now)
+	if _, ok := nilCache.lookup(hex.EncodeToString(first), now); ok {
+		t.Error("nil cache should remember nothing")
+	}
+}
+
+func TestSamplerRecordsDecisions(t *testing.T) {
+	s := newSampler(config.SamplingConfig{
+		Enabled:           true,
+		DecisionCacheSize: 100,
+		DecisionCacheTTL:  time.Minute,
+		Policies: []config.SamplingPolicy{
+			{Name: "checkout", ServiceName: "checkout", Rate: 1},
+			{Name: "default", Rate: 0.5, Logs: config.LogSamplingConfig{Action: "drop"}},
+		},
+	})
+	traceID := traceIDWithValue(1<<62 + 1) // outside 50%
+
+	s.KeepSpan("checkout", traceID)
+	s.KeepSpan("api", traceID)
+	s.KeepSpan("api", traceID)
+	s.KeepLog("api", traceID)
+
+	decisions, ok := s.decisions.lookup(hex.EncodeToString(traceID), time.Now())
+	if !ok || len(decisions) != 3 {
+		t.Fatalf("unexpected decisions %+v", decisions)
+	}
+	if d := decisions[0]; d.Policy != "checkout" || !d.Kept {
+		t.Errorf("checkout span decision = %+v", d)
+	}
+	if d := decisions[1]; d.Policy != "default" || d.Kept || d.Count != 2 || !strings.Contains(d.Reason, "outside rate 0.5") {
+		t.Errorf("api span decision = %+v", d)
+	}
+	if d := decisions[2]; d.Signal != "logs" || d.Kept || !strings.Contains(d.Reason, "drops its logs") {
+		t.Errorf("api log decision = %+v", d)
+	}
+}
+
+func TestHandleSamplingDecision(t *testing.T) {
+	s := newSampler(config.SamplingConfig{
+		Enabled:           true,
+		DecisionCacheSize: 100,
+		DecisionCacheTTL:  time.Minute,
+		Policies:          []config.SamplingPolicy{{Name: "default", Rate: 0.5}},
+	})
+	traceID := traceIDWithValue(1)
+	s.KeepSpan("api", traceID)
+
+	get := func(s *sampler, query string) (*httptest.ResponseRecorder, SamplingDecisionResponse) {
+		rec := httptest.NewRecorder()
+		s.handleDecision(rec, httptest.NewRequest(http.MethodGet, "/api/v1/admin/sampling/decision?"+query, nil))
+		var resp SamplingDecisionResponse
+		if rec.Code == http.StatusOK {
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+		}
+		return rec, resp
+	}
+
+	rec, resp := get(s, "trace_id="+strings.ToUpper(hex.EncodeToString(traceID)))
+	if rec.Code != http.StatusOK || !resp.Found || len(resp.Decisions) != 1 || !resp.Decisions[0].Kept {
+		t.Errorf("unexpected response %d %+v", rec.Code, resp)
+	}
+	if resp.TraceID != hex.EncodeToString(traceID) {
+		t.Errorf("trace ID not normalized: %q", resp.TraceID)
+	}
+
+	_, resp = get(s, "trace_id="+hex.EncodeToString(traceIDWithValue(2))+"&service=web")
+	if resp.Found || len(resp.Evaluation) != 2 || resp.Evaluation[0].Signal != "traces" || resp.Evaluation[1].Signal != "logs" {
+		t.Errorf("unexpected evaluation %+v", resp)
+	}
+
+	_, resp = get(nil, "trace_id="+hex.EncodeToString(traceID))
+	if resp.SamplingEnabled || resp.Found {
+		t.Errorf("disabled sampler response = %+v", resp)
+	}
+
+	if rec, _ := get(s, "trace_id=abc"); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid trace ID status = %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"otelservices/internal/config"
+)
+
+// traceIDWithValue builds a trace ID whose sampled half encodes v
+func traceIDWithValue(v uint64) []byte {
+	id := make([]byte, 16)
+	binary.BigEndian.PutUint64(id[8:], v<<1)
+	return id
+}
+
+func TestNilSamplerKeepsEverything(t *testing.T) {
+	var s *sampler
+	if s = newSampler(config.SamplingConfig{Enabled: false, Policies: []config.SamplingPolicy{{Name: "p", Rate: 0}}}); s != nil {
+		t.Fatal("expected nil sampler when disabled")
+	}
+	if !s.KeepSpan("api", traceIDWithValue(1)) || !s.KeepLog("api", traceIDWithValue(1)) {
+		t.Error("nil sampler should keep everything")
+	}
+}
+
+func TestSamplerKeepSpanByRate(t *testing.T) {
+	s := newSampler(config.SamplingConfig{
+		Enabled:  true,
+		Policies: []config.SamplingPolicy{{Name: "half", Rate: 0.5}},
+	})
+
+	if !s.KeepSpan("api", traceIDWithValue(1)) {
+		t.Error("low trace ID should be kept at 50%")
+	}
+	if s.KeepSpan("api", traceIDWithValue(1<<62+1)) {
+		t.Error("high trace ID should be dropped at 50%")
+	}
+	if !s.KeepSpan("api", []byte{1, 2, 3}) {
+		t.Error("invalid trace IDs should be kept")
+	}
+}
+
+func TestSamplerPolicyMatching(t *testing.T) {
+	s := newSampler(config.SamplingConfig{
+		Enabled: true,
+		Policies: []config.SamplingPolicy{
+			{Name: "checkout", ServiceName: "checkout", Rate: 1},
+			{Name: "default", Rate: 0},
+		},
+	})
+
+	id := traceIDWithValue(1 << 62)
+	if !s.KeepSpan("checkout", id) {
+		t.Error("checkout policy keeps everything")
+	}
+	if s.KeepSpan("frontend", id) {
+		t.Error("default policy drops everything")
+	}
+
+	unmatched := newSampler(config.SamplingConfig{
+		Enabled:  true,
+		Policies: []config.SamplingPolicy{{Name: "checkout", ServiceName: "checkout", Rate: 0}},
+	})
+	if !unmatched.KeepSpan("frontend", id) {
+		t.Error("services without a policy should be kept")
+	}
+}
+
+func TestSamplerKeepLog(t *testing.T) {
+	dropped := traceIDWithValue(1<<62 + 1)
+	kept := traceIDWithValue(1)
+
+	tests := []struct {
+		name    string
+		logs    config.LogSamplingConfig
+		traceID []byte
+		want    bool
+	}{
+		{name: "sampled trace", logs: config.LogSamplingConfig{Action: "drop"}, traceID: kept, want: true},
+		{name: "no trace id", logs: config.LogSamplingConfig{Action: "drop"}, traceID: nil, want: true},
+		{name: "keep action", logs: config.LogSamplingConfig{Action: "keep"}, traceID: dropped, want: true},
+		{name: "default action", logs: config.LogSamplingConfig{}, traceID: dropped, want: true},
+		{name: "drop action", logs: config.LogSamplingConfig{Action: "drop"}, traceID: dropped, want: false},
+		{name: "downsample none", logs: config.LogSamplingConfig{Action: "downsample", Rate: 0}, traceID: dropped, want: false},
+		{name: "downsample all", logs: config.LogSamplingConfig{Action: "downsample", Rate: 1}, traceID: dropped, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newSampler(config.SamplingConfig{
+				Enabled:  true,
+				Policies: []config.SamplingPolicy{{Name: "p", Rate: 0.5, Logs: tt.logs}},
+			})
+			if got := s.KeepLog("api", tt.traceID); got != tt.want {
+				t.Errorf("KeepLog() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTraceIDBelowIsNested(t *testing.T) {
+	// Anything kept at a lower rate must also be kept at a higher one
+	for v := uint64(0); v < 1<<63; v += 1 << 58 {
+		id := traceIDWithValue(v)
+		if traceIDBelow(id, 0.1, false) && !traceIDBelow(id, 0.2, false) {
+			t.Fatalf("trace %x kept at 10%% but dropped at 20%%", id)
+		}
+	}
+}
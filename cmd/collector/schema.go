@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"otelservices/internal/clickhouse"
+	"otelservices/internal/config"
+)
+
+// writeSchema rewrites the schema files in dir for the configured
+// deployment, a cluster and span deduplication, and writes the statements
+// to w, ready for clickhouse-client --multiquery
+func writeSchema(dir string, cfg *config.ClickHouseConfig, w io.Writer) error {
+	files, err := clickhouse.ReadSchema(dir)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		fmt.Fprintf(w, "-- %s\n", file.Name)
+		for _, stmt := range file.Statements {
+			ddl, err := clickhouse.DeploymentDDL(stmt, cfg)
+			if err != nil {
+				return fmt.Errorf("%s: %w", file.Name, err)
+			}
+			for _, s := range ddl {
+				fmt.Fprintf(w, "%s;\n\n", s)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+)
+
+// instrumentationScope is the stored form of an OTLP instrumentation scope,
+// shared by every span or log record it holds
+type instrumentationScope struct {
+	name       string
+	version    string
+	attributes map[string]string // nil without attributes
+}
+
+func newInstrumentationScope(scope *commonpb.InstrumentationScope) instrumentationScope {
+	s := instrumentationScope{name: scope.GetName(), version: scope.GetVersion()}
+	if len(scope.GetAttributes()) > 0 {
+		s.attributes = convertAttributes(scope.GetAttributes())
+	}
+	return s
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"otelservices/internal/config"
+
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+func TestExportKeepsScopeAndDroppedCounts(t *testing.T) {
+	scope := &commonpb.InstrumentationScope{
+		Name:       "io.opentelemetry.http",
+		Version:    "1.2.0",
+		Attributes: []*commonpb.KeyValue{{Key: "library.language", Value: stringValue("go")}},
+	}
+	c := NewCollector(config.DefaultConfig(), nil)
+
+	traces := &coltracepb.ExportTraceServiceRequest{ResourceSpans: []*tracepb.ResourceSpans{{
+		Resource: serviceResource("checkout", ""),
+		ScopeSpans: []*tracepb.ScopeSpans{{Scope: scope, Spans: []*tracepb.Span{{
+			Name:                   "GET /cart",
+			TraceId:                mustHex(t, "4bf92f3577b34da6a3ce929d0e0e4736"),
+			SpanId:                 mustHex(t, "00f067aa0ba902b7"),
+			TraceState:             "congo=t61rcWkgMzE",
+			DroppedAttributesCount: 3,
+			DroppedEventsCount:     2,
+			DroppedLinksCount:      1,
+		}}}},
+	}}}
+	if _, err := c.trace.Export(context.Background(), traces); err != nil {
+		t.Fatal(err)
+	}
+	span := <-c.trace.spanChan
+	if span.TraceState != "congo=t61rcWkgMzE" {
+		t.Errorf("trace state = %q", span.TraceState)
+	}
+	if span.DroppedAttributesCount != 3 || span.DroppedEventsCount != 2 || span.DroppedLinksCount != 1 {
+		t.Errorf("dropped counts = %d, %d, %d", span.DroppedAttributesCount, span.DroppedEventsCount, span.DroppedLinksCount)
+	}
+	if span.InstrumentationScopeName != "io.opentelemetry.http" || span.InstrumentationScopeVersion != "1.2.0" ||
+		span.ScopeAttributes["library.language"] != "go" {
+		t.Errorf("scope = %q %q %v", span.InstrumentationScopeName, span.InstrumentationScopeVersion, span.ScopeAttributes)
+	}
+
+	logs := &collogspb.ExportLogsServiceRequest{ResourceLogs: []*logspb.ResourceLogs{{
+		Resource: serviceResource("checkout", ""),
+		ScopeLogs: []*logspb.ScopeLogs{{Scope: scope, LogRecords: []*logspb.LogRecord{{
+			Body:                   stringValue("cart loaded"),
+			DroppedAttributesCount: 4,
+		}}}},
+	}}}
+	if _, err := c.logs.Export(context.Background(), logs); err != nil {
+		t.Fatal(err)
+	}
+	logRecord := <-c.logs.logChan
+	if logRecord.DroppedAttributesCount != 4 {
+		t.Errorf("dropped attributes = %d", logRecord.DroppedAttributesCount)
+	}
+	if logRecord.InstrumentationScopeName != "io.opentelemetry.http" || logRecord.ScopeAttributes["library.language"] != "go" {
+		t.Errorf("scope = %q %v", logRecord.InstrumentationScopeName, logRecord.ScopeAttributes)
+	}
+}
+
+func TestNewInstrumentationScopeWithoutScope(t *testing.T) {
+	scope := newInstrumentationScope(nil)
+	if scope.name != "" || scope.version != "" || scope.attributes != nil {
+		t.Errorf("nil scope = %+v", scope)
+	}
+}
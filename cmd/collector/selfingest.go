@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"log/slog"
+
+	"otelservices/internal/logging"
+	"otelservices/internal/models"
+	"otelservices/internal/monitoring"
+
+	"go.opentelemetry.io/otel/trace"
+
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+// selfTraceClient hands the collector's own spans to its trace pipeline
+// instead of exporting them over the network, which with the default
+// endpoint would be the collector itself. It implements otlptrace.Client.
+type selfTraceClient struct {
+	trace *TraceCollector
+}
+
+func (c *selfTraceClient) Start(context.Context) error { return nil }
+
+func (c *selfTraceClient) Stop(context.Context) error { return nil }
+
+// UploadTraces runs the spans through Export like received ones, so they are
+// sampled, counted and forwarded the same way
+func (c *selfTraceClient) UploadTraces(ctx context.Context, spans []*tracepb.ResourceSpans) error {
+	_, err := c.trace.Export(ctx, &coltracepb.ExportTraceServiceRequest{ResourceSpans: spans})
+	return err
+}
+
+// selfSeverity maps a slog level to its OpenTelemetry severity number
+func selfSeverity(level slog.Level) uint8 {
+	switch {
+	case level >= slog.LevelError:
+		return models.SeverityError
+	case level >= slog.LevelWarn:
+		return models.SeverityWarn
+	case level >= slog.LevelInfo:
+		return models.SeverityInfo
+	}
+	return models.SeverityDebug
+}
+
+// ingestSelf queues one of the collector's own log records for storage. It
+// is a logging sink, so it never blocks or logs: a record that finds the
+// queue full is dropped and counted in the ingest stats, rather than
+// logging a warning that would come back here.
+func (lc *LogsCollector) ingestSelf(r logging.Record) {
+	if !lc.intake.acquire() {
+		return
+	}
+	defer lc.intake.release()
+
+	severity := selfSeverity(r.Level)
+	record := models.LogRecord{
+		Timestamp:          r.Time,
+		ObservedTimestamp:  r.Time,
+		SeverityNumber:     severity,
+		SeverityText:       models.SeverityText(severity),
+		Body:               r.Message,
+		BodyType:           "string",
+		ServiceName:        serviceName,
+		Attributes:         r.Attributes,
+		ResourceAttributes: map[string]string{"service.version": serviceVersion},
+	}
+	if sc := trace.SpanContextFromContext(r.Context); sc.IsValid() {
+		traceID, spanID := sc.TraceID(), sc.SpanID()
+		record.TraceID = hex.EncodeToString(traceID[:])
+		record.SpanID = hex.EncodeToString(spanID[:])
+		record.TraceFlags = uint8(sc.TraceFlags())
+	}
+
+	select {
+	case lc.logChan <- record:
+		monitoring.ReceivedLogs.WithLabelValues(serviceName).Inc()
+		lc.stats.record(signalLogs, serviceName, 0, 1, 0, 0)
+	default:
+		lc.stats.record(signalLogs, serviceName, 0, 1, 0, 1)
+	}
+}
+
+// initSelfIngest sends the collector's own spans and logs into its pipeline
+// and returns the tracing shutdown function
+func (c *Collector) initSelfIngest() (func(context.Context) error, error) {
+	shutdown, err := monitoring.InitTracingClient(serviceName, serviceVersion, c.config.Monitoring, &selfTraceClient{trace: c.trace})
+	if err != nil {
+		return nil, err
+	}
+	logging.SetSink(c.logs.ingestSelf)
+	return func(ctx context.Context) error {
+		logging.SetSink(nil)
+		return shutdown(ctx)
+	}, nil
+}
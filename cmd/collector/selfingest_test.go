@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+
+	"otelservices/internal/config"
+	"otelservices/internal/logging"
+	"otelservices/internal/models"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestSelfIngestLogs(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Performance.QueueSize = 1
+	cfg.Performance.Signals.Logs.QueueSize = 1
+	c := NewCollector(cfg, nil)
+
+	sc := trace.NewSpanContext(trace.SpanContextConfig{TraceID: trace.TraceID{1}, SpanID: trace.SpanID{2}, TraceFlags: trace.FlagsSampled})
+	c.logs.ingestSelf(logging.Record{
+		Time:       time.Unix(1700000000, 0),
+		Level:      slog.LevelWarn,
+		Message:    "Span channel full",
+		Attributes: map[string]string{"component": "collector"},
+		Context:    trace.ContextWithSpanContext(context.Background(), sc),
+	})
+	// The queue holds one record; the next one is dropped without blocking
+	c.logs.ingestSelf(logging.Record{Time: time.Now(), Level: slog.LevelInfo, Message: "dropped", Context: context.Background()})
+
+	record := <-c.logs.logChan
+	if record.ServiceName != serviceName || record.Body != "Span channel full" || record.SeverityNumber != models.SeverityWarn || record.SeverityText != "WARN" {
+		t.Errorf("unexpected record %+v", record)
+	}
+	if record.TraceID != "01000000000000000000000000000000" || record.SpanID != "0200000000000000" || record.Attributes["component"] != "collector" {
+		t.Errorf("record not correlated: %+v", record)
+	}
+	stats := c.stats.snapshot().Services
+	if len(stats) != 1 || stats[0].Received != 2 || stats[0].Dropped != 1 {
+		t.Errorf("ingest stats = %+v", stats)
+	}
+
+	// Nothing is queued once the collector drains
+	c.drain(time.Second, func() {})
+	c.logs.ingestSelf(logging.Record{Time: time.Now(), Message: "late", Context: context.Background()})
+}
+
+func TestSelfSeverity(t *testing.T) {
+	tests := map[slog.Level]uint8{
+		slog.LevelDebug:     models.SeverityDebug,
+		slog.LevelInfo:      models.SeverityInfo,
+		slog.LevelWarn:      models.SeverityWarn,
+		slog.LevelError:     models.SeverityError,
+		slog.LevelError + 4: models.SeverityError,
+	}
+	for level, want := range tests {
+		if got := selfSeverity(level); got != want {
+			t.Errorf("selfSeverity(%v) = %d, want %d", level, got, want)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"otelservices/internal/config"
+	"otelservices/internal/models"
+	"otelservices/internal/pipeline"
+
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+// spanMetricsKey identifies one series of derived metrics
+type spanMetricsKey struct {
+	service    string
+	namespace  string
+	spanName   string
+	spanKind   string
+	statusCode string
+	dimensions string // dimension values joined by a NUL byte
+}
+
+// spanMetricsSeries accumulates the spans of one series since the last flush
+type spanMetricsSeries struct {
+	calls        uint64
+	durationSum  float64 // milliseconds
+	bucketCounts []uint64
+}
+
+// spanMetrics derives request, error and duration metrics per service and
+// operation from spans, like the OpenTelemetry spanmetrics connector. Spans
+// are counted as they are received, before sampling, and each flush writes
+// the counts since the previous one as delta values to otel_metrics.
+type spanMetrics struct {
+	namespace  string
+	dimensions []string
+	bounds     []float64 // bucket upper bounds in milliseconds
+
+	mu     sync.Mutex
+	series map[spanMetricsKey]*spanMetricsSeries
+}
+
+// newSpanMetrics returns nil when span metrics are disabled; a nil
+// spanMetrics ignores spans
+func newSpanMetrics(cfg config.SpanMetricsConfig) *spanMetrics {
+	if !cfg.Enabled {
+		return nil
+	}
+	bounds := make([]float64, len(cfg.Buckets))
+	for i, b := range cfg.Buckets {
+		bounds[i] = float64(b) / float64(time.Millisecond)
+	}
+	return &spanMetrics{
+		namespace:  cfg.Namespace,
+		dimensions: cfg.Dimensions,
+		bounds:     bounds,
+		series:     make(map[spanMetricsKey]*spanMetricsSeries),
+	}
+}
+
+// dimensionValues returns the configured span attributes, joined for use as
+// part of a map key
+func (m *spanMetrics) dimensionValues(attrs []*commonpb.KeyValue) string {
+	if len(m.dimensions) == 0 {
+		return ""
+	}
+	values := make([]string, len(m.dimensions))
+	for _, kv := range attrs {
+		for i, dim := range m.dimensions {
+			if kv.Key == dim {
+				values[i] = anyValueToString(kv.Value)
+			}
+		}
+	}
+	return strings.Join(values, "\x00")
+}
+
+// observe counts a received span under its normalized name
+func (m *spanMetrics) observe(serviceName, serviceNamespace, spanName string, span *tracepb.Span) {
+	if m == nil {
+		return
+	}
+	key := spanMetricsKey{
+		service:    serviceName,
+		namespace:  serviceNamespace,
+		spanName:   spanName,
+		spanKind:   spanKindName(span.Kind),
+		statusCode: statusCodeName(span.Status.GetCode()),
+		dimensions: m.dimensionValues(span.Attributes),
+	}
+	var durationMs float64
+	if span.EndTimeUnixNano > span.StartTimeUnixNano {
+		durationMs = float64(span.EndTimeUnixNano-span.StartTimeUnixNano) / float64(time.Millisecond)
+	}
+	bucket := sort.SearchFloat64s(m.bounds, durationMs)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.series[key]
+	if !ok {
+		s = &spanMetricsSeries{bucketCounts: make([]uint64, len(m.bounds)+1)}
+		m.series[key] = s
+	}
+	s.calls++
+	s.durationSum += durationMs
+	s.bucketCounts[bucket]++
+}
+
+// drain returns the metrics accumulated since the last call, stamped with now
+func (m *spanMetrics) drain(now time.Time) []models.Metric {
+	m.mu.Lock()
+	series := m.series
+	m.series = make(map[spanMetricsKey]*spanMetricsSeries, len(series))
+	m.mu.Unlock()
+
+	metrics := make([]models.Metric, 0, 2*len(series))
+	for key, s := range series {
+		attrs := map[string]string{
+			"span.name":   key.spanName,
+			"span.kind":   key.spanKind,
+			"status.code": key.statusCode,
+		}
+		if len(m.dimensions) > 0 {
+			for i, v := range strings.Split(key.dimensions, "\x00") {
+				if v != "" {
+					attrs[m.dimensions[i]] = v
+				}
+			}
+		}
+		base := models.Metric{
+			Timestamp:                now,
+			ServiceName:              key.service,
+			ServiceNamespace:         key.namespace,
+			InstrumentationScopeName: "spanmetrics",
+		}
+
+		calls := base
+		calls.MetricName = m.namespace + ".calls"
+		calls.MetricType = "counter"
+		calls.Value = float64(s.calls)
+		calls.Attributes = attrs
+		metrics = append(metrics, calls)
+
+		duration := base
+		duration.MetricName = m.namespace + ".duration"
+		duration.MetricType = "histogram"
+		duration.Value = s.durationSum / float64(s.calls)
+		duration.Attributes = attrs
+		duration.BucketCounts = s.bucketCounts
+		duration.ExplicitBounds = m.bounds
+		metrics = append(metrics, duration)
+	}
+	sort.Slice(metrics, func(i, j int) bool {
+		if metrics[i].ServiceName != metrics[j].ServiceName {
+			return metrics[i].ServiceName < metrics[j].ServiceName
+		}
+		return metrics[i].MetricName < metrics[j].MetricName
+	})
+	return metrics
+}
+
+// processDerivedMetrics periodically writes the metrics returned by drain,
+// such as those derived from spans or logs
+func (c *Collector) processDerivedMetrics(ctx context.Context, kind string, interval time.Duration, drain func(time.Time) []models.Metric) {
+	defer c.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	flush := func(ctx context.Context, now time.Time) {
+		metrics := drain(now)
+		if len(metrics) == 0 {
+			return
+		}
+		if err := c.writer.Consume(ctx, pipeline.Batch{Metrics: metrics}); err != nil {
+			logger.Error("Error inserting span metrics", "kind", kind, "error", err)
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			// ctx is already cancelled, use a short-lived one for the final write
+			finalCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			flush(finalCtx, time.Now())
+			cancel()
+			return
+		case now := <-ticker.C:
+			flush(ctx, now)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"otelservices/internal/config"
+	"otelservices/internal/models"
+	"otelservices/internal/pipeline"
+
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+func testSpan(name string, duration time.Duration, code tracepb.Status_StatusCode, method string) *tracepb.Span {
+	start := uint64(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC).UnixNano())
+	return &tracepb.Span{
+		Name:              name,
+		Kind:              tracepb.Span_SPAN_KIND_SERVER,
+		StartTimeUnixNano: start,
+		EndTimeUnixNano:   start + uint64(duration),
+		Status:            &tracepb.Status{Code: code},
+		Attributes: []*commonpb.KeyValue{
+			{Key: "http.method", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: method}}},
+		},
+	}
+}
+
+func TestSpanMetricsDrain(t *testing.T) {
+	m := newSpanMetrics(config.SpanMetricsConfig{
+		Enabled:    true,
+		Namespace:  "traces.span.metrics",
+		Dimensions: []string{"http.method", "http.route"},
+		Buckets:    []time.Duration{10 * time.Millisecond, 100 * time.Millisecond},
+	})
+
+	m.observe("api", "shop", "GET /items", testSpan("GET /items", 5*time.Millisecond, tracepb.Status_STATUS_CODE_UNSET, "GET"))
+	m.observe("api", "shop", "GET /items", testSpan("GET /items", 10*time.Millisecond, tracepb.Status_STATUS_CODE_UNSET, "GET"))
+	m.observe("api", "shop", "GET /items", testSpan("GET /items", 300*time.Millisecond, tracepb.Status_STATUS_CODE_UNSET, "GET"))
+	m.observe("api", "shop", "GET /items", testSpan("GET /items", 50*time.Millisecond, tracepb.Status_STATUS_CODE_ERROR, "GET"))
+
+	now := time.Date(2024, 3, 1, 12, 1, 0, 0, time.UTC)
+	metrics := m.drain(now)
+	if len(metrics) != 4 {
+		t.Fatalf("got %d metrics, want 4 (calls and duration for ok and error)", len(metrics))
+	}
+
+	byStatus := make(map[string]map[string]models.Metric)
+	for _, metric := range metrics {
+		if !metric.Timestamp.Equal(now) || metric.ServiceName != "api" || metric.ServiceNamespace != "shop" {
+			t.Errorf("unexpected metric %+v", metric)
+		}
+		if metric.Attributes["span.name"] != "GET /items" || metric.Attributes["span.kind"] != "server" || metric.Attributes["http.method"] != "GET" {
+			t.Errorf("unexpected attributes %v", metric.Attributes)
+		}
+		if _, ok := metric.Attributes["http.route"]; ok {
+			t.Error("missing dimensions should not become attributes")
+		}
+		status := metric.Attributes["status.code"]
+		if byStatus[status] == nil {
+			byStatus[status] = make(map[string]models.Metric)
+		}
+		byStatus[status][metric.MetricName] = metric
+	}
+
+	unset := byStatus["unset"]
+	if calls := unset["traces.span.metrics.calls"]; calls.Value != 3 || calls.MetricType != "counter" {
+		t.Errorf("unset calls = %+v", calls)
+	}
+	duration := unset["traces.span.metrics.duration"]
+	if duration.MetricType != "histogram" || duration.Value != 105 {
+		t.Errorf("unset duration = %+v", duration)
+	}
+	if want := []uint64{2, 0, 1}; len(duration.BucketCounts) != 3 || duration.BucketCounts[0] != want[0] || duration.BucketCounts[2] != want[2] {
+		t.Errorf("bucket counts = %v, want %v", duration.BucketCounts, want)
+	}
+	if calls := byStatus["error"]["traces.span.metrics.calls"]; calls.Value != 1 {
+		t.Errorf("error calls = %+v", calls)
+	}
+
+	if metrics := m.drain(now); len(metrics) != 0 {
+		t.Errorf("second drain returned %d metrics, want 0", len(metrics))
+	}
+
+	var disabled *spanMetrics
+	disabled.observe("api", "", "op", testSpan("op", time.Millisecond, tracepb.Status_STATUS_CODE_OK, "GET"))
+}
+
+// metricsRecorder collects the metric batches written by the collector
+type metricsRecorder struct {
+	recordingWriter
+	mu      sync.Mutex
+	metrics []models.Metric
+}
+
+func (w *metricsRecorder) Consume(ctx context.Context, batch pipeline.Batch) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.metrics = append(w.metrics, batch.Metrics...)
+	return w.recordingWriter.Consume(ctx, batch)
+}
+
+func TestSpanMetricsIgnoreSampling(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.SpanMetrics.Enabled = true
+	cfg.Sampling = config.SamplingConfig{Enabled: true, Policies: []config.SamplingPolicy{{Name: "none", Rate: 0}}}
+	c := NewCollector(cfg, nil)
+	writer := &metricsRecorder{}
+	c.writer = writer
+
+	span := testSpan("checkout", 20*time.Millisecond, tracepb.Status_STATUS_CODE_OK, "POST")
+	span.TraceId = traceIDWithValue(1)
+	_, err := c.trace.Export(context.Background(), &coltracepb.ExportTraceServiceRequest{
+		ResourceSpans: []*tracepb.ResourceSpans{{ScopeSpans: []*tracepb.ScopeSpans{{Spans: []*tracepb.Span{span}}}}},
+	})
+	if err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+	if len(c.trace.spanChan) != 0 {
+		t.Fatal("span should have been sampled out")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c.wg.Add(1)
+	go c.processDerivedMetrics(ctx, "span", time.Hour, c.spanMetrics.drain)
+	cancel()
+	c.wg.Wait()
+
+	if len(writer.metrics) != 2 || writer.metrics[0].Value != 1 {
+		t.Errorf("unexpected span metrics %+v", writer.metrics)
+	}
+}
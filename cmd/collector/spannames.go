@@ -0,0 +1,58 @@
+package main
+
+import (
+	"regexp"
+
+	"otelservices/internal/config"
+)
+
+// spanNameRule is a compiled span_names rule
+type spanNameRule struct {
+	serviceName string
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+// spanNamer normalizes span names with the configured rules before spans
+// are counted or stored, so operation-level aggregations see one name per
+// route rather than one per ID
+type spanNamer struct {
+	rules        []spanNameRule
+	rawAttribute string
+}
+
+func newSpanNamer(cfg config.SpanNamesConfig) *spanNamer {
+	if len(cfg.Rules) == 0 {
+		return nil
+	}
+	n := &spanNamer{rawAttribute: cfg.RawAttribute}
+	for _, r := range cfg.Rules {
+		n.rules = append(n.rules, spanNameRule{
+			serviceName: r.ServiceName,
+			pattern:     regexp.MustCompile(r.Pattern), // validated with the config
+			replacement: r.Replacement,
+		})
+	}
+	return n
+}
+
+// normalize returns the name a span of the service is stored under
+func (n *spanNamer) normalize(serviceName, name string) string {
+	if n == nil {
+		return name
+	}
+	for _, r := range n.rules {
+		if r.serviceName == "" || r.serviceName == serviceName {
+			name = r.pattern.ReplaceAllString(name, r.replacement)
+		}
+	}
+	return name
+}
+
+// keepRaw records the original name of a renamed span in attrs
+func (n *spanNamer) keepRaw(attrs map[string]string, raw, normalized string) {
+	if n == nil || n.rawAttribute == "" || raw == normalized {
+		return
+	}
+	attrs[n.rawAttribute] = raw
+}
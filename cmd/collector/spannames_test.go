@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"otelservices/internal/config"
+
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+var testSpanNameRules = []config.SpanNameRule{
+	{Pattern: `[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}`, Replacement: "{uuid}"},
+	{Pattern: `/\d+(/|$)`, Replacement: "/{id}$1"},
+	{ServiceName: "search", Pattern: `q=[^&]*`, Replacement: "q=?"},
+}
+
+func TestSpanNamer(t *testing.T) {
+	n := newSpanNamer(config.SpanNamesConfig{Rules: testSpanNameRules, RawAttribute: "span.name.original"})
+	tests := []struct {
+		service, name, want string
+	}{
+		{"api", "GET /users/123", "GET /users/{id}"},
+		{"api", "GET /users/123/orders/456", "GET /users/{id}/orders/{id}"},
+		{"api", "DELETE /sessions/3f2b9c1e-8a4d-4e2f-9b1a-7c6d5e4f3a2b", "DELETE /sessions/{uuid}"},
+		{"api", "GET /v2/items", "GET /v2/items"},
+		{"api", "GET /find?q=shoes", "GET /find?q=shoes"},
+		{"search", "GET /find?q=shoes", "GET /find?q=?"},
+	}
+	for _, tt := range tests {
+		if got := n.normalize(tt.service, tt.name); got != tt.want {
+			t.Errorf("normalize(%q, %q) = %q, want %q", tt.service, tt.name, got, tt.want)
+		}
+	}
+
+	attrs := map[string]string{}
+	n.keepRaw(attrs, "GET /v2/items", "GET /v2/items")
+	n.keepRaw(attrs, "GET /users/123", "GET /users/{id}")
+	if len(attrs) != 1 || attrs["span.name.original"] != "GET /users/123" {
+		t.Errorf("raw name attributes = %v", attrs)
+	}
+
+	var disabled *spanNamer
+	if got := disabled.normalize("api", "GET /users/123"); got != "GET /users/123" {
+		t.Errorf("disabled normalize() = %q", got)
+	}
+	if newSpanNamer(config.SpanNamesConfig{RawAttribute: "span.name.original"}) != nil {
+		t.Error("namer without rules should be nil")
+	}
+}
+
+func TestSpanNamesExport(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.SpanNames.Rules = testSpanNameRules
+	cfg.SpanMetrics.Enabled = true
+	c := NewCollector(cfg, nil)
+
+	req := &coltracepb.ExportTraceServiceRequest{ResourceSpans: []*tracepb.ResourceSpans{{
+		ScopeSpans: []*tracepb.ScopeSpans{{Spans: []*tracepb.Span{
+			{Name: "GET /users/123", TraceId: make([]byte, 16)},
+			{Name: "GET /users/456", TraceId: make([]byte, 16)},
+		}}},
+	}}}
+	if _, err := c.trace.Export(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	span := <-c.trace.spanChan
+	if span.SpanName != "GET /users/{id}" || span.Attributes["span.name.original"] != "GET /users/123" {
+		t.Errorf("stored span = %q %v", span.SpanName, span.Attributes)
+	}
+	if req.ResourceSpans[0].ScopeSpans[0].Spans[0].Name != "GET /users/123" {
+		t.Error("the request forwarded to exporters must keep the original name")
+	}
+
+	// Span metrics count both spans as one operation
+	calls := 0
+	for _, m := range c.trace.spanMetrics.drain(time.Now()) {
+		if m.MetricName == cfg.SpanMetrics.Namespace+".calls" {
+			calls++
+			if m.Attributes["span.name"] != "GET /users/{id}" || m.Value != 2 {
+				t.Errorf("calls metric = %+v", m)
+			}
+		}
+	}
+	if calls != 1 {
+		t.Errorf("got %d calls series, want 1", calls)
+	}
+}
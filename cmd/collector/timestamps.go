@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"otelservices/internal/clickhouse"
+	"otelservices/internal/config"
+	"otelservices/internal/models"
+	"otelservices/internal/monitoring"
+
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+// checkTimestamps reads the precision of the time columns at startup. The
+// schema stores nanoseconds; tables created with DateTime64(3) or (6)
+// silently drop digits on insert. In strict mode such a table stops the
+// collector, in compat mode spans keep their exact times in attributes.
+func (c *Collector) checkTimestamps(ctx context.Context) error {
+	cols, err := c.chClient.TimestampColumns(ctx)
+	if err != nil {
+		return err
+	}
+	return c.applyTimestampColumns(cols)
+}
+
+func (c *Collector) applyTimestampColumns(cols []clickhouse.TimestampColumn) error {
+	var truncating []string
+	for _, col := range cols {
+		monitoring.TimestampPrecision.WithLabelValues(col.Table, col.Column).Set(float64(col.Precision))
+		if col.Truncates() {
+			truncating = append(truncating, fmt.Sprintf("%s.%s %s", col.Table, col.Column, col.Type))
+		}
+	}
+	if len(truncating) == 0 {
+		return nil
+	}
+	if c.config.ClickHouse.TimestampMode == config.TimestampStrict {
+		return fmt.Errorf("time columns store less than nanoseconds: %s", strings.Join(truncating, ", "))
+	}
+	c.trace.precision = clickhouse.SpanTimePrecision(cols)
+	logger.Warn("Time columns store less than nanoseconds", "columns", truncating, "span_precision", c.trace.precision)
+	return nil
+}
+
+// spanTimes returns the start and end of a span as stored by time columns
+// of the collector's precision. When that drops digits, the exact times are
+// kept in attrs, so span timings are not silently truncated.
+func (tc *TraceCollector) spanTimes(span *tracepb.Span, attrs map[string]string) (start, end time.Time) {
+	start, end = time.Unix(0, int64(span.StartTimeUnixNano)), time.Unix(0, int64(span.EndTimeUnixNano))
+	if tc.precision >= models.TimestampPrecision {
+		return start, end
+	}
+	if stored := models.TruncateTime(start, tc.precision); !stored.Equal(start) {
+		attrs[models.StartTimeAttribute] = strconv.FormatUint(span.StartTimeUnixNano, 10)
+		start = stored
+	}
+	if stored := models.TruncateTime(end, tc.precision); !stored.Equal(end) {
+		attrs[models.EndTimeAttribute] = strconv.FormatUint(span.EndTimeUnixNano, 10)
+		end = stored
+	}
+	return start, end
+}
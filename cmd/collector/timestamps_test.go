@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"otelservices/internal/clickhouse"
+	"otelservices/internal/config"
+	"otelservices/internal/models"
+
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+func TestApplyTimestampColumns(t *testing.T) {
+	full := []clickhouse.TimestampColumn{
+		{Table: "otel_traces", Column: "start_time", Type: "DateTime64(9)", Precision: 9},
+		{Table: "otel_logs", Column: "timestamp", Type: "DateTime64(9)", Precision: 9},
+	}
+	legacy := []clickhouse.TimestampColumn{
+		{Table: "otel_traces", Column: "start_time", Type: "DateTime64(3)", Precision: 3},
+		{Table: "otel_traces", Column: "end_time", Type: "DateTime64(6)", Precision: 6},
+	}
+
+	c := NewCollector(config.DefaultConfig(), nil)
+	if err := c.applyTimestampColumns(full); err != nil || c.trace.precision != models.TimestampPrecision {
+		t.Errorf("nanosecond columns: precision %d, error %v", c.trace.precision, err)
+	}
+	if err := c.applyTimestampColumns(legacy); err != nil || c.trace.precision != 3 {
+		t.Errorf("compat mode: precision %d, error %v", c.trace.precision, err)
+	}
+
+	cfg := config.DefaultConfig()
+	cfg.ClickHouse.TimestampMode = config.TimestampStrict
+	if err := NewCollector(cfg, nil).applyTimestampColumns(legacy); err == nil {
+		t.Error("strict mode should refuse truncating columns")
+	}
+}
+
+func TestExportKeepsExactSpanTimes(t *testing.T) {
+	c := NewCollector(config.DefaultConfig(), nil)
+	c.trace.precision = 3
+	req := &coltracepb.ExportTraceServiceRequest{ResourceSpans: []*tracepb.ResourceSpans{{
+		Resource: serviceResource("checkout", ""),
+		ScopeSpans: []*tracepb.ScopeSpans{{Spans: []*tracepb.Span{{
+			Name:              "GET /cart",
+			TraceId:           mustHex(t, "4bf92f3577b34da6a3ce929d0e0e4736"),
+			SpanId:            mustHex(t, "00f067aa0ba902b7"),
+			StartTimeUnixNano: 1700000000123456789,
+			EndTimeUnixNano:   1700000000125000000,
+		}}}},
+	}}}
+	if _, err := c.trace.Export(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	span := <-c.trace.spanChan
+	if span.StartTime.UnixNano() != 1700000000123000000 || !span.Timestamp.Equal(span.StartTime) {
+		t.Errorf("stored start %v, timestamp %v", span.StartTime, span.Timestamp)
+	}
+	if span.DurationNs != 1543211 {
+		t.Errorf("duration = %d, want the exact 1543211ns", span.DurationNs)
+	}
+	if got := span.Attributes[models.StartTimeAttribute]; got != "1700000000123456789" {
+		t.Errorf("%s = %q", models.StartTimeAttribute, got)
+	}
+	// The end time fits the column, so it needs no attribute
+	if _, ok := span.Attributes[models.EndTimeAttribute]; ok {
+		t.Errorf("unexpected %s", models.EndTimeAttribute)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"strings"
+
+	"otelservices/internal/monitoring"
+
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+// W3C trace context ID sizes in bytes
+const (
+	traceIDSize = 16
+	spanIDSize  = 8
+)
+
+// repairedAttribute lists the IDs of a span or log record that were invalid
+// and replaced, e.g. "trace_id,span_id"
+const repairedAttribute = "otel.trace_context.repaired"
+
+// traceContext holds the validated IDs of a span or log record
+type traceContext struct {
+	traceID      []byte
+	spanID       []byte
+	parentSpanID []byte
+	repaired     []string // fields replaced, in the order trace_id, span_id, parent_span_id
+}
+
+// idProblem returns why id is not a valid W3C trace context ID of size
+// bytes: missing, length or zero; empty when it is valid
+func idProblem(id []byte, size int) string {
+	switch {
+	case len(id) == 0:
+		return "missing"
+	case len(id) != size:
+		return "length"
+	case isZeroID(id):
+		return "zero"
+	}
+	return ""
+}
+
+func isZeroID(id []byte) bool {
+	for _, b := range id {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// generatedID derives an ID of size bytes from parts, so a retried or
+// duplicated record gets the same ID
+func generatedID(size int, parts ...[]byte) []byte {
+	h := fnv.New128a()
+	var n [8]byte
+	for _, p := range parts {
+		binary.BigEndian.PutUint64(n[:], uint64(len(p)))
+		h.Write(n[:])
+		h.Write(p)
+	}
+	id := h.Sum(nil)[:size]
+	if isZeroID(id) {
+		id[size-1] = 1
+	}
+	return id
+}
+
+// paddedTraceID left-pads a 64-bit trace ID, as sent by older Jaeger and
+// Zipkin clients, to 128 bits; nil when id is not one
+func paddedTraceID(id []byte) []byte {
+	if len(id) != 8 || isZeroID(id) {
+		return nil
+	}
+	return append(make([]byte, traceIDSize-8), id...)
+}
+
+func (tc *traceContext) invalid(signal, service, field, problem string) {
+	tc.repaired = append(tc.repaired, field)
+	monitoring.TraceContextInvalid.WithLabelValues(signal, service, field, problem).Inc()
+}
+
+// flag records the repaired fields in attrs
+func (tc traceContext) flag(attrs map[string]string) {
+	if len(tc.repaired) > 0 {
+		attrs[repairedAttribute] = strings.Join(tc.repaired, ",")
+	}
+}
+
+// spanContext validates the IDs of a span. An invalid trace ID is padded
+// when it has 64 bits and otherwise replaced by one derived from it, or,
+// when missing or zero, from the span itself. Invalid span and parent span
+// IDs are replaced by IDs derived from the trace ID and the original bytes,
+// so a child still points at a parent whose malformed ID it shares. A zero
+// parent span ID marks a root span, like an empty one.
+func spanContext(service string, span *tracepb.Span) traceContext {
+	tc := traceContext{traceID: span.TraceId, spanID: span.SpanId, parentSpanID: span.ParentSpanId}
+	start := make([]byte, 8)
+	binary.BigEndian.PutUint64(start, span.StartTimeUnixNano)
+
+	if problem := idProblem(span.TraceId, traceIDSize); problem != "" {
+		tc.invalid(signalTraces, service, "trace_id", problem)
+		switch {
+		case paddedTraceID(span.TraceId) != nil:
+			tc.traceID = paddedTraceID(span.TraceId)
+		case problem == "length":
+			tc.traceID = generatedID(traceIDSize, []byte(service), span.TraceId)
+		default:
+			tc.traceID = generatedID(traceIDSize, []byte(service), []byte(span.Name), start, span.SpanId)
+		}
+	}
+	if problem := idProblem(span.SpanId, spanIDSize); problem != "" {
+		tc.invalid(signalTraces, service, "span_id", problem)
+		if problem == "length" {
+			tc.spanID = generatedID(spanIDSize, tc.traceID, span.SpanId)
+		} else {
+			tc.spanID = generatedID(spanIDSize, tc.traceID, []byte(span.Name), start)
+		}
+	}
+	if len(span.ParentSpanId) > 0 {
+		if problem := idProblem(span.ParentSpanId, spanIDSize); problem != "" {
+			tc.invalid(signalTraces, service, "parent_span_id", problem)
+			tc.parentSpanID = nil
+			if problem == "length" && !isZeroID(span.ParentSpanId) {
+				tc.parentSpanID = generatedID(spanIDSize, tc.traceID, span.ParentSpanId)
+			}
+		}
+	}
+	return tc
+}
+
+// logContext validates the IDs of a log record. Unlike a span, a log record
+// need not belong to a trace, so missing IDs are valid; a 64-bit trace ID is
+// padded and other invalid IDs are cleared rather than invented.
+func logContext(service string, record *logspb.LogRecord) traceContext {
+	tc := traceContext{traceID: record.TraceId, spanID: record.SpanId}
+	if len(record.TraceId) > 0 {
+		if problem := idProblem(record.TraceId, traceIDSize); problem != "" {
+			tc.invalid(signalLogs, service, "trace_id", problem)
+			tc.traceID = paddedTraceID(record.TraceId)
+		}
+	}
+	if len(record.SpanId) > 0 {
+		if problem := idProblem(record.SpanId, spanIDSize); problem != "" {
+			tc.invalid(signalLogs, service, "span_id", problem)
+			tc.spanID = nil
+		}
+	}
+	return tc
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"testing"
+
+	"otelservices/internal/config"
+
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestSpanContextValid(t *testing.T) {
+	span := &tracepb.Span{
+		TraceId:      mustHex(t, "4bf92f3577b34da6a3ce929d0e0e4736"),
+		SpanId:       mustHex(t, "00f067aa0ba902b7"),
+		ParentSpanId: mustHex(t, "53995c3f42cd8ad8"),
+	}
+	ids := spanContext("checkout", span)
+	if len(ids.repaired) != 0 || !bytes.Equal(ids.traceID, span.TraceId) || !bytes.Equal(ids.parentSpanID, span.ParentSpanId) {
+		t.Errorf("valid IDs changed: %+v", ids)
+	}
+	if ids := spanContext("checkout", &tracepb.Span{TraceId: span.TraceId, SpanId: span.SpanId}); len(ids.repaired) != 0 || ids.parentSpanID != nil {
+		t.Errorf("root span: %+v", ids)
+	}
+}
+
+func TestSpanContextRepair(t *testing.T) {
+	// A 64-bit trace ID is padded
+	ids := spanContext("checkout", &tracepb.Span{TraceId: mustHex(t, "a3ce929d0e0e4736"), SpanId: mustHex(t, "00f067aa0ba902b7")})
+	if got := hex.EncodeToString(ids.traceID); got != "0000000000000000a3ce929d0e0e4736" {
+		t.Errorf("padded trace ID = %s", got)
+	}
+	if len(ids.repaired) != 1 || ids.repaired[0] != "trace_id" {
+		t.Errorf("repaired = %v", ids.repaired)
+	}
+
+	// Missing and zero IDs get deterministic replacements
+	span := &tracepb.Span{Name: "GET /cart", StartTimeUnixNano: 1700000000000000000, TraceId: make([]byte, 16)}
+	first, second := spanContext("checkout", span), spanContext("checkout", span)
+	if idProblem(first.traceID, traceIDSize) != "" || idProblem(first.spanID, spanIDSize) != "" {
+		t.Fatalf("generated IDs are invalid: %x %x", first.traceID, first.spanID)
+	}
+	if !bytes.Equal(first.traceID, second.traceID) || !bytes.Equal(first.spanID, second.spanID) {
+		t.Error("generated IDs should be deterministic")
+	}
+	if other := spanContext("cart", span); bytes.Equal(other.traceID, first.traceID) {
+		t.Error("spans of different services should not share a generated trace")
+	}
+
+	// A child keeps pointing at a parent whose malformed span ID it shares
+	trace := mustHex(t, "4bf92f3577b34da6a3ce929d0e0e4736")
+	parent := spanContext("checkout", &tracepb.Span{TraceId: trace, SpanId: []byte{1, 2, 3}})
+	child := spanContext("checkout", &tracepb.Span{TraceId: trace, SpanId: mustHex(t, "00f067aa0ba902b7"), ParentSpanId: []byte{1, 2, 3}})
+	if !bytes.Equal(parent.spanID, child.parentSpanID) {
+		t.Errorf("parent %x, child's parent %x", parent.spanID, child.parentSpanID)
+	}
+
+	// A zero parent span ID marks a root span
+	root := spanContext("checkout", &tracepb.Span{TraceId: trace, SpanId: mustHex(t, "00f067aa0ba902b7"), ParentSpanId: make([]byte, 8)})
+	if root.parentSpanID != nil || len(root.repaired) != 1 || root.repaired[0] != "parent_span_id" {
+		t.Errorf("zero parent: %+v", root)
+	}
+}
+
+func TestLogContext(t *testing.T) {
+	if ids := logContext("checkout", &logspb.LogRecord{}); len(ids.repaired) != 0 || ids.traceID != nil {
+		t.Errorf("log without a trace: %+v", ids)
+	}
+	ids := logContext("checkout", &logspb.LogRecord{TraceId: make([]byte, 16), SpanId: []byte{1, 2, 3}})
+	if ids.traceID != nil || ids.spanID != nil || len(ids.repaired) != 2 {
+		t.Errorf("invalid IDs should be cleared: %+v", ids)
+	}
+	ids = logContext("checkout", &logspb.LogRecord{TraceId: mustHex(t, "a3ce929d0e0e4736")})
+	if got := hex.EncodeToString(ids.traceID); got != "0000000000000000a3ce929d0e0e4736" {
+		t.Errorf("padded trace ID = %s", got)
+	}
+}
+
+func TestExportRepairsTraceContext(t *testing.T) {
+	c := NewCollector(config.DefaultConfig(), nil)
+	req := &coltracepb.ExportTraceServiceRequest{ResourceSpans: []*tracepb.ResourceSpans{{
+		Resource: serviceResource("checkout", ""),
+		ScopeSpans: []*tracepb.ScopeSpans{{Spans: []*tracepb.Span{
+			{Name: "GET /cart", TraceId: mustHex(t, "a3ce929d0e0e4736")},
+		}}},
+	}}}
+	if _, err := c.trace.Export(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	span := <-c.trace.spanChan
+	if span.TraceID != "0000000000000000a3ce929d0e0e4736" || len(span.SpanID) != 16 {
+		t.Errorf("stored IDs %q %q", span.TraceID, span.SpanID)
+	}
+	if got := span.Attributes[repairedAttribute]; got != "trace_id,span_id" {
+		t.Errorf("%s = %q", repairedAttribute, got)
+	}
+
+	logs := &collogspb.ExportLogsServiceRequest{ResourceLogs: []*logspb.ResourceLogs{{
+		Resource:  serviceResource("checkout", ""),
+		ScopeLogs: []*logspb.ScopeLogs{{LogRecords: []*logspb.LogRecord{{TraceId: []byte{0xab}}}}},
+	}}}
+	if _, err := c.logs.Export(context.Background(), logs); err != nil {
+		t.Fatal(err)
+	}
+	record := <-c.logs.logChan
+	if record.TraceID != "" || record.Attributes[repairedAttribute] != "trace_id" {
+		t.Errorf("stored log trace ID %q, attributes %v", record.TraceID, record.Attributes)
+	}
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"otelservices/internal/clickhouse"
+	"otelservices/internal/config"
+	"otelservices/internal/monitoring"
+)
+
+// schemaMigrate creates what is missing of the schema in a directory on the
+// ClickHouse deployment of the config file, rewritten for its cluster and
+// span deduplication. -dry-run prints the statements instead.
+func (c *cli) schemaMigrate(ctx context.Context, args []string) error {
+	fs := c.flags("schema migrate")
+	dir := fs.String("dir", "schema", "directory of the *.sql schema files")
+	dryRun := fs.Bool("dry-run", false, "print the statements instead of running them")
+	if err := parse(fs, args, 0); err != nil {
+		return err
+	}
+
+	cfg, err := config.LoadConfig(c.configPath)
+	if err != nil {
+		return err
+	}
+	files, err := clickhouse.ReadSchema(*dir)
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		for _, file := range files {
+			fmt.Fprintf(c.stdout, "-- %s\n", file.Name)
+			for _, stmt := range file.Statements {
+				ddl, err := clickhouse.DeploymentDDL(stmt, &cfg.ClickHouse)
+				if err != nil {
+					return fmt.Errorf("%s: %w", file.Name, err)
+				}
+				for _, s := range ddl {
+					fmt.Fprintf(c.stdout, "%s;\n\n", s)
+				}
+			}
+		}
+		return nil
+	}
+
+	client, err := clickhouse.NewClient(&cfg.ClickHouse)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	statements := 0
+	err = client.Migrate(ctx, files, func(file, stmt string) {
+		statements++
+		fmt.Fprintf(c.stdout, "%s: %s\n", file, firstLine(stmt))
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.stdout, "Ran %d statements from %d files\n", statements, len(files))
+	return nil
+}
+
+// configValidate checks a config file, the -config file by default, with
+// its environment overrides, and prints it with its defaults filled in
+func (c *cli) configValidate(ctx context.Context, args []string) error {
+	fs := c.flags("config validate")
+	service := fs.String("service", "", "service the file configures, collector or query, to check only its ports; empty checks all")
+	dial := fs.Bool("dial", false, "also check that every ClickHouse address accepts connections")
+	if err := parse(fs, args, 1); err != nil {
+		return err
+	}
+	switch *service {
+	case "", "collector", "query":
+	default:
+		fmt.Fprintf(fs.Output(), "%s: unknown service %q\n", fs.Name(), *service)
+		return errUsage
+	}
+	path := c.configPath
+	if fs.NArg() == 1 {
+		path = fs.Arg(0)
+	}
+
+	result := config.CheckConfig(ctx, path, config.CheckOptions{Service: *service, Dial: *dial})
+	if c.output == outputJSON {
+		if err := c.printJSON(result); err != nil {
+			return err
+		}
+		if !result.Valid() {
+			return fmt.Errorf("%s: invalid configuration", path)
+		}
+		return nil
+	}
+	return result.Write(c.stdout, c.stderr)
+}
+
+// healthCheck is the readiness of one service
+type healthCheck struct {
+	Service string                     `json:"service"`
+	URL     string                     `json:"url"`
+	Report  monitoring.ReadinessReport `json:"report"`
+	Error   string                     `json:"error,omitempty"`
+}
+
+// health reads the readiness probes of the collector and the query
+// service. It fails unless both are ready or degraded.
+func (c *cli) health(ctx context.Context, args []string) error {
+	fs := c.flags("health")
+	path := fs.String("path", "/ready", "readiness path of both services")
+	if err := parse(fs, args, 0); err != nil {
+		return err
+	}
+
+	checks := []healthCheck{{Service: "collector", URL: c.collectorURL}, {Service: "query", URL: c.queryURL}}
+	failed := 0
+	for i := range checks {
+		check := &checks[i]
+		err := c.do(ctx, http.MethodGet, check.URL, *path, nil, &check.Report)
+		var status *statusError
+		if errors.As(err, &status) {
+			// An unready service still says why in its report
+			if json.Unmarshal([]byte(status.body), &check.Report) != nil {
+				check.Report.Reason = status.body
+			}
+			err = errors.New(status.status)
+		}
+		if err != nil {
+			check.Error = err.Error()
+			failed++
+		}
+		check.URL = strings.TrimRight(check.URL, "/") + *path
+	}
+
+	if c.output == outputJSON {
+		if err := c.printJSON(checks); err != nil {
+			return err
+		}
+	} else {
+		tw := tabwriter.NewWriter(c.stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "SERVICE\tSTATUS\tDETAIL")
+		for _, check := range checks {
+			status := check.Report.Status
+			if check.Error != "" && status == "" {
+				status = "unreachable"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", check.Service, status, healthDetail(check))
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d services not ready", failed, len(checks))
+	}
+	return nil
+}
+
+// healthDetail summarizes why a service is not ready
+func healthDetail(check healthCheck) string {
+	var detail []string
+	if check.Report.Reason != "" {
+		detail = append(detail, check.Report.Reason)
+	}
+	for _, dep := range check.Report.Dependencies {
+		if dep.Status != "up" {
+			detail = append(detail, fmt.Sprintf("%s %s: %s", dep.Name, dep.Status, dep.Error))
+		}
+	}
+	if len(detail) == 0 && check.Error != "" {
+		detail = append(detail, check.Error)
+	}
+	return strings.Join(detail, "; ")
+}
+
+// rollupsEnsure has the collector create missing metric rollups and verify
+// all of them
+func (c *cli) rollupsEnsure(ctx context.Context, args []string) error {
+	if err := parse(c.flags("rollups ensure"), args, 0); err != nil {
+		return err
+	}
+	var resp struct {
+		Rollups []string `json:"rollups"`
+	}
+	if err := c.do(ctx, http.MethodPost, c.collectorURL, "/api/v1/admin/rollups/ensure", nil, &resp); err != nil {
+		return err
+	}
+	if c.output == outputJSON {
+		return c.printJSON(resp)
+	}
+	fmt.Fprintf(c.stdout, "Rollups verified: %s\n", strings.Join(resp.Rollups, ", "))
+	return nil
+}
+
+// retentionApply sets the configured TTLs on the tables
+func (c *cli) retentionApply(ctx context.Context, args []string) error {
+	if err := parse(c.flags("retention apply"), args, 0); err != nil {
+		return err
+	}
+	var resp struct {
+		Tables []struct {
+			Table     string `json:"table"`
+			Retention string `json:"retention"`
+			TTL       string `json:"ttl"`
+		} `json:"tables"`
+	}
+	if err := c.do(ctx, http.MethodPost, c.queryURL, "/api/v1/admin/retention/apply", nil, &resp); err != nil {
+		return err
+	}
+	if c.output == outputJSON {
+		return c.printJSON(resp)
+	}
+	tw := tabwriter.NewWriter(c.stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TABLE\tRETENTION\tTTL")
+	for _, t := range resp.Tables {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", t.Table, t.Retention, t.TTL)
+	}
+	return tw.Flush()
+}
+
+// retentionPurge drops the partitions past their retention
+func (c *cli) retentionPurge(ctx context.Context, args []string) error {
+	if err := parse(c.flags("retention purge"), args, 0); err != nil {
+		return err
+	}
+	var resp struct {
+		Dropped []struct {
+			Table       string `json:"table"`
+			PartitionID string `json:"partition_id"`
+		} `json:"dropped"`
+	}
+	if err := c.do(ctx, http.MethodPost, c.queryURL, "/api/v1/admin/retention/purge", nil, &resp); err != nil {
+		return err
+	}
+	if c.output == outputJSON {
+		return c.printJSON(resp)
+	}
+	if len(resp.Dropped) == 0 {
+		fmt.Fprintln(c.stdout, "No expired partitions")
+		return nil
+	}
+	tw := tabwriter.NewWriter(c.stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TABLE\tPARTITION")
+	for _, p := range resp.Dropped {
+		fmt.Fprintf(tw, "%s\t%s\n", p.Table, p.PartitionID)
+	}
+	return tw.Flush()
+}
+
+// captureStatus is the state of a collector capture
+type captureStatus struct {
+	Active      bool      `json:"active"`
+	File        string    `json:"file"`
+	StartedAt   time.Time `json:"started_at"`
+	Until       time.Time `json:"until"`
+	SampleRatio float64   `json:"sample_ratio"`
+	MaxRequests int       `json:"max_requests"`
+	Requests    int       `json:"requests"`
+	Bytes       int64     `json:"bytes"`
+	Dropped     uint64    `json:"dropped"`
+	StopReason  string    `json:"stop_reason,omitempty"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// captureStart has the collector record a sanitized sample of the export
+// requests it receives, for the load test to replay
+func (c *cli) captureStart(ctx context.Context, args []string) error {
+	fs := c.flags("capture start")
+	duration := fs.Duration("duration", 0, "how long to capture; 0 uses capture.max_duration")
+	ratio := fs.Float64("sample-ratio", 0, "share of the requests recorded; 0 uses capture.sample_ratio")
+	maxRequests := fs.Int("max-requests", 0, "requests after which the capture stops; 0 uses capture.max_requests")
+	if err := parse(fs, args, 0); err != nil {
+		return err
+	}
+	q := url.Values{}
+	if *duration > 0 {
+		q.Set("duration", duration.String())
+	}
+	if *ratio > 0 {
+		q.Set("sample_ratio", strconv.FormatFloat(*ratio, 'g', -1, 64))
+	}
+	if *maxRequests > 0 {
+		q.Set("max_requests", strconv.Itoa(*maxRequests))
+	}
+	path := "/api/v1/admin/capture"
+	if len(q) > 0 {
+		path += "?" + q.Encode()
+	}
+	return c.capture(ctx, http.MethodPost, path)
+}
+
+// captureStop ends the running capture
+func (c *cli) captureStop(ctx context.Context, args []string) error {
+	if err := parse(c.flags("capture stop"), args, 0); err != nil {
+		return err
+	}
+	return c.capture(ctx, http.MethodDelete, "/api/v1/admin/capture")
+}
+
+// captureStatus shows the running or last capture
+func (c *cli) captureStatus(ctx context.Context, args []string) error {
+	if err := parse(c.flags("capture status"), args, 0); err != nil {
+		return err
+	}
+	return c.capture(ctx, http.MethodGet, "/api/v1/admin/capture")
+}
+
+func (c *cli) capture(ctx context.Context, method, path string) error {
+	var status captureStatus
+	if err := c.do(ctx, method, c.collectorURL, path, nil, &status); err != nil {
+		return err
+	}
+	if c.output == outputJSON {
+		return c.printJSON(status)
+	}
+	state := "running until " + status.Until.Format(time.RFC3339)
+	if !status.Active {
+		state = "stopped: " + status.StopReason
+		if status.Error != "" {
+			state += ": " + status.Error
+		}
+	}
+	fmt.Fprintf(c.stdout, "Capture %s\n", status.File)
+	fmt.Fprintf(c.stdout, "  %s\n", state)
+	fmt.Fprintf(c.stdout, "  %d of at most %d requests, %d bytes, %d dropped, sample ratio %g\n",
+		status.Requests, status.MaxRequests, status.Bytes, status.Dropped, status.SampleRatio)
+	return nil
+}
+
+// firstLine shortens a statement to its first line for progress output
+func firstLine(stmt string) string {
+	stmt = strings.TrimSpace(stmt)
+	if i := strings.IndexByte(stmt, '\n'); i >= 0 {
+		return stmt[:i] + " ..."
+	}
+	return stmt
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHealth(t *testing.T) {
+	ready := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ready" {
+			http.NotFound(w, r)
+			return
+		}
+		if ready {
+			w.Write([]byte(`{"status":"ready","dependencies":[]}`))
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"status":"ready","dependencies":[{"name":"clickhouse","status":"down","critical":true,"error":"connection refused"}]}`))
+	}))
+	defer srv.Close()
+
+	if code, out, errOut := runCLI(t, context.Background(), srv, "health"); code != 0 || strings.Count(out, "ready") != 2 {
+		t.Errorf("healthy: exit %d: %s%s", code, out, errOut)
+	}
+	ready = false
+	code, out, errOut := runCLI(t, context.Background(), srv, "health")
+	if code != 1 || !strings.Contains(out, "clickhouse down: connection refused") || !strings.Contains(errOut, "2 of 2 services not ready") {
+		t.Errorf("unhealthy: exit %d: %s%s", code, out, errOut)
+	}
+}
+
+func TestAdminJobs(t *testing.T) {
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		paths = append(paths, r.URL.Path)
+		switch r.URL.Path {
+		case "/api/v1/admin/rollups/ensure":
+			w.Write([]byte(`{"rollups":["otel_metrics_5m","otel_metrics_1h"]}`))
+		case "/api/v1/admin/retention/apply":
+			w.Write([]byte(`{"tables":[{"table":"otel_logs","retention":"720h0m0s","ttl":"timestamp + toIntervalDay(30)"}]}`))
+		case "/api/v1/admin/retention/purge":
+			w.Write([]byte(`{"dropped":[{"table":"otel_logs","partition_id":"20240101"}]}`))
+		}
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"rollups", "ensure"}, "otel_metrics_5m, otel_metrics_1h"},
+		{[]string{"retention", "apply"}, "toIntervalDay(30)"},
+		{[]string{"retention", "purge"}, "20240101"},
+	}
+	for _, tt := range tests {
+		code, out, errOut := runCLI(t, context.Background(), srv, tt.args...)
+		if code != 0 || !strings.Contains(out, tt.want) {
+			t.Errorf("%v: exit %d: %s%s", tt.args, code, out, errOut)
+		}
+	}
+	if len(paths) != len(tests) {
+		t.Errorf("requests %v", paths)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	valid := "../../configs/collector.yaml"
+	invalid := filepath.Join(t.TempDir(), "invalid.yaml")
+	os.WriteFile(invalid, []byte("clickhouse:\n  database: otel\nserver:\n  port: 9090\nmonitoring:\n  metrics_port: 9090\n"), 0o644)
+
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+	code, out, errOut := runCLI(t, context.Background(), srv, "config", "validate", "-service", "collector", valid)
+	if code != 0 || !strings.Contains(errOut, "collector.yaml: valid") || !strings.Contains(out, "grpc_port: 4317") {
+		t.Errorf("valid config: exit %d: %s%s", code, out, errOut)
+	}
+	code, _, errOut = runCLI(t, context.Background(), srv, "-config", invalid, "config", "validate")
+	for _, want := range []string{"invalid.yaml: error: clickhouse addresses cannot be empty", "error: monitoring.metrics_port: port 9090 is also used by server.port", "2 of 2 checks failed"} {
+		if code != 1 || !strings.Contains(errOut, want) {
+			t.Errorf("invalid config: exit %d, want %q: %s", code, want, errOut)
+		}
+	}
+	code, out, _ = runCLI(t, context.Background(), srv, "-o", "json", "config", "validate", invalid)
+	var result struct {
+		Valid       bool
+		Diagnostics []struct{ Setting string }
+	}
+	if code != 1 || json.Unmarshal([]byte(out), &result) != nil || result.Valid || len(result.Diagnostics) != 2 || result.Diagnostics[1].Setting != "monitoring.metrics_port" {
+		t.Errorf("json output (exit %d): %s", code, out)
+	}
+}
+
+func TestCapture(t *testing.T) {
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.String())
+		active := r.Method == http.MethodPost
+		fmt.Fprintf(w, `{"active":%t,"file":"/captures/capture-a.otlpcap.gz","requests":12,"max_requests":100,"stop_reason":"stopped"}`, active)
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"capture", "start", "-duration", "5m", "-sample-ratio", "0.5"}, "running until"},
+		{[]string{"capture", "status"}, "12 of at most 100 requests"},
+		{[]string{"capture", "stop"}, "stopped: stopped"},
+	}
+	for _, tt := range tests {
+		code, out, errOut := runCLI(t, context.Background(), srv, tt.args...)
+		if code != 0 || !strings.Contains(out, tt.want) {
+			t.Errorf("%v: exit %d: %s%s", tt.args, code, out, errOut)
+		}
+	}
+	want := []string{
+		"POST /api/v1/admin/capture?duration=5m0s&sample_ratio=0.5",
+		"GET /api/v1/admin/capture",
+		"DELETE /api/v1/admin/capture",
+	}
+	if strings.Join(requests, "\n") != strings.Join(want, "\n") {
+		t.Errorf("requests %q, want %q", requests, want)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+	for _, args := range [][]string{{}, {"traces"}, {"bogus", "command"}, {"traces", "query", "extra"}, {"-o", "yaml", "health"}, {"config", "validate", "-service", "kafka"}} {
+		if code, _, _ := runCLI(t, context.Background(), srv, args...); code != 2 {
+			t.Errorf("%v: exit %d, want 2", args, code)
+		}
+	}
+}
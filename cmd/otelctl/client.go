@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// maxErrorBody limits how much of an error response is printed
+const maxErrorBody = 4096
+
+// statusError is a response outside 2xx
+type statusError struct {
+	method, url string
+	status      string
+	body        string
+}
+
+func (e *statusError) Error() string {
+	if e.body == "" {
+		return fmt.Sprintf("%s %s: %s", e.method, e.url, e.status)
+	}
+	return fmt.Sprintf("%s %s: %s: %s", e.method, e.url, e.status, e.body)
+}
+
+// do sends body as JSON, when set, to base+path and decodes a 2xx response
+// into out, when set. Requests to the query service carry the API key.
+func (c *cli) do(ctx context.Context, method, base, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	url := strings.TrimRight(base, "/") + path
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.apiKey != "" && base == c.queryURL {
+		req.Header.Set("X-API-Key", c.apiKey)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		return &statusError{method: method, url: url, status: resp.Status, body: strings.TrimSpace(string(msg))}
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("%s %s: invalid response: %w", method, url, err)
+	}
+	return nil
+}
+
+// printJSON writes v indented, for -o json
+func (c *cli) printJSON(v interface{}) error {
+	enc := json.NewEncoder(c.stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
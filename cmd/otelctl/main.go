@@ -0,0 +1,165 @@
+// otelctl administers the collector, the query service and their ClickHouse
+// schema from the terminal
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Output formats selected with -o
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+)
+
+// errUsage reports a command line the subcommand could not parse; its usage
+// has already been printed
+var errUsage = errors.New("usage")
+
+// cli holds the global flags shared by every subcommand
+type cli struct {
+	configPath   string
+	queryURL     string
+	collectorURL string
+	apiKey       string
+	output       string
+	client       *http.Client
+	stdout       io.Writer
+	stderr       io.Writer
+}
+
+// command is one subcommand, run with the arguments after its name
+type command struct {
+	summary string
+	run     func(c *cli, ctx context.Context, args []string) error
+}
+
+var commands = map[string]command{
+	"schema migrate":  {"create the tables and views of a schema directory", (*cli).schemaMigrate},
+	"config validate": {"check a config file and print it with its defaults", (*cli).configValidate},
+	"traces query":    {"search spans through the query API", (*cli).tracesQuery},
+	"logs query":      {"search logs through the query API", (*cli).logsQuery},
+	"logs tail":       {"follow new logs through the query API", (*cli).logsTail},
+	"health":          {"check the readiness of the collector and the query service", (*cli).health},
+	"rollups ensure":  {"create and verify the metric rollups through the collector", (*cli).rollupsEnsure},
+	"retention apply": {"apply the retention TTLs through the query API", (*cli).retentionApply},
+	"retention purge": {"drop expired partitions through the query API", (*cli).retentionPurge},
+	"capture start":   {"record a sample of the collector's OTLP requests for load test replays", (*cli).captureStart},
+	"capture stop":    {"stop the running capture", (*cli).captureStop},
+	"capture status":  {"show the running or last capture", (*cli).captureStatus},
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	code := run(ctx, os.Args[1:], os.Stdout, os.Stderr)
+	stop()
+	os.Exit(code)
+}
+
+// run parses the global flags, runs the subcommand named by the remaining
+// arguments and returns the exit code: 1 when the command failed, 2 when the
+// command line was wrong
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	c := &cli{stdout: stdout, stderr: stderr}
+	fs := flag.NewFlagSet("otelctl", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.StringVar(&c.configPath, "config", envOr("CONFIG_PATH", "configs/collector.yaml"), "config file of the schema and config commands")
+	fs.StringVar(&c.queryURL, "query-url", envOr("OTELCTL_QUERY_URL", "http://localhost:8081"), "base URL of the query service")
+	fs.StringVar(&c.collectorURL, "collector-url", envOr("OTELCTL_COLLECTOR_URL", "http://localhost:8080"), "base URL of the collector health port")
+	fs.StringVar(&c.apiKey, "api-key", os.Getenv("OTELCTL_API_KEY"), "API key sent to the query service in X-API-Key")
+	timeout := fs.Duration("timeout", 30*time.Second, "timeout of each HTTP request")
+	fs.StringVar(&c.output, "o", outputTable, "output format: table or json")
+	fs.Usage = func() { usage(fs) }
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if c.output != outputTable && c.output != outputJSON {
+		fmt.Fprintf(stderr, "otelctl: unknown output format %q\n", c.output)
+		return 2
+	}
+	c.client = &http.Client{Timeout: *timeout}
+
+	name, cmd, rest, ok := lookup(fs.Args())
+	if !ok {
+		usage(fs)
+		return 2
+	}
+	if err := cmd.run(c, ctx, rest); err != nil {
+		if errors.Is(err, errUsage) {
+			return 2
+		}
+		fmt.Fprintf(stderr, "otelctl %s: %v\n", name, err)
+		return 1
+	}
+	return 0
+}
+
+// lookup finds the subcommand named by the first one or two arguments
+func lookup(args []string) (string, command, []string, bool) {
+	if len(args) >= 2 {
+		if cmd, ok := commands[args[0]+" "+args[1]]; ok {
+			return args[0] + " " + args[1], cmd, args[2:], true
+		}
+	}
+	if len(args) >= 1 {
+		if cmd, ok := commands[args[0]]; ok {
+			return args[0], cmd, args[1:], true
+		}
+	}
+	return "", command{}, nil, false
+}
+
+func usage(fs *flag.FlagSet) {
+	out := fs.Output()
+	fmt.Fprintln(out, "Usage: otelctl [flags] <command> [command flags]")
+	fmt.Fprintln(out, "\nCommands:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(out, "  %-16s %s\n", name, commands[name].summary)
+	}
+	fmt.Fprintln(out, "\nFlags:")
+	fs.PrintDefaults()
+}
+
+// flags returns the flag set of a subcommand, printing its errors and usage
+// to stderr
+func (c *cli) flags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet("otelctl "+name, flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	return fs
+}
+
+// parse parses the flags of a subcommand, which takes at most maxArgs
+// positional arguments
+func parse(fs *flag.FlagSet, args []string, maxArgs int) error {
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() > maxArgs {
+		fmt.Fprintf(fs.Output(), "%s: unexpected arguments %s\n", fs.Name(), strings.Join(fs.Args()[maxArgs:], " "))
+		return errUsage
+	}
+	return nil
+}
+
+func envOr(key, def string) string {
+	if val := os.Getenv(key); val != "" {
+		return val
+	}
+	return def
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// tailBatch is the number of logs a tail poll reads. A burst of more logs
+// between two polls shows only the newest of them.
+const tailBatch = 1000
+
+// traceQuery and logsQuery are the query API requests, with the fields
+// otelctl sets
+type traceQuery struct {
+	TraceID     string    `json:"trace_id"`
+	ServiceName string    `json:"service_name,omitempty"`
+	StartTime   time.Time `json:"start_time,omitempty"`
+	EndTime     time.Time `json:"end_time,omitempty"`
+	MinDuration int64     `json:"min_duration,omitempty"`
+	Limit       int       `json:"limit,omitempty"`
+}
+
+type logsQuery struct {
+	ServiceName string    `json:"service_name,omitempty"`
+	StartTime   time.Time `json:"start_time"`
+	EndTime     time.Time `json:"end_time"`
+	Severity    string    `json:"severity,omitempty"`
+	SearchText  string    `json:"search_text,omitempty"`
+	TraceID     string    `json:"trace_id,omitempty"`
+	Limit       int       `json:"limit,omitempty"`
+}
+
+// span and logRecord are the parts of the query API responses otelctl
+// prints as a table; -o json prints the responses unchanged
+type span struct {
+	TraceID     string    `json:"trace_id"`
+	SpanID      string    `json:"span_id"`
+	SpanName    string    `json:"span_name"`
+	StartTime   time.Time `json:"start_time"`
+	DurationNs  uint64    `json:"duration_ns"`
+	StatusCode  string    `json:"status_code"`
+	ServiceName string    `json:"service_name"`
+}
+
+type logRecord struct {
+	Timestamp    time.Time `json:"timestamp"`
+	SeverityText string    `json:"severity_text"`
+	Body         string    `json:"body"`
+	ServiceName  string    `json:"service_name"`
+	TraceID      string    `json:"trace_id,omitempty"`
+	SpanID       string    `json:"span_id,omitempty"`
+}
+
+// tracesQuery searches spans by trace ID, service and duration
+func (c *cli) tracesQuery(ctx context.Context, args []string) error {
+	fs := c.flags("traces query")
+	traceID := fs.String("trace-id", "", "trace ID")
+	service := fs.String("service", "", "service name")
+	since := fs.Duration("since", time.Hour, "how far back to search")
+	minDuration := fs.Duration("min-duration", 0, "only spans at least this long")
+	limit := fs.Int("limit", 20, "maximum number of spans")
+	if err := parse(fs, args, 0); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	req := traceQuery{
+		TraceID:     *traceID,
+		ServiceName: *service,
+		StartTime:   now.Add(-*since),
+		EndTime:     now,
+		MinDuration: minDuration.Nanoseconds(),
+		Limit:       *limit,
+	}
+	var resp struct {
+		Spans json.RawMessage `json:"spans"`
+		Total int             `json:"total"`
+	}
+	if err := c.do(ctx, http.MethodPost, c.queryURL, "/api/v1/traces", req, &resp); err != nil {
+		return err
+	}
+	if c.output == outputJSON {
+		return c.printJSON(resp)
+	}
+	var spans []span
+	if err := json.Unmarshal(resp.Spans, &spans); err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(c.stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "START\tTRACE ID\tSERVICE\tSPAN\tDURATION\tSTATUS")
+	for _, s := range spans {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", s.StartTime.Local().Format(time.RFC3339Nano), s.TraceID,
+			s.ServiceName, s.SpanName, time.Duration(s.DurationNs), s.StatusCode)
+	}
+	return tw.Flush()
+}
+
+// logFilters are the flags logs query and logs tail share
+type logFilters struct {
+	service, severity, search, traceID *string
+}
+
+func (f *logFilters) query(start, end time.Time, limit int) logsQuery {
+	return logsQuery{
+		ServiceName: *f.service,
+		StartTime:   start,
+		EndTime:     end,
+		Severity:    *f.severity,
+		SearchText:  *f.search,
+		TraceID:     *f.traceID,
+		Limit:       limit,
+	}
+}
+
+// logsQuery searches logs, newest first
+func (c *cli) logsQuery(ctx context.Context, args []string) error {
+	filters, fs := c.logFlags("logs query")
+	since := fs.Duration("since", 15*time.Minute, "how far back to search")
+	limit := fs.Int("limit", 100, "maximum number of logs")
+	if err := parse(fs, args, 0); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var resp struct {
+		Logs  json.RawMessage `json:"logs"`
+		Total int             `json:"total"`
+	}
+	if err := c.do(ctx, http.MethodPost, c.queryURL, "/api/v1/logs", filters.query(now.Add(-*since), now, *limit), &resp); err != nil {
+		return err
+	}
+	if c.output == outputJSON {
+		return c.printJSON(resp)
+	}
+	var logs []logRecord
+	if err := json.Unmarshal(resp.Logs, &logs); err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(c.stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tSEVERITY\tSERVICE\tBODY")
+	for _, l := range logs {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", l.Timestamp.Local().Format(time.RFC3339Nano), l.SeverityText, l.ServiceName, oneLine(l.Body))
+	}
+	return tw.Flush()
+}
+
+// logsTail polls the query API for logs newer than the last it printed
+// until interrupted. Logs sharing the timestamp of the last poll's newest
+// log are remembered, so none is printed twice.
+func (c *cli) logsTail(ctx context.Context, args []string) error {
+	filters, fs := c.logFlags("logs tail")
+	since := fs.Duration("since", time.Minute, "how far back to start")
+	interval := fs.Duration("interval", 2*time.Second, "how often to poll")
+	if err := parse(fs, args, 0); err != nil {
+		return err
+	}
+	if *interval <= 0 {
+		return fmt.Errorf("interval must be positive")
+	}
+
+	from := time.Now().Add(-*since)
+	seen := make(map[string]bool)
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for {
+		var resp struct {
+			Logs []json.RawMessage `json:"logs"`
+		}
+		if err := c.do(ctx, http.MethodPost, c.queryURL, "/api/v1/logs", filters.query(from, time.Now(), tailBatch), &resp); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		// The API returns newest first
+		for i := len(resp.Logs) - 1; i >= 0; i-- {
+			var l logRecord
+			if err := json.Unmarshal(resp.Logs[i], &l); err != nil {
+				return err
+			}
+			key := string(resp.Logs[i])
+			if l.Timestamp.Before(from) || seen[key] {
+				continue
+			}
+			if l.Timestamp.After(from) {
+				from = l.Timestamp
+				seen = make(map[string]bool)
+			}
+			seen[key] = true
+			if c.output == outputJSON {
+				fmt.Fprintf(c.stdout, "%s\n", resp.Logs[i])
+				continue
+			}
+			fmt.Fprintf(c.stdout, "%s %-5s %s %s\n", l.Timestamp.Local().Format(time.RFC3339Nano), l.SeverityText, l.ServiceName, oneLine(l.Body))
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// logFlags returns the flag set of a logs subcommand with the log filters
+// defined on it
+func (c *cli) logFlags(name string) (*logFilters, *flag.FlagSet) {
+	fs := c.flags(name)
+	return &logFilters{
+		service:  fs.String("service", "", "service name"),
+		severity: fs.String("severity", "", "severity text, e.g. ERROR"),
+		search:   fs.String("search", "", "text the body contains"),
+		traceID:  fs.String("trace-id", "", "trace ID"),
+	}, fs
+}
+
+// oneLine keeps a multi-line log body on its table row
+func oneLine(s string) string {
+	return strings.ReplaceAll(strings.TrimRight(s, "\n"), "\n", `\n`)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// runCLI runs otelctl against srv, as both the query service and the
+// collector, and returns its exit code and output
+func runCLI(t *testing.T, ctx context.Context, srv *httptest.Server, args ...string) (int, string, string) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	args = append([]string{"-query-url", srv.URL, "-collector-url", srv.URL, "-api-key", "secret"}, args...)
+	code := run(ctx, args, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestTracesQuery(t *testing.T) {
+	var got traceQuery
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/traces" || r.Header.Get("X-API-Key") != "secret" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Write([]byte(`{"spans":[{"trace_id":"abc","span_name":"checkout","service_name":"shop","duration_ns":20000000,"status_code":"OK"}],"total":1}`))
+	}))
+	defer srv.Close()
+
+	code, out, errOut := runCLI(t, context.Background(), srv, "traces", "query", "-service", "shop", "-since", "30m", "-min-duration", "10ms")
+	if code != 0 {
+		t.Fatalf("exit %d: %s", code, errOut)
+	}
+	if got.ServiceName != "shop" || got.MinDuration != int64(10*time.Millisecond) || got.Limit != 20 {
+		t.Errorf("request %+v", got)
+	}
+	if d := got.EndTime.Sub(got.StartTime); d != 30*time.Minute {
+		t.Errorf("time range %s", d)
+	}
+	for _, want := range []string{"TRACE ID", "abc", "checkout", "shop", "20ms", "OK"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+
+	code, out, _ = runCLI(t, context.Background(), srv, "-o", "json", "traces", "query")
+	var resp struct{ Total int }
+	if code != 0 || json.Unmarshal([]byte(out), &resp) != nil || resp.Total != 1 {
+		t.Errorf("json output (exit %d): %s", code, out)
+	}
+}
+
+func TestQueryError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "time range too long", http.StatusBadRequest)
+	}))
+	defer srv.Close()
+	code, _, errOut := runCLI(t, context.Background(), srv, "logs", "query", "-since", "720h")
+	if code != 1 || !strings.Contains(errOut, "400 Bad Request: time range too long") {
+		t.Errorf("exit %d: %s", code, errOut)
+	}
+}
+
+// logs tail prints each log once, oldest first, including logs that share
+// the timestamp a poll resumes from
+func TestLogsTail(t *testing.T) {
+	base := time.Now().Add(-10 * time.Second).UTC()
+	at := func(d time.Duration) time.Time { return base.Add(d) }
+	polls := [][]logRecord{
+		{{Timestamp: at(time.Second), Body: "b"}, {Timestamp: at(0), Body: "a"}},
+		{{Timestamp: at(time.Second), Body: "c"}, {Timestamp: at(time.Second), Body: "b"}},
+		{{Timestamp: at(2 * time.Second), Body: "d"}, {Timestamp: at(time.Second), Body: "c"}, {Timestamp: at(time.Second), Body: "b"}},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var mu sync.Mutex
+	var starts []time.Time
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req logsQuery
+		json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		defer mu.Unlock()
+		starts = append(starts, req.StartTime)
+		if len(starts) > len(polls) {
+			cancel()
+			w.Write([]byte(`{"logs":[]}`))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"logs": polls[len(starts)-1]})
+	}))
+	defer srv.Close()
+
+	code, out, errOut := runCLI(t, ctx, srv, "logs", "tail", "-interval", "10ms", "-since", "1m")
+	if code != 0 {
+		t.Fatalf("exit %d: %s", code, errOut)
+	}
+	var bodies []string
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		fields := strings.Fields(line)
+		bodies = append(bodies, fields[len(fields)-1])
+	}
+	if strings.Join(bodies, "") != "abcd" {
+		t.Errorf("printed %v:\n%s", bodies, out)
+	}
+	if !starts[1].Equal(at(time.Second)) || !starts[3].Equal(at(2*time.Second)) {
+		t.Errorf("polls started at %v", starts)
+	}
+}
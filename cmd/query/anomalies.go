@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"otelservices/internal/clickhouse"
+	"otelservices/internal/config"
+	"otelservices/internal/monitoring"
+)
+
+// Signals evaluated for every service
+const (
+	signalLatencyP95 = "latency_p95" // milliseconds
+	signalErrorRate  = "error_rate"  // fraction of spans with status error
+)
+
+// minDeviation keeps near-constant baselines from flagging tiny changes: the
+// deviation used for scoring is at least 5% of the baseline, and at least
+// 1ms of latency or 1 percentage point of error rate
+func minDeviation(signal string, mean float64) float64 {
+	floor := 0.01
+	if signal == signalLatencyP95 {
+		floor = 1
+	}
+	return math.Max(0.05*math.Abs(mean), floor)
+}
+
+// serviceSample is one evaluation window of a service's entry spans
+type serviceSample struct {
+	Service  string
+	Requests uint64
+	Errors   uint64
+	P95Ms    float64
+}
+
+// values returns the sample's value for each signal
+func (s serviceSample) values() map[string]float64 {
+	return map[string]float64{
+		signalLatencyP95: s.P95Ms,
+		signalErrorRate:  float64(s.Errors) / float64(s.Requests),
+	}
+}
+
+// baseline is an exponentially weighted moving mean and variance
+type baseline struct {
+	Mean     float64
+	Variance float64
+	Samples  int
+}
+
+// update folds x into the baseline with weight alpha
+func (b *baseline) update(x, alpha float64) {
+	if b.Samples == 0 {
+		b.Mean = x
+	} else {
+		diff := x - b.Mean
+		incr := alpha * diff
+		b.Mean += incr
+		b.Variance = (1 - alpha) * (b.Variance + diff*incr)
+	}
+	b.Samples++
+}
+
+// Anomaly is a service signal currently above its baseline
+type Anomaly struct {
+	ServiceName string    `json:"service_name"`
+	Signal      string    `json:"signal"` // latency_p95 (ms) or error_rate
+	Value       float64   `json:"value"`
+	Baseline    float64   `json:"baseline"`
+	Deviation   float64   `json:"deviation"` // standard deviation the score is measured in
+	Score       float64   `json:"score"`
+	Since       time.Time `json:"since"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// AnomaliesResponse lists the current anomalies
+type AnomaliesResponse struct {
+	Enabled     bool       `json:"enabled"`
+	EvaluatedAt *time.Time `json:"evaluated_at,omitempty"`
+	Anomalies   []Anomaly  `json:"anomalies"`
+}
+
+// anomalyKey identifies one signal of one service
+type anomalyKey struct {
+	service string
+	signal  string
+}
+
+// signalState is the baseline of a signal and its anomaly, if active
+type signalState struct {
+	baseline baseline
+	anomaly  *Anomaly
+}
+
+// anomalyDetector periodically compares each service's p95 latency and
+// error rate with an EWMA baseline and flags values more than threshold
+// standard deviations above it. Baselines live in memory and start over when
+// the query service restarts.
+type anomalyDetector struct {
+	cfg      config.AnomaliesConfig
+	profile  config.QueryProfile
+	chClient *clickhouse.Client
+
+	mu          sync.Mutex
+	states      map[anomalyKey]*signalState
+	evaluatedAt time.Time
+}
+
+func newAnomalyDetector(cfg config.AnomaliesConfig, profile config.QueryProfile, chClient *clickhouse.Client) *anomalyDetector {
+	return &anomalyDetector{
+		cfg:      cfg,
+		profile:  profile,
+		chClient: chClient,
+		states:   make(map[anomalyKey]*signalState),
+	}
+}
+
+// fetch reads the window ending at now of every service's server and
+// consumer spans
+func (d *anomalyDetector) fetch(ctx context.Context, now time.Time) ([]serviceSample, error) {
+	rows, err := d.chClient.Query(clickhouse.WithQueryProfile(ctx, d.profile), `
+		SELECT
+			service_name,
+			count(),
+			countIf(status_code = 'error'),
+			quantile(0.95)(duration_ns) / 1e6
+		FROM `+d.chClient.SpansTable()+`
+		WHERE timestamp >= ? AND timestamp < ?
+		  AND span_kind IN ('server', 'consumer')
+		GROUP BY service_name
+	`, now.Add(-d.cfg.Window), now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query service samples: %w", err)
+	}
+	defer rows.Close()
+
+	var samples []serviceSample
+	for rows.Next() {
+		var s serviceSample
+		if err := rows.Scan(&s.Service, &s.Requests, &s.Errors, &s.P95Ms); err != nil {
+			return nil, fmt.Errorf("failed to scan service sample: %w", err)
+		}
+		samples = append(samples, s)
+	}
+	return samples, rows.Err()
+}
+
+// observe scores each sample against its baselines, then folds it into them.
+// Services with too few requests are skipped and their anomalies cleared.
+func (d *anomalyDetector) observe(samples []serviceSample, now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.evaluatedAt = now
+
+	seen := make(map[anomalyKey]bool)
+	for _, sample := range samples {
+		if sample.Requests == 0 || sample.Requests < uint64(d.cfg.MinRequests) {
+			continue
+		}
+		for signal, value := range sample.values() {
+			key := anomalyKey{service: sample.Service, signal: signal}
+			seen[key] = true
+			state, ok := d.states[key]
+			if !ok {
+				state = &signalState{}
+				d.states[key] = state
+			}
+
+			b := &state.baseline
+			deviation := math.Max(math.Sqrt(b.Variance), minDeviation(signal, b.Mean))
+			score := (value - b.Mean) / deviation
+			if b.Samples == 0 {
+				score = 0
+			}
+			monitoring.AnomalyScore.WithLabelValues(sample.Service, signal).Set(score)
+
+			if b.Samples >= d.cfg.Warmup && score > d.cfg.Threshold {
+				if state.anomaly == nil {
+					state.anomaly = &Anomaly{ServiceName: sample.Service, Signal: signal, Since: now}
+					monitoring.AnomaliesDetected.WithLabelValues(sample.Service, signal).Inc()
+					logger.Warn("Anomaly detected", "service", sample.Service, "signal", signal, "value", value, "baseline", b.Mean, "score", score)
+				}
+				state.anomaly.Value = value
+				state.anomaly.Baseline = b.Mean
+				state.anomaly.Deviation = deviation
+				state.anomaly.Score = score
+				state.anomaly.UpdatedAt = now
+				monitoring.AnomalyActive.WithLabelValues(sample.Service, signal).Set(1)
+			} else if state.anomaly != nil {
+				state.anomaly = nil
+				monitoring.AnomalyActive.WithLabelValues(sample.Service, signal).Set(0)
+			}
+			b.update(value, d.cfg.Alpha)
+		}
+	}
+
+	for key, state := range d.states {
+		if !seen[key] && state.anomaly != nil {
+			state.anomaly = nil
+			monitoring.AnomalyActive.WithLabelValues(key.service, key.signal).Set(0)
+		}
+	}
+}
+
+// anomalies returns the active anomalies, optionally of one service, by
+// descending score
+func (d *anomalyDetector) anomalies(service string) ([]Anomaly, time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	anomalies := []Anomaly{}
+	for key, state := range d.states {
+		if state.anomaly != nil && (service == "" || key.service == service) {
+			anomalies = append(anomalies, *state.anomaly)
+		}
+	}
+	sort.Slice(anomalies, func(i, j int) bool {
+		return anomalies[i].Score > anomalies[j].Score
+	})
+	return anomalies, d.evaluatedAt
+}
+
+// run evaluates every interval until ctx is done
+func (d *anomalyDetector) run(ctx context.Context) {
+	if !d.cfg.Enabled {
+		return
+	}
+	ticker := time.NewTicker(d.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			samples, err := d.fetch(ctx, now)
+			if err != nil {
+				logger.Error("Error evaluating anomalies", "error", err)
+				continue
+			}
+			d.observe(samples, now)
+		}
+	}
+}
+
+// GetAnomalies returns the current latency and error rate anomalies
+func (s *QueryService) GetAnomalies(w http.ResponseWriter, r *http.Request) {
+	anomalies, evaluatedAt := s.anomalies.anomalies(r.URL.Query().Get("service"))
+	response := AnomaliesResponse{Enabled: s.anomalies.cfg.Enabled, Anomalies: anomalies}
+	if !evaluatedAt.IsZero() {
+		response.EvaluatedAt = &evaluatedAt
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"otelservices/internal/config"
+)
+
+func TestBaselineUpdate(t *testing.T) {
+	var b baseline
+	for _, x := range []float64{10, 10, 10} {
+		b.update(x, 0.5)
+	}
+	if b.Mean != 10 || b.Variance != 0 || b.Samples != 3 {
+		t.Errorf("constant input baseline = %+v", b)
+	}
+	b.update(20, 0.5)
+	if b.Mean != 15 || b.Variance != 25 {
+		t.Errorf("after a step baseline = %+v, want mean 15 and variance 25", b)
+	}
+}
+
+func TestAnomalyDetectorObserve(t *testing.T) {
+	cfg := config.DefaultConfig().Anomalies
+	cfg.Enabled = true
+	cfg.Warmup = 5
+	d := newAnomalyDetector(cfg, config.QueryProfile{}, nil)
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	// A service with steady latency around 100ms and a 1% error rate
+	for i := 0; i < 10; i++ {
+		p95 := 100 + float64(i%3)
+		d.observe([]serviceSample{{Service: "checkout", Requests: 1000, Errors: 10, P95Ms: p95}}, now)
+		now = now.Add(time.Minute)
+	}
+	if anomalies, _ := d.anomalies(""); len(anomalies) != 0 {
+		t.Fatalf("steady service flagged: %+v", anomalies)
+	}
+
+	// Latency triples while errors stay flat; a quiet service is ignored
+	d.observe([]serviceSample{
+		{Service: "checkout", Requests: 1000, Errors: 10, P95Ms: 300},
+		{Service: "cron", Requests: 3, Errors: 3, P95Ms: 5000},
+	}, now)
+	anomalies, evaluatedAt := d.anomalies("")
+	if len(anomalies) != 1 || !evaluatedAt.Equal(now) {
+		t.Fatalf("unexpected anomalies %+v", anomalies)
+	}
+	a := anomalies[0]
+	if a.ServiceName != "checkout" || a.Signal != signalLatencyP95 || a.Value != 300 || !a.Since.Equal(now) {
+		t.Errorf("unexpected anomaly %+v", a)
+	}
+	if a.Score <= cfg.Threshold || math.Abs(a.Baseline-101) > 1 {
+		t.Errorf("score %v against baseline %v", a.Score, a.Baseline)
+	}
+	if anomalies, _ := d.anomalies("cron"); len(anomalies) != 0 {
+		t.Errorf("service filter returned %+v", anomalies)
+	}
+
+	// The anomaly persists, keeping its start, until the service has no traffic
+	since := now
+	now = now.Add(time.Minute)
+	d.observe([]serviceSample{{Service: "checkout", Requests: 1000, Errors: 10, P95Ms: 320}}, now)
+	if anomalies, _ := d.anomalies("checkout"); len(anomalies) != 1 || !anomalies[0].Since.Equal(since) || !anomalies[0].UpdatedAt.Equal(now) {
+		t.Errorf("ongoing anomaly = %+v", anomalies)
+	}
+	d.observe(nil, now.Add(time.Minute))
+	if anomalies, _ := d.anomalies(""); len(anomalies) != 0 {
+		t.Errorf("anomaly of an idle service should clear, got %+v", anomalies)
+	}
+}
+
+func TestAnomalyDetectorWarmup(t *testing.T) {
+	cfg := config.DefaultConfig().Anomalies
+	cfg.Warmup = 5
+	d := newAnomalyDetector(cfg, config.QueryProfile{}, nil)
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	for _, rate := range []uint64{0, 0, 500} {
+		d.observe([]serviceSample{{Service: "api", Requests: 1000, Errors: rate, P95Ms: 50}}, now)
+	}
+	if anomalies, _ := d.anomalies(""); len(anomalies) != 0 {
+		t.Errorf("service flagged during warmup: %+v", anomalies)
+	}
+}
+
+func TestGetAnomalies(t *testing.T) {
+	s := NewQueryService(config.DefaultConfig(), nil)
+	rec := httptest.NewRecorder()
+	s.GetAnomalies(rec, httptest.NewRequest(http.MethodGet, "/api/v1/anomalies", nil))
+
+	var resp AnomaliesResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp.Enabled || resp.EvaluatedAt != nil || resp.Anomalies == nil || len(resp.Anomalies) != 0 {
+		t.Errorf("unexpected response %+v", resp)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// AttributeFilter compares an attribute of a span or log record with a
+// value. Numbers are compared with the integer and double attributes and
+// booleans with the boolean ones, as typed at ingest; strings are compared
+// with the string form of every attribute. Only items that have the
+// attribute with a matching type pass, also for ne.
+type AttributeFilter struct {
+	Key   string      `json:"key" validate:"required"`
+	Op    string      `json:"op" validate:"required,oneof=eq ne gt gte lt lte"`
+	Value interface{} `json:"value"`
+}
+
+// attributeOperators maps filter operators to SQL
+var attributeOperators = map[string]string{
+	"eq":  "=",
+	"ne":  "!=",
+	"gt":  ">",
+	"gte": ">=",
+	"lt":  "<",
+	"lte": "<=",
+}
+
+// condition returns the SQL condition of the filter and its arguments
+func (f AttributeFilter) condition() (string, []interface{}, error) {
+	op, ok := attributeOperators[f.Op]
+	if !ok {
+		return "", nil, fmt.Errorf("attribute filter %q: unsupported op %q", f.Key, f.Op)
+	}
+	switch value := f.Value.(type) {
+	case float64:
+		return fmt.Sprintf("((mapContains(attributes_int, ?) AND attributes_int[?] %s ?) OR (mapContains(attributes_double, ?) AND attributes_double[?] %s ?))", op, op),
+			[]interface{}{f.Key, f.Key, value, f.Key, f.Key, value}, nil
+	case bool, string:
+		if op != "=" && op != "!=" {
+			return "", nil, fmt.Errorf("attribute filter %q: %s needs a number", f.Key, f.Op)
+		}
+		column := "attributes"
+		if _, ok := value.(bool); ok {
+			column = "attributes_bool"
+		}
+		return fmt.Sprintf("(mapContains(%s, ?) AND %s[?] %s ?)", column, column, op),
+			[]interface{}{f.Key, f.Key, value}, nil
+	}
+	return "", nil, fmt.Errorf("attribute filter %q: value must be a number, boolean or string", f.Key)
+}
+
+// attributeFilterClause ANDs the conditions of filters, to be appended to a
+// WHERE clause
+func attributeFilterClause(filters []AttributeFilter) (string, []interface{}, error) {
+	var b strings.Builder
+	var args []interface{}
+	for _, f := range filters {
+		cond, condArgs, err := f.condition()
+		if err != nil {
+			return "", nil, err
+		}
+		b.WriteString(" AND ")
+		b.WriteString(cond)
+		args = append(args, condArgs...)
+	}
+	return b.String(), args, nil
+}
+
+// validateAttributeFilters checks the value types of filters, so a bad
+// filter is rejected before the query is built
+func validateAttributeFilters(filters []AttributeFilter) error {
+	_, _, err := attributeFilterClause(filters)
+	return err
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAttributeFilterClause(t *testing.T) {
+	clause, args, err := attributeFilterClause([]AttributeFilter{
+		{Key: "http.status_code", Op: "gte", Value: float64(500)},
+		{Key: "cache.hit", Op: "eq", Value: false},
+		{Key: "http.method", Op: "ne", Value: "OPTIONS"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"attributes_int[?] >= ?",
+		"attributes_double[?] >= ?",
+		"(mapContains(attributes_bool, ?) AND attributes_bool[?] = ?)",
+		"(mapContains(attributes, ?) AND attributes[?] != ?)",
+	} {
+		if !strings.Contains(clause, want) {
+			t.Errorf("clause is missing %q:\n%s", want, clause)
+		}
+	}
+	if strings.Count(clause, " AND (") != 3 || strings.Count(clause, "?") != len(args) {
+		t.Errorf("clause %s with %d args", clause, len(args))
+	}
+	if args[2] != float64(500) || args[8] != false || args[11] != "OPTIONS" {
+		t.Errorf("unexpected args: %v", args)
+	}
+
+	if clause, args, err := attributeFilterClause(nil); clause != "" || args != nil || err != nil {
+		t.Errorf("no filters = %q, %v, %v", clause, args, err)
+	}
+}
+
+func TestAttributeFilterErrors(t *testing.T) {
+	for _, f := range []AttributeFilter{
+		{Key: "http.method", Op: "gt", Value: "GET"},
+		{Key: "cache.hit", Op: "lt", Value: true},
+		{Key: "tags", Op: "eq", Value: []interface{}{"a"}},
+		{Key: "http.status_code", Op: "between", Value: float64(1)},
+		{Key: "http.status_code", Op: "eq"},
+	} {
+		if _, _, err := f.condition(); err == nil {
+			t.Errorf("expected an error for %+v", f)
+		}
+	}
+}
+
+func TestValidateAttributeFilters(t *testing.T) {
+	body := `{"attribute_filters":[{"key":"http.method","op":"gt","value":"GET"}]}`
+	if err := validateRequestBody([]byte(body), &TraceQueryRequest{}); err == nil || !strings.Contains(err.Error(), "needs a number") {
+		t.Errorf("expected a type error, got %v", err)
+	}
+	body = `{"attribute_filters":[{"key":"http.status_code","op":"above","value":500}]}`
+	if err := validateRequestBody([]byte(body), &TraceQueryRequest{}); err == nil || !strings.Contains(err.Error(), "attribute_filters[0].op") {
+		t.Errorf("expected an op error, got %v", err)
+	}
+}
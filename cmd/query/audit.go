@@ -0,0 +1,421 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"otelservices/internal/auth"
+	"otelservices/internal/clickhouse"
+	"otelservices/internal/config"
+	"otelservices/internal/models"
+	"otelservices/internal/monitoring"
+
+	"github.com/gorilla/mux"
+	"google.golang.org/grpc/peer"
+)
+
+const (
+	// resultRowsHeader carries the number of rows a query response holds, for
+	// the audit log and for clients
+	resultRowsHeader = "X-Result-Rows"
+	// auditDefaultWindow is the range /admin/audit lists without start
+	auditDefaultWindow = 24 * time.Hour
+	defaultAuditLimit  = 100
+	maxAuditLimit      = 1000
+)
+
+// auditStore persists audit records; *clickhouse.Client implements it
+type auditStore interface {
+	InsertAuditRecords(ctx context.Context, records []models.AuditRecord) error
+}
+
+// auditLog buffers the audit records of API requests and writes them to the
+// otel_audit table in batches, every flush interval or once a batch is full.
+// Records are dropped and counted when the queue is full, rather than
+// holding up queries while ClickHouse is slow. A nil auditLog records
+// nothing.
+type auditLog struct {
+	cfg   config.AuditConfig
+	store auditStore
+	ready chan struct{} // signalled when a batch is pending
+
+	mu      sync.Mutex
+	pending []models.AuditRecord
+}
+
+func newAuditLog(cfg config.AuditConfig, chClient *clickhouse.Client) *auditLog {
+	if !cfg.Enabled {
+		return nil
+	}
+	a := &auditLog{cfg: cfg, ready: make(chan struct{}, 1)}
+	if chClient != nil {
+		a.store = chClient
+	}
+	return a
+}
+
+// record queues a finished request
+func (a *auditLog) record(rec models.AuditRecord) {
+	a.mu.Lock()
+	if len(a.pending) >= a.cfg.QueueSize {
+		a.mu.Unlock()
+		monitoring.QueryAuditDropped.Inc()
+		return
+	}
+	a.pending = append(a.pending, rec)
+	full := len(a.pending) >= a.cfg.BatchSize
+	a.mu.Unlock()
+	if full {
+		select {
+		case a.ready <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// run writes the queued records until ctx is done
+func (a *auditLog) run(ctx context.Context) {
+	if a == nil {
+		return
+	}
+	ticker := time.NewTicker(a.cfg.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-a.ready:
+		}
+		a.flush(ctx)
+	}
+}
+
+// flush writes the queued records. Records that fail to write are queued
+// again, as far as the queue has room, for the next flush to retry.
+func (a *auditLog) flush(ctx context.Context) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	pending := a.pending
+	a.pending = nil
+	a.mu.Unlock()
+	if len(pending) == 0 || a.store == nil {
+		return
+	}
+
+	for start := 0; start < len(pending); start += a.cfg.BatchSize {
+		end := min(start+a.cfg.BatchSize, len(pending))
+		if err := a.store.InsertAuditRecords(ctx, pending[start:end]); err != nil {
+			logger.Error("Error writing audit records", "error", err)
+			a.requeue(pending[start:])
+			return
+		}
+	}
+}
+
+// requeue puts records that failed to write back in front of the queue
+func (a *auditLog) requeue(records []models.AuditRecord) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	queued := append(append([]models.AuditRecord(nil), records...), a.pending...)
+	if len(queued) > a.cfg.QueueSize {
+		monitoring.QueryAuditDropped.Add(float64(len(queued) - a.cfg.QueueSize))
+		queued = queued[:a.cfg.QueueSize]
+	}
+	a.pending = queued
+}
+
+type auditRecordKey struct{}
+
+// auditRecordFrom returns the audit record of the request of ctx, or nil
+// when the request is not audited
+func auditRecordFrom(ctx context.Context) *models.AuditRecord {
+	rec, _ := ctx.Value(auditRecordKey{}).(*models.AuditRecord)
+	return rec
+}
+
+// auditRequests is router middleware that records every /api/ request in
+// the audit log. The record travels in the request context: authentication
+// adds the caller, validation the normalized request body and the handler
+// the rows it returned.
+func (s *QueryService) auditRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.audit == nil || !strings.HasPrefix(r.URL.Path, "/api/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		rec := &models.AuditRecord{
+			Timestamp:  time.Now(),
+			RequestID:  monitoring.RequestID(r.Context()),
+			Method:     r.Method,
+			Route:      r.URL.Path,
+			Filters:    paramFilters(r),
+			RemoteAddr: r.RemoteAddr,
+		}
+		if route := mux.CurrentRoute(r); route != nil {
+			rec.Route, _ = route.GetPathTemplate()
+		}
+		sw := &auditStatusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), auditRecordKey{}, rec)))
+		rec.Status = sw.status
+		rec.Duration = time.Since(rec.Timestamp)
+		addAuditRows(rec, w.Header())
+		s.audit.record(*rec)
+	})
+}
+
+// auditCall starts the audit record of a gRPC call served by the REST
+// handler at route. finish completes and queues it.
+func (s *QueryService) auditCall(ctx context.Context, route string) (_ context.Context, finish func(err error)) {
+	if s.audit == nil {
+		return ctx, func(error) {}
+	}
+	rec := &models.AuditRecord{Timestamp: time.Now(), Method: "GRPC", Route: route}
+	if p := auth.FromContext(ctx); p != nil {
+		rec.Principal, rec.AuthMethod = p.Name, p.Method
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		rec.RemoteAddr = p.Addr.String()
+	}
+	return context.WithValue(ctx, auditRecordKey{}, rec), func(err error) {
+		rec.Status = http.StatusOK
+		if err != nil {
+			rec.Status = errorStatus(err)
+		}
+		rec.Duration = time.Since(rec.Timestamp)
+		s.audit.record(*rec)
+	}
+}
+
+// setAuditPrincipal records the authenticated caller of a request
+func setAuditPrincipal(ctx context.Context, p *auth.Principal) {
+	if rec := auditRecordFrom(ctx); rec != nil {
+		rec.Principal, rec.AuthMethod = p.Name, p.Method
+	}
+}
+
+// setAuditFilters replaces the filters of the audit record with the
+// validated body of a request to route. Bodies of queries other routes run
+// in process, such as Grafana's, are not the caller's filters and are left
+// out.
+func setAuditFilters(ctx context.Context, route string, op *apiOperation, body []byte) {
+	if rec := auditRecordFrom(ctx); rec != nil && rec.Route == route {
+		rec.Filters = string(normalizeBody(op, body))
+	}
+}
+
+// addAuditRows adds the rows a response reports in its X-Result-Rows header
+// to an audit record, so in-process queries count towards the request that
+// ran them
+func addAuditRows(rec *models.AuditRecord, header http.Header) {
+	if rec == nil {
+		return
+	}
+	if rows, err := strconv.ParseUint(header.Get(resultRowsHeader), 10, 64); err == nil {
+		rec.Rows += rows
+	}
+}
+
+// setResultRows reports the number of rows of a query response
+func setResultRows(w http.ResponseWriter, rows int) {
+	w.Header().Set(resultRowsHeader, strconv.Itoa(rows))
+}
+
+// paramFilters returns the path variables and URL parameters of a request
+// as canonical JSON, with keys sorted, or "" without any
+func paramFilters(r *http.Request) string {
+	filters := make(map[string]interface{})
+	for k, v := range r.URL.Query() {
+		if len(v) == 1 {
+			filters[k] = v[0]
+		} else {
+			filters[k] = v
+		}
+	}
+	for k, v := range mux.Vars(r) {
+		filters[k] = v
+	}
+	if len(filters) == 0 {
+		return ""
+	}
+	data, err := json.Marshal(filters)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// auditStatusWriter remembers the status of a response
+type auditStatusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (w *auditStatusWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *auditStatusWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush lets streaming handlers flush through the writer
+func (w *auditStatusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// AuditRecord is one audited API request
+type AuditRecord struct {
+	Timestamp  time.Time       `json:"timestamp"`
+	RequestID  string          `json:"request_id,omitempty"`
+	Principal  string          `json:"principal"`
+	AuthMethod string          `json:"auth_method,omitempty"`
+	Method     string          `json:"method"`
+	Route      string          `json:"route"`
+	Filters    json.RawMessage `json:"filters,omitempty"`
+	Status     int             `json:"status"`
+	Rows       uint64          `json:"rows"`
+	DurationMs float64         `json:"duration_ms"`
+	RemoteAddr string          `json:"remote_addr,omitempty"`
+}
+
+type AuditResponse struct {
+	Records []AuditRecord `json:"records"`
+	Total   int           `json:"total"`
+}
+
+// auditFilter selects the records /admin/audit lists
+type auditFilter struct {
+	Principal string
+	Route     string
+	Method    string
+	Status    int
+	Start     time.Time
+	End       time.Time
+	Limit     int
+}
+
+// auditFilterParams reads principal, route, method, status, start, end and
+// limit
+func auditFilterParams(r *http.Request, now time.Time) (auditFilter, error) {
+	q := r.URL.Query()
+	f := auditFilter{Principal: q.Get("principal"), Route: q.Get("route"), Method: strings.ToUpper(q.Get("method"))}
+	var err error
+	if f.Start, f.End, err = timeParams(q, now); err != nil {
+		return f, err
+	}
+	if f.End.IsZero() {
+		f.End = now
+	}
+	if f.Start.IsZero() {
+		f.Start = f.End.Add(-auditDefaultWindow)
+	}
+	if f.End.Before(f.Start) {
+		return f, fmt.Errorf("end must not be before start")
+	}
+	if f.Status, err = intParam(q, "status"); err != nil {
+		return f, err
+	}
+	if f.Limit, err = intParam(q, "limit"); err != nil {
+		return f, err
+	}
+	if f.Limit < 0 || f.Limit > maxAuditLimit {
+		return f, fmt.Errorf("limit must be between 0 and %d", maxAuditLimit)
+	}
+	if f.Limit == 0 {
+		f.Limit = defaultAuditLimit
+	}
+	return f, nil
+}
+
+// buildAuditQuery returns the newest audit records matching f
+func buildAuditQuery(f auditFilter) (string, []interface{}) {
+	query := `
+		SELECT timestamp, request_id, principal, auth_method, method, route,
+			filters, status, rows, duration_ns, remote_addr
+		FROM otel_audit
+		WHERE timestamp >= ? AND timestamp <= ?`
+	args := []interface{}{f.Start, f.End}
+	for _, cond := range []struct {
+		column string
+		value  interface{}
+		set    bool
+	}{
+		{"principal", f.Principal, f.Principal != ""},
+		{"route", f.Route, f.Route != ""},
+		{"method", f.Method, f.Method != ""},
+		{"status", f.Status, f.Status != 0},
+	} {
+		if cond.set {
+			query += " AND " + cond.column + " = ?"
+			args = append(args, cond.value)
+		}
+	}
+	query += fmt.Sprintf(" ORDER BY timestamp DESC LIMIT %d", f.Limit)
+	return query, args
+}
+
+// GetAudit lists audited API requests, newest first
+func (s *QueryService) GetAudit(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	defer func() {
+		monitoring.QueryDuration.WithLabelValues("audit").Observe(time.Since(start).Seconds())
+	}()
+
+	f, err := auditFilterParams(r, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		monitoring.QueryErrors.WithLabelValues("audit").Inc()
+		return
+	}
+	query, args := buildAuditQuery(f)
+	rows, err := s.chClient.Query(r.Context(), query, args...)
+	if err != nil {
+		queryFailed(w, "audit", err)
+		return
+	}
+	defer rows.Close()
+
+	records := []AuditRecord{}
+	for rows.Next() {
+		var rec AuditRecord
+		var filters string
+		var status uint16
+		var durationNs uint64
+		if err := rows.Scan(&rec.Timestamp, &rec.RequestID, &rec.Principal, &rec.AuthMethod, &rec.Method, &rec.Route,
+			&filters, &status, &rec.Rows, &durationNs, &rec.RemoteAddr); err != nil {
+			queryFailed(w, "audit", fmt.Errorf("failed to scan audit record: %w", err))
+			return
+		}
+		if filters != "" {
+			rec.Filters = json.RawMessage(filters)
+		}
+		rec.Status = int(status)
+		rec.DurationMs = float64(durationNs) / 1e6
+		records = append(records, rec)
+	}
+	if err := rows.Err(); err != nil {
+		queryFailed(w, "audit", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(AuditResponse{Records: records, Total: len(records)})
+}
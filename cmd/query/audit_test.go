@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"otelservices/internal/config"
+	"otelservices/internal/models"
+
+	"github.com/gorilla/mux"
+)
+
+type fakeAuditStore struct {
+	records []models.AuditRecord
+	err     error
+}
+
+func (f *fakeAuditStore) InsertAuditRecords(_ context.Context, records []models.AuditRecord) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.records = append(f.records, records...)
+	return nil
+}
+
+func TestAuditRequests(t *testing.T) {
+	s := authService(t)
+	store := &fakeAuditStore{}
+	s.audit = newAuditLog(config.AuditConfig{Enabled: true, FlushInterval: time.Second, BatchSize: 10, QueueSize: 10}, nil)
+	s.audit.store = store
+
+	router := mux.NewRouter()
+	router.Use(s.auditRequests, s.authenticate, validateRequests)
+	router.HandleFunc("/api/v1/logs", func(w http.ResponseWriter, r *http.Request) {
+		setResultRows(w, 3)
+		w.Write([]byte(`{"logs": []}`))
+	}).Methods("POST")
+	router.HandleFunc("/api/v1/services/{service}/operations", func(w http.ResponseWriter, r *http.Request) {}).Methods("GET")
+
+	for _, tt := range []struct{ method, path, key string }{
+		{"POST", "/api/v1/logs", ""},
+		{"GET", "/api/v1/services/checkout/operations?b=2&a=1&a=3", "logs-key"},
+		{"POST", "/api/v1/logs", "metrics-key"},
+	} {
+		req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(`{}`))
+		if tt.key != "" {
+			req.Header.Set("X-API-Key", tt.key)
+		}
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	s.audit.flush(context.Background())
+
+	want := []models.AuditRecord{
+		{Principal: "", Method: "POST", Route: "/api/v1/logs", Status: http.StatusUnauthorized},
+		{Principal: "logs", AuthMethod: "api_key", Method: "GET", Route: "/api/v1/services/{service}/operations",
+			Filters: `{"a":["1","3"],"b":"2","service":"checkout"}`, Status: http.StatusForbidden},
+		{Principal: "metrics", AuthMethod: "api_key", Method: "POST", Route: "/api/v1/logs", Status: http.StatusForbidden},
+	}
+	if len(store.records) != len(want) {
+		t.Fatalf("recorded %d requests, want %d", len(store.records), len(want))
+	}
+	for i, got := range store.records {
+		if got.Timestamp.IsZero() || got.Duration <= 0 || got.RemoteAddr == "" {
+			t.Errorf("record %d: missing timestamp, duration or address: %+v", i, got)
+		}
+		got.Timestamp, got.Duration, got.RemoteAddr = time.Time{}, 0, ""
+		if !reflect.DeepEqual(got, want[i]) {
+			t.Errorf("record %d = %+v, want %+v", i, got, want[i])
+		}
+	}
+}
+
+func TestAuditFiltersAndRows(t *testing.T) {
+	s := NewQueryService(config.DefaultConfig(), nil)
+	store := &fakeAuditStore{}
+	s.audit = newAuditLog(config.AuditConfig{Enabled: true, FlushInterval: time.Second, BatchSize: 10, QueueSize: 10}, nil)
+	s.audit.store = store
+
+	router := mux.NewRouter()
+	router.Use(s.auditRequests, validateRequests)
+	router.HandleFunc("/api/v1/logs", func(w http.ResponseWriter, r *http.Request) {
+		setResultRows(w, 3)
+	}).Methods("POST")
+	router.HandleFunc("/api/grafana/query", func(w http.ResponseWriter, r *http.Request) {
+		var out LogsQueryResponse
+		for i := 0; i < 2; i++ {
+			runHandler(r.Context(), func(w http.ResponseWriter, r *http.Request) {
+				setResultRows(w, 5)
+				w.Write([]byte(`{"logs": []}`))
+			}, "/api/v1/logs", LogsQueryRequest{ServiceName: "other"}, &out)
+		}
+	}).Methods("POST")
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/v1/logs",
+		strings.NewReader(`{"service_name": "checkout", "limit": 10, "start_time": "2024-01-01T00:00:00Z", "end_time": "2024-01-01T01:00:00Z"}`)))
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/grafana/query", strings.NewReader(`{}`)))
+	s.audit.flush(context.Background())
+
+	if len(store.records) != 2 {
+		t.Fatalf("recorded %d requests", len(store.records))
+	}
+	// Fields in the order of the request type, with defaults left out
+	logs := store.records[0]
+	if want := `{"service_name":"checkout","start_time":"2024-01-01T00:00:00Z","end_time":"2024-01-01T01:00:00Z","limit":10}`; logs.Filters != want || logs.Rows != 3 {
+		t.Errorf("logs filters %s, rows %d", logs.Filters, logs.Rows)
+	}
+	// In-process queries add their rows, but not their filters
+	grafana := store.records[1]
+	if strings.Contains(grafana.Filters, "other") || grafana.Rows != 10 {
+		t.Errorf("grafana filters %q, rows %d", grafana.Filters, grafana.Rows)
+	}
+}
+
+func TestAuditLogQueue(t *testing.T) {
+	store := &fakeAuditStore{err: errors.New("clickhouse down")}
+	a := newAuditLog(config.AuditConfig{Enabled: true, FlushInterval: time.Second, BatchSize: 2, QueueSize: 3}, nil)
+	a.store = store
+	for _, id := range []string{"a", "b", "c", "d"} {
+		a.record(models.AuditRecord{RequestID: id})
+	}
+	select {
+	case <-a.ready:
+	default:
+		t.Error("no flush signalled for a full batch")
+	}
+
+	// A failed write keeps the records for the next flush
+	a.flush(context.Background())
+	a.record(models.AuditRecord{RequestID: "e"})
+	store.err = nil
+	a.flush(context.Background())
+	var ids []string
+	for _, rec := range store.records {
+		ids = append(ids, rec.RequestID)
+	}
+	if got := strings.Join(ids, ","); got != "a,b,c" {
+		t.Errorf("written %s, want a,b,c", got)
+	}
+
+	var disabled *auditLog
+	disabled.flush(context.Background())
+	if newAuditLog(config.AuditConfig{}, nil) != nil {
+		t.Error("audit log created while disabled")
+	}
+}
+
+func TestAuditFilterParams(t *testing.T) {
+	now := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	r := httptest.NewRequest("GET", "/api/v1/admin/audit?principal=ci&method=grpc&status=403", nil)
+	f, err := auditFilterParams(r, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !f.Start.Equal(now.Add(-auditDefaultWindow)) || !f.End.Equal(now) || f.Limit != defaultAuditLimit {
+		t.Errorf("defaults: %+v", f)
+	}
+	query, args := buildAuditQuery(f)
+	if !strings.Contains(query, "principal = ? AND method = ? AND status = ?") || !strings.Contains(query, "LIMIT 100") {
+		t.Errorf("query = %s", query)
+	}
+	if !reflect.DeepEqual(args[2:], []interface{}{"ci", "GRPC", 403}) {
+		t.Errorf("args = %v", args)
+	}
+
+	for _, params := range []string{"limit=5000", "limit=-1", "status=ok", "start=now-1h&end=now-2h"} {
+		if _, err := auditFilterParams(httptest.NewRequest("GET", "/api/v1/admin/audit?"+params, nil), now); err == nil {
+			t.Errorf("%s: expected error", params)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"otelservices/internal/auth"
+	"otelservices/internal/config"
+	"otelservices/internal/monitoring"
+
+	"github.com/gorilla/mux"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// routeScopes are the scopes each /api/ route requires when auth is enabled,
+// unless auth.route_scopes replaces them. Routes without scopes only need an
+// authenticated caller: the Grafana, job and export routes check the scopes
+// of the trace, metrics or logs query they run.
+var routeScopes = map[string][]string{
+	"/api/v1/traces":                          {auth.ScopeReadTraces},
+	"/api/v1/traces/otlp":                     {auth.ScopeReadTraces},
+	"/api/v1/traces/latency-histogram":        {auth.ScopeReadTraces},
+	"/api/v1/traces/{trace_id}/otlp":          {auth.ScopeReadTraces},
+	"/api/v1/traces/{trace_id}/critical-path": {auth.ScopeReadTraces},
+	"/api/v1/metrics":                         {auth.ScopeReadMetrics},
+	"/api/v1/metrics/names":                   {auth.ScopeReadMetrics},
+	"/api/v1/metrics/{name}/labels":           {auth.ScopeReadMetrics},
+	"/api/v1/logs":                            {auth.ScopeReadLogs},
+	"/api/v1/logs/patterns":                   {auth.ScopeReadLogs},
+	"/api/v1/services/stats":                  {auth.ScopeReadTraces},
+	"/api/v1/services":                        {auth.ScopeReadTraces},
+	"/api/v1/services/{service}/operations":   {auth.ScopeReadTraces},
+	"/api/v1/services/{service}/compare":      {auth.ScopeReadTraces},
+	"/api/v1/errors":                          {auth.ScopeReadTraces, auth.ScopeReadLogs},
+	"/api/v1/anomalies":                       {auth.ScopeReadTraces},
+	"/api/v1/slo":                             {auth.ScopeReadTraces},
+	"/api/v1/slo/{name}":                      {auth.ScopeReadTraces},
+	"/api/v1/usage":                           {auth.ScopeAdmin},
+	"/api/v1/usage/quotas":                    {auth.ScopeAdmin},
+	"/api/grafana/":                           nil,
+	"/api/grafana/search":                     nil,
+	"/api/grafana/query":                      nil,
+	"/api/grafana/annotations":                nil,
+	"/api/v1/jobs":                            nil,
+	"/api/v1/jobs/{id}":                       nil,
+	"/api/v1/jobs/{id}/result":                nil,
+	"/api/v1/export":                          nil,
+	"/api/v1/admin/watermarks":                {auth.ScopeAdmin},
+	"/api/v1/admin/timestamps":                {auth.ScopeAdmin},
+	"/api/v1/admin/retention":                 {auth.ScopeAdmin},
+	"/api/v1/admin/retention/apply":           {auth.ScopeAdmin},
+	"/api/v1/admin/retention/purge":           {auth.ScopeAdmin},
+	"/api/v1/admin/deletions":                 {auth.ScopeAdmin},
+	"/api/v1/admin/deletions/{id}":            {auth.ScopeAdmin},
+	"/api/v1/admin/config":                    {auth.ScopeAdmin},
+	"/api/v1/admin/config/reload":             {auth.ScopeAdmin},
+	"/api/v1/admin/audit":                     {auth.ScopeAdmin},
+	"/api/openapi.json":                       nil,
+}
+
+// authPolicy authenticates API callers and holds the scopes of each route
+type authPolicy struct {
+	authn  *auth.Authenticator
+	routes map[string][]string
+}
+
+// newAuthPolicy returns the policy of the auth config, or nil when auth is
+// disabled
+func newAuthPolicy(cfg config.AuthConfig) (*authPolicy, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	authn, err := auth.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	routes := make(map[string][]string, len(routeScopes))
+	for route, scopes := range routeScopes {
+		routes[route] = scopes
+	}
+	for route, scopes := range cfg.RouteScopes {
+		if _, ok := routeScopes[route]; !ok {
+			return nil, fmt.Errorf("auth route_scopes: unknown route %q", route)
+		}
+		routes[route] = scopes
+	}
+	return &authPolicy{authn: authn, routes: routes}, nil
+}
+
+type authPolicyKey struct{}
+
+// authenticate requires a valid API key or token on /api/ routes and the
+// scopes of the route. The caller and the policy are kept in the request
+// context, for the checks of handlers that run other routes in process.
+func (s *QueryService) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.auth == nil || !strings.HasPrefix(r.URL.Path, "/api/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		principal, err := s.auth.authn.Authenticate(r.Context(), auth.Credential(r.Header.Get("Authorization"), r.Header.Get("X-API-Key")))
+		if err != nil {
+			authFailed(w, err)
+			return
+		}
+		setAuditPrincipal(r.Context(), principal)
+		ctx := context.WithValue(auth.NewContext(r.Context(), principal), authPolicyKey{}, s.auth)
+		r = r.WithContext(ctx)
+		if route := mux.CurrentRoute(r); route != nil {
+			tmpl, _ := route.GetPathTemplate()
+			if !authorized(w, r, tmpl) {
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authFailed answers a request without valid credentials
+func authFailed(w http.ResponseWriter, err error) {
+	countAuthFailure(err)
+	w.Header().Set("WWW-Authenticate", `Bearer realm="otelservices"`)
+	http.Error(w, err.Error(), http.StatusUnauthorized)
+}
+
+func countAuthFailure(err error) {
+	reason := "invalid_credentials"
+	if errors.Is(err, auth.ErrNoCredentials) {
+		reason = "missing_credentials"
+	}
+	logger.Debug("Rejected unauthenticated request", "error", err)
+	monitoring.QueryAuthFailures.WithLabelValues(reason).Inc()
+}
+
+// authorize returns an error when the caller of ctx lacks a scope that route
+// requires. Contexts without a caller, from background work or with auth
+// disabled, are not restricted.
+func authorize(ctx context.Context, route string) error {
+	principal := auth.FromContext(ctx)
+	policy, _ := ctx.Value(authPolicyKey{}).(*authPolicy)
+	if principal == nil || policy == nil {
+		return nil
+	}
+	if missing := principal.Missing(policy.routes[route]...); missing != "" {
+		monitoring.QueryAuthFailures.WithLabelValues("forbidden").Inc()
+		return fmt.Errorf("%s requires scope %s", route, missing)
+	}
+	return nil
+}
+
+// authorized checks route with authorize and reports whether the request may
+// proceed, writing a 403 otherwise
+func authorized(w http.ResponseWriter, r *http.Request, route string) bool {
+	if err := authorize(r.Context(), route); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// grpcAuthUnary authenticates unary gRPC calls like the REST API, from the
+// authorization or x-api-key metadata
+func (s *QueryService) grpcAuthUnary() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := s.grpcAuthContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// grpcAuthStream is grpcAuthUnary for streaming calls
+func (s *QueryService) grpcAuthStream() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := s.grpcAuthContext(ss.Context())
+		if err != nil {
+			return err
+		}
+		return handler(srv, &authStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+func (s *QueryService) grpcAuthContext(ctx context.Context) (context.Context, error) {
+	if s.auth == nil {
+		return ctx, nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	first := func(key string) string {
+		if values := md.Get(key); len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+	principal, err := s.auth.authn.Authenticate(ctx, auth.Credential(first("authorization"), first("x-api-key")))
+	if err != nil {
+		countAuthFailure(err)
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	return context.WithValue(auth.NewContext(ctx, principal), authPolicyKey{}, s.auth), nil
+}
+
+// authStream is a server stream with an authenticated context
+type authStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authStream) Context() context.Context {
+	return s.ctx
+}
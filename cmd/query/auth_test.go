@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"otelservices/internal/config"
+	queryv1 "otelservices/proto/query/v1"
+	"otelservices/proto/query/v1/queryv1grpc"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestRouteScopesCoverRoutes(t *testing.T) {
+	for _, op := range apiOperations {
+		if _, ok := routeScopes[op.route]; !ok {
+			t.Errorf("%s %s has no entry in routeScopes", op.method, op.route)
+		}
+	}
+}
+
+// authService returns a query service with a logs-only key, a metrics-only
+// key and an admin key
+func authService(t *testing.T) *QueryService {
+	t.Helper()
+	cfg := config.DefaultConfig()
+	cfg.Auth = config.AuthConfig{
+		Enabled: true,
+		Roles:   map[string][]string{"log-reader": {"read:logs"}},
+		APIKeys: []config.APIKeyConfig{
+			{Name: "logs", Key: "logs-key", Roles: []string{"log-reader"}},
+			{Name: "metrics", Key: "metrics-key", Scopes: []string{"read:metrics"}},
+			{Name: "ops", Key: "admin-key", Scopes: []string{"admin"}},
+		},
+		RouteScopes: map[string][]string{"/api/v1/usage/quotas": {"read:metrics"}},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	s := NewQueryService(cfg, nil)
+	var err error
+	if s.auth, err = newAuthPolicy(cfg.Auth); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestAuthenticate(t *testing.T) {
+	s := authService(t)
+	router := newRouter(s)
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		headers    map[string]string
+		wantStatus int
+		wantBody   string
+	}{
+		{"no credentials", "GET", "/api/v1/logs", "", nil, http.StatusUnauthorized, "missing credentials"},
+		{"unknown key", "GET", "/api/v1/logs", "", map[string]string{"X-API-Key": "guess"}, http.StatusUnauthorized, "invalid credentials"},
+		{"missing scope", "GET", "/api/v1/traces?trace_id=abc", "", map[string]string{"X-API-Key": "logs-key"}, http.StatusForbidden, "requires scope read:traces"},
+		{"scope through a role", "GET", "/api/v1/logs?limit=-5", "", map[string]string{"Authorization": "Bearer logs-key"}, http.StatusBadRequest, "limit must be at least 0"},
+		{"admin route", "GET", "/api/v1/admin/config", "", map[string]string{"X-API-Key": "logs-key"}, http.StatusForbidden, "requires scope admin"},
+		{"admin key", "GET", "/api/v1/admin/config", "", map[string]string{"X-API-Key": "admin-key"}, http.StatusOK, "clickhouse"},
+		{"route override", "GET", "/api/v1/usage/quotas", "", map[string]string{"X-API-Key": "metrics-key"}, http.StatusOK, ""},
+		{"authenticated only", "GET", "/api/openapi.json", "", map[string]string{"X-API-Key": "metrics-key"}, http.StatusOK, "openapi"},
+		{"grafana target scope", "POST", "/api/grafana/query", `{"targets": [{"target": "logs"}]}`, map[string]string{"X-API-Key": "metrics-key"}, http.StatusForbidden, "requires scope read:logs"},
+		{"job kind scope", "POST", "/api/v1/jobs", `{"kind": "traces", "query": {}}`, map[string]string{"X-API-Key": "metrics-key"}, http.StatusForbidden, "requires scope read:traces"},
+		{"export kind scope", "POST", "/api/v1/export", `{"kind": "logs", "format": "csv", "query": {}}`, map[string]string{"X-API-Key": "metrics-key"}, http.StatusForbidden, "requires scope read:logs"},
+		{"health check", "GET", s.config.Monitoring.HealthCheckPath, "", nil, http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+		for k, v := range tt.headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != tt.wantStatus || !strings.Contains(rec.Body.String(), tt.wantBody) {
+			t.Errorf("%s: status %d, body %q", tt.name, rec.Code, rec.Body.String())
+		}
+		if rec.Code == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s: no WWW-Authenticate header", tt.name)
+		}
+	}
+}
+
+func TestAuthJobAccess(t *testing.T) {
+	s := authService(t)
+	s.jobs = newJobManager(map[string]http.HandlerFunc{
+		"logs": func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(`{"logs": []}`)) },
+	}, s.config.ClickHouse.QueryProfiles)
+	router := newRouter(s)
+	j, err := s.jobs.submit("logs", "", []byte(`{}`), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.cancel()
+	for key, want := range map[string]int{"metrics-key": http.StatusForbidden, "logs-key": http.StatusOK} {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/jobs/"+j.snapshot().ID, nil)
+		req.Header.Set("X-API-Key", key)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Errorf("%s: status %d, body %q", key, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestNewAuthPolicy(t *testing.T) {
+	if p, err := newAuthPolicy(config.AuthConfig{}); p != nil || err != nil {
+		t.Errorf("disabled auth = %v, %v", p, err)
+	}
+	cfg := config.AuthConfig{
+		Enabled:     true,
+		APIKeys:     []config.APIKeyConfig{{Name: "ci", Key: "key"}},
+		RouteScopes: map[string][]string{"/api/v2/logs": {"read:logs"}},
+	}
+	if _, err := newAuthPolicy(cfg); err == nil || !strings.Contains(err.Error(), "unknown route") {
+		t.Errorf("unknown route: %v", err)
+	}
+}
+
+func TestGRPCAuth(t *testing.T) {
+	lis := bufconn.Listen(1 << 20)
+	srv := newGRPCServer(authService(t))
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, "bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := queryv1grpc.NewQueryServiceClient(conn)
+
+	tests := []struct {
+		md   metadata.MD
+		want codes.Code
+	}{
+		{nil, codes.Unauthenticated},
+		{metadata.Pairs("x-api-key", "metrics-key"), codes.PermissionDenied},
+		// Authorized, then rejected by validation before any query runs
+		{metadata.Pairs("authorization", "Bearer logs-key"), codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		logs, err := client.LogsQuery(metadata.NewOutgoingContext(ctx, tt.md), &queryv1.LogsQueryRequest{Limit: 50000})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = logs.Recv(); status.Code(err) != tt.want {
+			t.Errorf("%v: err = %v, want %v", tt.md, err, tt.want)
+		}
+	}
+}
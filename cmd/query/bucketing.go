@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultStep is the bucket width used when a metrics request has no step
+const defaultStep = 5 * time.Minute
+
+// parseStep parses a bucket width such as 30s, 5m, 1h or 1d. An empty step
+// means defaultStep.
+func parseStep(step string) (time.Duration, error) {
+	if step == "" {
+		return defaultStep, nil
+	}
+	var d time.Duration
+	var err error
+	if days, ok := strings.CutSuffix(step, "d"); ok {
+		var n int
+		n, err = strconv.Atoi(days)
+		d = time.Duration(n) * 24 * time.Hour
+	} else {
+		d, err = time.ParseDuration(step)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("invalid step %q", step)
+	}
+	if d < time.Second || d%time.Second != 0 {
+		return 0, fmt.Errorf("step must be a positive whole number of seconds, got %q", step)
+	}
+	return d, nil
+}
+
+// validateTimezone checks that tz is empty or an IANA time zone name known
+// to the server. ClickHouse resolves the name itself, so both must agree.
+func validateTimezone(tz string) error {
+	if tz == "" {
+		return nil
+	}
+	if _, err := time.LoadLocation(tz); err != nil {
+		return fmt.Errorf("unknown timezone %q", tz)
+	}
+	return nil
+}
+
+// stepInterval renders a bucket width as a ClickHouse interval in its
+// largest whole unit
+func stepInterval(d time.Duration) string {
+	switch {
+	case d%(24*time.Hour) == 0:
+		return fmt.Sprintf("INTERVAL %d DAY", d/(24*time.Hour))
+	case d%time.Hour == 0:
+		return fmt.Sprintf("INTERVAL %d HOUR", d/time.Hour)
+	case d%time.Minute == 0:
+		return fmt.Sprintf("INTERVAL %d MINUTE", d/time.Minute)
+	}
+	return fmt.Sprintf("INTERVAL %d SECOND", d/time.Second)
+}
+
+// timeBucket returns the expression grouping timestamp into step-wide
+// buckets and the arguments it needs. With a timezone, hour and day buckets
+// start on that zone's local boundaries, including across DST changes, and
+// the bucket timestamps carry its offset; otherwise buckets are UTC-aligned.
+func timeBucket(step time.Duration, tz string) (string, []interface{}) {
+	if tz == "" {
+		return fmt.Sprintf("toStartOfInterval(timestamp, %s)", stepInterval(step)), nil
+	}
+	return fmt.Sprintf("toStartOfInterval(timestamp, %s, ?)", stepInterval(step)), []interface{}{tz}
+}
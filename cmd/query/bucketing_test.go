@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"otelservices/internal/config"
+)
+
+func TestParseStep(t *testing.T) {
+	tests := []struct {
+		step string
+		want time.Duration
+	}{
+		{"", 5 * time.Minute},
+		{"30s", 30 * time.Second},
+		{"1h", time.Hour},
+		{"1d", 24 * time.Hour},
+		{"7d", 7 * 24 * time.Hour},
+	}
+	for _, tt := range tests {
+		got, err := parseStep(tt.step)
+		if err != nil || got != tt.want {
+			t.Errorf("parseStep(%q) = %v, %v; want %v", tt.step, got, err, tt.want)
+		}
+	}
+	for _, bad := range []string{"5", "d", "-1h", "0s", "1.5s", "500ms"} {
+		if _, err := parseStep(bad); err == nil {
+			t.Errorf("parseStep(%q): expected error", bad)
+		}
+	}
+}
+
+func TestStepInterval(t *testing.T) {
+	for d, want := range map[time.Duration]string{
+		24 * time.Hour:   "INTERVAL 1 DAY",
+		6 * time.Hour:    "INTERVAL 6 HOUR",
+		90 * time.Minute: "INTERVAL 90 MINUTE",
+		45 * time.Second: "INTERVAL 45 SECOND",
+	} {
+		if got := stepInterval(d); got != want {
+			t.Errorf("stepInterval(%v) = %s, want %s", d, got, want)
+		}
+	}
+}
+
+func TestBuildMetricsQueryTimezone(t *testing.T) {
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(7 * 24 * time.Hour)
+	req := &MetricsQueryRequest{
+		MetricName:  "orders_total",
+		StartTime:   start,
+		EndTime:     end,
+		Aggregation: "sum",
+		GroupBy:     []string{"region"},
+		Step:        "1d",
+		Timezone:    "Europe/Berlin",
+	}
+
+	query, args := buildMetricsQuery(req, "otel_metrics")
+	if !strings.Contains(query, "toStartOfInterval(timestamp, INTERVAL 1 DAY, ?) as ts") {
+		t.Errorf("Expected local day buckets, got:\n%s", query)
+	}
+	// The timezone is the first select argument
+	expected := []interface{}{"Europe/Berlin", "region", "orders_total", start, end}
+	if len(args) != len(expected) {
+		t.Fatalf("Expected %d args, got %d: %v", len(expected), len(args), args)
+	}
+	for i := range expected {
+		if args[i] != expected[i] {
+			t.Errorf("Arg %d: expected %v, got %v", i, expected[i], args[i])
+		}
+	}
+
+	req.Step, req.Timezone = "", ""
+	query, _ = buildMetricsQuery(req, "otel_metrics")
+	if !strings.Contains(query, "toStartOfInterval(timestamp, INTERVAL 5 MINUTE) as ts") {
+		t.Errorf("Expected default UTC buckets, got:\n%s", query)
+	}
+}
+
+func TestQueryMetricsRejectsInvalidBucketing(t *testing.T) {
+	service := NewQueryService(config.DefaultConfig(), nil)
+	for _, req := range []MetricsQueryRequest{
+		{MetricName: "cpu", Step: "fortnight"},
+		{MetricName: "cpu", Timezone: "Mars/Olympus_Mons"},
+	} {
+		body, _ := json.Marshal(req)
+		w := httptest.NewRecorder()
+		service.QueryMetrics(w, httptest.NewRequest(http.MethodPost, "/api/v1/metrics", bytes.NewReader(body)))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%+v: status = %d, want 400", req, w.Code)
+		}
+	}
+}
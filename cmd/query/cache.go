@@ -0,0 +1,204 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"otelservices/internal/monitoring"
+)
+
+const (
+	// metadataLoadTimeout bounds a single cache fill. Loads are detached from
+	// the request that triggered them since other requests may be waiting.
+	metadataLoadTimeout = 30 * time.Second
+	// cacheWarmTimeout bounds the warm-up done at startup before reporting ready
+	cacheWarmTimeout = 30 * time.Second
+	// cacheWarmConcurrency limits parallel queries issued while warming
+	cacheWarmConcurrency = 4
+	// cacheWarmMaxEntries caps how many per-service and per-metric entries are warmed
+	cacheWarmMaxEntries = 500
+	// metadataCacheMaxEntries caps the cache. Keys come from service and
+	// metric names in request paths, so without a cap any client could grow
+	// it by asking for names that do not exist.
+	metadataCacheMaxEntries = 10000
+)
+
+// metadataCache is a TTL cache for catalog lookups, bounded to maxEntries by
+// evicting the least recently used entries. Concurrent misses for the same
+// key share one load so a cold cache doesn't fan out into identical
+// ClickHouse queries.
+type metadataCache struct {
+	ttl        time.Duration
+	maxEntries int
+	mu         sync.Mutex
+	order      *list.List // front is most recently used
+	entries    map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key     string
+	ready   chan struct{}
+	value   interface{}
+	err     error
+	expires time.Time
+}
+
+// expired reports whether a loaded entry is past its TTL. Entries still
+// loading are not expired.
+func (e *cacheEntry) expired(now time.Time) bool {
+	select {
+	case <-e.ready:
+		return !now.Before(e.expires)
+	default:
+		return false
+	}
+}
+
+// newMetadataCache creates a cache; a non-positive ttl disables caching
+func newMetadataCache(ttl time.Duration) *metadataCache {
+	return &metadataCache{
+		ttl:        ttl,
+		maxEntries: metadataCacheMaxEntries,
+		order:      list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+}
+
+// Get returns the cached value for key, calling load on a miss or expiry
+func (c *metadataCache) Get(key string, load func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	if c.ttl <= 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), metadataLoadTimeout)
+		defer cancel()
+		return load(ctx)
+	}
+
+	c.mu.Lock()
+	if el, ok := c.entries[key]; ok {
+		e := el.Value.(*cacheEntry)
+		select {
+		case <-e.ready:
+			if time.Now().Before(e.expires) {
+				c.order.MoveToFront(el)
+				c.mu.Unlock()
+				monitoring.CacheRequests.WithLabelValues("metadata", "hit").Inc()
+				return e.value, nil
+			}
+			c.remove(el)
+		default:
+			// A load is in flight, wait for it
+			c.order.MoveToFront(el)
+			c.mu.Unlock()
+			monitoring.CacheRequests.WithLabelValues("metadata", "hit").Inc()
+			<-e.ready
+			return e.value, e.err
+		}
+	}
+	e := &cacheEntry{key: key, ready: make(chan struct{})}
+	c.entries[key] = c.order.PushFront(e)
+	c.evict(time.Now())
+	c.mu.Unlock()
+	monitoring.CacheRequests.WithLabelValues("metadata", "miss").Inc()
+
+	ctx, cancel := context.WithTimeout(context.Background(), metadataLoadTimeout)
+	e.value, e.err = load(ctx)
+	cancel()
+	e.expires = time.Now().Add(c.ttl)
+	close(e.ready)
+
+	if e.err != nil {
+		c.mu.Lock()
+		if el, ok := c.entries[key]; ok && el.Value == e {
+			c.remove(el)
+		}
+		c.mu.Unlock()
+	}
+	return e.value, e.err
+}
+
+// evict drops the expired entries at the least recently used end, then the
+// least recently used entries beyond maxEntries. Entries that are loading
+// may be evicted too; their waiters still get the result.
+func (c *metadataCache) evict(now time.Time) {
+	for el := c.order.Back(); el != nil && el.Value.(*cacheEntry).expired(now); el = c.order.Back() {
+		c.remove(el)
+	}
+	for c.order.Len() > c.maxEntries {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *metadataCache) remove(el *list.Element) {
+	e := c.order.Remove(el).(*cacheEntry)
+	delete(c.entries, e.key)
+}
+
+// Len returns the number of cached entries
+func (c *metadataCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// warmCaches pre-populates the service catalog and metric metadata caches so
+// the first dashboard loads after a deploy don't all miss at once. Metric
+// labels are the attribute keys of each metric with their values. Span and
+// log attribute keys have no catalog endpoint, so there is nothing to warm
+// for them. Failures are logged and left for the request path to retry.
+func (s *QueryService) warmCaches(ctx context.Context) {
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(ctx, cacheWarmTimeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, cacheWarmConcurrency)
+	run := func(fn func() error) {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := fn(); err != nil {
+				logger.Warn("Cache warm-up query failed", "error", err)
+			}
+		}()
+	}
+
+	services, err := s.cachedServices()
+	if err != nil {
+		logger.Warn("Cache warm-up failed to load services", "error", err)
+	}
+	for i, svc := range services {
+		if i >= cacheWarmMaxEntries {
+			break
+		}
+		name := svc.ServiceName
+		run(func() error {
+			_, err := s.cachedServiceOperations(name)
+			return err
+		})
+	}
+
+	metrics, err := s.cachedMetricNames()
+	if err != nil {
+		logger.Warn("Cache warm-up failed to load metric names", "error", err)
+	}
+	for i, m := range metrics {
+		if i >= cacheWarmMaxEntries {
+			break
+		}
+		name := m.MetricName
+		run(func() error {
+			_, err := s.cachedMetricLabels(name)
+			return err
+		})
+	}
+
+	wg.Wait()
+	logger.Info("Warmed metadata cache", "entries", s.metadata.Len(), "duration", time.Since(start))
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMetadataCacheHit(t *testing.T) {
+	cache := newMetadataCache(time.Minute)
+	var loads int32
+	load := func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&loads, 1)
+		return "value", nil
+	}
+
+	for i := 0; i < 3; i++ {
+		v, err := cache.Get("key", load)
+		if err != nil || v != "value" {
+			t.Fatalf("Get() = %v, %v", v, err)
+		}
+	}
+	if loads != 1 {
+		t.Errorf("loads = %d, want 1", loads)
+	}
+}
+
+func TestMetadataCacheExpiry(t *testing.T) {
+	cache := newMetadataCache(time.Millisecond)
+	var loads int32
+	load := func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&loads, 1)
+		return "value", nil
+	}
+
+	cache.Get("key", load)
+	time.Sleep(5 * time.Millisecond)
+	cache.Get("key", load)
+	if loads != 2 {
+		t.Errorf("loads = %d, want 2", loads)
+	}
+}
+
+func TestMetadataCacheSharesInFlightLoad(t *testing.T) {
+	cache := newMetadataCache(time.Minute)
+	var loads int32
+	release := make(chan struct{})
+	load := func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&loads, 1)
+		<-release
+		return "value", nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v, err := cache.Get("key", load); err != nil || v != "value" {
+				t.Errorf("Get() = %v, %v", v, err)
+			}
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if loads != 1 {
+		t.Errorf("loads = %d, want 1", loads)
+	}
+}
+
+func TestMetadataCacheDoesNotCacheErrors(t *testing.T) {
+	cache := newMetadataCache(time.Minute)
+	fail := true
+	load := func(ctx context.Context) (interface{}, error) {
+		if fail {
+			return nil, errors.New("clickhouse unavailable")
+		}
+		return "value", nil
+	}
+
+	if _, err := cache.Get("key", load); err == nil {
+		t.Fatal("expected error")
+	}
+	fail = false
+	if v, err := cache.Get("key", load); err != nil || v != "value" {
+		t.Errorf("Get() = %v, %v, want value", v, err)
+	}
+}
+
+func TestMetadataCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := newMetadataCache(time.Minute)
+	cache.maxEntries = 2
+	var loads int32
+	load := func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&loads, 1)
+		return "value", nil
+	}
+
+	cache.Get("a", load)
+	cache.Get("b", load)
+	cache.Get("a", load) // a is now more recently used than b
+	cache.Get("c", load)
+	if cache.Len() != 2 {
+		t.Errorf("Len() = %d, want 2", cache.Len())
+	}
+	cache.Get("a", load)
+	if loads != 3 {
+		t.Errorf("loads = %d, want 3: a should have been kept", loads)
+	}
+	cache.Get("b", load)
+	if loads != 4 {
+		t.Errorf("loads = %d, want 4: b should have been evicted", loads)
+	}
+}
+
+func TestMetadataCacheEvictsExpiredEntries(t *testing.T) {
+	cache := newMetadataCache(time.Millisecond)
+	load := func(ctx context.Context) (interface{}, error) {
+		return "value", nil
+	}
+
+	for _, key := range []string{"a", "b", "c"} {
+		cache.Get(key, load)
+	}
+	time.Sleep(5 * time.Millisecond)
+	cache.Get("d", load)
+	if cache.Len() != 1 {
+		t.Errorf("Len() = %d, want 1 once the expired entries are dropped", cache.Len())
+	}
+}
+
+func TestMetadataCacheDisabled(t *testing.T) {
+	cache := newMetadataCache(0)
+	var loads int32
+	load := func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&loads, 1)
+		return "value", nil
+	}
+
+	cache.Get("key", load)
+	cache.Get("key", load)
+	if loads != 2 {
+		t.Errorf("loads = %d, want 2", loads)
+	}
+	if cache.Len() != 0 {
+		t.Errorf("Len() = %d, want 0", cache.Len())
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// mergeColdAttributes adds the attributes_cold JSON of a tiered span to its
+// hot attributes. On a decode error the hot attributes are returned as is.
+func mergeColdAttributes(hot map[string]string, cold string) (map[string]string, error) {
+	if cold == "" {
+		return hot, nil
+	}
+	var coldAttrs map[string]string
+	if err := json.Unmarshal([]byte(cold), &coldAttrs); err != nil {
+		return hot, fmt.Errorf("failed to decode cold attributes: %w", err)
+	}
+	merged := make(map[string]string, len(hot)+len(coldAttrs))
+	for k, v := range coldAttrs {
+		merged[k] = v
+	}
+	for k, v := range hot {
+		merged[k] = v
+	}
+	return merged, nil
+}
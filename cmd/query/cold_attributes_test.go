@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestMergeColdAttributes(t *testing.T) {
+	hot := map[string]string{"http.method": "GET"}
+
+	got, err := mergeColdAttributes(hot, "")
+	if err != nil || len(got) != 1 {
+		t.Errorf("expected hot attributes unchanged, got %v, %v", got, err)
+	}
+
+	got, err = mergeColdAttributes(hot, `{"custom.a":"1","http.method":"stale"}`)
+	if err != nil {
+		t.Fatalf("mergeColdAttributes failed: %v", err)
+	}
+	if len(got) != 2 || got["custom.a"] != "1" || got["http.method"] != "GET" {
+		t.Errorf("unexpected merged attributes: %v", got)
+	}
+
+	got, err = mergeColdAttributes(hot, "{not json")
+	if err == nil {
+		t.Error("expected an error for invalid JSON")
+	}
+	if len(got) != 1 || got["http.method"] != "GET" {
+		t.Errorf("expected hot attributes on error, got %v", got)
+	}
+}
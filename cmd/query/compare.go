@@ -0,0 +1,306 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"otelservices/internal/monitoring"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// defaultCompareMinSpans is the number of spans an operation needs in
+	// each window before a difference can count as significant
+	defaultCompareMinSpans = 30
+	// defaultCompareSignificance is the p-value below which a difference is
+	// significant
+	defaultCompareSignificance = 0.05
+)
+
+// CompareWindow is one of the two time ranges of a comparison
+type CompareWindow struct {
+	StartTime time.Time `json:"start_time" validate:"required"`
+	EndTime   time.Time `json:"end_time" validate:"required"`
+}
+
+// ServiceCompareRequest compares a service's operations in a comparison
+// window, e.g. after a deploy, with a baseline window, e.g. before it
+type ServiceCompareRequest struct {
+	Baseline   CompareWindow `json:"baseline"`
+	Comparison CompareWindow `json:"comparison"`
+	// MinSpans is the number of spans an operation needs in each window
+	// for a regression to be flagged; defaults to 30
+	MinSpans uint64 `json:"min_spans,omitempty"`
+	// Significance is the p-value below which a difference is flagged;
+	// defaults to 0.05
+	Significance float64 `json:"significance,omitempty" validate:"min=0,max=0.5"`
+}
+
+// WindowStats summarizes an operation's spans in one window
+type WindowStats struct {
+	Spans         uint64  `json:"spans"`
+	Errors        uint64  `json:"errors"`
+	ThroughputRPS float64 `json:"throughput_rps"`
+	ErrorRate     float64 `json:"error_rate"`
+	AvgNs         float64 `json:"avg_ns"`
+	P50Ns         float64 `json:"p50_ns"`
+	P95Ns         float64 `json:"p95_ns"`
+	P99Ns         float64 `json:"p99_ns"`
+
+	variance float64 // sample variance of the duration, for the t-test
+}
+
+// CompareDelta is the change from the baseline to the comparison window.
+// Percentages are relative to the baseline and 0 when it has no spans; the
+// error rate delta is in percentage points.
+type CompareDelta struct {
+	ThroughputPct float64 `json:"throughput_pct"`
+	ErrorRatePP   float64 `json:"error_rate_pp"`
+	AvgPct        float64 `json:"avg_pct"`
+	P50Pct        float64 `json:"p50_pct"`
+	P95Pct        float64 `json:"p95_pct"`
+	P99Pct        float64 `json:"p99_pct"`
+}
+
+// OperationComparison compares one operation across the two windows. The
+// p-values are one-sided, for the comparison window being slower or
+// failing more often.
+type OperationComparison struct {
+	Operation         string       `json:"operation"`
+	Baseline          WindowStats  `json:"baseline"`
+	Comparison        WindowStats  `json:"comparison"`
+	Delta             CompareDelta `json:"delta"`
+	LatencyPValue     float64      `json:"latency_p_value"`
+	ErrorRatePValue   float64      `json:"error_rate_p_value"`
+	LatencyRegression bool         `json:"latency_regression"`
+	ErrorRegression   bool         `json:"error_regression"`
+}
+
+type ServiceCompareResponse struct {
+	ServiceName string                `json:"service_name"`
+	Baseline    CompareWindow         `json:"baseline"`
+	Comparison  CompareWindow         `json:"comparison"`
+	Operations  []OperationComparison `json:"operations"`
+	Regressions int                   `json:"regressions"`
+}
+
+// validate checks that both windows are ordered and do not overlap, so
+// every span belongs to one window
+func (req *ServiceCompareRequest) validate() error {
+	windows := []struct {
+		name string
+		win  CompareWindow
+	}{{"baseline", req.Baseline}, {"comparison", req.Comparison}}
+	for _, w := range windows {
+		if !w.win.EndTime.After(w.win.StartTime) {
+			return fmt.Errorf("%s.end_time must be after %s.start_time", w.name, w.name)
+		}
+	}
+	if req.Baseline.StartTime.Before(req.Comparison.EndTime) && req.Comparison.StartTime.Before(req.Baseline.EndTime) {
+		return fmt.Errorf("baseline and comparison windows overlap")
+	}
+	return nil
+}
+
+// buildCompareQuery summarizes the service's spans per window and operation
+func buildCompareQuery(service string, req *ServiceCompareRequest, table string) (string, []interface{}) {
+	query := fmt.Sprintf(`
+		SELECT
+			(timestamp >= ? AND timestamp <= ?) AS is_comparison,
+			toString(span_name) AS operation,
+			count() AS spans,
+			countIf(status_code = 'error') AS errors,
+			avg(duration_ns) AS avg_ns,
+			if(count() > 1, varSamp(duration_ns), 0) AS variance,
+			quantiles(0.5, 0.95, 0.99)(duration_ns) AS q
+		FROM %s
+		WHERE service_name = ?
+		  AND ((timestamp >= ? AND timestamp <= ?) OR (timestamp >= ? AND timestamp <= ?))
+		GROUP BY is_comparison, operation
+	`, table)
+	args := []interface{}{
+		req.Comparison.StartTime, req.Comparison.EndTime,
+		service,
+		req.Baseline.StartTime, req.Baseline.EndTime,
+		req.Comparison.StartTime, req.Comparison.EndTime,
+	}
+	return query, args
+}
+
+// pctChange is the relative change from a to b in percent, 0 when a is 0
+func pctChange(a, b float64) float64 {
+	if a == 0 {
+		return 0
+	}
+	return (b - a) / a * 100
+}
+
+// upperTailP is the one-sided p-value of a standard normal statistic z
+func upperTailP(z float64) float64 {
+	return 0.5 * math.Erfc(z/math.Sqrt2)
+}
+
+// latencyPValue tests whether the comparison window's mean duration is
+// higher with Welch's t-test, using the normal approximation of the t
+// distribution, which holds for the sample sizes required by min_spans
+func latencyPValue(a, b WindowStats) float64 {
+	if a.Spans < 2 || b.Spans < 2 {
+		return 1
+	}
+	se := math.Sqrt(a.variance/float64(a.Spans) + b.variance/float64(b.Spans))
+	if se == 0 {
+		if b.AvgNs > a.AvgNs {
+			return 0
+		}
+		return 1
+	}
+	return upperTailP((b.AvgNs - a.AvgNs) / se)
+}
+
+// errorRatePValue tests whether the comparison window's error rate is
+// higher with a two-proportion z-test
+func errorRatePValue(a, b WindowStats) float64 {
+	if a.Spans == 0 || b.Spans == 0 {
+		return 1
+	}
+	pooled := float64(a.Errors+b.Errors) / float64(a.Spans+b.Spans)
+	se := math.Sqrt(pooled * (1 - pooled) * (1/float64(a.Spans) + 1/float64(b.Spans)))
+	if se == 0 {
+		return 1
+	}
+	return upperTailP((b.ErrorRate - a.ErrorRate) / se)
+}
+
+// compareOperation fills in the deltas and flags significant regressions
+func compareOperation(op *OperationComparison, minSpans uint64, significance float64) {
+	a, b := op.Baseline, op.Comparison
+	op.Delta = CompareDelta{
+		ThroughputPct: pctChange(a.ThroughputRPS, b.ThroughputRPS),
+		ErrorRatePP:   (b.ErrorRate - a.ErrorRate) * 100,
+		AvgPct:        pctChange(a.AvgNs, b.AvgNs),
+		P50Pct:        pctChange(a.P50Ns, b.P50Ns),
+		P95Pct:        pctChange(a.P95Ns, b.P95Ns),
+		P99Pct:        pctChange(a.P99Ns, b.P99Ns),
+	}
+	op.LatencyPValue = latencyPValue(a, b)
+	op.ErrorRatePValue = errorRatePValue(a, b)
+	enough := a.Spans >= minSpans && b.Spans >= minSpans
+	op.LatencyRegression = enough && op.LatencyPValue < significance
+	op.ErrorRegression = enough && op.ErrorRatePValue < significance
+}
+
+// sortComparisons lists regressions first, then the busiest operations
+func sortComparisons(ops []OperationComparison) {
+	sort.SliceStable(ops, func(i, j int) bool {
+		ri := ops[i].LatencyRegression || ops[i].ErrorRegression
+		rj := ops[j].LatencyRegression || ops[j].ErrorRegression
+		if ri != rj {
+			return ri
+		}
+		if ops[i].Comparison.Spans != ops[j].Comparison.Spans {
+			return ops[i].Comparison.Spans > ops[j].Comparison.Spans
+		}
+		return ops[i].Operation < ops[j].Operation
+	})
+}
+
+// CompareService compares latency percentiles, throughput and error rate of
+// a service's operations between two time windows
+func (s *QueryService) CompareService(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	defer func() {
+		monitoring.QueryDuration.WithLabelValues("compare").Observe(time.Since(start).Seconds())
+	}()
+
+	service := mux.Vars(r)["service"]
+	var req ServiceCompareRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		monitoring.QueryErrors.WithLabelValues("compare").Inc()
+		return
+	}
+	if req.MinSpans == 0 {
+		req.MinSpans = defaultCompareMinSpans
+	}
+	if req.Significance == 0 {
+		req.Significance = defaultCompareSignificance
+	}
+	if !limitTimeRange(w, r, "compare", &req.Baseline.StartTime, &req.Baseline.EndTime) ||
+		!limitTimeRange(w, r, "compare", &req.Comparison.StartTime, &req.Comparison.EndTime) {
+		return
+	}
+	s.markStorageTier(w, "traces", req.Baseline.StartTime)
+
+	table := s.chClient.SpansTable()
+	query, args := buildCompareQuery(service, &req, table)
+	if !s.checkQueryCost(w, r, "compare", query, args...) {
+		return
+	}
+
+	rows, err := s.chClient.Query(r.Context(), query, args...)
+	if err != nil {
+		queryFailed(w, "compare", err)
+		return
+	}
+	defer rows.Close()
+
+	index := map[string]int{}
+	ops := []OperationComparison{}
+	for rows.Next() {
+		var isComparison uint8
+		var operation string
+		var stats WindowStats
+		var q []float64
+		if err := rows.Scan(&isComparison, &operation, &stats.Spans, &stats.Errors, &stats.AvgNs, &stats.variance, &q); err != nil {
+			logger.Error("Error scanning comparison", "error", err)
+			continue
+		}
+		if len(q) == 3 {
+			stats.P50Ns, stats.P95Ns, stats.P99Ns = q[0], q[1], q[2]
+		}
+		win := req.Baseline
+		if isComparison == 1 {
+			win = req.Comparison
+		}
+		stats.ThroughputRPS = float64(stats.Spans) / win.EndTime.Sub(win.StartTime).Seconds()
+		stats.ErrorRate = float64(stats.Errors) / float64(stats.Spans)
+
+		i, ok := index[operation]
+		if !ok {
+			i = len(ops)
+			index[operation] = i
+			ops = append(ops, OperationComparison{Operation: operation})
+		}
+		if isComparison == 1 {
+			ops[i].Comparison = stats
+		} else {
+			ops[i].Baseline = stats
+		}
+	}
+	if err := rows.Err(); err != nil {
+		queryFailed(w, "compare", err)
+		return
+	}
+
+	response := ServiceCompareResponse{
+		ServiceName: service,
+		Baseline:    req.Baseline,
+		Comparison:  req.Comparison,
+		Operations:  ops,
+	}
+	for i := range ops {
+		compareOperation(&ops[i], req.MinSpans, req.Significance)
+		if ops[i].LatencyRegression || ops[i].ErrorRegression {
+			response.Regressions++
+		}
+	}
+	sortComparisons(ops)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServiceCompareRequestValidate(t *testing.T) {
+	t0 := time.Date(2024, 3, 1, 11, 0, 0, 0, time.UTC)
+	req := ServiceCompareRequest{
+		Baseline:   CompareWindow{StartTime: t0, EndTime: t0.Add(time.Hour)},
+		Comparison: CompareWindow{StartTime: t0.Add(time.Hour), EndTime: t0.Add(2 * time.Hour)},
+	}
+	if err := req.validate(); err != nil {
+		t.Fatalf("adjacent windows rejected: %v", err)
+	}
+
+	overlap := req
+	overlap.Comparison.StartTime = t0.Add(30 * time.Minute)
+	if err := overlap.validate(); err == nil || !strings.Contains(err.Error(), "overlap") {
+		t.Errorf("overlapping windows: err = %v", err)
+	}
+
+	inverted := req
+	inverted.Baseline.EndTime = t0
+	if err := inverted.validate(); err == nil || !strings.Contains(err.Error(), "baseline.end_time") {
+		t.Errorf("empty baseline: err = %v", err)
+	}
+
+	if err := validateRequestBody([]byte(`{"baseline": {"start_time": "2024-03-01T11:00:00Z"}}`), &ServiceCompareRequest{}); err == nil ||
+		!strings.Contains(err.Error(), "baseline.end_time is required") {
+		t.Errorf("missing window bound: err = %v", err)
+	}
+}
+
+func TestBuildCompareQuery(t *testing.T) {
+	t0 := time.Date(2024, 3, 1, 11, 0, 0, 0, time.UTC)
+	req := &ServiceCompareRequest{
+		Baseline:   CompareWindow{StartTime: t0, EndTime: t0.Add(time.Hour)},
+		Comparison: CompareWindow{StartTime: t0.Add(time.Hour), EndTime: t0.Add(2 * time.Hour)},
+	}
+	query, args := buildCompareQuery("checkout", req, "otel_traces")
+	if !strings.Contains(query, "GROUP BY is_comparison, operation") || !strings.Contains(query, "FROM otel_traces") {
+		t.Errorf("unexpected query:\n%s", query)
+	}
+	if n := strings.Count(query, "?"); n != len(args) {
+		t.Fatalf("query has %d placeholders but %d args", n, len(args))
+	}
+	if args[0] != req.Comparison.StartTime || args[2] != "checkout" {
+		t.Errorf("unexpected args %v", args)
+	}
+}
+
+func TestCompareOperation(t *testing.T) {
+	base := WindowStats{Spans: 1000, Errors: 10, ThroughputRPS: 1, ErrorRate: 0.01, AvgNs: 100e6, P95Ns: 200e6, variance: 400e12}
+	slower := base
+	slower.AvgNs, slower.P95Ns, slower.ThroughputRPS = 110e6, 260e6, 1.2
+
+	op := OperationComparison{Baseline: base, Comparison: slower}
+	compareOperation(&op, 30, 0.05)
+	if !op.LatencyRegression || op.ErrorRegression {
+		t.Errorf("expected a latency regression only: %+v", op)
+	}
+	if math.Abs(op.Delta.AvgPct-10) > 1e-9 || math.Abs(op.Delta.P95Pct-30) > 1e-9 || math.Abs(op.Delta.ThroughputPct-20) > 1e-9 {
+		t.Errorf("unexpected deltas %+v", op.Delta)
+	}
+
+	failing := base
+	failing.Errors, failing.ErrorRate = 40, 0.04
+	op = OperationComparison{Baseline: base, Comparison: failing}
+	compareOperation(&op, 30, 0.05)
+	if op.LatencyRegression || !op.ErrorRegression {
+		t.Errorf("expected an error regression only: %+v", op)
+	}
+	if math.Abs(op.Delta.ErrorRatePP-3) > 1e-9 {
+		t.Errorf("error rate delta = %v", op.Delta.ErrorRatePP)
+	}
+
+	// Too few spans to flag anything, however large the change
+	few := slower
+	few.Spans = 10
+	op = OperationComparison{Baseline: base, Comparison: few}
+	compareOperation(&op, 30, 0.05)
+	if op.LatencyRegression {
+		t.Error("regression flagged below min_spans")
+	}
+
+	// Faster is never a regression
+	op = OperationComparison{Baseline: slower, Comparison: base}
+	compareOperation(&op, 30, 0.05)
+	if op.LatencyRegression || op.LatencyPValue < 0.5 {
+		t.Errorf("improvement flagged: %+v", op)
+	}
+}
+
+func TestSortComparisons(t *testing.T) {
+	ops := []OperationComparison{
+		{Operation: "b", Comparison: WindowStats{Spans: 10}},
+		{Operation: "a", Comparison: WindowStats{Spans: 10}},
+		{Operation: "busy", Comparison: WindowStats{Spans: 500}},
+		{Operation: "slow", Comparison: WindowStats{Spans: 1}, LatencyRegression: true},
+	}
+	sortComparisons(ops)
+	var got []string
+	for _, op := range ops {
+		got = append(got, op.Operation)
+	}
+	if strings.Join(got, ",") != "slow,busy,a,b" {
+		t.Errorf("order = %v", got)
+	}
+}
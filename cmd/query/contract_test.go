@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"otelservices/internal/clickhouse"
+	"otelservices/internal/config"
+
+	"github.com/gorilla/mux"
+)
+
+// Contract fixtures in testdata/contract pin the JSON clients send to and
+// receive from each public endpoint. A failing fixture means existing clients
+// would break; if the change is additive and intended, update the fixture.
+
+type contractFixture struct {
+	file        string
+	Description string          `json:"description"`
+	Method      string          `json:"method"`
+	Route       string          `json:"route"`
+	Path        string          `json:"path"`
+	Request     json.RawMessage `json:"request,omitempty"`
+	Status      int             `json:"status"`
+	Response    json.RawMessage `json:"response,omitempty"`
+	SkipLive    bool            `json:"skip_live,omitempty"` // endpoint changes server state
+}
+
+// contractTypes maps "METHOD route" to the request and response types of the
+// endpoint. Nil constructors mean the endpoint has no JSON body.
+var contractTypes = map[string]struct {
+	request  func() interface{}
+	response func() interface{}
+}{
+	"POST /api/v1/traces": {
+		func() interface{} { return &TraceQueryRequest{} },
+		func() interface{} { return &TraceQueryResponse{} },
+	},
+	"GET /api/v1/traces": {nil, func() interface{} { return &TraceQueryResponse{} }},
+	"POST /api/v1/traces/otlp": {
+		func() interface{} { return &OTLPExportRequest{} },
+		func() interface{} { return &map[string]interface{}{} },
+	},
+	"GET /api/v1/traces/{trace_id}/otlp":          {nil, func() interface{} { return &map[string]interface{}{} }},
+	"GET /api/v1/traces/{trace_id}/critical-path": {nil, func() interface{} { return &CriticalPathResponse{} }},
+	"GET /api/v1/traces/latency-histogram":        {nil, func() interface{} { return &LatencyHistogramResponse{} }},
+	"POST /api/v1/metrics": {
+		func() interface{} { return &MetricsQueryRequest{} },
+		func() interface{} { return &MetricsQueryResponse{} },
+	},
+	"GET /api/v1/metrics":                       {nil, func() interface{} { return &MetricsQueryResponse{} }},
+	"GET /api/v1/metrics/names":                 {nil, func() interface{} { return &MetricNamesResponse{} }},
+	"GET /api/v1/metrics/{name}/labels":         {nil, func() interface{} { return &MetricLabelsResponse{} }},
+	"GET /api/v1/services/stats":                {nil, func() interface{} { return &[]ServiceStat{} }},
+	"GET /api/v1/services":                      {nil, func() interface{} { return &ServicesResponse{} }},
+	"GET /api/v1/services/{service}/operations": {nil, func() interface{} { return &OperationsResponse{} }},
+	"POST /api/v1/services/{service}/compare": {
+		func() interface{} { return &ServiceCompareRequest{} },
+		func() interface{} { return &ServiceCompareResponse{} },
+	},
+	"GET /api/v1/errors":       {nil, func() interface{} { return &ErrorGroupsResponse{} }},
+	"GET /api/v1/anomalies":    {nil, func() interface{} { return &AnomaliesResponse{} }},
+	"GET /api/v1/slo":          {nil, func() interface{} { return &SLOsResponse{} }},
+	"GET /api/v1/slo/{name}":   {nil, func() interface{} { return &SLOStatus{} }},
+	"GET /api/v1/usage":        {nil, func() interface{} { return &UsageResponse{} }},
+	"GET /api/v1/usage/quotas": {nil, func() interface{} { return &QuotasResponse{} }},
+	"POST /api/v1/logs": {
+		func() interface{} { return &LogsQueryRequest{} },
+		func() interface{} { return &LogsQueryResponse{} },
+	},
+	"GET /api/v1/logs":          {nil, func() interface{} { return &LogsQueryResponse{} }},
+	"GET /api/v1/logs/patterns": {nil, func() interface{} { return &LogPatternsResponse{} }},
+	"POST /api/v1/jobs": {
+		func() interface{} { return &JobSubmitRequest{} },
+		func() interface{} { return &JobInfo{} },
+	},
+	"GET /api/v1/jobs/{id}":        {nil, func() interface{} { return &JobInfo{} }},
+	"DELETE /api/v1/jobs/{id}":     {nil, nil},
+	"GET /api/v1/jobs/{id}/result": {nil, func() interface{} { return &MetricsQueryResponse{} }},
+	"POST /api/v1/export": {
+		func() interface{} { return &ExportRequest{} },
+		func() interface{} { return &JobInfo{} },
+	},
+	"GET /api/v1/admin/watermarks":       {nil, func() interface{} { return &WatermarksResponse{} }},
+	"GET /api/v1/admin/audit":            {nil, func() interface{} { return &AuditResponse{} }},
+	"GET /api/v1/admin/timestamps":       {nil, func() interface{} { return &TimestampsResponse{} }},
+	"GET /api/v1/admin/retention":        {nil, func() interface{} { return &RetentionResponse{} }},
+	"POST /api/v1/admin/retention/apply": {nil, func() interface{} { return &RetentionResponse{} }},
+	"POST /api/v1/admin/retention/purge": {nil, func() interface{} { return &PurgeResponse{} }},
+	"POST /api/v1/admin/deletions": {
+		func() interface{} { return &DeletionRequest{} },
+		func() interface{} { return &DeletionInfo{} },
+	},
+	"GET /api/v1/admin/deletions":      {nil, func() interface{} { return &DeletionsResponse{} }},
+	"GET /api/v1/admin/deletions/{id}": {nil, func() interface{} { return &DeletionInfo{} }},
+	"GET /api/v1/admin/config":         {nil, func() interface{} { return &map[string]interface{}{} }},
+	"POST /api/v1/admin/config/reload": {nil, func() interface{} { return &config.ReloadResult{} }},
+	"GET /api/grafana/":                {nil, nil},
+	"POST /api/grafana/search": {
+		func() interface{} { return &GrafanaSearchRequest{} },
+		func() interface{} { return &[]string{} },
+	},
+	"POST /api/grafana/query": {
+		func() interface{} { return &GrafanaQueryRequest{} },
+		func() interface{} { return &[]GrafanaQueryResult{} },
+	},
+	"POST /api/grafana/annotations": {
+		func() interface{} { return &GrafanaAnnotationRequest{} },
+		func() interface{} { return &[]GrafanaAnnotation{} },
+	},
+	"GET /api/openapi.json": {nil, func() interface{} { return &OpenAPIDocument{} }},
+}
+
+func loadContractFixtures(t *testing.T) []contractFixture {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join("testdata", "contract", "*.json"))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no contract fixtures found: %v", err)
+	}
+
+	fixtures := make([]contractFixture, 0, len(files))
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", file, err)
+		}
+		var f contractFixture
+		if err := json.Unmarshal(data, &f); err != nil {
+			t.Fatalf("failed to parse %s: %v", file, err)
+		}
+		f.file = filepath.Base(file)
+		fixtures = append(fixtures, f)
+	}
+	return fixtures
+}
+
+// roundTrip strictly decodes data into v and checks that encoding v again
+// yields the same JSON, so renamed, removed or newly required fields show up
+func roundTrip(t *testing.T, what string, data json.RawMessage, v interface{}) {
+	t.Helper()
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		t.Fatalf("%s no longer decodes: %v", what, err)
+	}
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("%s no longer encodes: %v", what, err)
+	}
+
+	var want, got interface{}
+	if err := json.Unmarshal(data, &want); err != nil {
+		t.Fatalf("invalid %s fixture: %v", what, err)
+	}
+	if err := json.Unmarshal(encoded, &got); err != nil {
+		t.Fatalf("invalid %s encoding: %v", what, err)
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("%s changed shape:\n got %s\nwant %s", what, encoded, bytes.Join(bytes.Fields(data), []byte(" ")))
+	}
+}
+
+func TestContractFixturesCoverRoutes(t *testing.T) {
+	fixtures := map[string]bool{}
+	for _, f := range loadContractFixtures(t) {
+		key := f.Method + " " + f.Route
+		if _, ok := contractTypes[key]; !ok {
+			t.Errorf("%s: no contract types registered for %s", f.file, key)
+		}
+		fixtures[key] = true
+	}
+
+	router := newRouter(NewQueryService(config.DefaultConfig(), nil))
+	err := router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		tmpl, err := route.GetPathTemplate()
+		if err != nil || !strings.HasPrefix(tmpl, "/api/") {
+			return nil
+		}
+		methods, _ := route.GetMethods()
+		for _, method := range methods {
+			if !fixtures[method+" "+tmpl] {
+				t.Errorf("public endpoint %s %s has no contract fixture", method, tmpl)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to walk routes: %v", err)
+	}
+}
+
+func TestContractRequests(t *testing.T) {
+	for _, f := range loadContractFixtures(t) {
+		t.Run(f.file, func(t *testing.T) {
+			types := contractTypes[f.Method+" "+f.Route]
+			if types.request == nil {
+				if len(f.Request) != 0 {
+					t.Fatal("fixture has a request body but the endpoint takes none")
+				}
+				return
+			}
+			roundTrip(t, "request", f.Request, types.request())
+		})
+	}
+}
+
+func TestContractResponses(t *testing.T) {
+	for _, f := range loadContractFixtures(t) {
+		t.Run(f.file, func(t *testing.T) {
+			types := contractTypes[f.Method+" "+f.Route]
+			if types.response == nil {
+				if len(f.Response) != 0 {
+					t.Fatal("fixture has a response body but the endpoint returns none")
+				}
+				return
+			}
+			roundTrip(t, "response", f.Response, types.response())
+		})
+	}
+}
+
+// TestContractLive replays the fixtures against a server backed by
+// ClickHouse and checks status codes and that responses decode into the
+// contract types. Fixtures that need a job ID are covered by the jobs tests,
+// and fixtures marked skip_live are never replayed.
+func TestContractLive(t *testing.T) {
+	cfg := config.DefaultConfig()
+	chClient, err := clickhouse.NewClient(&cfg.ClickHouse)
+	if err != nil {
+		t.Skip("ClickHouse not available for test")
+	}
+	defer chClient.Close()
+	if err := chClient.Ping(context.Background()); err != nil {
+		t.Skip("ClickHouse not available for test")
+	}
+
+	server := httptest.NewServer(newRouter(NewQueryService(cfg, chClient)))
+	defer server.Close()
+
+	for _, f := range loadContractFixtures(t) {
+		if f.SkipLive || strings.Contains(f.Path, "{") {
+			continue
+		}
+		t.Run(f.file, func(t *testing.T) {
+			req, err := http.NewRequest(f.Method, server.URL+f.Path, bytes.NewReader(f.Request))
+			if err != nil {
+				t.Fatalf("failed to build request: %v", err)
+			}
+			req.Header.Set("Content-Type", "application/json")
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != f.Status {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, f.Status)
+			}
+			types := contractTypes[f.Method+" "+f.Route]
+			if types.response == nil {
+				return
+			}
+			dec := json.NewDecoder(resp.Body)
+			dec.DisallowUnknownFields()
+			if err := dec.Decode(types.response()); err != nil {
+				t.Errorf("response does not match the contract types: %v", err)
+			}
+		})
+	}
+}
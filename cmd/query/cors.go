@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"otelservices/internal/config"
+)
+
+// corsHandler answers CORS preflight requests and adds the CORS headers to
+// responses for allowed origins, so a browser application on another origin
+// can call the API without a proxy. It wraps the router rather than being
+// router middleware, as a preflight OPTIONS request matches no route.
+func corsHandler(cfg config.CORSConfig, next http.Handler) http.Handler {
+	if len(cfg.AllowedOrigins) == 0 {
+		return next
+	}
+	anyOrigin := false
+	origins := make(map[string]bool, len(cfg.AllowedOrigins))
+	for _, origin := range cfg.AllowedOrigins {
+		if origin == "*" {
+			anyOrigin = true
+		}
+		origins[strings.TrimSuffix(origin, "/")] = true
+	}
+	methods := strings.Join(cfg.AllowedMethods, ", ")
+	headers := strings.Join(cfg.AllowedHeaders, ", ")
+	maxAge := strconv.Itoa(int(cfg.MaxAge.Seconds()))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		allowed := anyOrigin || origins[origin]
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		if !allowed {
+			if preflight {
+				// Without the CORS headers the browser refuses the request
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		if anyOrigin {
+			h.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			h.Set("Access-Control-Allow-Origin", origin)
+		}
+		if !preflight {
+			next.ServeHTTP(w, r)
+			return
+		}
+		h.Add("Vary", "Access-Control-Request-Method")
+		h.Add("Vary", "Access-Control-Request-Headers")
+		h.Set("Access-Control-Allow-Methods", methods)
+		if headers != "" {
+			h.Set("Access-Control-Allow-Headers", headers)
+		}
+		if cfg.MaxAge > 0 {
+			h.Set("Access-Control-Max-Age", maxAge)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"otelservices/internal/config"
+)
+
+func TestCORSHandler(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Server.CORS.AllowedOrigins = []string{"https://dash.example.com"}
+	handler := corsHandler(cfg.Server.CORS, newRouter(NewQueryService(cfg, nil)))
+	serve := func(method, path, origin string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	preflight := map[string]string{"Access-Control-Request-Method": "POST", "Access-Control-Request-Headers": "content-type"}
+
+	rec := serve(http.MethodOptions, "/api/v1/logs", "https://dash.example.com", preflight)
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("preflight status = %d", rec.Code)
+	}
+	for header, want := range map[string]string{
+		"Access-Control-Allow-Origin":  "https://dash.example.com",
+		"Access-Control-Allow-Methods": "GET, POST, DELETE",
+		"Access-Control-Allow-Headers": "Content-Type, Authorization",
+		"Access-Control-Max-Age":       "600",
+	} {
+		if got := rec.Header().Get(header); got != want {
+			t.Errorf("%s = %q, want %q", header, got, want)
+		}
+	}
+
+	// Actual requests reach the router with the origin allowed
+	rec = serve(http.MethodGet, "/api/openapi.json", "https://dash.example.com", nil)
+	if rec.Header().Get("Access-Control-Allow-Origin") != "https://dash.example.com" || rec.Header().Get("Access-Control-Allow-Methods") != "" {
+		t.Errorf("GET headers = %v", rec.Header())
+	}
+
+	// Other origins get no CORS headers, so the browser blocks them
+	rec = serve(http.MethodOptions, "/api/v1/logs", "https://evil.example.com", preflight)
+	if rec.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("disallowed origin allowed: %v", rec.Header())
+	}
+
+	// A plain OPTIONS request without an origin is left to the router
+	if rec = serve(http.MethodOptions, "/api/v1/logs", "", nil); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("OPTIONS without origin: status %d", rec.Code)
+	}
+
+	cfg.Server.CORS = config.CORSConfig{AllowedOrigins: []string{"*"}, AllowedMethods: []string{"GET"}, MaxAge: time.Minute}
+	handler = corsHandler(cfg.Server.CORS, http.NotFoundHandler())
+	rec = serve(http.MethodOptions, "/api/v1/logs", "https://any.example.com", preflight)
+	if rec.Header().Get("Access-Control-Allow-Origin") != "*" || rec.Header().Get("Access-Control-Allow-Headers") != "" || rec.Header().Get("Access-Control-Max-Age") != "60" {
+		t.Errorf("wildcard preflight headers = %v", rec.Header())
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"otelservices/internal/config"
+	"otelservices/internal/monitoring"
+)
+
+type queryBudgetKey struct{}
+
+// withQueryBudget attaches the budget checked by checkQueryCost. Only
+// synchronous API requests carry one; async jobs run unchecked.
+func withQueryBudget(ctx context.Context, budget config.QueryBudget) context.Context {
+	return context.WithValue(ctx, queryBudgetKey{}, budget)
+}
+
+func queryBudgetFrom(ctx context.Context) (config.QueryBudget, bool) {
+	budget, ok := ctx.Value(queryBudgetKey{}).(config.QueryBudget)
+	return budget, ok && budget.MaxRows > 0
+}
+
+// queryCost is the amount of data ClickHouse expects a query to read
+type queryCost struct {
+	Parts uint64
+	Rows  uint64
+	Marks uint64
+}
+
+// estimateQueryCost sums the per-table EXPLAIN ESTIMATE output for a query
+func (s *QueryService) estimateQueryCost(ctx context.Context, query string, args ...interface{}) (queryCost, error) {
+	rows, err := s.chClient.Query(ctx, "EXPLAIN ESTIMATE "+query, args...)
+	if err != nil {
+		return queryCost{}, err
+	}
+	defer rows.Close()
+
+	var total queryCost
+	for rows.Next() {
+		var database, table string
+		var parts, rowCount, marks uint64
+		if err := rows.Scan(&database, &table, &parts, &rowCount, &marks); err != nil {
+			return queryCost{}, fmt.Errorf("failed to scan estimate: %w", err)
+		}
+		total.Parts += parts
+		total.Rows += rowCount
+		total.Marks += marks
+	}
+	return total, rows.Err()
+}
+
+// budgetMessage explains an over-budget query to the caller, or returns
+// an empty string when the cost is within budget
+func budgetMessage(cost queryCost, budget config.QueryBudget) string {
+	if budget.MaxRows == 0 || cost.Rows <= budget.MaxRows {
+		return ""
+	}
+	return fmt.Sprintf("query would read about %d rows in %d parts, over the budget of %d rows; narrow the time range or add filters",
+		cost.Rows, cost.Parts, budget.MaxRows)
+}
+
+// checkQueryCost estimates the query when the request carries a budget.
+// Over-budget queries are rejected with 422 and a QueryLimitError, or run with an
+// X-Query-Cost-Warning header when the action is warn. It returns false
+// when the response has already been written. Estimation failures are
+// logged and do not block the query.
+func (s *QueryService) checkQueryCost(w http.ResponseWriter, r *http.Request, queryType, query string, args ...interface{}) bool {
+	budget, ok := queryBudgetFrom(r.Context())
+	if !ok {
+		return true
+	}
+
+	cost, err := s.estimateQueryCost(r.Context(), query, args...)
+	if err != nil {
+		logger.Warn("Error estimating query cost", "query_type", queryType, "error", err)
+		return true
+	}
+	msg := budgetMessage(cost, budget)
+	if msg == "" {
+		return true
+	}
+
+	if budget.Action == "warn" {
+		monitoring.QueryBudgetExceeded.WithLabelValues(queryType, "warn").Inc()
+		w.Header().Set("X-Query-Cost-Warning", msg)
+		return true
+	}
+	monitoring.QueryBudgetExceeded.WithLabelValues(queryType, "reject").Inc()
+	writeLimitError(w, QueryLimitError{
+		Error:  msg,
+		Limit:  "max_rows",
+		Max:    strconv.FormatUint(budget.MaxRows, 10),
+		Actual: strconv.FormatUint(cost.Rows, 10),
+	})
+	return false
+}
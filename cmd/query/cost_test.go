@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"otelservices/internal/config"
+)
+
+func TestBudgetMessage(t *testing.T) {
+	budget := config.QueryBudget{MaxRows: 1000, Action: "reject"}
+
+	if msg := budgetMessage(queryCost{Rows: 1000, Parts: 3}, budget); msg != "" {
+		t.Errorf("expected no message at the budget, got %q", msg)
+	}
+
+	msg := budgetMessage(queryCost{Rows: 5000, Parts: 12}, budget)
+	if !strings.Contains(msg, "5000 rows in 12 parts") || !strings.Contains(msg, "narrow the time range") {
+		t.Errorf("unexpected message: %q", msg)
+	}
+
+	if msg := budgetMessage(queryCost{Rows: 5000}, config.QueryBudget{}); msg != "" {
+		t.Errorf("expected no message without a budget, got %q", msg)
+	}
+}
+
+func TestQueryBudgetFrom(t *testing.T) {
+	if _, ok := queryBudgetFrom(context.Background()); ok {
+		t.Error("expected no budget on a plain context")
+	}
+	if _, ok := queryBudgetFrom(withQueryBudget(context.Background(), config.QueryBudget{Action: "reject"})); ok {
+		t.Error("expected a zero max_rows budget to be disabled")
+	}
+	budget, ok := queryBudgetFrom(withQueryBudget(context.Background(), config.QueryBudget{MaxRows: 10, Action: "warn"}))
+	if !ok || budget.MaxRows != 10 || budget.Action != "warn" {
+		t.Errorf("unexpected budget: %+v, %v", budget, ok)
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"otelservices/internal/monitoring"
+
+	"github.com/gorilla/mux"
+)
+
+// maxCriticalPathSpans bounds the spans read for one trace
+const maxCriticalPathSpans = 100000
+
+// CriticalPathSegment is a stretch of the critical path spent in one span
+// while none of its children on the path ran: the span's own work, or the
+// gap between two of its calls
+type CriticalPathSegment struct {
+	SpanID      string    `json:"span_id"`
+	SpanName    string    `json:"span_name"`
+	ServiceName string    `json:"service_name"`
+	StartTime   time.Time `json:"start_time"`
+	EndTime     time.Time `json:"end_time"`
+	DurationNs  uint64    `json:"duration_ns"`
+}
+
+// CriticalPathContribution is the time a service spends on the critical path
+type CriticalPathContribution struct {
+	ServiceName string  `json:"service_name"`
+	DurationNs  uint64  `json:"duration_ns"`
+	Percent     float64 `json:"percent"`
+}
+
+type CriticalPathResponse struct {
+	TraceID    string                     `json:"trace_id"`
+	RootSpanID string                     `json:"root_span_id"`
+	DurationNs uint64                     `json:"duration_ns"`
+	Segments   []CriticalPathSegment      `json:"segments"`
+	Services   []CriticalPathContribution `json:"services"`
+	// ClampedSpans counts spans on the path that started before or ended
+	// after their parent, usually from clock skew between hosts, and were
+	// cut to the parent's duration
+	ClampedSpans int `json:"clamped_spans"`
+}
+
+// pathSpan is a span of the assembled span tree
+type pathSpan struct {
+	id, parentID  string
+	name, service string
+	start, end    time.Time
+	children      []*pathSpan
+}
+
+const criticalPathQuery = `
+	SELECT span_id, parent_span_id, span_name, service_name, start_time, end_time
+	FROM %s
+	WHERE trace_id = ?%s
+	LIMIT %d
+`
+
+// buildSpanTree links spans to their parents and returns the root the
+// critical path starts from: the span without a stored parent that ends
+// last, the earliest starting on a tie. Spans whose parent is missing are
+// roots of their own subtree.
+func buildSpanTree(spans []*pathSpan) *pathSpan {
+	byID := make(map[string]*pathSpan, len(spans))
+	for _, sp := range spans {
+		byID[sp.id] = sp
+	}
+	var root *pathSpan
+	for _, sp := range spans {
+		if parent, ok := byID[sp.parentID]; ok && parent != sp {
+			parent.children = append(parent.children, sp)
+			continue
+		}
+		if root == nil || sp.end.After(root.end) || (sp.end.Equal(root.end) && sp.start.Before(root.start)) {
+			root = sp
+		}
+	}
+	if root == nil && len(spans) > 0 {
+		// Every span has a parent, so the references form a cycle
+		root = spans[0]
+	}
+	return root
+}
+
+// criticalPath collects the segments of a critical path, latest first
+type criticalPath struct {
+	segments []CriticalPathSegment
+	clamped  int
+	visited  map[*pathSpan]bool
+}
+
+// walk follows the path backwards from end through sp. The child that
+// finishes last is on the path; the time between its end and the end of the
+// span, or the start of the previous path child, is the span's own. The
+// walk then continues in the child, and in the span before the child's
+// start. Children are cut to their parent's duration first.
+func (p *criticalPath) walk(sp *pathSpan, start, end time.Time) {
+	p.visited[sp] = true
+
+	type bounded struct {
+		span       *pathSpan
+		start, end time.Time
+	}
+	children := make([]bounded, 0, len(sp.children))
+	for _, c := range sp.children {
+		if p.visited[c] {
+			continue
+		}
+		b := bounded{span: c, start: c.start, end: c.end}
+		if b.start.Before(start) {
+			b.start = start
+		}
+		if b.end.After(end) {
+			b.end = end
+		}
+		if b.end.After(b.start) {
+			children = append(children, b)
+		}
+	}
+	sort.SliceStable(children, func(i, j int) bool { return children[i].end.After(children[j].end) })
+
+	cursor := end
+	for _, c := range children {
+		// Children ending after the cursor ran in parallel with the path
+		if c.end.After(cursor) || !c.start.Before(cursor) {
+			continue
+		}
+		if !c.start.Equal(c.span.start) || !c.end.Equal(c.span.end) {
+			p.clamped++
+		}
+		p.self(sp, c.end, cursor)
+		p.walk(c.span, c.start, c.end)
+		cursor = c.start
+	}
+	p.self(sp, start, cursor)
+}
+
+// self records that sp is on the path from start to end, in reverse order
+func (p *criticalPath) self(sp *pathSpan, start, end time.Time) {
+	if !end.After(start) {
+		return
+	}
+	p.segments = append(p.segments, CriticalPathSegment{
+		SpanID:      sp.id,
+		SpanName:    sp.name,
+		ServiceName: sp.service,
+		StartTime:   start,
+		EndTime:     end,
+		DurationNs:  uint64(end.Sub(start)),
+	})
+}
+
+// computeCriticalPath returns the critical path of the trace rooted at root
+// and each service's share of it, largest first
+func computeCriticalPath(root *pathSpan) CriticalPathResponse {
+	p := &criticalPath{segments: []CriticalPathSegment{}, visited: map[*pathSpan]bool{}}
+	p.walk(root, root.start, root.end)
+	for i, j := 0, len(p.segments)-1; i < j; i, j = i+1, j-1 {
+		p.segments[i], p.segments[j] = p.segments[j], p.segments[i]
+	}
+
+	resp := CriticalPathResponse{
+		RootSpanID:   root.id,
+		Segments:     p.segments,
+		Services:     []CriticalPathContribution{},
+		ClampedSpans: p.clamped,
+	}
+	if root.end.After(root.start) {
+		resp.DurationNs = uint64(root.end.Sub(root.start))
+	}
+	byService := map[string]uint64{}
+	for _, seg := range p.segments {
+		byService[seg.ServiceName] += seg.DurationNs
+	}
+	for service, d := range byService {
+		c := CriticalPathContribution{ServiceName: service, DurationNs: d}
+		if resp.DurationNs > 0 {
+			c.Percent = float64(d) / float64(resp.DurationNs) * 100
+		}
+		resp.Services = append(resp.Services, c)
+	}
+	sort.Slice(resp.Services, func(i, j int) bool {
+		if resp.Services[i].DurationNs != resp.Services[j].DurationNs {
+			return resp.Services[i].DurationNs > resp.Services[j].DurationNs
+		}
+		return resp.Services[i].ServiceName < resp.Services[j].ServiceName
+	})
+	return resp
+}
+
+// fetchPathSpans reads the spans of a trace needed for its critical path
+func (s *QueryService) fetchPathSpans(ctx context.Context, traceID string) ([]*pathSpan, error) {
+	timeRange, rangeArgs := traceRangeClause(s.traceLookupRange(ctx, []string{traceID}))
+	query := fmt.Sprintf(criticalPathQuery, s.chClient.SpansTable(), timeRange, maxCriticalPathSpans)
+	rows, err := s.chClient.Query(ctx, query, append([]interface{}{traceID}, rangeArgs...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	spans := []*pathSpan{}
+	for rows.Next() {
+		sp := &pathSpan{}
+		if err := rows.Scan(&sp.id, &sp.parentID, &sp.name, &sp.service, &sp.start, &sp.end); err != nil {
+			logger.Error("Error scanning span", "error", err)
+			continue
+		}
+		spans = append(spans, sp)
+	}
+	return spans, rows.Err()
+}
+
+// GetCriticalPath returns the chain of spans that determines a trace's
+// end-to-end latency and each service's share of it
+func (s *QueryService) GetCriticalPath(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	defer func() {
+		monitoring.QueryDuration.WithLabelValues("critical_path").Observe(time.Since(start).Seconds())
+	}()
+
+	ids, err := normalizeTraceIDs([]string{mux.Vars(r)["trace_id"]})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		monitoring.QueryErrors.WithLabelValues("critical_path").Inc()
+		return
+	}
+	spans, err := s.fetchPathSpans(r.Context(), ids[0])
+	if err != nil {
+		queryFailed(w, "critical_path", err)
+		return
+	}
+	root := buildSpanTree(spans)
+	if root == nil {
+		http.Error(w, "trace not found", http.StatusNotFound)
+		return
+	}
+
+	response := computeCriticalPath(root)
+	response.TraceID = ids[0]
+	writeJSONWithETag(w, r, response)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// testSpan builds a span running from startMs to endMs after a fixed epoch
+func testSpan(id, parent, service string, startMs, endMs int) *pathSpan {
+	t0 := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	return &pathSpan{
+		id: id, parentID: parent, name: "op-" + id, service: service,
+		start: t0.Add(time.Duration(startMs) * time.Millisecond),
+		end:   t0.Add(time.Duration(endMs) * time.Millisecond),
+	}
+}
+
+func pathOf(resp CriticalPathResponse) string {
+	var parts []string
+	for _, seg := range resp.Segments {
+		parts = append(parts, seg.SpanID)
+	}
+	return strings.Join(parts, ",")
+}
+
+func TestCriticalPathSequentialAndParallelCalls(t *testing.T) {
+	// root calls a then b in sequence; c runs in parallel with b and
+	// finishes earlier, so it is off the path
+	root := buildSpanTree([]*pathSpan{
+		testSpan("root", "", "frontend", 0, 110),
+		testSpan("a", "root", "auth", 10, 30),
+		testSpan("b", "root", "cart", 40, 90),
+		testSpan("c", "root", "stock", 40, 60),
+		testSpan("b1", "b", "db", 50, 80),
+	})
+	resp := computeCriticalPath(root)
+
+	if got := pathOf(resp); got != "root,a,root,b,b1,b,root" {
+		t.Errorf("path = %s", got)
+	}
+	if resp.DurationNs != uint64(110*time.Millisecond) || resp.RootSpanID != "root" {
+		t.Errorf("unexpected root %s duration %d", resp.RootSpanID, resp.DurationNs)
+	}
+	var total uint64
+	for _, seg := range resp.Segments {
+		total += seg.DurationNs
+	}
+	if total != resp.DurationNs {
+		t.Errorf("segments cover %d ns of %d", total, resp.DurationNs)
+	}
+	if resp.Services[0].ServiceName != "frontend" || resp.Services[0].DurationNs != uint64(40*time.Millisecond) {
+		t.Errorf("largest contribution = %+v", resp.Services[0])
+	}
+	for _, c := range resp.Services {
+		if c.ServiceName == "stock" {
+			t.Error("parallel span off the path contributed")
+		}
+		if c.ServiceName == "db" && c.DurationNs != uint64(30*time.Millisecond) {
+			t.Errorf("db on path for %d ns", c.DurationNs)
+		}
+	}
+}
+
+func TestCriticalPathClampsSkewedChildren(t *testing.T) {
+	root := buildSpanTree([]*pathSpan{
+		testSpan("root", "", "frontend", 0, 100),
+		testSpan("late", "root", "skewed", 20, 120),
+	})
+	resp := computeCriticalPath(root)
+	if got := pathOf(resp); got != "root,late" {
+		t.Errorf("path = %s", got)
+	}
+	if resp.ClampedSpans != 1 {
+		t.Errorf("clamped_spans = %d", resp.ClampedSpans)
+	}
+	if last := resp.Segments[len(resp.Segments)-1]; last.DurationNs != uint64(80*time.Millisecond) {
+		t.Errorf("clamped child on path for %d ns", last.DurationNs)
+	}
+}
+
+func TestBuildSpanTreeRoots(t *testing.T) {
+	// A span whose parent was not stored is a root; the one ending last wins
+	root := buildSpanTree([]*pathSpan{
+		testSpan("early", "", "a", 0, 50),
+		testSpan("orphan", "missing", "b", 10, 80),
+	})
+	if root.id != "orphan" {
+		t.Errorf("root = %s", root.id)
+	}
+
+	if buildSpanTree(nil) != nil {
+		t.Error("empty trace should have no root")
+	}
+
+	// Parent references forming a cycle still produce a path
+	cycle := buildSpanTree([]*pathSpan{
+		testSpan("x", "y", "a", 0, 10),
+		testSpan("y", "x", "a", 0, 10),
+	})
+	if cycle == nil {
+		t.Fatal("cycle should fall back to a root")
+	}
+	if resp := computeCriticalPath(cycle); len(resp.Segments) == 0 {
+		t.Error("no path for a cyclic trace")
+	}
+}
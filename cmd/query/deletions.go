@@ -0,0 +1,371 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"otelservices/internal/clickhouse"
+	"otelservices/internal/monitoring"
+
+	"github.com/gorilla/mux"
+)
+
+// maxDeletions caps the number of deletions tracked; the oldest are forgotten
+// first. Their mutations keep running in ClickHouse regardless.
+const maxDeletions = 100
+
+// Deletion and per-table states
+const (
+	deletionPending = "pending" // mutation not visible in system.mutations yet
+	deletionRunning = "running"
+	deletionDone    = "done"
+	deletionFailed  = "failed"
+)
+
+// deletionTable is a table holding per-service data and how to select it
+type deletionTable struct {
+	Name    string
+	Signal  string // traces, logs or metrics
+	Service string // condition on the service name, with ? placeholders
+	TimeCol string
+}
+
+var deletionTables = []deletionTable{
+	{Name: "otel_traces", Signal: "traces", Service: "service_name = ?", TimeCol: "timestamp"},
+	// Index rows of traces spanning other services stay, so those traces
+	// remain searchable for their other services
+	{Name: "otel_trace_index", Signal: "traces", Service: "service_names = [?]", TimeCol: "min_timestamp"},
+	{Name: "otel_service_operations", Signal: "traces", Service: "service_name = ?", TimeCol: "timestamp"},
+	{Name: "otel_span_stats_1h", Signal: "traces", Service: "service_name = ?", TimeCol: "timestamp"},
+	{Name: "otel_service_dependencies_1h", Signal: "traces", Service: "parent_service = ? OR child_service = ?", TimeCol: "timestamp"},
+	{Name: "otel_logs", Signal: "logs", Service: "service_name = ?", TimeCol: "timestamp"},
+	{Name: "otel_logs_errors_1h", Signal: "logs", Service: "service_name = ?", TimeCol: "timestamp"},
+	{Name: "otel_metrics", Signal: "metrics", Service: "service_name = ?", TimeCol: "timestamp"},
+	{Name: "otel_metrics_5m", Signal: "metrics", Service: "service_name = ?", TimeCol: "timestamp"},
+	{Name: "otel_metrics_1h", Signal: "metrics", Service: "service_name = ?", TimeCol: "timestamp"},
+}
+
+// DeletionRequest asks for a service's telemetry to be deleted. Without
+// Before all of it is deleted; without Signals every signal is.
+type DeletionRequest struct {
+	ServiceName string     `json:"service_name" validate:"required"`
+	Before      *time.Time `json:"before,omitempty"`
+	Signals     []string   `json:"signals,omitempty"` // traces, logs, metrics
+}
+
+// TableDeletion is the progress of the mutation on one table
+type TableDeletion struct {
+	Table     string `json:"table"`
+	Status    string `json:"status"`
+	PartsToDo int64  `json:"parts_to_do"`
+	Error     string `json:"error,omitempty"`
+}
+
+// DeletionInfo is the externally visible state of a deletion
+type DeletionInfo struct {
+	ID          string          `json:"id"`
+	ServiceName string          `json:"service_name"`
+	Before      *time.Time      `json:"before,omitempty"`
+	Signals     []string        `json:"signals"`
+	Status      string          `json:"status"`
+	Tables      []TableDeletion `json:"tables"`
+	CreatedAt   time.Time       `json:"created_at"`
+}
+
+type DeletionsResponse struct {
+	Deletions []DeletionInfo `json:"deletions"`
+}
+
+// validate checks the request and fills in the default signals
+func (req *DeletionRequest) validate() error {
+	if strings.TrimSpace(req.ServiceName) == "" {
+		return fmt.Errorf("service_name is required")
+	}
+	if req.Before != nil && req.Before.IsZero() {
+		return fmt.Errorf("before cannot be the zero time")
+	}
+	if len(req.Signals) == 0 {
+		req.Signals = []string{"traces", "logs", "metrics"}
+	}
+	for _, signal := range req.Signals {
+		switch signal {
+		case "traces", "logs", "metrics":
+		default:
+			return fmt.Errorf("unknown signal %q", signal)
+		}
+	}
+	return nil
+}
+
+// tables returns the tables the request deletes from
+func (req *DeletionRequest) tables() []deletionTable {
+	var tables []deletionTable
+	for _, t := range deletionTables {
+		for _, signal := range req.Signals {
+			if t.Signal == signal {
+				tables = append(tables, t)
+				break
+			}
+		}
+	}
+	return tables
+}
+
+// deletionStatement builds the DELETE mutation for one table. target is
+// what the mutation alters: the table, or its local tables on a cluster.
+func deletionStatement(t deletionTable, target, serviceName string, before *time.Time) (string, []interface{}) {
+	var args []interface{}
+	for i := 0; i < strings.Count(t.Service, "?"); i++ {
+		args = append(args, serviceName)
+	}
+	where := "(" + t.Service + ")"
+	if before != nil {
+		where += fmt.Sprintf(" AND %s < ?", t.TimeCol)
+		args = append(args, *before)
+	}
+	return fmt.Sprintf("ALTER TABLE %s DELETE WHERE %s", target, where), args
+}
+
+// quotedLiteral renders a string the way ClickHouse prints it in the command
+// column of system.mutations
+func quotedLiteral(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
+
+// mutationState summarizes the mutations of one deletion on one table
+type mutationState struct {
+	Mutations  uint64
+	Done       uint64
+	PartsToDo  int64
+	FailReason string
+}
+
+// tableStatus derives a table's deletion status from its mutations
+func tableStatus(m *mutationState) string {
+	switch {
+	case m == nil || m.Mutations == 0:
+		return deletionPending
+	case m.Done == m.Mutations:
+		return deletionDone
+	case m.FailReason != "":
+		return deletionFailed
+	}
+	return deletionRunning
+}
+
+// overallStatus combines table statuses: failed if any failed, done when all
+// are done, pending while none has started
+func overallStatus(tables []TableDeletion) string {
+	counts := make(map[string]int)
+	for _, t := range tables {
+		counts[t.Status]++
+	}
+	switch {
+	case counts[deletionFailed] > 0:
+		return deletionFailed
+	case counts[deletionDone] == len(tables):
+		return deletionDone
+	case counts[deletionPending] == len(tables):
+		return deletionPending
+	}
+	return deletionRunning
+}
+
+type deletion struct {
+	mu   sync.Mutex
+	info DeletionInfo
+}
+
+// deletionManager issues delete mutations and tracks them through
+// system.mutations. Only submitted deletions are tracked, in memory.
+type deletionManager struct {
+	mu        sync.Mutex
+	deletions map[string]*deletion
+	chClient  *clickhouse.Client
+}
+
+func newDeletionManager(chClient *clickhouse.Client) *deletionManager {
+	return &deletionManager{deletions: make(map[string]*deletion), chClient: chClient}
+}
+
+// submit starts the mutations of a validated request. A table whose mutation
+// could not be started is marked failed; the others go ahead.
+func (m *deletionManager) submit(ctx context.Context, req DeletionRequest) *deletion {
+	d := &deletion{info: DeletionInfo{
+		ID:          newJobID(),
+		ServiceName: req.ServiceName,
+		Before:      req.Before,
+		Signals:     req.Signals,
+		CreatedAt:   time.Now().UTC().Truncate(time.Second),
+	}}
+	for _, t := range req.tables() {
+		td := TableDeletion{Table: t.Name, Status: deletionPending}
+		query, args := deletionStatement(t, m.chClient.AlterTable(t.Name), req.ServiceName, req.Before)
+		if err := m.chClient.Exec(ctx, query, args...); err != nil {
+			td.Status = deletionFailed
+			td.Error = err.Error()
+		}
+		d.info.Tables = append(d.info.Tables, td)
+	}
+	d.info.Status = overallStatus(d.info.Tables)
+	logger.Info("Deleting service telemetry", "deletion", d.info.ID, "signals", req.Signals, "service", req.ServiceName, "before", req.Before)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.deletions) >= maxDeletions {
+		var oldest *deletion
+		for _, other := range m.deletions {
+			if oldest == nil || other.info.CreatedAt.Before(oldest.info.CreatedAt) {
+				oldest = other
+			}
+		}
+		delete(m.deletions, oldest.info.ID)
+	}
+	m.deletions[d.info.ID] = d
+	return d
+}
+
+func (m *deletionManager) get(id string) *deletion {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.deletions[id]
+}
+
+func (m *deletionManager) list() []*deletion {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	list := make([]*deletion, 0, len(m.deletions))
+	for _, d := range m.deletions {
+		list = append(list, d)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].info.CreatedAt.After(list[j].info.CreatedAt) })
+	return list
+}
+
+// mutations loads the state of a deletion's mutations per table. Mutations
+// are matched by table, creation time and the quoted service name in their
+// command.
+func (m *deletionManager) mutations(ctx context.Context, info DeletionInfo) (map[string]*mutationState, error) {
+	tables := make([]string, len(info.Tables))
+	for i, t := range info.Tables {
+		tables[i] = m.chClient.LocalTable(t.Table)
+	}
+	// On a cluster each replica runs its own copy of a mutation
+	rows, err := m.chClient.Query(ctx, fmt.Sprintf(`
+		SELECT table, count(), countIf(is_done), sum(parts_to_do), argMax(latest_fail_reason, create_time)
+		FROM %s
+		WHERE database = currentDatabase()
+		  AND table IN ?
+		  AND create_time >= ?
+		  AND position(command, ?) > 0
+		GROUP BY table`, m.chClient.SystemTable("system.mutations")), tables, info.CreatedAt, quotedLiteral(info.ServiceName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query mutations: %w", err)
+	}
+	defer rows.Close()
+
+	states := make(map[string]*mutationState)
+	for rows.Next() {
+		var table string
+		var s mutationState
+		if err := rows.Scan(&table, &s.Mutations, &s.Done, &s.PartsToDo, &s.FailReason); err != nil {
+			return nil, fmt.Errorf("failed to scan mutation: %w", err)
+		}
+		states[strings.TrimSuffix(table, clickhouse.LocalSuffix)] = &s
+	}
+	return states, rows.Err()
+}
+
+// refresh updates a deletion from system.mutations and returns its state
+func (m *deletionManager) refresh(ctx context.Context, d *deletion) (DeletionInfo, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.info.Status == deletionDone {
+		return d.info, nil
+	}
+
+	states, err := m.mutations(ctx, d.info)
+	if err != nil {
+		return d.info, err
+	}
+	for i := range d.info.Tables {
+		t := &d.info.Tables[i]
+		if t.Status == deletionFailed && t.Error != "" && states[t.Table] == nil {
+			// The mutation was never started
+			continue
+		}
+		state := states[t.Table]
+		t.Status = tableStatus(state)
+		if state != nil {
+			t.PartsToDo = state.PartsToDo
+			t.Error = state.FailReason
+		}
+	}
+	d.info.Status = overallStatus(d.info.Tables)
+	return d.info, nil
+}
+
+// SubmitDeletion starts deleting a service's telemetry and returns 202 with
+// the deletion to poll
+func (s *QueryService) SubmitDeletion(w http.ResponseWriter, r *http.Request) {
+	var req DeletionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		monitoring.QueryErrors.WithLabelValues("deletions").Inc()
+		return
+	}
+	if err := req.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		monitoring.QueryErrors.WithLabelValues("deletions").Inc()
+		return
+	}
+
+	d := s.deletions.submit(r.Context(), req)
+	d.mu.Lock()
+	info := d.info
+	d.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/api/v1/admin/deletions/"+info.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(info)
+}
+
+// GetDeletion returns the progress of a deletion
+func (s *QueryService) GetDeletion(w http.ResponseWriter, r *http.Request) {
+	d := s.deletions.get(mux.Vars(r)["id"])
+	if d == nil {
+		http.Error(w, "deletion not found", http.StatusNotFound)
+		return
+	}
+	info, err := s.deletions.refresh(r.Context(), d)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		monitoring.QueryErrors.WithLabelValues("deletions").Inc()
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
+
+// ListDeletions returns the tracked deletions, newest first
+func (s *QueryService) ListDeletions(w http.ResponseWriter, r *http.Request) {
+	response := DeletionsResponse{Deletions: []DeletionInfo{}}
+	for _, d := range s.deletions.list() {
+		info, err := s.deletions.refresh(r.Context(), d)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			monitoring.QueryErrors.WithLabelValues("deletions").Inc()
+			return
+		}
+		response.Deletions = append(response.Deletions, info)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
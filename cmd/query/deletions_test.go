@@ -0,0 +1,108 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDeletionRequestValidate(t *testing.T) {
+	req := DeletionRequest{ServiceName: "legacy"}
+	if err := req.validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(req.Signals, []string{"traces", "logs", "metrics"}) {
+		t.Errorf("default signals = %v", req.Signals)
+	}
+	if len(req.tables()) != len(deletionTables) {
+		t.Errorf("all signals should select every table, got %d", len(req.tables()))
+	}
+
+	req = DeletionRequest{ServiceName: "legacy", Signals: []string{"logs"}}
+	if err := req.validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, table := range req.tables() {
+		if table.Signal != "logs" {
+			t.Errorf("logs deletion selected %s", table.Name)
+		}
+	}
+
+	zero := time.Time{}
+	for name, bad := range map[string]DeletionRequest{
+		"missing service": {ServiceName: " "},
+		"unknown signal":  {ServiceName: "legacy", Signals: []string{"profiles"}},
+		"zero before":     {ServiceName: "legacy", Before: &zero},
+	} {
+		if err := bad.validate(); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestDeletionStatement(t *testing.T) {
+	before := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	query, args := deletionStatement(deletionTables[0], "otel_traces", "legacy", &before)
+	if query != "ALTER TABLE otel_traces DELETE WHERE (service_name = ?) AND timestamp < ?" {
+		t.Errorf("unexpected query %q", query)
+	}
+	if !reflect.DeepEqual(args, []interface{}{"legacy", before}) {
+		t.Errorf("unexpected args %v", args)
+	}
+
+	var deps deletionTable
+	for _, table := range deletionTables {
+		if table.Name == "otel_service_dependencies_1h" {
+			deps = table
+		}
+	}
+	query, args = deletionStatement(deps, "otel_service_dependencies_1h_local ON CLUSTER 'otel'", "legacy", nil)
+	if query != "ALTER TABLE otel_service_dependencies_1h_local ON CLUSTER 'otel' DELETE WHERE (parent_service = ? OR child_service = ?)" {
+		t.Errorf("unexpected query %q", query)
+	}
+	if !reflect.DeepEqual(args, []interface{}{"legacy", "legacy"}) {
+		t.Errorf("unexpected args %v", args)
+	}
+}
+
+func TestQuotedLiteral(t *testing.T) {
+	if got := quotedLiteral(`o'brien\svc`); got != `'o\'brien\\svc'` {
+		t.Errorf("quotedLiteral = %s", got)
+	}
+}
+
+func TestDeletionStatus(t *testing.T) {
+	tests := []struct {
+		state *mutationState
+		want  string
+	}{
+		{nil, deletionPending},
+		{&mutationState{Mutations: 1}, deletionRunning},
+		{&mutationState{Mutations: 1, Done: 1}, deletionDone},
+		{&mutationState{Mutations: 2, Done: 1, FailReason: "Memory limit exceeded"}, deletionFailed},
+	}
+	for _, tt := range tests {
+		if got := tableStatus(tt.state); got != tt.want {
+			t.Errorf("tableStatus(%+v) = %s, want %s", tt.state, got, tt.want)
+		}
+	}
+
+	tables := func(statuses ...string) []TableDeletion {
+		var ts []TableDeletion
+		for _, s := range statuses {
+			ts = append(ts, TableDeletion{Status: s})
+		}
+		return ts
+	}
+	for want, ts := range map[string][]TableDeletion{
+		deletionPending: tables(deletionPending, deletionPending),
+		deletionRunning: tables(deletionPending, deletionDone),
+		deletionDone:    tables(deletionDone, deletionDone),
+		deletionFailed:  tables(deletionDone, deletionFailed, deletionRunning),
+	} {
+		if got := overallStatus(ts); got != want {
+			t.Errorf("overallStatus(%v) = %s, want %s", ts, got, want)
+		}
+	}
+}
@@ -0,0 +1,441 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"otelservices/internal/monitoring"
+	"otelservices/internal/timeutil"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// defaultDiscoveryWindow is the time range used by discovery endpoints when
+	// the request does not specify one
+	defaultDiscoveryWindow = 1 * time.Hour
+	// defaultLabelValuesLimit caps the number of distinct values returned per label key
+	defaultLabelValuesLimit = 100
+)
+
+// Metric discovery structures
+type MetricName struct {
+	MetricName   string `json:"metric_name"`
+	MetricType   string `json:"metric_type"`
+	ServiceCount uint64 `json:"service_count"`
+	SampleCount  uint64 `json:"sample_count"`
+}
+
+type MetricNamesResponse struct {
+	Metrics []MetricName `json:"metrics"`
+	Total   int          `json:"total"`
+}
+
+type MetricLabel struct {
+	Key         string   `json:"key"`
+	Values      []string `json:"values"`
+	Cardinality uint64   `json:"cardinality"`
+}
+
+type MetricLabelsResponse struct {
+	MetricName string        `json:"metric_name"`
+	Labels     []MetricLabel `json:"labels"`
+}
+
+// Service discovery structures
+type ServiceInfo struct {
+	ServiceName    string    `json:"service_name"`
+	OperationCount uint64    `json:"operation_count"`
+	LastSeen       time.Time `json:"last_seen"`
+}
+
+type ServicesResponse struct {
+	Services []ServiceInfo `json:"services"`
+	Total    int           `json:"total"`
+}
+
+type Operation struct {
+	SpanName string    `json:"span_name"`
+	SpanKind string    `json:"span_kind"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+type OperationsResponse struct {
+	ServiceName string      `json:"service_name"`
+	Operations  []Operation `json:"operations"`
+	Total       int         `json:"total"`
+}
+
+// parseTimeRange reads the start and end query parameters, RFC 3339 or
+// relative like now-1h. Missing values default to the window ending now.
+func parseTimeRange(r *http.Request, window time.Duration) (time.Time, time.Time, error) {
+	now := time.Now()
+	end := now
+	if val := r.URL.Query().Get("end"); val != "" {
+		t, err := timeutil.Parse(val, now)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid end time: %w", err)
+		}
+		end = t
+	}
+
+	start := end.Add(-window)
+	if val := r.URL.Query().Get("start"); val != "" {
+		t, err := timeutil.Parse(val, now)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid start time: %w", err)
+		}
+		start = t
+	}
+
+	if start.After(end) {
+		return time.Time{}, time.Time{}, fmt.Errorf("start time must be before end time")
+	}
+	return start, end, nil
+}
+
+// isDefaultRange reports whether the request relies on the default discovery
+// window and limits, which is what dashboards send and what gets cached
+func isDefaultRange(r *http.Request) bool {
+	q := r.URL.Query()
+	return q.Get("start") == "" && q.Get("end") == "" && q.Get("limit") == ""
+}
+
+// buildMetricLabelsQuery builds the query listing label keys and their observed
+// values for a metric. service_name is reported alongside the attribute keys
+// since it can be used for filtering and grouping the same way.
+func buildMetricLabelsQuery(metricName string, start, end time.Time, limit int) (string, []interface{}) {
+	query := fmt.Sprintf(`
+		SELECT
+			label_key,
+			groupUniqArray(%d)(label_value) as label_values,
+			uniq(label_value) as cardinality
+		FROM otel_metrics
+		ARRAY JOIN
+			arrayConcat(['service_name'], mapKeys(attributes)) AS label_key,
+			arrayConcat([toString(service_name)], mapValues(attributes)) AS label_value
+		WHERE metric_name = ?
+		  AND timestamp >= ?
+		  AND timestamp <= ?
+		GROUP BY label_key
+		ORDER BY label_key
+	`, limit)
+
+	return query, []interface{}{metricName, start, end}
+}
+
+// ListMetricNames returns the metric names seen in a time range with their types
+func (s *QueryService) ListMetricNames(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	defer func() {
+		monitoring.QueryDuration.WithLabelValues("metric_names").Observe(time.Since(start).Seconds())
+	}()
+
+	var metrics []MetricName
+	var err error
+	if isDefaultRange(r) {
+		metrics, err = s.cachedMetricNames()
+	} else {
+		from, to, perr := parseTimeRange(r, defaultDiscoveryWindow)
+		if perr != nil {
+			http.Error(w, perr.Error(), http.StatusBadRequest)
+			monitoring.QueryErrors.WithLabelValues("metric_names").Inc()
+			return
+		}
+		metrics, err = s.fetchMetricNames(r.Context(), from, to)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		monitoring.QueryErrors.WithLabelValues("metric_names").Inc()
+		return
+	}
+
+	response := MetricNamesResponse{
+		Metrics: metrics,
+		Total:   len(metrics),
+	}
+
+	writeJSONWithETag(w, r, response)
+}
+
+func (s *QueryService) cachedMetricNames() ([]MetricName, error) {
+	v, err := s.metadata.Get("metric_names", func(ctx context.Context) (interface{}, error) {
+		to := time.Now()
+		return s.fetchMetricNames(ctx, to.Add(-defaultDiscoveryWindow), to)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.([]MetricName), nil
+}
+
+func (s *QueryService) fetchMetricNames(ctx context.Context, from, to time.Time) ([]MetricName, error) {
+	query := `
+		SELECT
+			metric_name,
+			toString(any(metric_type)) as metric_type,
+			uniq(service_name) as service_count,
+			count() as sample_count
+		FROM otel_metrics
+		WHERE timestamp >= ?
+		  AND timestamp <= ?
+		GROUP BY metric_name
+		ORDER BY metric_name
+	`
+
+	rows, err := s.chClient.Query(ctx, query, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	metrics := []MetricName{}
+	for rows.Next() {
+		var m MetricName
+		if err := rows.Scan(&m.MetricName, &m.MetricType, &m.ServiceCount, &m.SampleCount); err != nil {
+			logger.Error("Error scanning metric name", "error", err)
+			continue
+		}
+		metrics = append(metrics, m)
+	}
+	return metrics, nil
+}
+
+// ListMetricLabels returns the label keys and observed values for one metric
+func (s *QueryService) ListMetricLabels(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	defer func() {
+		monitoring.QueryDuration.WithLabelValues("metric_labels").Observe(time.Since(start).Seconds())
+	}()
+
+	metricName := mux.Vars(r)["name"]
+
+	var labels []MetricLabel
+	var err error
+	if isDefaultRange(r) {
+		labels, err = s.cachedMetricLabels(metricName)
+	} else {
+		from, to, perr := parseTimeRange(r, defaultDiscoveryWindow)
+		if perr != nil {
+			http.Error(w, perr.Error(), http.StatusBadRequest)
+			monitoring.QueryErrors.WithLabelValues("metric_labels").Inc()
+			return
+		}
+
+		limit := defaultLabelValuesLimit
+		if val := r.URL.Query().Get("limit"); val != "" {
+			limit, err = strconv.Atoi(val)
+			if err != nil || limit <= 0 {
+				http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+				monitoring.QueryErrors.WithLabelValues("metric_labels").Inc()
+				return
+			}
+		}
+		labels, err = s.fetchMetricLabels(r.Context(), metricName, from, to, limit)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		monitoring.QueryErrors.WithLabelValues("metric_labels").Inc()
+		return
+	}
+
+	response := MetricLabelsResponse{
+		MetricName: metricName,
+		Labels:     labels,
+	}
+
+	writeJSONWithETag(w, r, response)
+}
+
+func (s *QueryService) cachedMetricLabels(metricName string) ([]MetricLabel, error) {
+	v, err := s.metadata.Get("metric_labels/"+metricName, func(ctx context.Context) (interface{}, error) {
+		to := time.Now()
+		return s.fetchMetricLabels(ctx, metricName, to.Add(-defaultDiscoveryWindow), to, defaultLabelValuesLimit)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.([]MetricLabel), nil
+}
+
+func (s *QueryService) fetchMetricLabels(ctx context.Context, metricName string, from, to time.Time, limit int) ([]MetricLabel, error) {
+	query, args := buildMetricLabelsQuery(metricName, from, to, limit)
+	rows, err := s.chClient.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	labels := []MetricLabel{}
+	for rows.Next() {
+		var label MetricLabel
+		if err := rows.Scan(&label.Key, &label.Values, &label.Cardinality); err != nil {
+			logger.Error("Error scanning metric label", "error", err)
+			continue
+		}
+		labels = append(labels, label)
+	}
+	return labels, nil
+}
+
+// ListServices returns the services seen in a time range, read from the
+// otel_service_operations dictionary maintained by the collector
+func (s *QueryService) ListServices(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	defer func() {
+		monitoring.QueryDuration.WithLabelValues("services").Observe(time.Since(start).Seconds())
+	}()
+
+	var services []ServiceInfo
+	var err error
+	if isDefaultRange(r) {
+		services, err = s.cachedServices()
+	} else {
+		from, to, perr := parseTimeRange(r, defaultDiscoveryWindow)
+		if perr != nil {
+			http.Error(w, perr.Error(), http.StatusBadRequest)
+			monitoring.QueryErrors.WithLabelValues("services").Inc()
+			return
+		}
+		services, err = s.fetchServices(r.Context(), from, to)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		monitoring.QueryErrors.WithLabelValues("services").Inc()
+		return
+	}
+
+	response := ServicesResponse{
+		Services: services,
+		Total:    len(services),
+	}
+
+	writeJSONWithETag(w, r, response)
+}
+
+func (s *QueryService) cachedServices() ([]ServiceInfo, error) {
+	v, err := s.metadata.Get("services", func(ctx context.Context) (interface{}, error) {
+		to := time.Now()
+		return s.fetchServices(ctx, to.Add(-defaultDiscoveryWindow), to)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.([]ServiceInfo), nil
+}
+
+func (s *QueryService) fetchServices(ctx context.Context, from, to time.Time) ([]ServiceInfo, error) {
+	// Dictionary rows are hourly buckets, so widen the lower bound to the
+	// bucket containing the start time
+	query := `
+		SELECT
+			service_name,
+			uniq(span_name, span_kind) as operation_count,
+			max(timestamp) as last_seen
+		FROM otel_service_operations
+		WHERE timestamp >= toStartOfHour(?)
+		  AND timestamp <= ?
+		GROUP BY service_name
+		ORDER BY service_name
+	`
+
+	rows, err := s.chClient.Query(ctx, query, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	services := []ServiceInfo{}
+	for rows.Next() {
+		var svc ServiceInfo
+		if err := rows.Scan(&svc.ServiceName, &svc.OperationCount, &svc.LastSeen); err != nil {
+			logger.Error("Error scanning service", "error", err)
+			continue
+		}
+		services = append(services, svc)
+	}
+	return services, nil
+}
+
+// ListServiceOperations returns the span names and kinds of one service
+func (s *QueryService) ListServiceOperations(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	defer func() {
+		monitoring.QueryDuration.WithLabelValues("operations").Observe(time.Since(start).Seconds())
+	}()
+
+	serviceName := mux.Vars(r)["service"]
+
+	var operations []Operation
+	var err error
+	if isDefaultRange(r) {
+		operations, err = s.cachedServiceOperations(serviceName)
+	} else {
+		from, to, perr := parseTimeRange(r, defaultDiscoveryWindow)
+		if perr != nil {
+			http.Error(w, perr.Error(), http.StatusBadRequest)
+			monitoring.QueryErrors.WithLabelValues("operations").Inc()
+			return
+		}
+		operations, err = s.fetchServiceOperations(r.Context(), serviceName, from, to)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		monitoring.QueryErrors.WithLabelValues("operations").Inc()
+		return
+	}
+
+	response := OperationsResponse{
+		ServiceName: serviceName,
+		Operations:  operations,
+		Total:       len(operations),
+	}
+
+	writeJSONWithETag(w, r, response)
+}
+
+func (s *QueryService) cachedServiceOperations(serviceName string) ([]Operation, error) {
+	v, err := s.metadata.Get("operations/"+serviceName, func(ctx context.Context) (interface{}, error) {
+		to := time.Now()
+		return s.fetchServiceOperations(ctx, serviceName, to.Add(-defaultDiscoveryWindow), to)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.([]Operation), nil
+}
+
+func (s *QueryService) fetchServiceOperations(ctx context.Context, serviceName string, from, to time.Time) ([]Operation, error) {
+	query := `
+		SELECT
+			span_name,
+			toString(span_kind) as span_kind,
+			max(timestamp) as last_seen
+		FROM otel_service_operations
+		WHERE service_name = ?
+		  AND timestamp >= toStartOfHour(?)
+		  AND timestamp <= ?
+		GROUP BY span_name, span_kind
+		ORDER BY span_name, span_kind
+	`
+
+	rows, err := s.chClient.Query(ctx, query, serviceName, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	operations := []Operation{}
+	for rows.Next() {
+		var op Operation
+		if err := rows.Scan(&op.SpanName, &op.SpanKind, &op.LastSeen); err != nil {
+			logger.Error("Error scanning operation", "error", err)
+			continue
+		}
+		operations = append(operations, op)
+	}
+	return operations, nil
+}
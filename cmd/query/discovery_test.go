@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTimeRange(t *testing.T) {
+	tests := []struct {
+		name      string
+		url       string
+		wantErr   bool
+		wantStart time.Time
+		wantEnd   time.Time
+	}{
+		{
+			name:      "explicit range",
+			url:       "/x?start=2024-01-01T00:00:00Z&end=2024-01-01T06:00:00Z",
+			wantStart: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			wantEnd:   time.Date(2024, 1, 1, 6, 0, 0, 0, time.UTC),
+		},
+		{
+			name:      "start defaults to window before end",
+			url:       "/x?end=2024-01-01T06:00:00Z",
+			wantStart: time.Date(2024, 1, 1, 5, 0, 0, 0, time.UTC),
+			wantEnd:   time.Date(2024, 1, 1, 6, 0, 0, 0, time.UTC),
+		},
+		{
+			name:    "invalid start",
+			url:     "/x?start=yesterday",
+			wantErr: true,
+		},
+		{
+			name:    "start after end",
+			url:     "/x?start=2024-01-02T00:00:00Z&end=2024-01-01T00:00:00Z",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.url, nil)
+			start, end, err := parseTimeRange(req, time.Hour)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseTimeRange() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !start.Equal(tt.wantStart) {
+				t.Errorf("Expected start %v, got %v", tt.wantStart, start)
+			}
+			if !end.Equal(tt.wantEnd) {
+				t.Errorf("Expected end %v, got %v", tt.wantEnd, end)
+			}
+		})
+	}
+}
+
+func TestParseTimeRangeDefaults(t *testing.T) {
+	req := httptest.NewRequest("GET", "/x", nil)
+	start, end, err := parseTimeRange(req, time.Hour)
+	if err != nil {
+		t.Fatalf("parseTimeRange() error = %v", err)
+	}
+	if time.Since(end) > time.Minute {
+		t.Errorf("Expected end to default to now, got %v", end)
+	}
+	if end.Sub(start) != time.Hour {
+		t.Errorf("Expected 1h window, got %v", end.Sub(start))
+	}
+}
+
+func TestBuildMetricLabelsQuery(t *testing.T) {
+	start := time.Now().Add(-1 * time.Hour)
+	end := time.Now()
+
+	query, args := buildMetricLabelsQuery("http_requests_total", start, end, 25)
+
+	if !strings.Contains(query, "groupUniqArray(25)(label_value)") {
+		t.Errorf("Expected value limit in query, got:\n%s", query)
+	}
+	if !strings.Contains(query, "mapKeys(attributes)") {
+		t.Errorf("Expected attribute keys in query, got:\n%s", query)
+	}
+	if len(args) != 3 || args[0] != "http_requests_total" {
+		t.Errorf("Unexpected args: %v", args)
+	}
+}
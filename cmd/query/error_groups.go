@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"otelservices/internal/models"
+	"otelservices/internal/monitoring"
+)
+
+const (
+	defaultErrorGroupsLimit = 100
+	maxErrorGroupsLimit     = 1000
+	// errorSampleTraces is the number of trace IDs kept per error group
+	errorSampleTraces = 5
+	// maxErrorMessageLength truncates normalized messages, so messages that
+	// only differ in a long tail group together
+	maxErrorMessageLength = 200
+)
+
+// ErrorGroup counts the error spans or ERROR and FATAL logs of a service
+// that share an operation, exception type and normalized message
+type ErrorGroup struct {
+	Source         string    `json:"source"` // spans or logs
+	ServiceName    string    `json:"service_name"`
+	Operation      string    `json:"operation,omitempty"`
+	ExceptionType  string    `json:"exception_type,omitempty"`
+	Message        string    `json:"message"`
+	Count          uint64    `json:"count"`
+	FirstSeen      time.Time `json:"first_seen"`
+	LastSeen       time.Time `json:"last_seen"`
+	SampleTraceIDs []string  `json:"sample_trace_ids"`
+}
+
+type ErrorGroupsResponse struct {
+	Groups []ErrorGroup `json:"groups"`
+	Total  int          `json:"total"`
+}
+
+// messagePatterns replace the variable parts of error messages, in order,
+// so that messages differing only in IDs, addresses or numbers group
+// together. They are RE2 patterns run by ClickHouse's replaceRegexpAll.
+var messagePatterns = []struct{ pattern, placeholder string }{
+	{`"[^"]*"`, "<str>"},
+	{`[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`, "<uuid>"},
+	{`\b\d{1,3}(\.\d{1,3}){3}(:\d+)?\b`, "<ip>"},
+	{`\b0x[0-9a-fA-F]+\b|\b[0-9a-fA-F]{12,}\b`, "<hex>"},
+	{`\d+`, "<num>"},
+}
+
+// normalizedMessage returns the SQL expression normalizing the message in
+// expr with messagePatterns, and the arguments it needs
+func normalizedMessage(expr string) (string, []interface{}) {
+	var args []interface{}
+	for _, p := range messagePatterns {
+		expr = fmt.Sprintf("replaceRegexpAll(%s, ?, ?)", expr)
+		args = append(args, p.pattern, p.placeholder)
+	}
+	return fmt.Sprintf("substring(%s, 1, %d)", expr, maxErrorMessageLength), args
+}
+
+// errorGroupsRequest holds the parsed query parameters of /api/v1/errors
+type errorGroupsRequest struct {
+	service string
+	source  string
+	start   time.Time
+	end     time.Time
+	limit   int
+}
+
+func parseErrorGroupsRequest(r *http.Request) (*errorGroupsRequest, error) {
+	q := r.URL.Query()
+	req := &errorGroupsRequest{service: q.Get("service"), source: q.Get("source"), limit: defaultErrorGroupsLimit}
+	switch req.source {
+	case "", "spans", "logs":
+	default:
+		return nil, fmt.Errorf("source must be spans or logs, got %q", req.source)
+	}
+	var err error
+	if req.start, req.end, err = parseTimeRange(r, defaultDiscoveryWindow); err != nil {
+		return nil, err
+	}
+	if val := q.Get("limit"); val != "" {
+		req.limit, err = strconv.Atoi(val)
+		if err != nil || req.limit <= 0 || req.limit > maxErrorGroupsLimit {
+			return nil, fmt.Errorf("limit must be an integer from 1 to %d", maxErrorGroupsLimit)
+		}
+	}
+	return req, nil
+}
+
+// errorSpansSelect selects the error spans of the request. The exception
+// type and message come from the span's attributes or, as the exception
+// semantic conventions record them, its first exception event.
+func errorSpansSelect(req *errorGroupsRequest, table string) (string, []interface{}) {
+	exception := func(key string) string {
+		return fmt.Sprintf("if(attributes['%[1]s'] != '', attributes['%[1]s'], arrayFirst((a, n) -> n = 'exception', events.attributes, events.name)['%[1]s'])", key)
+	}
+	message, args := normalizedMessage(fmt.Sprintf("if(status_message != '', status_message, %s)", exception("exception.message")))
+	query := fmt.Sprintf(`
+			SELECT
+				'spans' AS source,
+				toString(service_name) AS service,
+				toString(span_name) AS operation,
+				%s AS exception_type,
+				%s AS message,
+				timestamp,
+				trace_id
+			FROM %s
+			WHERE status_code = 'error' AND timestamp >= ? AND timestamp <= ?`, exception("exception.type"), message, table)
+	args = append(args, req.start, req.end)
+	if req.service != "" {
+		query += " AND service_name = ?"
+		args = append(args, req.service)
+	}
+	return query, args
+}
+
+// errorLogsSelect selects the ERROR and FATAL logs of the request. Logs
+// have no operation.
+func errorLogsSelect(req *errorGroupsRequest) (string, []interface{}) {
+	minSeverity, _, _ := models.SeverityRange("ERROR")
+	message, args := normalizedMessage("if(body != '', body, attributes['exception.message'])")
+	query := fmt.Sprintf(`
+			SELECT
+				'logs' AS source,
+				toString(service_name) AS service,
+				'' AS operation,
+				attributes['exception.type'] AS exception_type,
+				%s AS message,
+				timestamp,
+				trace_id
+			FROM otel_logs
+			WHERE severity_number >= ? AND timestamp >= ? AND timestamp <= ?`, message)
+	args = append(args, minSeverity, req.start, req.end)
+	if req.service != "" {
+		query += " AND service_name = ?"
+		args = append(args, req.service)
+	}
+	return query, args
+}
+
+// buildErrorGroupsQuery groups the error spans and logs of the request,
+// largest groups first
+func buildErrorGroupsQuery(req *errorGroupsRequest, spansTable string) (string, []interface{}) {
+	var selects []string
+	var args []interface{}
+	if req.source != "logs" {
+		q, a := errorSpansSelect(req, spansTable)
+		selects = append(selects, q)
+		args = append(args, a...)
+	}
+	if req.source != "spans" {
+		q, a := errorLogsSelect(req)
+		selects = append(selects, q)
+		args = append(args, a...)
+	}
+	inner := selects[0]
+	if len(selects) == 2 {
+		inner += "\n\t\t\tUNION ALL" + selects[1]
+	}
+
+	query := fmt.Sprintf(`
+		SELECT
+			source, service, operation, exception_type, message,
+			count() AS errors,
+			min(timestamp) AS first_seen,
+			max(timestamp) AS last_seen,
+			groupUniqArrayIf(%d)(trace_id, trace_id != '') AS sample_trace_ids
+		FROM (%s
+		)
+		GROUP BY source, service, operation, exception_type, message
+		ORDER BY errors DESC, last_seen DESC
+		LIMIT %d
+	`, errorSampleTraces, inner, req.limit)
+	return query, args
+}
+
+// GetErrorGroups groups recent errors by service, operation, exception type
+// and normalized message, for an error inbox
+func (s *QueryService) GetErrorGroups(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	defer func() {
+		monitoring.QueryDuration.WithLabelValues("errors").Observe(time.Since(start).Seconds())
+	}()
+
+	req, err := parseErrorGroupsRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		monitoring.QueryErrors.WithLabelValues("errors").Inc()
+		return
+	}
+	if !limitTimeRange(w, r, "errors", &req.start, &req.end) {
+		return
+	}
+
+	query, args := buildErrorGroupsQuery(req, s.chClient.SpansTable())
+	if !s.checkQueryCost(w, r, "errors", query, args...) {
+		return
+	}
+
+	rows, err := s.chClient.Query(r.Context(), query, args...)
+	if err != nil {
+		queryFailed(w, "errors", err)
+		return
+	}
+	defer rows.Close()
+
+	groups := []ErrorGroup{}
+	for rows.Next() {
+		var g ErrorGroup
+		if err := rows.Scan(&g.Source, &g.ServiceName, &g.Operation, &g.ExceptionType, &g.Message,
+			&g.Count, &g.FirstSeen, &g.LastSeen, &g.SampleTraceIDs); err != nil {
+			logger.Error("Error scanning error group", "error", err)
+			continue
+		}
+		groups = append(groups, g)
+	}
+	if err := rows.Err(); err != nil {
+		queryFailed(w, "errors", err)
+		return
+	}
+
+	writeJSONWithETag(w, r, ErrorGroupsResponse{Groups: groups, Total: len(groups)})
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// normalizeLikeClickHouse applies messagePatterns the way the query does
+func normalizeLikeClickHouse(msg string) string {
+	for _, p := range messagePatterns {
+		msg = regexp.MustCompile(p.pattern).ReplaceAllLiteralString(msg, p.placeholder)
+	}
+	return msg
+}
+
+func TestMessagePatterns(t *testing.T) {
+	tests := map[string]string{
+		"order 1234 not found":                                   "order <num> not found",
+		`user "bob" has no cart`:                                 "user <str> has no cart",
+		"session 550e8400-e29b-41d4-a716-446655440000 expired":   "session <uuid> expired",
+		"dial tcp 10.0.3.17:5432: connection refused":            "dial tcp <ip>: connection refused",
+		"trace 4bf92f3577b34da6a3ce929d0e0e4736 at 0xc000123abc": "trace <hex> at <hex>",
+		"HTTP 503 from upstream after 30s":                       "HTTP <num> from upstream after <num>s",
+		"deadline exceeded":                                      "deadline exceeded",
+	}
+	for in, want := range tests {
+		if got := normalizeLikeClickHouse(in); got != want {
+			t.Errorf("normalize(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestParseErrorGroupsRequest(t *testing.T) {
+	req, err := parseErrorGroupsRequest(httptest.NewRequest("GET", "/x?service=checkout&source=logs&limit=20", nil))
+	if err != nil {
+		t.Fatalf("parseErrorGroupsRequest() error = %v", err)
+	}
+	if req.service != "checkout" || req.source != "logs" || req.limit != 20 || req.end.Sub(req.start) != time.Hour {
+		t.Errorf("unexpected request %+v", req)
+	}
+
+	for _, url := range []string{"/x?source=metrics", "/x?limit=0", "/x?limit=5000", "/x?end=soon"} {
+		if _, err := parseErrorGroupsRequest(httptest.NewRequest("GET", url, nil)); err == nil {
+			t.Errorf("%s: expected an error", url)
+		}
+	}
+}
+
+func TestBuildErrorGroupsQuery(t *testing.T) {
+	req := &errorGroupsRequest{service: "checkout", limit: 10}
+	query, args := buildErrorGroupsQuery(req, "otel_traces")
+	for _, want := range []string{
+		"status_code = 'error'",
+		"UNION ALL",
+		"FROM otel_logs",
+		"arrayFirst((a, n) -> n = 'exception', events.attributes, events.name)['exception.type']",
+		"groupUniqArrayIf(5)(trace_id, trace_id != '')",
+		"GROUP BY source, service, operation, exception_type, message",
+		"LIMIT 10",
+	} {
+		if !strings.Contains(query, want) {
+			t.Errorf("query missing %q", want)
+		}
+	}
+	if n := strings.Count(query, "?"); n != len(args) {
+		t.Errorf("query has %d placeholders but %d args", n, len(args))
+	}
+
+	req.source = "spans"
+	query, _ = buildErrorGroupsQuery(req, "otel_traces FINAL")
+	if strings.Contains(query, "otel_logs") || !strings.Contains(query, "FROM otel_traces FINAL") {
+		t.Errorf("spans-only query:\n%s", query)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// computeETag returns a strong entity tag for a response body
+func computeETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatches reports whether an If-None-Match header value matches etag.
+// Weak validators are compared with the weak comparison function, as required
+// for If-None-Match (RFC 9110 section 13.1.2).
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	if strings.TrimSpace(ifNoneMatch) == "*" {
+		return true
+	}
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// writeJSONWithETag encodes v as the JSON response body with an ETag header,
+// answering 304 Not Modified when the client already holds the same payload.
+// Used by endpoints that UIs poll for slowly changing data (service lists,
+// metric catalogs) so unchanged responses are not re-downloaded.
+func writeJSONWithETag(w http.ResponseWriter, r *http.Request, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+	body = append(body, '\n')
+
+	etag := computeETag(body)
+	w.Header().Set("ETag", etag)
+	// Clients may keep the payload but must revalidate before reusing it
+	w.Header().Set("Cache-Control", "no-cache")
+
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestEtagMatches(t *testing.T) {
+	etag := `"abc123"`
+
+	tests := []struct {
+		name        string
+		ifNoneMatch string
+		want        bool
+	}{
+		{name: "empty header", ifNoneMatch: "", want: false},
+		{name: "exact match", ifNoneMatch: `"abc123"`, want: true},
+		{name: "weak match", ifNoneMatch: `W/"abc123"`, want: true},
+		{name: "wildcard", ifNoneMatch: "*", want: true},
+		{name: "list with match", ifNoneMatch: `"zzz", "abc123"`, want: true},
+		{name: "no match", ifNoneMatch: `"zzz"`, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := etagMatches(tt.ifNoneMatch, etag); got != tt.want {
+				t.Errorf("etagMatches(%q) = %v, want %v", tt.ifNoneMatch, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteJSONWithETag(t *testing.T) {
+	payload := map[string]interface{}{"services": []string{"api", "db"}}
+
+	req := httptest.NewRequest("GET", "/api/v1/services", nil)
+	w := httptest.NewRecorder()
+	writeJSONWithETag(w, req, payload)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	etag := w.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("Expected ETag header to be set")
+	}
+	if w.Body.Len() == 0 {
+		t.Error("Expected response body")
+	}
+
+	// Same payload with If-None-Match should return 304 and no body
+	req = httptest.NewRequest("GET", "/api/v1/services", nil)
+	req.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	writeJSONWithETag(w, req, payload)
+
+	if w.Code != http.StatusNotModified {
+		t.Errorf("Expected status 304, got %d", w.Code)
+	}
+	if w.Body.Len() != 0 {
+		t.Errorf("Expected empty body for 304, got %q", w.Body.String())
+	}
+
+	// Changed payload must produce a new ETag
+	payload["services"] = []string{"api"}
+	req = httptest.NewRequest("GET", "/api/v1/services", nil)
+	req.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	writeJSONWithETag(w, req, payload)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status 200 after change, got %d", w.Code)
+	}
+	if w.Header().Get("ETag") == etag {
+		t.Error("Expected ETag to change with payload")
+	}
+}
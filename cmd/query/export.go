@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"otelservices/internal/config"
+	"otelservices/internal/monitoring"
+	"otelservices/internal/parquet"
+)
+
+// Export formats and destinations
+const (
+	exportParquet = "parquet"
+	exportCSV     = "csv"
+
+	exportLocal = "local"
+	exportS3    = "s3"
+)
+
+// ExportRequest submits a query as an export job that writes its result as
+// a file. Query holds the same body the synchronous endpoint accepts.
+type ExportRequest struct {
+	Kind        string          `json:"kind" validate:"required,oneof=traces metrics logs"`
+	Format      string          `json:"format" validate:"required,oneof=parquet csv"`
+	Destination string          `json:"destination,omitempty" validate:"oneof=local s3"` // local is the default
+	Query       json.RawMessage `json:"query" validate:"required"`
+}
+
+// ExportOutput describes the file an export job wrote
+type ExportOutput struct {
+	Format      string `json:"format"`
+	Destination string `json:"destination"`
+	Location    string `json:"location"` // file path, or s3://bucket/key
+	Rows        int    `json:"rows"`
+	Bytes       int    `json:"bytes"`
+}
+
+// exportSpec is the output an export job was submitted with
+type exportSpec struct {
+	format      string
+	destination string
+}
+
+// exporter converts query responses to tables and writes them to the
+// configured destinations
+type exporter struct {
+	cfg config.ExportConfig
+	s3  *s3Client // nil when S3 exports are not configured
+}
+
+func newExporter(cfg config.ExportConfig) *exporter {
+	e := &exporter{cfg: cfg}
+	if cfg.S3.Enabled() {
+		e.s3 = newS3Client(cfg.S3)
+	}
+	return e
+}
+
+// check reports whether a destination is configured
+func (e *exporter) check(destination string) error {
+	switch destination {
+	case exportLocal:
+		if e.cfg.Directory == "" {
+			return fmt.Errorf("local exports are not configured")
+		}
+	case exportS3:
+		if e.s3 == nil {
+			return fmt.Errorf("s3 exports are not configured")
+		}
+	default:
+		return fmt.Errorf("unknown export destination %q", destination)
+	}
+	return nil
+}
+
+// write encodes the JSON response of a job and stores it as id with the
+// format's extension
+func (e *exporter) write(ctx context.Context, id, kind string, spec exportSpec, response []byte) (*ExportOutput, error) {
+	t, err := exportTable(kind, response)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	contentType := "text/csv"
+	if spec.format == exportParquet {
+		contentType = "application/vnd.apache.parquet"
+		err = parquet.Write(&buf, t.columns, t.rows)
+	} else {
+		err = t.writeCSV(&buf)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode export: %w", err)
+	}
+
+	out := &ExportOutput{
+		Format:      spec.format,
+		Destination: spec.destination,
+		Rows:        len(t.rows),
+		Bytes:       buf.Len(),
+	}
+	name := id + "." + spec.format
+	switch spec.destination {
+	case exportS3:
+		key, err := e.s3.put(ctx, name, contentType, buf.Bytes())
+		if err != nil {
+			return nil, err
+		}
+		out.Location = e.s3.location(key)
+	default:
+		path := filepath.Join(e.cfg.Directory, name)
+		if err := writeFileAtomic(path, buf.Bytes()); err != nil {
+			return nil, err
+		}
+		out.Location = path
+	}
+	monitoring.ExportedBytes.WithLabelValues(spec.format, spec.destination).Add(float64(out.Bytes))
+	return out, nil
+}
+
+// writeFileAtomic writes through a temporary file so readers never see a
+// partial export
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create export directory: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write export: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write export: %w", err)
+	}
+	return nil
+}
+
+// table is a query result flattened to one row per span, log record or
+// data point. Maps and nested lists become JSON strings.
+type table struct {
+	columns []parquet.Column
+	rows    [][]interface{}
+}
+
+func exportTable(kind string, response []byte) (*table, error) {
+	switch kind {
+	case "traces":
+		var resp TraceQueryResponse
+		if err := json.Unmarshal(response, &resp); err != nil {
+			return nil, fmt.Errorf("failed to decode traces response: %w", err)
+		}
+		return traceTable(resp.Spans), nil
+	case "logs":
+		var resp LogsQueryResponse
+		if err := json.Unmarshal(response, &resp); err != nil {
+			return nil, fmt.Errorf("failed to decode logs response: %w", err)
+		}
+		return logTable(resp.Logs), nil
+	case "metrics":
+		var resp MetricsQueryResponse
+		if err := json.Unmarshal(response, &resp); err != nil {
+			return nil, fmt.Errorf("failed to decode metrics response: %w", err)
+		}
+		return metricTable(&resp), nil
+	}
+	return nil, fmt.Errorf("unknown export kind %q", kind)
+}
+
+func traceTable(spans []Span) *table {
+	t := &table{columns: []parquet.Column{
+		{Name: "trace_id", Type: parquet.String},
+		{Name: "span_id", Type: parquet.String},
+		{Name: "parent_span_id", Type: parquet.String},
+		{Name: "span_name", Type: parquet.String},
+		{Name: "span_kind", Type: parquet.String},
+		{Name: "start_time", Type: parquet.Timestamp},
+		{Name: "end_time", Type: parquet.Timestamp},
+		{Name: "duration_ns", Type: parquet.Int64},
+		{Name: "status_code", Type: parquet.String},
+		{Name: "status_message", Type: parquet.String},
+		{Name: "service_name", Type: parquet.String},
+		{Name: "attributes", Type: parquet.String},
+		{Name: "events", Type: parquet.String},
+		{Name: "links", Type: parquet.String},
+	}}
+	for _, s := range spans {
+		t.rows = append(t.rows, []interface{}{
+			s.TraceID, s.SpanID, s.ParentSpanID, s.SpanName, s.SpanKind,
+			s.StartTime, s.EndTime, int64(s.DurationNs),
+			s.StatusCode, s.StatusMessage, s.ServiceName,
+			jsonString(s.Attributes), jsonString(s.Events), jsonString(s.Links),
+		})
+	}
+	return t
+}
+
+func logTable(logs []LogRecord) *table {
+	t := &table{columns: []parquet.Column{
+		{Name: "timestamp", Type: parquet.Timestamp},
+		{Name: "severity_number", Type: parquet.Int64},
+		{Name: "severity_text", Type: parquet.String},
+		{Name: "body", Type: parquet.String},
+		{Name: "service_name", Type: parquet.String},
+		{Name: "trace_id", Type: parquet.String},
+		{Name: "span_id", Type: parquet.String},
+		{Name: "attributes", Type: parquet.String},
+	}}
+	for _, l := range logs {
+		t.rows = append(t.rows, []interface{}{
+			l.Timestamp, int64(l.SeverityNumber), l.SeverityText, l.Body,
+			l.ServiceName, l.TraceID, l.SpanID, jsonString(l.Attributes),
+		})
+	}
+	return t
+}
+
+// metricTable has one row per data point; grouped queries carry their
+// group-by labels on each point
+func metricTable(resp *MetricsQueryResponse) *table {
+	t := &table{columns: []parquet.Column{
+		{Name: "metric_name", Type: parquet.String},
+		{Name: "timestamp", Type: parquet.Timestamp},
+		{Name: "value", Type: parquet.Double},
+		{Name: "labels", Type: parquet.String},
+	}}
+	for _, dp := range resp.DataPoints {
+		t.rows = append(t.rows, []interface{}{resp.MetricName, dp.Timestamp, dp.Value, jsonString(dp.Labels)})
+	}
+	return t
+}
+
+// jsonString encodes maps and lists for a string column; empty ones become ""
+func jsonString(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	switch string(data) {
+	case "null", "{}", "[]":
+		return ""
+	}
+	return string(data)
+}
+
+// writeCSV writes a header row and the rows, with timestamps in RFC 3339
+func (t *table) writeCSV(buf *bytes.Buffer) error {
+	w := csv.NewWriter(buf)
+	record := make([]string, len(t.columns))
+	for i, c := range t.columns {
+		record[i] = c.Name
+	}
+	w.Write(record)
+	for _, row := range t.rows {
+		for i, v := range row {
+			switch v := v.(type) {
+			case string:
+				record[i] = v
+			case int64:
+				record[i] = strconv.FormatInt(v, 10)
+			case float64:
+				record[i] = strconv.FormatFloat(v, 'g', -1, 64)
+			case time.Time:
+				record[i] = v.UTC().Format(time.RFC3339Nano)
+			default:
+				record[i] = fmt.Sprint(v)
+			}
+		}
+		w.Write(record)
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// SubmitExport starts an export job that writes a query's result as a
+// Parquet or CSV file. Progress is polled like any other job.
+func (s *QueryService) SubmitExport(w http.ResponseWriter, r *http.Request) {
+	var req ExportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		monitoring.QueryErrors.WithLabelValues("export").Inc()
+		return
+	}
+	if !authorized(w, r, "/api/v1/"+req.Kind) {
+		return
+	}
+	if req.Destination == "" {
+		req.Destination = exportLocal
+	}
+	if req.Format != exportParquet && req.Format != exportCSV {
+		http.Error(w, fmt.Sprintf("unsupported export format %q", req.Format), http.StatusBadRequest)
+		monitoring.QueryErrors.WithLabelValues("export").Inc()
+		return
+	}
+	if err := s.jobs.exports.check(req.Destination); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		monitoring.QueryErrors.WithLabelValues("export").Inc()
+		return
+	}
+	if len(req.Query) == 0 {
+		http.Error(w, "query is required", http.StatusBadRequest)
+		monitoring.QueryErrors.WithLabelValues("export").Inc()
+		return
+	}
+	if op := findOperation(http.MethodPost, "/api/v1/"+req.Kind); op != nil {
+		var err error
+		if req.Query, err = prepareRequestBody(req.Query, op.request(), time.Now()); err != nil {
+			http.Error(w, "query: "+err.Error(), http.StatusBadRequest)
+			monitoring.QueryErrors.WithLabelValues("export").Inc()
+			return
+		}
+	}
+
+	j, err := s.jobs.submitExport(req.Kind, req.Query, exportSpec{format: req.Format, destination: req.Destination})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		monitoring.QueryErrors.WithLabelValues("export").Inc()
+		return
+	}
+
+	info := j.snapshot()
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/api/v1/jobs/"+info.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(info)
+}
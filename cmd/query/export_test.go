@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"otelservices/internal/config"
+)
+
+func TestExportTableLogs(t *testing.T) {
+	ts := time.Date(2024, 1, 1, 12, 0, 0, 500, time.UTC)
+	body, _ := json.Marshal(LogsQueryResponse{Logs: []LogRecord{
+		{Timestamp: ts, SeverityNumber: 17, SeverityText: "ERROR", Body: "payment failed, retrying",
+			ServiceName: "checkout", Attributes: map[string]string{"order.id": "42"}},
+		{Timestamp: ts, Body: "ok"},
+	}, Total: 2})
+
+	tbl, err := exportTable("logs", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := tbl.writeCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("invalid CSV: %v", err)
+	}
+	if len(records) != 3 || records[0][0] != "timestamp" {
+		t.Fatalf("unexpected records %v", records)
+	}
+	want := []string{"2024-01-01T12:00:00.0000005Z", "17", "ERROR", "payment failed, retrying", "checkout", "", "", `{"order.id":"42"}`}
+	if strings.Join(records[1], "|") != strings.Join(want, "|") {
+		t.Errorf("row = %q, want %q", records[1], want)
+	}
+	if records[2][7] != "" {
+		t.Errorf("empty attributes = %q, want empty", records[2][7])
+	}
+}
+
+func TestExportTableMetrics(t *testing.T) {
+	body, _ := json.Marshal(MetricsQueryResponse{
+		MetricName: "cpu",
+		DataPoints: []MetricDataPoint{{Timestamp: time.Unix(0, 0), Value: 0.25, Labels: map[string]string{"host": "a"}}},
+		Series:     []MetricSeries{{Labels: map[string]string{"host": "a"}}},
+	})
+	tbl, err := exportTable("metrics", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tbl.rows) != 1 || tbl.rows[0][0] != "cpu" || tbl.rows[0][2] != 0.25 || tbl.rows[0][3] != `{"host":"a"}` {
+		t.Errorf("unexpected rows %v", tbl.rows)
+	}
+	if _, err := exportTable("profiles", body); err == nil {
+		t.Error("expected error for an unknown kind")
+	}
+}
+
+func TestExporterWritesLocalFile(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "exports")
+	e := newExporter(config.ExportConfig{Directory: dir})
+	if err := e.check(exportS3); err == nil {
+		t.Error("s3 should not be available without configuration")
+	}
+
+	body, _ := json.Marshal(TraceQueryResponse{Spans: []Span{{TraceID: "abc", SpanID: "1", StartTime: time.Now()}}, Total: 1})
+	out, err := e.write(context.Background(), "job1", "traces", exportSpec{format: exportParquet, destination: exportLocal}, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Location != filepath.Join(dir, "job1.parquet") || out.Rows != 1 {
+		t.Errorf("unexpected output %+v", out)
+	}
+	data, err := os.ReadFile(out.Location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != out.Bytes || !bytes.HasPrefix(data, []byte("PAR1")) || !bytes.HasSuffix(data, []byte("PAR1")) {
+		t.Errorf("file is not a Parquet file of %d bytes", out.Bytes)
+	}
+}
+
+func TestExportJob(t *testing.T) {
+	dir := t.TempDir()
+	m := newJobManager(map[string]http.HandlerFunc{
+		"logs": func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Accept") != "" {
+				t.Errorf("export jobs should request JSON, got Accept %q", r.Header.Get("Accept"))
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"logs":[{"timestamp":"2024-01-01T00:00:00Z","body":"hello"}],"total":1}`))
+		},
+	}, config.QueryProfiles{})
+	m.exports = newExporter(config.ExportConfig{Directory: dir})
+
+	j, err := m.submitExport("logs", []byte(`{}`), exportSpec{format: exportCSV, destination: exportLocal})
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := waitForJob(t, j)
+	if info.Status != JobSucceeded || info.Class != jobClassExport {
+		t.Fatalf("status = %s class = %s (error %q)", info.Status, info.Class, info.Error)
+	}
+	if info.Export == nil || info.Export.Location != filepath.Join(dir, info.ID+".csv") || info.Export.Rows != 1 {
+		t.Fatalf("unexpected export %+v", info.Export)
+	}
+	data, _ := os.ReadFile(info.Export.Location)
+	if !strings.Contains(string(data), "hello") {
+		t.Errorf("export file = %q", data)
+	}
+}
+
+func TestSubmitExportRejectsUnconfiguredDestination(t *testing.T) {
+	s := &QueryService{jobs: newJobManager(nil, config.QueryProfiles{})}
+	for _, body := range []string{
+		`{"kind":"logs","format":"csv","query":{}}`,
+		`{"kind":"logs","format":"parquet","destination":"s3","query":{}}`,
+		`{"kind":"logs","format":"xlsx","query":{}}`,
+	} {
+		rec := httptest.NewRecorder()
+		s.SubmitExport(rec, httptest.NewRequest(http.MethodPost, "/api/v1/export", strings.NewReader(body)))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", body, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"otelservices/internal/config"
+	"otelservices/internal/monitoring"
+)
+
+// Reasons a request is turned away by the query gate
+var (
+	errQueueFull    = errors.New("too many queries waiting")
+	errQueueTimeout = errors.New("timed out waiting for a query slot")
+)
+
+// queryGate bounds the synchronous requests that query ClickHouse at once,
+// so one heavy dashboard cannot take every connection. Requests over the
+// limit wait in a bounded queue for up to queue_timeout and are then
+// rejected with 503 and Retry-After. It also applies per-route timeouts.
+type queryGate struct {
+	slots    chan struct{} // nil when concurrency is unlimited
+	maxQueue int64
+	queued   atomic.Int64
+	wait     time.Duration
+	timeouts map[string]time.Duration
+	timeout  time.Duration
+}
+
+func newQueryGate(cfg config.QueryGate) *queryGate {
+	g := &queryGate{
+		maxQueue: int64(cfg.MaxQueued),
+		wait:     cfg.QueueTimeout,
+		timeouts: cfg.Timeouts,
+		timeout:  cfg.DefaultTimeout,
+	}
+	if cfg.MaxConcurrent > 0 {
+		g.slots = make(chan struct{}, cfg.MaxConcurrent)
+	}
+	return g
+}
+
+// acquire waits for a slot. On success the caller must call release.
+func (g *queryGate) acquire(ctx context.Context) error {
+	if g.slots == nil {
+		return nil
+	}
+	select {
+	case g.slots <- struct{}{}:
+		monitoring.QueriesInFlight.Inc()
+		return nil
+	default:
+	}
+
+	if g.queued.Add(1) > g.maxQueue {
+		g.queued.Add(-1)
+		return errQueueFull
+	}
+	monitoring.QueriesQueued.Inc()
+	defer func() {
+		g.queued.Add(-1)
+		monitoring.QueriesQueued.Dec()
+	}()
+
+	timer := time.NewTimer(g.wait)
+	defer timer.Stop()
+	select {
+	case g.slots <- struct{}{}:
+		monitoring.QueriesInFlight.Inc()
+		return nil
+	case <-timer.C:
+		return errQueueTimeout
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (g *queryGate) release() {
+	if g.slots == nil {
+		return
+	}
+	<-g.slots
+	monitoring.QueriesInFlight.Dec()
+}
+
+// routeTimeout returns the timeout of route, or the default
+func (g *queryGate) routeTimeout(route string) time.Duration {
+	if timeout, ok := g.timeouts[route]; ok {
+		return timeout
+	}
+	return g.timeout
+}
+
+// wrap runs next holding a slot and with the route's timeout. Requests that
+// get no slot are rejected with 503 and a Retry-After of the queue timeout.
+func (g *queryGate) wrap(route string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if timeout := g.routeTimeout(route); timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+
+		if err := g.acquire(ctx); err != nil {
+			reason := "queue_timeout"
+			if errors.Is(err, errQueueFull) {
+				reason = "queue_full"
+			}
+			monitoring.QueriesRejected.WithLabelValues(route, reason).Inc()
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Max(1, math.Ceil(g.wait.Seconds())))))
+			http.Error(w, "Query API is saturated: "+err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		defer g.release()
+		next(w, r.WithContext(ctx))
+	}
+}
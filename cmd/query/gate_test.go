@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"otelservices/internal/config"
+)
+
+func TestQueryGateRejectsWhenSaturated(t *testing.T) {
+	g := newQueryGate(config.QueryGate{MaxConcurrent: 1, MaxQueued: 1, QueueTimeout: 50 * time.Millisecond})
+	started, unblock := make(chan struct{}), make(chan struct{})
+	handler := g.wrap("/api/v1/traces", func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-unblock
+	})
+
+	done := make(chan struct{})
+	go func() {
+		handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/v1/traces", nil))
+		close(done)
+	}()
+	<-started
+
+	// The second request waits in the queue until queue_timeout
+	queuedDone := make(chan *httptest.ResponseRecorder)
+	go func() {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodPost, "/api/v1/traces", nil))
+		queuedDone <- rec
+	}()
+	for g.queued.Load() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// The queue is full, so the third is rejected at once
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodPost, "/api/v1/traces", nil))
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "1" {
+		t.Errorf("full queue: got %d, Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	if rec := <-queuedDone; rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("queued request should time out with 503, got %d", rec.Code)
+	}
+	close(unblock)
+	<-done
+}
+
+func TestQueryGateQueuedRequestRuns(t *testing.T) {
+	g := newQueryGate(config.QueryGate{MaxConcurrent: 1, MaxQueued: 1, QueueTimeout: time.Second})
+	if err := g.acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for g.queued.Load() != 1 {
+			time.Sleep(time.Millisecond)
+		}
+		g.release()
+	}()
+	if err := g.acquire(context.Background()); err != nil {
+		t.Errorf("queued request should get the released slot: %v", err)
+	}
+	g.release()
+}
+
+func TestQueryGateTimeouts(t *testing.T) {
+	g := newQueryGate(config.QueryGate{
+		DefaultTimeout: time.Minute,
+		Timeouts:       map[string]time.Duration{"/api/v1/traces": time.Second},
+	})
+	for route, want := range map[string]time.Duration{"/api/v1/traces": time.Second, "/api/v1/logs": time.Minute} {
+		var remaining time.Duration
+		g.wrap(route, func(w http.ResponseWriter, r *http.Request) {
+			deadline, ok := r.Context().Deadline()
+			if !ok {
+				t.Fatalf("%s: no deadline", route)
+			}
+			remaining = time.Until(deadline)
+		})(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, route, nil))
+		if remaining > want || remaining < want-time.Second/2 {
+			t.Errorf("%s: deadline in %v, want %v", route, remaining, want)
+		}
+	}
+
+	// Unlimited concurrency without timeouts passes requests through
+	g = newQueryGate(config.QueryGate{})
+	g.wrap("/api/v1/logs", func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Deadline(); ok {
+			t.Error("unexpected deadline")
+		}
+	})(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/v1/logs", nil))
+}
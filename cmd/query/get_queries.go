@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"otelservices/internal/monitoring"
+	"otelservices/internal/timeutil"
+)
+
+// GET variants of the POST search endpoints, for curl and dashboards that
+// cannot send a JSON body. URL parameters are mapped to the same request
+// structs, which are then validated and served as if they had been posted.
+
+// getQuery serves the GET variant of the POST route with handler. parse
+// builds the request from the URL parameters; its JSON is the body the
+// handler reads.
+func getQuery(route string, parse func(q url.Values, now time.Time) (interface{}, error), handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, err := parse(r.URL.Query(), time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			monitoring.QueryErrors.WithLabelValues("validation").Inc()
+			return
+		}
+		body, err := json.Marshal(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		post := r.Clone(r.Context())
+		post.Method = http.MethodPost
+		post.Body = io.NopCloser(bytes.NewReader(body))
+		post.ContentLength = int64(len(body))
+		withValidation(route, handler)(w, post)
+	}
+}
+
+// traceQueryParams maps trace_id, service, start, end, min_duration,
+// max_duration, limit and include_cold_attributes to a TraceQueryRequest
+func traceQueryParams(q url.Values, now time.Time) (interface{}, error) {
+	req := &TraceQueryRequest{TraceID: q.Get("trace_id"), ServiceName: q.Get("service")}
+	var err error
+	if req.StartTime, req.EndTime, err = timeParams(q, now); err != nil {
+		return nil, err
+	}
+	if req.MinDuration, err = durationParam(q, "min_duration"); err != nil {
+		return nil, err
+	}
+	if req.MaxDuration, err = durationParam(q, "max_duration"); err != nil {
+		return nil, err
+	}
+	if req.Limit, err = intParam(q, "limit"); err != nil {
+		return nil, err
+	}
+	if val := q.Get("include_cold_attributes"); val != "" {
+		if req.IncludeColdAttributes, err = strconv.ParseBool(val); err != nil {
+			return nil, fmt.Errorf("invalid include_cold_attributes %q: must be true or false", val)
+		}
+	}
+	return req, nil
+}
+
+// metricsQueryParams maps metric, service, start, end, aggregation,
+// group_by, filter, step and timezone to a MetricsQueryRequest
+func metricsQueryParams(q url.Values, now time.Time) (interface{}, error) {
+	req := &MetricsQueryRequest{
+		MetricName:  q.Get("metric"),
+		ServiceName: q.Get("service"),
+		Aggregation: q.Get("aggregation"),
+		GroupBy:     listParam(q, "group_by"),
+		Step:        q.Get("step"),
+		Timezone:    q.Get("timezone"),
+	}
+	var err error
+	if req.StartTime, req.EndTime, err = timeParams(q, now); err != nil {
+		return nil, err
+	}
+	if req.Filters, err = filterParams(q); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// logsQueryParams maps service, start, end, severity, severity_min,
+// severity_max, search, trace_id, filter and limit to a LogsQueryRequest
+func logsQueryParams(q url.Values, now time.Time) (interface{}, error) {
+	req := &LogsQueryRequest{
+		ServiceName: q.Get("service"),
+		Severity:    q.Get("severity"),
+		SearchText:  q.Get("search"),
+		TraceID:     q.Get("trace_id"),
+	}
+	var err error
+	if req.StartTime, req.EndTime, err = timeParams(q, now); err != nil {
+		return nil, err
+	}
+	if req.SeverityMin, err = severityParam(q, "severity_min"); err != nil {
+		return nil, err
+	}
+	if req.SeverityMax, err = severityParam(q, "severity_max"); err != nil {
+		return nil, err
+	}
+	if req.Filters, err = filterParams(q); err != nil {
+		return nil, err
+	}
+	if req.Limit, err = intParam(q, "limit"); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// timeParams reads the start and end parameters, each RFC 3339 or relative
+// to now. Missing values stay zero, so the endpoint's default range applies.
+func timeParams(q url.Values, now time.Time) (start, end time.Time, err error) {
+	if val := q.Get("start"); val != "" {
+		if start, err = timeutil.Parse(val, now); err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid start time: %w", err)
+		}
+	}
+	if val := q.Get("end"); val != "" {
+		if end, err = timeutil.Parse(val, now); err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid end time: %w", err)
+		}
+	}
+	return start, end, nil
+}
+
+// durationParam reads a duration in nanoseconds, either a plain integer or
+// a Go duration such as 250ms
+func durationParam(q url.Values, name string) (int64, error) {
+	val := q.Get(name)
+	if val == "" {
+		return 0, nil
+	}
+	if n, err := strconv.ParseInt(val, 10, 64); err == nil {
+		return n, nil
+	}
+	d, err := time.ParseDuration(val)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: must be nanoseconds or a duration such as 250ms", name, val)
+	}
+	return int64(d), nil
+}
+
+func intParam(q url.Values, name string) (int, error) {
+	val := q.Get(name)
+	if val == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(val)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: must be an integer", name, val)
+	}
+	return n, nil
+}
+
+func severityParam(q url.Values, name string) (uint8, error) {
+	val := q.Get(name)
+	if val == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseUint(val, 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: must be a severity number", name, val)
+	}
+	return uint8(n), nil
+}
+
+// listParam reads a parameter given repeatedly or comma-separated
+func listParam(q url.Values, name string) []string {
+	var values []string
+	for _, val := range q[name] {
+		for _, v := range strings.Split(val, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+	}
+	return values
+}
+
+// filterParams reads repeated filter=key:value parameters
+func filterParams(q url.Values) (map[string]string, error) {
+	if len(q["filter"]) == 0 {
+		return nil, nil
+	}
+	filters := make(map[string]string, len(q["filter"]))
+	for _, val := range q["filter"] {
+		key, value, ok := strings.Cut(val, ":")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid filter %q: must be key:value", val)
+		}
+		filters[key] = value
+	}
+	return filters, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"otelservices/internal/config"
+)
+
+func TestQueryParams(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	parse := func(f func(url.Values, time.Time) (interface{}, error), query string) interface{} {
+		t.Helper()
+		q, err := url.ParseQuery(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := f(q, now)
+		if err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		return req
+	}
+
+	traces := parse(traceQueryParams, "service=frontend&start=now-1h&min_duration=250ms&max_duration=2000000000&limit=20&include_cold_attributes=true")
+	wantTraces := &TraceQueryRequest{ServiceName: "frontend", StartTime: now.Add(-time.Hour),
+		MinDuration: 250000000, MaxDuration: 2000000000, Limit: 20, IncludeColdAttributes: true}
+	if !reflect.DeepEqual(traces, wantTraces) {
+		t.Errorf("traces = %+v", traces)
+	}
+
+	metrics := parse(metricsQueryParams, "metric=cpu&start=now-6h&end=now&group_by=host,region&group_by=pod&filter=env:prod&filter=url:http://a")
+	wantMetrics := &MetricsQueryRequest{MetricName: "cpu", StartTime: now.Add(-6 * time.Hour), EndTime: now,
+		GroupBy: []string{"host", "region", "pod"}, Filters: map[string]string{"env": "prod", "url": "http://a"}}
+	if !reflect.DeepEqual(metrics, wantMetrics) {
+		t.Errorf("metrics = %+v", metrics)
+	}
+
+	logs := parse(logsQueryParams, "service=api&start=now-15m&end=now&severity=WARN%2B&severity_max=20&search=timeout&trace_id=abc")
+	wantLogs := &LogsQueryRequest{ServiceName: "api", StartTime: now.Add(-15 * time.Minute), EndTime: now,
+		Severity: "WARN+", SeverityMax: 20, SearchText: "timeout", TraceID: "abc"}
+	if !reflect.DeepEqual(logs, wantLogs) {
+		t.Errorf("logs = %+v", logs)
+	}
+
+	for _, query := range []string{"limit=ten", "min_duration=soon", "start=yesterday", "include_cold_attributes=maybe"} {
+		q, _ := url.ParseQuery(query)
+		if _, err := traceQueryParams(q, now); err == nil {
+			t.Errorf("%s: expected an error", query)
+		}
+	}
+	q, _ := url.ParseQuery("filter=env")
+	if _, err := logsQueryParams(q, now); err == nil {
+		t.Error("filter without a value: expected an error")
+	}
+}
+
+func TestGetQueryValidation(t *testing.T) {
+	router := newRouter(NewQueryService(config.DefaultConfig(), nil))
+	tests := []struct {
+		path    string
+		wantErr string
+	}{
+		{"/api/v1/logs?start=now-1h&end=now&limit=-5", "limit must be at least 0"},
+		{"/api/v1/logs?start=now&end=now-1h", "end_time must not be before start_time"},
+		{"/api/v1/metrics?start=now-1h&end=now", "metric_name is required"},
+		{"/api/v1/traces?start=last+week", "invalid start time"},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), tt.wantErr) {
+			t.Errorf("%s: status %d, body %q", tt.path, rec.Code, rec.Body.String())
+		}
+	}
+}
@@ -0,0 +1,419 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"otelservices/internal/models"
+	"otelservices/internal/monitoring"
+)
+
+// Targets that select logs and traces rather than a metric
+const (
+	grafanaLogsTarget   = "logs"
+	grafanaTracesTarget = "traces"
+)
+
+// grafanaRowLimit caps the rows of log and trace tables and annotations when
+// Grafana does not send maxDataPoints
+const grafanaRowLimit = 100
+
+// Grafana JSON datasource structures, as sent and expected by the
+// SimpleJSON and Infinity datasource plugins
+
+type GrafanaRange struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+type GrafanaSearchRequest struct {
+	Target string `json:"target"`
+}
+
+// GrafanaTargetData holds the optional per-target settings, entered as JSON in
+// the panel's query editor
+type GrafanaTargetData struct {
+	ServiceName string            `json:"service_name,omitempty"`
+	Aggregation string            `json:"aggregation,omitempty" validate:"oneof=avg min max sum"`
+	GroupBy     []string          `json:"group_by,omitempty"` // metrics: one series per label set
+	Filters     map[string]string `json:"filters,omitempty"`
+	Severity    string            `json:"severity,omitempty"` // logs: e.g. WARN+
+	SearchText  string            `json:"search_text,omitempty"`
+}
+
+type GrafanaTarget struct {
+	Target string             `json:"target"`
+	RefID  string             `json:"refId,omitempty"`
+	Type   string             `json:"type,omitempty"` // timeserie or table
+	Data   *GrafanaTargetData `json:"data,omitempty"`
+}
+
+type GrafanaAdhocFilter struct {
+	Key      string `json:"key"`
+	Operator string `json:"operator"`
+	Value    string `json:"value"`
+}
+
+type GrafanaQueryRequest struct {
+	Range         GrafanaRange         `json:"range"`
+	IntervalMs    int64                `json:"intervalMs,omitempty"`
+	MaxDataPoints int                  `json:"maxDataPoints,omitempty"`
+	Targets       []GrafanaTarget      `json:"targets"`
+	AdhocFilters  []GrafanaAdhocFilter `json:"adhocFilters,omitempty"`
+}
+
+type GrafanaColumn struct {
+	Text string `json:"text"`
+	Type string `json:"type"` // time, string or number
+}
+
+// GrafanaQueryResult is either a time series (target and datapoints) or a
+// table (type, columns and rows)
+type GrafanaQueryResult struct {
+	Target     string          `json:"target"`
+	Datapoints [][2]float64    `json:"datapoints"` // [value, unix milliseconds]
+	Type       string          `json:"type"`
+	Columns    []GrafanaColumn `json:"columns"`
+	Rows       [][]interface{} `json:"rows"`
+}
+
+// MarshalJSON writes only the fields of the result's shape
+func (r GrafanaQueryResult) MarshalJSON() ([]byte, error) {
+	if r.Type == "table" {
+		return json.Marshal(struct {
+			Type    string          `json:"type"`
+			Columns []GrafanaColumn `json:"columns"`
+			Rows    [][]interface{} `json:"rows"`
+		}{r.Type, r.Columns, r.Rows})
+	}
+	return json.Marshal(struct {
+		Target     string       `json:"target"`
+		Datapoints [][2]float64 `json:"datapoints"`
+	}{r.Target, r.Datapoints})
+}
+
+type GrafanaAnnotationQuery struct {
+	Name   string `json:"name"`
+	Query  string `json:"query,omitempty"` // service name, empty for all services
+	Enable bool   `json:"enable"`
+}
+
+type GrafanaAnnotationRequest struct {
+	Range      GrafanaRange           `json:"range"`
+	Annotation GrafanaAnnotationQuery `json:"annotation"`
+}
+
+type GrafanaAnnotation struct {
+	Annotation GrafanaAnnotationQuery `json:"annotation"`
+	Time       int64                  `json:"time"` // unix milliseconds
+	Title      string                 `json:"title"`
+	Text       string                 `json:"text"`
+	Tags       []string               `json:"tags"`
+}
+
+// handlerError is the error response of a handler run in process
+type handlerError struct {
+	status  int
+	message string
+}
+
+func (e *handlerError) Error() string { return e.message }
+
+// errorStatus returns the status of a failed handler, or 500
+func errorStatus(err error) int {
+	var herr *handlerError
+	if errors.As(err, &herr) {
+		return herr.status
+	}
+	return http.StatusInternalServerError
+}
+
+// runHandler calls one of the query handlers in process, the way jobs do,
+// and decodes its JSON response into out. The body is validated as the
+// router would.
+func runHandler(ctx context.Context, handler http.HandlerFunc, path string, body, out interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	rec := newResponseBuffer()
+	withValidation(path, handler)(rec, req)
+	addAuditRows(auditRecordFrom(ctx), rec.header)
+	if rec.status >= 300 {
+		return &handlerError{status: rec.status, message: errorMessage(rec.body.Bytes())}
+	}
+	return json.Unmarshal(rec.body.Bytes(), out)
+}
+
+// grafanaStep converts Grafana's suggested interval to a metrics step
+func grafanaStep(intervalMs int64) string {
+	if intervalMs < 1000 {
+		return ""
+	}
+	return fmt.Sprintf("%ds", intervalMs/1000)
+}
+
+// metricsRequest builds the metrics query for a target. Ad hoc filters with
+// the = operator become label filters; others are not supported and ignored.
+func (req *GrafanaQueryRequest) metricsRequest(target GrafanaTarget) MetricsQueryRequest {
+	mreq := MetricsQueryRequest{
+		MetricName: target.Target,
+		StartTime:  req.Range.From,
+		EndTime:    req.Range.To,
+		Step:       grafanaStep(req.IntervalMs),
+		Filters:    map[string]string{},
+	}
+	if d := target.Data; d != nil {
+		mreq.ServiceName = d.ServiceName
+		mreq.Aggregation = d.Aggregation
+		mreq.GroupBy = d.GroupBy
+		for k, v := range d.Filters {
+			mreq.Filters[k] = v
+		}
+	}
+	for _, f := range req.AdhocFilters {
+		if f.Operator == "=" {
+			mreq.Filters[f.Key] = f.Value
+		}
+	}
+	return mreq
+}
+
+// rowLimit returns the row limit for tables
+func (req *GrafanaQueryRequest) rowLimit() int {
+	if req.MaxDataPoints > 0 && req.MaxDataPoints < grafanaRowLimit {
+		return req.MaxDataPoints
+	}
+	return grafanaRowLimit
+}
+
+// timeseries converts a metrics response to Grafana series, one per label
+// set, named like metric{key="value"}
+func timeseries(resp *MetricsQueryResponse) []GrafanaQueryResult {
+	toDatapoints := func(points []MetricDataPoint) [][2]float64 {
+		datapoints := make([][2]float64, len(points))
+		for i, p := range points {
+			datapoints[i] = [2]float64{p.Value, float64(p.Timestamp.UnixMilli())}
+		}
+		return datapoints
+	}
+
+	if len(resp.Series) == 0 {
+		if len(resp.DataPoints) == 0 {
+			return nil
+		}
+		return []GrafanaQueryResult{{Target: resp.MetricName, Datapoints: toDatapoints(resp.DataPoints)}}
+	}
+	results := make([]GrafanaQueryResult, 0, len(resp.Series))
+	for _, series := range resp.Series {
+		keys := make([]string, 0, len(series.Labels))
+		for k := range series.Labels {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		labels := make([]string, len(keys))
+		for i, k := range keys {
+			labels[i] = fmt.Sprintf("%s=%q", k, series.Labels[k])
+		}
+		results = append(results, GrafanaQueryResult{
+			Target:     resp.MetricName + "{" + strings.Join(labels, ", ") + "}",
+			Datapoints: toDatapoints(series.DataPoints),
+		})
+	}
+	return results
+}
+
+func logsTable(logs []LogRecord) GrafanaQueryResult {
+	table := GrafanaQueryResult{
+		Type: "table",
+		Columns: []GrafanaColumn{
+			{Text: "Time", Type: "time"},
+			{Text: "Service", Type: "string"},
+			{Text: "Severity", Type: "string"},
+			{Text: "Body", Type: "string"},
+			{Text: "Trace ID", Type: "string"},
+		},
+		Rows: [][]interface{}{},
+	}
+	for _, l := range logs {
+		table.Rows = append(table.Rows, []interface{}{l.Timestamp.UnixMilli(), l.ServiceName, l.SeverityText, l.Body, l.TraceID})
+	}
+	return table
+}
+
+func tracesTable(spans []Span) GrafanaQueryResult {
+	table := GrafanaQueryResult{
+		Type: "table",
+		Columns: []GrafanaColumn{
+			{Text: "Time", Type: "time"},
+			{Text: "Service", Type: "string"},
+			{Text: "Span", Type: "string"},
+			{Text: "Duration (ms)", Type: "number"},
+			{Text: "Status", Type: "string"},
+			{Text: "Trace ID", Type: "string"},
+		},
+		Rows: [][]interface{}{},
+	}
+	for _, s := range spans {
+		table.Rows = append(table.Rows, []interface{}{
+			s.StartTime.UnixMilli(), s.ServiceName, s.SpanName, float64(s.DurationNs) / 1e6, s.StatusCode, s.TraceID,
+		})
+	}
+	return table
+}
+
+// GrafanaTest answers the datasource connection test
+func (s *QueryService) GrafanaTest(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("OK"))
+}
+
+// GrafanaSearch lists the targets a panel can query: the logs and traces
+// tables and the metric names of the last hour, filtered by substring
+func (s *QueryService) GrafanaSearch(w http.ResponseWriter, r *http.Request) {
+	var req GrafanaSearchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		monitoring.QueryErrors.WithLabelValues("grafana").Inc()
+		return
+	}
+	metrics, err := s.cachedMetricNames()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		monitoring.QueryErrors.WithLabelValues("grafana").Inc()
+		return
+	}
+
+	targets := []string{}
+	for _, name := range []string{grafanaLogsTarget, grafanaTracesTarget} {
+		if strings.Contains(name, req.Target) {
+			targets = append(targets, name)
+		}
+	}
+	for _, m := range metrics {
+		if strings.Contains(m.MetricName, req.Target) {
+			targets = append(targets, m.MetricName)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(targets)
+}
+
+// GrafanaQuery runs each target through the metrics, logs or traces query
+// and returns time series for metrics and tables for logs and traces
+func (s *QueryService) GrafanaQuery(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	defer func() {
+		monitoring.QueryDuration.WithLabelValues("grafana").Observe(time.Since(start).Seconds())
+	}()
+
+	var req GrafanaQueryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		monitoring.QueryErrors.WithLabelValues("grafana").Inc()
+		return
+	}
+
+	ctx := r.Context()
+	results := []GrafanaQueryResult{}
+	for _, target := range req.Targets {
+		data := target.Data
+		if data == nil {
+			data = &GrafanaTargetData{}
+		}
+
+		var err error
+		switch target.Target {
+		case "":
+			continue
+		case grafanaLogsTarget:
+			var resp LogsQueryResponse
+			err = runHandler(ctx, s.results.wrap("/api/v1/logs", s.QueryLogs), "/api/v1/logs", LogsQueryRequest{
+				ServiceName: data.ServiceName,
+				StartTime:   req.Range.From,
+				EndTime:     req.Range.To,
+				Severity:    data.Severity,
+				SearchText:  data.SearchText,
+				Limit:       req.rowLimit(),
+			}, &resp)
+			if err == nil {
+				results = append(results, logsTable(resp.Logs))
+			}
+		case grafanaTracesTarget:
+			var resp TraceQueryResponse
+			err = runHandler(ctx, s.results.wrap("/api/v1/traces", s.QueryTraces), "/api/v1/traces", TraceQueryRequest{
+				ServiceName: data.ServiceName,
+				StartTime:   req.Range.From,
+				EndTime:     req.Range.To,
+				Limit:       req.rowLimit(),
+			}, &resp)
+			if err == nil {
+				results = append(results, tracesTable(resp.Spans))
+			}
+		default:
+			var resp MetricsQueryResponse
+			err = runHandler(ctx, s.results.wrap("/api/v1/metrics", s.QueryMetrics), "/api/v1/metrics", req.metricsRequest(target), &resp)
+			if err == nil {
+				results = append(results, timeseries(&resp)...)
+			}
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("target %q: %v", target.Target, err), errorStatus(err))
+			monitoring.QueryErrors.WithLabelValues("grafana").Inc()
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+// GrafanaAnnotations marks the ERROR and FATAL logs of the service named by
+// the annotation query, or of every service, on the panel's time range
+func (s *QueryService) GrafanaAnnotations(w http.ResponseWriter, r *http.Request) {
+	var req GrafanaAnnotationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		monitoring.QueryErrors.WithLabelValues("grafana").Inc()
+		return
+	}
+
+	var resp LogsQueryResponse
+	err := runHandler(r.Context(), s.results.wrap("/api/v1/logs", s.QueryLogs), "/api/v1/logs", LogsQueryRequest{
+		ServiceName: strings.TrimSpace(req.Annotation.Query),
+		StartTime:   req.Range.From,
+		EndTime:     req.Range.To,
+		SeverityMin: models.SeverityError,
+		Limit:       grafanaRowLimit,
+	}, &resp)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		monitoring.QueryErrors.WithLabelValues("grafana").Inc()
+		return
+	}
+
+	annotations := []GrafanaAnnotation{}
+	for _, l := range resp.Logs {
+		annotations = append(annotations, GrafanaAnnotation{
+			Annotation: req.Annotation,
+			Time:       l.Timestamp.UnixMilli(),
+			Title:      l.SeverityText + " in " + l.ServiceName,
+			Text:       l.Body,
+			Tags:       []string{l.ServiceName, l.SeverityText},
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(annotations)
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"otelservices/internal/monitoring"
+	queryv1 "otelservices/proto/query/v1"
+	"otelservices/proto/query/v1/queryv1grpc"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// streamBatchSize is the number of spans, logs or metric data points sent
+// per message of a streamed query result
+const streamBatchSize = 1000
+
+// grpcQueryServer serves the QueryService by running the REST handlers in
+// process, so both APIs share validation, query budgets and metrics
+type grpcQueryServer struct {
+	s *QueryService
+}
+
+// newGRPCServer creates the gRPC server of the query service
+func newGRPCServer(s *QueryService) *grpc.Server {
+	srv := grpc.NewServer(
+		grpc.ForceServerCodec(queryv1grpc.Codec{}),
+		grpc.ChainUnaryInterceptor(monitoring.GRPCUnaryTracing(), s.grpcAuthUnary()),
+		grpc.ChainStreamInterceptor(monitoring.GRPCStreamTracing(), s.grpcAuthStream()),
+	)
+	queryv1grpc.RegisterQueryServiceServer(srv, &grpcQueryServer{s: s})
+	return srv
+}
+
+// TraceQuery streams the spans of a trace search
+func (g *grpcQueryServer) TraceQuery(in *queryv1.TraceQueryRequest, stream queryv1grpc.TraceQueryStream) error {
+	req := TraceQueryRequest{
+		TraceID:               in.TraceID,
+		ServiceName:           in.ServiceName,
+		StartTime:             fromUnixNano(in.StartTimeUnixNano),
+		EndTime:               fromUnixNano(in.EndTimeUnixNano),
+		MinDuration:           int64(in.MinDurationNs),
+		MaxDuration:           int64(in.MaxDurationNs),
+		Limit:                 int(in.Limit),
+		IncludeColdAttributes: in.IncludeColdAttributes,
+	}
+	var resp TraceQueryResponse
+	if err := g.run(stream.Context(), g.s.QueryTraces, "/api/v1/traces", req, &resp); err != nil {
+		return err
+	}
+	result := resp.toProto()
+	for _, spans := range batches(result.Spans, streamBatchSize) {
+		if err := stream.Send(&queryv1.TraceQueryResponse{Spans: spans, Total: result.Total}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MetricsQuery streams the data points of a metrics query
+func (g *grpcQueryServer) MetricsQuery(in *queryv1.MetricsQueryRequest, stream queryv1grpc.MetricsQueryStream) error {
+	req := MetricsQueryRequest{
+		MetricName:  in.MetricName,
+		ServiceName: in.ServiceName,
+		StartTime:   fromUnixNano(in.StartTimeUnixNano),
+		EndTime:     fromUnixNano(in.EndTimeUnixNano),
+		Aggregation: in.Aggregation,
+		GroupBy:     in.GroupBy,
+		Filters:     in.Filters,
+		Step:        in.Step,
+		Timezone:    in.Timezone,
+	}
+	var resp MetricsQueryResponse
+	if err := g.run(stream.Context(), g.s.QueryMetrics, "/api/v1/metrics", req, &resp); err != nil {
+		return err
+	}
+	for _, batch := range metricsBatches(resp.toProto(), streamBatchSize) {
+		if err := stream.Send(batch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LogsQuery streams the logs matching a logs query
+func (g *grpcQueryServer) LogsQuery(in *queryv1.LogsQueryRequest, stream queryv1grpc.LogsQueryStream) error {
+	req := LogsQueryRequest{
+		ServiceName: in.ServiceName,
+		StartTime:   fromUnixNano(in.StartTimeUnixNano),
+		EndTime:     fromUnixNano(in.EndTimeUnixNano),
+		Severity:    in.Severity,
+		SeverityMin: uint8(min(in.SeverityMin, 255)),
+		SeverityMax: uint8(min(in.SeverityMax, 255)),
+		SearchText:  in.SearchText,
+		TraceID:     in.TraceID,
+		Filters:     in.Filters,
+		Limit:       int(in.Limit),
+	}
+	var resp LogsQueryResponse
+	if err := g.run(stream.Context(), g.s.QueryLogs, "/api/v1/logs", req, &resp); err != nil {
+		return err
+	}
+	result := resp.toProto()
+	for _, logs := range batches(result.Logs, streamBatchSize) {
+		if err := stream.Send(&queryv1.LogsQueryResponse{Logs: logs, Total: result.Total}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ServiceStats returns the span statistics of the last hour
+func (g *grpcQueryServer) ServiceStats(ctx context.Context, _ *queryv1.ServiceStatsRequest) (*queryv1.ServiceStatsResponse, error) {
+	var stats []ServiceStat
+	if err := g.run(ctx, g.s.GetServiceStats, "/api/v1/services/stats", nil, &stats); err != nil {
+		return nil, err
+	}
+	return serviceStatsToProto(stats), nil
+}
+
+// run calls a REST handler with the interactive query profile, budget,
+// result cache and query gate, as the router does, and converts its errors
+// to gRPC statuses
+func (g *grpcQueryServer) run(ctx context.Context, handler http.HandlerFunc, path string, body, out interface{}) error {
+	auditCtx, finish := g.s.auditCall(ctx, path)
+	err := runHandler(g.s.interactiveContext(auditCtx), g.s.results.wrap(path, g.s.gate.wrap(path, handler)), path, body, out)
+	finish(err)
+	if err == nil {
+		return nil
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return status.FromContextError(ctxErr).Err()
+	}
+	var herr *handlerError
+	if !errors.As(err, &herr) {
+		return status.Error(codes.Internal, err.Error())
+	}
+	return status.Error(grpcCode(herr.status), herr.message)
+}
+
+// grpcCode maps the HTTP status of a failed handler to a gRPC code
+func grpcCode(httpStatus int) codes.Code {
+	switch httpStatus {
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusUnprocessableEntity:
+		// Over a query limit or budget; the query has to be narrowed
+		return codes.FailedPrecondition
+	case http.StatusServiceUnavailable:
+		return codes.Unavailable
+	}
+	return codes.Internal
+}
+
+func fromUnixNano(ns uint64) time.Time {
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, int64(ns)).UTC()
+}
+
+// batches splits items into slices of at most size items. An empty result
+// is one empty batch, so streams always send at least one message.
+func batches[T any](items []T, size int) [][]T {
+	out := [][]T{items[:min(len(items), size)]}
+	for i := size; i < len(items); i += size {
+		out = append(out, items[i:min(len(items), i+size)])
+	}
+	return out
+}
+
+// metricsBatches splits a metrics result into messages of at most size data
+// points, counting both data_points and series. A series that does not fit
+// continues in the next message with the same labels.
+func metricsBatches(resp *queryv1.MetricsQueryResponse, size int) []*queryv1.MetricsQueryResponse {
+	var out []*queryv1.MetricsQueryResponse
+	batch := &queryv1.MetricsQueryResponse{MetricName: resp.MetricName}
+	points := 0
+	next := func() bool {
+		if points < size {
+			return false
+		}
+		out = append(out, batch)
+		batch = &queryv1.MetricsQueryResponse{MetricName: resp.MetricName}
+		points = 0
+		return true
+	}
+	for _, dp := range resp.DataPoints {
+		next()
+		batch.DataPoints = append(batch.DataPoints, dp)
+		points++
+	}
+	for _, series := range resp.Series {
+		var part *queryv1.MetricSeries
+		for _, dp := range series.DataPoints {
+			if next() || part == nil {
+				part = &queryv1.MetricSeries{Labels: series.Labels}
+				batch.Series = append(batch.Series, part)
+			}
+			part.DataPoints = append(part.DataPoints, dp)
+			points++
+		}
+	}
+	return append(out, batch)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"otelservices/internal/config"
+	queryv1 "otelservices/proto/query/v1"
+	"otelservices/proto/query/v1/queryv1grpc"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestBatches(t *testing.T) {
+	if got := batches([]int{}, 2); len(got) != 1 || len(got[0]) != 0 {
+		t.Errorf("empty input should give one empty batch, got %v", got)
+	}
+	got := batches([]int{1, 2, 3, 4, 5}, 2)
+	if len(got) != 3 || len(got[0]) != 2 || len(got[2]) != 1 || got[2][0] != 5 {
+		t.Errorf("got %v", got)
+	}
+}
+
+func TestMetricsBatches(t *testing.T) {
+	point := func(ts uint64) *queryv1.MetricDataPoint { return &queryv1.MetricDataPoint{TimestampUnixNano: ts} }
+	resp := &queryv1.MetricsQueryResponse{
+		MetricName: "cpu",
+		DataPoints: []*queryv1.MetricDataPoint{point(1), point(2), point(3)},
+		Series: []*queryv1.MetricSeries{
+			{Labels: map[string]string{"host": "a"}, DataPoints: []*queryv1.MetricDataPoint{point(1), point(2)}},
+			{Labels: map[string]string{"host": "b"}, DataPoints: []*queryv1.MetricDataPoint{point(3)}},
+		},
+	}
+
+	got := metricsBatches(resp, 2)
+	if len(got) != 3 {
+		t.Fatalf("got %d batches, want 3", len(got))
+	}
+	for i, batch := range got {
+		if batch.MetricName != "cpu" {
+			t.Errorf("batch %d has no metric name", i)
+		}
+	}
+	// Points 1-2; point 3 and host=a's first point; the rest of host=a and host=b
+	if len(got[0].DataPoints) != 2 || len(got[0].Series) != 0 {
+		t.Errorf("batch 0 = %+v", got[0])
+	}
+	if len(got[1].DataPoints) != 1 || len(got[1].Series) != 1 || got[1].Series[0].Labels["host"] != "a" {
+		t.Errorf("batch 1 = %+v", got[1])
+	}
+	if len(got[2].Series) != 2 || got[2].Series[0].Labels["host"] != "a" || got[2].Series[1].Labels["host"] != "b" {
+		t.Errorf("batch 2 = %+v", got[2])
+	}
+
+	if got := metricsBatches(&queryv1.MetricsQueryResponse{MetricName: "cpu"}, 2); len(got) != 1 {
+		t.Errorf("empty result should be one message, got %d", len(got))
+	}
+}
+
+func TestGRPCCode(t *testing.T) {
+	tests := map[int]codes.Code{
+		400: codes.InvalidArgument,
+		401: codes.Unauthenticated,
+		403: codes.PermissionDenied,
+		404: codes.NotFound,
+		422: codes.FailedPrecondition,
+		503: codes.Unavailable,
+		500: codes.Internal,
+	}
+	for httpStatus, want := range tests {
+		if got := grpcCode(httpStatus); got != want {
+			t.Errorf("grpcCode(%d) = %v, want %v", httpStatus, got, want)
+		}
+	}
+}
+
+func TestGRPCQueryValidation(t *testing.T) {
+	lis := bufconn.Listen(1 << 20)
+	srv := newGRPCServer(NewQueryService(config.DefaultConfig(), nil))
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, "bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := queryv1grpc.NewQueryServiceClient(conn)
+
+	// Requests are validated before any query runs, so no ClickHouse is needed
+	logs, err := client.LogsQuery(ctx, &queryv1.LogsQueryRequest{ServiceName: "api", Limit: 50000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = logs.Recv()
+	if status.Code(err) != codes.InvalidArgument || !strings.Contains(err.Error(), "limit must be at most 10000") {
+		t.Errorf("LogsQuery error = %v", err)
+	}
+
+	metrics, err := client.MetricsQuery(ctx, &queryv1.MetricsQueryRequest{
+		MetricName:        "cpu",
+		StartTimeUnixNano: uint64(time.Now().Add(-time.Hour).UnixNano()),
+		EndTimeUnixNano:   uint64(time.Now().UnixNano()),
+		Aggregation:       "avg(value)); DROP TABLE otel_metrics; --",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = metrics.Recv()
+	if status.Code(err) != codes.InvalidArgument || !strings.Contains(err.Error(), "aggregation must be one of") {
+		t.Errorf("MetricsQuery error = %v", err)
+	}
+}
@@ -0,0 +1,437 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"otelservices/internal/clickhouse"
+	"otelservices/internal/config"
+	"otelservices/internal/monitoring"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// jobTimeout bounds how long a single job may run
+	jobTimeout = 30 * time.Minute
+	// jobRetention is how long finished jobs and their results are kept
+	jobRetention = 1 * time.Hour
+	// maxConcurrentJobs limits jobs running at once; others wait queued
+	maxConcurrentJobs = 4
+	// maxJobs caps the number of jobs tracked, finished or not
+	maxJobs = 1000
+)
+
+// JobStatus is the lifecycle state of an async query job
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobSucceeded JobStatus = "succeeded"
+	JobFailed    JobStatus = "failed"
+	JobCanceled  JobStatus = "canceled"
+)
+
+// Job classes select the ClickHouse query profile a job runs with
+const (
+	jobClassBackground = "background"
+	jobClassExport     = "export"
+)
+
+// JobSubmitRequest submits one of the query endpoints as a background job.
+// Query holds the same body the synchronous endpoint accepts.
+type JobSubmitRequest struct {
+	Kind  string          `json:"kind" validate:"required,oneof=traces metrics logs"`
+	Class string          `json:"class,omitempty" validate:"oneof=background export"` // background is the default
+	Query json.RawMessage `json:"query" validate:"required"`
+}
+
+// JobProgress reports rows and bytes read so far, from ClickHouse progress events
+type JobProgress struct {
+	RowsRead  uint64  `json:"rows_read"`
+	BytesRead uint64  `json:"bytes_read"`
+	TotalRows uint64  `json:"total_rows"`
+	Percent   float64 `json:"percent"`
+}
+
+// JobInfo is the externally visible state of a job
+type JobInfo struct {
+	ID         string        `json:"id"`
+	Kind       string        `json:"kind"`
+	Class      string        `json:"class"`
+	Status     JobStatus     `json:"status"`
+	Progress   JobProgress   `json:"progress"`
+	Error      string        `json:"error,omitempty"`
+	Export     *ExportOutput `json:"export,omitempty"` // the written file, once an export job succeeds
+	CreatedAt  time.Time     `json:"created_at"`
+	StartedAt  *time.Time    `json:"started_at,omitempty"`
+	FinishedAt *time.Time    `json:"finished_at,omitempty"`
+}
+
+type job struct {
+	mu          sync.Mutex
+	info        JobInfo
+	query       []byte
+	accept      string
+	result      []byte
+	contentType string
+	export      *exportSpec // set for export jobs
+	cancel      context.CancelFunc
+}
+
+func (j *job) snapshot() JobInfo {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	info := j.info
+	if info.Progress.TotalRows > 0 {
+		info.Progress.Percent = 100 * float64(info.Progress.RowsRead) / float64(info.Progress.TotalRows)
+		if info.Progress.Percent > 100 {
+			info.Progress.Percent = 100
+		}
+	}
+	if info.Status == JobSucceeded {
+		info.Progress.Percent = 100
+	}
+	return info
+}
+
+func (j *job) addProgress(rows, bytes, totalRows uint64) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.info.Progress.RowsRead += rows
+	j.info.Progress.BytesRead += bytes
+	j.info.Progress.TotalRows += totalRows
+}
+
+func (j *job) finish(status JobStatus, errMsg string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	now := time.Now()
+	j.info.Status = status
+	j.info.Error = errMsg
+	j.info.FinishedAt = &now
+}
+
+func (j *job) finished() bool {
+	switch j.info.Status {
+	case JobSucceeded, JobFailed, JobCanceled:
+		return true
+	}
+	return false
+}
+
+// jobManager runs query jobs in the background and keeps their results
+// around for jobRetention
+type jobManager struct {
+	mu       sync.Mutex
+	jobs     map[string]*job
+	handlers map[string]http.HandlerFunc
+	profiles config.QueryProfiles
+	slots    chan struct{}
+	exports  *exporter
+}
+
+func newJobManager(handlers map[string]http.HandlerFunc, profiles config.QueryProfiles) *jobManager {
+	return &jobManager{
+		jobs:     make(map[string]*job),
+		handlers: handlers,
+		profiles: profiles,
+		slots:    make(chan struct{}, maxConcurrentJobs),
+		exports:  newExporter(config.ExportConfig{}),
+	}
+}
+
+func newJobID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// submit registers and starts a job
+func (m *jobManager) submit(kind, class string, query []byte, accept string) (*job, error) {
+	return m.start(kind, class, query, accept, nil)
+}
+
+// submitExport registers and starts an export job, which runs with the
+// export query profile and writes its result as a file
+func (m *jobManager) submitExport(kind string, query []byte, spec exportSpec) (*job, error) {
+	return m.start(kind, jobClassExport, query, "", &spec)
+}
+
+func (m *jobManager) start(kind, class string, query []byte, accept string, export *exportSpec) (*job, error) {
+	if _, ok := m.handlers[kind]; !ok {
+		return nil, fmt.Errorf("unknown job kind %q", kind)
+	}
+	switch class {
+	case "":
+		class = jobClassBackground
+	case jobClassBackground, jobClassExport:
+	default:
+		return nil, fmt.Errorf("unknown job class %q", class)
+	}
+
+	m.mu.Lock()
+	m.evictLocked(time.Now())
+	if len(m.jobs) >= maxJobs {
+		m.mu.Unlock()
+		return nil, fmt.Errorf("too many jobs, try again later")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), jobTimeout)
+	j := &job{
+		info: JobInfo{
+			ID:        newJobID(),
+			Kind:      kind,
+			Class:     class,
+			Status:    JobQueued,
+			CreatedAt: time.Now(),
+		},
+		query:  query,
+		accept: accept,
+		export: export,
+		cancel: cancel,
+	}
+	m.jobs[j.info.ID] = j
+	m.mu.Unlock()
+
+	go m.run(ctx, j)
+	return j, nil
+}
+
+// evictLocked drops finished jobs older than jobRetention
+func (m *jobManager) evictLocked(now time.Time) {
+	for id, j := range m.jobs {
+		j.mu.Lock()
+		expired := j.finished() && now.Sub(*j.info.FinishedAt) > jobRetention
+		j.mu.Unlock()
+		if expired {
+			delete(m.jobs, id)
+		}
+	}
+}
+
+func (m *jobManager) get(id string) *job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.evictLocked(time.Now())
+	return m.jobs[id]
+}
+
+// run waits for a slot and executes the job through the synchronous handler
+// for its kind, capturing the response body as the result
+func (m *jobManager) run(ctx context.Context, j *job) {
+	defer j.cancel()
+
+	select {
+	case m.slots <- struct{}{}:
+		defer func() { <-m.slots }()
+	case <-ctx.Done():
+		j.finish(JobCanceled, ctx.Err().Error())
+		return
+	}
+
+	j.mu.Lock()
+	now := time.Now()
+	j.info.Status = JobRunning
+	j.info.StartedAt = &now
+	j.mu.Unlock()
+
+	ctx = clickhouse.WithProgress(ctx, j.addProgress)
+	ctx = clickhouse.WithQueryProfile(ctx, m.profileFor(j.info.Class))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "/api/v1/"+j.info.Kind, bytes.NewReader(j.query))
+	if err != nil {
+		j.finish(JobFailed, err.Error())
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if j.accept != "" {
+		req.Header.Set("Accept", j.accept)
+	}
+
+	rec := newResponseBuffer()
+	m.handlers[j.info.Kind](rec, req)
+
+	switch {
+	case ctx.Err() == context.Canceled:
+		j.finish(JobCanceled, "canceled")
+	case ctx.Err() == context.DeadlineExceeded:
+		j.finish(JobFailed, fmt.Sprintf("job exceeded %v", jobTimeout))
+	case rec.status >= 300:
+		j.finish(JobFailed, errorMessage(rec.body.Bytes()))
+	case j.export != nil:
+		out, err := m.exports.write(ctx, j.info.ID, j.info.Kind, *j.export, rec.body.Bytes())
+		if err != nil {
+			j.finish(JobFailed, err.Error())
+			break
+		}
+		result, _ := json.Marshal(out)
+		j.mu.Lock()
+		j.info.Export = out
+		j.result = result
+		j.contentType = "application/json"
+		j.mu.Unlock()
+		j.finish(JobSucceeded, "")
+	default:
+		j.mu.Lock()
+		j.result = rec.body.Bytes()
+		j.contentType = rec.header.Get("Content-Type")
+		j.mu.Unlock()
+		j.finish(JobSucceeded, "")
+	}
+
+	info := j.snapshot()
+	logger.Info("Job finished", "job", info.ID, "kind", info.Kind, "status", info.Status, "duration", info.FinishedAt.Sub(info.CreatedAt))
+}
+
+// setProfiles replaces the query profiles of jobs that have not started yet
+func (m *jobManager) setProfiles(profiles config.QueryProfiles) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.profiles = profiles
+}
+
+// profileFor returns the query profile for a job class. Execution time is
+// capped at jobTimeout and defaults to it, so the client-wide limit meant for
+// interactive queries does not cut jobs short.
+func (m *jobManager) profileFor(class string) config.QueryProfile {
+	m.mu.Lock()
+	p := m.profiles.Background
+	if class == jobClassExport {
+		p = m.profiles.Export
+	}
+	m.mu.Unlock()
+	if p.MaxExecutionTime <= 0 || p.MaxExecutionTime > jobTimeout {
+		p.MaxExecutionTime = jobTimeout
+	}
+	return p
+}
+
+// responseBuffer captures a handler's response in memory
+type responseBuffer struct {
+	header http.Header
+	body   bytes.Buffer
+	status int
+}
+
+func newResponseBuffer() *responseBuffer {
+	return &responseBuffer{header: make(http.Header), status: http.StatusOK}
+}
+
+func (b *responseBuffer) Header() http.Header { return b.header }
+
+func (b *responseBuffer) Write(p []byte) (int, error) { return b.body.Write(p) }
+
+func (b *responseBuffer) WriteHeader(status int) { b.status = status }
+
+// SubmitJob starts an async query job and returns its id
+func (s *QueryService) SubmitJob(w http.ResponseWriter, r *http.Request) {
+	var req JobSubmitRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		monitoring.QueryErrors.WithLabelValues("jobs").Inc()
+		return
+	}
+	if len(req.Query) == 0 {
+		http.Error(w, "query is required", http.StatusBadRequest)
+		monitoring.QueryErrors.WithLabelValues("jobs").Inc()
+		return
+	}
+	if !authorized(w, r, "/api/v1/"+req.Kind) {
+		return
+	}
+	// Jobs call the query handlers directly, so check the query now rather
+	// than failing the job later. Relative times are resolved now as well,
+	// so a queued job reads the range it was submitted for.
+	if op := findOperation(http.MethodPost, "/api/v1/"+req.Kind); op != nil {
+		var err error
+		if req.Query, err = prepareRequestBody(req.Query, op.request(), time.Now()); err != nil {
+			http.Error(w, "query: "+err.Error(), http.StatusBadRequest)
+			monitoring.QueryErrors.WithLabelValues("jobs").Inc()
+			return
+		}
+	}
+
+	j, err := s.jobs.submit(req.Kind, req.Class, req.Query, r.Header.Get("Accept"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		monitoring.QueryErrors.WithLabelValues("jobs").Inc()
+		return
+	}
+
+	info := j.snapshot()
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/api/v1/jobs/"+info.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(info)
+}
+
+// GetJob returns a job's status and progress
+func (s *QueryService) GetJob(w http.ResponseWriter, r *http.Request) {
+	j := s.jobs.get(mux.Vars(r)["id"])
+	if j == nil {
+		http.Error(w, "job not found", http.StatusNotFound)
+		return
+	}
+	if !authorized(w, r, "/api/v1/"+j.snapshot().Kind) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(j.snapshot())
+}
+
+// GetJobResult returns the result of a succeeded job in the format the
+// synchronous endpoint would have used. Export jobs return where their file
+// was written.
+func (s *QueryService) GetJobResult(w http.ResponseWriter, r *http.Request) {
+	j := s.jobs.get(mux.Vars(r)["id"])
+	if j == nil {
+		http.Error(w, "job not found", http.StatusNotFound)
+		return
+	}
+	if !authorized(w, r, "/api/v1/"+j.snapshot().Kind) {
+		return
+	}
+
+	info := j.snapshot()
+	switch info.Status {
+	case JobSucceeded:
+	case JobFailed, JobCanceled:
+		http.Error(w, fmt.Sprintf("job %s: %s", info.Status, info.Error), http.StatusConflict)
+		return
+	default:
+		http.Error(w, "job has not finished", http.StatusConflict)
+		return
+	}
+
+	j.mu.Lock()
+	result, contentType := j.result, j.contentType
+	j.mu.Unlock()
+
+	w.Header().Set("Content-Type", contentType)
+	w.Write(result)
+}
+
+// CancelJob cancels a queued or running job
+func (s *QueryService) CancelJob(w http.ResponseWriter, r *http.Request) {
+	j := s.jobs.get(mux.Vars(r)["id"])
+	if j == nil {
+		http.Error(w, "job not found", http.StatusNotFound)
+		return
+	}
+	if !authorized(w, r, "/api/v1/"+j.snapshot().Kind) {
+		return
+	}
+
+	j.cancel()
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"otelservices/internal/config"
+)
+
+func waitForJob(t *testing.T, j *job) JobInfo {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		info := j.snapshot()
+		switch info.Status {
+		case JobSucceeded, JobFailed, JobCanceled:
+			return info
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("job did not finish")
+	return JobInfo{}
+}
+
+func TestJobManagerRunsHandler(t *testing.T) {
+	m := newJobManager(map[string]http.HandlerFunc{
+		"metrics": func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(append([]byte(`{"echo":`), append(body, '}')...))
+		},
+	}, config.QueryProfiles{})
+
+	j, err := m.submit("metrics", "", []byte(`{"metric_name":"cpu"}`), "")
+	if err != nil {
+		t.Fatalf("submit failed: %v", err)
+	}
+
+	info := waitForJob(t, j)
+	if info.Status != JobSucceeded {
+		t.Fatalf("status = %s, want succeeded (error %q)", info.Status, info.Error)
+	}
+	if info.Progress.Percent != 100 {
+		t.Errorf("percent = %v, want 100", info.Progress.Percent)
+	}
+	if string(j.result) != `{"echo":{"metric_name":"cpu"}}` {
+		t.Errorf("result = %s", j.result)
+	}
+	if j.contentType != "application/json" {
+		t.Errorf("content type = %q", j.contentType)
+	}
+	if m.get(info.ID) != j {
+		t.Error("get() did not return the submitted job")
+	}
+}
+
+func TestJobManagerRecordsHandlerErrors(t *testing.T) {
+	m := newJobManager(map[string]http.HandlerFunc{
+		"logs": func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "bad query", http.StatusBadRequest)
+		},
+	}, config.QueryProfiles{})
+
+	j, _ := m.submit("logs", "", []byte(`{}`), "")
+	info := waitForJob(t, j)
+	if info.Status != JobFailed || info.Error != "bad query" {
+		t.Errorf("got status %s error %q, want failed with bad query", info.Status, info.Error)
+	}
+}
+
+func TestJobManagerCancel(t *testing.T) {
+	m := newJobManager(map[string]http.HandlerFunc{
+		"traces": func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+			http.Error(w, r.Context().Err().Error(), http.StatusInternalServerError)
+		},
+	}, config.QueryProfiles{})
+
+	j, _ := m.submit("traces", "", []byte(`{}`), "")
+	j.cancel()
+	if info := waitForJob(t, j); info.Status != JobCanceled {
+		t.Errorf("status = %s, want canceled", info.Status)
+	}
+}
+
+func TestJobManagerUnknownKind(t *testing.T) {
+	m := newJobManager(map[string]http.HandlerFunc{}, config.QueryProfiles{})
+	if _, err := m.submit("profiles", "", []byte(`{}`), ""); err == nil {
+		t.Error("expected error for unknown kind")
+	}
+}
+
+func TestJobManagerUnknownClass(t *testing.T) {
+	m := newJobManager(map[string]http.HandlerFunc{
+		"logs": func(w http.ResponseWriter, r *http.Request) {},
+	}, config.QueryProfiles{})
+	if _, err := m.submit("logs", "dashboard", []byte(`{}`), ""); err == nil {
+		t.Error("expected error for unknown class")
+	}
+}
+
+func TestJobProfileFor(t *testing.T) {
+	m := newJobManager(nil, config.QueryProfiles{
+		Background: config.QueryProfile{MaxThreads: 4, Priority: 10},
+		Export:     config.QueryProfile{MaxThreads: 2, MaxExecutionTime: 2 * time.Hour},
+	})
+
+	bg := m.profileFor(jobClassBackground)
+	if bg.MaxThreads != 4 || bg.Priority != 10 {
+		t.Errorf("background profile = %+v", bg)
+	}
+	if bg.MaxExecutionTime != jobTimeout {
+		t.Errorf("background max execution time = %v, want default %v", bg.MaxExecutionTime, jobTimeout)
+	}
+
+	export := m.profileFor(jobClassExport)
+	if export.MaxThreads != 2 {
+		t.Errorf("export profile = %+v", export)
+	}
+	if export.MaxExecutionTime != jobTimeout {
+		t.Errorf("export max execution time = %v, want capped at %v", export.MaxExecutionTime, jobTimeout)
+	}
+}
+
+func TestJobProgressPercent(t *testing.T) {
+	j := &job{info: JobInfo{Status: JobRunning}}
+	j.addProgress(250, 1000, 1000)
+	j.addProgress(250, 1000, 0)
+
+	info := j.snapshot()
+	if info.Progress.RowsRead != 500 || info.Progress.BytesRead != 2000 {
+		t.Errorf("unexpected progress: %+v", info.Progress)
+	}
+	if info.Progress.Percent != 50 {
+		t.Errorf("percent = %v, want 50", info.Progress.Percent)
+	}
+}
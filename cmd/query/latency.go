@@ -0,0 +1,265 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"otelservices/internal/monitoring"
+)
+
+const (
+	// defaultLatencyPrecision is the number of histogram buckets per doubling
+	// of the span duration
+	defaultLatencyPrecision = 4
+	maxLatencyPrecision     = 16
+	// maxLatencyColumns caps the number of time buckets of a histogram
+	maxLatencyColumns = 1000
+)
+
+// LatencyBucket is a duration bucket of a latency histogram, from LowerNs
+// (inclusive) to UpperNs (exclusive)
+type LatencyBucket struct {
+	LowerNs uint64 `json:"lower_ns"`
+	UpperNs uint64 `json:"upper_ns"`
+}
+
+// LatencyColumn holds the span counts of one time bucket, one per entry of
+// the response's buckets, and duration percentiles computed from the spans
+type LatencyColumn struct {
+	Timestamp time.Time `json:"timestamp"`
+	Count     uint64    `json:"count"`
+	P50Ns     float64   `json:"p50_ns"`
+	P90Ns     float64   `json:"p90_ns"`
+	P99Ns     float64   `json:"p99_ns"`
+	Counts    []uint64  `json:"counts"`
+}
+
+// LatencyHistogramResponse is a heatmap of span durations: columns are time
+// buckets of step, rows are the duration buckets
+type LatencyHistogramResponse struct {
+	ServiceName string          `json:"service_name"`
+	SpanName    string          `json:"span_name,omitempty"`
+	Step        string          `json:"step"`
+	Buckets     []LatencyBucket `json:"buckets"`
+	Columns     []LatencyColumn `json:"columns"`
+}
+
+// latencyHistogramRequest holds the parsed query parameters of a histogram
+type latencyHistogramRequest struct {
+	service   string
+	operation string
+	start     time.Time
+	end       time.Time
+	step      time.Duration
+	precision int
+}
+
+// latencyCell is the span count of one duration bucket in one time bucket
+type latencyCell struct {
+	Timestamp time.Time
+	Bucket    int32
+	Count     uint64
+}
+
+// parseLatencyHistogramRequest reads service, operation, start, end, step and
+// precision from the query string
+func parseLatencyHistogramRequest(r *http.Request) (*latencyHistogramRequest, error) {
+	q := r.URL.Query()
+	req := &latencyHistogramRequest{
+		service:   q.Get("service"),
+		operation: q.Get("operation"),
+		precision: defaultLatencyPrecision,
+	}
+	if req.service == "" {
+		return nil, fmt.Errorf("service is required")
+	}
+	var err error
+	if req.start, req.end, err = parseTimeRange(r, defaultDiscoveryWindow); err != nil {
+		return nil, err
+	}
+	if req.step, err = parseStep(q.Get("step")); err != nil {
+		return nil, err
+	}
+	if val := q.Get("precision"); val != "" {
+		req.precision, err = strconv.Atoi(val)
+		if err != nil || req.precision < 1 || req.precision > maxLatencyPrecision {
+			return nil, fmt.Errorf("precision must be an integer from 1 to %d", maxLatencyPrecision)
+		}
+	}
+	return req, nil
+}
+
+// checkColumns rejects a range that would produce too many time buckets
+func (req *latencyHistogramRequest) checkColumns() error {
+	if n := req.end.Sub(req.start) / req.step; n > maxLatencyColumns {
+		return fmt.Errorf("range of %s in steps of %s gives %d time buckets, over the limit of %d; use a larger step",
+			req.end.Sub(req.start), req.step, n, maxLatencyColumns)
+	}
+	return nil
+}
+
+// latencyFilter returns the WHERE clause shared by the histogram queries
+func (req *latencyHistogramRequest) latencyFilter() (string, []interface{}) {
+	where := " WHERE service_name = ? AND timestamp >= ? AND timestamp <= ?"
+	args := []interface{}{req.service, req.start, req.end}
+	if req.operation != "" {
+		where += " AND span_name = ?"
+		args = append(args, req.operation)
+	}
+	return where, args
+}
+
+// buildLatencyHistogramQuery counts spans per time bucket and log-scale
+// duration bucket. Bucket i covers durations from 2^(i/precision) ns, so
+// buckets line up across time buckets and requests.
+func buildLatencyHistogramQuery(req *latencyHistogramRequest, table string) (string, []interface{}) {
+	where, args := req.latencyFilter()
+	query := fmt.Sprintf(`
+		SELECT
+			toStartOfInterval(timestamp, %s) AS ts,
+			toInt32(floor(log2(greatest(duration_ns, 1)) * %d)) AS bucket,
+			count() AS spans
+		FROM %s`, stepInterval(req.step), req.precision, table)
+	return query + where + " GROUP BY ts, bucket ORDER BY ts, bucket", args
+}
+
+// buildLatencyQuantilesQuery computes exact span counts and duration
+// percentiles per time bucket
+func buildLatencyQuantilesQuery(req *latencyHistogramRequest, table string) (string, []interface{}) {
+	where, args := req.latencyFilter()
+	query := fmt.Sprintf(`
+		SELECT
+			toStartOfInterval(timestamp, %s) AS ts,
+			count() AS spans,
+			quantiles(0.5, 0.9, 0.99)(duration_ns) AS q
+		FROM %s`, stepInterval(req.step), table)
+	return query + where + " GROUP BY ts ORDER BY ts", args
+}
+
+// latencyBucketBounds returns the duration range of log-scale bucket i
+func latencyBucketBounds(i int32, precision int) LatencyBucket {
+	p := float64(precision)
+	return LatencyBucket{
+		LowerNs: uint64(math.Round(math.Pow(2, float64(i)/p))),
+		UpperNs: uint64(math.Round(math.Pow(2, float64(i+1)/p))),
+	}
+}
+
+// fillLatencyColumns lays the cells out as a dense matrix: the buckets run
+// from the shortest to the longest duration bucket seen, and every column
+// gets a count per bucket. Cells of a time bucket missing from columns add
+// a column without percentiles.
+func fillLatencyColumns(cells []latencyCell, columns []LatencyColumn, precision int) ([]LatencyBucket, []LatencyColumn) {
+	buckets := []LatencyBucket{}
+	if len(cells) == 0 {
+		return buckets, columns
+	}
+	lo, hi := cells[0].Bucket, cells[0].Bucket
+	for _, c := range cells {
+		lo, hi = min(lo, c.Bucket), max(hi, c.Bucket)
+	}
+	for i := lo; i <= hi; i++ {
+		buckets = append(buckets, latencyBucketBounds(i, precision))
+	}
+
+	index := make(map[int64]int, len(columns))
+	for i := range columns {
+		columns[i].Counts = make([]uint64, len(buckets))
+		index[columns[i].Timestamp.UnixNano()] = i
+	}
+	for _, c := range cells {
+		i, ok := index[c.Timestamp.UnixNano()]
+		if !ok {
+			i = len(columns)
+			index[c.Timestamp.UnixNano()] = i
+			columns = append(columns, LatencyColumn{Timestamp: c.Timestamp, Counts: make([]uint64, len(buckets))})
+		}
+		columns[i].Counts[c.Bucket-lo] += c.Count
+	}
+	return buckets, columns
+}
+
+// GetLatencyHistogram returns a service's span durations as a heatmap
+func (s *QueryService) GetLatencyHistogram(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	defer func() {
+		monitoring.QueryDuration.WithLabelValues("latency_histogram").Observe(time.Since(start).Seconds())
+	}()
+
+	req, err := parseLatencyHistogramRequest(r)
+	if err == nil {
+		err = req.checkColumns()
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		monitoring.QueryErrors.WithLabelValues("latency_histogram").Inc()
+		return
+	}
+	if !limitTimeRange(w, r, "latency_histogram", &req.start, &req.end) {
+		return
+	}
+	s.markStorageTier(w, "traces", req.start)
+
+	ctx := r.Context()
+	table := s.chClient.SpansTable()
+	query, args := buildLatencyHistogramQuery(req, table)
+	if !s.checkQueryCost(w, r, "latency_histogram", query, args...) {
+		return
+	}
+
+	rows, err := s.chClient.Query(ctx, query, args...)
+	if err != nil {
+		queryFailed(w, "latency_histogram", err)
+		return
+	}
+	defer rows.Close()
+	cells := []latencyCell{}
+	for rows.Next() {
+		var c latencyCell
+		if err := rows.Scan(&c.Timestamp, &c.Bucket, &c.Count); err != nil {
+			logger.Error("Error scanning latency bucket", "error", err)
+			continue
+		}
+		cells = append(cells, c)
+	}
+	if err := rows.Err(); err != nil {
+		queryFailed(w, "latency_histogram", err)
+		return
+	}
+
+	query, args = buildLatencyQuantilesQuery(req, table)
+	qrows, err := s.chClient.Query(ctx, query, args...)
+	if err != nil {
+		queryFailed(w, "latency_histogram", err)
+		return
+	}
+	defer qrows.Close()
+	columns := []LatencyColumn{}
+	for qrows.Next() {
+		var col LatencyColumn
+		var q []float64
+		if err := qrows.Scan(&col.Timestamp, &col.Count, &q); err != nil {
+			logger.Error("Error scanning latency percentiles", "error", err)
+			continue
+		}
+		if len(q) == 3 {
+			col.P50Ns, col.P90Ns, col.P99Ns = q[0], q[1], q[2]
+		}
+		columns = append(columns, col)
+	}
+	if err := qrows.Err(); err != nil {
+		queryFailed(w, "latency_histogram", err)
+		return
+	}
+
+	response := LatencyHistogramResponse{
+		ServiceName: req.service,
+		SpanName:    req.operation,
+		Step:        req.step.String(),
+	}
+	response.Buckets, response.Columns = fillLatencyColumns(cells, columns, req.precision)
+	writeJSONWithETag(w, r, response)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseLatencyHistogramRequest(t *testing.T) {
+	req, err := parseLatencyHistogramRequest(httptest.NewRequest("GET",
+		"/x?service=checkout&operation=GET%20/cart&start=2024-01-01T00:00:00Z&end=2024-01-01T06:00:00Z&step=1h&precision=2", nil))
+	if err != nil {
+		t.Fatalf("parseLatencyHistogramRequest() error = %v", err)
+	}
+	if req.service != "checkout" || req.operation != "GET /cart" || req.step != time.Hour || req.precision != 2 {
+		t.Errorf("unexpected request %+v", req)
+	}
+
+	for _, url := range []string{
+		"/x",
+		"/x?service=a&step=fast",
+		"/x?service=a&precision=0",
+		"/x?service=a&precision=17",
+		"/x?service=a&start=yesterday",
+	} {
+		if _, err := parseLatencyHistogramRequest(httptest.NewRequest("GET", url, nil)); err == nil {
+			t.Errorf("%s: expected an error", url)
+		}
+	}
+}
+
+func TestLatencyHistogramColumnLimit(t *testing.T) {
+	end := time.Date(2024, 1, 8, 0, 0, 0, 0, time.UTC)
+	req := &latencyHistogramRequest{start: end.Add(-7 * 24 * time.Hour), end: end, step: time.Minute}
+	if err := req.checkColumns(); err == nil {
+		t.Error("expected 10080 one-minute buckets to be rejected")
+	}
+	req.step = time.Hour
+	if err := req.checkColumns(); err != nil {
+		t.Errorf("168 hourly buckets rejected: %v", err)
+	}
+}
+
+func TestBuildLatencyHistogramQuery(t *testing.T) {
+	req := &latencyHistogramRequest{service: "checkout", operation: "GET /cart", step: time.Minute, precision: 4}
+	query, args := buildLatencyHistogramQuery(req, "otel_traces")
+	for _, want := range []string{
+		"toStartOfInterval(timestamp, INTERVAL 1 MINUTE) AS ts",
+		"floor(log2(greatest(duration_ns, 1)) * 4)",
+		"FROM otel_traces WHERE service_name = ?",
+		"AND span_name = ?",
+		"GROUP BY ts, bucket",
+	} {
+		if !strings.Contains(query, want) {
+			t.Errorf("query missing %q:\n%s", want, query)
+		}
+	}
+	if len(args) != 4 || args[3] != "GET /cart" {
+		t.Errorf("unexpected args %v", args)
+	}
+
+	req.operation = ""
+	query, args = buildLatencyQuantilesQuery(req, "otel_traces FINAL")
+	if !strings.Contains(query, "quantiles(0.5, 0.9, 0.99)(duration_ns)") || !strings.Contains(query, "FROM otel_traces FINAL") {
+		t.Errorf("unexpected quantiles query:\n%s", query)
+	}
+	if strings.Contains(query, "span_name") || len(args) != 3 {
+		t.Errorf("operation filter without operation: %s %v", query, args)
+	}
+}
+
+func TestLatencyBucketBounds(t *testing.T) {
+	if b := latencyBucketBounds(20, 1); b.LowerNs != 1<<20 || b.UpperNs != 1<<21 {
+		t.Errorf("bucket 20 at precision 1 = %+v", b)
+	}
+	if b := latencyBucketBounds(41, 2); b.LowerNs != 1482910 || b.UpperNs != 1<<21 {
+		t.Errorf("bucket 41 at precision 2 = %+v", b)
+	}
+}
+
+func TestFillLatencyColumns(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	t1 := t0.Add(time.Minute)
+	t2 := t1.Add(time.Minute)
+	cells := []latencyCell{
+		{Timestamp: t0, Bucket: 10, Count: 3},
+		{Timestamp: t0, Bucket: 12, Count: 1},
+		{Timestamp: t1, Bucket: 11, Count: 5},
+		{Timestamp: t2, Bucket: 10, Count: 2},
+	}
+	columns := []LatencyColumn{{Timestamp: t0, Count: 4}, {Timestamp: t1, Count: 5}}
+
+	buckets, columns := fillLatencyColumns(cells, columns, 1)
+	if len(buckets) != 3 || buckets[0].LowerNs != 1024 || buckets[2].UpperNs != 8192 {
+		t.Fatalf("unexpected buckets %+v", buckets)
+	}
+	want := [][]uint64{{3, 0, 1}, {0, 5, 0}, {2, 0, 0}}
+	if len(columns) != len(want) {
+		t.Fatalf("got %d columns, want %d", len(columns), len(want))
+	}
+	for i, col := range columns {
+		for j, n := range want[i] {
+			if col.Counts[j] != n {
+				t.Errorf("column %d counts = %v, want %v", i, col.Counts, want[i])
+				break
+			}
+		}
+	}
+
+	buckets, columns = fillLatencyColumns(nil, []LatencyColumn{}, 4)
+	if len(buckets) != 0 || len(columns) != 0 {
+		t.Errorf("empty histogram = %v %v", buckets, columns)
+	}
+}
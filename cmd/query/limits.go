@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"otelservices/internal/clickhouse"
+	"otelservices/internal/config"
+	"otelservices/internal/monitoring"
+)
+
+// QueryLimitError is the body of a request rejected by a query limit
+type QueryLimitError struct {
+	Error     string `json:"error"`
+	Limit     string `json:"limit"`            // the limit hit, e.g. max_time_range or max_rows_to_read
+	Max       string `json:"max,omitempty"`    // its configured value
+	Actual    string `json:"actual,omitempty"` // what the request asked for
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// writeLimitError rejects a request with 422 and a QueryLimitError
+func writeLimitError(w http.ResponseWriter, e QueryLimitError) {
+	e.RequestID = w.Header().Get(monitoring.RequestIDHeader)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(w).Encode(e)
+}
+
+type queryLimitsKey struct{}
+
+// withQueryLimits attaches the limits applied by limitTimeRange. Like the
+// query budget, only synchronous API requests carry them.
+func withQueryLimits(ctx context.Context, limits config.QueryLimits) context.Context {
+	return context.WithValue(ctx, queryLimitsKey{}, limits)
+}
+
+func queryLimitsFrom(ctx context.Context) (config.QueryLimits, bool) {
+	limits, ok := ctx.Value(queryLimitsKey{}).(config.QueryLimits)
+	return limits, ok && limits.MaxTimeRange > 0
+}
+
+// limitTimeRange checks a request's time range against the limits it
+// carries. Ranges without both bounds or longer than max_time_range are
+// rejected, or with action clamp narrowed by clampTimeRange, in which case
+// X-Query-Time-Range gives the range queried. It returns false when the
+// response has already been written.
+func limitTimeRange(w http.ResponseWriter, r *http.Request, queryType string, start, end *time.Time) bool {
+	limits, ok := queryLimitsFrom(r.Context())
+	if !ok {
+		return true
+	}
+	violation := checkTimeRange(*start, *end, limits.MaxTimeRange)
+	if violation == nil {
+		return true
+	}
+
+	if limits.Action == "clamp" {
+		*start, *end = clampTimeRange(*start, *end, limits.MaxTimeRange, time.Now().UTC())
+		monitoring.QueryLimitsApplied.WithLabelValues(queryType, violation.Limit, "clamp").Inc()
+		w.Header().Set("X-Query-Time-Range", start.Format(time.RFC3339)+"/"+end.Format(time.RFC3339))
+		return true
+	}
+	monitoring.QueryLimitsApplied.WithLabelValues(queryType, violation.Limit, "reject").Inc()
+	writeLimitError(w, *violation)
+	return false
+}
+
+// checkTimeRange returns the limit a time range violates, or nil
+func checkTimeRange(start, end time.Time, maxRange time.Duration) *QueryLimitError {
+	if start.IsZero() || end.IsZero() {
+		return &QueryLimitError{
+			Error: "start_time and end_time are required",
+			Limit: "time_bounds",
+			Max:   maxRange.String(),
+		}
+	}
+	if d := end.Sub(start); d > maxRange {
+		return &QueryLimitError{
+			Error:  fmt.Sprintf("time range of %s is over the limit of %s; narrow the range or submit a job", d, maxRange),
+			Limit:  "max_time_range",
+			Max:    maxRange.String(),
+			Actual: d.String(),
+		}
+	}
+	return nil
+}
+
+// clampTimeRange narrows a time range to at most maxRange, keeping its end.
+// A missing end is now, or start+maxRange when that is earlier.
+func clampTimeRange(start, end time.Time, maxRange time.Duration, now time.Time) (time.Time, time.Time) {
+	if end.IsZero() {
+		end = now
+		if !start.IsZero() && start.Add(maxRange).Before(now) {
+			end = start.Add(maxRange)
+		}
+	}
+	if start.IsZero() || end.Sub(start) > maxRange {
+		start = end.Add(-maxRange)
+	}
+	return start, end
+}
+
+// queryFailed reports a failed ClickHouse query: 422 with a QueryLimitError
+// when ClickHouse aborted it at a setting of the query profile, such as
+// max_rows_to_read, and 500 otherwise
+func queryFailed(w http.ResponseWriter, queryType string, err error) {
+	monitoring.QueryErrors.WithLabelValues(queryType).Inc()
+	limit := clickhouse.ExceededLimit(err)
+	if limit == "" {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	monitoring.QueryLimitsApplied.WithLabelValues(queryType, limit, "reject").Inc()
+	writeLimitError(w, QueryLimitError{
+		Error: fmt.Sprintf("query stopped at the %s limit; narrow the time range or add filters", limit),
+		Limit: limit,
+	})
+}
+
+// errorMessage returns the message of a failed handler's response body,
+// unwrapping a QueryLimitError
+func errorMessage(body []byte) string {
+	var e QueryLimitError
+	if json.Unmarshal(body, &e) == nil && e.Limit != "" {
+		return e.Error
+	}
+	return string(bytes.TrimSpace(body))
+}
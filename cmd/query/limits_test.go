@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"otelservices/internal/config"
+)
+
+func TestCheckTimeRange(t *testing.T) {
+	end := time.Date(2024, 1, 8, 0, 0, 0, 0, time.UTC)
+	week := 7 * 24 * time.Hour
+
+	if v := checkTimeRange(end.Add(-week), end, week); v != nil {
+		t.Errorf("range at the limit should pass, got %+v", v)
+	}
+	if v := checkTimeRange(time.Time{}, end, week); v == nil || v.Limit != "time_bounds" {
+		t.Errorf("missing start: got %+v", v)
+	}
+	v := checkTimeRange(end.Add(-30*24*time.Hour), end, week)
+	if v == nil || v.Limit != "max_time_range" || v.Max != "168h0m0s" || v.Actual != "720h0m0s" {
+		t.Errorf("long range: got %+v", v)
+	}
+}
+
+func TestClampTimeRange(t *testing.T) {
+	now := time.Date(2024, 1, 8, 0, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	tests := []struct {
+		name               string
+		start, end         time.Time
+		wantStart, wantEnd time.Time
+	}{
+		{"no bounds", time.Time{}, time.Time{}, now.Add(-day), now},
+		{"no start", time.Time{}, now.Add(-day), now.Add(-2 * day), now.Add(-day)},
+		{"old start without end", now.Add(-5 * day), time.Time{}, now.Add(-5 * day), now.Add(-4 * day)},
+		{"recent start without end", now.Add(-time.Hour), time.Time{}, now.Add(-time.Hour), now},
+		{"long range keeps end", now.Add(-5 * day), now.Add(-day), now.Add(-2 * day), now.Add(-day)},
+	}
+	for _, tt := range tests {
+		start, end := clampTimeRange(tt.start, tt.end, day, now)
+		if !start.Equal(tt.wantStart) || !end.Equal(tt.wantEnd) {
+			t.Errorf("%s: got %v - %v, want %v - %v", tt.name, start, end, tt.wantStart, tt.wantEnd)
+		}
+	}
+}
+
+func TestLimitTimeRange(t *testing.T) {
+	limits := config.QueryLimits{MaxTimeRange: time.Hour, Action: "reject"}
+	request := func(limits config.QueryLimits) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/logs", nil)
+		return r.WithContext(withQueryLimits(context.Background(), limits))
+	}
+	end := time.Now().UTC()
+
+	start := end.Add(-2 * time.Hour)
+	rec := httptest.NewRecorder()
+	if limitTimeRange(rec, request(limits), "logs", &start, &end) {
+		t.Fatal("expected the range to be rejected")
+	}
+	var body QueryLimitError
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || rec.Code != http.StatusUnprocessableEntity || body.Limit != "max_time_range" {
+		t.Errorf("rejection: %d %s (%v)", rec.Code, rec.Body.String(), err)
+	}
+	if msg := errorMessage(rec.Body.Bytes()); msg != body.Error {
+		t.Errorf("errorMessage() = %q, want %q", msg, body.Error)
+	}
+
+	limits.Action = "clamp"
+	rec = httptest.NewRecorder()
+	if !limitTimeRange(rec, request(limits), "logs", &start, &end) {
+		t.Fatal("expected the range to be clamped")
+	}
+	if end.Sub(start) != time.Hour || rec.Header().Get("X-Query-Time-Range") == "" {
+		t.Errorf("clamped to %v, header %q", end.Sub(start), rec.Header().Get("X-Query-Time-Range"))
+	}
+
+	// Requests without limits, such as async jobs, run unchanged
+	start = end.Add(-48 * time.Hour)
+	if !limitTimeRange(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/v1/logs", nil), "logs", &start, &end) || end.Sub(start) != 48*time.Hour {
+		t.Error("request without limits should not be changed")
+	}
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"otelservices/internal/monitoring"
+)
+
+const (
+	defaultLogPatternsLimit = 50
+	maxLogPatternsLimit     = 500
+	// maxLogPatternLines caps the log lines clustered per request; wider
+	// ranges are sampled down to it
+	maxLogPatternLines = 50000
+	// maxLogPatternBodyLength truncates bodies before they are tokenized
+	maxLogPatternBodyLength = 1000
+	// logPatternBuckets is the number of time buckets of a pattern's trend
+	logPatternBuckets = 12
+	// logPatternSimilarity is the share of tokens a line must have in common
+	// with a pattern to join it
+	logPatternSimilarity = 0.5
+	// logPatternWildcard replaces the tokens that vary within a pattern
+	logPatternWildcard = "<*>"
+)
+
+// LogPattern is a template shared by log lines of a service, with the
+// variable tokens replaced by <*>. Counts are estimates when the response is
+// sampled.
+type LogPattern struct {
+	ServiceName string    `json:"service_name"`
+	Pattern     string    `json:"pattern"`
+	Sample      string    `json:"sample"`
+	Count       uint64    `json:"count"`
+	Percent     float64   `json:"percent"`
+	FirstSeen   time.Time `json:"first_seen"`
+	LastSeen    time.Time `json:"last_seen"`
+	// Trend compares the second half of the range with the first: new,
+	// rising, falling or steady
+	Trend  string   `json:"trend"`
+	Counts []uint64 `json:"counts"` // per time bucket of bucket_ms
+}
+
+type LogPatternsResponse struct {
+	Patterns  []LogPattern `json:"patterns"`
+	Total     int          `json:"total"` // patterns found, before the limit
+	TotalLogs uint64       `json:"total_logs"`
+	// SampleRate is the share of the matching logs that were clustered
+	SampleRate float64 `json:"sample_rate"`
+	BucketMs   int64   `json:"bucket_ms"`
+}
+
+// logPatternsRequest holds the parsed query parameters of /api/v1/logs/patterns
+type logPatternsRequest struct {
+	service  string
+	severity string
+	start    time.Time
+	end      time.Time
+	limit    int
+}
+
+func parseLogPatternsRequest(r *http.Request) (*logPatternsRequest, error) {
+	q := r.URL.Query()
+	req := &logPatternsRequest{service: q.Get("service"), severity: q.Get("severity"), limit: defaultLogPatternsLimit}
+	var err error
+	if req.start, req.end, err = parseTimeRange(r, defaultDiscoveryWindow); err != nil {
+		return nil, err
+	}
+	if val := q.Get("limit"); val != "" {
+		req.limit, err = strconv.Atoi(val)
+		if err != nil || req.limit <= 0 || req.limit > maxLogPatternsLimit {
+			return nil, fmt.Errorf("limit must be an integer from 1 to %d", maxLogPatternsLimit)
+		}
+	}
+	return req, nil
+}
+
+// logPatternsFilter returns the WHERE clause selecting the request's logs
+func logPatternsFilter(req *logPatternsRequest) (string, []interface{}, error) {
+	where := "timestamp >= ? AND timestamp <= ?"
+	args := []interface{}{req.start, req.end}
+	if req.service != "" {
+		where += " AND service_name = ?"
+		args = append(args, req.service)
+	}
+	clause, severityArgs, err := severityFilter(&LogsQueryRequest{Severity: req.severity})
+	if err != nil {
+		return "", nil, err
+	}
+	return where + clause, append(args, severityArgs...), nil
+}
+
+// buildLogPatternsQuery selects the bodies to cluster. A sample rate below 1
+// keeps each log with that probability.
+func buildLogPatternsQuery(where string, args []interface{}, sampleRate float64) (string, []interface{}) {
+	if sampleRate < 1 {
+		where += " AND rand() < ?"
+		args = append(args, uint32(sampleRate*math.MaxUint32))
+	}
+	query := fmt.Sprintf(`
+		SELECT toString(service_name), timestamp, substring(body, 1, %d)
+		FROM otel_logs
+		WHERE %s
+		LIMIT %d
+	`, maxLogPatternBodyLength, where, maxLogPatternLines)
+	return query, args
+}
+
+// patternTokens splits a log body into tokens. Tokens containing a digit
+// are IDs, counts or timestamps in almost every log format and are masked
+// up front, so that the first line of a pattern is already general.
+func patternTokens(body string) []string {
+	tokens := strings.Fields(body)
+	for i, tok := range tokens {
+		if strings.IndexFunc(tok, unicode.IsDigit) >= 0 {
+			tokens[i] = logPatternWildcard
+		}
+	}
+	return tokens
+}
+
+// logCluster is a pattern being mined and the lines that joined it
+type logCluster struct {
+	service   string
+	tokens    []string
+	sample    string
+	count     uint64
+	firstSeen time.Time
+	lastSeen  time.Time
+	counts    []uint64
+}
+
+// similarity is the share of positions where tokens equal the cluster's
+// template, and the number of wildcards in it, which break ties
+func (c *logCluster) similarity(tokens []string) (float64, int) {
+	same, wildcards := 0, 0
+	for i, tok := range c.tokens {
+		if tok == logPatternWildcard {
+			wildcards++
+		} else if tok == tokens[i] {
+			same++
+		}
+	}
+	return float64(same) / float64(len(tokens)), wildcards
+}
+
+// patternMiner groups log lines into patterns in the manner of Drain: lines
+// are only compared with patterns of the same service, token count and
+// first token, and join the most similar one if it shares enough tokens,
+// which then turns the tokens that differ into wildcards
+type patternMiner struct {
+	start, end time.Time
+	groups     map[string][]*logCluster
+	clusters   []*logCluster
+	lines      uint64
+}
+
+func newPatternMiner(start, end time.Time) *patternMiner {
+	return &patternMiner{start: start, end: end, groups: make(map[string][]*logCluster)}
+}
+
+// bucket returns the trend bucket of a timestamp
+func (m *patternMiner) bucket(ts time.Time) int {
+	width := m.end.Sub(m.start)
+	if width <= 0 {
+		return 0
+	}
+	i := int(int64(ts.Sub(m.start)) * logPatternBuckets / int64(width))
+	if i < 0 {
+		return 0
+	}
+	if i >= logPatternBuckets {
+		return logPatternBuckets - 1
+	}
+	return i
+}
+
+// add clusters one log line
+func (m *patternMiner) add(service string, ts time.Time, body string) {
+	tokens := patternTokens(body)
+	if len(tokens) == 0 {
+		return
+	}
+	m.lines++
+	key := fmt.Sprintf("%s\x00%d\x00%s", service, len(tokens), tokens[0])
+
+	var best *logCluster
+	bestSim, bestWildcards := 0.0, -1
+	for _, c := range m.groups[key] {
+		sim, wildcards := c.similarity(tokens)
+		if sim > bestSim || (sim == bestSim && wildcards > bestWildcards) {
+			best, bestSim, bestWildcards = c, sim, wildcards
+		}
+	}
+	if best == nil || bestSim < logPatternSimilarity {
+		best = &logCluster{service: service, tokens: tokens, sample: body, firstSeen: ts, lastSeen: ts, counts: make([]uint64, logPatternBuckets)}
+		m.groups[key] = append(m.groups[key], best)
+		m.clusters = append(m.clusters, best)
+	} else {
+		for i, tok := range tokens {
+			if best.tokens[i] != tok {
+				best.tokens[i] = logPatternWildcard
+			}
+		}
+	}
+
+	best.count++
+	best.counts[m.bucket(ts)]++
+	if ts.Before(best.firstSeen) {
+		best.firstSeen = ts
+	}
+	if ts.After(best.lastSeen) {
+		best.lastSeen = ts
+	}
+}
+
+// patternTrend compares the second half of the buckets with the first
+func patternTrend(counts []uint64) string {
+	var first, second uint64
+	for i, n := range counts {
+		if i < len(counts)/2 {
+			first += n
+		} else {
+			second += n
+		}
+	}
+	switch {
+	case first == 0 && second > 0:
+		return "new"
+	case first == 0:
+		return "steady"
+	case float64(second) >= 1.5*float64(first):
+		return "rising"
+	case float64(second) <= float64(first)/1.5:
+		return "falling"
+	}
+	return "steady"
+}
+
+// patterns returns the mined patterns, most frequent first, with counts
+// scaled up by the sample rate
+func (m *patternMiner) patterns(sampleRate float64) []LogPattern {
+	scale := func(n uint64) uint64 { return uint64(math.Round(float64(n) / sampleRate)) }
+	patterns := make([]LogPattern, 0, len(m.clusters))
+	for _, c := range m.clusters {
+		p := LogPattern{
+			ServiceName: c.service,
+			Pattern:     strings.Join(c.tokens, " "),
+			Sample:      c.sample,
+			Count:       scale(c.count),
+			Percent:     float64(c.count) / float64(m.lines) * 100,
+			FirstSeen:   c.firstSeen,
+			LastSeen:    c.lastSeen,
+			Trend:       patternTrend(c.counts),
+			Counts:      make([]uint64, len(c.counts)),
+		}
+		for i, n := range c.counts {
+			p.Counts[i] = scale(n)
+		}
+		patterns = append(patterns, p)
+	}
+	sort.SliceStable(patterns, func(i, j int) bool {
+		if patterns[i].Count != patterns[j].Count {
+			return patterns[i].Count > patterns[j].Count
+		}
+		return patterns[i].Pattern < patterns[j].Pattern
+	})
+	return patterns
+}
+
+// GetLogPatterns clusters the log bodies of a time range into patterns and
+// returns the most frequent ones with their trend
+func (s *QueryService) GetLogPatterns(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	defer func() {
+		monitoring.QueryDuration.WithLabelValues("log_patterns").Observe(time.Since(start).Seconds())
+	}()
+
+	req, err := parseLogPatternsRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		monitoring.QueryErrors.WithLabelValues("log_patterns").Inc()
+		return
+	}
+	if !limitTimeRange(w, r, "log_patterns", &req.start, &req.end) {
+		return
+	}
+	s.markStorageTier(w, "logs", req.start)
+	where, args, err := logPatternsFilter(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		monitoring.QueryErrors.WithLabelValues("log_patterns").Inc()
+		return
+	}
+
+	// Count first to pick a sample rate that keeps the lines under the cap
+	var total uint64
+	if err := s.chClient.QueryRow(r.Context(), "SELECT count() FROM otel_logs WHERE "+where, args...).Scan(&total); err != nil {
+		queryFailed(w, "log_patterns", err)
+		return
+	}
+	sampleRate := 1.0
+	if total > maxLogPatternLines {
+		sampleRate = float64(maxLogPatternLines) / float64(total)
+	}
+
+	query, args := buildLogPatternsQuery(where, args, sampleRate)
+	if !s.checkQueryCost(w, r, "log_patterns", query, args...) {
+		return
+	}
+	rows, err := s.chClient.Query(r.Context(), query, args...)
+	if err != nil {
+		queryFailed(w, "log_patterns", err)
+		return
+	}
+	defer rows.Close()
+
+	miner := newPatternMiner(req.start, req.end)
+	for rows.Next() {
+		var service, body string
+		var ts time.Time
+		if err := rows.Scan(&service, &ts, &body); err != nil {
+			logger.Error("Error scanning log line", "error", err)
+			continue
+		}
+		miner.add(service, ts, body)
+	}
+	if err := rows.Err(); err != nil {
+		queryFailed(w, "log_patterns", err)
+		return
+	}
+
+	patterns := miner.patterns(sampleRate)
+	response := LogPatternsResponse{
+		Patterns:   patterns,
+		Total:      len(patterns),
+		TotalLogs:  total,
+		SampleRate: sampleRate,
+		BucketMs:   req.end.Sub(req.start).Milliseconds() / logPatternBuckets,
+	}
+	if len(patterns) > req.limit {
+		response.Patterns = patterns[:req.limit]
+	}
+	writeJSONWithETag(w, r, response)
+}
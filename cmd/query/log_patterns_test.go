@@ -0,0 +1,113 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseLogPatternsRequest(t *testing.T) {
+	req, err := parseLogPatternsRequest(httptest.NewRequest("GET", "/x?service=checkout&severity=WARN%2B&limit=10", nil))
+	if err != nil {
+		t.Fatalf("parseLogPatternsRequest() error = %v", err)
+	}
+	if req.service != "checkout" || req.severity != "WARN+" || req.limit != 10 || req.end.Sub(req.start) != time.Hour {
+		t.Errorf("unexpected request %+v", req)
+	}
+
+	for _, url := range []string{"/x?limit=0", "/x?limit=501", "/x?start=yesterday"} {
+		if _, err := parseLogPatternsRequest(httptest.NewRequest("GET", url, nil)); err == nil {
+			t.Errorf("%s: expected an error", url)
+		}
+	}
+}
+
+func TestBuildLogPatternsQuery(t *testing.T) {
+	where, args, err := logPatternsFilter(&logPatternsRequest{service: "checkout", severity: "ERROR+"})
+	if err != nil {
+		t.Fatalf("logPatternsFilter() error = %v", err)
+	}
+	if !strings.Contains(where, "service_name = ?") || !strings.Contains(where, "severity_number >= ?") {
+		t.Errorf("unexpected filter %s", where)
+	}
+
+	query, all := buildLogPatternsQuery(where, args, 1)
+	if strings.Contains(query, "rand()") || len(all) != len(args) {
+		t.Errorf("unsampled query:\n%s", query)
+	}
+	query, all = buildLogPatternsQuery(where, args, 0.5)
+	if !strings.Contains(query, "rand() < ?") || all[len(all)-1] != uint32(2147483647) {
+		t.Errorf("sampled query:\n%s\nargs %v", query, all)
+	}
+	if n := strings.Count(query, "?"); n != len(all) {
+		t.Errorf("query has %d placeholders but %d args", n, len(all))
+	}
+
+	if _, _, err := logPatternsFilter(&logPatternsRequest{severity: "LOUD+"}); err == nil {
+		t.Error("expected an error for an unknown severity level")
+	}
+}
+
+func TestPatternMiner(t *testing.T) {
+	t0 := time.Date(2024, 3, 1, 11, 0, 0, 0, time.UTC)
+	m := newPatternMiner(t0, t0.Add(time.Hour))
+	lines := []struct {
+		service, body string
+		minute        int
+	}{
+		{"checkout", "user alice logged in from web", 1},
+		{"checkout", "user bob logged in from mobile", 40},
+		{"checkout", "user carol logged in from web", 50},
+		{"checkout", "payment 1234 failed after 3 retries", 55},
+		{"checkout", "payment 98 failed after 1 retries", 59},
+		{"cart", "user dave logged in from web", 10},
+		{"checkout", "shutting down", 30},
+		{"checkout", "   ", 30},
+	}
+	for _, l := range lines {
+		m.add(l.service, t0.Add(time.Duration(l.minute)*time.Minute), l.body)
+	}
+
+	patterns := m.patterns(1)
+	if len(patterns) != 4 {
+		t.Fatalf("patterns = %+v", patterns)
+	}
+	top := patterns[0]
+	if top.Pattern != "user <*> logged in from <*>" || top.ServiceName != "checkout" || top.Count != 3 {
+		t.Errorf("top pattern = %+v", top)
+	}
+	if top.Sample != "user alice logged in from web" || !top.FirstSeen.Equal(t0.Add(time.Minute)) || !top.LastSeen.Equal(t0.Add(50*time.Minute)) {
+		t.Errorf("top pattern bounds = %+v", top)
+	}
+	if top.Counts[0] != 1 || top.Counts[8] != 1 || top.Counts[10] != 1 || top.Trend != "rising" {
+		t.Errorf("top pattern trend %s %v", top.Trend, top.Counts)
+	}
+	if patterns[1].Pattern != "payment <*> failed after <*> retries" || patterns[1].Trend != "new" {
+		t.Errorf("masked pattern = %+v", patterns[1])
+	}
+
+	// Sampled counts are scaled up, shares are not
+	scaled := m.patterns(0.25)
+	if scaled[0].Count != 12 || scaled[0].Counts[0] != 4 || scaled[0].Percent != top.Percent {
+		t.Errorf("scaled pattern = %+v", scaled[0])
+	}
+}
+
+func TestPatternTrend(t *testing.T) {
+	tests := []struct {
+		counts []uint64
+		want   string
+	}{
+		{[]uint64{0, 0, 3, 4}, "new"},
+		{[]uint64{2, 2, 3, 3}, "rising"},
+		{[]uint64{5, 5, 3, 3}, "falling"},
+		{[]uint64{5, 5, 4, 5}, "steady"},
+		{[]uint64{0, 0, 0, 0}, "steady"},
+	}
+	for _, tt := range tests {
+		if got := patternTrend(tt.counts); got != tt.want {
+			t.Errorf("patternTrend(%v) = %s, want %s", tt.counts, got, tt.want)
+		}
+	}
+}
@@ -3,19 +3,27 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"sort"
+	"strings"
 	"syscall"
 	"time"
 
 	"otelservices/internal/clickhouse"
 	"otelservices/internal/config"
+	"otelservices/internal/logging"
 	"otelservices/internal/monitoring"
 
 	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
 )
 
 const (
@@ -23,46 +31,107 @@ const (
 	serviceVersion = "1.0.0"
 )
 
+// logger is the component logger of the query service
+var logger = logging.For("query")
+
 // QueryService provides query APIs for OTEL data
 type QueryService struct {
 	config      *config.Config
 	chClient    *clickhouse.Client
 	healthCheck *monitoring.HealthCheck
+	metadata    *metadataCache
+	results     *resultCache
+	gate        *queryGate
+	jobs        *jobManager
+	retention   *retentionManager
+	deletions   *deletionManager
+	anomalies   *anomalyDetector
+	slos        *sloEvaluator
+	reloader    *config.Reloader
+	auth        *authPolicy // nil with auth disabled
+	audit       *auditLog   // nil with auditing disabled
 }
 
 // NewQueryService creates a new query service instance
 func NewQueryService(cfg *config.Config, chClient *clickhouse.Client) *QueryService {
-	return &QueryService{
+	s := &QueryService{
 		config:      cfg,
 		chClient:    chClient,
 		healthCheck: monitoring.NewHealthCheck(),
-	}
+		metadata:    newMetadataCache(cfg.Performance.CacheTTL),
+		results:     newResultCache(cfg.QueryCache, cfg.Performance.CacheTTL),
+		gate:        newQueryGate(cfg.ClickHouse.QueryGate),
+		retention:   newRetentionManager(cfg.Retention, chClient),
+		deletions:   newDeletionManager(chClient),
+		anomalies:   newAnomalyDetector(cfg.Anomalies, cfg.ClickHouse.QueryProfiles.Background, chClient),
+		slos:        newSLOEvaluator(cfg.SLO, cfg.ClickHouse.QueryProfiles.Background, chClient),
+		audit:       newAuditLog(cfg.Audit, chClient),
+	}
+	s.jobs = newJobManager(map[string]http.HandlerFunc{
+		"traces":  s.QueryTraces,
+		"metrics": s.QueryMetrics,
+		"logs":    s.QueryLogs,
+	}, cfg.ClickHouse.QueryProfiles)
+	s.jobs.exports = newExporter(cfg.Export)
+	s.reloader = config.NewReloader(cfg, s.applyConfig)
+	return s
+}
+
+// interactiveProfile runs synchronous API requests with the interactive query
+// profile, query budget and query limits. Jobs call the handlers directly and set their own
+// profile.
+func (s *QueryService) interactiveProfile(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(s.interactiveContext(r.Context())))
+	})
+}
+
+// interactiveContext sets the interactive query profile, query budget and
+// query limits of the effective config
+func (s *QueryService) interactiveContext(ctx context.Context) context.Context {
+	cfg := s.reloader.Current()
+	ctx = clickhouse.WithQueryProfile(ctx, cfg.ClickHouse.QueryProfiles.Interactive)
+	ctx = withQueryLimits(ctx, cfg.ClickHouse.QueryLimits)
+	return withQueryBudget(ctx, cfg.ClickHouse.QueryBudget)
 }
 
 // Trace query request/response structures
 type TraceQueryRequest struct {
-	TraceID   string    `json:"trace_id"`
-	ServiceName string  `json:"service_name,omitempty"`
-	StartTime time.Time `json:"start_time,omitempty"`
-	EndTime   time.Time `json:"end_time,omitempty"`
-	MinDuration int64   `json:"min_duration,omitempty"`
-	MaxDuration int64   `json:"max_duration,omitempty"`
-	Limit     int       `json:"limit,omitempty"`
+	TraceID     string    `json:"trace_id"`
+	ServiceName string    `json:"service_name,omitempty"`
+	StartTime   time.Time `json:"start_time,omitempty"`
+	EndTime     time.Time `json:"end_time,omitempty"`
+	MinDuration int64     `json:"min_duration,omitempty" validate:"min=0"`
+	MaxDuration int64     `json:"max_duration,omitempty" validate:"min=0"`
+	Limit       int       `json:"limit,omitempty" validate:"min=0,max=10000"`
+	// IncludeColdAttributes also reads attributes_cold, for spans whose
+	// attributes were tiered at ingest
+	IncludeColdAttributes bool `json:"include_cold_attributes,omitempty"`
+	// AttributeFilters compare attributes by type, e.g. http.status_code gte 500
+	AttributeFilters []AttributeFilter `json:"attribute_filters,omitempty"`
 }
 
 type Span struct {
-	TraceID       string            `json:"trace_id"`
-	SpanID        string            `json:"span_id"`
-	ParentSpanID  string            `json:"parent_span_id"`
-	SpanName      string            `json:"span_name"`
-	SpanKind      string            `json:"span_kind"`
-	StartTime     time.Time         `json:"start_time"`
-	EndTime       time.Time         `json:"end_time"`
-	DurationNs    uint64            `json:"duration_ns"`
-	StatusCode    string            `json:"status_code"`
-	StatusMessage string            `json:"status_message"`
-	ServiceName   string            `json:"service_name"`
-	Attributes    map[string]string `json:"attributes"`
+	TraceID                string                `json:"trace_id"`
+	SpanID                 string                `json:"span_id"`
+	ParentSpanID           string                `json:"parent_span_id"`
+	SpanName               string                `json:"span_name"`
+	SpanKind               string                `json:"span_kind"`
+	StartTime              time.Time             `json:"start_time"`
+	EndTime                time.Time             `json:"end_time"`
+	DurationNs             uint64                `json:"duration_ns"`
+	StatusCode             string                `json:"status_code"`
+	StatusMessage          string                `json:"status_message"`
+	ServiceName            string                `json:"service_name"`
+	Attributes             map[string]string     `json:"attributes"`
+	Events                 []SpanEvent           `json:"events"`
+	Links                  []SpanLink            `json:"links"`
+	Trace                  *TraceSummary         `json:"trace,omitempty"`
+	TraceState             string                `json:"trace_state,omitempty"`
+	DroppedAttributesCount uint32                `json:"dropped_attributes_count,omitempty"`
+	DroppedEventsCount     uint32                `json:"dropped_events_count,omitempty"`
+	DroppedLinksCount      uint32                `json:"dropped_links_count,omitempty"`
+	Scope                  *InstrumentationScope `json:"scope,omitempty"`
 }
 
 type TraceQueryResponse struct {
@@ -71,15 +140,17 @@ type TraceQueryResponse struct {
 }
 
 // Metrics query structures
+
 type MetricsQueryRequest struct {
-	MetricName string            `json:"metric_name"`
-	ServiceName string           `json:"service_name,omitempty"`
-	StartTime  time.Time         `json:"start_time"`
-	EndTime    time.Time         `json:"end_time"`
-	Aggregation string           `json:"aggregation,omitempty"` // avg, min, max, sum
-	GroupBy    []string          `json:"group_by,omitempty"`
-	Filters    map[string]string `json:"filters,omitempty"`
-	Step       string            `json:"step,omitempty"` // 5m, 1h, etc.
+	MetricName  string            `json:"metric_name" validate:"required"`
+	ServiceName string            `json:"service_name,omitempty"`
+	StartTime   time.Time         `json:"start_time"`
+	EndTime     time.Time         `json:"end_time"`
+	Aggregation string            `json:"aggregation,omitempty" validate:"oneof=avg min max sum"`
+	GroupBy     []string          `json:"group_by,omitempty"`
+	Filters     map[string]string `json:"filters,omitempty"`
+	Step        string            `json:"step,omitempty"`     // 5m, 1h, 1d, etc.; defaults to 5m
+	Timezone    string            `json:"timezone,omitempty"` // IANA name bucket boundaries align to; defaults to UTC
 }
 
 type MetricDataPoint struct {
@@ -88,9 +159,16 @@ type MetricDataPoint struct {
 	Labels    map[string]string `json:"labels,omitempty"`
 }
 
+// MetricSeries holds the data points for one combination of group-by labels
+type MetricSeries struct {
+	Labels     map[string]string `json:"labels"`
+	DataPoints []MetricDataPoint `json:"data_points"`
+}
+
 type MetricsQueryResponse struct {
 	MetricName string            `json:"metric_name"`
 	DataPoints []MetricDataPoint `json:"data_points"`
+	Series     []MetricSeries    `json:"series,omitempty"`
 }
 
 // Logs query structures
@@ -99,20 +177,27 @@ type LogsQueryRequest struct {
 	StartTime   time.Time         `json:"start_time"`
 	EndTime     time.Time         `json:"end_time"`
 	Severity    string            `json:"severity,omitempty"`
+	SeverityMin uint8             `json:"severity_min,omitempty" validate:"max=24"` // severity_number lower bound
+	SeverityMax uint8             `json:"severity_max,omitempty" validate:"max=24"` // severity_number upper bound
 	SearchText  string            `json:"search_text,omitempty"`
 	TraceID     string            `json:"trace_id,omitempty"`
 	Filters     map[string]string `json:"filters,omitempty"`
-	Limit       int               `json:"limit,omitempty"`
+	Limit       int               `json:"limit,omitempty" validate:"min=0,max=10000"`
+	// AttributeFilters compare attributes by type, e.g. http.status_code gte 500
+	AttributeFilters []AttributeFilter `json:"attribute_filters,omitempty"`
 }
 
 type LogRecord struct {
-	Timestamp     time.Time         `json:"timestamp"`
-	SeverityText  string            `json:"severity_text"`
-	Body          string            `json:"body"`
-	ServiceName   string            `json:"service_name"`
-	TraceID       string            `json:"trace_id,omitempty"`
-	SpanID        string            `json:"span_id,omitempty"`
-	Attributes    map[string]string `json:"attributes"`
+	Timestamp              time.Time             `json:"timestamp"`
+	SeverityNumber         uint8                 `json:"severity_number"`
+	SeverityText           string                `json:"severity_text"`
+	Body                   string                `json:"body"`
+	ServiceName            string                `json:"service_name"`
+	TraceID                string                `json:"trace_id,omitempty"`
+	SpanID                 string                `json:"span_id,omitempty"`
+	Attributes             map[string]string     `json:"attributes"`
+	DroppedAttributesCount uint32                `json:"dropped_attributes_count,omitempty"`
+	Scope                  *InstrumentationScope `json:"scope,omitempty"`
 }
 
 type LogsQueryResponse struct {
@@ -140,14 +225,36 @@ func (s *QueryService) QueryTraces(w http.ResponseWriter, r *http.Request) {
 	if req.Limit == 0 {
 		req.Limit = 100
 	}
+	// Lookups by trace ID are cheap at any range; an open range is narrowed
+	// to the trace's days from the trace index
+	if req.TraceID == "" && !limitTimeRange(w, r, "traces", &req.StartTime, &req.EndTime) {
+		return
+	}
+	if req.TraceID != "" && (req.StartTime.IsZero() || req.EndTime.IsZero()) {
+		from, to := s.traceLookupRange(r.Context(), []string{req.TraceID})
+		if req.StartTime.IsZero() {
+			req.StartTime = from
+		}
+		if req.EndTime.IsZero() {
+			req.EndTime = to
+		}
+	}
+	s.markStorageTier(w, "traces", req.StartTime)
 
 	ctx := r.Context()
+	_, buildSpan := monitoring.Tracer().Start(ctx, "build traces query")
 	query := `
 		SELECT
 			trace_id, span_id, parent_span_id, span_name, span_kind,
 			start_time, end_time, duration_ns,
-			status_code, status_message, service_name, attributes
-		FROM otel_traces
+			status_code, status_message, service_name, attributes,
+			` + spanFidelityColumns + `,
+			` + spanEventColumns
+	if req.IncludeColdAttributes {
+		query += ", attributes_cold"
+	}
+	query += `
+		FROM ` + s.chClient.SpansTable() + `
 		WHERE 1=1
 	`
 	args := []interface{}{}
@@ -176,13 +283,26 @@ func (s *QueryService) QueryTraces(w http.ResponseWriter, r *http.Request) {
 		query += " AND duration_ns <= ?"
 		args = append(args, req.MaxDuration)
 	}
+	filterClause, filterArgs, err := attributeFilterClause(req.AttributeFilters)
+	if err != nil {
+		buildSpan.End()
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		monitoring.QueryErrors.WithLabelValues("traces").Inc()
+		return
+	}
+	query += filterClause
+	args = append(args, filterArgs...)
 
 	query += fmt.Sprintf(" ORDER BY timestamp DESC LIMIT %d", req.Limit)
+	buildSpan.End()
+
+	if !s.checkQueryCost(w, r, "traces", query, args...) {
+		return
+	}
 
 	rows, err := s.chClient.Query(ctx, query, args...)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		monitoring.QueryErrors.WithLabelValues("traces").Inc()
+		queryFailed(w, "traces", err)
 		return
 	}
 	defer rows.Close()
@@ -191,24 +311,65 @@ func (s *QueryService) QueryTraces(w http.ResponseWriter, r *http.Request) {
 	for rows.Next() {
 		var span Span
 		var attrs map[string]string
-		if err := rows.Scan(
+		var arrays spanEventArrays
+		dest := []interface{}{
 			&span.TraceID, &span.SpanID, &span.ParentSpanID, &span.SpanName, &span.SpanKind,
 			&span.StartTime, &span.EndTime, &span.DurationNs,
 			&span.StatusCode, &span.StatusMessage, &span.ServiceName, &attrs,
-		); err != nil {
-			log.Printf("Error scanning span: %v", err)
+		}
+		var scope scopeColumns
+		dest = append(dest, span.fidelityDest(&scope)...)
+		dest = append(dest, arrays.dest()...)
+		var coldAttrs string
+		if req.IncludeColdAttributes {
+			dest = append(dest, &coldAttrs)
+		}
+		if err := rows.Scan(dest...); err != nil {
+			logger.Error("Error scanning span", "error", err)
 			continue
 		}
+		if attrs, err = mergeColdAttributes(attrs, coldAttrs); err != nil {
+			logger.Error("Error reading cold attributes", "span_id", span.SpanID, "error", err)
+		}
 		span.Attributes = attrs
+		span.restoreExactTimes()
+		span.Scope = scope.scope()
+		if span.Events, err = arrays.events(); err != nil {
+			logger.Error("Error reading span events", "error", err)
+			continue
+		}
+		if span.Links, err = arrays.links(); err != nil {
+			logger.Error("Error reading span links", "error", err)
+			continue
+		}
 		spans = append(spans, span)
 	}
+	if err := rows.Err(); err != nil {
+		queryFailed(w, "traces", err)
+		return
+	}
+
+	// Trace-level fields are best effort; spans are still returned without them
+	summaries, err := s.fetchTraceSummaries(ctx, uniqueTraceIDs(spans))
+	if err != nil {
+		logger.Warn("Error fetching trace summaries", "error", err)
+	} else {
+		attachTraceSummaries(spans, summaries)
+	}
 
 	response := TraceQueryResponse{
 		Spans: spans,
 		Total: len(spans),
 	}
+	setResultRows(w, len(spans))
+
+	if wantsProtobuf(r) {
+		writeProtobuf(w, response.toProto().Marshal())
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Add("Vary", "Accept")
 	json.NewEncoder(w).Encode(response)
 }
 
@@ -230,6 +391,20 @@ func (s *QueryService) QueryMetrics(w http.ResponseWriter, r *http.Request) {
 	if req.Aggregation == "" {
 		req.Aggregation = "avg"
 	}
+	if _, err := parseStep(req.Step); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		monitoring.QueryErrors.WithLabelValues("metrics").Inc()
+		return
+	}
+	if err := validateTimezone(req.Timezone); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		monitoring.QueryErrors.WithLabelValues("metrics").Inc()
+		return
+	}
+
+	if !limitTimeRange(w, r, "metrics", &req.StartTime, &req.EndTime) {
+		return
+	}
 
 	// Determine which table to query based on time range
 	tableName := "otel_metrics"
@@ -240,12 +415,101 @@ func (s *QueryService) QueryMetrics(w http.ResponseWriter, r *http.Request) {
 	}
 
 	ctx := r.Context()
+	_, buildSpan := monitoring.Tracer().Start(ctx, "build metrics query", trace.WithAttributes(attribute.String("db.sql.table", tableName)))
+	query, args := buildMetricsQuery(&req, tableName)
+	buildSpan.End()
+
+	if !s.checkQueryCost(w, r, "metrics", query, args...) {
+		return
+	}
+
+	rows, err := s.chClient.Query(ctx, query, args...)
+	if err != nil {
+		queryFailed(w, "metrics", err)
+		return
+	}
+	defer rows.Close()
+
+	dataPoints := []MetricDataPoint{}
+	seriesIndex := make(map[string]int)
+	series := []MetricSeries{}
+	for rows.Next() {
+		var dp MetricDataPoint
+		labelValues := make([]string, len(req.GroupBy))
+		dest := []interface{}{&dp.Timestamp, &dp.Value}
+		for i := range labelValues {
+			dest = append(dest, &labelValues[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			logger.Error("Error scanning metric", "error", err)
+			continue
+		}
+
+		if len(req.GroupBy) > 0 {
+			dp.Labels = make(map[string]string, len(req.GroupBy))
+			for i, key := range req.GroupBy {
+				dp.Labels[key] = labelValues[i]
+			}
+			seriesKey := strings.Join(labelValues, "\x00")
+			idx, ok := seriesIndex[seriesKey]
+			if !ok {
+				idx = len(series)
+				seriesIndex[seriesKey] = idx
+				series = append(series, MetricSeries{Labels: dp.Labels, DataPoints: []MetricDataPoint{}})
+			}
+			series[idx].DataPoints = append(series[idx].DataPoints, MetricDataPoint{Timestamp: dp.Timestamp, Value: dp.Value})
+		}
+		dataPoints = append(dataPoints, dp)
+	}
+	if err := rows.Err(); err != nil {
+		queryFailed(w, "metrics", err)
+		return
+	}
+
+	response := MetricsQueryResponse{
+		MetricName: req.MetricName,
+		DataPoints: dataPoints,
+		Series:     series,
+	}
+	setResultRows(w, len(dataPoints))
+
+	if wantsProtobuf(r) {
+		writeProtobuf(w, response.toProto().Marshal())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Add("Vary", "Accept")
+	json.NewEncoder(w).Encode(response)
+}
+
+// metricColumns maps group-by/filter keys that are stored as dedicated
+// columns in every metrics table. Any other key is looked up in attributes.
+var metricColumns = map[string]string{
+	"service_name": "service_name",
+	"metric_type":  "metric_type",
+}
+
+// metricLabelExpr returns the SQL expression for a label key along with the
+// argument it needs, if any. Attribute keys are bound as parameters so that
+// arbitrary user input never ends up in the SQL text.
+func metricLabelExpr(key string) (string, []interface{}) {
+	if col, ok := metricColumns[key]; ok {
+		return col, nil
+	}
+	return "attributes[?]", []interface{}{key}
+}
+
+// buildMetricsQuery builds the aggregation query for a metrics request against
+// the given table. Group-by labels are returned as extra columns after ts and value.
+func buildMetricsQuery(req *MetricsQueryRequest, tableName string) (string, []interface{}) {
 	aggFunc := req.Aggregation
 	if tableName != "otel_metrics" {
-		// Use pre-aggregated columns
+		// Rollup rows are partial aggregates until merged, so combine them
+		// rather than averaging averages
 		switch req.Aggregation {
 		case "avg":
-			aggFunc = "avg(value_avg)"
+			aggFunc = "sum(value_sum) / sum(value_count)"
 		case "min":
 			aggFunc = "min(value_min)"
 		case "max":
@@ -257,50 +521,57 @@ func (s *QueryService) QueryMetrics(w http.ResponseWriter, r *http.Request) {
 		aggFunc = fmt.Sprintf("%s(value)", req.Aggregation)
 	}
 
+	// The step is validated by the handler
+	step, err := parseStep(req.Step)
+	if err != nil {
+		step = defaultStep
+	}
+	bucketExpr, selectArgs := timeBucket(step, req.Timezone)
+	groupCols := []string{"ts"}
+	labelSelect := ""
+	for i, key := range req.GroupBy {
+		expr, exprArgs := metricLabelExpr(key)
+		alias := fmt.Sprintf("label_%d", i)
+		labelSelect += fmt.Sprintf(",\n\t\t\ttoString(%s) as %s", expr, alias)
+		selectArgs = append(selectArgs, exprArgs...)
+		groupCols = append(groupCols, alias)
+	}
+
 	query := fmt.Sprintf(`
 		SELECT
-			toStartOfInterval(timestamp, INTERVAL 5 MINUTE) as ts,
-			%s as value
+			%s as ts,
+			%s as value%s
 		FROM %s
 		WHERE metric_name = ?
 		  AND timestamp >= ?
 		  AND timestamp <= ?
-	`, aggFunc, tableName)
+	`, bucketExpr, aggFunc, labelSelect, tableName)
 
-	args := []interface{}{req.MetricName, req.StartTime, req.EndTime}
+	args := append(selectArgs, req.MetricName, req.StartTime, req.EndTime)
 
 	if req.ServiceName != "" {
 		query += " AND service_name = ?"
 		args = append(args, req.ServiceName)
 	}
 
-	query += " GROUP BY ts ORDER BY ts"
-
-	rows, err := s.chClient.Query(ctx, query, args...)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		monitoring.QueryErrors.WithLabelValues("metrics").Inc()
-		return
+	// Sort filter keys so the generated SQL is stable across requests
+	filterKeys := make([]string, 0, len(req.Filters))
+	for key := range req.Filters {
+		filterKeys = append(filterKeys, key)
 	}
-	defer rows.Close()
-
-	dataPoints := []MetricDataPoint{}
-	for rows.Next() {
-		var dp MetricDataPoint
-		if err := rows.Scan(&dp.Timestamp, &dp.Value); err != nil {
-			log.Printf("Error scanning metric: %v", err)
-			continue
-		}
-		dataPoints = append(dataPoints, dp)
+	sort.Strings(filterKeys)
+	for _, key := range filterKeys {
+		expr, exprArgs := metricLabelExpr(key)
+		query += fmt.Sprintf(" AND %s = ?", expr)
+		args = append(args, exprArgs...)
+		args = append(args, req.Filters[key])
 	}
 
-	response := MetricsQueryResponse{
-		MetricName: req.MetricName,
-		DataPoints: dataPoints,
-	}
+	// Order by series first so each series' points come back contiguous
+	orderCols := append(append([]string{}, groupCols[1:]...), "ts")
+	query += fmt.Sprintf(" GROUP BY %s ORDER BY %s", strings.Join(groupCols, ", "), strings.Join(orderCols, ", "))
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	return query, args
 }
 
 // QueryLogs handles log queries (Loki-compatible)
@@ -321,11 +592,24 @@ func (s *QueryService) QueryLogs(w http.ResponseWriter, r *http.Request) {
 		req.Limit = 100
 	}
 
+	severityClause, severityArgs, err := severityFilter(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		monitoring.QueryErrors.WithLabelValues("logs").Inc()
+		return
+	}
+	if !limitTimeRange(w, r, "logs", &req.StartTime, &req.EndTime) {
+		return
+	}
+	s.markStorageTier(w, "logs", req.StartTime)
+
 	ctx := r.Context()
+	_, buildSpan := monitoring.Tracer().Start(ctx, "build logs query")
 	query := `
 		SELECT
-			timestamp, severity_text, body, service_name,
-			trace_id, span_id, attributes
+			timestamp, severity_number, severity_text, body, service_name,
+			trace_id, span_id, attributes,
+			dropped_attributes_count, ` + scopeColumnList + `
 		FROM otel_logs
 		WHERE timestamp >= ?
 		  AND timestamp <= ?
@@ -336,10 +620,8 @@ func (s *QueryService) QueryLogs(w http.ResponseWriter, r *http.Request) {
 		query += " AND service_name = ?"
 		args = append(args, req.ServiceName)
 	}
-	if req.Severity != "" {
-		query += " AND severity_text = ?"
-		args = append(args, req.Severity)
-	}
+	query += severityClause
+	args = append(args, severityArgs...)
 	if req.TraceID != "" {
 		query += " AND trace_id = ?"
 		args = append(args, req.TraceID)
@@ -348,13 +630,26 @@ func (s *QueryService) QueryLogs(w http.ResponseWriter, r *http.Request) {
 		query += " AND body LIKE ?"
 		args = append(args, "%"+req.SearchText+"%")
 	}
+	filterClause, filterArgs, err := attributeFilterClause(req.AttributeFilters)
+	if err != nil {
+		buildSpan.End()
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		monitoring.QueryErrors.WithLabelValues("logs").Inc()
+		return
+	}
+	query += filterClause
+	args = append(args, filterArgs...)
 
 	query += fmt.Sprintf(" ORDER BY timestamp DESC LIMIT %d", req.Limit)
+	buildSpan.End()
+
+	if !s.checkQueryCost(w, r, "logs", query, args...) {
+		return
+	}
 
 	rows, err := s.chClient.Query(ctx, query, args...)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		monitoring.QueryErrors.WithLabelValues("logs").Inc()
+		queryFailed(w, "logs", err)
 		return
 	}
 	defer rows.Close()
@@ -363,26 +658,43 @@ func (s *QueryService) QueryLogs(w http.ResponseWriter, r *http.Request) {
 	for rows.Next() {
 		var logRec LogRecord
 		var attrs map[string]string
+		var scope scopeColumns
 		if err := rows.Scan(
-			&logRec.Timestamp, &logRec.SeverityText, &logRec.Body, &logRec.ServiceName,
+			&logRec.Timestamp, &logRec.SeverityNumber, &logRec.SeverityText, &logRec.Body, &logRec.ServiceName,
 			&logRec.TraceID, &logRec.SpanID, &attrs,
+			&logRec.DroppedAttributesCount, &scope.name, &scope.version, &scope.attributes,
 		); err != nil {
-			log.Printf("Error scanning log: %v", err)
+			logger.Error("Error scanning log", "error", err)
 			continue
 		}
 		logRec.Attributes = attrs
+		logRec.Scope = scope.scope()
 		logs = append(logs, logRec)
 	}
+	if err := rows.Err(); err != nil {
+		queryFailed(w, "logs", err)
+		return
+	}
 
 	response := LogsQueryResponse{
 		Logs:  logs,
 		Total: len(logs),
 	}
+	setResultRows(w, len(logs))
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
+// ServiceStat summarizes a service's spans over the last hour
+type ServiceStat struct {
+	ServiceName string  `json:"service_name"`
+	SpanCount   uint64  `json:"span_count"`
+	AvgDuration float64 `json:"avg_duration_ns"`
+	P95Duration float64 `json:"p95_duration_ns"`
+	ErrorCount  uint64  `json:"error_count"`
+}
+
 // GetServiceStats returns service statistics
 func (s *QueryService) GetServiceStats(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
@@ -394,7 +706,7 @@ func (s *QueryService) GetServiceStats(w http.ResponseWriter, r *http.Request) {
 			avg(duration_ns) as avg_duration,
 			quantile(0.95)(duration_ns) as p95_duration,
 			countIf(status_code = 'error') as error_count
-		FROM otel_traces
+		FROM ` + s.chClient.SpansTable() + `
 		WHERE timestamp >= now() - INTERVAL 1 HOUR
 		GROUP BY service_name
 		ORDER BY span_count DESC
@@ -407,100 +719,222 @@ func (s *QueryService) GetServiceStats(w http.ResponseWriter, r *http.Request) {
 	}
 	defer rows.Close()
 
-	type ServiceStat struct {
-		ServiceName  string  `json:"service_name"`
-		SpanCount    uint64  `json:"span_count"`
-		AvgDuration  float64 `json:"avg_duration_ns"`
-		P95Duration  float64 `json:"p95_duration_ns"`
-		ErrorCount   uint64  `json:"error_count"`
-	}
-
 	stats := []ServiceStat{}
 	for rows.Next() {
 		var stat ServiceStat
 		if err := rows.Scan(&stat.ServiceName, &stat.SpanCount, &stat.AvgDuration, &stat.P95Duration, &stat.ErrorCount); err != nil {
-			log.Printf("Error scanning stat: %v", err)
+			logger.Error("Error scanning stat", "error", err)
 			continue
 		}
 		stats = append(stats, stat)
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(stats)
+	writeJSONWithETag(w, r, stats)
+}
+
+// nameSpan names the request's span after the route that matched
+func nameSpan(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if route := mux.CurrentRoute(r); route != nil {
+			if template, err := route.GetPathTemplate(); err == nil {
+				monitoring.SetRoute(r, template)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// newRouter registers the public API routes
+func newRouter(s *QueryService) *mux.Router {
+	router := mux.NewRouter()
+	router.Use(nameSpan, s.auditRequests, s.authenticate, s.interactiveProfile, validateRequests)
+	// Routes that query ClickHouse hold a query gate slot; cached ones only
+	// on a cache miss
+	query := func(route string, handler http.HandlerFunc) *mux.Route {
+		return router.HandleFunc(route, s.gate.wrap(route, handler))
+	}
+	cachedQuery := func(route string, handler http.HandlerFunc) *mux.Route {
+		return router.HandleFunc(route, s.results.wrap(route, s.gate.wrap(route, handler)))
+	}
+	cachedQuery("/api/v1/traces", s.QueryTraces).Methods("POST")
+	cachedQuery("/api/v1/traces", getQuery("/api/v1/traces", traceQueryParams, s.QueryTraces)).Methods("GET")
+	query("/api/v1/traces/otlp", s.ExportTracesOTLP).Methods("POST")
+	query("/api/v1/traces/latency-histogram", s.GetLatencyHistogram).Methods("GET")
+	query("/api/v1/traces/{trace_id}/otlp", s.ExportTraceOTLP).Methods("GET")
+	query("/api/v1/traces/{trace_id}/critical-path", s.GetCriticalPath).Methods("GET")
+	cachedQuery("/api/v1/metrics", s.QueryMetrics).Methods("POST")
+	cachedQuery("/api/v1/metrics", getQuery("/api/v1/metrics", metricsQueryParams, s.QueryMetrics)).Methods("GET")
+	query("/api/v1/metrics/names", s.ListMetricNames).Methods("GET")
+	query("/api/v1/metrics/{name}/labels", s.ListMetricLabels).Methods("GET")
+	cachedQuery("/api/v1/logs", s.QueryLogs).Methods("POST")
+	cachedQuery("/api/v1/logs", getQuery("/api/v1/logs", logsQueryParams, s.QueryLogs)).Methods("GET")
+	query("/api/v1/logs/patterns", s.GetLogPatterns).Methods("GET")
+	cachedQuery("/api/v1/services/stats", s.GetServiceStats).Methods("GET")
+	query("/api/v1/services", s.ListServices).Methods("GET")
+	query("/api/v1/services/{service}/operations", s.ListServiceOperations).Methods("GET")
+	query("/api/v1/services/{service}/compare", s.CompareService).Methods("POST")
+	query("/api/v1/errors", s.GetErrorGroups).Methods("GET")
+	query("/api/v1/usage", s.GetUsage).Methods("GET")
+	query("/api/v1/usage/quotas", s.GetQuotas).Methods("GET")
+	router.HandleFunc("/api/v1/anomalies", s.GetAnomalies).Methods("GET")
+	router.HandleFunc("/api/v1/slo", s.ListSLOs).Methods("GET")
+	router.HandleFunc("/api/v1/slo/{name}", s.GetSLO).Methods("GET")
+	router.HandleFunc("/api/grafana/", s.GrafanaTest).Methods("GET")
+	query("/api/grafana/search", s.GrafanaSearch).Methods("POST")
+	query("/api/grafana/query", s.GrafanaQuery).Methods("POST")
+	query("/api/grafana/annotations", s.GrafanaAnnotations).Methods("POST")
+	router.HandleFunc("/api/v1/jobs", s.SubmitJob).Methods("POST")
+	router.HandleFunc("/api/v1/jobs/{id}", s.GetJob).Methods("GET")
+	router.HandleFunc("/api/v1/jobs/{id}", s.CancelJob).Methods("DELETE")
+	router.HandleFunc("/api/v1/jobs/{id}/result", s.GetJobResult).Methods("GET")
+	router.HandleFunc("/api/v1/export", s.SubmitExport).Methods("POST")
+	query("/api/v1/admin/watermarks", s.GetWatermarks).Methods("GET")
+	query("/api/v1/admin/timestamps", s.GetTimestamps).Methods("GET")
+	router.HandleFunc("/api/v1/admin/retention", s.GetRetention).Methods("GET")
+	router.HandleFunc("/api/v1/admin/retention/apply", s.ApplyRetention).Methods("POST")
+	router.HandleFunc("/api/v1/admin/retention/purge", s.PurgeRetention).Methods("POST")
+	router.HandleFunc("/api/v1/admin/deletions", s.SubmitDeletion).Methods("POST")
+	router.HandleFunc("/api/v1/admin/deletions", s.ListDeletions).Methods("GET")
+	router.HandleFunc("/api/v1/admin/deletions/{id}", s.GetDeletion).Methods("GET")
+	router.HandleFunc("/api/v1/admin/config", s.reloader.HandleConfig).Methods("GET")
+	router.HandleFunc("/api/v1/admin/config/reload", s.reloader.HandleReload).Methods("POST")
+	query("/api/v1/admin/audit", s.GetAudit).Methods("GET")
+	router.HandleFunc("/api/openapi.json", s.GetOpenAPI).Methods("GET")
+	router.HandleFunc(s.config.Monitoring.HealthCheckPath, s.healthCheck.LivenessHandler).Methods("GET")
+	router.HandleFunc(s.config.Monitoring.ReadyCheckPath, s.healthCheck.ReadinessHandler).Methods("GET")
+	return router
 }
 
 func main() {
+	validateConfig := flag.Bool("validate-config", false, "check the config file, print it with its defaults filled in and exit, with status 1 if it is invalid")
+	dial := flag.Bool("dial", false, "with -validate-config, also check that every ClickHouse address accepts connections")
+	flag.Parse()
+
 	// Load configuration
 	configPath := os.Getenv("CONFIG_PATH")
 	if configPath == "" {
 		configPath = "configs/query.yaml"
 	}
+	if *validateConfig {
+		result := config.CheckConfig(context.Background(), configPath, config.CheckOptions{Service: "query", Dial: *dial})
+		if err := result.Write(os.Stdout, os.Stderr); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
 
 	cfg, err := config.LoadConfig(configPath)
 	if err != nil {
 		log.Fatalf("Failed to load config: %v", err)
 	}
+	if err := logging.Setup(cfg.Monitoring.LogLevel, cfg.Monitoring.LogFormat); err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
+	}
 
 	// Initialize monitoring
-	shutdown, err := monitoring.InitTracing(serviceName, serviceVersion, cfg.Monitoring.TraceSampleRate)
+	shutdown, err := monitoring.InitTracing(serviceName, serviceVersion, cfg.Monitoring)
 	if err != nil {
-		log.Fatalf("Failed to initialize tracing: %v", err)
+		logging.Fatal(logger, "Failed to initialize tracing", "error", err)
 	}
 	defer shutdown(context.Background())
 
 	// Start metrics server
-	metricsServer := monitoring.StartMetricsServer(cfg.Monitoring.MetricsPort, cfg.Monitoring.MetricsPath)
+	metricsServer := monitoring.StartMetricsServer(cfg.Monitoring.MetricsPort, cfg.Monitoring.MetricsPath, monitoring.InstanceID(cfg.Monitoring))
 	defer metricsServer.Shutdown(context.Background())
 
 	// Connect to ClickHouse
 	chClient, err := clickhouse.NewClient(&cfg.ClickHouse)
 	if err != nil {
-		log.Fatalf("Failed to connect to ClickHouse: %v", err)
+		logging.Fatal(logger, "Failed to connect to ClickHouse", "error", err)
 	}
 	defer chClient.Close()
 
 	// Create query service
 	queryService := NewQueryService(cfg, chClient)
+	if queryService.auth, err = newAuthPolicy(cfg.Auth); err != nil {
+		logging.Fatal(logger, "Failed to set up authentication", "error", err)
+	}
+	chClient.SetHealthCheck(queryService.healthCheck)
+	queryService.healthCheck.AddDependency(monitoring.Dependency{Name: "clickhouse", Critical: true, Check: chClient.Ping})
+	queryService.warmCaches(context.Background())
+
+	// Apply retention TTLs, then start the partition purge, anomaly detection and SLO evaluation
+	if cfg.Retention.ApplyOnStartup {
+		if _, err := queryService.retention.apply(context.Background()); err != nil {
+			logger.Error("Failed to apply retention", "error", err)
+		}
+	}
+	backgroundCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+	go queryService.retention.run(backgroundCtx)
+	go queryService.anomalies.run(backgroundCtx)
+	go queryService.slos.run(backgroundCtx)
+	go queryService.audit.run(backgroundCtx)
+	go queryService.reloader.WatchSignals(backgroundCtx)
+
 	queryService.healthCheck.SetReady(true)
 
 	// Setup HTTP router
-	router := mux.NewRouter()
-	router.HandleFunc("/api/v1/traces", queryService.QueryTraces).Methods("POST")
-	router.HandleFunc("/api/v1/metrics", queryService.QueryMetrics).Methods("POST")
-	router.HandleFunc("/api/v1/logs", queryService.QueryLogs).Methods("POST")
-	router.HandleFunc("/api/v1/services/stats", queryService.GetServiceStats).Methods("GET")
-	router.HandleFunc(cfg.Monitoring.HealthCheckPath, queryService.healthCheck.LivenessHandler).Methods("GET")
-	router.HandleFunc(cfg.Monitoring.ReadyCheckPath, queryService.healthCheck.ReadinessHandler).Methods("GET")
+	router := newRouter(queryService)
 
 	// Start HTTP server
-	srv := &http.Server{
-		Addr:         fmt.Sprintf(":%d", cfg.Server.Port),
-		Handler:      router,
-		ReadTimeout:  cfg.Server.ReadTimeout,
-		WriteTimeout: cfg.Server.WriteTimeout,
+	srv, err := newHTTPServer(cfg.Server, monitoring.HTTPTracing(monitoring.RequestLogger(corsHandler(cfg.Server.CORS, router))))
+	if err != nil {
+		logging.Fatal(logger, "Failed to set up the HTTP server", "error", err)
 	}
 
 	go func() {
-		log.Printf("Query API server started on port %d", cfg.Server.Port)
+		logger.Info("Query API server started", "port", cfg.Server.Port, "h2c", cfg.Server.H2C)
 		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Server error: %v", err)
+			logging.Fatal(logger, "Server error", "error", err)
 		}
 	}()
 
+	// Start the gRPC query API if enabled
+	var grpcServer *grpc.Server
+	if cfg.Server.GRPCPort > 0 {
+		lis, err := net.Listen("tcp", fmt.Sprintf(":%d", cfg.Server.GRPCPort))
+		if err != nil {
+			logging.Fatal(logger, "Failed to listen", "error", err)
+		}
+		grpcServer = newGRPCServer(queryService)
+		go func() {
+			logger.Info("gRPC query API started", "port", cfg.Server.GRPCPort)
+			if err := grpcServer.Serve(lis); err != nil {
+				logging.Fatal(logger, "gRPC server error", "error", err)
+			}
+		}()
+	}
+
 	// Wait for interrupt signal
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 	<-sigCh
 
-	log.Println("Shutting down gracefully...")
-	queryService.healthCheck.SetReady(false)
+	logger.Info("Shutting down gracefully")
+	queryService.healthCheck.SetState(monitoring.StateDraining, "shutting down")
 
 	ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
 	defer cancel()
 
-	if err := srv.Shutdown(ctx); err != nil {
-		log.Printf("Server shutdown error: %v", err)
+	if err := srv.drain(ctx); err != nil {
+		logger.Error("Server shutdown error", "error", err)
+	}
+	if grpcServer != nil {
+		stopped := make(chan struct{})
+		go func() {
+			grpcServer.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			grpcServer.Stop()
+		}
 	}
+	// Write the audit records of the requests served while draining
+	queryService.audit.flush(ctx)
 
-	log.Println("Shutdown complete")
+	logger.Info("Shutdown complete")
 }
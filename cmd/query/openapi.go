@@ -0,0 +1,475 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"otelservices/internal/config"
+)
+
+// OpenAPI 3 document served at /api/openapi.json. Schemas are generated from
+// the request and response structs, so the document follows the code; the
+// constraints in validate tags become required, minimum, maximum and enum.
+
+type OpenAPIDocument struct {
+	OpenAPI    string                                  `json:"openapi"`
+	Info       OpenAPIInfo                             `json:"info"`
+	Paths      map[string]map[string]*OpenAPIOperation `json:"paths"`
+	Components OpenAPIComponents                       `json:"components"`
+}
+
+type OpenAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type OpenAPIOperation struct {
+	Summary     string                     `json:"summary"`
+	OperationID string                     `json:"operationId"`
+	Parameters  []OpenAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *OpenAPIRequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]OpenAPIResponse `json:"responses"`
+}
+
+type OpenAPIParameter struct {
+	Name        string         `json:"name"`
+	In          string         `json:"in"` // path or query
+	Description string         `json:"description,omitempty"`
+	Required    bool           `json:"required,omitempty"`
+	Schema      *OpenAPISchema `json:"schema"`
+}
+
+type OpenAPIRequestBody struct {
+	Required bool                        `json:"required"`
+	Content  map[string]OpenAPIMediaType `json:"content"`
+}
+
+type OpenAPIMediaType struct {
+	Schema *OpenAPISchema `json:"schema"`
+}
+
+type OpenAPIResponse struct {
+	Description string                      `json:"description"`
+	Content     map[string]OpenAPIMediaType `json:"content,omitempty"`
+}
+
+type OpenAPIComponents struct {
+	Schemas map[string]*OpenAPISchema `json:"schemas"`
+}
+
+type OpenAPISchema struct {
+	Ref                  string                    `json:"$ref,omitempty"`
+	Type                 string                    `json:"type,omitempty"`
+	Format               string                    `json:"format,omitempty"`
+	Description          string                    `json:"description,omitempty"`
+	Properties           map[string]*OpenAPISchema `json:"properties,omitempty"`
+	Required             []string                  `json:"required,omitempty"`
+	Items                *OpenAPISchema            `json:"items,omitempty"`
+	AdditionalProperties *OpenAPISchema            `json:"additionalProperties,omitempty"`
+	Enum                 []string                  `json:"enum,omitempty"`
+	Minimum              *float64                  `json:"minimum,omitempty"`
+	Maximum              *float64                  `json:"maximum,omitempty"`
+}
+
+// apiParam is a query parameter of an operation; path parameters are taken
+// from the route
+type apiParam struct {
+	name        string
+	kind        string // string, integer or date-time
+	description string
+}
+
+// apiOperation describes one public endpoint. Nil constructors mean the
+// endpoint has no JSON body. status is the success status, 200 when unset.
+// limited endpoints may be rejected by the query limits and budget.
+type apiOperation struct {
+	method   string
+	route    string
+	summary  string
+	request  func() interface{}
+	response func() interface{}
+	status   int
+	params   []apiParam
+	limited  bool
+}
+
+var timeRangeParams = []apiParam{
+	{"start", "date-time", "RFC 3339 start of the range, or relative like now-1h"},
+	{"end", "date-time", "RFC 3339 end of the range, or relative like now; defaults to now"},
+}
+
+// searchTimeParams are the time range of the GET variants of the search
+// endpoints, which have the defaults of their POST bodies
+var searchTimeParams = []apiParam{
+	{"start", "date-time", "RFC 3339 start of the range, or relative like now-1h"},
+	{"end", "date-time", "RFC 3339 end of the range, or relative like now"},
+}
+
+// apiOperations lists the public endpoints registered by newRouter
+var apiOperations = []apiOperation{
+	{method: "POST", route: "/api/v1/traces", summary: "Search spans",
+		request:  func() interface{} { return &TraceQueryRequest{} },
+		response: func() interface{} { return &TraceQueryResponse{} },
+		limited:  true},
+	{method: "GET", route: "/api/v1/traces", summary: "Search spans with URL parameters",
+		response: func() interface{} { return &TraceQueryResponse{} },
+		params: append([]apiParam{
+			{"trace_id", "string", "only spans of the trace"},
+			{"service", "string", "only spans of the service"},
+			{"min_duration", "string", "nanoseconds or a duration such as 250ms"},
+			{"max_duration", "string", "nanoseconds or a duration such as 2s"},
+			{"limit", "integer", "spans returned, up to 10000; defaults to 100"},
+			{"include_cold_attributes", "string", "true to also read tiered attributes"},
+		}, searchTimeParams...),
+		limited: true},
+	{method: "POST", route: "/api/v1/traces/otlp", summary: "Export traces as an OTLP ExportTraceServiceRequest",
+		request:  func() interface{} { return &OTLPExportRequest{} },
+		response: func() interface{} { return &map[string]interface{}{} }},
+	{method: "GET", route: "/api/v1/traces/latency-histogram", summary: "Span duration histogram per time bucket, for heatmaps",
+		response: func() interface{} { return &LatencyHistogramResponse{} },
+		params: append([]apiParam{
+			{"service", "string", "service of the spans, required"},
+			{"operation", "string", "only spans with this span name"},
+			{"step", "string", "width of the time buckets, e.g. 1m or 1h; defaults to 5m"},
+			{"precision", "integer", "duration buckets per doubling, 1 to 16; defaults to 4"},
+		}, timeRangeParams...),
+		limited: true},
+	{method: "GET", route: "/api/v1/traces/{trace_id}/otlp", summary: "Export a trace as an OTLP ExportTraceServiceRequest",
+		response: func() interface{} { return &map[string]interface{}{} }},
+	{method: "GET", route: "/api/v1/traces/{trace_id}/critical-path", summary: "Critical path of a trace and each service's share of it",
+		response: func() interface{} { return &CriticalPathResponse{} }},
+	{method: "POST", route: "/api/v1/metrics", summary: "Query a metric as time series",
+		request:  func() interface{} { return &MetricsQueryRequest{} },
+		response: func() interface{} { return &MetricsQueryResponse{} },
+		limited:  true},
+	{method: "GET", route: "/api/v1/metrics", summary: "Query a metric as time series with URL parameters",
+		response: func() interface{} { return &MetricsQueryResponse{} },
+		params: append([]apiParam{
+			{"metric", "string", "metric name, required"},
+			{"service", "string", "only points of the service"},
+			{"aggregation", "string", "avg, min, max or sum"},
+			{"group_by", "string", "comma-separated labels to split series by"},
+			{"filter", "string", "label filter as key:value, repeatable"},
+			{"step", "string", "width of the time buckets, e.g. 1m or 1h; defaults to 5m"},
+			{"timezone", "string", "IANA time zone bucket boundaries align to"},
+		}, searchTimeParams...),
+		limited: true},
+	{method: "GET", route: "/api/v1/metrics/names", summary: "List metric names",
+		response: func() interface{} { return &MetricNamesResponse{} },
+		params:   timeRangeParams},
+	{method: "GET", route: "/api/v1/metrics/{name}/labels", summary: "List the labels of a metric and their values",
+		response: func() interface{} { return &MetricLabelsResponse{} },
+		params:   append([]apiParam{{"limit", "integer", "values returned per label"}}, timeRangeParams...)},
+	{method: "POST", route: "/api/v1/logs", summary: "Search logs",
+		request:  func() interface{} { return &LogsQueryRequest{} },
+		response: func() interface{} { return &LogsQueryResponse{} },
+		limited:  true},
+	{method: "GET", route: "/api/v1/logs", summary: "Search logs with URL parameters",
+		response: func() interface{} { return &LogsQueryResponse{} },
+		params: append([]apiParam{
+			{"service", "string", "only logs of the service"},
+			{"severity", "string", "a level, e.g. WARN, or a level and above, e.g. WARN+"},
+			{"severity_min", "integer", "lowest severity number"},
+			{"severity_max", "integer", "highest severity number"},
+			{"search", "string", "text the body contains"},
+			{"trace_id", "string", "only logs of the trace"},
+			{"filter", "string", "attribute filter as key:value, repeatable"},
+			{"limit", "integer", "records returned, up to 10000; defaults to 100"},
+		}, searchTimeParams...),
+		limited: true},
+	{method: "GET", route: "/api/v1/logs/patterns", summary: "Log bodies clustered into patterns, most frequent first, with their trend",
+		response: func() interface{} { return &LogPatternsResponse{} },
+		params: append([]apiParam{
+			{"service", "string", "only logs of the service"},
+			{"severity", "string", "a level, e.g. WARN, or a level and above, e.g. WARN+"},
+			{"limit", "integer", "patterns returned, up to 500; defaults to 50"},
+		}, timeRangeParams...),
+		limited: true},
+	{method: "GET", route: "/api/v1/services/stats", summary: "Span statistics per service for the last hour",
+		response: func() interface{} { return &[]ServiceStat{} }},
+	{method: "GET", route: "/api/v1/services", summary: "List services",
+		response: func() interface{} { return &ServicesResponse{} },
+		params:   timeRangeParams},
+	{method: "GET", route: "/api/v1/services/{service}/operations", summary: "List the operations of a service",
+		response: func() interface{} { return &OperationsResponse{} },
+		params:   timeRangeParams},
+	{method: "POST", route: "/api/v1/services/{service}/compare", summary: "Compare a service's operations between a baseline and a comparison window",
+		request:  func() interface{} { return &ServiceCompareRequest{} },
+		response: func() interface{} { return &ServiceCompareResponse{} },
+		limited:  true},
+	{method: "GET", route: "/api/v1/errors", summary: "Error spans and logs grouped by service, operation, exception type and message",
+		response: func() interface{} { return &ErrorGroupsResponse{} },
+		params: append([]apiParam{
+			{"service", "string", "only errors of the service"},
+			{"source", "string", "spans or logs; both when unset"},
+			{"limit", "integer", "groups returned, up to 1000; defaults to 100"},
+		}, timeRangeParams...),
+		limited: true},
+	{method: "GET", route: "/api/v1/anomalies", summary: "Current latency and error rate anomalies",
+		response: func() interface{} { return &AnomaliesResponse{} },
+		params:   []apiParam{{"service", "string", "only anomalies of the service"}}},
+	{method: "GET", route: "/api/v1/slo", summary: "Service level objectives with their error budgets and burn rates",
+		response: func() interface{} { return &SLOsResponse{} },
+		params:   []apiParam{{"service", "string", "only objectives of the service"}}},
+	{method: "GET", route: "/api/v1/slo/{name}", summary: "A service level objective with its error budget and burn rates",
+		response: func() interface{} { return &SLOStatus{} }},
+	{method: "GET", route: "/api/v1/usage", summary: "Bytes and items ingested per day, service and signal",
+		response: func() interface{} { return &UsageResponse{} },
+		params: append([]apiParam{
+			{"service", "string", "only the usage of the service"},
+			{"namespace", "string", "only the usage of services in the namespace"},
+		}, timeRangeParams...)},
+	{method: "GET", route: "/api/v1/usage/quotas", summary: "Ingest quotas with the usage of their current period",
+		response: func() interface{} { return &QuotasResponse{} }},
+	{method: "GET", route: "/api/grafana/", summary: "Grafana datasource connection test"},
+	{method: "POST", route: "/api/grafana/search", summary: "Grafana metric name search",
+		request:  func() interface{} { return &GrafanaSearchRequest{} },
+		response: func() interface{} { return &[]string{} }},
+	{method: "POST", route: "/api/grafana/query", summary: "Grafana panel query",
+		request:  func() interface{} { return &GrafanaQueryRequest{} },
+		response: func() interface{} { return &[]GrafanaQueryResult{} }},
+	{method: "POST", route: "/api/grafana/annotations", summary: "Grafana annotations from error logs",
+		request:  func() interface{} { return &GrafanaAnnotationRequest{} },
+		response: func() interface{} { return &[]GrafanaAnnotation{} }},
+	{method: "POST", route: "/api/v1/jobs", summary: "Submit an async query job", status: http.StatusAccepted,
+		request:  func() interface{} { return &JobSubmitRequest{} },
+		response: func() interface{} { return &JobInfo{} }},
+	{method: "GET", route: "/api/v1/jobs/{id}", summary: "Get the status of a job",
+		response: func() interface{} { return &JobInfo{} }},
+	{method: "DELETE", route: "/api/v1/jobs/{id}", summary: "Cancel a job", status: http.StatusNoContent},
+	{method: "GET", route: "/api/v1/jobs/{id}/result", summary: "Get the result of a finished job, in the shape of the synchronous response"},
+	{method: "POST", route: "/api/v1/export", summary: "Export a query result as a Parquet or CSV file; poll the job for its location", status: http.StatusAccepted,
+		request:  func() interface{} { return &ExportRequest{} },
+		response: func() interface{} { return &JobInfo{} }},
+	{method: "GET", route: "/api/v1/admin/watermarks", summary: "Latest ingested timestamp per signal",
+		response: func() interface{} { return &WatermarksResponse{} },
+		params: []apiParam{
+			{"service", "string", "only the watermarks of the service"},
+			{"lateness", "string", "delay allowed before a signal counts as late, as a Go duration"},
+		}},
+	{method: "GET", route: "/api/v1/admin/timestamps", summary: "Precision of the time columns, flagging those that truncate nanoseconds",
+		response: func() interface{} { return &TimestampsResponse{} }},
+	{method: "GET", route: "/api/v1/admin/retention", summary: "Configured and applied retention",
+		response: func() interface{} { return &RetentionResponse{} }},
+	{method: "POST", route: "/api/v1/admin/retention/apply", summary: "Apply the configured retention TTLs",
+		response: func() interface{} { return &RetentionResponse{} }},
+	{method: "POST", route: "/api/v1/admin/retention/purge", summary: "Drop partitions past retention",
+		response: func() interface{} { return &PurgeResponse{} }},
+	{method: "POST", route: "/api/v1/admin/deletions", summary: "Delete a service's telemetry", status: http.StatusAccepted,
+		request:  func() interface{} { return &DeletionRequest{} },
+		response: func() interface{} { return &DeletionInfo{} }},
+	{method: "GET", route: "/api/v1/admin/deletions", summary: "List deletions",
+		response: func() interface{} { return &DeletionsResponse{} }},
+	{method: "GET", route: "/api/v1/admin/deletions/{id}", summary: "Get the progress of a deletion",
+		response: func() interface{} { return &DeletionInfo{} }},
+	{method: "GET", route: "/api/v1/admin/config", summary: "Effective config, with passwords and header values redacted",
+		response: func() interface{} { return &map[string]interface{}{} }},
+	{method: "POST", route: "/api/v1/admin/config/reload", summary: "Reload the config file and apply its dynamic settings",
+		response: func() interface{} { return &config.ReloadResult{} }},
+	{method: "GET", route: "/api/v1/admin/audit", summary: "Audited API requests, newest first",
+		response: func() interface{} { return &AuditResponse{} },
+		params: append([]apiParam{
+			{"principal", "string", "only the requests of the API key or token subject"},
+			{"route", "string", "only the requests to the route, as a path template such as /api/v1/logs"},
+			{"method", "string", "only the requests with the HTTP method, or GRPC"},
+			{"status", "integer", "only the requests answered with the status"},
+			{"limit", "integer", "maximum records, up to 1000; defaults to 100"},
+		}, timeRangeParams...)},
+	{method: "GET", route: "/api/openapi.json", summary: "This document",
+		response: func() interface{} { return &OpenAPIDocument{} }},
+}
+
+// findOperation returns the operation registered for a method and route
+// template, or nil
+func findOperation(method, route string) *apiOperation {
+	for i := range apiOperations {
+		if apiOperations[i].method == method && apiOperations[i].route == route {
+			return &apiOperations[i]
+		}
+	}
+	return nil
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+// schemaBuilder collects the named struct schemas referenced by operations
+type schemaBuilder struct {
+	schemas map[string]*OpenAPISchema
+}
+
+func (b *schemaBuilder) schema(t reflect.Type) *OpenAPISchema {
+	switch t {
+	case timeType:
+		return &OpenAPISchema{Type: "string", Format: "date-time"}
+	case rawMessageType:
+		return &OpenAPISchema{Description: "any JSON value"}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return b.schema(t.Elem())
+	case reflect.String:
+		return &OpenAPISchema{Type: "string"}
+	case reflect.Bool:
+		return &OpenAPISchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32:
+		return &OpenAPISchema{Type: "integer", Format: "int32"}
+	case reflect.Int64:
+		return &OpenAPISchema{Type: "integer", Format: "int64"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		zero := 0.0
+		return &OpenAPISchema{Type: "integer", Format: "int64", Minimum: &zero}
+	case reflect.Float32, reflect.Float64:
+		return &OpenAPISchema{Type: "number", Format: "double"}
+	case reflect.Slice, reflect.Array:
+		return &OpenAPISchema{Type: "array", Items: b.schema(t.Elem())}
+	case reflect.Map:
+		return &OpenAPISchema{Type: "object", AdditionalProperties: b.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.structSchema(t)
+		}
+		if _, ok := b.schemas[t.Name()]; !ok {
+			b.schemas[t.Name()] = &OpenAPISchema{} // placeholder for recursive types
+			b.schemas[t.Name()] = b.structSchema(t)
+		}
+		return &OpenAPISchema{Ref: "#/components/schemas/" + t.Name()}
+	}
+	return &OpenAPISchema{}
+}
+
+func (b *schemaBuilder) structSchema(t reflect.Type) *OpenAPISchema {
+	s := &OpenAPISchema{Type: "object", Properties: make(map[string]*OpenAPISchema)}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := jsonFieldName(f)
+		if name == "" {
+			continue
+		}
+		fs := b.schema(f.Type)
+		rules := parseRules(f.Tag.Get("validate"))
+		if rules.required {
+			s.Required = append(s.Required, name)
+		}
+		if fs.Ref == "" {
+			if rules.min != nil {
+				fs.Minimum = rules.min
+			}
+			fs.Maximum = rules.max
+			fs.Enum = rules.oneOf
+		}
+		s.Properties[name] = fs
+	}
+	return s
+}
+
+// jsonFieldName returns the JSON name of an exported field, or "" when the
+// field is not encoded
+func jsonFieldName(f reflect.StructField) string {
+	if f.PkgPath != "" {
+		return ""
+	}
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	switch name {
+	case "-":
+		return ""
+	case "":
+		return f.Name
+	}
+	return name
+}
+
+// buildOpenAPI generates the document for apiOperations
+func buildOpenAPI() *OpenAPIDocument {
+	b := &schemaBuilder{schemas: make(map[string]*OpenAPISchema)}
+	doc := &OpenAPIDocument{
+		OpenAPI:    "3.0.3",
+		Info:       OpenAPIInfo{Title: "OpenTelemetry Query API", Version: serviceVersion},
+		Paths:      make(map[string]map[string]*OpenAPIOperation),
+		Components: OpenAPIComponents{Schemas: b.schemas},
+	}
+	for _, op := range apiOperations {
+		o := &OpenAPIOperation{
+			Summary:     op.summary,
+			OperationID: operationID(op.method, op.route),
+			Responses: map[string]OpenAPIResponse{
+				"400": {Description: "Invalid request; the body names the problem"},
+				"500": {Description: "Query failed"},
+			},
+		}
+		for _, segment := range strings.Split(op.route, "/") {
+			if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+				o.Parameters = append(o.Parameters, OpenAPIParameter{
+					Name: strings.Trim(segment, "{}"), In: "path", Required: true, Schema: &OpenAPISchema{Type: "string"},
+				})
+			}
+		}
+		for _, p := range op.params {
+			schema := &OpenAPISchema{Type: p.kind}
+			if p.kind == "date-time" {
+				schema = &OpenAPISchema{Type: "string", Format: "date-time"}
+			}
+			o.Parameters = append(o.Parameters, OpenAPIParameter{Name: p.name, In: "query", Description: p.description, Schema: schema})
+		}
+		if op.request != nil {
+			o.RequestBody = &OpenAPIRequestBody{
+				Required: true,
+				Content:  map[string]OpenAPIMediaType{"application/json": {Schema: b.schema(reflect.TypeOf(op.request()))}},
+			}
+		}
+		status := op.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		ok := OpenAPIResponse{Description: http.StatusText(status)}
+		if op.response != nil {
+			ok.Content = map[string]OpenAPIMediaType{"application/json": {Schema: b.schema(reflect.TypeOf(op.response()))}}
+		}
+		o.Responses[strconv.Itoa(status)] = ok
+		if op.limited {
+			o.Responses["422"] = OpenAPIResponse{
+				Description: "Over a query limit",
+				Content:     map[string]OpenAPIMediaType{"application/json": {Schema: b.schema(reflect.TypeOf(&QueryLimitError{}))}},
+			}
+		}
+
+		if doc.Paths[op.route] == nil {
+			doc.Paths[op.route] = make(map[string]*OpenAPIOperation)
+		}
+		doc.Paths[op.route][strings.ToLower(op.method)] = o
+	}
+	return doc
+}
+
+// operationID derives a stable identifier such as getApiV1JobsId
+func operationID(method, route string) string {
+	parts := strings.FieldsFunc(route, func(r rune) bool {
+		return r == '/' || r == '{' || r == '}' || r == '.' || r == '-' || r == '_'
+	})
+	id := strings.ToLower(method)
+	for _, p := range parts {
+		id += strings.ToUpper(p[:1]) + p[1:]
+	}
+	return id
+}
+
+var (
+	openAPIOnce sync.Once
+	openAPIJSON []byte
+)
+
+// GetOpenAPI serves the OpenAPI document
+func (s *QueryService) GetOpenAPI(w http.ResponseWriter, r *http.Request) {
+	openAPIOnce.Do(func() {
+		openAPIJSON, _ = json.Marshal(buildOpenAPI())
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPIJSON)
+}
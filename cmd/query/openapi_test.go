@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"otelservices/internal/config"
+
+	"github.com/gorilla/mux"
+)
+
+func TestAPIOperationsCoverRoutes(t *testing.T) {
+	registered := map[string]bool{}
+	router := newRouter(NewQueryService(config.DefaultConfig(), nil))
+	router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		tmpl, err := route.GetPathTemplate()
+		if err != nil || !strings.HasPrefix(tmpl, "/api/") {
+			return nil
+		}
+		methods, _ := route.GetMethods()
+		for _, method := range methods {
+			registered[method+" "+tmpl] = true
+			if findOperation(method, tmpl) == nil {
+				t.Errorf("%s %s is missing from apiOperations", method, tmpl)
+			}
+		}
+		return nil
+	})
+	for _, op := range apiOperations {
+		if !registered[op.method+" "+op.route] {
+			t.Errorf("apiOperations lists %s %s, which has no route", op.method, op.route)
+		}
+	}
+}
+
+func TestBuildOpenAPI(t *testing.T) {
+	doc := buildOpenAPI()
+
+	// Every reference must resolve
+	data, _ := json.Marshal(doc)
+	for _, part := range strings.Split(string(data), `"$ref":"#/components/schemas/`)[1:] {
+		name := part[:strings.Index(part, `"`)]
+		if _, ok := doc.Components.Schemas[name]; !ok {
+			t.Errorf("unresolved reference to %s", name)
+		}
+	}
+
+	metrics := doc.Components.Schemas["MetricsQueryRequest"]
+	if metrics == nil {
+		t.Fatal("MetricsQueryRequest schema missing")
+	}
+	if strings.Join(metrics.Required, ",") != "metric_name" {
+		t.Errorf("required = %v", metrics.Required)
+	}
+	if agg := metrics.Properties["aggregation"]; strings.Join(agg.Enum, ",") != "avg,min,max,sum" {
+		t.Errorf("aggregation enum = %v", agg.Enum)
+	}
+	if start := metrics.Properties["start_time"]; start.Type != "string" || start.Format != "date-time" {
+		t.Errorf("start_time schema = %+v", start)
+	}
+	limit := doc.Components.Schemas["TraceQueryRequest"].Properties["limit"]
+	if limit.Minimum == nil || *limit.Minimum != 0 || limit.Maximum == nil || *limit.Maximum != 10000 {
+		t.Errorf("limit schema = %+v", limit)
+	}
+
+	if logs := doc.Paths["/api/v1/logs"]["post"]; logs.Responses["422"].Content == nil {
+		t.Error("logs query should document the query limit error")
+	}
+
+	submit := doc.Paths["/api/v1/jobs"]["post"]
+	if submit == nil || submit.RequestBody == nil || submit.Responses["202"].Content == nil {
+		t.Errorf("jobs submit operation = %+v", submit)
+	}
+	if get := doc.Paths["/api/v1/jobs/{id}"]["get"]; get == nil || len(get.Parameters) != 1 || get.Parameters[0].In != "path" {
+		t.Errorf("jobs get operation = %+v", get)
+	}
+}
+
+func TestGetOpenAPI(t *testing.T) {
+	rec := httptest.NewRecorder()
+	(&QueryService{}).GetOpenAPI(rec, httptest.NewRequest("GET", "/api/openapi.json", nil))
+	var doc OpenAPIDocument
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil || doc.OpenAPI != "3.0.3" || len(doc.Paths) == 0 {
+		t.Errorf("unexpected document (%v): %.200s", err, rec.Body.String())
+	}
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"otelservices/internal/monitoring"
+
+	"github.com/gorilla/mux"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+const (
+	// maxOTLPExportTraces caps the traces of one OTLP export request
+	maxOTLPExportTraces = 100
+	// maxOTLPExportSpans caps the spans read for one OTLP export request
+	maxOTLPExportSpans = 100000
+)
+
+// OTLPExportRequest selects the traces re-encoded as OTLP
+type OTLPExportRequest struct {
+	TraceIDs []string `json:"trace_ids" validate:"required"`
+}
+
+// otlpSpanQuery reads every stored field of the spans of a set of traces
+const otlpSpanQuery = `
+	SELECT
+		trace_id, span_id, parent_span_id, span_name, span_kind,
+		start_time, end_time, status_code, status_message,
+		service_name, service_namespace, service_instance_id, deployment_environment,
+		attributes, attributes_cold, resource_attributes,
+		` + spanFidelityColumns + `,
+		` + spanEventColumns + `
+	FROM %s
+	WHERE trace_id IN (?)%s
+	ORDER BY trace_id, start_time
+	LIMIT %d
+`
+
+// storedSpan is a span with the resource and scope it was received with
+type storedSpan struct {
+	Span
+	resource map[string]string
+	scope    scopeColumns
+}
+
+// ExportTraceOTLP returns one trace as an OTLP ExportTraceServiceRequest
+func (s *QueryService) ExportTraceOTLP(w http.ResponseWriter, r *http.Request) {
+	s.exportOTLP(w, r, []string{mux.Vars(r)["trace_id"]})
+}
+
+// ExportTracesOTLP returns a set of traces as one OTLP ExportTraceServiceRequest
+func (s *QueryService) ExportTracesOTLP(w http.ResponseWriter, r *http.Request) {
+	var req OTLPExportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		monitoring.QueryErrors.WithLabelValues("otlp_export").Inc()
+		return
+	}
+	s.exportOTLP(w, r, req.TraceIDs)
+}
+
+// exportOTLP writes the spans of traceIDs as OTLP/JSON, or as OTLP/protobuf
+// when the client accepts it, ready to be posted to any OTLP receiver
+func (s *QueryService) exportOTLP(w http.ResponseWriter, r *http.Request, traceIDs []string) {
+	start := time.Now()
+	defer func() {
+		monitoring.QueryDuration.WithLabelValues("otlp_export").Observe(time.Since(start).Seconds())
+	}()
+
+	ids, err := normalizeTraceIDs(traceIDs)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		monitoring.QueryErrors.WithLabelValues("otlp_export").Inc()
+		return
+	}
+
+	spans, err := s.fetchStoredSpans(r.Context(), ids)
+	if err != nil {
+		queryFailed(w, "otlp_export", err)
+		return
+	}
+	if len(spans) == 0 {
+		http.Error(w, "trace not found", http.StatusNotFound)
+		return
+	}
+	req := buildExportTraceRequest(spans)
+
+	filename := "traces"
+	if len(ids) == 1 {
+		filename = "trace-" + ids[0]
+	}
+	if wantsProtobuf(r) {
+		body, err := proto.Marshal(req)
+		if err != nil {
+			queryFailed(w, "otlp_export", err)
+			return
+		}
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.pb"`, filename))
+		writeProtobuf(w, body)
+		return
+	}
+	body, err := marshalOTLPJSON(req)
+	if err != nil {
+		queryFailed(w, "otlp_export", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Add("Vary", "Accept")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.json"`, filename))
+	w.Write(body)
+}
+
+// normalizeTraceIDs checks that trace IDs are 32 hex digits and lowercases
+// them like the collector stores them
+func normalizeTraceIDs(traceIDs []string) ([]string, error) {
+	if len(traceIDs) == 0 {
+		return nil, fmt.Errorf("trace_ids is required")
+	}
+	if len(traceIDs) > maxOTLPExportTraces {
+		return nil, fmt.Errorf("trace_ids must have at most %d entries, got %d", maxOTLPExportTraces, len(traceIDs))
+	}
+	ids := make([]string, len(traceIDs))
+	for i, id := range traceIDs {
+		if _, err := hex.DecodeString(id); err != nil || len(id) != 32 {
+			return nil, fmt.Errorf("invalid trace ID %q: must be 32 hex digits", id)
+		}
+		ids[i] = strings.ToLower(id)
+	}
+	return ids, nil
+}
+
+// fetchStoredSpans reads the spans of the given traces, up to maxOTLPExportSpans
+func (s *QueryService) fetchStoredSpans(ctx context.Context, traceIDs []string) ([]storedSpan, error) {
+	timeRange, rangeArgs := traceRangeClause(s.traceLookupRange(ctx, traceIDs))
+	query := fmt.Sprintf(otlpSpanQuery, s.chClient.SpansTable(), timeRange, maxOTLPExportSpans)
+	rows, err := s.chClient.Query(ctx, query, append([]interface{}{traceIDs}, rangeArgs...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	spans := []storedSpan{}
+	for rows.Next() {
+		var span storedSpan
+		var namespace, instanceID, environment, coldAttrs string
+		var arrays spanEventArrays
+		dest := []interface{}{
+			&span.TraceID, &span.SpanID, &span.ParentSpanID, &span.SpanName, &span.SpanKind,
+			&span.StartTime, &span.EndTime, &span.StatusCode, &span.StatusMessage,
+			&span.ServiceName, &namespace, &instanceID, &environment,
+			&span.Attributes, &coldAttrs, &span.resource,
+		}
+		dest = append(dest, span.fidelityDest(&span.scope)...)
+		if err := rows.Scan(append(dest, arrays.dest()...)...); err != nil {
+			return nil, fmt.Errorf("failed to scan span: %w", err)
+		}
+		if span.Attributes, err = mergeColdAttributes(span.Attributes, coldAttrs); err != nil {
+			logger.Error("Error reading cold attributes", "span_id", span.SpanID, "error", err)
+		}
+		span.restoreExactTimes()
+		span.Scope = span.scope.scope()
+		if span.Events, err = arrays.events(); err != nil {
+			return nil, err
+		}
+		if span.Links, err = arrays.links(); err != nil {
+			return nil, err
+		}
+		span.resource = resourceAttributes(span.resource, map[string]string{
+			"service.name":           span.ServiceName,
+			"service.namespace":      namespace,
+			"service.instance.id":    instanceID,
+			"deployment.environment": environment,
+		})
+		spans = append(spans, span)
+	}
+	return spans, rows.Err()
+}
+
+// resourceAttributes adds the resource attributes the collector stores in
+// their own columns back to the others, skipping empty ones
+func resourceAttributes(attrs, columns map[string]string) map[string]string {
+	merged := make(map[string]string, len(attrs)+len(columns))
+	for k, v := range attrs {
+		merged[k] = v
+	}
+	for k, v := range columns {
+		if v != "" {
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
+// buildExportTraceRequest groups spans by resource and instrumentation scope
+// in the order they are first seen
+func buildExportTraceRequest(spans []storedSpan) *coltracepb.ExportTraceServiceRequest {
+	req := &coltracepb.ExportTraceServiceRequest{}
+	resources := make(map[string]*tracepb.ResourceSpans)
+	scopes := make(map[string]*tracepb.ScopeSpans)
+	for _, span := range spans {
+		resourceKey := attributesKey(span.resource)
+		rs, ok := resources[resourceKey]
+		if !ok {
+			rs = &tracepb.ResourceSpans{Resource: &resourcepb.Resource{Attributes: otlpAttributes(span.resource)}}
+			resources[resourceKey] = rs
+			req.ResourceSpans = append(req.ResourceSpans, rs)
+		}
+		scopeKey := resourceKey + "\x00" + span.scope.name + "\x00" + span.scope.version + "\x00" + attributesKey(span.scope.attributes)
+		ss, ok := scopes[scopeKey]
+		if !ok {
+			ss = &tracepb.ScopeSpans{Scope: &commonpb.InstrumentationScope{
+				Name:       span.scope.name,
+				Version:    span.scope.version,
+				Attributes: otlpAttributes(span.scope.attributes),
+			}}
+			scopes[scopeKey] = ss
+			rs.ScopeSpans = append(rs.ScopeSpans, ss)
+		}
+		ss.Spans = append(ss.Spans, otlpSpan(&span.Span))
+	}
+	return req
+}
+
+func otlpSpan(span *Span) *tracepb.Span {
+	out := &tracepb.Span{
+		TraceId:                decodeID(span.TraceID),
+		SpanId:                 decodeID(span.SpanID),
+		ParentSpanId:           decodeID(span.ParentSpanID),
+		Name:                   span.SpanName,
+		Kind:                   otlpSpanKind(span.SpanKind),
+		StartTimeUnixNano:      unixNano(span.StartTime),
+		EndTimeUnixNano:        unixNano(span.EndTime),
+		Attributes:             otlpAttributes(span.Attributes),
+		Status:                 &tracepb.Status{Code: otlpStatusCode(span.StatusCode), Message: span.StatusMessage},
+		TraceState:             span.TraceState,
+		DroppedAttributesCount: span.DroppedAttributesCount,
+		DroppedEventsCount:     span.DroppedEventsCount,
+		DroppedLinksCount:      span.DroppedLinksCount,
+	}
+	for _, e := range span.Events {
+		out.Events = append(out.Events, &tracepb.Span_Event{
+			TimeUnixNano: unixNano(e.Timestamp),
+			Name:         e.Name,
+			Attributes:   otlpAttributes(e.Attributes),
+		})
+	}
+	for _, l := range span.Links {
+		out.Links = append(out.Links, &tracepb.Span_Link{
+			TraceId:    decodeID(l.TraceID),
+			SpanId:     decodeID(l.SpanID),
+			TraceState: l.TraceState,
+			Attributes: otlpAttributes(l.Attributes),
+		})
+	}
+	return out
+}
+
+// decodeID turns a stored hex ID back into bytes; empty or invalid IDs,
+// such as the parent of a root span, become nil
+func decodeID(id string) []byte {
+	b, err := hex.DecodeString(id)
+	if err != nil || len(b) == 0 {
+		return nil
+	}
+	return b
+}
+
+// otlpAttributes converts stored attributes, which are strings, to sorted
+// OTLP key-values
+func otlpAttributes(attrs map[string]string) []*commonpb.KeyValue {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	out := make([]*commonpb.KeyValue, 0, len(keys))
+	for _, k := range keys {
+		out = append(out, &commonpb.KeyValue{
+			Key:   k,
+			Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: attrs[k]}},
+		})
+	}
+	return out
+}
+
+// attributesKey identifies a set of attributes regardless of map order
+func attributesKey(attrs map[string]string) string {
+	key, _ := json.Marshal(attrs) // map keys are encoded sorted
+	return string(key)
+}
+
+// otlpSpanKind maps the span_kind enum value back to OTLP
+func otlpSpanKind(kind string) tracepb.Span_SpanKind {
+	switch kind {
+	case "server":
+		return tracepb.Span_SPAN_KIND_SERVER
+	case "client":
+		return tracepb.Span_SPAN_KIND_CLIENT
+	case "producer":
+		return tracepb.Span_SPAN_KIND_PRODUCER
+	case "consumer":
+		return tracepb.Span_SPAN_KIND_CONSUMER
+	}
+	return tracepb.Span_SPAN_KIND_INTERNAL
+}
+
+// otlpStatusCode maps the status_code enum value back to OTLP
+func otlpStatusCode(code string) tracepb.Status_StatusCode {
+	switch code {
+	case "ok":
+		return tracepb.Status_STATUS_CODE_OK
+	case "error":
+		return tracepb.Status_STATUS_CODE_ERROR
+	}
+	return tracepb.Status_STATUS_CODE_UNSET
+}
+
+// marshalOTLPJSON encodes a message as OTLP/JSON, which differs from
+// protojson in encoding enums as numbers and trace and span IDs as hex
+func marshalOTLPJSON(msg proto.Message) ([]byte, error) {
+	data, err := protojson.MarshalOptions{UseEnumNumbers: true}.Marshal(msg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode OTLP/JSON: %w", err)
+	}
+	return base64IDsToHex(data)
+}
+
+// base64IDsToHex rewrites the base64 protojson uses for the bytes fields of
+// trace and span IDs into the hex OTLP/JSON requires
+func base64IDsToHex(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			for key, value := range v {
+				switch key {
+				case "traceId", "spanId", "parentSpanId":
+					if s, ok := value.(string); ok {
+						if raw, err := base64.StdEncoding.DecodeString(s); err == nil {
+							v[key] = hex.EncodeToString(raw)
+						}
+					}
+				default:
+					walk(value)
+				}
+			}
+		case []interface{}:
+			for _, value := range v {
+				walk(value)
+			}
+		}
+	}
+	walk(doc)
+	return json.Marshal(doc)
+}
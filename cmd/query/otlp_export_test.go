@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+func TestBuildExportTraceRequest(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	frontend := map[string]string{"service.name": "frontend", "deployment.environment": "prod"}
+	spans := []storedSpan{
+		{Span: Span{TraceID: "0af7651916cd43dd8448eb211c80319c", SpanID: "b7ad6b7169203331", SpanName: "GET /users",
+			SpanKind: "server", StatusCode: "error", StatusMessage: "timeout", StartTime: start, EndTime: start.Add(time.Second),
+			Attributes: map[string]string{"http.method": "GET"},
+			Events:     []SpanEvent{{Timestamp: start, Name: "exception"}},
+			Links:      []SpanLink{{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", SpanID: "00f067aa0ba902b7"}},
+			TraceState: "congo=t61rcWkgMzE", DroppedAttributesCount: 2, DroppedEventsCount: 1},
+			resource: frontend, scope: scopeColumns{name: "http", attributes: map[string]string{"library.language": "go"}}},
+		{Span: Span{TraceID: "0af7651916cd43dd8448eb211c80319c", SpanID: "00f067aa0ba902b7", ParentSpanID: "b7ad6b7169203331", SpanKind: "client"},
+			resource: map[string]string{"deployment.environment": "prod", "service.name": "frontend"}, scope: scopeColumns{name: "db"}},
+		{Span: Span{TraceID: "0af7651916cd43dd8448eb211c80319c", SpanID: "1111111111111111", SpanKind: "internal"},
+			resource: map[string]string{"service.name": "users"}},
+	}
+
+	req := buildExportTraceRequest(spans)
+	if len(req.ResourceSpans) != 2 {
+		t.Fatalf("want 2 resources, got %d", len(req.ResourceSpans))
+	}
+	frontendSpans := req.ResourceSpans[0]
+	if len(frontendSpans.ScopeSpans) != 2 || frontendSpans.ScopeSpans[0].Scope.Name != "http" {
+		t.Fatalf("unexpected scopes %v", frontendSpans.ScopeSpans)
+	}
+	if attrs := frontendSpans.ScopeSpans[0].Scope.Attributes; len(attrs) != 1 || attrs[0].Key != "library.language" {
+		t.Errorf("unexpected scope attributes %v", attrs)
+	}
+	if attrs := frontendSpans.Resource.Attributes; len(attrs) != 2 || attrs[0].Key != "deployment.environment" {
+		t.Errorf("unexpected resource attributes %v", attrs)
+	}
+
+	root := frontendSpans.ScopeSpans[0].Spans[0]
+	if root.Kind != tracepb.Span_SPAN_KIND_SERVER || root.Status.Code != tracepb.Status_STATUS_CODE_ERROR {
+		t.Errorf("kind = %v, status = %v", root.Kind, root.Status.Code)
+	}
+	if root.ParentSpanId != nil || len(root.TraceId) != 16 || len(root.SpanId) != 8 {
+		t.Errorf("unexpected IDs %x %x %x", root.TraceId, root.SpanId, root.ParentSpanId)
+	}
+	if root.StartTimeUnixNano != uint64(start.UnixNano()) || len(root.Events) != 1 || len(root.Links) != 1 {
+		t.Errorf("unexpected span %v", root)
+	}
+	if root.TraceState != "congo=t61rcWkgMzE" || root.DroppedAttributesCount != 2 || root.DroppedEventsCount != 1 {
+		t.Errorf("trace state %q, dropped attributes %d, dropped events %d", root.TraceState, root.DroppedAttributesCount, root.DroppedEventsCount)
+	}
+}
+
+func TestMarshalOTLPJSON(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	req := buildExportTraceRequest([]storedSpan{{
+		Span: Span{TraceID: "0af7651916cd43dd8448eb211c80319c", SpanID: "b7ad6b7169203331", ParentSpanID: "00f067aa0ba902b7",
+			SpanKind: "client", StatusCode: "ok", StartTime: start, EndTime: start,
+			Links: []SpanLink{{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", SpanID: "00f067aa0ba902b7"}}},
+		resource: map[string]string{"service.name": "frontend"},
+	}})
+	data, err := marshalOTLPJSON(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var doc struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Spans []struct {
+					TraceID           string `json:"traceId"`
+					SpanID            string `json:"spanId"`
+					ParentSpanID      string `json:"parentSpanId"`
+					Kind              int    `json:"kind"`
+					StartTimeUnixNano string `json:"startTimeUnixNano"`
+					Status            struct {
+						Code int `json:"code"`
+					} `json:"status"`
+					Links []struct {
+						TraceID string `json:"traceId"`
+					} `json:"links"`
+				} `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("invalid JSON %s: %v", data, err)
+	}
+	span := doc.ResourceSpans[0].ScopeSpans[0].Spans[0]
+	if span.TraceID != "0af7651916cd43dd8448eb211c80319c" || span.SpanID != "b7ad6b7169203331" || span.ParentSpanID != "00f067aa0ba902b7" {
+		t.Errorf("IDs not hex encoded: %s", data)
+	}
+	if span.Links[0].TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("link trace ID = %q", span.Links[0].TraceID)
+	}
+	if span.Kind != 3 || span.Status.Code != 1 || span.StartTimeUnixNano != "1700000000000000000" {
+		t.Errorf("unexpected span encoding %s", data)
+	}
+}
+
+func TestNormalizeTraceIDs(t *testing.T) {
+	ids, err := normalizeTraceIDs([]string{"0AF7651916CD43DD8448EB211C80319C"})
+	if err != nil || ids[0] != "0af7651916cd43dd8448eb211c80319c" {
+		t.Errorf("ids = %v, err = %v", ids, err)
+	}
+	tooMany := make([]string, maxOTLPExportTraces+1)
+	for i := range tooMany {
+		tooMany[i] = strings.Repeat("a", 32)
+	}
+	for _, bad := range [][]string{nil, {"xyz"}, {"b7ad6b7169203331"}, tooMany} {
+		if _, err := normalizeTraceIDs(bad); err == nil {
+			t.Errorf("expected error for %d IDs %.40v", len(bad), bad)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	queryv1 "otelservices/proto/query/v1"
+)
+
+// wantsProtobuf reports whether the client asked for a protobuf response via
+// the Accept header. JSON remains the default for everything else.
+func wantsProtobuf(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil || mediaType != queryv1.ContentType {
+			continue
+		}
+		if q, ok := params["q"]; ok && (q == "0" || strings.Trim(q, "0.") == "") {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// writeProtobuf writes an encoded protobuf message
+func writeProtobuf(w http.ResponseWriter, body []byte) {
+	w.Header().Set("Content-Type", queryv1.ContentType)
+	w.Header().Add("Vary", "Accept")
+	if _, err := w.Write(body); err != nil {
+		logger.Error("Error writing protobuf response", "error", err)
+	}
+}
+
+func unixNano(t time.Time) uint64 {
+	if t.IsZero() {
+		return 0
+	}
+	return uint64(t.UnixNano())
+}
+
+func (resp *TraceQueryResponse) toProto() *queryv1.TraceQueryResponse {
+	out := &queryv1.TraceQueryResponse{
+		Spans: make([]*queryv1.Span, 0, len(resp.Spans)),
+		Total: int64(resp.Total),
+	}
+	for _, span := range resp.Spans {
+		out.Spans = append(out.Spans, &queryv1.Span{
+			TraceID:                span.TraceID,
+			SpanID:                 span.SpanID,
+			ParentSpanID:           span.ParentSpanID,
+			SpanName:               span.SpanName,
+			SpanKind:               span.SpanKind,
+			StartTimeUnixNano:      unixNano(span.StartTime),
+			EndTimeUnixNano:        unixNano(span.EndTime),
+			DurationNs:             span.DurationNs,
+			StatusCode:             span.StatusCode,
+			StatusMessage:          span.StatusMessage,
+			ServiceName:            span.ServiceName,
+			Attributes:             span.Attributes,
+			Trace:                  span.Trace.toProto(),
+			Events:                 spanEventsToProto(span.Events),
+			Links:                  spanLinksToProto(span.Links),
+			TraceState:             span.TraceState,
+			DroppedAttributesCount: span.DroppedAttributesCount,
+			DroppedEventsCount:     span.DroppedEventsCount,
+			DroppedLinksCount:      span.DroppedLinksCount,
+			Scope:                  span.Scope.toProto(),
+		})
+	}
+	return out
+}
+
+func spanEventsToProto(events []SpanEvent) []*queryv1.SpanEvent {
+	if len(events) == 0 {
+		return nil
+	}
+	out := make([]*queryv1.SpanEvent, len(events))
+	for i, e := range events {
+		out[i] = &queryv1.SpanEvent{TimeUnixNano: unixNano(e.Timestamp), Name: e.Name, Attributes: e.Attributes}
+	}
+	return out
+}
+
+func spanLinksToProto(links []SpanLink) []*queryv1.SpanLink {
+	if len(links) == 0 {
+		return nil
+	}
+	out := make([]*queryv1.SpanLink, len(links))
+	for i, l := range links {
+		out[i] = &queryv1.SpanLink{TraceID: l.TraceID, SpanID: l.SpanID, TraceState: l.TraceState, Attributes: l.Attributes}
+	}
+	return out
+}
+
+func (summary *TraceSummary) toProto() *queryv1.TraceSummary {
+	if summary == nil {
+		return nil
+	}
+	return &queryv1.TraceSummary{
+		RootServiceName:   summary.RootServiceName,
+		RootSpanName:      summary.RootSpanName,
+		StartTimeUnixNano: unixNano(summary.StartTime),
+		EndTimeUnixNano:   unixNano(summary.EndTime),
+		DurationNs:        summary.DurationNs,
+		SpanCount:         summary.SpanCount,
+		HasErrors:         summary.HasErrors,
+		ServiceNames:      summary.ServiceNames,
+	}
+}
+
+func metricDataPointsToProto(points []MetricDataPoint) []*queryv1.MetricDataPoint {
+	out := make([]*queryv1.MetricDataPoint, 0, len(points))
+	for _, dp := range points {
+		out = append(out, &queryv1.MetricDataPoint{
+			TimestampUnixNano: unixNano(dp.Timestamp),
+			Value:             dp.Value,
+			Labels:            dp.Labels,
+		})
+	}
+	return out
+}
+
+func (resp *MetricsQueryResponse) toProto() *queryv1.MetricsQueryResponse {
+	out := &queryv1.MetricsQueryResponse{
+		MetricName: resp.MetricName,
+		DataPoints: metricDataPointsToProto(resp.DataPoints),
+	}
+	for _, series := range resp.Series {
+		out.Series = append(out.Series, &queryv1.MetricSeries{
+			Labels:     series.Labels,
+			DataPoints: metricDataPointsToProto(series.DataPoints),
+		})
+	}
+	return out
+}
+
+func (resp *LogsQueryResponse) toProto() *queryv1.LogsQueryResponse {
+	out := &queryv1.LogsQueryResponse{
+		Logs:  make([]*queryv1.LogRecord, 0, len(resp.Logs)),
+		Total: int64(resp.Total),
+	}
+	for _, logRec := range resp.Logs {
+		out.Logs = append(out.Logs, &queryv1.LogRecord{
+			TimestampUnixNano:      unixNano(logRec.Timestamp),
+			SeverityNumber:         uint32(logRec.SeverityNumber),
+			SeverityText:           logRec.SeverityText,
+			Body:                   logRec.Body,
+			ServiceName:            logRec.ServiceName,
+			TraceID:                logRec.TraceID,
+			SpanID:                 logRec.SpanID,
+			Attributes:             logRec.Attributes,
+			DroppedAttributesCount: logRec.DroppedAttributesCount,
+			Scope:                  logRec.Scope.toProto(),
+		})
+	}
+	return out
+}
+
+func serviceStatsToProto(stats []ServiceStat) *queryv1.ServiceStatsResponse {
+	out := &queryv1.ServiceStatsResponse{Stats: make([]*queryv1.ServiceStat, 0, len(stats))}
+	for _, stat := range stats {
+		out.Stats = append(out.Stats, &queryv1.ServiceStat{
+			ServiceName:   stat.ServiceName,
+			SpanCount:     stat.SpanCount,
+			AvgDurationNs: stat.AvgDuration,
+			P95DurationNs: stat.P95Duration,
+			ErrorCount:    stat.ErrorCount,
+		})
+	}
+	return out
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	queryv1 "otelservices/proto/query/v1"
+)
+
+func TestWantsProtobuf(t *testing.T) {
+	tests := []struct {
+		name   string
+		accept string
+		want   bool
+	}{
+		{name: "no header", accept: "", want: false},
+		{name: "json", accept: "application/json", want: false},
+		{name: "protobuf", accept: "application/x-protobuf", want: true},
+		{name: "protobuf in list", accept: "application/json;q=0.5, application/x-protobuf", want: true},
+		{name: "protobuf refused", accept: "application/x-protobuf;q=0", want: false},
+		{name: "wildcard", accept: "*/*", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/v1/traces/search", nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			if got := wantsProtobuf(req); got != tt.want {
+				t.Errorf("wantsProtobuf(%q) = %v, want %v", tt.accept, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMetricsQueryResponseToProto(t *testing.T) {
+	ts := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	resp := &MetricsQueryResponse{
+		MetricName: "cpu_usage",
+		DataPoints: []MetricDataPoint{{Timestamp: ts, Value: 1.5}},
+		Series: []MetricSeries{{
+			Labels:     map[string]string{"service_name": "api"},
+			DataPoints: []MetricDataPoint{{Timestamp: ts, Value: 1.5}},
+		}},
+	}
+
+	var decoded queryv1.MetricsQueryResponse
+	if err := decoded.Unmarshal(resp.toProto().Marshal()); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if decoded.MetricName != "cpu_usage" || len(decoded.DataPoints) != 1 || len(decoded.Series) != 1 {
+		t.Fatalf("unexpected decoded response: %+v", decoded)
+	}
+	if got := decoded.DataPoints[0].TimestampUnixNano; got != uint64(ts.UnixNano()) {
+		t.Errorf("timestamp = %d, want %d", got, ts.UnixNano())
+	}
+	if decoded.Series[0].Labels["service_name"] != "api" {
+		t.Errorf("series labels = %v", decoded.Series[0].Labels)
+	}
+}
+
+func TestTraceQueryResponseToProto(t *testing.T) {
+	resp := &TraceQueryResponse{
+		Spans: []Span{{
+			TraceID: "abc", SpanID: "def", DurationNs: 1000, Attributes: map[string]string{"k": "v"},
+			Events: []SpanEvent{{Timestamp: time.Unix(0, 5), Name: "exception"}},
+			Links:  []SpanLink{{TraceID: "123", SpanID: "456"}},
+		}},
+		Total: 1,
+	}
+
+	var decoded queryv1.TraceQueryResponse
+	if err := decoded.Unmarshal(resp.toProto().Marshal()); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if decoded.Total != 1 || len(decoded.Spans) != 1 {
+		t.Fatalf("unexpected decoded response: %+v", decoded)
+	}
+	if s := decoded.Spans[0]; s.TraceID != "abc" || s.DurationNs != 1000 || s.Attributes["k"] != "v" {
+		t.Errorf("unexpected span: %+v", s)
+	}
+	if decoded.Spans[0].StartTimeUnixNano != 0 {
+		t.Error("zero start time should encode as 0")
+	}
+	if s := decoded.Spans[0]; len(s.Events) != 1 || s.Events[0].Name != "exception" || s.Events[0].TimeUnixNano != 5 {
+		t.Errorf("unexpected events: %+v", s.Events)
+	}
+	if s := decoded.Spans[0]; len(s.Links) != 1 || s.Links[0].TraceID != "123" || s.Links[0].SpanID != "456" {
+		t.Errorf("unexpected links: %+v", s.Links)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -373,6 +374,90 @@ func TestQueryMetricsDefaults(t *testing.T) {
 	t.Log("QueryMetrics with defaults completed")
 }
 
+func TestBuildMetricsQueryGroupByAndFilters(t *testing.T) {
+	start := time.Now().Add(-1 * time.Hour)
+	end := time.Now()
+	req := &MetricsQueryRequest{
+		MetricName:  "http_requests_total",
+		StartTime:   start,
+		EndTime:     end,
+		Aggregation: "sum",
+		GroupBy:     []string{"service_name", "http.method"},
+		Filters:     map[string]string{"region": "us-east-1", "http.status_code": "500"},
+	}
+
+	query, args := buildMetricsQuery(req, "otel_metrics")
+
+	for _, fragment := range []string{
+		"sum(value) as value",
+		"toString(service_name) as label_0",
+		"toString(attributes[?]) as label_1",
+		"AND attributes[?] = ?",
+		"GROUP BY ts, label_0, label_1",
+		"ORDER BY label_0, label_1, ts",
+	} {
+		if !strings.Contains(query, fragment) {
+			t.Errorf("Expected query to contain %q, got:\n%s", fragment, query)
+		}
+	}
+
+	// Select args come first, then the WHERE args, then filters in key order
+	expected := []interface{}{
+		"http.method",
+		"http_requests_total", start, end,
+		"http.status_code", "500",
+		"region", "us-east-1",
+	}
+	if len(args) != len(expected) {
+		t.Fatalf("Expected %d args, got %d: %v", len(expected), len(args), args)
+	}
+	for i := range expected {
+		if args[i] != expected[i] {
+			t.Errorf("Arg %d: expected %v, got %v", i, expected[i], args[i])
+		}
+	}
+}
+
+func TestBuildMetricsQueryWithoutGroupBy(t *testing.T) {
+	req := &MetricsQueryRequest{
+		MetricName:  "memory_usage",
+		ServiceName: "api-server",
+		StartTime:   time.Now().Add(-100 * 24 * time.Hour),
+		EndTime:     time.Now(),
+		Aggregation: "max",
+	}
+
+	query, args := buildMetricsQuery(req, "otel_metrics_1h")
+
+	if !strings.Contains(query, "max(value_max) as value") {
+		t.Errorf("Expected rollup aggregation, got:\n%s", query)
+	}
+	if !strings.Contains(query, "GROUP BY ts ORDER BY ts") {
+		t.Errorf("Expected plain time grouping, got:\n%s", query)
+	}
+	if strings.Contains(query, "label_") {
+		t.Errorf("Did not expect label columns, got:\n%s", query)
+	}
+	if len(args) != 4 {
+		t.Errorf("Expected 4 args, got %d", len(args))
+	}
+}
+
+func TestBuildMetricsQueryRollupAverage(t *testing.T) {
+	req := &MetricsQueryRequest{
+		MetricName:  "cpu_usage",
+		StartTime:   time.Now().Add(-40 * 24 * time.Hour),
+		EndTime:     time.Now(),
+		Aggregation: "avg",
+	}
+
+	query, _ := buildMetricsQuery(req, "otel_metrics_5m")
+
+	if !strings.Contains(query, "sum(value_sum) / sum(value_count) as value") {
+		t.Errorf("Expected averages to be re-aggregated from partial rollups, got:\n%s", query)
+	}
+}
+
 func TestQueryLogsDefaults(t *testing.T) {
 	cfg := config.DefaultConfig()
 	chClient, err := clickhouse.NewClient(&cfg.ClickHouse)
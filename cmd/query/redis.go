@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// redisMaxIdleConns is the number of idle connections kept to Redis
+const redisMaxIdleConns = 8
+
+// redisStore keeps cache entries in Redis, so query instances behind a load
+// balancer share them. It speaks just enough RESP for GET and SET.
+type redisStore struct {
+	addr     string
+	password string
+	db       int
+	idle     chan *redisConn
+}
+
+type redisConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func newRedisStore(addr, password string, db int) *redisStore {
+	return &redisStore{addr: addr, password: password, db: db, idle: make(chan *redisConn, redisMaxIdleConns)}
+}
+
+func (s *redisStore) get(ctx context.Context, key string) ([]byte, bool, error) {
+	reply, err := s.do(ctx, "GET", key)
+	if err != nil {
+		return nil, false, err
+	}
+	if reply == nil {
+		return nil, false, nil
+	}
+	value, ok := reply.([]byte)
+	if !ok {
+		return nil, false, fmt.Errorf("unexpected GET reply %v", reply)
+	}
+	return value, true, nil
+}
+
+func (s *redisStore) set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	_, err := s.do(ctx, "SET", key, string(value), "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	return err
+}
+
+// do runs one command on an idle or new connection. Connections that fail
+// are closed rather than reused.
+func (s *redisStore) do(ctx context.Context, args ...string) (interface{}, error) {
+	var conn *redisConn
+	select {
+	case conn = <-s.idle:
+	default:
+		var err error
+		if conn, err = s.dial(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	} else {
+		conn.SetDeadline(time.Time{})
+	}
+
+	reply, err := conn.do(args...)
+	var redisErr redisError
+	if err != nil && !errors.As(err, &redisErr) {
+		conn.Close()
+		return nil, err
+	}
+	select {
+	case s.idle <- conn:
+	default:
+		conn.Close()
+	}
+	return reply, err
+}
+
+func (s *redisStore) dial(ctx context.Context) (*redisConn, error) {
+	var d net.Dialer
+	c, err := d.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to redis: %w", err)
+	}
+	conn := &redisConn{Conn: c, r: bufio.NewReader(c)}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if s.password != "" {
+		if _, err := conn.do("AUTH", s.password); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("redis AUTH failed: %w", err)
+		}
+	}
+	if s.db != 0 {
+		if _, err := conn.do("SELECT", strconv.Itoa(s.db)); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("redis SELECT failed: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+// redisError is an error reply; the connection stays usable
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+// do writes a command as an array of bulk strings and reads the reply:
+// a string or []byte, an int64, nil, or a redisError
+func (c *redisConn) do(args ...string) (interface{}, error) {
+	buf := []byte("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		buf = append(buf, "$"+strconv.Itoa(len(arg))+"\r\n"...)
+		buf = append(buf, arg...)
+		buf = append(buf, "\r\n"...)
+	}
+	if _, err := c.Write(buf); err != nil {
+		return nil, err
+	}
+
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("invalid redis reply %q", line)
+	}
+	line = line[:len(line)-2]
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid redis bulk length %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		value := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, value); err != nil {
+			return nil, err
+		}
+		return value[:n], nil
+	}
+	return nil, fmt.Errorf("unsupported redis reply %q", line)
+}
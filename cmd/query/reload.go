@@ -0,0 +1,17 @@
+package main
+
+import (
+	"otelservices/internal/config"
+	"otelservices/internal/logging"
+)
+
+// applyConfig applies the dynamic settings of a reloaded config. The log
+// level and format apply at once, the query profiles to new requests and
+// jobs, and the query budget and limits to new requests through
+// interactiveContext.
+func (s *QueryService) applyConfig(cfg *config.Config) {
+	if err := logging.Setup(cfg.Monitoring.LogLevel, cfg.Monitoring.LogFormat); err != nil {
+		logger.Error("Failed to apply log settings", "error", err)
+	}
+	s.jobs.setProfiles(cfg.ClickHouse.QueryProfiles)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"otelservices/internal/config"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestReloadAppliesQuerySettings(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Path = filepath.Join(t.TempDir(), "query.yaml")
+	s := NewQueryService(cfg, nil)
+
+	next := config.DefaultConfig()
+	next.ClickHouse.QueryBudget = config.QueryBudget{MaxRows: 5000, Action: "warn"}
+	next.ClickHouse.QueryProfiles.Export.MaxThreads = 2
+	data, err := yaml.Marshal(next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(cfg.Path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.reloader.Reload(); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+
+	budget, ok := queryBudgetFrom(s.interactiveContext(context.Background()))
+	if !ok || budget.MaxRows != 5000 || budget.Action != "warn" {
+		t.Errorf("requests should use the reloaded budget, got %+v", budget)
+	}
+	if p := s.jobs.profileFor(jobClassExport); p.MaxThreads != 2 {
+		t.Errorf("new export jobs should use the reloaded profile, got %+v", p)
+	}
+}
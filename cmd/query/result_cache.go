@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"otelservices/internal/config"
+	"otelservices/internal/monitoring"
+)
+
+const (
+	// resultCacheMaxEntry is the largest response stored; bigger results are
+	// rarely repeated and would evict many small ones
+	resultCacheMaxEntry = 8 << 20
+	// resultCacheTimeout bounds a cache lookup or store, so a slow Redis
+	// does not slow down queries
+	resultCacheTimeout = 200 * time.Millisecond
+	// resultCacheKeyPrefix namespaces the keys in a shared Redis
+	resultCacheKeyPrefix = "otel-query:result:v1:"
+)
+
+// resultStore holds encoded cache entries
+type resultStore interface {
+	get(ctx context.Context, key string) ([]byte, bool, error)
+	set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+}
+
+// resultCache caches successful query responses, keyed by route, response
+// format and the normalized request. A nil cache passes requests through.
+type resultCache struct {
+	ttl   time.Duration
+	store resultStore
+}
+
+// cachedResponse is a stored response
+type cachedResponse struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"`
+}
+
+// newResultCache creates the cache configured by cfg, or nil when it is
+// disabled or the TTL is not positive
+func newResultCache(cfg config.QueryCacheConfig, ttl time.Duration) *resultCache {
+	if !cfg.Enabled || ttl <= 0 {
+		return nil
+	}
+	if cfg.RedisAddress != "" {
+		return &resultCache{ttl: ttl, store: newRedisStore(cfg.RedisAddress, cfg.RedisPassword, cfg.RedisDB)}
+	}
+	return &resultCache{ttl: ttl, store: newMemoryStore(int64(cfg.MaxSizeMiB) << 20)}
+}
+
+// wrap caches the responses of a handler. Requests with Cache-Control
+// no-cache skip the lookup and refresh the entry; no-store skips the cache
+// entirely. Responses carry X-Cache: HIT, MISS or BYPASS.
+func (c *resultCache) wrap(route string, next http.HandlerFunc) http.HandlerFunc {
+	if c == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		directives := r.Header.Get("Cache-Control")
+		if strings.Contains(directives, "no-store") {
+			monitoring.CacheRequests.WithLabelValues("results", "bypass").Inc()
+			w.Header().Set("X-Cache", "BYPASS")
+			next(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		key := resultCacheKey(route, r, body)
+
+		if !strings.Contains(directives, "no-cache") {
+			if resp, ok := c.lookup(r.Context(), key); ok {
+				monitoring.CacheRequests.WithLabelValues("results", "hit").Inc()
+				resp.write(w, r, "HIT")
+				return
+			}
+		}
+		monitoring.CacheRequests.WithLabelValues("results", "miss").Inc()
+
+		// Run without the client's validators so the full response is
+		// stored, then answer the conditional request from it
+		fill := r.Clone(r.Context())
+		fill.Header.Del("If-None-Match")
+		rec := newResponseBuffer()
+		next(rec, fill)
+		resp := &cachedResponse{Status: rec.status, Header: rec.header, Body: rec.body.Bytes()}
+		if resp.Status == http.StatusOK && len(resp.Body) <= resultCacheMaxEntry {
+			c.save(r.Context(), key, resp)
+		}
+		resp.write(w, r, "MISS")
+	}
+}
+
+func (c *resultCache) lookup(ctx context.Context, key string) (*cachedResponse, bool) {
+	ctx, cancel := context.WithTimeout(ctx, resultCacheTimeout)
+	defer cancel()
+	data, ok, err := c.store.get(ctx, key)
+	if err != nil {
+		logger.Warn("Query cache lookup failed", "error", err)
+		monitoring.CacheRequests.WithLabelValues("results", "error").Inc()
+		return nil, false
+	}
+	if !ok {
+		return nil, false
+	}
+	var resp cachedResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		logger.Warn("Invalid query cache entry", "error", err)
+		return nil, false
+	}
+	return &resp, true
+}
+
+func (c *resultCache) save(ctx context.Context, key string, resp *cachedResponse) {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return
+	}
+	// Store even when the client has gone, the result is complete
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), resultCacheTimeout)
+	defer cancel()
+	if err := c.store.set(ctx, key, data, c.ttl); err != nil {
+		logger.Warn("Query cache store failed", "error", err)
+		monitoring.CacheRequests.WithLabelValues("results", "error").Inc()
+	}
+}
+
+// write sends the response, or 304 when it has an ETag the client holds
+func (resp *cachedResponse) write(w http.ResponseWriter, r *http.Request, result string) {
+	for k, v := range resp.Header {
+		w.Header()[k] = v
+	}
+	w.Header().Set("X-Cache", result)
+	if etag := resp.Header.Get("ETag"); etag != "" && etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.WriteHeader(resp.Status)
+	w.Write(resp.Body)
+}
+
+// resultCacheKey hashes what determines a response: the route, its query
+// string, the response format and the request body. Bodies of known
+// operations are re-encoded through their request type, so field order,
+// whitespace and unknown fields do not split entries.
+func resultCacheKey(route string, r *http.Request, body []byte) string {
+	if op := findOperation(r.Method, route); op != nil {
+		body = normalizeBody(op, body)
+	}
+	format := "json"
+	if wantsProtobuf(r) {
+		format = "protobuf"
+	}
+	h := sha256.New()
+	for _, part := range []string{r.Method, route, r.URL.Query().Encode(), format} {
+		io.WriteString(h, part)
+		h.Write([]byte{0})
+	}
+	h.Write(body)
+	return resultCacheKeyPrefix + hex.EncodeToString(h.Sum(nil))
+}
+
+// normalizeBody re-encodes a request body for op through its request type,
+// or returns it as it is when op takes no body or it does not decode
+func normalizeBody(op *apiOperation, body []byte) []byte {
+	if op.request == nil {
+		return body
+	}
+	v := op.request()
+	if err := json.Unmarshal(body, v); err != nil {
+		return body
+	}
+	normalized, err := json.Marshal(v)
+	if err != nil {
+		return body
+	}
+	return normalized
+}
+
+// memoryStore is an in-process LRU store bounded by the size of its values
+type memoryStore struct {
+	maxBytes int64
+	mu       sync.Mutex
+	bytes    int64
+	order    *list.List // front is most recently used
+	entries  map[string]*list.Element
+}
+
+type memoryEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+func newMemoryStore(maxBytes int64) *memoryStore {
+	return &memoryStore{maxBytes: maxBytes, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+func (m *memoryStore) get(_ context.Context, key string) ([]byte, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	el, ok := m.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+	e := el.Value.(*memoryEntry)
+	if time.Now().After(e.expires) {
+		m.remove(el)
+		return nil, false, nil
+	}
+	m.order.MoveToFront(el)
+	return e.value, true, nil
+}
+
+func (m *memoryStore) set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	if int64(len(value)) > m.maxBytes {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if el, ok := m.entries[key]; ok {
+		m.remove(el)
+	}
+	m.entries[key] = m.order.PushFront(&memoryEntry{key: key, value: value, expires: time.Now().Add(ttl)})
+	m.bytes += int64(len(value))
+	for m.bytes > m.maxBytes {
+		m.remove(m.order.Back())
+	}
+	return nil
+}
+
+func (m *memoryStore) remove(el *list.Element) {
+	e := m.order.Remove(el).(*memoryEntry)
+	delete(m.entries, e.key)
+	m.bytes -= int64(len(e.value))
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"otelservices/internal/config"
+)
+
+func TestResultCacheWrap(t *testing.T) {
+	cache := newResultCache(config.QueryCacheConfig{Enabled: true, MaxSizeMiB: 1}, time.Minute)
+	calls := 0
+	handler := cache.wrap("/api/v1/logs", func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if strings.Contains(r.URL.RawQuery, "fail") {
+			http.Error(w, "boom", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"call": %d}`, calls)
+	})
+	do := func(target, body string, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	body := `{"start_time": "2024-01-01T00:00:00Z", "end_time": "2024-01-01T01:00:00Z", "service_name": "api"}`
+	if rec := do("/api/v1/logs", body, nil); rec.Header().Get("X-Cache") != "MISS" || rec.Body.String() != `{"call": 1}` {
+		t.Fatalf("first request: %s %s", rec.Header().Get("X-Cache"), rec.Body.String())
+	}
+	// Same query with fields reordered and different whitespace
+	reordered := `{"service_name":"api","end_time":"2024-01-01T01:00:00Z","start_time":"2024-01-01T00:00:00Z"}`
+	rec := do("/api/v1/logs", reordered, nil)
+	if rec.Header().Get("X-Cache") != "HIT" || rec.Body.String() != `{"call": 1}` || rec.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("normalized request should hit: %s %s", rec.Header().Get("X-Cache"), rec.Body.String())
+	}
+	if rec := do("/api/v1/logs", body, map[string]string{"Accept": "application/x-protobuf"}); rec.Header().Get("X-Cache") != "MISS" {
+		t.Error("protobuf responses should be cached separately")
+	}
+
+	if rec := do("/api/v1/logs", body, map[string]string{"Cache-Control": "no-cache"}); rec.Header().Get("X-Cache") != "MISS" || rec.Body.String() != `{"call": 3}` {
+		t.Errorf("no-cache should refresh: %s %s", rec.Header().Get("X-Cache"), rec.Body.String())
+	}
+	if rec := do("/api/v1/logs", body, nil); rec.Body.String() != `{"call": 3}` {
+		t.Errorf("no-cache should store the fresh response, got %s", rec.Body.String())
+	}
+	if rec := do("/api/v1/logs", body, map[string]string{"Cache-Control": "no-store"}); rec.Header().Get("X-Cache") != "BYPASS" || rec.Body.String() != `{"call": 4}` {
+		t.Errorf("no-store should bypass: %s %s", rec.Header().Get("X-Cache"), rec.Body.String())
+	}
+
+	do("/api/v1/logs?fail", body, nil)
+	if rec := do("/api/v1/logs?fail", body, nil); rec.Code != http.StatusInternalServerError || rec.Header().Get("X-Cache") != "MISS" {
+		t.Errorf("errors should not be cached: %d %s", rec.Code, rec.Header().Get("X-Cache"))
+	}
+}
+
+func TestResultCacheETag(t *testing.T) {
+	cache := newResultCache(config.QueryCacheConfig{Enabled: true, MaxSizeMiB: 1}, time.Minute)
+	handler := cache.wrap("/api/v1/services/stats", func(w http.ResponseWriter, r *http.Request) {
+		writeJSONWithETag(w, r, []ServiceStat{{ServiceName: "api"}})
+	})
+
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/api/v1/services/stats", nil))
+	etag := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK || etag == "" {
+		t.Fatalf("got %d with ETag %q", rec.Code, etag)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/services/stats", nil)
+	req.Header.Set("If-None-Match", etag)
+	rec = httptest.NewRecorder()
+	handler(rec, req)
+	if rec.Code != http.StatusNotModified || rec.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("cached response should answer If-None-Match with 304, got %d %s", rec.Code, rec.Header().Get("X-Cache"))
+	}
+}
+
+func TestNilResultCache(t *testing.T) {
+	if newResultCache(config.QueryCacheConfig{Enabled: false}, time.Minute) != nil {
+		t.Error("disabled cache should be nil")
+	}
+	if newResultCache(config.QueryCacheConfig{Enabled: true, MaxSizeMiB: 1}, 0) != nil {
+		t.Error("cache without a TTL should be nil")
+	}
+	var cache *resultCache
+	rec := httptest.NewRecorder()
+	cache.wrap("/api/v1/logs", func(w http.ResponseWriter, r *http.Request) {})(rec, httptest.NewRequest(http.MethodPost, "/api/v1/logs", nil))
+	if rec.Header().Get("X-Cache") != "" {
+		t.Error("nil cache should not touch the response")
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryStore(10)
+	store.set(ctx, "a", []byte("1234"), time.Minute)
+	store.set(ctx, "b", []byte("1234"), time.Minute)
+	store.get(ctx, "a")
+	// Evicts b, the least recently used
+	store.set(ctx, "c", []byte("1234"), time.Minute)
+	if _, ok, _ := store.get(ctx, "b"); ok {
+		t.Error("b should have been evicted")
+	}
+	if _, ok, _ := store.get(ctx, "a"); !ok {
+		t.Error("a should still be cached")
+	}
+	if store.bytes != 8 {
+		t.Errorf("bytes = %d, want 8", store.bytes)
+	}
+
+	store.set(ctx, "big", []byte("12345678901"), time.Minute)
+	if _, ok, _ := store.get(ctx, "big"); ok || store.bytes != 8 {
+		t.Error("values larger than the store should not be kept")
+	}
+
+	store.set(ctx, "expired", []byte("1"), -time.Second)
+	if _, ok, _ := store.get(ctx, "expired"); ok {
+		t.Error("expired entries should not be returned")
+	}
+}
+
+// fakeRedis serves GET and SET from a map over RESP
+func fakeRedis(t *testing.T) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lis.Close() })
+	data := make(map[string]string)
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				readLine := func() (int, error) {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return 0, err
+					}
+					return strconv.Atoi(strings.TrimSpace(line[1:]))
+				}
+				for {
+					n, err := readLine()
+					if err != nil {
+						return
+					}
+					args := make([]string, n)
+					for i := range args {
+						size, err := readLine()
+						if err != nil {
+							return
+						}
+						buf := make([]byte, size+2)
+						if _, err := io.ReadFull(r, buf); err != nil {
+							return
+						}
+						args[i] = string(buf[:size])
+					}
+					switch args[0] {
+					case "GET":
+						if v, ok := data[args[1]]; ok {
+							fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(v), v)
+						} else {
+							conn.Write([]byte("$-1\r\n"))
+						}
+					case "SET":
+						data[args[1]] = args[2]
+						conn.Write([]byte("+OK\r\n"))
+					default:
+						conn.Write([]byte("-ERR unknown command '" + args[0] + "'\r\n"))
+					}
+				}
+			}()
+		}
+	}()
+	return lis.Addr().String()
+}
+
+func TestRedisStore(t *testing.T) {
+	ctx := context.Background()
+	store := newRedisStore(fakeRedis(t), "", 0)
+
+	if _, ok, err := store.get(ctx, "missing"); ok || err != nil {
+		t.Errorf("get(missing) = %v, %v", ok, err)
+	}
+	value := "line one\r\nline two " + strconv.Itoa(42)
+	if err := store.set(ctx, "key", []byte(value), time.Minute); err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	got, ok, err := store.get(ctx, "key")
+	if err != nil || !ok || string(got) != value {
+		t.Errorf("get(key) = %q, %v, %v", got, ok, err)
+	}
+
+	// Error replies keep the connection usable
+	if _, err := store.do(ctx, "PING"); err == nil || !strings.Contains(err.Error(), "unknown command") {
+		t.Errorf("expected error reply, got %v", err)
+	}
+	if _, ok, err := store.get(ctx, "key"); !ok || err != nil {
+		t.Errorf("get after error reply = %v, %v", ok, err)
+	}
+}
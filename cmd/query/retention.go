@@ -0,0 +1,413 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"otelservices/internal/clickhouse"
+	"otelservices/internal/config"
+	"otelservices/internal/monitoring"
+)
+
+// retentionTable describes how a table's age is measured and partitioned
+type retentionTable struct {
+	Name    string
+	Class   string // traces, logs, metrics, metrics_5m or rollups
+	TimeCol string // DateTime expression the TTL is based on
+	Tenants bool   // has service_namespace, so tenant overrides apply
+	Daily   bool   // partitioned by day rather than month
+}
+
+var retentionTables = []retentionTable{
+	{Name: "otel_traces", Class: "traces", TimeCol: "toDateTime(timestamp)", Tenants: true, Daily: true},
+	{Name: "otel_trace_index", Class: "traces", TimeCol: "toDateTime(min_timestamp)", Daily: true},
+	{Name: "otel_service_operations", Class: "traces", TimeCol: "timestamp"},
+	{Name: "otel_logs", Class: "logs", TimeCol: "toDateTime(timestamp)", Tenants: true, Daily: true},
+	{Name: "otel_metrics", Class: "metrics", TimeCol: "toDateTime(timestamp)", Tenants: true, Daily: true},
+	{Name: "otel_metrics_5m", Class: "metrics_5m", TimeCol: "timestamp"},
+	{Name: "otel_metrics_1h", Class: "rollups", TimeCol: "timestamp"},
+	{Name: "otel_logs_errors_1h", Class: "rollups", TimeCol: "timestamp"},
+	{Name: "otel_service_dependencies_1h", Class: "rollups", TimeCol: "timestamp"},
+	{Name: "otel_span_stats_1h", Class: "rollups", TimeCol: "timestamp"},
+}
+
+// TenantTTL is the retention of one service namespace in a table
+type TenantTTL struct {
+	Namespace string `json:"namespace"`
+	Retention string `json:"retention"`
+}
+
+// TableRetention is the retention policy applied to one table
+type TableRetention struct {
+	Table     string      `json:"table"`
+	Class     string      `json:"class"`
+	Retention string      `json:"retention"` // 0s when data is only moved
+	Tenants   []TenantTTL `json:"tenants,omitempty"`
+	MoveAfter string      `json:"move_after,omitempty"` // age at which data moves to Volume
+	Volume    string      `json:"volume,omitempty"`
+	TTL       string      `json:"ttl"`
+}
+
+type RetentionResponse struct {
+	Tables []TableRetention `json:"tables"`
+}
+
+// DroppedPartition is a partition removed by a purge
+type DroppedPartition struct {
+	Table       string `json:"table"`
+	PartitionID string `json:"partition_id"`
+}
+
+type PurgeResponse struct {
+	Dropped []DroppedPartition `json:"dropped"`
+}
+
+// tablePolicy is the resolved retention and tiering of a table
+type tablePolicy struct {
+	table     retentionTable
+	retention time.Duration
+	tenants   []tenantPolicy
+	moveAfter time.Duration // 0 keeps the data on its first volume
+	volume    string
+}
+
+type tenantPolicy struct {
+	namespace string
+	retention time.Duration
+}
+
+// retentionManager applies per-signal and per-tenant TTLs to the tables and
+// optionally drops partitions that are entirely past retention, which frees
+// disk sooner than TTL merges do. With tiering, the TTLs also move older
+// trace and log parts to a slower volume.
+type retentionManager struct {
+	cfg      config.RetentionConfig
+	chClient *clickhouse.Client
+}
+
+func newRetentionManager(cfg config.RetentionConfig, chClient *clickhouse.Client) *retentionManager {
+	return &retentionManager{cfg: cfg, chClient: chClient}
+}
+
+func (m *retentionManager) classRetention(class string) time.Duration {
+	switch class {
+	case "traces":
+		return m.cfg.Traces
+	case "logs":
+		return m.cfg.Logs
+	case "metrics":
+		return m.cfg.Metrics
+	case "metrics_5m":
+		return m.cfg.Metrics5m
+	case "rollups":
+		return m.cfg.Rollups
+	}
+	return 0
+}
+
+// classMoveAfter returns the age at which a class's data moves to the tiering
+// volume, or 0
+func (m *retentionManager) classMoveAfter(class string) time.Duration {
+	if m.cfg.Tiering.Volume == "" {
+		return 0
+	}
+	switch class {
+	case "traces":
+		return m.cfg.Tiering.Traces
+	case "logs":
+		return m.cfg.Tiering.Logs
+	}
+	return 0
+}
+
+func tenantRetention(t config.TenantRetention, class string) time.Duration {
+	switch class {
+	case "traces":
+		return t.Traces
+	case "logs":
+		return t.Logs
+	case "metrics":
+		return t.Metrics
+	}
+	return 0
+}
+
+// policies resolves the retention of every managed table. Tables whose class
+// has neither retention nor tiering configured are left alone.
+func (m *retentionManager) policies() []tablePolicy {
+	var policies []tablePolicy
+	for _, table := range retentionTables {
+		retention := m.classRetention(table.Class)
+		moveAfter := m.classMoveAfter(table.Class)
+		if retention <= 0 && moveAfter <= 0 {
+			continue
+		}
+		p := tablePolicy{table: table, retention: retention, moveAfter: moveAfter}
+		if moveAfter > 0 {
+			p.volume = m.cfg.Tiering.Volume
+		}
+		if table.Tenants && retention > 0 {
+			for _, t := range m.cfg.Tenants {
+				if r := tenantRetention(t, table.Class); r > 0 && r != retention {
+					p.tenants = append(p.tenants, tenantPolicy{namespace: t.Namespace, retention: r})
+				}
+			}
+			sort.Slice(p.tenants, func(i, j int) bool { return p.tenants[i].namespace < p.tenants[j].namespace })
+		}
+		policies = append(policies, p)
+	}
+	return policies
+}
+
+// interval renders a retention period as a ClickHouse interval, in days when
+// it is a whole number of days
+func interval(d time.Duration) string {
+	if d%(24*time.Hour) == 0 {
+		return fmt.Sprintf("INTERVAL %d DAY", d/(24*time.Hour))
+	}
+	return fmt.Sprintf("INTERVAL %d SECOND", d/time.Second)
+}
+
+// ttlClause builds the TTL expression for a table. Tenant overrides get their
+// own DELETE rule and are excluded from the default rule, so a tenant can
+// keep data longer as well as shorter than the default. Tiering adds a move
+// rule ahead of them. Namespaces and volume names are validated by the
+// config package before they are embedded.
+func (p tablePolicy) ttlClause() string {
+	var rules []string
+	if p.moveAfter > 0 {
+		rules = append(rules, fmt.Sprintf("%s + %s TO VOLUME '%s'", p.table.TimeCol, interval(p.moveAfter), p.volume))
+	}
+	if p.retention <= 0 {
+		return strings.Join(rules, ", ")
+	}
+	if len(p.tenants) == 0 {
+		rules = append(rules, fmt.Sprintf("%s + %s", p.table.TimeCol, interval(p.retention)))
+		return strings.Join(rules, ", ")
+	}
+	quoted := make([]string, len(p.tenants))
+	for i, t := range p.tenants {
+		quoted[i] = "'" + t.namespace + "'"
+		rules = append(rules, fmt.Sprintf("%s + %s DELETE WHERE service_namespace = %s",
+			p.table.TimeCol, interval(t.retention), quoted[i]))
+	}
+	rules = append(rules, fmt.Sprintf("%s + %s DELETE WHERE service_namespace NOT IN (%s)",
+		p.table.TimeCol, interval(p.retention), strings.Join(quoted, ", ")))
+	return strings.Join(rules, ", ")
+}
+
+// maxRetention is the longest retention of any row in the table; only
+// partitions older than that can be dropped whole
+func (p tablePolicy) maxRetention() time.Duration {
+	max := p.retention
+	for _, t := range p.tenants {
+		if t.retention > max {
+			max = t.retention
+		}
+	}
+	return max
+}
+
+func (p tablePolicy) toResponse() TableRetention {
+	tr := TableRetention{
+		Table:     p.table.Name,
+		Class:     p.table.Class,
+		Retention: p.retention.String(),
+		Volume:    p.volume,
+		TTL:       p.ttlClause(),
+	}
+	if p.moveAfter > 0 {
+		tr.MoveAfter = p.moveAfter.String()
+	}
+	for _, t := range p.tenants {
+		tr.Tenants = append(tr.Tenants, TenantTTL{Namespace: t.namespace, Retention: t.retention.String()})
+	}
+	return tr
+}
+
+// apply sets the TTL of every managed table. ClickHouse applies a modified
+// TTL to existing parts in the background.
+func (m *retentionManager) apply(ctx context.Context) ([]TableRetention, error) {
+	applied := []TableRetention{}
+	for _, p := range m.policies() {
+		if p.moveAfter > 0 && m.cfg.Tiering.StoragePolicy != "" {
+			if err := m.setStoragePolicy(ctx, p.table.Name); err != nil {
+				return applied, err
+			}
+		}
+		stmt := fmt.Sprintf("ALTER TABLE %s MODIFY TTL %s", m.chClient.AlterTable(p.table.Name), p.ttlClause())
+		if err := m.chClient.Exec(ctx, stmt); err != nil {
+			return applied, fmt.Errorf("failed to set TTL on %s: %w", p.table.Name, err)
+		}
+		applied = append(applied, p.toResponse())
+		logger.Info("Retention set", "table", p.table.Name, "retention", p.retention, "move_after", p.moveAfter)
+	}
+	return applied, nil
+}
+
+// setStoragePolicy switches a table to the tiering storage policy. ClickHouse
+// only allows this when the new policy keeps every disk of the current one.
+func (m *retentionManager) setStoragePolicy(ctx context.Context, table string) error {
+	policy := m.cfg.Tiering.StoragePolicy
+	var current string
+	row := m.chClient.QueryRow(ctx, `
+		SELECT storage_policy
+		FROM system.tables
+		WHERE database = currentDatabase() AND name = ?
+	`, m.chClient.LocalTable(table))
+	if err := row.Scan(&current); err != nil {
+		return fmt.Errorf("failed to read storage policy of %s: %w", table, err)
+	}
+	if current == policy {
+		return nil
+	}
+	// The policy name is validated by the config package
+	stmt := fmt.Sprintf("ALTER TABLE %s MODIFY SETTING storage_policy = '%s'", m.chClient.AlterTable(table), policy)
+	if err := m.chClient.Exec(ctx, stmt); err != nil {
+		return fmt.Errorf("failed to set storage policy of %s: %w", table, err)
+	}
+	logger.Info("Storage policy set", "table", table, "storage_policy", policy)
+	return nil
+}
+
+var partitionIDPattern = regexp.MustCompile(`^\d{6}(\d{2})?$`)
+
+// partitionEnd returns the end of the day (YYYYMMDD) or month (YYYYMM)
+// covered by a partition ID
+func partitionEnd(id string, daily bool) (time.Time, bool) {
+	if !partitionIDPattern.MatchString(id) || (len(id) == 8) != daily {
+		return time.Time{}, false
+	}
+	if daily {
+		t, err := time.Parse("20060102", id)
+		if err != nil {
+			return time.Time{}, false
+		}
+		return t.AddDate(0, 0, 1), true
+	}
+	t, err := time.Parse("200601", id)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t.AddDate(0, 1, 0), true
+}
+
+// expiredPartitions returns the partitions whose whole range is past the
+// table's longest retention
+func expiredPartitions(p tablePolicy, ids []string, now time.Time) []string {
+	cutoff := now.Add(-p.maxRetention())
+	var expired []string
+	for _, id := range ids {
+		if end, ok := partitionEnd(id, p.table.Daily); ok && !end.After(cutoff) {
+			expired = append(expired, id)
+		}
+	}
+	return expired
+}
+
+// purge drops the expired partitions of every managed table
+func (m *retentionManager) purge(ctx context.Context, now time.Time) ([]DroppedPartition, error) {
+	dropped := []DroppedPartition{}
+	for _, p := range m.policies() {
+		if p.retention <= 0 {
+			continue
+		}
+		ids, err := m.partitionIDs(ctx, p.table.Name)
+		if err != nil {
+			return dropped, err
+		}
+		for _, id := range expiredPartitions(p, ids, now) {
+			// IDs are validated digits, so they are safe to embed
+			stmt := fmt.Sprintf("ALTER TABLE %s DROP PARTITION ID '%s'", m.chClient.AlterTable(p.table.Name), id)
+			if err := m.chClient.Exec(ctx, stmt); err != nil {
+				return dropped, fmt.Errorf("failed to drop partition %s of %s: %w", id, p.table.Name, err)
+			}
+			dropped = append(dropped, DroppedPartition{Table: p.table.Name, PartitionID: id})
+			monitoring.RetentionPartitionsDropped.WithLabelValues(p.table.Name).Inc()
+			logger.Info("Dropped expired partition", "partition", id, "table", p.table.Name)
+		}
+	}
+	return dropped, nil
+}
+
+func (m *retentionManager) partitionIDs(ctx context.Context, table string) ([]string, error) {
+	// On a cluster the partitions of every replica are listed
+	rows, err := m.chClient.Query(ctx, fmt.Sprintf(`
+		SELECT DISTINCT partition_id
+		FROM %s
+		WHERE database = currentDatabase() AND table = ? AND active
+	`, m.chClient.SystemTable("system.parts")), m.chClient.LocalTable(table))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list partitions of %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan partition: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// run purges expired partitions every purge_interval until ctx is done
+func (m *retentionManager) run(ctx context.Context) {
+	if m.cfg.PurgeInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(m.cfg.PurgeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if _, err := m.purge(ctx, now); err != nil {
+				logger.Error("Error purging expired partitions", "error", err)
+			}
+		}
+	}
+}
+
+// GetRetention returns the retention policy of every managed table
+func (s *QueryService) GetRetention(w http.ResponseWriter, r *http.Request) {
+	response := RetentionResponse{Tables: []TableRetention{}}
+	for _, p := range s.retention.policies() {
+		response.Tables = append(response.Tables, p.toResponse())
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// ApplyRetention sets the configured TTLs on the tables
+func (s *QueryService) ApplyRetention(w http.ResponseWriter, r *http.Request) {
+	applied, err := s.retention.apply(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		monitoring.QueryErrors.WithLabelValues("retention").Inc()
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(RetentionResponse{Tables: applied})
+}
+
+// PurgeRetention drops partitions that are entirely past retention
+func (s *QueryService) PurgeRetention(w http.ResponseWriter, r *http.Request) {
+	dropped, err := s.retention.purge(r.Context(), time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		monitoring.QueryErrors.WithLabelValues("retention").Inc()
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(PurgeResponse{Dropped: dropped})
+}
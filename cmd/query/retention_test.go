@@ -0,0 +1,148 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"otelservices/internal/config"
+)
+
+const day = 24 * time.Hour
+
+func testRetentionManager() *retentionManager {
+	return newRetentionManager(config.RetentionConfig{
+		Traces:  7 * day,
+		Logs:    14 * day,
+		Metrics: 30 * day,
+		Rollups: 365 * day,
+		Tenants: []config.TenantRetention{
+			{Namespace: "team-b", Traces: 30 * day},
+			{Namespace: "team-a", Traces: 3 * day, Logs: 14 * day},
+		},
+	}, nil)
+}
+
+func findPolicy(t *testing.T, policies []tablePolicy, table string) tablePolicy {
+	t.Helper()
+	for _, p := range policies {
+		if p.table.Name == table {
+			return p
+		}
+	}
+	t.Fatalf("no policy for %s", table)
+	return tablePolicy{}
+}
+
+func TestRetentionPolicies(t *testing.T) {
+	policies := testRetentionManager().policies()
+
+	for _, p := range policies {
+		if p.table.Name == "otel_metrics_5m" {
+			t.Error("tables without a configured retention should not be managed")
+		}
+	}
+
+	traces := findPolicy(t, policies, "otel_traces")
+	want := "toDateTime(timestamp) + INTERVAL 3 DAY DELETE WHERE service_namespace = 'team-a', " +
+		"toDateTime(timestamp) + INTERVAL 30 DAY DELETE WHERE service_namespace = 'team-b', " +
+		"toDateTime(timestamp) + INTERVAL 7 DAY DELETE WHERE service_namespace NOT IN ('team-a', 'team-b')"
+	if got := traces.ttlClause(); got != want {
+		t.Errorf("traces TTL:\n got %s\nwant %s", got, want)
+	}
+	if traces.maxRetention() != 30*day {
+		t.Errorf("max retention = %s, want 720h", traces.maxRetention())
+	}
+
+	// A tenant override equal to the default needs no rule of its own
+	logs := findPolicy(t, policies, "otel_logs")
+	if got := logs.ttlClause(); got != "toDateTime(timestamp) + INTERVAL 14 DAY" {
+		t.Errorf("logs TTL = %s", got)
+	}
+
+	// Tenant overrides only apply to tables with service_namespace
+	index := findPolicy(t, policies, "otel_trace_index")
+	if got := index.ttlClause(); got != "toDateTime(min_timestamp) + INTERVAL 7 DAY" {
+		t.Errorf("trace index TTL = %s", got)
+	}
+}
+
+func TestInterval(t *testing.T) {
+	if got := interval(7 * day); got != "INTERVAL 7 DAY" {
+		t.Errorf("interval(7d) = %s", got)
+	}
+	if got := interval(36 * time.Hour); got != "INTERVAL 129600 SECOND" {
+		t.Errorf("interval(36h) = %s", got)
+	}
+}
+
+func TestPartitionEnd(t *testing.T) {
+	end, ok := partitionEnd("20240131", true)
+	if !ok || !end.Equal(time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("daily partition end = %v, %v", end, ok)
+	}
+	end, ok = partitionEnd("202412", false)
+	if !ok || !end.Equal(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("monthly partition end = %v, %v", end, ok)
+	}
+	for _, id := range []string{"all", "202401", "2024013", "20241340"} {
+		if _, ok := partitionEnd(id, true); ok {
+			t.Errorf("partitionEnd(%q, daily) should fail", id)
+		}
+	}
+}
+
+func TestExpiredPartitions(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	traces := findPolicy(t, testRetentionManager().policies(), "otel_traces")
+
+	// team-b keeps traces for 30 days, so nothing newer can be dropped whole
+	ids := []string{"20240201", "20240208", "20240209", "20240210", "20240309"}
+	got := expiredPartitions(traces, ids, now)
+	if want := []string{"20240201", "20240208"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expired = %v, want %v", got, want)
+	}
+
+	rollups := findPolicy(t, testRetentionManager().policies(), "otel_metrics_1h")
+	got = expiredPartitions(rollups, []string{"202302", "202303", "202304"}, now)
+	if want := []string{"202302"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expired rollups = %v, want %v", got, want)
+	}
+}
+
+func TestTieringPolicies(t *testing.T) {
+	m := testRetentionManager()
+	m.cfg.Metrics = 0
+	m.cfg.Tiering = config.TieringConfig{Volume: "cold", Traces: 2 * day, Logs: 3 * day}
+	policies := m.policies()
+
+	traces := findPolicy(t, policies, "otel_traces")
+	want := "toDateTime(timestamp) + INTERVAL 2 DAY TO VOLUME 'cold', " +
+		"toDateTime(timestamp) + INTERVAL 3 DAY DELETE WHERE service_namespace = 'team-a', " +
+		"toDateTime(timestamp) + INTERVAL 30 DAY DELETE WHERE service_namespace = 'team-b', " +
+		"toDateTime(timestamp) + INTERVAL 7 DAY DELETE WHERE service_namespace NOT IN ('team-a', 'team-b')"
+	if got := traces.ttlClause(); got != want {
+		t.Errorf("traces TTL:\n got %s\nwant %s", got, want)
+	}
+	logs := findPolicy(t, policies, "otel_logs")
+	if got := logs.ttlClause(); got != "toDateTime(timestamp) + INTERVAL 3 DAY TO VOLUME 'cold', toDateTime(timestamp) + INTERVAL 14 DAY" {
+		t.Errorf("logs TTL = %s", got)
+	}
+	if r := logs.toResponse(); r.MoveAfter != "72h0m0s" || r.Volume != "cold" {
+		t.Errorf("logs response = %+v", r)
+	}
+
+	// Metrics are not tiered, so without retention they stay unmanaged
+	for _, p := range policies {
+		if p.table.Name == "otel_metrics" {
+			t.Error("otel_metrics should not be managed")
+		}
+	}
+
+	// Tiering alone manages a table without deleting from it
+	m.cfg.Logs = 0
+	logs = findPolicy(t, m.policies(), "otel_logs")
+	if got := logs.ttlClause(); got != "toDateTime(timestamp) + INTERVAL 3 DAY TO VOLUME 'cold'" {
+		t.Errorf("move-only logs TTL = %s", got)
+	}
+}
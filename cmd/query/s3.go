@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"otelservices/internal/config"
+	"otelservices/internal/sigv4"
+)
+
+// s3Client uploads objects to an S3-compatible bucket with path-style URLs
+// and AWS Signature Version 4, which S3, MinIO and GCS interoperability all
+// accept
+type s3Client struct {
+	cfg    config.S3Config
+	client *http.Client
+}
+
+func newS3Client(cfg config.S3Config) *s3Client {
+	return &s3Client{cfg: cfg, client: &http.Client{Timeout: 10 * time.Minute}}
+}
+
+// location returns the s3:// URL of an object key
+func (c *s3Client) location(key string) string {
+	return "s3://" + c.cfg.Bucket + "/" + key
+}
+
+// put uploads body as the object key, prefixed with the configured prefix,
+// and returns the full key
+func (c *s3Client) put(ctx context.Context, key, contentType string, body []byte) (string, error) {
+	key = c.cfg.Prefix + key
+	target := strings.TrimRight(c.cfg.Endpoint, "/") + "/" + c.cfg.Bucket + "/" + escapePath(key)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, target, bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("failed to build s3 request: %w", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	sum := sha256.Sum256(body)
+	c.sign(req, hex.EncodeToString(sum[:]), time.Now())
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to upload %s: %w", c.location(key), err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("failed to upload %s: %s: %s", c.location(key), resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return key, nil
+}
+
+// sign adds the x-amz-date, x-amz-content-sha256 and Authorization headers,
+// signing the host and every header already set on the request
+func (c *s3Client) sign(req *http.Request, payloadHash string, now time.Time) {
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	creds := sigv4.Credentials{AccessKeyID: c.cfg.AccessKeyID, SecretAccessKey: c.cfg.SecretAccessKey}
+	sigv4.Sign(req, payloadHash, creds, c.cfg.Region, "s3", now)
+}
+
+// escapePath escapes each segment of an object key, keeping the slashes
+func escapePath(key string) string {
+	segments := strings.Split(key, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return strings.Join(segments, "/")
+}
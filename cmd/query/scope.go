@@ -0,0 +1,56 @@
+package main
+
+import (
+	queryv1 "otelservices/proto/query/v1"
+)
+
+// scopeColumnList selects the instrumentation scope of a span or log record
+const scopeColumnList = `instrumentation_scope_name, instrumentation_scope_version, scope_attributes`
+
+// spanFidelityColumns selects the OTLP fields of a span kept for fidelity
+// rather than search: trace state, dropped counts and scope
+const spanFidelityColumns = `trace_state, dropped_attributes_count, dropped_events_count, dropped_links_count,
+			` + scopeColumnList
+
+// InstrumentationScope is the library that emitted a span or log record, as
+// received over OTLP
+type InstrumentationScope struct {
+	Name       string            `json:"name"`
+	Version    string            `json:"version,omitempty"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// scopeColumns holds the scan destinations for scopeColumnList
+type scopeColumns struct {
+	name       string
+	version    string
+	attributes map[string]string
+}
+
+// scope returns the scope of the scanned columns, or nil for items
+// received without one
+func (c *scopeColumns) scope() *InstrumentationScope {
+	if c.name == "" && c.version == "" && len(c.attributes) == 0 {
+		return nil
+	}
+	scope := &InstrumentationScope{Name: c.name, Version: c.version}
+	if len(c.attributes) > 0 {
+		scope.Attributes = c.attributes
+	}
+	return scope
+}
+
+// fidelityDest returns the scan destinations for spanFidelityColumns
+func (s *Span) fidelityDest(scope *scopeColumns) []interface{} {
+	return []interface{}{
+		&s.TraceState, &s.DroppedAttributesCount, &s.DroppedEventsCount, &s.DroppedLinksCount,
+		&scope.name, &scope.version, &scope.attributes,
+	}
+}
+
+func (scope *InstrumentationScope) toProto() *queryv1.InstrumentationScope {
+	if scope == nil {
+		return nil
+	}
+	return &queryv1.InstrumentationScope{Name: scope.Name, Version: scope.Version, Attributes: scope.Attributes}
+}
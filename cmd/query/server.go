@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"otelservices/internal/config"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+const (
+	// drainPollInterval is how often shutdown checks for requests in flight
+	drainPollInterval = 50 * time.Millisecond
+	// cancelGrace is how long requests still running at the shutdown timeout
+	// get to return after their contexts are cancelled
+	cancelGrace = 2 * time.Second
+)
+
+// httpServer serves the query API over HTTP/1.1 and, with server.h2c,
+// cleartext HTTP/2. It counts the requests in flight itself, as
+// http.Server.Shutdown does not wait for those on h2c connections, which
+// are hijacked from it.
+type httpServer struct {
+	*http.Server
+	active atomic.Int64
+	cancel context.CancelFunc // cancels the contexts of all requests
+}
+
+// newHTTPServer creates the query API server. WriteTimeout is applied to
+// each write of a response rather than to the whole response, so slow
+// queries and long streamed responses are not cut off while they are still
+// making progress, and a client that stops reading is still dropped.
+func newHTTPServer(cfg config.ServerConfig, handler http.Handler) (*httpServer, error) {
+	baseCtx, cancel := context.WithCancel(context.Background())
+	s := &httpServer{cancel: cancel}
+	s.Server = &http.Server{
+		Addr:           fmt.Sprintf(":%d", cfg.Port),
+		ReadTimeout:    cfg.ReadTimeout,
+		IdleTimeout:    cfg.IdleTimeout,
+		MaxHeaderBytes: cfg.MaxHeaderBytes,
+		BaseContext:    func(net.Listener) context.Context { return baseCtx },
+	}
+	handler = s.track(writeDeadlines(cfg.WriteTimeout, handler))
+	if cfg.H2C {
+		h2s := &http2.Server{IdleTimeout: cfg.IdleTimeout}
+		// Registers the HTTP/2 connections for a GOAWAY on Shutdown
+		if err := http2.ConfigureServer(s.Server, h2s); err != nil {
+			return nil, err
+		}
+		handler = h2c.NewHandler(handler, h2s)
+	}
+	s.Handler = handler
+	return s, nil
+}
+
+// track counts the requests in flight
+func (s *httpServer) track(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.active.Add(1)
+		defer s.active.Add(-1)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// drain stops accepting connections, closes idle ones and waits for the
+// requests in flight to complete, streamed responses included, until ctx
+// is done. Requests still running then have their contexts cancelled, so
+// their queries stop and handlers return, and are given cancelGrace before
+// the remaining connections are closed.
+func (s *httpServer) drain(ctx context.Context) error {
+	err := s.Shutdown(ctx)
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for s.active.Load() > 0 && ctx.Err() == nil {
+		select {
+		case <-ctx.Done():
+		case <-ticker.C:
+		}
+	}
+	if s.active.Load() == 0 {
+		return err
+	}
+
+	logger.Warn("Cancelling requests still running at the shutdown timeout", "requests", s.active.Load())
+	s.cancel()
+	deadline := time.Now().Add(cancelGrace)
+	for s.active.Load() > 0 && time.Now().Before(deadline) {
+		<-ticker.C
+	}
+	s.Close()
+	return ctx.Err()
+}
+
+// writeDeadlines moves the write deadline of a response timeout past each
+// write, so timeout bounds how long a client may take to accept a write
+// instead of how long the whole response takes. 0 leaves writes unbounded.
+func writeDeadlines(timeout time.Duration, next http.Handler) http.Handler {
+	if timeout <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&deadlineWriter{ResponseWriter: w, rc: http.NewResponseController(w), timeout: timeout}, r)
+	})
+}
+
+type deadlineWriter struct {
+	http.ResponseWriter
+	rc      *http.ResponseController
+	timeout time.Duration
+}
+
+func (w *deadlineWriter) extend() {
+	// Writers that do not support deadlines are left unbounded
+	_ = w.rc.SetWriteDeadline(time.Now().Add(w.timeout))
+}
+
+func (w *deadlineWriter) WriteHeader(status int) {
+	w.extend()
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *deadlineWriter) Write(b []byte) (int, error) {
+	w.extend()
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush lets streaming handlers flush through the writer
+func (w *deadlineWriter) Flush() {
+	w.extend()
+	w.rc.Flush()
+}
+
+// Unwrap gives http.ResponseController access to the underlying writer
+func (w *deadlineWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"otelservices/internal/config"
+
+	"golang.org/x/net/http2"
+)
+
+// startHTTPServer serves handler with the server settings of cfg on a
+// local port and returns its base URL
+func startHTTPServer(t *testing.T, cfg config.ServerConfig, handler http.Handler) (*httpServer, string) {
+	t.Helper()
+	srv, err := newHTTPServer(cfg, handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(lis)
+	t.Cleanup(func() { srv.Close() })
+	return srv, "http://" + lis.Addr().String()
+}
+
+// h2cClient speaks HTTP/2 with prior knowledge over plain TCP
+func h2cClient() *http.Client {
+	return &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+	}}
+}
+
+func TestWriteTimeoutPerWrite(t *testing.T) {
+	cfg := config.DefaultConfig().Server
+	cfg.WriteTimeout = 100 * time.Millisecond
+	_, url := startHTTPServer(t, cfg, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// A slow query followed by a response streamed past the timeout
+		time.Sleep(250 * time.Millisecond)
+		for i := 0; i < 3; i++ {
+			fmt.Fprintf(w, "chunk %d\n", i)
+			w.(http.Flusher).Flush()
+			time.Sleep(60 * time.Millisecond)
+		}
+	}))
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil || string(body) != "chunk 0\nchunk 1\nchunk 2\n" {
+		t.Errorf("body %q, err %v", body, err)
+	}
+}
+
+func TestH2C(t *testing.T) {
+	cfg := config.DefaultConfig().Server
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Proto)
+	})
+	for _, enabled := range []bool{true, false} {
+		cfg.H2C = enabled
+		_, url := startHTTPServer(t, cfg, handler)
+		resp, err := h2cClient().Get(url)
+		if !enabled {
+			if err == nil {
+				resp.Body.Close()
+				t.Error("HTTP/2 with prior knowledge served with h2c disabled")
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != "HTTP/2.0" {
+			t.Errorf("served over %s", body)
+		}
+	}
+}
+
+func TestDrainWaitsForStreams(t *testing.T) {
+	cfg := config.DefaultConfig().Server
+	cfg.H2C = true
+	started, release := make(chan struct{}), make(chan struct{})
+	srv, url := startHTTPServer(t, cfg, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "first ")
+		w.(http.Flusher).Flush()
+		close(started)
+		<-release
+		fmt.Fprint(w, "last")
+	}))
+
+	// Over HTTP/2 the connection is hijacked, so only the server's own
+	// count of requests keeps drain waiting
+	result := make(chan string, 1)
+	go func() {
+		resp, err := h2cClient().Get(url)
+		if err != nil {
+			result <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		result <- string(body)
+	}()
+	<-started
+
+	drained := make(chan error, 1)
+	go func() { drained <- srv.drain(context.Background()) }()
+	select {
+	case err := <-drained:
+		t.Fatalf("drain returned with a response in flight: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+	close(release)
+	if err := <-drained; err != nil {
+		t.Errorf("drain: %v", err)
+	}
+	if body := <-result; body != "first last" {
+		t.Errorf("body %q", body)
+	}
+}
+
+func TestDrainCancelsAtTimeout(t *testing.T) {
+	started := make(chan struct{})
+	srv, url := startHTTPServer(t, config.DefaultConfig().Server, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-r.Context().Done()
+	}))
+	go http.Get(url)
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := srv.drain(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("drain: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > cancelGrace {
+		t.Errorf("drain took %s; the request was not cancelled", elapsed)
+	}
+	if n := srv.active.Load(); n != 0 {
+		t.Errorf("%d requests still running", n)
+	}
+}
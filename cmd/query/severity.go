@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"otelservices/internal/models"
+)
+
+// severityFilter returns the WHERE clause for the severity fields of a logs
+// request. Severity accepts a level name matching that level ("warning"
+// matches 13 to 16) or a level followed by "+" matching it and above
+// ("WARN+"); other texts are compared with severity_text as stored.
+// SeverityMin and SeverityMax bound severity_number and narrow the range.
+func severityFilter(req *LogsQueryRequest) (string, []interface{}, error) {
+	min, max := req.SeverityMin, req.SeverityMax
+	clause := ""
+	var args []interface{}
+
+	if text := strings.TrimSpace(req.Severity); text != "" {
+		if level := strings.TrimSuffix(text, "+"); level != text {
+			levelMin, _, ok := models.SeverityRange(level)
+			if !ok {
+				return "", nil, fmt.Errorf("unknown severity level %q", level)
+			}
+			if levelMin > min {
+				min = levelMin
+			}
+		} else if levelMin, levelMax, ok := models.SeverityRange(text); ok {
+			if levelMin > min {
+				min = levelMin
+			}
+			if max == 0 || levelMax < max {
+				max = levelMax
+			}
+		} else {
+			clause += " AND severity_text = ?"
+			args = append(args, text)
+		}
+	}
+
+	if min > models.SeverityMax || max > models.SeverityMax {
+		return "", nil, fmt.Errorf("severity must be between 1 and %d", models.SeverityMax)
+	}
+	if max != 0 && min > max {
+		return "", nil, fmt.Errorf("severity range %d to %d is empty", min, max)
+	}
+	if min > 0 {
+		clause += " AND severity_number >= ?"
+		args = append(args, min)
+	}
+	if max > 0 {
+		clause += " AND severity_number <= ?"
+		args = append(args, max)
+	}
+	return clause, args, nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSeverityFilter(t *testing.T) {
+	tests := []struct {
+		name       string
+		req        LogsQueryRequest
+		wantClause string
+		wantArgs   []interface{}
+		wantErr    bool
+	}{
+		{name: "none", req: LogsQueryRequest{}},
+		{
+			name:       "level name",
+			req:        LogsQueryRequest{Severity: "warning"},
+			wantClause: " AND severity_number >= ? AND severity_number <= ?",
+			wantArgs:   []interface{}{uint8(13), uint8(16)},
+		},
+		{
+			name:       "level and above",
+			req:        LogsQueryRequest{Severity: "WARN+"},
+			wantClause: " AND severity_number >= ?",
+			wantArgs:   []interface{}{uint8(13)},
+		},
+		{
+			name:       "numeric range",
+			req:        LogsQueryRequest{SeverityMin: 9, SeverityMax: 20},
+			wantClause: " AND severity_number >= ? AND severity_number <= ?",
+			wantArgs:   []interface{}{uint8(9), uint8(20)},
+		},
+		{
+			name:       "level narrowed by max",
+			req:        LogsQueryRequest{Severity: "INFO+", SeverityMax: 16},
+			wantClause: " AND severity_number >= ? AND severity_number <= ?",
+			wantArgs:   []interface{}{uint8(9), uint8(16)},
+		},
+		{
+			name:       "unknown text",
+			req:        LogsQueryRequest{Severity: "verbose"},
+			wantClause: " AND severity_text = ?",
+			wantArgs:   []interface{}{"verbose"},
+		},
+		{name: "unknown level and above", req: LogsQueryRequest{Severity: "loud+"}, wantErr: true},
+		{name: "out of range", req: LogsQueryRequest{SeverityMin: 25}, wantErr: true},
+		{name: "empty range", req: LogsQueryRequest{Severity: "ERROR", SeverityMax: 13}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clause, args, err := severityFilter(&tt.req)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("severityFilter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if clause != tt.wantClause {
+				t.Errorf("clause = %q, want %q", clause, tt.wantClause)
+			}
+			if len(args) != 0 || len(tt.wantArgs) != 0 {
+				if !reflect.DeepEqual(args, tt.wantArgs) {
+					t.Errorf("args = %v, want %v", args, tt.wantArgs)
+				}
+			}
+		})
+	}
+}
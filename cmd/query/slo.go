@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"otelservices/internal/clickhouse"
+	"otelservices/internal/config"
+	"otelservices/internal/monitoring"
+
+	"github.com/gorilla/mux"
+)
+
+// SLOBurnRate is the rate the error budget was spent at over a window: 1
+// spends exactly the budget over the objective's window, 14.4 spends 2% of
+// a 30 day budget in an hour
+type SLOBurnRate struct {
+	Window      string  `json:"window"`
+	Requests    uint64  `json:"requests"`
+	BadRequests uint64  `json:"bad_requests"`
+	BurnRate    float64 `json:"burn_rate"`
+}
+
+// SLOStatus is an objective and its last evaluation
+type SLOStatus struct {
+	Name               string  `json:"name"`
+	ServiceName        string  `json:"service_name"`
+	Operation          string  `json:"operation,omitempty"`
+	Target             float64 `json:"target"`
+	LatencyThresholdMs float64 `json:"latency_threshold_ms,omitempty"`
+	Window             string  `json:"window"`
+
+	EvaluatedAt *time.Time `json:"evaluated_at,omitempty"`
+	Requests    uint64     `json:"requests"`
+	BadRequests uint64     `json:"bad_requests"`
+	// Compliance is the fraction of good requests over the window, 1
+	// without requests
+	Compliance float64 `json:"compliance"`
+	// ErrorBudgetRemaining is the fraction of the error budget left,
+	// negative once the objective is missed
+	ErrorBudgetRemaining float64       `json:"error_budget_remaining"`
+	BurnRates            []SLOBurnRate `json:"burn_rates"`
+}
+
+// SLOsResponse lists the configured objectives
+type SLOsResponse struct {
+	Enabled     bool        `json:"enabled"`
+	EvaluatedAt *time.Time  `json:"evaluated_at,omitempty"`
+	SLOs        []SLOStatus `json:"slos"`
+}
+
+// sloCounts is the number of requests and bad requests in one window
+type sloCounts struct {
+	requests uint64
+	bad      uint64
+}
+
+// windowLabel renders a window in its largest whole unit, e.g. 30d or 5m
+func windowLabel(d time.Duration) string {
+	switch {
+	case d%(24*time.Hour) == 0:
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	case d%time.Minute == 0:
+		return fmt.Sprintf("%dm", d/time.Minute)
+	}
+	return d.String()
+}
+
+// buildSLOQuery counts an objective's requests and bad requests in the
+// objective's window and each burn window, all ending at now. The latency
+// threshold comes from the config and is embedded as an integer.
+func buildSLOQuery(o config.SLOObjective, burnWindows []time.Duration, table string, now time.Time) (string, []interface{}) {
+	bad := "status_code = 'error'"
+	if o.LatencyThreshold > 0 {
+		bad = fmt.Sprintf("(status_code = 'error' OR duration_ns > %d)", o.LatencyThreshold.Nanoseconds())
+	}
+
+	windows := append([]time.Duration{o.Window}, burnWindows...)
+	longest := o.Window
+	var columns []string
+	var args []interface{}
+	for _, w := range windows {
+		columns = append(columns, "countIf(timestamp >= ?)", fmt.Sprintf("countIf(timestamp >= ? AND %s)", bad))
+		args = append(args, now.Add(-w), now.Add(-w))
+		if w > longest {
+			longest = w
+		}
+	}
+
+	where := []string{"service_name = ?", "span_kind IN ('server', 'consumer')", "timestamp >= ?", "timestamp < ?"}
+	args = append(args, o.Service, now.Add(-longest), now)
+	if o.Operation != "" {
+		where = append(where, "span_name = ?")
+		args = append(args, o.Operation)
+	}
+
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM %s
+		WHERE %s
+	`, strings.Join(columns, ", "), table, strings.Join(where, "\n\t\t  AND "))
+	return query, args
+}
+
+// newSLOStatus describes an objective that has not been evaluated yet
+func newSLOStatus(o config.SLOObjective) SLOStatus {
+	return SLOStatus{
+		Name:               o.Name,
+		ServiceName:        o.Service,
+		Operation:          o.Operation,
+		Target:             o.Target,
+		LatencyThresholdMs: float64(o.LatencyThreshold) / float64(time.Millisecond),
+		Window:             windowLabel(o.Window),
+		Compliance:         1,
+		BurnRates:          []SLOBurnRate{},
+	}
+}
+
+// computeSLOStatus derives compliance, the remaining error budget and the
+// burn rates from the counts of the objective's window followed by those of
+// the burn windows
+func computeSLOStatus(o config.SLOObjective, burnWindows []time.Duration, counts []sloCounts, now time.Time) SLOStatus {
+	status := newSLOStatus(o)
+	status.EvaluatedAt = &now
+	budget := 1 - o.Target
+	badRatio := func(c sloCounts) float64 {
+		if c.requests == 0 {
+			return 0
+		}
+		return float64(c.bad) / float64(c.requests)
+	}
+
+	status.Requests, status.BadRequests = counts[0].requests, counts[0].bad
+	status.Compliance = 1 - badRatio(counts[0])
+	status.ErrorBudgetRemaining = 1 - badRatio(counts[0])/budget
+	for i, w := range burnWindows {
+		c := counts[i+1]
+		status.BurnRates = append(status.BurnRates, SLOBurnRate{
+			Window:      windowLabel(w),
+			Requests:    c.requests,
+			BadRequests: c.bad,
+			BurnRate:    badRatio(c) / budget,
+		})
+	}
+	return status
+}
+
+// sloEvaluator periodically evaluates the configured objectives against the
+// stored spans. Each evaluation scans the longest of the objective's window
+// and the burn windows, so the interval should grow with the window.
+type sloEvaluator struct {
+	cfg      config.SLOConfig
+	profile  config.QueryProfile
+	chClient *clickhouse.Client
+
+	mu          sync.Mutex
+	statuses    map[string]SLOStatus
+	evaluatedAt time.Time
+}
+
+func newSLOEvaluator(cfg config.SLOConfig, profile config.QueryProfile, chClient *clickhouse.Client) *sloEvaluator {
+	statuses := make(map[string]SLOStatus, len(cfg.Objectives))
+	for _, o := range cfg.Objectives {
+		statuses[o.Name] = newSLOStatus(o)
+	}
+	return &sloEvaluator{
+		cfg:      cfg,
+		profile:  profile,
+		chClient: chClient,
+		statuses: statuses,
+	}
+}
+
+// fetch counts an objective's requests in each window ending at now
+func (e *sloEvaluator) fetch(ctx context.Context, o config.SLOObjective, now time.Time) ([]sloCounts, error) {
+	query, args := buildSLOQuery(o, e.cfg.BurnWindows, e.chClient.SpansTable(), now)
+	rows, err := e.chClient.Query(clickhouse.WithQueryProfile(ctx, e.profile), query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query objective %s: %w", o.Name, err)
+	}
+	defer rows.Close()
+
+	counts := make([]sloCounts, len(e.cfg.BurnWindows)+1)
+	dest := make([]interface{}, 0, 2*len(counts))
+	for i := range counts {
+		dest = append(dest, &counts[i].requests, &counts[i].bad)
+	}
+	if rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return nil, fmt.Errorf("failed to scan objective %s: %w", o.Name, err)
+		}
+	}
+	return counts, rows.Err()
+}
+
+// observe records an objective's status and exports it to Prometheus
+func (e *sloEvaluator) observe(status SLOStatus) {
+	monitoring.SLOCompliance.WithLabelValues(status.Name, status.ServiceName).Set(status.Compliance)
+	monitoring.SLOErrorBudgetRemaining.WithLabelValues(status.Name, status.ServiceName).Set(status.ErrorBudgetRemaining)
+	for _, b := range status.BurnRates {
+		monitoring.SLOBurnRate.WithLabelValues(status.Name, status.ServiceName, b.Window).Set(b.BurnRate)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.statuses[status.Name] = status
+	if status.EvaluatedAt.After(e.evaluatedAt) {
+		e.evaluatedAt = *status.EvaluatedAt
+	}
+}
+
+// evaluate evaluates every objective. An objective that fails keeps its
+// previous status.
+func (e *sloEvaluator) evaluate(ctx context.Context, now time.Time) {
+	for _, o := range e.cfg.Objectives {
+		counts, err := e.fetch(ctx, o, now)
+		if err != nil {
+			logger.Error("Error evaluating SLO", "slo", o.Name, "error", err)
+			continue
+		}
+		e.observe(computeSLOStatus(o, e.cfg.BurnWindows, counts, now))
+	}
+}
+
+// run evaluates right away, then every interval until ctx is done
+func (e *sloEvaluator) run(ctx context.Context) {
+	if len(e.cfg.Objectives) == 0 {
+		return
+	}
+	e.evaluate(ctx, time.Now())
+	ticker := time.NewTicker(e.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			e.evaluate(ctx, now)
+		}
+	}
+}
+
+// slos returns the objectives in config order, optionally of one service
+func (e *sloEvaluator) slos(service string) ([]SLOStatus, time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	slos := []SLOStatus{}
+	for _, o := range e.cfg.Objectives {
+		if service == "" || o.Service == service {
+			slos = append(slos, e.statuses[o.Name])
+		}
+	}
+	return slos, e.evaluatedAt
+}
+
+// slo returns one objective by name
+func (e *sloEvaluator) slo(name string) (SLOStatus, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	status, ok := e.statuses[name]
+	return status, ok
+}
+
+// ListSLOs returns the configured objectives with their error budgets and
+// burn rates
+func (s *QueryService) ListSLOs(w http.ResponseWriter, r *http.Request) {
+	slos, evaluatedAt := s.slos.slos(r.URL.Query().Get("service"))
+	response := SLOsResponse{Enabled: len(s.slos.cfg.Objectives) > 0, SLOs: slos}
+	if !evaluatedAt.IsZero() {
+		response.EvaluatedAt = &evaluatedAt
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// GetSLO returns one objective with its error budget and burn rates
+func (s *QueryService) GetSLO(w http.ResponseWriter, r *http.Request) {
+	status, ok := s.slos.slo(mux.Vars(r)["name"])
+	if !ok {
+		http.Error(w, "slo not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
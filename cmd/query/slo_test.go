@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"otelservices/internal/config"
+
+	"github.com/gorilla/mux"
+)
+
+func testObjectives() []config.SLOObjective {
+	return []config.SLOObjective{
+		{Name: "checkout-availability", Service: "checkout", Target: 0.999, Window: 720 * time.Hour},
+		{Name: "cart-latency", Service: "cart", Operation: "POST /cart", Target: 0.99, LatencyThreshold: 300 * time.Millisecond, Window: 168 * time.Hour},
+	}
+}
+
+func TestWindowLabel(t *testing.T) {
+	tests := map[time.Duration]string{
+		720 * time.Hour:         "30d",
+		6 * time.Hour:           "6h",
+		90 * time.Minute:        "90m",
+		1500 * time.Millisecond: "1.5s",
+	}
+	for d, want := range tests {
+		if got := windowLabel(d); got != want {
+			t.Errorf("windowLabel(%s) = %s, want %s", d, got, want)
+		}
+	}
+}
+
+func TestBuildSLOQuery(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	burn := []time.Duration{time.Hour, 720 * time.Hour}
+
+	availability := testObjectives()[0]
+	query, args := buildSLOQuery(availability, burn, "otel_traces", now)
+	if strings.Contains(query, "duration_ns") || strings.Contains(query, "span_name") {
+		t.Errorf("availability objective should only count errors:\n%s", query)
+	}
+	if n := strings.Count(query, "?"); n != len(args) {
+		t.Fatalf("query has %d placeholders but %d args", n, len(args))
+	}
+
+	latency := testObjectives()[1]
+	query, args = buildSLOQuery(latency, burn, "otel_traces FINAL", now)
+	for _, want := range []string{"duration_ns > 300000000", "span_name = ?", "FROM otel_traces FINAL", "span_kind IN ('server', 'consumer')"} {
+		if !strings.Contains(query, want) {
+			t.Errorf("query missing %q:\n%s", want, query)
+		}
+	}
+	if n := strings.Count(query, "?"); n != len(args) {
+		t.Fatalf("query has %d placeholders but %d args", n, len(args))
+	}
+	// The scan covers the longest window, here the 30 day burn window
+	if args[6] != "cart" || args[7] != now.Add(-720*time.Hour) || args[9] != "POST /cart" {
+		t.Errorf("unexpected args %v", args)
+	}
+}
+
+func TestComputeSLOStatus(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	o := testObjectives()[0]
+	burn := []time.Duration{5 * time.Minute, time.Hour}
+
+	status := computeSLOStatus(o, burn, []sloCounts{{1000000, 400}, {1000, 20}, {0, 0}}, now)
+	if math.Abs(status.Compliance-0.9996) > 1e-9 || math.Abs(status.ErrorBudgetRemaining-0.6) > 1e-9 {
+		t.Errorf("compliance %v, budget remaining %v", status.Compliance, status.ErrorBudgetRemaining)
+	}
+	if len(status.BurnRates) != 2 || status.BurnRates[0].Window != "5m" || math.Abs(status.BurnRates[0].BurnRate-20) > 1e-9 {
+		t.Errorf("unexpected burn rates %+v", status.BurnRates)
+	}
+	if status.BurnRates[1].BurnRate != 0 {
+		t.Errorf("window without requests burned %v", status.BurnRates[1].BurnRate)
+	}
+
+	// Missing the objective leaves a negative budget
+	status = computeSLOStatus(o, nil, []sloCounts{{1000, 3}}, now)
+	if math.Abs(status.ErrorBudgetRemaining+2) > 1e-9 || status.Window != "30d" || !status.EvaluatedAt.Equal(now) {
+		t.Errorf("unexpected status %+v", status)
+	}
+}
+
+func TestSLOEndpoints(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.SLO.Objectives = testObjectives()
+	s := NewQueryService(cfg, nil)
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	s.slos.observe(computeSLOStatus(cfg.SLO.Objectives[1], nil, []sloCounts{{100, 1}}, now))
+	router := mux.NewRouter()
+	router.HandleFunc("/api/v1/slo", s.ListSLOs)
+	router.HandleFunc("/api/v1/slo/{name}", s.GetSLO)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/slo", nil))
+	var list SLOsResponse
+	if err := json.NewDecoder(rec.Body).Decode(&list); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if !list.Enabled || list.EvaluatedAt == nil || len(list.SLOs) != 2 {
+		t.Fatalf("unexpected response %+v", list)
+	}
+	// Objectives not evaluated yet are listed without an evaluation
+	if list.SLOs[0].EvaluatedAt != nil || list.SLOs[0].Compliance != 1 || list.SLOs[1].Requests != 100 {
+		t.Errorf("unexpected objectives %+v", list.SLOs)
+	}
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/slo?service=cart", nil))
+	list = SLOsResponse{}
+	json.NewDecoder(rec.Body).Decode(&list)
+	if len(list.SLOs) != 1 || list.SLOs[0].Name != "cart-latency" || list.SLOs[0].LatencyThresholdMs != 300 {
+		t.Errorf("service filter returned %+v", list.SLOs)
+	}
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/slo/unknown", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("unknown objective: status %d", rec.Code)
+	}
+}
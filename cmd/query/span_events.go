@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// SpanEvent is a timestamped annotation recorded during a span
+type SpanEvent struct {
+	Timestamp  time.Time         `json:"timestamp"`
+	Name       string            `json:"name"`
+	Attributes map[string]string `json:"attributes"`
+}
+
+// SpanLink references a span in the same or another trace
+type SpanLink struct {
+	TraceID    string            `json:"trace_id"`
+	SpanID     string            `json:"span_id"`
+	TraceState string            `json:"trace_state,omitempty"`
+	Attributes map[string]string `json:"attributes"`
+}
+
+// spanEventColumns selects the events and links tuple elements as parallel
+// arrays, which scan into plain slices
+const spanEventColumns = `events.timestamp, events.name, events.attributes,
+			links.trace_id, links.span_id, links.trace_state, links.attributes`
+
+// spanEventArrays holds the scan destinations for spanEventColumns
+type spanEventArrays struct {
+	eventTimes      []time.Time
+	eventNames      []string
+	eventAttributes []map[string]string
+	linkTraceIDs    []string
+	linkSpanIDs     []string
+	linkTraceStates []string
+	linkAttributes  []map[string]string
+}
+
+func (a *spanEventArrays) dest() []interface{} {
+	return []interface{}{
+		&a.eventTimes, &a.eventNames, &a.eventAttributes,
+		&a.linkTraceIDs, &a.linkSpanIDs, &a.linkTraceStates, &a.linkAttributes,
+	}
+}
+
+// events zips the event arrays back into events
+func (a *spanEventArrays) events() ([]SpanEvent, error) {
+	n := len(a.eventTimes)
+	if len(a.eventNames) != n || len(a.eventAttributes) != n {
+		return nil, fmt.Errorf("mismatched event arrays: %d timestamps, %d names, %d attributes",
+			n, len(a.eventNames), len(a.eventAttributes))
+	}
+	events := make([]SpanEvent, n)
+	for i := range events {
+		events[i] = SpanEvent{Timestamp: a.eventTimes[i], Name: a.eventNames[i], Attributes: a.eventAttributes[i]}
+	}
+	return events, nil
+}
+
+// links zips the link arrays back into links
+func (a *spanEventArrays) links() ([]SpanLink, error) {
+	n := len(a.linkTraceIDs)
+	if len(a.linkSpanIDs) != n || len(a.linkTraceStates) != n || len(a.linkAttributes) != n {
+		return nil, fmt.Errorf("mismatched link arrays: %d trace IDs, %d span IDs, %d trace states, %d attributes",
+			n, len(a.linkSpanIDs), len(a.linkTraceStates), len(a.linkAttributes))
+	}
+	links := make([]SpanLink, n)
+	for i := range links {
+		links[i] = SpanLink{
+			TraceID:    a.linkTraceIDs[i],
+			SpanID:     a.linkSpanIDs[i],
+			TraceState: a.linkTraceStates[i],
+			Attributes: a.linkAttributes[i],
+		}
+	}
+	return links, nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSpanEventArrays(t *testing.T) {
+	ts := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	arrays := spanEventArrays{
+		eventTimes:      []time.Time{ts, ts.Add(time.Millisecond)},
+		eventNames:      []string{"retry", "exception"},
+		eventAttributes: []map[string]string{{}, {"exception.type": "IOError"}},
+		linkTraceIDs:    []string{"4bf92f3577b34da6a3ce929d0e0e4736"},
+		linkSpanIDs:     []string{"00f067aa0ba902b7"},
+		linkTraceStates: []string{""},
+		linkAttributes:  []map[string]string{{"link.kind": "follows_from"}},
+	}
+
+	events, err := arrays.events()
+	if err != nil {
+		t.Fatalf("events: %v", err)
+	}
+	if len(events) != 2 || events[1].Name != "exception" || events[1].Attributes["exception.type"] != "IOError" {
+		t.Errorf("unexpected events: %+v", events)
+	}
+
+	links, err := arrays.links()
+	if err != nil {
+		t.Fatalf("links: %v", err)
+	}
+	if len(links) != 1 || links[0].SpanID != "00f067aa0ba902b7" || links[0].Attributes["link.kind"] != "follows_from" {
+		t.Errorf("unexpected links: %+v", links)
+	}
+}
+
+func TestSpanEventArraysEmpty(t *testing.T) {
+	var arrays spanEventArrays
+	events, err := arrays.events()
+	if err != nil || events == nil || len(events) != 0 {
+		t.Errorf("expected empty non-nil events, got %#v, %v", events, err)
+	}
+	links, err := arrays.links()
+	if err != nil || links == nil || len(links) != 0 {
+		t.Errorf("expected empty non-nil links, got %#v, %v", links, err)
+	}
+}
+
+func TestSpanEventArraysMismatch(t *testing.T) {
+	arrays := spanEventArrays{eventTimes: []time.Time{time.Now()}}
+	if _, err := arrays.events(); err == nil {
+		t.Error("expected an error for mismatched event arrays")
+	}
+	arrays = spanEventArrays{linkTraceIDs: []string{"a"}, linkSpanIDs: []string{"b"}}
+	if _, err := arrays.links(); err == nil {
+		t.Error("expected an error for mismatched link arrays")
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"otelservices/internal/monitoring"
+)
+
+// storageTierHeader names the volume a query reads moved data from
+const storageTierHeader = "X-Storage-Tier"
+
+// markStorageTier flags the response of a traces or logs query that reaches
+// data moved to the tiering volume, because its range starts before the age
+// at which the data moves or has no start. Reading moved parts, typically
+// from object storage, is slower, so clients get the volume in
+// X-Storage-Tier and a Warning header.
+func (s *QueryService) markStorageTier(w http.ResponseWriter, queryType string, start time.Time) {
+	moveAfter := s.retention.classMoveAfter(queryType)
+	if moveAfter <= 0 {
+		return
+	}
+	if !start.IsZero() && start.After(time.Now().Add(-moveAfter)) {
+		return
+	}
+	volume := s.retention.cfg.Tiering.Volume
+	w.Header().Set(storageTierHeader, volume)
+	w.Header().Add("Warning", fmt.Sprintf(`299 - "query reads data older than %s from the %s volume, expect slower responses"`, moveAfter, volume))
+	monitoring.TieredQueries.WithLabelValues(queryType).Inc()
+}
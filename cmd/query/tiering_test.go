@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"otelservices/internal/config"
+)
+
+func TestMarkStorageTier(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Retention.Tiering = config.TieringConfig{Volume: "cold", Traces: 3 * day}
+	s := NewQueryService(cfg, nil)
+
+	rec := httptest.NewRecorder()
+	s.markStorageTier(rec, "traces", time.Now().Add(-time.Hour))
+	if rec.Header().Get(storageTierHeader) != "" {
+		t.Error("recent traces should not be flagged")
+	}
+
+	for _, start := range []time.Time{time.Now().Add(-4 * day), {}} {
+		rec = httptest.NewRecorder()
+		s.markStorageTier(rec, "traces", start)
+		if rec.Header().Get(storageTierHeader) != "cold" || rec.Header().Get("Warning") == "" {
+			t.Errorf("start %v: headers = %v", start, rec.Header())
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	s.markStorageTier(rec, "logs", time.Time{})
+	if rec.Header().Get(storageTierHeader) != "" {
+		t.Error("logs are not tiered in this config")
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"otelservices/internal/timeutil"
+)
+
+// timeRanged is a request whose time range defaults to the last
+// timeutil.DefaultWindow when it gives none
+type timeRanged interface {
+	defaultTimeRange(now time.Time) bool
+}
+
+// defaultTimeRange fills in the range of a span search. Lookups by trace ID
+// are left unbounded, as the trace may be of any age; QueryTraces narrows
+// them with the trace index.
+func (req *TraceQueryRequest) defaultTimeRange(now time.Time) bool {
+	if req.TraceID != "" {
+		return false
+	}
+	return timeutil.DefaultRange(&req.StartTime, &req.EndTime, now, timeutil.DefaultWindow)
+}
+
+// defaultTimeRange fills in the range of a metrics query
+func (req *MetricsQueryRequest) defaultTimeRange(now time.Time) bool {
+	return timeutil.DefaultRange(&req.StartTime, &req.EndTime, now, timeutil.DefaultWindow)
+}
+
+// defaultTimeRange fills in the range of a logs query
+func (req *LogsQueryRequest) defaultTimeRange(now time.Time) bool {
+	return timeutil.DefaultRange(&req.StartTime, &req.EndTime, now, timeutil.DefaultWindow)
+}
+
+// prepareRequestBody resolves relative times in a JSON request body for v,
+// fills in a missing time range and validates the result, which is returned
+// as the body the handler reads
+func prepareRequestBody(body []byte, v interface{}, now time.Time) ([]byte, error) {
+	body, err := resolveRelativeTimes(body, reflect.TypeOf(v), now)
+	if err != nil {
+		return nil, err
+	}
+	if ranged, ok := v.(timeRanged); ok && len(bytes.TrimSpace(body)) > 0 {
+		if err := json.Unmarshal(body, v); err != nil {
+			return nil, describeDecodeError(err)
+		}
+		if ranged.defaultTimeRange(now) {
+			if body, err = json.Marshal(v); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return body, validateRequestBody(body, v)
+}
+
+// resolveRelativeTimes replaces relative times such as "now-15m" in the
+// time fields of a JSON body for type t with RFC 3339 times. Bodies that are
+// not valid JSON are returned as they are, for validation to report.
+func resolveRelativeTimes(body []byte, t reflect.Type, now time.Time) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return body, nil
+	}
+	resolved, changed, err := resolveTimes(doc, t, "", now)
+	if err != nil || !changed {
+		return body, err
+	}
+	return json.Marshal(resolved)
+}
+
+// resolveTimes walks a decoded JSON value v alongside its Go type t
+func resolveTimes(v interface{}, t reflect.Type, path string, now time.Time) (interface{}, bool, error) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch {
+	case t == timeType:
+		s, ok := v.(string)
+		if !ok || !timeutil.IsRelative(s) {
+			return v, false, nil
+		}
+		resolved, err := timeutil.Parse(s, now)
+		if err != nil {
+			return nil, false, fmt.Errorf("%s: %w", path, err)
+		}
+		return resolved.UTC().Format(time.RFC3339Nano), true, nil
+	case t == rawMessageType:
+		return v, false, nil
+	case t.Kind() == reflect.Struct:
+		fields, ok := v.(map[string]interface{})
+		if !ok {
+			return v, false, nil
+		}
+		changed := false
+		for i := 0; i < t.NumField(); i++ {
+			name := jsonFieldName(t.Field(i))
+			value, present := fields[name]
+			if name == "" || !present {
+				continue
+			}
+			resolved, c, err := resolveTimes(value, t.Field(i).Type, joinPath(path, name), now)
+			if err != nil {
+				return nil, false, err
+			}
+			fields[name], changed = resolved, changed || c
+		}
+		return fields, changed, nil
+	case t.Kind() == reflect.Slice || t.Kind() == reflect.Array:
+		items, ok := v.([]interface{})
+		if !ok {
+			return v, false, nil
+		}
+		changed := false
+		for i, item := range items {
+			resolved, c, err := resolveTimes(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i), now)
+			if err != nil {
+				return nil, false, err
+			}
+			items[i], changed = resolved, changed || c
+		}
+		return items, changed, nil
+	}
+	return v, false, nil
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
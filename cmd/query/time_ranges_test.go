@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrepareRequestBody(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	// Relative times become RFC 3339, other fields are kept as given
+	body, err := prepareRequestBody([]byte(`{"start_time": "now-15m", "end_time": "now", "min_duration": 9007199254740993}`), &TraceQueryRequest{}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var traces TraceQueryRequest
+	if err := json.Unmarshal(body, &traces); err != nil {
+		t.Fatal(err)
+	}
+	if !traces.StartTime.Equal(now.Add(-15*time.Minute)) || !traces.EndTime.Equal(now) || traces.MinDuration != 9007199254740993 {
+		t.Errorf("traces = %+v", traces)
+	}
+
+	// An empty range is the last hour, a missing start an hour before end
+	body, err = prepareRequestBody([]byte(`{"metric_name": "cpu"}`), &MetricsQueryRequest{}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var metrics MetricsQueryRequest
+	json.Unmarshal(body, &metrics)
+	if !metrics.StartTime.Equal(now.Add(-time.Hour)) || !metrics.EndTime.Equal(now) {
+		t.Errorf("metrics range = %v - %v", metrics.StartTime, metrics.EndTime)
+	}
+	body, err = prepareRequestBody([]byte(`{"end_time": "now-1d"}`), &LogsQueryRequest{}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var logs LogsQueryRequest
+	json.Unmarshal(body, &logs)
+	if !logs.StartTime.Equal(now.Add(-25*time.Hour)) || !logs.EndTime.Equal(now.Add(-24*time.Hour)) {
+		t.Errorf("logs range = %v - %v", logs.StartTime, logs.EndTime)
+	}
+
+	// Trace ID lookups keep searching every time
+	in := `{"trace_id": "4bf92f3577b34da6a3ce929d0e0e4736"}`
+	if body, err = prepareRequestBody([]byte(in), &TraceQueryRequest{}, now); err != nil || string(body) != in {
+		t.Errorf("trace ID lookup = %s, %v", body, err)
+	}
+
+	// Nested time fields are resolved too
+	body, err = prepareRequestBody([]byte(`{"baseline": {"start_time": "now-2h", "end_time": "now-1h"},
+		"comparison": {"start_time": "now-1h", "end_time": "now"}}`), &ServiceCompareRequest{}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var compare ServiceCompareRequest
+	json.Unmarshal(body, &compare)
+	if !compare.Baseline.StartTime.Equal(now.Add(-2 * time.Hour)) {
+		t.Errorf("baseline start = %v", compare.Baseline.StartTime)
+	}
+
+	for in, wantErr := range map[string]string{
+		`{"start_time": "now-soon"}`:                        `start_time: invalid relative duration "soon"`,
+		`{"start_time": "now", "end_time": "now-1h"}`:       "end_time must not be before start_time",
+		`{"start_time": "yesterday", "end_time": "now"}`:    "invalid request body",
+		`{"attribute_filters": [{"key": "a", "op": "gt"}]}`: "value must be a number",
+	} {
+		if _, err := prepareRequestBody([]byte(in), &LogsQueryRequest{}, now); err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Errorf("%s: err = %v, want %q", in, err, wantErr)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"otelservices/internal/clickhouse"
+	"otelservices/internal/config"
+	"otelservices/internal/models"
+	"otelservices/internal/monitoring"
+)
+
+// TimestampColumnStatus is the stored precision of one time column
+type TimestampColumnStatus struct {
+	Table     string `json:"table"`
+	Column    string `json:"column"`
+	Type      string `json:"type"`
+	Precision int    `json:"precision"`
+	Truncates bool   `json:"truncates"` // stores less than nanoseconds
+}
+
+// TimestampsResponse audits the time columns against the nanosecond
+// timestamps the collector writes. With truncating span columns the
+// collector's mode decides whether it keeps exact times in attributes or
+// refuses to start.
+type TimestampsResponse struct {
+	GeneratedAt time.Time               `json:"generated_at"`
+	Precision   int                     `json:"precision"` // of the timestamps written
+	Mode        string                  `json:"mode"`
+	Truncating  bool                    `json:"truncating"`
+	Columns     []TimestampColumnStatus `json:"columns"`
+}
+
+// timestampsResponse builds the audit of cols
+func timestampsResponse(cols []clickhouse.TimestampColumn, mode string, now time.Time) TimestampsResponse {
+	if mode == "" {
+		mode = config.TimestampCompat
+	}
+	response := TimestampsResponse{
+		GeneratedAt: now,
+		Precision:   models.TimestampPrecision,
+		Mode:        mode,
+		Columns:     []TimestampColumnStatus{},
+	}
+	for _, c := range cols {
+		response.Columns = append(response.Columns, TimestampColumnStatus{
+			Table:     c.Table,
+			Column:    c.Column,
+			Type:      c.Type,
+			Precision: c.Precision,
+			Truncates: c.Truncates(),
+		})
+		response.Truncating = response.Truncating || c.Truncates()
+	}
+	return response
+}
+
+// GetTimestamps returns the precision of every time column
+func (s *QueryService) GetTimestamps(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	defer func() {
+		monitoring.QueryDuration.WithLabelValues("timestamps").Observe(time.Since(start).Seconds())
+	}()
+
+	cols, err := s.chClient.TimestampColumns(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		monitoring.QueryErrors.WithLabelValues("timestamps").Inc()
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(timestampsResponse(cols, s.config.ClickHouse.TimestampMode, time.Now().UTC()))
+}
+
+// restoreExactTimes replaces the stored start and end of a span with the
+// exact times the collector keeps in attributes when the time columns are
+// less precise than nanoseconds
+func (s *Span) restoreExactTimes() {
+	if s.Attributes == nil {
+		return
+	}
+	s.StartTime = models.ExactTime(s.StartTime, s.Attributes, models.StartTimeAttribute)
+	s.EndTime = models.ExactTime(s.EndTime, s.Attributes, models.EndTimeAttribute)
+	delete(s.Attributes, models.StartTimeAttribute)
+	delete(s.Attributes, models.EndTimeAttribute)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"otelservices/internal/clickhouse"
+	"otelservices/internal/models"
+)
+
+func TestTimestampsResponse(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	got := timestampsResponse(nil, "", now)
+	if got.Mode != "compat" || got.Truncating || got.Columns == nil || got.Precision != 9 {
+		t.Errorf("empty audit = %+v", got)
+	}
+
+	got = timestampsResponse([]clickhouse.TimestampColumn{
+		{Table: "otel_traces", Column: "start_time", Type: "DateTime64(3)", Precision: 3},
+		{Table: "otel_logs", Column: "timestamp", Type: "DateTime64(9)", Precision: 9},
+	}, "strict", now)
+	if !got.Truncating || got.Mode != "strict" || len(got.Columns) != 2 {
+		t.Fatalf("audit = %+v", got)
+	}
+	if !got.Columns[0].Truncates || got.Columns[1].Truncates {
+		t.Errorf("columns = %+v", got.Columns)
+	}
+}
+
+func TestRestoreExactTimes(t *testing.T) {
+	span := Span{
+		StartTime: time.Unix(1700000000, 123000000).UTC(),
+		EndTime:   time.Unix(1700000000, 125000000).UTC(),
+		Attributes: map[string]string{
+			models.StartTimeAttribute: "1700000000123456789",
+			"http.method":             "GET",
+		},
+	}
+	span.restoreExactTimes()
+	if span.StartTime.UnixNano() != 1700000000123456789 || span.EndTime.UnixNano() != 1700000000125000000 {
+		t.Errorf("restored %v - %v", span.StartTime, span.EndTime)
+	}
+	if _, ok := span.Attributes[models.StartTimeAttribute]; ok || len(span.Attributes) != 1 {
+		t.Errorf("attributes = %v", span.Attributes)
+	}
+}
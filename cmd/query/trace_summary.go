@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// TraceSummary holds trace-level fields from otel_trace_index so span search
+// results can be rendered as a trace list without further queries
+type TraceSummary struct {
+	RootServiceName string    `json:"root_service_name"`
+	RootSpanName    string    `json:"root_span_name"`
+	StartTime       time.Time `json:"start_time"`
+	EndTime         time.Time `json:"end_time"`
+	DurationNs      uint64    `json:"duration_ns"`
+	SpanCount       uint64    `json:"span_count"`
+	HasErrors       bool      `json:"has_errors"`
+	ServiceNames    []string  `json:"service_names"`
+}
+
+// traceSummaryQuery merges the otel_trace_index rows of each trace. The
+// materialized view writes one row per trace per insert block, so a trace
+// whose spans arrived in several batches has several partial rows.
+const traceSummaryQuery = `
+	SELECT
+		trace_id,
+		anyIf(root_service_name, root_span_name != '') AS root_service,
+		anyIf(root_span_name, root_span_name != '') AS root_span,
+		min(min_timestamp) AS start_time,
+		max(max_timestamp) AS end_time,
+		sum(span_count) AS span_count,
+		max(has_errors) AS has_errors,
+		groupUniqArrayArray(service_names) AS service_names
+	FROM otel_trace_index
+	WHERE trace_id IN (?)
+	GROUP BY trace_id
+`
+
+// spanSummaryQuery computes the summaries from the spans themselves when
+// spans are deduplicated. The trace index is filled at insert time, so a
+// span sent twice is counted twice there.
+const spanSummaryQuery = `
+	SELECT
+		trace_id,
+		anyIf(service_name, parent_span_id = '') AS root_service,
+		anyIf(span_name, parent_span_id = '') AS root_span,
+		min(start_time) AS first_start,
+		max(end_time) AS last_end,
+		count() AS span_count,
+		max(status_code = 'error') AS has_errors,
+		groupUniqArray(service_name) AS service_names
+	FROM otel_traces FINAL
+	WHERE trace_id IN (?)
+	GROUP BY trace_id
+`
+
+// traceRangeQuery reads when a set of traces happened from their
+// otel_trace_index rows: the first span start, the last span end and how
+// many of the traces are indexed
+const traceRangeQuery = `
+	SELECT min(min_timestamp), max(max_timestamp), uniqExact(trace_id)
+	FROM otel_trace_index
+	WHERE trace_id IN (?)
+`
+
+// traceLookupRange returns the time range holding every span of traceIDs,
+// so a lookup by trace ID reads the partitions of those days only instead of
+// the whole of otel_traces. The range is widened to whole seconds, which
+// keeps it safe at any precision of the time columns. Both times are zero
+// when a trace is missing from the index, such as one stored before the
+// index existed, or the index cannot be read; the lookup is then unbounded.
+func (s *QueryService) traceLookupRange(ctx context.Context, traceIDs []string) (time.Time, time.Time) {
+	if len(traceIDs) == 0 {
+		return time.Time{}, time.Time{}
+	}
+	var from, to time.Time
+	var indexed uint64
+	if err := s.chClient.QueryRow(ctx, traceRangeQuery, traceIDs).Scan(&from, &to, &indexed); err != nil {
+		logger.Warn("Error reading trace index, looking up traces without a time range", "error", err)
+		return time.Time{}, time.Time{}
+	}
+	if indexed < uint64(len(traceIDs)) {
+		return time.Time{}, time.Time{}
+	}
+	return from.Truncate(time.Second), to.Truncate(time.Second).Add(time.Second)
+}
+
+// traceRangeClause restricts a span query to the range of traceLookupRange,
+// if any
+func traceRangeClause(from, to time.Time) (string, []interface{}) {
+	if from.IsZero() || to.IsZero() {
+		return "", nil
+	}
+	return " AND timestamp >= ? AND timestamp <= ?", []interface{}{from, to}
+}
+
+// uniqueTraceIDs returns the distinct trace IDs of spans in first-seen order
+func uniqueTraceIDs(spans []Span) []string {
+	seen := make(map[string]bool)
+	ids := []string{}
+	for _, span := range spans {
+		if span.TraceID == "" || seen[span.TraceID] {
+			continue
+		}
+		seen[span.TraceID] = true
+		ids = append(ids, span.TraceID)
+	}
+	return ids
+}
+
+// fetchTraceSummaries looks up the summaries of the given traces
+func (s *QueryService) fetchTraceSummaries(ctx context.Context, traceIDs []string) (map[string]*TraceSummary, error) {
+	summaries := make(map[string]*TraceSummary, len(traceIDs))
+	if len(traceIDs) == 0 {
+		return summaries, nil
+	}
+
+	query := traceSummaryQuery
+	if s.chClient.DeduplicatesSpans() {
+		query = spanSummaryQuery
+	}
+	rows, err := s.chClient.Query(ctx, query, traceIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query trace index: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var traceID string
+		var hasErrors uint8
+		summary := &TraceSummary{}
+		if err := rows.Scan(
+			&traceID, &summary.RootServiceName, &summary.RootSpanName,
+			&summary.StartTime, &summary.EndTime, &summary.SpanCount, &hasErrors, &summary.ServiceNames,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan trace summary: %w", err)
+		}
+		summary.HasErrors = hasErrors > 0
+		if summary.EndTime.After(summary.StartTime) {
+			summary.DurationNs = uint64(summary.EndTime.Sub(summary.StartTime))
+		}
+		summaries[traceID] = summary
+	}
+	return summaries, rows.Err()
+}
+
+// attachTraceSummaries sets the Trace field of each span that has a summary.
+// Spans of the same trace share one summary.
+func attachTraceSummaries(spans []Span, summaries map[string]*TraceSummary) {
+	for i := range spans {
+		spans[i].Trace = summaries[spans[i].TraceID]
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUniqueTraceIDs(t *testing.T) {
+	spans := []Span{
+		{TraceID: "b", SpanID: "1"},
+		{TraceID: "a", SpanID: "2"},
+		{TraceID: "b", SpanID: "3"},
+		{TraceID: "", SpanID: "4"},
+	}
+	if got, want := uniqueTraceIDs(spans), []string{"b", "a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("uniqueTraceIDs() = %v, want %v", got, want)
+	}
+	if got := uniqueTraceIDs(nil); len(got) != 0 {
+		t.Errorf("uniqueTraceIDs(nil) = %v, want empty", got)
+	}
+}
+
+func TestAttachTraceSummaries(t *testing.T) {
+	summary := &TraceSummary{RootServiceName: "frontend", RootSpanName: "GET /", SpanCount: 3}
+	spans := []Span{
+		{TraceID: "a", SpanID: "1"},
+		{TraceID: "b", SpanID: "2"},
+		{TraceID: "a", SpanID: "3"},
+	}
+
+	attachTraceSummaries(spans, map[string]*TraceSummary{"a": summary})
+
+	if spans[0].Trace != summary || spans[2].Trace != summary {
+		t.Errorf("spans of trace a should share its summary, got %+v and %+v", spans[0].Trace, spans[2].Trace)
+	}
+	if spans[1].Trace != nil {
+		t.Errorf("span without an indexed trace should have no summary, got %+v", spans[1].Trace)
+	}
+}
+
+func TestTraceSummaryQueryMergesPartialRows(t *testing.T) {
+	for _, want := range []string{"GROUP BY trace_id", "sum(span_count)", "min(min_timestamp)", "max(max_timestamp)"} {
+		if !strings.Contains(traceSummaryQuery, want) {
+			t.Errorf("trace summary query missing %q", want)
+		}
+	}
+}
+
+func TestSpanSummaryQueryReadsDeduplicatedSpans(t *testing.T) {
+	for _, want := range []string{"FROM otel_traces FINAL", "GROUP BY trace_id", "count() AS span_count"} {
+		if !strings.Contains(spanSummaryQuery, want) {
+			t.Errorf("span summary query missing %q", want)
+		}
+	}
+}
+
+func TestTraceRangeQueryMergesPartialRows(t *testing.T) {
+	for _, want := range []string{"FROM otel_trace_index", "min(min_timestamp)", "max(max_timestamp)", "uniqExact(trace_id)"} {
+		if !strings.Contains(traceRangeQuery, want) {
+			t.Errorf("trace range query missing %q", want)
+		}
+	}
+}
+
+func TestTraceRangeClause(t *testing.T) {
+	from := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	to := from.Add(time.Minute)
+
+	clause, args := traceRangeClause(from, to)
+	if clause != " AND timestamp >= ? AND timestamp <= ?" {
+		t.Errorf("clause = %q", clause)
+	}
+	if !reflect.DeepEqual(args, []interface{}{from, to}) {
+		t.Errorf("args = %v, want [%v %v]", args, from, to)
+	}
+
+	// A trace missing from the index is looked up without a range
+	if clause, args := traceRangeClause(time.Time{}, time.Time{}); clause != "" || args != nil {
+		t.Errorf("traceRangeClause(zero) = %q, %v, want no clause", clause, args)
+	}
+}
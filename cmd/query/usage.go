@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"otelservices/internal/config"
+	"otelservices/internal/monitoring"
+)
+
+// defaultUsageWindow is the range reported by /api/v1/usage without start
+const defaultUsageWindow = 30 * 24 * time.Hour
+
+// DailyUsage is what one service sent for one signal on one UTC day
+type DailyUsage struct {
+	Date             string `json:"date"` // YYYY-MM-DD
+	ServiceName      string `json:"service_name"`
+	ServiceNamespace string `json:"service_namespace"`
+	Signal           string `json:"signal"`
+	Bytes            uint64 `json:"bytes"`
+	Items            uint64 `json:"items"`
+}
+
+type UsageResponse struct {
+	Start time.Time    `json:"start"`
+	End   time.Time    `json:"end"`
+	Usage []DailyUsage `json:"usage"`
+}
+
+// QuotaStatus compares a configured quota with the usage of its current
+// period. PercentUsed is that of the limit closest to exhaustion.
+type QuotaStatus struct {
+	ServiceName      string    `json:"service_name,omitempty"`
+	ServiceNamespace string    `json:"service_namespace,omitempty"`
+	Period           string    `json:"period"`
+	PeriodStart      time.Time `json:"period_start"`
+	MaxBytes         int64     `json:"max_bytes"`
+	MaxItems         int64     `json:"max_items"`
+	Bytes            uint64    `json:"bytes"`
+	Items            uint64    `json:"items"`
+	PercentUsed      float64   `json:"percent_used"`
+	Exceeded         bool      `json:"exceeded"`
+}
+
+type QuotasResponse struct {
+	GeneratedAt time.Time     `json:"generated_at"`
+	Enabled     bool          `json:"enabled"`
+	Quotas      []QuotaStatus `json:"quotas"`
+}
+
+// buildUsageQuery sums the hourly usage rows per day, service and signal
+func buildUsageQuery(start, end time.Time, serviceName, serviceNamespace string) (string, []interface{}) {
+	query := `
+		SELECT toDate(timestamp) AS day, service_name, service_namespace, signal, sum(bytes), sum(items)
+		FROM otel_ingest_usage
+		WHERE timestamp >= ? AND timestamp < ?`
+	args := []interface{}{start.UTC().Truncate(time.Hour), end}
+	if serviceName != "" {
+		query += " AND service_name = ?"
+		args = append(args, serviceName)
+	}
+	if serviceNamespace != "" {
+		query += " AND service_namespace = ?"
+		args = append(args, serviceNamespace)
+	}
+	query += `
+		GROUP BY day, service_name, service_namespace, signal
+		ORDER BY day, service_name, service_namespace, signal`
+	return query, args
+}
+
+// GetUsage returns the bytes and items ingested per day, service and signal
+func (s *QueryService) GetUsage(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	defer func() {
+		monitoring.QueryDuration.WithLabelValues("usage").Observe(time.Since(start).Seconds())
+	}()
+
+	from, to, err := parseTimeRange(r, defaultUsageWindow)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		monitoring.QueryErrors.WithLabelValues("usage").Inc()
+		return
+	}
+
+	query, args := buildUsageQuery(from, to, r.URL.Query().Get("service"), r.URL.Query().Get("namespace"))
+	rows, err := s.chClient.Query(r.Context(), query, args...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		monitoring.QueryErrors.WithLabelValues("usage").Inc()
+		return
+	}
+	defer rows.Close()
+
+	response := UsageResponse{Start: from, End: to, Usage: []DailyUsage{}}
+	for rows.Next() {
+		var u DailyUsage
+		var day time.Time
+		if err := rows.Scan(&day, &u.ServiceName, &u.ServiceNamespace, &u.Signal, &u.Bytes, &u.Items); err != nil {
+			http.Error(w, fmt.Sprintf("failed to scan usage: %v", err), http.StatusInternalServerError)
+			monitoring.QueryErrors.WithLabelValues("usage").Inc()
+			return
+		}
+		u.Date = day.Format("2006-01-02")
+		response.Usage = append(response.Usage, u)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		monitoring.QueryErrors.WithLabelValues("usage").Inc()
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// quotaStatus compares the usage of a quota's current period with its limits
+func quotaStatus(q config.Quota, periodStart time.Time, bytes, items uint64) QuotaStatus {
+	status := QuotaStatus{
+		ServiceName:      q.ServiceName,
+		ServiceNamespace: q.ServiceNamespace,
+		Period:           q.Period,
+		PeriodStart:      periodStart,
+		MaxBytes:         q.MaxBytes,
+		MaxItems:         q.MaxItems,
+		Bytes:            bytes,
+		Items:            items,
+	}
+	for _, limit := range []struct{ used, max uint64 }{{bytes, uint64(q.MaxBytes)}, {items, uint64(q.MaxItems)}} {
+		if limit.max == 0 {
+			continue
+		}
+		if pct := float64(limit.used) / float64(limit.max) * 100; pct > status.PercentUsed {
+			status.PercentUsed = pct
+		}
+		// The collector rejects whatever would go past the limit
+		if limit.used >= limit.max {
+			status.Exceeded = true
+		}
+	}
+	return status
+}
+
+// GetQuotas returns the configured ingest quotas with the usage of their
+// current period
+func (s *QueryService) GetQuotas(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	defer func() {
+		monitoring.QueryDuration.WithLabelValues("quotas").Observe(time.Since(start).Seconds())
+	}()
+
+	now := time.Now().UTC()
+	response := QuotasResponse{GeneratedAt: now, Enabled: s.config.Quotas.Enabled, Quotas: []QuotaStatus{}}
+	if response.Enabled {
+		for _, q := range s.config.Quotas.Limits {
+			periodStart := q.PeriodStart(now)
+			bytes, items, err := s.chClient.IngestUsageSince(r.Context(), periodStart, q.ServiceName, q.ServiceNamespace)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				monitoring.QueryErrors.WithLabelValues("quotas").Inc()
+				return
+			}
+			response.Quotas = append(response.Quotas, quotaStatus(q, periodStart, bytes, items))
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(response)
+}
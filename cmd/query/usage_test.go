@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"otelservices/internal/config"
+)
+
+func TestBuildUsageQuery(t *testing.T) {
+	start := time.Date(2024, 3, 1, 10, 45, 0, 0, time.UTC)
+	end := time.Date(2024, 3, 3, 0, 0, 0, 0, time.UTC)
+
+	query, args := buildUsageQuery(start, end, "", "")
+	if strings.Contains(query, "service_name = ?") || len(args) != 2 {
+		t.Errorf("unexpected filters: %s %v", query, args)
+	}
+	if got := args[0].(time.Time); !got.Equal(start.Truncate(time.Hour)) {
+		t.Errorf("start = %v, want it truncated to the hour of the usage rows", got)
+	}
+
+	query, args = buildUsageQuery(start, end, "checkout", "shop")
+	if !strings.Contains(query, "service_name = ?") || !strings.Contains(query, "service_namespace = ?") {
+		t.Errorf("expected service and namespace filters:\n%s", query)
+	}
+	if len(args) != 4 || args[2] != "checkout" || args[3] != "shop" {
+		t.Errorf("unexpected args: %v", args)
+	}
+}
+
+func TestQuotaStatus(t *testing.T) {
+	periodStart := time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC)
+	q := config.Quota{ServiceName: "checkout", Period: config.QuotaDaily, MaxBytes: 1000, MaxItems: 10}
+
+	status := quotaStatus(q, periodStart, 250, 5)
+	if status.PercentUsed != 50 || status.Exceeded {
+		t.Errorf("quotaStatus() = %+v, want 50%% of the item limit", status)
+	}
+	if status = quotaStatus(q, periodStart, 1000, 1); !status.Exceeded || status.PercentUsed != 100 {
+		t.Errorf("quotaStatus() = %+v, want the byte limit exceeded", status)
+	}
+
+	q.MaxItems = 0
+	if status = quotaStatus(q, periodStart, 0, 1<<20); status.Exceeded || status.PercentUsed != 0 {
+		t.Errorf("quotaStatus() = %+v, an unlimited dimension cannot be exceeded", status)
+	}
+}
+
+func TestGetQuotasDisabled(t *testing.T) {
+	s := NewQueryService(config.DefaultConfig(), nil)
+	w := httptest.NewRecorder()
+	s.GetQuotas(w, httptest.NewRequest(http.MethodGet, "/api/v1/usage/quotas", nil))
+
+	var response QuotasResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Enabled || response.Quotas == nil || len(response.Quotas) != 0 {
+		t.Errorf("GetQuotas() = %+v", response)
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"otelservices/internal/monitoring"
+
+	"github.com/gorilla/mux"
+)
+
+// maxRequestBody bounds the JSON bodies read by the validation middleware
+const maxRequestBody = 1 << 20
+
+// fieldRules are the constraints of a validate struct tag, a comma-separated
+// list of required, min=N, max=N and oneof=a b c. Empty values pass oneof
+// unless the field is also required.
+type fieldRules struct {
+	required bool
+	min, max *float64
+	oneOf    []string
+}
+
+func parseRules(tag string) fieldRules {
+	var rules fieldRules
+	for _, rule := range strings.Split(tag, ",") {
+		name, arg, _ := strings.Cut(rule, "=")
+		switch name {
+		case "required":
+			rules.required = true
+		case "min", "max":
+			n, err := strconv.ParseFloat(arg, 64)
+			if err != nil {
+				panic(fmt.Sprintf("invalid validate tag %q", tag))
+			}
+			if name == "min" {
+				rules.min = &n
+			} else {
+				rules.max = &n
+			}
+		case "oneof":
+			rules.oneOf = strings.Fields(arg)
+		}
+	}
+	return rules
+}
+
+// validateRequestBody decodes body into v and checks v's validate tags and,
+// when v has one, its validate method. Errors name the offending field.
+func validateRequestBody(body []byte, v interface{}) error {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return fmt.Errorf("request body is required")
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return describeDecodeError(err)
+	}
+	if err := validateValue(reflect.ValueOf(v), ""); err != nil {
+		return err
+	}
+	if validator, ok := v.(interface{ validate() error }); ok {
+		return validator.validate()
+	}
+	return nil
+}
+
+// describeDecodeError rewrites JSON decoding errors in terms of the request's
+// field names and JSON types rather than Go's
+func describeDecodeError(err error) error {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		return fmt.Errorf("%s: expected %s, got %s", typeErr.Field, jsonTypeName(typeErr.Type), typeErr.Value)
+	}
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		return fmt.Errorf("invalid JSON at offset %d: %v", syntaxErr.Offset, syntaxErr)
+	}
+	return fmt.Errorf("invalid request body: %v", err)
+}
+
+func jsonTypeName(t reflect.Type) string {
+	if t == timeType {
+		return "an RFC 3339 time string"
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return "integer"
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "non-negative integer"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	}
+	return "object"
+}
+
+// validateValue checks the fields of structs reachable from v, descending
+// into pointers, slices and nested structs. path is the JSON path of v.
+func validateValue(v reflect.Value, path string) error {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return validateValue(v.Elem(), path)
+	case reflect.Slice, reflect.Array:
+		if v.Type() == rawMessageType {
+			return nil
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err := validateValue(v.Index(i), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		if v.Type() == timeType {
+			return nil
+		}
+		for i := 0; i < v.NumField(); i++ {
+			f := v.Type().Field(i)
+			name := jsonFieldName(f)
+			if name == "" {
+				continue
+			}
+			if path != "" {
+				name = path + "." + name
+			}
+			if err := checkRules(v.Field(i), name, parseRules(f.Tag.Get("validate"))); err != nil {
+				return err
+			}
+			if err := validateValue(v.Field(i), name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func checkRules(v reflect.Value, name string, rules fieldRules) error {
+	if v.IsZero() || (v.Type() == rawMessageType && string(v.Bytes()) == "null") {
+		if rules.required {
+			return fmt.Errorf("%s is required", name)
+		}
+		return nil
+	}
+
+	var n float64
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n = float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n = float64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		n = v.Float()
+	case reflect.String:
+		if len(rules.oneOf) > 0 && !contains(rules.oneOf, v.String()) {
+			return fmt.Errorf("%s must be one of %s, got %q", name, strings.Join(rules.oneOf, ", "), v.String())
+		}
+		return nil
+	default:
+		return nil
+	}
+	if rules.min != nil && n < *rules.min {
+		return fmt.Errorf("%s must be at least %g, got %g", name, *rules.min, n)
+	}
+	if rules.max != nil && n > *rules.max {
+		return fmt.Errorf("%s must be at most %g, got %g", name, *rules.max, n)
+	}
+	return nil
+}
+
+// validate checks that the time range is not inverted
+func (req *MetricsQueryRequest) validate() error {
+	if req.EndTime.Before(req.StartTime) {
+		return fmt.Errorf("end_time must not be before start_time")
+	}
+	return nil
+}
+
+// validate checks the types of the attribute filters
+func (req *TraceQueryRequest) validate() error {
+	return validateAttributeFilters(req.AttributeFilters)
+}
+
+// validate checks that the time range is not inverted and the types of the
+// attribute filters
+func (req *LogsQueryRequest) validate() error {
+	if req.EndTime.Before(req.StartTime) {
+		return fmt.Errorf("end_time must not be before start_time")
+	}
+	return validateAttributeFilters(req.AttributeFilters)
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// validateRequests is router middleware that checks JSON request bodies
+// against the operation's request type before the handler runs, so invalid
+// requests get a 400 naming the field instead of a ClickHouse error
+func validateRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if route := mux.CurrentRoute(r); route != nil {
+			tmpl, _ := route.GetPathTemplate()
+			if op := findOperation(r.Method, tmpl); op != nil && !checkBody(w, r, op) {
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// withValidation applies the scopes and the validation of the POST
+// operation at route to a handler called in process, which bypasses the
+// router
+func withValidation(route string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(w, r, route) {
+			return
+		}
+		if op := findOperation(http.MethodPost, route); op != nil && !checkBody(w, r, op) {
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// checkBody validates the request body for op and reports whether the
+// request may proceed, writing a 400 otherwise. The body is replaced by the
+// prepared one, with relative times resolved, so the handler can read it.
+func checkBody(w http.ResponseWriter, r *http.Request, op *apiOperation) bool {
+	if op.request == nil {
+		return true
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBody))
+	if err == nil {
+		body, err = prepareRequestBody(body, op.request(), time.Now())
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		monitoring.QueryErrors.WithLabelValues("validation").Inc()
+		return false
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	setAuditFilters(r.Context(), op.route, op, body)
+	return true
+}
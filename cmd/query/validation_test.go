@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"otelservices/internal/config"
+)
+
+func TestValidateRequestBody(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		request interface{}
+		wantErr string
+	}{
+		{"valid metrics", `{"metric_name": "cpu", "start_time": "2024-01-01T00:00:00Z", "end_time": "2024-01-01T01:00:00Z", "aggregation": "max"}`, &MetricsQueryRequest{}, ""},
+		{"empty body", ``, &MetricsQueryRequest{}, "request body is required"},
+		{"syntax", `{"metric_name": }`, &MetricsQueryRequest{}, "invalid JSON at offset"},
+		{"missing field", `{"start_time": "2024-01-01T00:00:00Z", "end_time": "2024-01-01T01:00:00Z"}`, &MetricsQueryRequest{}, "metric_name is required"},
+		{"wrong type", `{"trace_id": "abc", "limit": "ten"}`, &TraceQueryRequest{}, "limit: expected integer, got string"},
+		{"bad time", `{"metric_name": "cpu", "start_time": "yesterday"}`, &MetricsQueryRequest{}, "invalid request body"},
+		{"out of range", `{"limit": 50000}`, &TraceQueryRequest{}, "limit must be at most 10000, got 50000"},
+		{"negative", `{"min_duration": -1}`, &TraceQueryRequest{}, "min_duration must be at least 0"},
+		{"enum", `{"metric_name": "cpu", "start_time": "2024-01-01T00:00:00Z", "end_time": "2024-01-01T01:00:00Z", "aggregation": "median"}`, &MetricsQueryRequest{}, `aggregation must be one of avg, min, max, sum, got "median"`},
+		{"inverted range", `{"start_time": "2024-01-02T00:00:00Z", "end_time": "2024-01-01T00:00:00Z"}`, &LogsQueryRequest{}, "end_time must not be before start_time"},
+		{"nested", `{"range": {}, "targets": [{"target": "cpu", "data": {"aggregation": "p99"}}]}`, &GrafanaQueryRequest{}, "targets[0].data.aggregation must be one of"},
+		{"validate method", `{"service_name": "api", "signals": ["profiles"]}`, &DeletionRequest{}, "profiles"},
+		{"raw query", `{"kind": "logs", "query": null}`, &JobSubmitRequest{}, "query is required"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateRequestBody([]byte(tt.body), tt.request)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("err = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateRequestsMiddleware(t *testing.T) {
+	router := newRouter(NewQueryService(config.DefaultConfig(), nil))
+	body := `{"start_time": "2024-01-01T00:00:00Z", "end_time": "2024-01-01T01:00:00Z", "limit": -5}`
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/logs", bytes.NewBufferString(body)))
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "limit must be at least 0") {
+		t.Errorf("status %d, body %q", rec.Code, rec.Body.String())
+	}
+
+	// Job queries are checked against the endpoint they run
+	body = `{"kind": "metrics", "query": {"start_time": "now-1h"}}`
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/jobs", bytes.NewBufferString(body)))
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "query: metric_name is required") {
+		t.Errorf("status %d, body %q", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"otelservices/internal/monitoring"
+)
+
+const (
+	// defaultWatermarkLateness is subtracted from the latest stored timestamp
+	// to allow for data still buffered in collectors or retried
+	defaultWatermarkLateness = 1 * time.Minute
+	// watermarkLookback limits how far back the tables are scanned; services
+	// silent for longer are not reported
+	watermarkLookback = 24 * time.Hour
+)
+
+// ServiceWatermark reports how far a service's telemetry can be safely read.
+// Latest holds the newest stored timestamp per signal; Watermark is the
+// oldest of those, capped at the current time, minus the allowed lateness.
+type ServiceWatermark struct {
+	ServiceName string               `json:"service_name"`
+	Watermark   time.Time            `json:"watermark"`
+	Latest      map[string]time.Time `json:"latest"`
+}
+
+type WatermarksResponse struct {
+	GeneratedAt time.Time          `json:"generated_at"`
+	Lateness    string             `json:"lateness"`
+	Services    []ServiceWatermark `json:"services"`
+}
+
+// signalLatest is the newest stored timestamp of one signal for one service
+type signalLatest struct {
+	Signal      string
+	ServiceName string
+	Latest      time.Time
+}
+
+// buildWatermarksQuery returns the newest timestamp per signal and service
+// within the lookback window, optionally for a single service
+func buildWatermarksQuery(since time.Time, serviceName string) (string, []interface{}) {
+	var query string
+	var args []interface{}
+	for i, table := range []struct{ signal, name string }{
+		{"traces", "otel_traces"},
+		{"metrics", "otel_metrics"},
+		{"logs", "otel_logs"},
+	} {
+		if i > 0 {
+			query += " UNION ALL "
+		}
+		query += fmt.Sprintf(`
+			SELECT '%s' AS signal, toString(service_name) AS service, toDateTime64(max(timestamp), 9) AS latest
+			FROM %s
+			WHERE timestamp >= ?`, table.signal, table.name)
+		args = append(args, since)
+		if serviceName != "" {
+			query += " AND service_name = ?"
+			args = append(args, serviceName)
+		}
+		query += " GROUP BY service_name"
+	}
+	return query, args
+}
+
+// computeWatermarks folds per-signal timestamps into per-service watermarks,
+// sorted by service name
+func computeWatermarks(latest []signalLatest, now time.Time, lateness time.Duration) []ServiceWatermark {
+	byService := make(map[string]*ServiceWatermark)
+	for _, l := range latest {
+		sw, ok := byService[l.ServiceName]
+		if !ok {
+			sw = &ServiceWatermark{ServiceName: l.ServiceName, Latest: make(map[string]time.Time)}
+			byService[l.ServiceName] = sw
+		}
+		sw.Latest[l.Signal] = l.Latest
+
+		// Clock skew can put timestamps in the future; never report past now
+		t := l.Latest
+		if t.After(now) {
+			t = now
+		}
+		t = t.Add(-lateness)
+		if sw.Watermark.IsZero() || t.Before(sw.Watermark) {
+			sw.Watermark = t
+		}
+	}
+
+	services := make([]ServiceWatermark, 0, len(byService))
+	for _, sw := range byService {
+		services = append(services, *sw)
+	}
+	sort.Slice(services, func(i, j int) bool { return services[i].ServiceName < services[j].ServiceName })
+	return services
+}
+
+func (s *QueryService) fetchSignalLatest(ctx context.Context, since time.Time, serviceName string) ([]signalLatest, error) {
+	query, args := buildWatermarksQuery(since, serviceName)
+	rows, err := s.chClient.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	latest := []signalLatest{}
+	for rows.Next() {
+		var l signalLatest
+		if err := rows.Scan(&l.Signal, &l.ServiceName, &l.Latest); err != nil {
+			return nil, fmt.Errorf("failed to scan watermark: %w", err)
+		}
+		latest = append(latest, l)
+	}
+	return latest, rows.Err()
+}
+
+// GetWatermarks returns per-service watermarks so downstream consumers know
+// up to which timestamp they can read without missing late data
+func (s *QueryService) GetWatermarks(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	defer func() {
+		monitoring.QueryDuration.WithLabelValues("watermarks").Observe(time.Since(start).Seconds())
+	}()
+
+	lateness := defaultWatermarkLateness
+	if val := r.URL.Query().Get("lateness"); val != "" {
+		d, err := time.ParseDuration(val)
+		if err != nil || d < 0 {
+			http.Error(w, fmt.Sprintf("invalid lateness %q", val), http.StatusBadRequest)
+			monitoring.QueryErrors.WithLabelValues("watermarks").Inc()
+			return
+		}
+		lateness = d
+	}
+
+	now := time.Now()
+	latest, err := s.fetchSignalLatest(r.Context(), now.Add(-watermarkLookback), r.URL.Query().Get("service"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		monitoring.QueryErrors.WithLabelValues("watermarks").Inc()
+		return
+	}
+
+	response := WatermarksResponse{
+		GeneratedAt: now,
+		Lateness:    lateness.String(),
+		Services:    computeWatermarks(latest, now, lateness),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestComputeWatermarks(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	latest := []signalLatest{
+		{Signal: "traces", ServiceName: "checkout", Latest: now.Add(-10 * time.Second)},
+		{Signal: "logs", ServiceName: "checkout", Latest: now.Add(-2 * time.Minute)},
+		{Signal: "metrics", ServiceName: "api", Latest: now.Add(time.Hour)}, // clock skew
+	}
+
+	got := computeWatermarks(latest, now, time.Minute)
+	if len(got) != 2 {
+		t.Fatalf("expected 2 services, got %d", len(got))
+	}
+
+	api, checkout := got[0], got[1]
+	if api.ServiceName != "api" || checkout.ServiceName != "checkout" {
+		t.Fatalf("services not sorted: %s, %s", api.ServiceName, checkout.ServiceName)
+	}
+	if want := now.Add(-time.Minute); !api.Watermark.Equal(want) {
+		t.Errorf("api watermark = %v, want %v (capped at now)", api.Watermark, want)
+	}
+	if want := now.Add(-3 * time.Minute); !checkout.Watermark.Equal(want) {
+		t.Errorf("checkout watermark = %v, want %v (slowest signal)", checkout.Watermark, want)
+	}
+	if len(checkout.Latest) != 2 || !checkout.Latest["traces"].Equal(now.Add(-10*time.Second)) {
+		t.Errorf("unexpected latest timestamps: %v", checkout.Latest)
+	}
+}
+
+func TestBuildWatermarksQuery(t *testing.T) {
+	since := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	query, args := buildWatermarksQuery(since, "")
+	if strings.Count(query, "UNION ALL") != 2 {
+		t.Errorf("expected one SELECT per signal table:\n%s", query)
+	}
+	if len(args) != 3 {
+		t.Errorf("expected 3 args, got %d", len(args))
+	}
+
+	query, args = buildWatermarksQuery(since, "checkout")
+	if strings.Count(query, "service_name = ?") != 3 {
+		t.Errorf("expected service filter on every table:\n%s", query)
+	}
+	if len(args) != 6 || args[1] != "checkout" {
+		t.Errorf("unexpected args: %v", args)
+	}
+}
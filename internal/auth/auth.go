@@ -0,0 +1,142 @@
+// Package auth authenticates API callers by API key or by a JWT issued by an
+// OpenID Connect provider, and resolves the scopes they are granted.
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"otelservices/internal/config"
+)
+
+// Scopes routes can require
+const (
+	ScopeReadTraces  = "read:traces"
+	ScopeReadMetrics = "read:metrics"
+	ScopeReadLogs    = "read:logs"
+	ScopeAdmin       = "admin" // grants every scope
+)
+
+var (
+	// ErrNoCredentials is returned when a request carries no API key or token
+	ErrNoCredentials = errors.New("missing credentials")
+	// ErrInvalidCredentials is returned for unknown API keys and tokens that
+	// fail validation
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// Principal is an authenticated caller
+type Principal struct {
+	Name   string   // API key name, or the subject of a token
+	Method string   // "api_key" or "jwt"
+	Scopes []string // granted directly and through roles
+}
+
+// Allowed reports whether the principal holds every one of scopes
+func (p *Principal) Allowed(scopes ...string) bool {
+	return p.Missing(scopes...) == ""
+}
+
+// Missing returns the first of scopes the principal does not hold, or ""
+func (p *Principal) Missing(scopes ...string) string {
+	if slices.Contains(p.Scopes, ScopeAdmin) {
+		return ""
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(p.Scopes, scope) {
+			return scope
+		}
+	}
+	return ""
+}
+
+// Authenticator checks API keys and JWTs against the auth config
+type Authenticator struct {
+	keys  map[[sha256.Size]byte]*Principal // by the SHA-256 digest of the key
+	roles map[string][]string
+	oidc  *oidcVerifier // nil without an issuer
+}
+
+// New creates an authenticator for a validated auth config
+func New(cfg config.AuthConfig) (*Authenticator, error) {
+	a := &Authenticator{keys: make(map[[sha256.Size]byte]*Principal, len(cfg.APIKeys)), roles: cfg.Roles}
+	for _, key := range cfg.APIKeys {
+		digest := sha256.Sum256([]byte(key.Key))
+		if key.KeySHA256 != "" {
+			b, err := hex.DecodeString(key.KeySHA256)
+			if err != nil || len(b) != sha256.Size {
+				return nil, fmt.Errorf("api key %q: key_sha256 must be 64 hex digits", key.Name)
+			}
+			copy(digest[:], b)
+		}
+		a.keys[digest] = &Principal{Name: key.Name, Method: "api_key", Scopes: a.grant(key.Scopes, key.Roles)}
+	}
+	if cfg.OIDC.IssuerURL != "" {
+		a.oidc = newOIDCVerifier(cfg.OIDC)
+	}
+	return a, nil
+}
+
+// Authenticate returns the principal of a credential: an API key, or a JWT
+// when an issuer is configured
+func (a *Authenticator) Authenticate(ctx context.Context, credential string) (*Principal, error) {
+	if credential == "" {
+		return nil, ErrNoCredentials
+	}
+	if p, ok := a.keys[sha256.Sum256([]byte(credential))]; ok {
+		return p, nil
+	}
+	if a.oidc == nil || strings.Count(credential, ".") != 2 {
+		return nil, ErrInvalidCredentials
+	}
+	claims, err := a.oidc.verify(ctx, credential)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCredentials, err)
+	}
+	return &Principal{
+		Name:   claims.subject(),
+		Method: "jwt",
+		Scopes: a.grant(claims.strings(a.oidc.cfg.ScopesClaim), claims.strings(a.oidc.cfg.RolesClaim)),
+	}, nil
+}
+
+// grant merges scopes with the scopes of roles; unknown roles grant nothing
+func (a *Authenticator) grant(scopes, roles []string) []string {
+	granted := append([]string(nil), scopes...)
+	for _, role := range roles {
+		for _, scope := range a.roles[role] {
+			if !slices.Contains(granted, scope) {
+				granted = append(granted, scope)
+			}
+		}
+	}
+	return granted
+}
+
+// Credential extracts the credential of a request from its Authorization
+// header ("Bearer <key or token>") or its X-API-Key header
+func Credential(authorization, apiKey string) string {
+	if scheme, value, ok := strings.Cut(authorization, " "); ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(value)
+	}
+	return strings.TrimSpace(apiKey)
+}
+
+type principalKey struct{}
+
+// NewContext returns a context carrying p
+func NewContext(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// FromContext returns the principal of ctx, or nil for unauthenticated and
+// internal calls
+func FromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
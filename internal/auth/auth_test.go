@@ -0,0 +1,265 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"otelservices/internal/config"
+)
+
+func TestAPIKeys(t *testing.T) {
+	digest := sha256.Sum256([]byte("ops-key"))
+	a, err := New(config.AuthConfig{
+		Roles: map[string][]string{"reader": {ScopeReadTraces, ScopeReadLogs}},
+		APIKeys: []config.APIKeyConfig{
+			{Name: "ci", Key: "ci-key", Scopes: []string{ScopeReadMetrics}, Roles: []string{"reader"}},
+			{Name: "ops", KeySHA256: hex.EncodeToString(digest[:]), Scopes: []string{ScopeAdmin}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := a.Authenticate(context.Background(), "ci-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Name != "ci" || p.Method != "api_key" || !p.Allowed(ScopeReadMetrics, ScopeReadTraces, ScopeReadLogs) {
+		t.Errorf("ci = %+v", p)
+	}
+	if missing := p.Missing(ScopeReadLogs, ScopeAdmin); missing != ScopeAdmin {
+		t.Errorf("missing = %q", missing)
+	}
+
+	p, err = a.Authenticate(context.Background(), "ops-key")
+	if err != nil || p.Name != "ops" || !p.Allowed(ScopeReadTraces, ScopeAdmin) {
+		t.Errorf("ops = %+v, %v", p, err)
+	}
+
+	if _, err := a.Authenticate(context.Background(), ""); !errors.Is(err, ErrNoCredentials) {
+		t.Errorf("empty credential: %v", err)
+	}
+	if _, err := a.Authenticate(context.Background(), "guess"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("unknown key: %v", err)
+	}
+}
+
+func TestCredential(t *testing.T) {
+	tests := []struct{ authorization, apiKey, want string }{
+		{"Bearer abc", "", "abc"},
+		{"bearer  abc ", "xyz", "abc"},
+		{"", "xyz", "xyz"},
+		{"Basic dXNlcjpwYXNz", "", ""},
+	}
+	for _, tt := range tests {
+		if got := Credential(tt.authorization, tt.apiKey); got != tt.want {
+			t.Errorf("Credential(%q, %q) = %q, want %q", tt.authorization, tt.apiKey, got, tt.want)
+		}
+	}
+}
+
+// testIssuer is an OIDC provider publishing an RSA and an EC signing key
+type testIssuer struct {
+	*httptest.Server
+	rsaKey      *rsa.PrivateKey
+	ecKey       *ecdsa.PrivateKey
+	jwksFetches atomic.Int32
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iss := &testIssuer{rsaKey: rsaKey, ecKey: ecKey}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"issuer": iss.URL, "jwks_uri": iss.URL + "/keys"})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		iss.jwksFetches.Add(1)
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{
+			{"kty": "RSA", "kid": "rsa1", "use": "sig", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+			{"kty": "EC", "kid": "ec1", "crv": "P-256", "x": b64(ecKey.X.FillBytes(make([]byte, 32))), "y": b64(ecKey.Y.FillBytes(make([]byte, 32)))},
+			{"kty": "oct", "kid": "hmac", "k": b64([]byte("secret"))},
+		}})
+	})
+	iss.Server = httptest.NewServer(mux)
+	t.Cleanup(iss.Close)
+	return iss
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// sign returns a token with claims, signed with alg and the key kid
+func (iss *testIssuer) sign(t *testing.T, alg, kid string, claims map[string]interface{}) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := b64(header) + "." + b64(payload)
+	sum := sha256.Sum256([]byte(signed))
+	var sig []byte
+	var err error
+	switch alg {
+	case "RS256":
+		sig, err = rsa.SignPKCS1v15(rand.Reader, iss.rsaKey, crypto.SHA256, sum[:])
+	case "PS256":
+		sig, err = rsa.SignPSS(rand.Reader, iss.rsaKey, crypto.SHA256, sum[:], nil)
+	case "ES256":
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, iss.ecKey, sum[:])
+		sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	default:
+		sig = []byte("signature")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + b64(sig)
+}
+
+func TestJWT(t *testing.T) {
+	iss := newTestIssuer(t)
+	cfg := config.DefaultConfig().Auth
+	cfg.OIDC.IssuerURL = iss.URL
+	cfg.OIDC.Audience = "otel-query"
+	cfg.OIDC.RolesClaim = "realm_access.roles"
+	cfg.Roles = map[string][]string{"sre": {ScopeReadTraces, ScopeReadLogs}}
+	a, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	valid := func() map[string]interface{} {
+		return map[string]interface{}{
+			"iss":          iss.URL,
+			"sub":          "alice",
+			"aud":          []string{"otel-query", "account"},
+			"exp":          now.Add(time.Hour).Unix(),
+			"scope":        "openid read:metrics",
+			"realm_access": map[string]interface{}{"roles": []string{"sre", "offline_access"}},
+		}
+	}
+
+	for _, alg := range []string{"RS256", "PS256", "ES256"} {
+		kid := "rsa1"
+		if alg == "ES256" {
+			kid = "ec1"
+		}
+		p, err := a.Authenticate(context.Background(), iss.sign(t, alg, kid, valid()))
+		if err != nil {
+			t.Fatalf("%s: %v", alg, err)
+		}
+		if p.Name != "alice" || p.Method != "jwt" || !p.Allowed(ScopeReadMetrics, ScopeReadTraces, ScopeReadLogs) || p.Allowed(ScopeAdmin) {
+			t.Errorf("%s: principal = %+v", alg, p)
+		}
+	}
+	if n := iss.jwksFetches.Load(); n != 1 {
+		t.Errorf("keys fetched %d times, want 1", n)
+	}
+
+	tests := []struct {
+		name    string
+		token   func() string
+		wantErr string
+	}{
+		{"expired", func() string {
+			c := valid()
+			c["exp"] = now.Add(-2 * time.Minute).Unix()
+			return iss.sign(t, "RS256", "rsa1", c)
+		}, "token expired"},
+		{"not yet valid", func() string {
+			c := valid()
+			c["nbf"] = now.Add(10 * time.Minute).Unix()
+			return iss.sign(t, "RS256", "rsa1", c)
+		}, "not valid yet"},
+		{"no expiry", func() string {
+			c := valid()
+			delete(c, "exp")
+			return iss.sign(t, "RS256", "rsa1", c)
+		}, "no expiry"},
+		{"other issuer", func() string {
+			c := valid()
+			c["iss"] = "https://evil.example.com"
+			return iss.sign(t, "RS256", "rsa1", c)
+		}, "token issuer"},
+		{"other audience", func() string {
+			c := valid()
+			c["aud"] = "grafana"
+			return iss.sign(t, "RS256", "rsa1", c)
+		}, "audience"},
+		{"tampered", func() string {
+			token := iss.sign(t, "RS256", "rsa1", valid())
+			c := valid()
+			c["scope"] = "admin"
+			payload, _ := json.Marshal(c)
+			parts := strings.Split(token, ".")
+			return parts[0] + "." + b64(payload) + "." + parts[2]
+		}, "invalid token signature"},
+		{"symmetric", func() string { return iss.sign(t, "HS256", "hmac", valid()) }, "unknown signing key"},
+		{"unsigned", func() string { return iss.sign(t, "none", "rsa1", valid()) }, "unsupported token algorithm"},
+		{"key type mismatch", func() string { return iss.sign(t, "ES256", "rsa1", valid()) }, "does not match"},
+	}
+	for _, tt := range tests {
+		_, err := a.Authenticate(context.Background(), tt.token())
+		if !errors.Is(err, ErrInvalidCredentials) || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: err = %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestKeyRefresh(t *testing.T) {
+	iss := newTestIssuer(t)
+	cfg := config.DefaultConfig().Auth.OIDC
+	cfg.IssuerURL = iss.URL
+	cfg.JWKSURL = iss.URL + "/keys"
+	v := newOIDCVerifier(cfg)
+	now := time.Now()
+	v.now = func() time.Time { return now }
+
+	for _, kid := range []string{"rsa1", "ec1", "rotated", "rotated"} {
+		v.key(context.Background(), kid)
+	}
+	// An unknown key ID refreshes the keys at most every minKeyRefresh
+	if n := iss.jwksFetches.Load(); n != 1 {
+		t.Errorf("keys fetched %d times, want 1", n)
+	}
+	now = now.Add(minKeyRefresh)
+	if _, err := v.key(context.Background(), "rotated"); err == nil || iss.jwksFetches.Load() != 2 {
+		t.Errorf("err = %v after %d fetches", err, iss.jwksFetches.Load())
+	}
+	now = now.Add(cfg.RefreshInterval)
+	if _, err := v.key(context.Background(), "rsa1"); err != nil || iss.jwksFetches.Load() != 3 {
+		t.Errorf("err = %v after %d fetches", err, iss.jwksFetches.Load())
+	}
+
+	// Keys already fetched stay in use while the issuer is down
+	iss.Close()
+	now = now.Add(cfg.RefreshInterval)
+	if _, err := v.key(context.Background(), "rsa1"); err != nil {
+		t.Errorf("key after a failed refresh: %v", err)
+	}
+}
@@ -0,0 +1,387 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"otelservices/internal/config"
+)
+
+const (
+	// keyFetchTimeout bounds discovery and JWKS requests
+	keyFetchTimeout = 10 * time.Second
+	// minKeyRefresh rate-limits refreshes for tokens signed with unknown key
+	// IDs, which a provider rotating its keys produces until the next refresh
+	minKeyRefresh = time.Minute
+)
+
+// oidcVerifier validates JWTs signed with the issuer's published keys. The
+// keys are fetched on first use and re-read every refresh interval, or
+// sooner when a token names a key ID they do not include.
+type oidcVerifier struct {
+	cfg    config.OIDCConfig
+	client *http.Client
+	now    func() time.Time
+
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey // by key ID
+	fetchedAt time.Time
+	jwksURL   string
+}
+
+func newOIDCVerifier(cfg config.OIDCConfig) *oidcVerifier {
+	return &oidcVerifier{
+		cfg:     cfg,
+		client:  &http.Client{Timeout: keyFetchTimeout},
+		now:     time.Now,
+		jwksURL: cfg.JWKSURL,
+	}
+}
+
+// claims are the decoded payload of a JWT
+type claims map[string]interface{}
+
+// subject names the caller: the sub claim, or the client of a token issued
+// to a service without one
+func (c claims) subject() string {
+	for _, name := range []string{"sub", "client_id", "azp"} {
+		if s, ok := c[name].(string); ok && s != "" {
+			return s
+		}
+	}
+	return "unknown"
+}
+
+// strings reads a claim holding a space-separated string or a list of
+// strings. Dots in name reach into nested objects, as in
+// realm_access.roles.
+func (c claims) strings(name string) []string {
+	if name == "" {
+		return nil
+	}
+	var v interface{} = map[string]interface{}(c)
+	for _, part := range strings.Split(name, ".") {
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = obj[part]
+	}
+	switch v := v.(type) {
+	case string:
+		return strings.Fields(v)
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// audience reports whether the aud claim, a string or a list, includes aud
+func (c claims) audience(aud string) bool {
+	switch v := c["aud"].(type) {
+	case string:
+		return v == aud
+	case []interface{}:
+		for _, item := range v {
+			if item == aud {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// time reads a NumericDate claim
+func (c claims) time(name string) (time.Time, bool) {
+	n, ok := c[name].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(n), 0), true
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// verify checks the signature, issuer, audience and lifetime of a token and
+// returns its claims
+func (v *oidcVerifier) verify(ctx context.Context, token string) (claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("token header: %w", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("token signature: %w", err)
+	}
+	key, err := v.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return nil, err
+	}
+
+	var c claims
+	if err := decodeSegment(parts[1], &c); err != nil {
+		return nil, fmt.Errorf("token claims: %w", err)
+	}
+	if iss, _ := c["iss"].(string); iss != v.cfg.IssuerURL {
+		return nil, fmt.Errorf("token issuer %q is not %q", iss, v.cfg.IssuerURL)
+	}
+	if v.cfg.Audience != "" && !c.audience(v.cfg.Audience) {
+		return nil, fmt.Errorf("token audience does not include %q", v.cfg.Audience)
+	}
+	now := v.now()
+	exp, ok := c.time("exp")
+	if !ok {
+		return nil, errors.New("token has no expiry")
+	}
+	if now.After(exp.Add(v.cfg.ClockSkew)) {
+		return nil, errors.New("token expired")
+	}
+	if nbf, ok := c.time("nbf"); ok && now.Add(v.cfg.ClockSkew).Before(nbf) {
+		return nil, errors.New("token not valid yet")
+	}
+	return c, nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// verifySignature checks an RS*, PS* or ES* signature. Symmetric and
+// unsigned algorithms are refused, as the keys are public.
+func verifySignature(alg string, key crypto.PublicKey, signed, sig []byte) error {
+	if len(alg) != 5 {
+		return fmt.Errorf("unsupported token algorithm %q", alg)
+	}
+	var h crypto.Hash
+	switch alg[2:] {
+	case "256":
+		h = crypto.SHA256
+	case "384":
+		h = crypto.SHA384
+	case "512":
+		h = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported token algorithm %q", alg)
+	}
+	digest := newHash(h)
+	digest.Write(signed)
+	sum := digest.Sum(nil)
+
+	switch alg[:2] {
+	case "RS", "PS":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("token algorithm %s does not match an RSA key", alg)
+		}
+		var err error
+		if alg[0] == 'R' {
+			err = rsa.VerifyPKCS1v15(pub, h, sum, sig)
+		} else {
+			err = rsa.VerifyPSS(pub, h, sum, sig, nil)
+		}
+		if err != nil {
+			return errors.New("invalid token signature")
+		}
+		return nil
+	case "ES":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("token algorithm %s does not match an EC key", alg)
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return errors.New("invalid token signature")
+		}
+		r, s := new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(pub, sum, r, s) {
+			return errors.New("invalid token signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported token algorithm %q", alg)
+}
+
+func newHash(h crypto.Hash) hash.Hash {
+	switch h {
+	case crypto.SHA384:
+		return sha512.New384()
+	case crypto.SHA512:
+		return sha512.New()
+	}
+	return sha256.New()
+}
+
+// key returns the signing key with ID kid, or the only key when the token
+// names none
+func (v *oidcVerifier) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	since := v.now().Sub(v.fetchedAt)
+	key, ok := v.lookup(kid)
+	if since >= v.cfg.RefreshInterval || (!ok && since >= minKeyRefresh) {
+		if err := v.refresh(ctx); err != nil && v.keys == nil {
+			return nil, fmt.Errorf("fetching signing keys: %w", err)
+		}
+		key, ok = v.lookup(kid)
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+func (v *oidcVerifier) lookup(kid string) (crypto.PublicKey, bool) {
+	if key, ok := v.keys[kid]; ok {
+		return key, true
+	}
+	if kid == "" && len(v.keys) == 1 {
+		for _, key := range v.keys {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+// refresh re-reads the JWKS, discovering its URL from the issuer first if
+// it is not configured. The previous keys are kept when it fails.
+func (v *oidcVerifier) refresh(ctx context.Context) error {
+	v.fetchedAt = v.now()
+	if v.jwksURL == "" {
+		var discovery struct {
+			JWKSURI string `json:"jwks_uri"`
+		}
+		if err := v.get(ctx, strings.TrimSuffix(v.cfg.IssuerURL, "/")+"/.well-known/openid-configuration", &discovery); err != nil {
+			return err
+		}
+		if discovery.JWKSURI == "" {
+			return errors.New("discovery document has no jwks_uri")
+		}
+		v.jwksURL = discovery.JWKSURI
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := v.get(ctx, v.jwksURL, &set); err != nil {
+		return err
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		// Keys of other types, such as symmetric ones, are skipped
+		if key, err := k.publicKey(); err == nil {
+			keys[k.Kid] = key
+		}
+	}
+	if len(keys) == 0 {
+		return errors.New("no usable signing keys")
+	}
+	v.keys = keys
+	return nil
+}
+
+func (v *oidcVerifier) get(ctx context.Context, url string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// jwk is a JSON Web Key of a JWKS
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) > 4 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		pub := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, errors.New("EC key is not on its curve")
+		}
+		return pub, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
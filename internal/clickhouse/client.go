@@ -3,11 +3,17 @@ package clickhouse
 import (
 	"context"
 	"crypto/tls"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"otelservices/internal/config"
 	"otelservices/internal/models"
+	"otelservices/internal/monitoring"
 
 	"github.com/ClickHouse/clickhouse-go/v2"
 	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
@@ -15,30 +21,91 @@ import (
 
 // Client wraps a ClickHouse connection
 type Client struct {
+	mu     sync.RWMutex
 	conn   driver.Conn
+	addrs  []string // resolved addresses conn was opened with
 	config *config.ClickHouseConfig
+
+	resolver addressResolver
+	dial     func(addrs []string) (driver.Conn, error)
+	stop     chan struct{}
+
+	unhealthy atomic.Bool             // set by the watchdog
+	health    *monitoring.HealthCheck // notified by the watchdog, may be nil
 }
 
-// NewClient creates a new ClickHouse client
+// NewClient creates a new ClickHouse client. Addresses that use DNS discovery
+// are resolved before connecting and, with a discovery interval, re-resolved
+// in the background.
 func NewClient(cfg *config.ClickHouseConfig) (*Client, error) {
+	c := &Client{
+		config:   cfg,
+		resolver: net.DefaultResolver,
+		stop:     make(chan struct{}),
+	}
+	c.dial = c.open
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	addrs, err := resolveAddresses(ctx, c.resolver, cfg.Addresses)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve ClickHouse addresses: %w", err)
+	}
+	conn, err := c.dial(addrs)
+	if err != nil {
+		return nil, err
+	}
+	c.conn, c.addrs = conn, addrs
+	monitoring.ClickHouseAddresses.Set(float64(len(addrs)))
+	monitoring.ClickHouseUp.Set(1)
+
+	if cfg.DiscoveryInterval > 0 && usesDiscovery(cfg.Addresses) {
+		go c.discover(cfg.DiscoveryInterval)
+	}
+	if cfg.Watchdog.Interval > 0 {
+		go c.watch(cfg.Watchdog)
+	}
+	if cfg.Cluster.Enabled() && cfg.Cluster.HealthCheckInterval > 0 {
+		go c.checkShards(cfg.Cluster.HealthCheckInterval)
+	}
+	if cfg.CompressionStatsInterval > 0 {
+		go c.reportCompression(cfg.CompressionStatsInterval)
+	}
+	return c, nil
+}
+
+// open connects to addrs and checks the connection with a ping
+func (c *Client) open(addrs []string) (driver.Conn, error) {
+	cfg := c.config
 	opts := &clickhouse.Options{
-		Addr: cfg.Addresses,
+		Addr: addrs,
 		Auth: clickhouse.Auth{
 			Database: cfg.Database,
 			Username: cfg.Username,
 			Password: cfg.Password,
 		},
-		DialTimeout:      cfg.DialTimeout,
-		MaxOpenConns:     cfg.MaxOpenConns,
-		MaxIdleConns:     cfg.MaxIdleConns,
-		ConnMaxLifetime:  cfg.ConnMaxLifetime,
-		Compression: &clickhouse.Compression{
-			Method: clickhouse.CompressionZSTD,
-		},
+		DialTimeout:     cfg.DialTimeout,
+		MaxOpenConns:    cfg.MaxOpenConns,
+		MaxIdleConns:    cfg.MaxIdleConns,
+		ConnMaxLifetime: cfg.ConnMaxLifetime,
+		Compression:     compression(cfg),
 		Settings: clickhouse.Settings{
 			"max_execution_time": 60,
 		},
 	}
+	for name, value := range cfg.Settings {
+		opts.Settings[name] = value
+	}
+	if cfg.UsesHTTP() {
+		opts.Protocol = clickhouse.HTTP
+		opts.HttpHeaders = cfg.HTTPHeaders
+	}
+
+	// Local inserts spread batches over the nodes instead of sending all of
+	// them to the first address
+	if cfg.Cluster.InsertMode == config.InsertLocal {
+		opts.ConnOpenStrategy = clickhouse.ConnOpenRoundRobin
+	}
 
 	// Only configure TLS if explicitly needed
 	// For local Docker deployments without TLS, leave it nil
@@ -57,18 +124,24 @@ func NewClient(cfg *config.ClickHouseConfig) (*Client, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	if err := conn.Ping(ctx); err != nil {
+		conn.Close()
 		return nil, fmt.Errorf("failed to ping ClickHouse: %w", err)
 	}
+	return conn, nil
+}
 
-	return &Client{
-		conn:   conn,
-		config: cfg,
-	}, nil
+// current returns the connection to use for the next operation; discovery
+// may replace it between calls
+func (c *Client) current() driver.Conn {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.conn
 }
 
-// Close closes the ClickHouse connection
+// Close stops address discovery and the watchdog and closes the ClickHouse connection
 func (c *Client) Close() error {
-	return c.conn.Close()
+	close(c.stop)
+	return c.current().Close()
 }
 
 // InsertMetrics inserts a batch of metrics into ClickHouse
@@ -76,39 +149,60 @@ func (c *Client) InsertMetrics(ctx context.Context, metrics []models.Metric) err
 	if len(metrics) == 0 {
 		return nil
 	}
+	cols := models.AcquireMetricColumns(len(metrics))
+	defer models.ReleaseMetricColumns(cols)
+	for i := range metrics {
+		cols.Append(&metrics[i])
+	}
+	return c.InsertMetricColumns(ctx, cols)
+}
+
+// InsertLogs inserts a batch of logs into ClickHouse
+func (c *Client) InsertLogs(ctx context.Context, logs []models.LogRecord) error {
+	if len(logs) == 0 {
+		return nil
+	}
+	cols := models.AcquireLogColumns(len(logs))
+	defer models.ReleaseLogColumns(cols)
+	for i := range logs {
+		cols.Append(&logs[i])
+	}
+	return c.InsertLogColumns(ctx, cols)
+}
+
+// InsertSpans inserts a batch of spans into ClickHouse
+func (c *Client) InsertSpans(ctx context.Context, spans []models.Span) error {
+	if len(spans) == 0 {
+		return nil
+	}
+	cols := models.AcquireSpanColumns(len(spans))
+	defer models.ReleaseSpanColumns(cols)
+	for i := range spans {
+		cols.Append(&spans[i])
+	}
+	return c.InsertSpanColumns(ctx, cols)
+}
+
+// InsertServiceOperations inserts service/operation dictionary entries into ClickHouse
+func (c *Client) InsertServiceOperations(ctx context.Context, ops []models.ServiceOperation) (err error) {
+	if len(ops) == 0 {
+		return nil
+	}
+	ctx, ins := c.startInsert(ctx, "otel_service_operations", "operations", len(ops))
+	defer func() { ins.end(err) }()
 
-	batch, err := c.conn.PrepareBatch(ctx, `
-		INSERT INTO otel_metrics (
-			timestamp, metric_name, metric_type, value,
-			service_name, service_namespace, service_instance_id, deployment_environment,
-			attributes, resource_attributes,
-			bucket_counts, explicit_bounds,
-			instrumentation_scope_name, instrumentation_scope_version
+	batch, err := c.current().PrepareBatch(ctx, fmt.Sprintf(`
+		INSERT INTO %s (
+			timestamp, service_name, span_name, span_kind
 		)
-	`)
+	`, c.insertTable("otel_service_operations")))
 	if err != nil {
 		return fmt.Errorf("failed to prepare batch: %w", err)
 	}
 
-	for _, m := range metrics {
-		err := batch.Append(
-			m.Timestamp,
-			m.MetricName,
-			m.MetricType,
-			m.Value,
-			m.ServiceName,
-			m.ServiceNamespace,
-			m.ServiceInstanceID,
-			m.DeploymentEnvironment,
-			m.Attributes,
-			m.ResourceAttributes,
-			m.BucketCounts,
-			m.ExplicitBounds,
-			m.InstrumentationScopeName,
-			m.InstrumentationScopeVersion,
-		)
-		if err != nil {
-			return fmt.Errorf("failed to append metric: %w", err)
+	for _, op := range ops {
+		if err := batch.Append(op.Timestamp, op.ServiceName, op.SpanName, op.SpanKind); err != nil {
+			return fmt.Errorf("failed to append service operation: %w", err)
 		}
 	}
 
@@ -119,49 +213,30 @@ func (c *Client) InsertMetrics(ctx context.Context, metrics []models.Metric) err
 	return nil
 }
 
-// InsertLogs inserts a batch of logs into ClickHouse
-func (c *Client) InsertLogs(ctx context.Context, logs []models.LogRecord) error {
-	if len(logs) == 0 {
+// InsertIngestUsage adds ingest usage to the otel_ingest_usage accounting
+// table, whose SummingMergeTree engine adds up rows of the same hour. Block
+// deduplication is off for these inserts: two flushes of equal usage are
+// both real.
+func (c *Client) InsertIngestUsage(ctx context.Context, usage []models.IngestUsage) (err error) {
+	if len(usage) == 0 {
 		return nil
 	}
+	ctx, ins := c.startInsert(ctx, "otel_ingest_usage", "usage", len(usage))
+	defer func() { ins.end(err) }()
+	ctx = clickhouse.Context(ctx, clickhouse.WithSettings(clickhouse.Settings{"insert_deduplicate": 0}))
 
-	batch, err := c.conn.PrepareBatch(ctx, `
-		INSERT INTO otel_logs (
-			timestamp, observed_timestamp, severity_number, severity_text,
-			body, body_type,
-			service_name, service_namespace, service_instance_id, deployment_environment, host_name,
-			trace_id, span_id, trace_flags,
-			attributes, resource_attributes,
-			instrumentation_scope_name, instrumentation_scope_version
+	batch, err := c.current().PrepareBatch(ctx, fmt.Sprintf(`
+		INSERT INTO %s (
+			timestamp, service_name, service_namespace, signal, bytes, items
 		)
-	`)
+	`, c.insertTable("otel_ingest_usage")))
 	if err != nil {
 		return fmt.Errorf("failed to prepare batch: %w", err)
 	}
 
-	for _, l := range logs {
-		err := batch.Append(
-			l.Timestamp,
-			l.ObservedTimestamp,
-			l.SeverityNumber,
-			l.SeverityText,
-			l.Body,
-			l.BodyType,
-			l.ServiceName,
-			l.ServiceNamespace,
-			l.ServiceInstanceID,
-			l.DeploymentEnvironment,
-			l.HostName,
-			l.TraceID,
-			l.SpanID,
-			l.TraceFlags,
-			l.Attributes,
-			l.ResourceAttributes,
-			l.InstrumentationScopeName,
-			l.InstrumentationScopeVersion,
-		)
-		if err != nil {
-			return fmt.Errorf("failed to append log: %w", err)
+	for _, u := range usage {
+		if err := batch.Append(u.Timestamp, u.ServiceName, u.ServiceNamespace, u.Signal, u.Bytes, u.Items); err != nil {
+			return fmt.Errorf("failed to append ingest usage: %w", err)
 		}
 	}
 
@@ -172,65 +247,30 @@ func (c *Client) InsertLogs(ctx context.Context, logs []models.LogRecord) error
 	return nil
 }
 
-// InsertSpans inserts a batch of spans into ClickHouse
-func (c *Client) InsertSpans(ctx context.Context, spans []models.Span) error {
-	if len(spans) == 0 {
+// InsertAuditRecords writes query API requests to the otel_audit table
+func (c *Client) InsertAuditRecords(ctx context.Context, records []models.AuditRecord) (err error) {
+	if len(records) == 0 {
 		return nil
 	}
+	ctx, ins := c.startInsert(ctx, "otel_audit", "records", len(records))
+	defer func() { ins.end(err) }()
 
-	batch, err := c.conn.PrepareBatch(ctx, `
-		INSERT INTO otel_traces (
-			timestamp, trace_id, span_id, parent_span_id,
-			span_name, span_kind, start_time, end_time, duration_ns,
-			status_code, status_message,
-			service_name, service_namespace, service_instance_id, deployment_environment,
-			attributes, resource_attributes,
-			events, links,
-			instrumentation_scope_name, instrumentation_scope_version
+	batch, err := c.current().PrepareBatch(ctx, fmt.Sprintf(`
+		INSERT INTO %s (
+			timestamp, request_id, principal, auth_method, method, route,
+			filters, status, rows, duration_ns, remote_addr
 		)
-	`)
+	`, c.insertTable("otel_audit")))
 	if err != nil {
 		return fmt.Errorf("failed to prepare batch: %w", err)
 	}
 
-	for _, s := range spans {
-		// Convert events to ClickHouse tuples
-		events := make([]interface{}, len(s.Events))
-		for i, e := range s.Events {
-			events[i] = []interface{}{e.Timestamp, e.Name, e.Attributes}
-		}
-
-		// Convert links to ClickHouse tuples
-		links := make([]interface{}, len(s.Links))
-		for i, l := range s.Links {
-			links[i] = []interface{}{l.TraceID, l.SpanID, l.TraceState, l.Attributes}
-		}
-
-		err := batch.Append(
-			s.Timestamp,
-			s.TraceID,
-			s.SpanID,
-			s.ParentSpanID,
-			s.SpanName,
-			s.SpanKind,
-			s.StartTime,
-			s.EndTime,
-			s.DurationNs,
-			s.StatusCode,
-			s.StatusMessage,
-			s.ServiceName,
-			s.ServiceNamespace,
-			s.ServiceInstanceID,
-			s.DeploymentEnvironment,
-			s.Attributes,
-			s.ResourceAttributes,
-			events,
-			links,
-			s.InstrumentationScopeName,
-			s.InstrumentationScopeVersion,
-		)
-		if err != nil {
-			return fmt.Errorf("failed to append span: %w", err)
+	for _, r := range records {
+		if err := batch.Append(
+			r.Timestamp, r.RequestID, r.Principal, r.AuthMethod, r.Method, r.Route,
+			r.Filters, uint16(r.Status), r.Rows, uint64(r.Duration), r.RemoteAddr,
+		); err != nil {
+			return fmt.Errorf("failed to append audit record: %w", err)
 		}
 	}
 
@@ -241,17 +281,123 @@ func (c *Client) InsertSpans(ctx context.Context, spans []models.Span) error {
 	return nil
 }
 
+// IngestUsageSince returns the bytes and items recorded in otel_ingest_usage
+// since a time, for one service or, with an empty serviceName, for every
+// service of serviceNamespace
+func (c *Client) IngestUsageSince(ctx context.Context, since time.Time, serviceName, serviceNamespace string) (bytes, items uint64, err error) {
+	column, value := "service_name", serviceName
+	if serviceName == "" {
+		column, value = "service_namespace", serviceNamespace
+	}
+	err = c.QueryRow(ctx, fmt.Sprintf(`
+		SELECT sum(bytes), sum(items)
+		FROM otel_ingest_usage
+		WHERE %s = ? AND timestamp >= ?`, column),
+		value, since.UTC().Truncate(time.Hour)).Scan(&bytes, &items)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to read ingest usage: %w", err)
+	}
+	return bytes, items, nil
+}
+
 // Ping checks the connection to ClickHouse
 func (c *Client) Ping(ctx context.Context) error {
-	return c.conn.Ping(ctx)
+	return c.current().Ping(ctx)
 }
 
 // Query executes a query and returns rows
 func (c *Client) Query(ctx context.Context, query string, args ...interface{}) (driver.Rows, error) {
-	return c.conn.Query(ctx, query, args...)
+	ctx, span := c.startQuerySpan(ctx, query)
+	rows, err := c.current().Query(ctx, query, args...)
+	if err != nil {
+		endSpan(span, err)
+		return nil, err
+	}
+	return &tracedRows{Rows: rows, span: span}, nil
+}
+
+// Exec executes a statement that returns no rows, such as DDL
+func (c *Client) Exec(ctx context.Context, query string, args ...interface{}) error {
+	ctx, span := c.startQuerySpan(ctx, query)
+	err := c.current().Exec(ctx, query, args...)
+	endSpan(span, err)
+	return err
 }
 
 // QueryRow executes a query that returns a single row
 func (c *Client) QueryRow(ctx context.Context, query string, args ...interface{}) driver.Row {
-	return c.conn.QueryRow(ctx, query, args...)
+	ctx, span := c.startQuerySpan(ctx, query)
+	row := c.current().QueryRow(ctx, query, args...)
+	endSpan(span, row.Err())
+	return row
+}
+
+// WithProgress returns a context that reports ClickHouse progress packets for
+// queries run with it. Each call to fn carries the increments since the
+// previous packet.
+func WithProgress(ctx context.Context, fn func(rows, bytes, totalRows uint64)) context.Context {
+	return clickhouse.Context(ctx, clickhouse.WithProgress(func(p *clickhouse.Progress) {
+		fn(p.Rows, p.Bytes, p.TotalRows)
+	}))
+}
+
+// WithQueryProfile applies a query profile's settings to queries run with the
+// returned context, overriding the client-wide defaults
+func WithQueryProfile(ctx context.Context, p config.QueryProfile) context.Context {
+	settings := profileSettings(p)
+	if len(settings) == 0 {
+		return ctx
+	}
+	return clickhouse.Context(ctx, clickhouse.WithSettings(settings))
+}
+
+func profileSettings(p config.QueryProfile) clickhouse.Settings {
+	settings := clickhouse.Settings{}
+	if p.MaxThreads > 0 {
+		settings["max_threads"] = p.MaxThreads
+	}
+	if p.MaxMemoryUsage > 0 {
+		settings["max_memory_usage"] = p.MaxMemoryUsage
+	}
+	if p.MaxExecutionTime > 0 {
+		settings["max_execution_time"] = int(p.MaxExecutionTime.Seconds())
+	}
+	if p.MaxRowsToRead > 0 {
+		settings["max_rows_to_read"] = p.MaxRowsToRead
+	}
+	if p.Priority > 0 {
+		settings["priority"] = p.Priority
+	}
+	return settings
+}
+
+// ExceededLimit returns the query setting that made ClickHouse abort a
+// query, such as max_rows_to_read, or "" when err is not a limit error
+func ExceededLimit(err error) string {
+	var ex *clickhouse.Exception
+	if !errors.As(err, &ex) {
+		return ""
+	}
+	switch ex.Code {
+	case 158: // TOO_MANY_ROWS
+		return "max_rows_to_read"
+	case 159: // TIMEOUT_EXCEEDED
+		return "max_execution_time"
+	case 241: // MEMORY_LIMIT_EXCEEDED
+		return "max_memory_usage"
+	}
+	return ""
+}
+
+// encodeColdAttributes renders cold span attributes as the JSON stored in
+// attributes_cold, or an empty string when there are none
+func encodeColdAttributes(attrs map[string]string) (string, error) {
+	if len(attrs) == 0 {
+		return "", nil
+	}
+	data, err := json.Marshal(attrs)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
 }
@@ -2,11 +2,15 @@ package clickhouse
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"testing"
 	"time"
 
 	"otelservices/internal/config"
 	"otelservices/internal/models"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
 )
 
 func TestNewClient(t *testing.T) {
@@ -55,6 +59,48 @@ func TestClientClose(t *testing.T) {
 	}
 }
 
+func TestProfileSettings(t *testing.T) {
+	got := profileSettings(config.QueryProfile{
+		MaxThreads:       4,
+		MaxMemoryUsage:   4 << 30,
+		MaxExecutionTime: 30 * time.Minute,
+		MaxRowsToRead:    1e9,
+		Priority:         10,
+	})
+	want := map[string]interface{}{
+		"max_threads":        4,
+		"max_memory_usage":   int64(4 << 30),
+		"max_execution_time": 1800,
+		"max_rows_to_read":   uint64(1e9),
+		"priority":           10,
+	}
+	if len(got) != len(want) {
+		t.Fatalf("profileSettings() = %v, want %v", got, want)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("setting %s = %v, want %v", k, got[k], v)
+		}
+	}
+
+	if got := profileSettings(config.QueryProfile{}); len(got) != 0 {
+		t.Errorf("empty profile should produce no settings, got %v", got)
+	}
+}
+
+func TestExceededLimit(t *testing.T) {
+	err := fmt.Errorf("query failed: %w", &clickhouse.Exception{Code: 158, Message: "Limit for rows exceeded"})
+	if got := ExceededLimit(err); got != "max_rows_to_read" {
+		t.Errorf("ExceededLimit() = %q, want max_rows_to_read", got)
+	}
+	if got := ExceededLimit(&clickhouse.Exception{Code: 60}); got != "" {
+		t.Errorf("unknown table error should not be a limit, got %q", got)
+	}
+	if got := ExceededLimit(errors.New("connection refused")); got != "" {
+		t.Errorf("plain error should not be a limit, got %q", got)
+	}
+}
+
 // Integration tests - require ClickHouse to be running
 // Run with: go test -tags=integration
 
@@ -81,6 +127,21 @@ func createTestClient(t *testing.T) *Client {
 	return client
 }
 
+func TestEncodeColdAttributes(t *testing.T) {
+	got, err := encodeColdAttributes(nil)
+	if err != nil || got != "" {
+		t.Errorf("encodeColdAttributes(nil) = %q, %v; want empty", got, err)
+	}
+
+	got, err = encodeColdAttributes(map[string]string{"b": "2", "a": "1"})
+	if err != nil {
+		t.Fatalf("encodeColdAttributes failed: %v", err)
+	}
+	if want := `{"a":"1","b":"2"}`; got != want {
+		t.Errorf("encodeColdAttributes() = %q, want %q", got, want)
+	}
+}
+
 func TestInsertMetrics(t *testing.T) {
 	client := createTestClient(t)
 	defer client.Close()
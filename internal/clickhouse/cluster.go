@@ -0,0 +1,237 @@
+package clickhouse
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"otelservices/internal/config"
+	"otelservices/internal/monitoring"
+)
+
+// LocalSuffix names the per-node table behind each Distributed table
+const LocalSuffix = "_local"
+
+// ReplicaPath is the ZooKeeper/Keeper path of the replicated tables; the
+// macros are filled in from each node's configuration
+const ReplicaPath = "/clickhouse/tables/{shard}/{database}/{table}"
+
+// shardingKeys keep rows that are aggregated or deduplicated together on one
+// shard. Tables not listed spread their rows randomly.
+var shardingKeys = map[string]string{
+	"otel_traces":             "cityHash64(trace_id)",
+	"otel_trace_index":        "cityHash64(trace_id)",
+	"otel_service_operations": "cityHash64(service_name, span_name, span_kind)",
+	"otel_ingest_usage":       "cityHash64(service_name, service_namespace)",
+}
+
+func shardingKey(table string) string {
+	if key, ok := shardingKeys[table]; ok {
+		return key
+	}
+	return "rand()"
+}
+
+var (
+	createTablePattern = regexp.MustCompile(`(?is)^CREATE TABLE IF NOT EXISTS (\w+)\s*\(`)
+	createViewPattern  = regexp.MustCompile(`(?is)^CREATE MATERIALIZED VIEW IF NOT EXISTS (\w+)\s+TO (\w+)`)
+	enginePattern      = regexp.MustCompile(`(?i)ENGINE = (\w*)MergeTree\(([^)]*)\)`)
+	fromPattern        = regexp.MustCompile(`(?i)\bFROM (\w+)`)
+)
+
+// ClusterDDL rewrites a single-node CREATE statement for a cluster. A table
+// becomes a replicated <name>_local table on every node plus a Distributed
+// table under the original name; a materialized view is created on every
+// node and reads from and writes to the local tables.
+func ClusterDDL(stmt, cluster string) ([]string, error) {
+	stmt = strings.TrimSpace(stmt)
+	onCluster := fmt.Sprintf(" ON CLUSTER '%s'", cluster)
+
+	if m := createViewPattern.FindStringSubmatchIndex(stmt); m != nil {
+		name, target := stmt[m[2]:m[3]], stmt[m[4]:m[5]]
+		header := fmt.Sprintf("CREATE MATERIALIZED VIEW IF NOT EXISTS %s%s TO %s", name, onCluster, target+LocalSuffix)
+		body := fromPattern.ReplaceAllString(stmt[m[1]:], "FROM ${1}"+LocalSuffix)
+		return []string{header + body}, nil
+	}
+
+	m := createTablePattern.FindStringSubmatchIndex(stmt)
+	if m == nil {
+		return nil, fmt.Errorf("unsupported statement for a cluster: %.40q", stmt)
+	}
+	name := stmt[m[2]:m[3]]
+	local := name + LocalSuffix
+	engine := enginePattern.FindStringSubmatch(stmt)
+	if engine == nil {
+		return nil, fmt.Errorf("table %s does not use a MergeTree engine", name)
+	}
+	args := fmt.Sprintf("'%s', '{replica}'", ReplicaPath)
+	if strings.TrimSpace(engine[2]) != "" {
+		args += ", " + engine[2]
+	}
+	localDDL := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s%s (", local, onCluster) + stmt[m[1]:]
+	localDDL = strings.Replace(localDDL, engine[0], fmt.Sprintf("ENGINE = Replicated%sMergeTree(%s)", engine[1], args), 1)
+
+	distributed := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s%s AS %s\nENGINE = Distributed('%s', currentDatabase(), '%s', %s)",
+		name, onCluster, local, cluster, local, shardingKey(name))
+	return []string{localDDL, distributed}, nil
+}
+
+// SplitStatements splits a schema file into its statements, dropping
+// comment lines
+func SplitStatements(sql string) []string {
+	var b strings.Builder
+	for _, line := range strings.Split(sql, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "--") {
+			continue
+		}
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+	var stmts []string
+	for _, stmt := range strings.Split(b.String(), ";") {
+		if stmt = strings.TrimSpace(stmt); stmt != "" {
+			stmts = append(stmts, stmt)
+		}
+	}
+	return stmts
+}
+
+// SchemaDDL returns the statements that create a schema object on the
+// configured deployment: stmt itself on a single node, its cluster rewrite
+// otherwise, with the replacing otel_traces engine when spans are
+// deduplicated
+func (c *Client) SchemaDDL(stmt string) ([]string, error) {
+	return DeploymentDDL(stmt, c.config)
+}
+
+// LocalTable returns the table that holds the rows of table on each node
+func (c *Client) LocalTable(table string) string {
+	if !c.config.Cluster.Enabled() {
+		return table
+	}
+	return table + LocalSuffix
+}
+
+// AlterTable returns the target of an ALTER TABLE statement changing table:
+// its local tables on every node of a cluster
+func (c *Client) AlterTable(table string) string {
+	if !c.config.Cluster.Enabled() {
+		return table
+	}
+	return fmt.Sprintf("%s%s ON CLUSTER '%s'", table, LocalSuffix, c.config.Cluster.Name)
+}
+
+// SystemTable returns a source for a system table covering every replica of
+// a cluster, e.g. system.parts
+func (c *Client) SystemTable(table string) string {
+	if !c.config.Cluster.Enabled() {
+		return table
+	}
+	return fmt.Sprintf("clusterAllReplicas('%s', %s)", c.config.Cluster.Name, table)
+}
+
+// insertTable returns the table a batch for table is inserted into
+func (c *Client) insertTable(table string) string {
+	if c.config.Cluster.InsertMode == config.InsertLocal {
+		return c.LocalTable(table)
+	}
+	return table
+}
+
+// ShardStatus is the health of one shard of the cluster
+type ShardStatus struct {
+	Shard      int `json:"shard"`
+	Replicas   int `json:"replicas"`
+	ReplicasUp int `json:"replicas_up"`
+}
+
+// ShardHealth reports how many replicas of each shard answer a query.
+// Replicas come from system.clusters; each one is probed through
+// clusterAllReplicas, skipping the ones that cannot be reached.
+func (c *Client) ShardHealth(ctx context.Context) ([]ShardStatus, error) {
+	name := c.config.Cluster.Name
+	rows, err := c.Query(ctx, `
+		SELECT shard_num, count()
+		FROM system.clusters
+		WHERE cluster = ?
+		GROUP BY shard_num
+		ORDER BY shard_num`, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list shards of %s: %w", name, err)
+	}
+	defer rows.Close()
+	var shards []ShardStatus
+	for rows.Next() {
+		var shard uint32
+		var replicas uint64
+		if err := rows.Scan(&shard, &replicas); err != nil {
+			return nil, fmt.Errorf("failed to scan shard: %w", err)
+		}
+		shards = append(shards, ShardStatus{Shard: int(shard), Replicas: int(replicas)})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(shards) == 0 {
+		return nil, fmt.Errorf("cluster %s is not defined on the server", name)
+	}
+
+	// The cluster name is validated by the config package
+	probe, err := c.Query(ctx, fmt.Sprintf(`
+		SELECT shardNum(), uniqExact(hostName())
+		FROM clusterAllReplicas('%s', system.one)
+		GROUP BY shardNum()
+		SETTINGS skip_unavailable_shards = 1`, name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to probe shards of %s: %w", name, err)
+	}
+	defer probe.Close()
+	up := make(map[int]int)
+	for probe.Next() {
+		var shard uint32
+		var hosts uint64
+		if err := probe.Scan(&shard, &hosts); err != nil {
+			return nil, fmt.Errorf("failed to scan shard probe: %w", err)
+		}
+		up[int(shard)] = int(hosts)
+	}
+	if err := probe.Err(); err != nil {
+		return nil, err
+	}
+	for i := range shards {
+		shards[i].ReplicasUp = up[shards[i].Shard]
+	}
+	return shards, nil
+}
+
+// checkShards updates the shard gauges every interval until the client is
+// closed
+func (c *Client) checkShards(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		shards, err := c.ShardHealth(ctx)
+		cancel()
+		if err != nil {
+			logger.Warn("Shard health check failed", "cluster", c.config.Cluster.Name, "error", err)
+		}
+		for _, s := range shards {
+			shard := strconv.Itoa(s.Shard)
+			monitoring.ClickHouseShardReplicas.WithLabelValues(shard).Set(float64(s.Replicas))
+			monitoring.ClickHouseShardReplicasUp.WithLabelValues(shard).Set(float64(s.ReplicasUp))
+			if s.ReplicasUp == 0 {
+				logger.Warn("No replica of shard is reachable", "cluster", c.config.Cluster.Name, "shard", s.Shard)
+			}
+		}
+
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package clickhouse
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"otelservices/internal/config"
+)
+
+func TestClusterDDLTable(t *testing.T) {
+	stmts, err := ClusterDDL(`CREATE TABLE IF NOT EXISTS otel_service_operations (
+    timestamp DateTime,
+    service_name LowCardinality(String)
+)
+ENGINE = ReplacingMergeTree(timestamp)
+ORDER BY (service_name, timestamp)`, "otel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stmts) != 2 {
+		t.Fatalf("want local and distributed tables, got %v", stmts)
+	}
+	local, dist := stmts[0], stmts[1]
+	if !strings.HasPrefix(local, "CREATE TABLE IF NOT EXISTS otel_service_operations_local ON CLUSTER 'otel' (\n    timestamp DateTime,") {
+		t.Errorf("unexpected local table:\n%s", local)
+	}
+	if !strings.Contains(local, "ENGINE = ReplicatedReplacingMergeTree('/clickhouse/tables/{shard}/{database}/{table}', '{replica}', timestamp)") {
+		t.Errorf("local table is not replicated:\n%s", local)
+	}
+	want := "CREATE TABLE IF NOT EXISTS otel_service_operations ON CLUSTER 'otel' AS otel_service_operations_local\n" +
+		"ENGINE = Distributed('otel', currentDatabase(), 'otel_service_operations_local', cityHash64(service_name, span_name, span_kind))"
+	if dist != want {
+		t.Errorf("distributed table =\n%s\nwant\n%s", dist, want)
+	}
+}
+
+func TestClusterDDLView(t *testing.T) {
+	stmts, err := ClusterDDL(`CREATE MATERIALIZED VIEW IF NOT EXISTS otel_trace_index_mv
+TO otel_trace_index
+AS SELECT trace_id, count() AS span_count
+FROM otel_traces
+GROUP BY trace_id`, "otel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `CREATE MATERIALIZED VIEW IF NOT EXISTS otel_trace_index_mv ON CLUSTER 'otel' TO otel_trace_index_local
+AS SELECT trace_id, count() AS span_count
+FROM otel_traces_local
+GROUP BY trace_id`
+	if len(stmts) != 1 || stmts[0] != want {
+		t.Errorf("view =\n%v\nwant\n%s", stmts, want)
+	}
+
+	if _, err := ClusterDDL("ALTER TABLE otel_logs MODIFY TTL timestamp", "otel"); err == nil {
+		t.Error("expected error for an ALTER statement")
+	}
+}
+
+// Every statement of the shipped schema must have a cluster form
+func TestClusterDDLSchemaFiles(t *testing.T) {
+	files, _ := filepath.Glob("../../schema/*.sql")
+	if len(files) == 0 {
+		t.Skip("schema files not found")
+	}
+	tables := 0
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, stmt := range SplitStatements(string(data)) {
+			ddl, err := ClusterDDL(stmt, "otel")
+			if err != nil {
+				t.Errorf("%s: %v", filepath.Base(file), err)
+				continue
+			}
+			for _, s := range ddl {
+				if strings.Contains(s, "ENGINE = MergeTree") || strings.Contains(s, "ENGINE = AggregatingMergeTree") {
+					t.Errorf("%s: unreplicated table in\n%s", filepath.Base(file), s)
+				}
+			}
+			if len(ddl) == 2 {
+				tables++
+			}
+		}
+	}
+	if tables == 0 {
+		t.Error("no tables found in the schema files")
+	}
+}
+
+func TestClusterTableNames(t *testing.T) {
+	single := &Client{config: &config.ClickHouseConfig{}}
+	if single.LocalTable("otel_logs") != "otel_logs" || single.AlterTable("otel_logs") != "otel_logs" ||
+		single.SystemTable("system.parts") != "system.parts" || single.insertTable("otel_logs") != "otel_logs" {
+		t.Error("single node tables should be unchanged")
+	}
+
+	c := &Client{config: &config.ClickHouseConfig{Cluster: config.ClusterConfig{Name: "otel", InsertMode: config.InsertDistributed}}}
+	if got := c.AlterTable("otel_logs"); got != "otel_logs_local ON CLUSTER 'otel'" {
+		t.Errorf("AlterTable = %q", got)
+	}
+	if got := c.SystemTable("system.parts"); got != "clusterAllReplicas('otel', system.parts)" {
+		t.Errorf("SystemTable = %q", got)
+	}
+	if got := c.insertTable("otel_logs"); got != "otel_logs" {
+		t.Errorf("distributed insert table = %q", got)
+	}
+	c.config.Cluster.InsertMode = config.InsertLocal
+	if got := c.insertTable("otel_logs"); got != "otel_logs_local" {
+		t.Errorf("local insert table = %q", got)
+	}
+}
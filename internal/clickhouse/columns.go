@@ -0,0 +1,246 @@
+package clickhouse
+
+import (
+	"context"
+	"fmt"
+
+	"otelservices/internal/models"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+)
+
+// appendColumns appends a whole typed slice to each column of batch, in the
+// order of the INSERT's column list. Column appends skip the per-row
+// conversion of batch.Append, which dominates inserts into wide tables.
+func appendColumns(batch driver.Batch, columns ...any) error {
+	for i, col := range columns {
+		if err := batch.Column(i).Append(col); err != nil {
+			return fmt.Errorf("failed to append column %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// InsertMetricColumns inserts a column-oriented batch of metrics into ClickHouse
+func (c *Client) InsertMetricColumns(ctx context.Context, cols *models.MetricColumns) (err error) {
+	n := cols.Len()
+	if n == 0 {
+		return nil
+	}
+	ctx, ins := c.startInsert(ctx, "otel_metrics", "metrics", n)
+	defer func() { ins.end(err) }()
+	ctx = c.withDeduplication(ctx, ins, func() string { return metricToken(cols) })
+
+	batch, err := c.current().PrepareBatch(ctx, fmt.Sprintf(`
+		INSERT INTO %s (
+			timestamp, metric_name, metric_type, value,
+			service_name, service_namespace, service_instance_id, deployment_environment,
+			attributes, resource_attributes,
+			bucket_counts, explicit_bounds,
+			instrumentation_scope_name, instrumentation_scope_version
+		)
+	`, c.insertTable("otel_metrics")))
+	if err != nil {
+		return fmt.Errorf("failed to prepare batch: %w", err)
+	}
+
+	err = appendColumns(batch,
+		cols.Timestamp,
+		cols.MetricName,
+		cols.MetricType,
+		cols.Value,
+		cols.ServiceName,
+		cols.ServiceNamespace,
+		cols.ServiceInstanceID,
+		cols.DeploymentEnvironment,
+		cols.Attributes,
+		cols.ResourceAttributes,
+		cols.BucketCounts,
+		cols.ExplicitBounds,
+		cols.InstrumentationScopeName,
+		cols.InstrumentationScopeVersion,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to append metrics: %w", err)
+	}
+
+	if err := batch.Send(); err != nil {
+		return fmt.Errorf("failed to send batch: %w", err)
+	}
+
+	return nil
+}
+
+// InsertLogColumns inserts a column-oriented batch of logs into ClickHouse
+func (c *Client) InsertLogColumns(ctx context.Context, cols *models.LogColumns) (err error) {
+	n := cols.Len()
+	if n == 0 {
+		return nil
+	}
+	ctx, ins := c.startInsert(ctx, "otel_logs", "logs", n)
+	defer func() { ins.end(err) }()
+	ctx = c.withDeduplication(ctx, ins, func() string { return logToken(cols) })
+
+	batch, err := c.current().PrepareBatch(ctx, fmt.Sprintf(`
+		INSERT INTO %s (
+			timestamp, observed_timestamp, severity_number, severity_text,
+			body, body_type,
+			service_name, service_namespace, service_instance_id, deployment_environment, host_name,
+			trace_id, span_id, trace_flags,
+			attributes, attributes_int, attributes_double, attributes_bool, resource_attributes,
+			dropped_attributes_count,
+			instrumentation_scope_name, instrumentation_scope_version, scope_attributes
+		)
+	`, c.insertTable("otel_logs")))
+	if err != nil {
+		return fmt.Errorf("failed to prepare batch: %w", err)
+	}
+
+	err = appendColumns(batch,
+		cols.Timestamp,
+		cols.ObservedTimestamp,
+		cols.SeverityNumber,
+		cols.SeverityText,
+		cols.Body,
+		cols.BodyType,
+		cols.ServiceName,
+		cols.ServiceNamespace,
+		cols.ServiceInstanceID,
+		cols.DeploymentEnvironment,
+		cols.HostName,
+		cols.TraceID,
+		cols.SpanID,
+		cols.TraceFlags,
+		cols.Attributes,
+		cols.IntAttributes,
+		cols.DoubleAttributes,
+		cols.BoolAttributes,
+		cols.ResourceAttributes,
+		cols.DroppedAttributesCount,
+		cols.InstrumentationScopeName,
+		cols.InstrumentationScopeVersion,
+		cols.ScopeAttributes,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to append logs: %w", err)
+	}
+
+	if err := batch.Send(); err != nil {
+		return fmt.Errorf("failed to send batch: %w", err)
+	}
+
+	return nil
+}
+
+// InsertSpanColumns inserts a column-oriented batch of spans into ClickHouse
+func (c *Client) InsertSpanColumns(ctx context.Context, cols *models.SpanColumns) (err error) {
+	n := cols.Len()
+	if n == 0 {
+		return nil
+	}
+	ctx, ins := c.startInsert(ctx, "otel_traces", "traces", n)
+	defer func() { ins.end(err) }()
+	ctx = c.withDeduplication(ctx, ins, func() string { return spanToken(cols) })
+
+	coldAttributes, err := coldAttributesColumn(cols.ColdAttributes)
+	if err != nil {
+		return err
+	}
+
+	batch, err := c.current().PrepareBatch(ctx, fmt.Sprintf(`
+		INSERT INTO %s (
+			timestamp, trace_id, span_id, parent_span_id, trace_state,
+			span_name, span_kind, start_time, end_time, duration_ns,
+			status_code, status_message,
+			service_name, service_namespace, service_instance_id, deployment_environment,
+			attributes, attributes_cold, attributes_int, attributes_double, attributes_bool, resource_attributes,
+			events, links,
+			dropped_attributes_count, dropped_events_count, dropped_links_count,
+			instrumentation_scope_name, instrumentation_scope_version, scope_attributes
+		)
+	`, c.insertTable("otel_traces")))
+	if err != nil {
+		return fmt.Errorf("failed to prepare batch: %w", err)
+	}
+
+	err = appendColumns(batch,
+		cols.Timestamp,
+		cols.TraceID,
+		cols.SpanID,
+		cols.ParentSpanID,
+		cols.TraceState,
+		cols.SpanName,
+		cols.SpanKind,
+		cols.StartTime,
+		cols.EndTime,
+		cols.DurationNs,
+		cols.StatusCode,
+		cols.StatusMessage,
+		cols.ServiceName,
+		cols.ServiceNamespace,
+		cols.ServiceInstanceID,
+		cols.DeploymentEnvironment,
+		cols.Attributes,
+		coldAttributes,
+		cols.IntAttributes,
+		cols.DoubleAttributes,
+		cols.BoolAttributes,
+		cols.ResourceAttributes,
+		eventsColumn(cols.Events),
+		linksColumn(cols.Links),
+		cols.DroppedAttributesCount,
+		cols.DroppedEventsCount,
+		cols.DroppedLinksCount,
+		cols.InstrumentationScopeName,
+		cols.InstrumentationScopeVersion,
+		cols.ScopeAttributes,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to append spans: %w", err)
+	}
+
+	if err := batch.Send(); err != nil {
+		return fmt.Errorf("failed to send batch: %w", err)
+	}
+
+	return nil
+}
+
+// coldAttributesColumn encodes the attributes_cold column of a span batch
+func coldAttributesColumn(attrs []map[string]string) ([]string, error) {
+	col := make([]string, len(attrs))
+	for i, a := range attrs {
+		encoded, err := encodeColdAttributes(a)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode cold attributes: %w", err)
+		}
+		col[i] = encoded
+	}
+	return col, nil
+}
+
+// eventsColumn converts the events of a span batch to the tuples of the
+// events column
+func eventsColumn(events [][]models.SpanEvent) [][][]any {
+	col := make([][][]any, len(events))
+	for i, row := range events {
+		col[i] = make([][]any, len(row))
+		for j, e := range row {
+			col[i][j] = []any{e.Timestamp, e.Name, e.Attributes}
+		}
+	}
+	return col
+}
+
+// linksColumn converts the links of a span batch to the tuples of the links
+// column
+func linksColumn(links [][]models.SpanLink) [][][]any {
+	col := make([][][]any, len(links))
+	for i, row := range links {
+		col[i] = make([][]any, len(row))
+		for j, l := range row {
+			col[i][j] = []any{l.TraceID, l.SpanID, l.TraceState, l.Attributes}
+		}
+	}
+	return col
+}
@@ -0,0 +1,37 @@
+package clickhouse
+
+import (
+	"testing"
+	"time"
+
+	"otelservices/internal/models"
+)
+
+func TestEventsAndLinksColumns(t *testing.T) {
+	now := time.Now()
+	events := eventsColumn([][]models.SpanEvent{
+		{{Timestamp: now, Name: "retry", Attributes: map[string]string{"attempt": "2"}}},
+		nil,
+	})
+	if len(events) != 2 || len(events[0]) != 1 || len(events[1]) != 0 {
+		t.Fatalf("Expected one event on the first span only, got %v", events)
+	}
+	if events[0][0][1] != "retry" {
+		t.Errorf("Expected the event name second in the tuple, got %v", events[0][0])
+	}
+
+	links := linksColumn([][]models.SpanLink{{{TraceID: "abc", SpanID: "def", TraceState: "k=v"}}})
+	if len(links) != 1 || len(links[0][0]) != 4 || links[0][0][2] != "k=v" {
+		t.Errorf("Expected a four field link tuple, got %v", links)
+	}
+}
+
+func TestColdAttributesColumn(t *testing.T) {
+	col, err := coldAttributesColumn([]map[string]string{nil, {"a": "1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if col[0] != "" || col[1] != `{"a":"1"}` {
+		t.Errorf("Expected empty and JSON encoded cold attributes, got %q", col)
+	}
+}
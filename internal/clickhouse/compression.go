@@ -0,0 +1,130 @@
+package clickhouse
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"otelservices/internal/config"
+	"otelservices/internal/monitoring"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+)
+
+// queryLogFlushLag leaves the server time to flush system.query_log, every
+// 7.5s by default, before a window of it is read
+const queryLogFlushLag = 15 * time.Second
+
+// insertedTables are the tables the collector inserts batches into
+var insertedTables = []string{"otel_traces", "otel_metrics", "otel_logs", "otel_service_operations"}
+
+// defaultHTTPCompressionLevel is used for gzip, deflate and brotli when no
+// level is configured, since level 0 disables gzip and deflate compression
+const defaultHTTPCompressionLevel = 3
+
+// compression returns the driver compression for cfg, nil when disabled
+func compression(cfg *config.ClickHouseConfig) *clickhouse.Compression {
+	c := &clickhouse.Compression{Method: clickhouse.CompressionZSTD, Level: cfg.CompressionLevel}
+	switch cfg.Compression {
+	case config.CompressionNone:
+		return nil
+	case config.CompressionLZ4:
+		c.Method = clickhouse.CompressionLZ4
+	case config.CompressionGzip, config.CompressionDeflate, config.CompressionBrotli:
+		c.Method = httpCompressionMethods[cfg.Compression]
+		if c.Level == 0 {
+			c.Level = defaultHTTPCompressionLevel
+		}
+	}
+	return c
+}
+
+var httpCompressionMethods = map[string]clickhouse.CompressionMethod{
+	config.CompressionGzip:    clickhouse.CompressionGZIP,
+	config.CompressionDeflate: clickhouse.CompressionDeflate,
+	config.CompressionBrotli:  clickhouse.CompressionBrotli,
+}
+
+// TableCompression is the traffic of the inserts into one table
+type TableCompression struct {
+	Table             string
+	WireBytes         uint64 // received by the server over the network
+	UncompressedBytes uint64 // written to the table
+}
+
+// Ratio returns uncompressed to wire bytes, 0 without traffic
+func (t TableCompression) Ratio() float64 {
+	if t.WireBytes == 0 {
+		return 0
+	}
+	return float64(t.UncompressedBytes) / float64(t.WireBytes)
+}
+
+// InsertCompression reads the traffic of this user's inserts that finished
+// in [from, to) from system.query_log
+func (c *Client) InsertCompression(ctx context.Context, from, to time.Time) ([]TableCompression, error) {
+	tables := make([]string, len(insertedTables))
+	for i, table := range insertedTables {
+		tables[i] = c.insertTable(table)
+	}
+	rows, err := c.Query(ctx, fmt.Sprintf(`
+		SELECT
+			extract(query, '(?i)INSERT INTO\\s+(\\w+)') AS target,
+			sum(ProfileEvents['NetworkReceiveBytes']),
+			sum(written_bytes)
+		FROM %s
+		WHERE type = 'QueryFinish' AND query_kind = 'Insert' AND is_initial_query
+		  AND user = currentUser() AND current_database = currentDatabase()
+		  AND event_time_microseconds >= fromUnixTimestamp64Micro(?)
+		  AND event_time_microseconds < fromUnixTimestamp64Micro(?)
+		  AND target IN ?
+		GROUP BY target`, c.SystemTable("system.query_log")),
+		from.UnixMicro(), to.UnixMicro(), tables)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read insert traffic: %w", err)
+	}
+	defer rows.Close()
+	var stats []TableCompression
+	for rows.Next() {
+		var s TableCompression
+		if err := rows.Scan(&s.Table, &s.WireBytes, &s.UncompressedBytes); err != nil {
+			return nil, fmt.Errorf("failed to scan insert traffic: %w", err)
+		}
+		s.Table = strings.TrimSuffix(s.Table, LocalSuffix)
+		stats = append(stats, s)
+	}
+	return stats, rows.Err()
+}
+
+// reportCompression updates the compression metrics every interval until
+// the client is closed
+func (c *Client) reportCompression(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	from := time.Now().Add(-queryLogFlushLag)
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+		}
+
+		to := time.Now().Add(-queryLogFlushLag)
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		stats, err := c.InsertCompression(ctx, from, to)
+		cancel()
+		if err != nil {
+			logger.Warn("Reading insert compression failed", "error", err)
+			continue
+		}
+		from = to
+		for _, s := range stats {
+			monitoring.ClickHouseInsertWireBytes.WithLabelValues(s.Table).Add(float64(s.WireBytes))
+			monitoring.ClickHouseInsertUncompressedBytes.WithLabelValues(s.Table).Add(float64(s.UncompressedBytes))
+			if ratio := s.Ratio(); ratio > 0 {
+				monitoring.ClickHouseInsertCompressionRatio.WithLabelValues(s.Table).Set(ratio)
+			}
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package clickhouse
+
+import (
+	"testing"
+
+	"otelservices/internal/config"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+)
+
+func TestCompression(t *testing.T) {
+	tests := []struct {
+		method string
+		want   clickhouse.CompressionMethod
+	}{
+		{"", clickhouse.CompressionZSTD},
+		{"zstd", clickhouse.CompressionZSTD},
+		{"lz4", clickhouse.CompressionLZ4},
+	}
+	for _, tt := range tests {
+		got := compression(&config.ClickHouseConfig{Compression: tt.method, CompressionLevel: 3})
+		if got == nil || got.Method != tt.want || got.Level != 3 {
+			t.Errorf("compression(%q) = %+v, want method %v level 3", tt.method, got, tt.want)
+		}
+	}
+	if got := compression(&config.ClickHouseConfig{Compression: "gzip"}); got == nil || got.Method != clickhouse.CompressionGZIP || got.Level != defaultHTTPCompressionLevel {
+		t.Errorf("compression(gzip) = %+v, want the default http level", got)
+	}
+	if got := compression(&config.ClickHouseConfig{Compression: "none"}); got != nil {
+		t.Errorf("compression(none) = %+v, want nil", got)
+	}
+}
+
+func TestTableCompressionRatio(t *testing.T) {
+	if r := (TableCompression{WireBytes: 100, UncompressedBytes: 800}).Ratio(); r != 8 {
+		t.Errorf("Ratio() = %v, want 8", r)
+	}
+	if r := (TableCompression{UncompressedBytes: 800}).Ratio(); r != 0 {
+		t.Errorf("Ratio() without wire bytes = %v, want 0", r)
+	}
+}
@@ -0,0 +1,139 @@
+package clickhouse
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"math"
+	"slices"
+	"time"
+
+	"otelservices/internal/models"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+)
+
+// duplicatedBlocksEvent is the profile event ClickHouse sends for blocks it
+// dropped because their deduplication token was already seen
+const duplicatedBlocksEvent = "DuplicatedInsertedBlocks"
+
+// withDeduplication sends the insert with a deduplication token when
+// insert deduplication is enabled, counting the blocks ClickHouse drops as
+// duplicates on ins. token is only called when it is needed.
+func (c *Client) withDeduplication(ctx context.Context, ins *insert, token func() string) context.Context {
+	if !c.config.InsertDeduplication.Enabled {
+		return ctx
+	}
+	return clickhouse.Context(ctx,
+		clickhouse.WithSettings(clickhouse.Settings{"insert_deduplication_token": token()}),
+		clickhouse.WithProfileEvents(func(events []clickhouse.ProfileEvent) {
+			for _, e := range events {
+				if e.Name == duplicatedBlocksEvent {
+					ins.duplicates += e.Value
+				}
+			}
+		}))
+}
+
+// EnableDeduplication makes the inserted tables of a single node remember
+// the tokens of their last window blocks. Replicated tables of a cluster
+// deduplicate by default.
+func (c *Client) EnableDeduplication(ctx context.Context) error {
+	if c.config.Cluster.Enabled() {
+		return nil
+	}
+	for _, table := range insertedTables {
+		stmt := fmt.Sprintf("ALTER TABLE %s MODIFY SETTING non_replicated_deduplication_window = %d",
+			table, c.config.InsertDeduplication.Window)
+		if err := c.Exec(ctx, stmt); err != nil {
+			return fmt.Errorf("failed to enable deduplication on %s: %w", table, err)
+		}
+	}
+	return nil
+}
+
+// tokenHash builds a deduplication token from the identifying fields of a
+// batch's rows
+type tokenHash struct {
+	h   hash.Hash
+	buf [8]byte
+}
+
+func newTokenHash() *tokenHash {
+	return &tokenHash{h: fnv.New128a()}
+}
+
+func (t *tokenHash) string(s string) {
+	t.uint64(uint64(len(s)))
+	t.h.Write([]byte(s))
+}
+
+func (t *tokenHash) uint64(v uint64) {
+	binary.LittleEndian.PutUint64(t.buf[:], v)
+	t.h.Write(t.buf[:])
+}
+
+func (t *tokenHash) time(v time.Time) {
+	t.uint64(uint64(v.UnixNano()))
+}
+
+// attributes hashes m in key order, so equal maps hash the same
+func (t *tokenHash) attributes(m map[string]string) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	t.uint64(uint64(len(keys)))
+	for _, k := range keys {
+		t.string(k)
+		t.string(m[k])
+	}
+}
+
+func (t *tokenHash) sum() string {
+	return hex.EncodeToString(t.h.Sum(nil))
+}
+
+// spanToken identifies a span batch by its spans' IDs and start times
+func spanToken(cols *models.SpanColumns) string {
+	t := newTokenHash()
+	for i := range cols.TraceID {
+		t.string(cols.TraceID[i])
+		t.string(cols.SpanID[i])
+		t.time(cols.StartTime[i])
+	}
+	return t.sum()
+}
+
+// metricToken identifies a metric batch by its series, timestamps and values
+func metricToken(cols *models.MetricColumns) string {
+	t := newTokenHash()
+	for i := range cols.Timestamp {
+		t.time(cols.Timestamp[i])
+		t.string(cols.MetricName[i])
+		t.string(cols.ServiceName[i])
+		t.string(cols.ServiceInstanceID[i])
+		t.attributes(cols.Attributes[i])
+		t.uint64(math.Float64bits(cols.Value[i]))
+	}
+	return t.sum()
+}
+
+// logToken identifies a log batch by its records' sources, timestamps and bodies
+func logToken(cols *models.LogColumns) string {
+	t := newTokenHash()
+	for i := range cols.Timestamp {
+		t.time(cols.Timestamp[i])
+		t.time(cols.ObservedTimestamp[i])
+		t.string(cols.ServiceName[i])
+		t.string(cols.ServiceInstanceID[i])
+		t.string(cols.TraceID[i])
+		t.string(cols.SpanID[i])
+		t.string(cols.Body[i])
+	}
+	return t.sum()
+}
@@ -0,0 +1,60 @@
+package clickhouse
+
+import (
+	"testing"
+	"time"
+
+	"otelservices/internal/models"
+)
+
+func TestSpanTokenDeterministic(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	batch := func(spanIDs ...string) *models.SpanColumns {
+		cols := &models.SpanColumns{}
+		for _, id := range spanIDs {
+			cols.Append(&models.Span{TraceID: "abc", SpanID: id, StartTime: now})
+		}
+		return cols
+	}
+	if spanToken(batch("1", "2")) != spanToken(batch("1", "2")) {
+		t.Error("Expected equal batches to get the same token")
+	}
+	if spanToken(batch("1", "2")) == spanToken(batch("1", "3")) {
+		t.Error("Expected different batches to get different tokens")
+	}
+	if spanToken(batch("12")) == spanToken(batch("1", "2")) {
+		t.Error("Expected field boundaries to be part of the token")
+	}
+}
+
+func TestMetricTokenIgnoresAttributeOrder(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	batch := func(value float64) *models.MetricColumns {
+		cols := &models.MetricColumns{}
+		attrs := map[string]string{}
+		for _, k := range []string{"a", "b", "c", "d", "e", "f"} {
+			attrs[k] = k
+		}
+		cols.Append(&models.Metric{Timestamp: now, MetricName: "requests", Value: value, Attributes: attrs})
+		return cols
+	}
+	for i := 0; i < 10; i++ {
+		if metricToken(batch(1)) != metricToken(batch(1)) {
+			t.Fatal("Expected the token not to depend on map iteration order")
+		}
+	}
+	if metricToken(batch(1)) == metricToken(batch(2)) {
+		t.Error("Expected different values to get different tokens")
+	}
+}
+
+func TestLogToken(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	a := &models.LogColumns{}
+	a.Append(&models.LogRecord{Timestamp: now, Body: "first"})
+	b := &models.LogColumns{}
+	b.Append(&models.LogRecord{Timestamp: now, Body: "second"})
+	if logToken(a) == logToken(b) {
+		t.Error("Expected different bodies to get different tokens")
+	}
+}
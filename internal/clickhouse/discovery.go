@@ -0,0 +1,154 @@
+package clickhouse
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"otelservices/internal/config"
+	"otelservices/internal/logging"
+	"otelservices/internal/monitoring"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+)
+
+// retiredConnGrace is how long a replaced connection stays open so queries
+// and inserts already running on it can finish
+const retiredConnGrace = 2 * time.Minute
+
+// logger is the component logger of the ClickHouse client
+var logger = logging.For("clickhouse")
+
+// addressResolver is the part of net.Resolver used for discovery
+type addressResolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+// usesDiscovery reports whether any address is resolved through DNS
+func usesDiscovery(addrs []string) bool {
+	for _, addr := range addrs {
+		if a, err := config.ParseClickHouseAddress(addr); err == nil && a.Discovery != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveAddresses expands discovery entries into host:port pairs. Static
+// entries are kept as they are. The result is sorted and deduplicated so
+// successive resolutions can be compared.
+func resolveAddresses(ctx context.Context, r addressResolver, addrs []string) ([]string, error) {
+	seen := make(map[string]bool)
+	var resolved []string
+	add := func(host, port string) {
+		addr := net.JoinHostPort(host, port)
+		if !seen[addr] {
+			seen[addr] = true
+			resolved = append(resolved, addr)
+		}
+	}
+
+	for _, entry := range addrs {
+		a, err := config.ParseClickHouseAddress(entry)
+		if err != nil {
+			return nil, err
+		}
+		switch a.Discovery {
+		case "":
+			add(a.Host, a.Port)
+		case "dns":
+			hosts, err := r.LookupHost(ctx, a.Host)
+			if err != nil {
+				return nil, fmt.Errorf("failed to resolve %s: %w", a.Host, err)
+			}
+			for _, host := range hosts {
+				add(host, a.Port)
+			}
+		case "dnssrv":
+			_, records, err := r.LookupSRV(ctx, "", "", a.Host)
+			if err != nil {
+				return nil, fmt.Errorf("failed to look up SRV records for %s: %w", a.Host, err)
+			}
+			for _, srv := range records {
+				add(strings.TrimSuffix(srv.Target, "."), strconv.Itoa(int(srv.Port)))
+			}
+		}
+	}
+	if len(resolved) == 0 {
+		return nil, fmt.Errorf("no ClickHouse addresses found")
+	}
+	sort.Strings(resolved)
+	return resolved, nil
+}
+
+// discover re-resolves the configured addresses every interval until the
+// client is closed
+func (c *Client) discover(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			if err := c.refresh(); err != nil {
+				monitoring.ClickHouseDiscoveryErrors.Inc()
+				logger.Error("Address discovery failed, keeping the current addresses", "addresses", c.addresses(), "error", err)
+			}
+		}
+	}
+}
+
+// refresh resolves the addresses again and, when the set changed, connects
+// to the new set and swaps it in. The previous connection is closed after
+// retiredConnGrace. On error the current connection is kept.
+func (c *Client) refresh() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	addrs, err := resolveAddresses(ctx, c.resolver, c.config.Addresses)
+	if err != nil {
+		return err
+	}
+	if slices.Equal(addrs, c.addresses()) {
+		return nil
+	}
+
+	conn, err := c.dial(addrs)
+	if err != nil {
+		return err
+	}
+	oldAddrs := c.swap(conn, addrs)
+	logger.Info("Addresses changed", "from", oldAddrs, "to", addrs)
+	return nil
+}
+
+// swap makes conn, opened to addrs, the current connection and returns the
+// previous addresses. The previous connection is closed after
+// retiredConnGrace.
+func (c *Client) swap(conn driver.Conn, addrs []string) []string {
+	c.mu.Lock()
+	old, oldAddrs := c.conn, c.addrs
+	c.conn, c.addrs = conn, addrs
+	c.mu.Unlock()
+
+	monitoring.ClickHouseAddresses.Set(float64(len(addrs)))
+	time.AfterFunc(retiredConnGrace, func() {
+		if err := old.Close(); err != nil {
+			logger.Error("Failed to close retired connection", "error", err)
+		}
+	})
+	return oldAddrs
+}
+
+// addresses returns the resolved addresses of the current connection
+func (c *Client) addresses() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.addrs
+}
@@ -0,0 +1,116 @@
+package clickhouse
+
+import (
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"testing"
+
+	"otelservices/internal/config"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+)
+
+type fakeResolver struct {
+	hosts map[string][]string
+	srv   map[string][]*net.SRV
+}
+
+func (r *fakeResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	if addrs, ok := r.hosts[host]; ok {
+		return addrs, nil
+	}
+	return nil, errors.New("no such host")
+}
+
+func (r *fakeResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	if records, ok := r.srv[name]; ok {
+		return name, records, nil
+	}
+	return "", nil, errors.New("no such host")
+}
+
+// fakeConn records Close; other driver.Conn methods are not used
+type fakeConn struct {
+	driver.Conn
+	closed bool
+}
+
+func (c *fakeConn) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestResolveAddresses(t *testing.T) {
+	r := &fakeResolver{
+		hosts: map[string][]string{"clickhouse-headless": {"10.0.0.2", "10.0.0.1"}},
+		srv: map[string][]*net.SRV{"_native._tcp.clickhouse": {
+			{Target: "ch-1.clickhouse.", Port: 9000},
+			{Target: "ch-0.clickhouse.", Port: 9000},
+		}},
+	}
+	got, err := resolveAddresses(context.Background(), r, []string{
+		"dns+clickhouse-headless:9000",
+		"dnssrv+_native._tcp.clickhouse",
+		"10.0.0.1:9000",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{"10.0.0.1:9000", "10.0.0.2:9000", "ch-0.clickhouse:9000", "ch-1.clickhouse:9000"}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if _, err := resolveAddresses(context.Background(), r, []string{"dns+missing:9000"}); err == nil {
+		t.Error("expected error for unresolvable host")
+	}
+	r.srv["_native._tcp.empty"] = nil
+	if _, err := resolveAddresses(context.Background(), r, []string{"dnssrv+_native._tcp.empty"}); err == nil {
+		t.Error("expected error when discovery finds no addresses")
+	}
+}
+
+func TestUsesDiscovery(t *testing.T) {
+	if usesDiscovery([]string{"localhost:9000"}) {
+		t.Error("static addresses should not use discovery")
+	}
+	if !usesDiscovery([]string{"localhost:9000", "dns+clickhouse:9000"}) {
+		t.Error("dns+ address should use discovery")
+	}
+}
+
+func TestClientRefresh(t *testing.T) {
+	r := &fakeResolver{hosts: map[string][]string{"clickhouse": {"10.0.0.1"}}}
+	var dialed [][]string
+	first := &fakeConn{}
+	c := &Client{
+		conn:     first,
+		addrs:    []string{"10.0.0.1:9000"},
+		config:   &config.ClickHouseConfig{Addresses: []string{"dns+clickhouse:9000"}},
+		resolver: r,
+		dial: func(addrs []string) (driver.Conn, error) {
+			dialed = append(dialed, addrs)
+			return &fakeConn{}, nil
+		},
+	}
+
+	if err := c.refresh(); err != nil || len(dialed) != 0 {
+		t.Fatalf("unchanged addresses should not reconnect: err=%v dialed=%v", err, dialed)
+	}
+
+	r.hosts["clickhouse"] = []string{"10.0.0.1", "10.0.0.3"}
+	if err := c.refresh(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c.current() == first || !slices.Equal(c.addresses(), []string{"10.0.0.1:9000", "10.0.0.3:9000"}) {
+		t.Errorf("connection not swapped, addresses %v", c.addresses())
+	}
+
+	delete(r.hosts, "clickhouse")
+	current := c.current()
+	if err := c.refresh(); err == nil || c.current() != current {
+		t.Error("failed resolution should keep the current connection")
+	}
+}
@@ -0,0 +1,49 @@
+package clickhouse
+
+import (
+	"context"
+	"time"
+
+	"otelservices/internal/monitoring"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// insert tracks one batch insert for tracing and the storage metrics
+type insert struct {
+	span   trace.Span
+	table  string
+	signal string
+	rows   int
+	start  time.Time
+	// duplicates counts the blocks ClickHouse dropped as already inserted
+	duplicates int64
+}
+
+// startInsert starts the span of a batch insert of rows into table; signal
+// is the signal_type label of the batch metrics
+func (c *Client) startInsert(ctx context.Context, table, signal string, rows int) (context.Context, *insert) {
+	ctx, span := c.startInsertSpan(ctx, table, rows)
+	return ctx, &insert{span: span, table: table, signal: signal, rows: rows, start: time.Now()}
+}
+
+// end records the outcome of the insert
+func (i *insert) end(err error) {
+	endSpan(i.span, err)
+	recordInsert(i.table, i.signal, i.rows, time.Since(i.start), err)
+	if err == nil && i.duplicates > 0 {
+		monitoring.StorageDeduplicatedBlocks.WithLabelValues(i.table).Add(float64(i.duplicates))
+	}
+}
+
+func recordInsert(table, signal string, rows int, duration time.Duration, err error) {
+	monitoring.StorageWriteDuration.WithLabelValues(table).Observe(duration.Seconds())
+	monitoring.BatchSize.WithLabelValues(signal).Observe(float64(rows))
+	if err != nil {
+		monitoring.StorageWrites.WithLabelValues(table, "error").Inc()
+		return
+	}
+	monitoring.StorageWrites.WithLabelValues(table, "success").Inc()
+	monitoring.StorageRowsWritten.WithLabelValues(table).Add(float64(rows))
+	monitoring.LastSuccessfulInsert.WithLabelValues(signal).SetToCurrentTime()
+}
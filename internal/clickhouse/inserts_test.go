@@ -0,0 +1,34 @@
+package clickhouse
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"otelservices/internal/monitoring"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRecordInsert(t *testing.T) {
+	table := "otel_test_inserts"
+	success := monitoring.StorageWrites.WithLabelValues(table, "success")
+	failure := monitoring.StorageWrites.WithLabelValues(table, "error")
+	rows := monitoring.StorageRowsWritten.WithLabelValues(table)
+	last := monitoring.LastSuccessfulInsert.WithLabelValues("test")
+
+	recordInsert(table, "test", 100, 20*time.Millisecond, nil)
+	if testutil.ToFloat64(success) != 1 || testutil.ToFloat64(rows) != 100 {
+		t.Errorf("success = %v, rows = %v", testutil.ToFloat64(success), testutil.ToFloat64(rows))
+	}
+	stamp := testutil.ToFloat64(last)
+	if now := float64(time.Now().Unix()); stamp < now-5 || stamp > now+5 {
+		t.Errorf("last successful insert = %v, want about %v", stamp, now)
+	}
+
+	last.Set(0)
+	recordInsert(table, "test", 50, time.Millisecond, errors.New("connection reset"))
+	if testutil.ToFloat64(failure) != 1 || testutil.ToFloat64(rows) != 100 || testutil.ToFloat64(last) != 0 {
+		t.Errorf("failed insert counted as written: error = %v, rows = %v", testutil.ToFloat64(failure), testutil.ToFloat64(rows))
+	}
+}
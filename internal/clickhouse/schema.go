@@ -0,0 +1,75 @@
+package clickhouse
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"otelservices/internal/config"
+)
+
+// SchemaFile is one file of a schema directory split into its statements
+type SchemaFile struct {
+	Name       string
+	Statements []string
+}
+
+// ReadSchema reads the *.sql files in dir in name order
+func ReadSchema(dir string) ([]SchemaFile, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.sql"))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no schema files in %s", dir)
+	}
+	sort.Strings(paths)
+	files := make([]SchemaFile, 0, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read schema: %w", err)
+		}
+		files = append(files, SchemaFile{Name: filepath.Base(path), Statements: SplitStatements(string(data))})
+	}
+	return files, nil
+}
+
+// DeploymentDDL rewrites a schema statement for the deployment in cfg: the
+// replacing otel_traces engine when spans are deduplicated, and its cluster
+// form when a cluster is configured
+func DeploymentDDL(stmt string, cfg *config.ClickHouseConfig) ([]string, error) {
+	if cfg.DeduplicateSpans {
+		stmt = ReplacingSpansDDL(stmt)
+	}
+	if !cfg.Cluster.Enabled() {
+		return []string{stmt}, nil
+	}
+	return ClusterDDL(stmt, cfg.Cluster.Name)
+}
+
+// Migrate runs the statements of the schema files in order on the
+// configured deployment. Every statement creates its object IF NOT EXISTS,
+// so migrating a database adds what is missing and leaves existing tables
+// alone. done, when set, is called after each statement.
+func (c *Client) Migrate(ctx context.Context, files []SchemaFile, done func(file, stmt string)) error {
+	for _, file := range files {
+		for _, stmt := range file.Statements {
+			ddl, err := c.SchemaDDL(stmt)
+			if err != nil {
+				return fmt.Errorf("%s: %w", file.Name, err)
+			}
+			for _, s := range ddl {
+				if err := c.Exec(ctx, s); err != nil {
+					return fmt.Errorf("%s: %w", file.Name, err)
+				}
+				if done != nil {
+					done(file.Name, s)
+				}
+			}
+		}
+	}
+	return nil
+}
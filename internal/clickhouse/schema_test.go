@@ -0,0 +1,40 @@
+package clickhouse
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"otelservices/internal/config"
+)
+
+func TestReadSchema(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := ReadSchema(dir); err == nil {
+		t.Error("expected an error for a directory without schema files")
+	}
+	os.WriteFile(filepath.Join(dir, "002_b.sql"), []byte("CREATE TABLE IF NOT EXISTS b (x UInt8) ENGINE = MergeTree ORDER BY x;\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, "001_a.sql"), []byte("CREATE TABLE IF NOT EXISTS a (x UInt8) ENGINE = MergeTree ORDER BY x;\nCREATE TABLE IF NOT EXISTS c (x UInt8) ENGINE = MergeTree ORDER BY x;\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, "README.md"), []byte("not schema"), 0o644)
+
+	files, err := ReadSchema(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[0].Name != "001_a.sql" || len(files[0].Statements) != 2 || files[1].Name != "002_b.sql" {
+		t.Errorf("files %+v", files)
+	}
+}
+
+func TestDeploymentDDL(t *testing.T) {
+	stmt := "CREATE TABLE IF NOT EXISTS a (\n    x UInt8\n)\nENGINE = MergeTree()\nORDER BY x"
+	if ddl, err := DeploymentDDL(stmt, &config.ClickHouseConfig{}); err != nil || len(ddl) != 1 || ddl[0] != stmt {
+		t.Errorf("single node: %v, %v", ddl, err)
+	}
+	cfg := &config.ClickHouseConfig{Cluster: config.ClusterConfig{Name: "otel"}}
+	ddl, err := DeploymentDDL(stmt, cfg)
+	if err != nil || len(ddl) != 2 || !strings.Contains(ddl[0], "ON CLUSTER 'otel'") {
+		t.Errorf("cluster: %v, %v", ddl, err)
+	}
+}
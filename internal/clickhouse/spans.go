@@ -0,0 +1,30 @@
+package clickhouse
+
+import (
+	"regexp"
+)
+
+// spansTablePattern matches the engine of the otel_traces CREATE statement
+var spansTablePattern = regexp.MustCompile(`(?is)^(CREATE TABLE IF NOT EXISTS otel_traces\s*\(.*ENGINE = )MergeTree\(\)`)
+
+// ReplacingSpansDDL rewrites the CREATE statement of otel_traces to use a
+// ReplacingMergeTree, which keeps one row per (trace_id, span_id, timestamp)
+// sorting key when parts merge. Other statements are returned unchanged.
+func ReplacingSpansDDL(stmt string) string {
+	return spansTablePattern.ReplaceAllString(stmt, "${1}ReplacingMergeTree()")
+}
+
+// DeduplicatesSpans reports whether otel_traces keeps one row per span
+func (c *Client) DeduplicatesSpans() bool {
+	return c.config.DeduplicateSpans
+}
+
+// SpansTable returns the source queries read spans from. With span
+// deduplication it reads otel_traces with FINAL, so spans stored twice and
+// not merged yet are returned once.
+func (c *Client) SpansTable() string {
+	if c.DeduplicatesSpans() {
+		return "otel_traces FINAL"
+	}
+	return "otel_traces"
+}
@@ -0,0 +1,40 @@
+package clickhouse
+
+import (
+	"strings"
+	"testing"
+
+	"otelservices/internal/config"
+)
+
+func TestReplacingSpansDDL(t *testing.T) {
+	traces := "CREATE TABLE IF NOT EXISTS otel_traces (\n    trace_id String\n)\nENGINE = MergeTree()\nORDER BY (trace_id, span_id, timestamp)"
+	got := ReplacingSpansDDL(traces)
+	if !strings.Contains(got, "ENGINE = ReplacingMergeTree()") || !strings.Contains(got, "ORDER BY (trace_id, span_id, timestamp)") {
+		t.Errorf("ReplacingSpansDDL(otel_traces) = %q", got)
+	}
+
+	index := "CREATE TABLE IF NOT EXISTS otel_trace_index (\n    trace_id String\n)\nENGINE = MergeTree()"
+	if got := ReplacingSpansDDL(index); got != index {
+		t.Errorf("other tables should be unchanged, got %q", got)
+	}
+
+	ddl, err := ClusterDDL(ReplacingSpansDDL(traces), "otel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(ddl[0], "ENGINE = ReplicatedReplacingMergeTree(") {
+		t.Errorf("cluster rewrite should replicate the replacing engine, got %q", ddl[0])
+	}
+}
+
+func TestSpansTable(t *testing.T) {
+	c := &Client{config: &config.ClickHouseConfig{}}
+	if got := c.SpansTable(); got != "otel_traces" {
+		t.Errorf("SpansTable() = %q", got)
+	}
+	c.config.DeduplicateSpans = true
+	if got := c.SpansTable(); got != "otel_traces FINAL" {
+		t.Errorf("SpansTable() with deduplication = %q", got)
+	}
+}
@@ -0,0 +1,111 @@
+package clickhouse
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"otelservices/internal/models"
+)
+
+// timestampColumns are the time columns of each table written with the
+// full precision of a span, log record or metric
+var timestampColumns = map[string][]string{
+	"otel_traces":      {"timestamp", "start_time", "end_time"},
+	"otel_trace_index": {"min_timestamp", "max_timestamp"},
+	"otel_logs":        {"timestamp", "observed_timestamp"},
+	"otel_metrics":     {"timestamp"},
+}
+
+// TimestampColumn is the stored precision of one time column
+type TimestampColumn struct {
+	Table     string `json:"table"`
+	Column    string `json:"column"`
+	Type      string `json:"type"`
+	Precision int    `json:"precision"` // fractional second digits, 9 for nanoseconds
+}
+
+// Truncates reports whether the column drops digits of a nanosecond timestamp
+func (c TimestampColumn) Truncates() bool {
+	return c.Precision < models.TimestampPrecision
+}
+
+var dateTime64Pattern = regexp.MustCompile(`DateTime64\((\d+)`)
+
+// dateTimePrecision returns the fractional second digits of a DateTime or
+// DateTime64 column type, which may be wrapped in Nullable or
+// LowCardinality and carry a time zone
+func dateTimePrecision(typ string) (int, bool) {
+	if m := dateTime64Pattern.FindStringSubmatch(typ); m != nil {
+		p, err := strconv.Atoi(m[1])
+		return p, err == nil
+	}
+	if strings.Contains(typ, "DateTime") {
+		return 0, true
+	}
+	return 0, false
+}
+
+// TimestampColumns reads the type of every time column from system.columns
+// of the node the client is connected to. On a cluster these are the
+// columns of the local tables, which hold the rows.
+func (c *Client) TimestampColumns(ctx context.Context) ([]TimestampColumn, error) {
+	var tables, columns []string
+	local := make(map[string]string)
+	for table, cols := range timestampColumns {
+		tables = append(tables, c.LocalTable(table))
+		local[c.LocalTable(table)] = table
+		columns = append(columns, cols...)
+	}
+	rows, err := c.Query(ctx, `
+		SELECT table, name, type
+		FROM system.columns
+		WHERE database = currentDatabase() AND table IN ? AND name IN ?
+		ORDER BY table, position`, tables, columns)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read timestamp columns: %w", err)
+	}
+	defer rows.Close()
+
+	var result []TimestampColumn
+	for rows.Next() {
+		var col TimestampColumn
+		if err := rows.Scan(&col.Table, &col.Column, &col.Type); err != nil {
+			return nil, fmt.Errorf("failed to scan timestamp column: %w", err)
+		}
+		col.Table = local[col.Table]
+		if !isTimestampColumn(col.Table, col.Column) {
+			continue
+		}
+		p, ok := dateTimePrecision(col.Type)
+		if !ok {
+			return nil, fmt.Errorf("column %s.%s has type %s, expected DateTime64", col.Table, col.Column, col.Type)
+		}
+		col.Precision = p
+		result = append(result, col)
+	}
+	return result, rows.Err()
+}
+
+func isTimestampColumn(table, column string) bool {
+	for _, c := range timestampColumns[table] {
+		if c == column {
+			return true
+		}
+	}
+	return false
+}
+
+// SpanTimePrecision returns the lowest precision of the otel_traces time
+// columns in cols, models.TimestampPrecision when none are listed
+func SpanTimePrecision(cols []TimestampColumn) int {
+	precision := models.TimestampPrecision
+	for _, c := range cols {
+		if c.Table == "otel_traces" && c.Precision < precision {
+			precision = c.Precision
+		}
+	}
+	return precision
+}
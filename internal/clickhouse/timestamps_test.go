@@ -0,0 +1,41 @@
+package clickhouse
+
+import "testing"
+
+func TestDateTimePrecision(t *testing.T) {
+	tests := []struct {
+		typ  string
+		want int
+		ok   bool
+	}{
+		{"DateTime64(9)", 9, true},
+		{"DateTime64(3, 'UTC')", 3, true},
+		{"Nullable(DateTime64(6))", 6, true},
+		{"DateTime", 0, true},
+		{"DateTime('Europe/Berlin')", 0, true},
+		{"UInt64", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := dateTimePrecision(tt.typ)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("dateTimePrecision(%q) = %d, %v; want %d, %v", tt.typ, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestSpanTimePrecision(t *testing.T) {
+	if got := SpanTimePrecision(nil); got != 9 {
+		t.Errorf("without columns = %d, want 9", got)
+	}
+	cols := []TimestampColumn{
+		{Table: "otel_logs", Column: "timestamp", Precision: 0},
+		{Table: "otel_traces", Column: "start_time", Precision: 9},
+		{Table: "otel_traces", Column: "end_time", Precision: 3},
+	}
+	if got := SpanTimePrecision(cols); got != 3 {
+		t.Errorf("SpanTimePrecision = %d, want 3", got)
+	}
+	if !cols[2].Truncates() || cols[1].Truncates() {
+		t.Error("only columns below nanoseconds truncate")
+	}
+}
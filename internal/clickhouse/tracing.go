@@ -0,0 +1,86 @@
+package clickhouse
+
+import (
+	"context"
+	"strings"
+	"unicode/utf8"
+
+	"otelservices/internal/monitoring"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// maxStatementLength bounds the db.statement attribute of query spans, as
+// generated queries with many filters can be long
+const maxStatementLength = 2048
+
+// startQuerySpan starts a client span for a statement. The statement is
+// recorded with its whitespace collapsed and truncated to maxStatementLength.
+func (c *Client) startQuerySpan(ctx context.Context, statement string) (context.Context, trace.Span) {
+	statement = truncateStatement(statement)
+	operation, _, _ := strings.Cut(statement, " ")
+	operation = strings.ToUpper(operation)
+	return monitoring.Tracer().Start(ctx, operation+" "+c.config.Database,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemClickhouse,
+			semconv.DBName(c.config.Database),
+			semconv.DBOperation(operation),
+			semconv.DBStatement(statement),
+		),
+	)
+}
+
+// startInsertSpan starts a client span for a batch insert into table
+func (c *Client) startInsertSpan(ctx context.Context, table string, rows int) (context.Context, trace.Span) {
+	return monitoring.Tracer().Start(ctx, "INSERT "+c.config.Database+"."+table,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemClickhouse,
+			semconv.DBName(c.config.Database),
+			semconv.DBOperation("INSERT"),
+			semconv.DBSQLTable(table),
+			attribute.Int("db.clickhouse.rows", rows),
+		),
+	)
+}
+
+// endSpan records err, if any, and ends span
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// truncateStatement collapses whitespace and cuts statements longer than
+// maxStatementLength at a character boundary
+func truncateStatement(statement string) string {
+	statement = strings.Join(strings.Fields(statement), " ")
+	if len(statement) <= maxStatementLength {
+		return statement
+	}
+	cut := maxStatementLength
+	for cut > 0 && !utf8.RuneStart(statement[cut]) {
+		cut--
+	}
+	return statement[:cut] + "..."
+}
+
+// tracedRows ends the query span when the rows are closed, so the span
+// covers reading the result
+type tracedRows struct {
+	driver.Rows
+	span trace.Span
+}
+
+func (r *tracedRows) Close() error {
+	err := r.Rows.Close()
+	endSpan(r.span, r.Rows.Err())
+	return err
+}
@@ -0,0 +1,22 @@
+package clickhouse
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTruncateStatement(t *testing.T) {
+	got := truncateStatement("\n\t\tSELECT count()\n\t\tFROM otel_traces\n\t\tWHERE service_name = ?\n\t")
+	if got != "SELECT count() FROM otel_traces WHERE service_name = ?" {
+		t.Errorf("whitespace not collapsed: %q", got)
+	}
+
+	long := "SELECT " + strings.Repeat("é", maxStatementLength)
+	got = truncateStatement(long)
+	if !strings.HasSuffix(got, "...") || len(got) > maxStatementLength+3 {
+		t.Errorf("long statement not truncated: %d bytes", len(got))
+	}
+	if trimmed := strings.TrimSuffix(got, "..."); !strings.HasPrefix(long, trimmed) || !strings.HasSuffix(trimmed, "é") {
+		t.Errorf("statement cut inside a character: %q", trimmed[len(trimmed)-4:])
+	}
+}
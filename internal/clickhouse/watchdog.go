@@ -0,0 +1,123 @@
+package clickhouse
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"otelservices/internal/config"
+	"otelservices/internal/monitoring"
+)
+
+// SetHealthCheck makes the watchdog report the connection state to h, so
+// the service stops being ready while ClickHouse is unreachable
+func (c *Client) SetHealthCheck(h *monitoring.HealthCheck) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.health = h
+}
+
+// Healthy reports whether the watchdog considers the connection usable
+func (c *Client) Healthy() bool {
+	return !c.unhealthy.Load()
+}
+
+// watch pings ClickHouse every interval until the client is closed
+func (c *Client) watch(cfg config.Watchdog) {
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+	failures := 0
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			failures = c.checkConnection(cfg, failures)
+		}
+	}
+}
+
+// checkConnection pings the current connection and returns the number of
+// consecutive failures. From the failure threshold on, the client is
+// unhealthy and every check reconnects, each time starting from another
+// address so a dead node is skipped.
+func (c *Client) checkConnection(cfg config.Watchdog, failures int) int {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeout)
+	err := c.current().Ping(ctx)
+	cancel()
+	if err == nil {
+		c.setHealthy(true, nil)
+		return 0
+	}
+
+	failures++
+	monitoring.ClickHousePingFailures.Inc()
+	logger.Warn("Ping failed", "addresses", c.addresses(), "failures", failures, "error", err)
+	if failures < cfg.FailureThreshold {
+		return failures
+	}
+	c.setHealthy(false, err)
+
+	if err := c.reconnect(failures - cfg.FailureThreshold); err != nil {
+		monitoring.ClickHouseReconnects.WithLabelValues("error").Inc()
+		logger.Error("Reconnect failed", "error", err)
+		return failures
+	}
+	monitoring.ClickHouseReconnects.WithLabelValues("success").Inc()
+	c.setHealthy(true, nil)
+	return 0
+}
+
+// reconnect resolves the addresses again and opens a new connection with
+// them rotated by attempt. The connection is pinged before it replaces the
+// current one.
+func (c *Client) reconnect(attempt int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	addrs, err := resolveAddresses(ctx, c.resolver, c.config.Addresses)
+	if err != nil {
+		return fmt.Errorf("failed to resolve ClickHouse addresses: %w", err)
+	}
+	order := rotate(addrs, attempt)
+	conn, err := c.dial(order)
+	if err != nil {
+		return err
+	}
+	// The resolved order is kept so discovery still sees the same set
+	c.swap(conn, addrs)
+	logger.Info("Reconnected", "addresses", order)
+	return nil
+}
+
+// rotate returns addrs starting at index n modulo their number
+func rotate(addrs []string, n int) []string {
+	if len(addrs) == 0 {
+		return addrs
+	}
+	n %= len(addrs)
+	return append(append([]string{}, addrs[n:]...), addrs[:n]...)
+}
+
+// setHealthy records a change of the connection state
+func (c *Client) setHealthy(healthy bool, err error) {
+	if c.unhealthy.Swap(!healthy) == !healthy {
+		return
+	}
+	c.mu.RLock()
+	h := c.health
+	c.mu.RUnlock()
+
+	if healthy {
+		monitoring.ClickHouseUp.Set(1)
+		logger.Info("Connection recovered")
+		if h != nil {
+			h.SetAvailable(true, "")
+		}
+		return
+	}
+	monitoring.ClickHouseUp.Set(0)
+	logger.Error("Connection unhealthy", "error", err)
+	if h != nil {
+		h.SetAvailable(false, "clickhouse unreachable: "+err.Error())
+	}
+}
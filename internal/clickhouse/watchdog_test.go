@@ -0,0 +1,80 @@
+package clickhouse
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"otelservices/internal/config"
+	"otelservices/internal/monitoring"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+)
+
+// pingConn answers Ping with err
+type pingConn struct {
+	fakeConn
+	err error
+}
+
+func (c *pingConn) Ping(ctx context.Context) error {
+	return c.err
+}
+
+func TestWatchdogReconnects(t *testing.T) {
+	dead := &pingConn{err: errors.New("connection refused")}
+	var dialed [][]string
+	c := &Client{
+		conn:     dead,
+		addrs:    []string{"10.0.0.1:9000", "10.0.0.2:9000"},
+		config:   &config.ClickHouseConfig{Addresses: []string{"10.0.0.1:9000", "10.0.0.2:9000"}},
+		resolver: &fakeResolver{},
+		dial: func(addrs []string) (driver.Conn, error) {
+			dialed = append(dialed, addrs)
+			if len(dialed) == 1 {
+				return nil, errors.New("dial failed")
+			}
+			return &pingConn{}, nil
+		},
+	}
+	health := monitoring.NewHealthCheck()
+	health.SetReady(true)
+	c.SetHealthCheck(health)
+	cfg := config.Watchdog{Timeout: 1, FailureThreshold: 2}
+
+	failures := c.checkConnection(cfg, 0)
+	if failures != 1 || !c.Healthy() || len(dialed) != 0 {
+		t.Fatalf("one failed ping should not reconnect: failures=%d dialed=%v", failures, dialed)
+	}
+
+	failures = c.checkConnection(cfg, failures)
+	if failures != 2 || c.Healthy() || health.IsReady() {
+		t.Fatalf("failures=%d healthy=%v ready=%v", failures, c.Healthy(), health.IsReady())
+	}
+	if state, _ := health.State(); state != monitoring.StateUnavailable {
+		t.Errorf("readiness state = %s, want unavailable", state)
+	}
+
+	failures = c.checkConnection(cfg, failures)
+	if failures != 0 || !c.Healthy() || !health.IsReady() || c.current() == dead {
+		t.Fatalf("reconnect did not recover: failures=%d healthy=%v", failures, c.Healthy())
+	}
+	want := [][]string{{"10.0.0.1:9000", "10.0.0.2:9000"}, {"10.0.0.2:9000", "10.0.0.1:9000"}}
+	if !slices.EqualFunc(dialed, want, slices.Equal[[]string]) {
+		t.Errorf("dialed %v, want %v", dialed, want)
+	}
+	if !slices.Equal(c.addresses(), want[0]) {
+		t.Errorf("addresses = %v, want the resolved order", c.addresses())
+	}
+}
+
+func TestRotate(t *testing.T) {
+	addrs := []string{"a", "b", "c"}
+	if got := rotate(addrs, 4); !slices.Equal(got, []string{"b", "c", "a"}) {
+		t.Errorf("rotate = %v", got)
+	}
+	if !slices.Equal(addrs, []string{"a", "b", "c"}) {
+		t.Error("rotate modified its input")
+	}
+}
@@ -0,0 +1,247 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Diagnostic severities
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// defaultCheckDialTimeout bounds each dial test when the config has no
+// ClickHouse dial timeout
+const defaultCheckDialTimeout = 5 * time.Second
+
+// Diagnostic is one problem CheckConfig found
+type Diagnostic struct {
+	Severity string `json:"severity"`
+	Setting  string `json:"setting,omitempty"` // e.g. server.port
+	Message  string `json:"message"`
+}
+
+func (d Diagnostic) String() string {
+	if d.Setting == "" {
+		return d.Severity + ": " + d.Message
+	}
+	return d.Severity + ": " + d.Setting + ": " + d.Message
+}
+
+// CheckOptions selects the checks of CheckConfig beyond validation
+type CheckOptions struct {
+	// Service is "collector" or "query" to check only the ports that
+	// service listens on; empty checks all of them
+	Service string
+	// Dial tests that every ClickHouse address accepts connections
+	Dial bool
+}
+
+// CheckResult is the outcome of CheckConfig
+type CheckResult struct {
+	Path        string
+	Diagnostics []Diagnostic
+	// Effective is the config as a service runs it, with its defaults
+	// filled in and credentials redacted; nil when the file cannot be read
+	Effective *Config
+}
+
+// Valid reports whether no check failed. Warnings do not fail a config.
+func (r *CheckResult) Valid() bool {
+	for _, d := range r.Diagnostics {
+		if d.Severity == SeverityError {
+			return false
+		}
+	}
+	return true
+}
+
+// MarshalJSON encodes the effective config keyed like the YAML file, as the
+// admin config endpoint does
+func (r *CheckResult) MarshalJSON() ([]byte, error) {
+	view := struct {
+		Path        string                 `json:"path"`
+		Valid       bool                   `json:"valid"`
+		Diagnostics []Diagnostic           `json:"diagnostics"`
+		Config      map[string]interface{} `json:"config,omitempty"`
+	}{Path: r.Path, Valid: r.Valid(), Diagnostics: r.Diagnostics}
+	if view.Diagnostics == nil {
+		view.Diagnostics = []Diagnostic{}
+	}
+	if r.Effective != nil {
+		m, err := r.Effective.toMap()
+		if err != nil {
+			return nil, err
+		}
+		view.Config = m
+	}
+	return json.Marshal(view)
+}
+
+// Write prints the effective config as YAML to out and the diagnostics and
+// the verdict to diag. It fails unless the config is valid.
+func (r *CheckResult) Write(out, diag io.Writer) error {
+	if r.Effective != nil {
+		data, err := yaml.Marshal(r.Effective)
+		if err != nil {
+			return fmt.Errorf("failed to encode config: %w", err)
+		}
+		if _, err := out.Write(data); err != nil {
+			return err
+		}
+	}
+	errs := 0
+	for _, d := range r.Diagnostics {
+		if d.Severity == SeverityError {
+			errs++
+		}
+		fmt.Fprintf(diag, "%s: %s\n", r.Path, d)
+	}
+	if errs > 0 {
+		return fmt.Errorf("%s: %d of %d checks failed", r.Path, errs, len(r.Diagnostics))
+	}
+	fmt.Fprintf(diag, "%s: valid\n", r.Path)
+	return nil
+}
+
+// CheckConfig loads a config file like LoadConfig, but reports every
+// problem it finds instead of the first: parse errors such as invalid
+// durations, the validation error, listening ports that conflict and, with
+// opts.Dial, ClickHouse addresses that cannot be reached
+func CheckConfig(ctx context.Context, path string, opts CheckOptions) *CheckResult {
+	r := &CheckResult{Path: path}
+	cfg, err := load(path)
+	if err != nil {
+		var typeErr *yaml.TypeError
+		if errors.As(err, &typeErr) {
+			for _, msg := range typeErr.Errors {
+				if strings.HasSuffix(msg, "into time.Duration") {
+					msg += "; durations are numbers with a unit, such as 500ms, 30s or 1h"
+				}
+				r.add(SeverityError, "", "%s", msg)
+			}
+		} else {
+			r.add(SeverityError, "", "%s", err.Error())
+		}
+		return r
+	}
+
+	if err := cfg.Validate(); err != nil {
+		r.add(SeverityError, "", "%s", err.Error())
+	}
+	r.checkPorts(cfg, opts.Service)
+	if opts.Dial {
+		r.dialClickHouse(ctx, &cfg.ClickHouse)
+	}
+	r.Effective = cfg.Effective()
+	return r
+}
+
+func (r *CheckResult) add(severity, setting, format string, args ...interface{}) {
+	r.Diagnostics = append(r.Diagnostics, Diagnostic{Severity: severity, Setting: setting, Message: fmt.Sprintf(format, args...)})
+}
+
+// checkPorts reports ports out of range and ports that more than one
+// listener of service uses, and warns about privileged ports
+func (r *CheckResult) checkPorts(cfg *Config, service string) {
+	type listener struct {
+		setting string
+		port    int
+	}
+	var listeners []listener
+	if service == "" || service == "collector" {
+		listeners = append(listeners, listener{"otlp.grpc_port", cfg.OTLP.GRPCPort})
+		if cfg.OTLP.EnableHTTP {
+			listeners = append(listeners, listener{"otlp.http_port", cfg.OTLP.HTTPPort})
+		}
+	}
+	if service == "" || service == "query" {
+		listeners = append(listeners, listener{"server.grpc_port", cfg.Server.GRPCPort})
+	}
+	listeners = append(listeners, listener{"server.port", cfg.Server.Port}, listener{"monitoring.metrics_port", cfg.Monitoring.MetricsPort})
+
+	used := make(map[int]string)
+	for _, l := range listeners {
+		switch {
+		case l.port < 0 || l.port > 65535:
+			r.add(SeverityError, l.setting, "port %d is not between 0 and 65535", l.port)
+		case l.port == 0:
+			// A random port, or none for the optional listeners
+		case used[l.port] != "":
+			r.add(SeverityError, l.setting, "port %d is also used by %s", l.port, used[l.port])
+		default:
+			used[l.port] = l.setting
+			if l.port < 1024 {
+				r.add(SeverityWarning, l.setting, "port %d needs root or CAP_NET_BIND_SERVICE", l.port)
+			}
+		}
+	}
+}
+
+// dialClickHouse reports ClickHouse addresses that do not accept TCP
+// connections, and SRV names that do not resolve
+func (r *CheckResult) dialClickHouse(ctx context.Context, cfg *ClickHouseConfig) {
+	timeout := cfg.DialTimeout
+	if timeout <= 0 {
+		timeout = defaultCheckDialTimeout
+	}
+	dialer := net.Dialer{Timeout: timeout}
+	for _, addr := range cfg.Addresses {
+		a, err := ParseClickHouseAddress(addr)
+		if err != nil {
+			continue // reported by Validate
+		}
+		if a.Discovery == "dnssrv" {
+			if _, _, err := net.DefaultResolver.LookupSRV(ctx, "", "", a.Host); err != nil {
+				r.add(SeverityError, "clickhouse.addresses", "%s does not resolve: %v", addr, err)
+			}
+			continue
+		}
+		conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(a.Host, a.Port))
+		if err != nil {
+			r.add(SeverityError, "clickhouse.addresses", "%s is unreachable: %v", addr, err)
+			continue
+		}
+		conn.Close()
+	}
+}
+
+// Effective returns a copy of the config as the services run it, with
+// credentials redacted and the settings they fall back on for unset ones
+// filled in: the per-signal batch settings and the exporter queues, retry
+// policies and signals
+func (c *Config) Effective() *Config {
+	e := c.Redacted()
+	e.Performance.Signals = SignalsTuning{
+		Traces:  c.Performance.ForSignal("traces").tuning(),
+		Metrics: c.Performance.ForSignal("metrics").tuning(),
+		Logs:    c.Performance.ForSignal("logs").tuning(),
+	}
+	for i, exp := range e.Exporters {
+		exp = exp.WithDefaults(c.Performance)
+		if len(exp.Signals) == 0 {
+			exp.Signals = []string{"traces", "metrics", "logs"}
+		}
+		e.Exporters[i] = exp
+	}
+	return e
+}
+
+// tuning returns the batch settings of p
+func (p PerformanceConfig) tuning() SignalTuning {
+	return SignalTuning{
+		WorkerCount:  p.WorkerCount,
+		QueueSize:    p.QueueSize,
+		BatchSize:    p.BatchSize,
+		BatchTimeout: p.BatchTimeout,
+	}
+}
@@ -0,0 +1,203 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// writeCheckConfig writes the example collector config with the settings
+// of overrides merged in
+func writeCheckConfig(t *testing.T, overrides string) string {
+	t.Helper()
+	data, err := os.ReadFile("../../configs/collector.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var base, over map[string]interface{}
+	if err := yaml.Unmarshal(data, &base); err != nil {
+		t.Fatal(err)
+	}
+	if err := yaml.Unmarshal([]byte(overrides), &over); err != nil {
+		t.Fatal(err)
+	}
+	merge(base, over)
+	if data, err = yaml.Marshal(base); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "collector.yaml")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func merge(dst, src map[string]interface{}) {
+	for k, v := range src {
+		if sub, ok := v.(map[string]interface{}); ok {
+			if d, ok := dst[k].(map[string]interface{}); ok {
+				merge(d, sub)
+				continue
+			}
+		}
+		dst[k] = v
+	}
+}
+
+func TestCheckConfig(t *testing.T) {
+	ctx := context.Background()
+	r := CheckConfig(ctx, "../../configs/collector.yaml", CheckOptions{Service: "collector"})
+	if !r.Valid() || len(r.Diagnostics) != 0 || r.Effective == nil {
+		t.Fatalf("example config: %v", r.Diagnostics)
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		opts    CheckOptions
+		want    []string
+		valid   bool
+	}{
+		{
+			name:    "invalid durations",
+			content: "\nperformance:\n  batch_timeout: 10 seconds\n  retry_max_interval: soon\n",
+			want:    []string{"`10 seconds` into time.Duration; durations are numbers with a unit", "`soon` into time.Duration"},
+		},
+		{
+			name:    "duration without a unit",
+			content: "\nperformance:\n  batch_timeout: 10\n",
+			want:    []string{"cannot unmarshal !!int `10` into time.Duration; durations are"},
+		},
+		{
+			name:    "port conflict",
+			content: "\nmonitoring:\n  metrics_port: 4317\n",
+			opts:    CheckOptions{Service: "collector"},
+			want:    []string{"error: monitoring.metrics_port: port 4317 is also used by otlp.grpc_port"},
+		},
+		{
+			name:    "privileged port",
+			content: "\nserver:\n  port: 80\n",
+			want:    []string{"warning: server.port: port 80 needs root"},
+			valid:   true,
+		},
+		{
+			name:    "query ports only",
+			content: "\nmonitoring:\n  metrics_port: 4317\n",
+			opts:    CheckOptions{Service: "query"},
+			valid:   true,
+		},
+		{
+			name:    "validation and ports together",
+			content: "\nperformance:\n  batch_size: 0\nserver:\n  port: 70000\n",
+			want:    []string{"error: batch size must be positive", "error: server.port: port 70000 is not between 0 and 65535"},
+		},
+	}
+	for _, tt := range tests {
+		r := CheckConfig(ctx, writeCheckConfig(t, tt.content), tt.opts)
+		var got []string
+		for _, d := range r.Diagnostics {
+			got = append(got, d.String())
+		}
+		if r.Valid() != tt.valid || len(got) != len(tt.want) {
+			t.Errorf("%s: valid %v, diagnostics %q", tt.name, r.Valid(), got)
+			continue
+		}
+		for i, want := range tt.want {
+			if !strings.Contains(got[i], want) {
+				t.Errorf("%s: diagnostic %q, want %q", tt.name, got[i], want)
+			}
+		}
+	}
+}
+
+func TestCheckConfigDial(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	up := lis.Addr().String()
+	lis.Close()
+	lis, err = net.Listen("tcp", up)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	down, _ := net.Listen("tcp", "127.0.0.1:0")
+	downAddr := down.Addr().String()
+	down.Close()
+
+	path := writeCheckConfig(t, "\nclickhouse:\n  database: otel\n  dial_timeout: 1s\n  addresses: ["+up+", "+downAddr+"]\n")
+	r := CheckConfig(context.Background(), path, CheckOptions{Dial: true})
+	if r.Valid() || len(r.Diagnostics) != 1 || !strings.Contains(r.Diagnostics[0].String(), downAddr+" is unreachable") {
+		t.Errorf("diagnostics %v", r.Diagnostics)
+	}
+	if r := CheckConfig(context.Background(), path, CheckOptions{}); !r.Valid() {
+		t.Errorf("without -dial: %v", r.Diagnostics)
+	}
+}
+
+func TestCheckResultOutput(t *testing.T) {
+	path := writeCheckConfig(t, `
+clickhouse:
+  password: s3cret
+performance:
+  queue_size: 5000
+  signals:
+    logs:
+      worker_count: 8
+exporters:
+  - name: upstream
+    endpoint: "collector:4317"
+`)
+	r := CheckConfig(context.Background(), path, CheckOptions{})
+	var out, diag bytes.Buffer
+	if err := r.Write(&out, &diag); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(diag.String(), path+": valid\n") {
+		t.Errorf("diagnostics output %q", diag.String())
+	}
+	effective := out.String()
+	for _, want := range []string{"password: <redacted>", "worker_count: 8", "timeout: 10s", "- logs"} {
+		if !strings.Contains(effective, want) {
+			t.Errorf("effective config missing %q:\n%s", want, effective)
+		}
+	}
+	if strings.Contains(effective, "s3cret") {
+		t.Error("effective config shows the password")
+	}
+	if exp := r.Effective.Exporters[0]; exp.QueueSize != 5000 || exp.Timeout != DefaultExporterTimeout {
+		t.Errorf("exporter defaults %+v", exp)
+	}
+	if tr := r.Effective.Performance.Signals.Traces; tr.QueueSize != 5000 || tr.BatchTimeout != 10*time.Second {
+		t.Errorf("traces tuning %+v", tr)
+	}
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var view struct {
+		Valid       bool
+		Diagnostics []Diagnostic
+		Config      map[string]interface{}
+	}
+	if err := json.Unmarshal(data, &view); err != nil || !view.Valid || view.Diagnostics == nil || view.Config["clickhouse"] == nil {
+		t.Errorf("json %s", data)
+	}
+
+	r = CheckConfig(context.Background(), filepath.Join(t.TempDir(), "missing.yaml"), CheckOptions{})
+	out.Reset()
+	diag.Reset()
+	if err := r.Write(&out, &diag); err == nil || out.Len() != 0 || !strings.Contains(diag.String(), "failed to read config file") {
+		t.Errorf("missing file: %v, %q", err, diag.String())
+	}
+}
@@ -1,64 +1,310 @@
 package config
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"net"
+	"net/url"
 	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
+	"otelservices/internal/logging"
+	"otelservices/internal/models"
+
 	"gopkg.in/yaml.v3"
 )
 
 // Config represents the application configuration
 type Config struct {
-	Server      ServerConfig      `yaml:"server"`
-	ClickHouse  ClickHouseConfig  `yaml:"clickhouse"`
-	OTLP        OTLPConfig        `yaml:"otlp"`
-	Monitoring  MonitoringConfig  `yaml:"monitoring"`
-	Performance PerformanceConfig `yaml:"performance"`
+	Server        ServerConfig        `yaml:"server"`
+	ClickHouse    ClickHouseConfig    `yaml:"clickhouse"`
+	OTLP          OTLPConfig          `yaml:"otlp"`
+	Monitoring    MonitoringConfig    `yaml:"monitoring"`
+	Performance   PerformanceConfig   `yaml:"performance"`
+	Exporters     []ExporterConfig    `yaml:"exporters"`
+	Kafka         KafkaConfig         `yaml:"kafka"`
+	Pipelines     PipelinesConfig     `yaml:"pipelines"`
+	Routing       RoutingConfig       `yaml:"routing"`
+	DiskQueue     DiskQueueConfig     `yaml:"disk_queue"`
+	Sampling      SamplingConfig      `yaml:"sampling"`
+	Attributes    AttributesConfig    `yaml:"attributes"`
+	K8sAttributes K8sAttributesConfig `yaml:"k8s_attributes"`
+	Enrichment    EnrichmentConfig    `yaml:"enrichment"`
+	Quotas        QuotasConfig        `yaml:"quotas"`
+	Retention     RetentionConfig     `yaml:"retention"`
+	Rollups       RollupsConfig       `yaml:"rollups"`
+	SpanNames     SpanNamesConfig     `yaml:"span_names"`
+	SpanMetrics   SpanMetricsConfig   `yaml:"span_metrics"`
+	LogMetrics    LogMetricsConfig    `yaml:"log_metrics"`
+	Anomalies     AnomaliesConfig     `yaml:"anomalies"`
+	SLO           SLOConfig           `yaml:"slo"`
+	QueryCache    QueryCacheConfig    `yaml:"query_cache"`
+	Export        ExportConfig        `yaml:"export"`
+	Auth          AuthConfig          `yaml:"auth"`
+	Audit         AuditConfig         `yaml:"audit"`
+	Capture       CaptureConfig       `yaml:"capture"`
+
+	Path string `yaml:"-"` // file the config was loaded from, for reloads
 }
 
 // ServerConfig contains server-specific settings
 type ServerConfig struct {
 	Host            string        `yaml:"host"`
 	Port            int           `yaml:"port"`
+	GRPCPort        int           `yaml:"grpc_port"` // query gRPC API; 0 disables it
 	ReadTimeout     time.Duration `yaml:"read_timeout"`
 	WriteTimeout    time.Duration `yaml:"write_timeout"`
 	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	DrainTimeout    time.Duration `yaml:"drain_timeout"`
+	IdleTimeout     time.Duration `yaml:"idle_timeout"`     // keep-alive connections idle longer are closed; 0 uses read_timeout
+	MaxHeaderBytes  int           `yaml:"max_header_bytes"` // request header size limit; 0 is 1 MiB
+	H2C             bool          `yaml:"h2c"`              // serve HTTP/2 without TLS; query API only
+	CORS            CORSConfig    `yaml:"cors"`             // query API only
+}
+
+// CORSConfig lets browser applications on other origins call the query API.
+// CORS is disabled while AllowedOrigins is empty.
+type CORSConfig struct {
+	AllowedOrigins []string      `yaml:"allowed_origins"` // exact origins such as https://dash.example.com, or "*" for any
+	AllowedMethods []string      `yaml:"allowed_methods"`
+	AllowedHeaders []string      `yaml:"allowed_headers"` // request headers a browser may send
+	MaxAge         time.Duration `yaml:"max_age"`         // how long browsers may cache a preflight response
 }
 
 // ClickHouseConfig contains ClickHouse connection settings
 type ClickHouseConfig struct {
-	Addresses       []string      `yaml:"addresses"`
-	Database        string        `yaml:"database"`
-	Username        string        `yaml:"username"`
-	Password        string        `yaml:"password"`
-	MaxOpenConns    int           `yaml:"max_open_conns"`
-	MaxIdleConns    int           `yaml:"max_idle_conns"`
-	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"`
-	DialTimeout     time.Duration `yaml:"dial_timeout"`
-	Compression     string        `yaml:"compression"`
-	TLSEnabled      bool          `yaml:"tls_enabled"`
-	TLSSkipVerify   bool          `yaml:"tls_skip_verify"`
+	// Addresses are host:port pairs, or discovery entries:
+	// dns+host:port re-resolves host to all of its A/AAAA records and
+	// dnssrv+_service._proto.name uses the targets of the SRV records
+	Addresses         []string      `yaml:"addresses"`
+	DiscoveryInterval time.Duration `yaml:"discovery_interval"` // 0 resolves only at startup
+	Database          string        `yaml:"database"`
+	Username          string        `yaml:"username"`
+	Password          string        `yaml:"password"`      // or a secret reference such as vault://secret/data/otel#password
+	PasswordFile      string        `yaml:"password_file"` // file holding the password, e.g. a mounted Kubernetes secret
+	MaxOpenConns      int           `yaml:"max_open_conns"`
+	MaxIdleConns      int           `yaml:"max_idle_conns"`
+	ConnMaxLifetime   time.Duration `yaml:"conn_max_lifetime"`
+	DialTimeout       time.Duration `yaml:"dial_timeout"`
+	// Protocol is native (port 9000, 9440 with TLS) or http (8123, 8443
+	// with TLS, which also suits HTTP-only load balancers and ClickHouse Cloud)
+	Protocol string `yaml:"protocol"`
+	// Settings are ClickHouse settings sent with every query and insert
+	Settings map[string]string `yaml:"settings"`
+	// HTTPHeaders are added to every request of the http protocol
+	HTTPHeaders      map[string]string `yaml:"http_headers"`
+	Compression      string            `yaml:"compression"`       // zstd, lz4 or none; gzip, deflate or br over http
+	CompressionLevel int               `yaml:"compression_level"` // 0 uses the driver's default
+	// CompressionStatsInterval is how often insert bytes on the wire are read
+	// from system.query_log; 0 disables the compression metrics
+	CompressionStatsInterval time.Duration       `yaml:"compression_stats_interval"`
+	TLSEnabled               bool                `yaml:"tls_enabled"`
+	TLSSkipVerify            bool                `yaml:"tls_skip_verify"`
+	QueryProfiles            QueryProfiles       `yaml:"query_profiles"`
+	QueryBudget              QueryBudget         `yaml:"query_budget"`
+	QueryGate                QueryGate           `yaml:"query_gate"`
+	QueryLimits              QueryLimits         `yaml:"query_limits"`
+	Cluster                  ClusterConfig       `yaml:"cluster"`
+	Watchdog                 Watchdog            `yaml:"watchdog"`
+	InsertDeduplication      InsertDeduplication `yaml:"insert_deduplication"`
+	// DeduplicateSpans stores otel_traces as a ReplacingMergeTree and reads
+	// it with FINAL, so a span sent twice is counted once. It changes the
+	// storage layout: the collector's -schema output creates the table.
+	DeduplicateSpans bool `yaml:"deduplicate_spans"`
+	// TimestampMode decides what the collector does when the DateTime64
+	// columns of existing tables store less than nanoseconds: compat keeps
+	// the exact span times in attributes, strict refuses to start
+	TimestampMode string `yaml:"timestamp_mode"`
+}
+
+// InsertDeduplication sends a token derived from each batch's rows with its
+// insert, so ClickHouse drops a batch that is inserted again, e.g. replayed
+// from the disk queue or redelivered by Kafka after a failure
+type InsertDeduplication struct {
+	Enabled bool `yaml:"enabled"`
+	// Window is the number of recent blocks whose tokens a non-replicated
+	// table remembers (non_replicated_deduplication_window)
+	Window int `yaml:"window"`
+}
+
+// Watchdog pings ClickHouse in the background. After FailureThreshold
+// failed pings in a row the client reports unhealthy and reconnects.
+type Watchdog struct {
+	Interval         time.Duration `yaml:"interval"` // 0 disables the watchdog
+	Timeout          time.Duration `yaml:"timeout"`
+	FailureThreshold int           `yaml:"failure_threshold"`
+}
+
+// ClusterConfig runs the pipeline on a multi-node ClickHouse cluster. Each
+// table is then a replicated <name>_local table on every node plus a
+// Distributed table under the original name that queries read.
+type ClusterConfig struct {
+	Name                string        `yaml:"name"`                  // cluster in remote_servers; empty for a single node
+	InsertMode          string        `yaml:"insert_mode"`           // distributed or local
+	HealthCheckInterval time.Duration `yaml:"health_check_interval"` // per-shard checks, 0 disables them
+}
+
+// Cluster insert modes
+const (
+	InsertDistributed = "distributed" // insert into the Distributed tables, which forward rows to the shards
+	InsertLocal       = "local"       // insert into the _local tables of the node the batch is sent to
+)
+
+// Enabled reports whether a cluster is configured
+func (c *ClusterConfig) Enabled() bool {
+	return c.Name != ""
+}
+
+// ClickHouse protocols
+const (
+	ProtocolNative = "native"
+	ProtocolHTTP   = "http"
+)
+
+// ClickHouse compression methods. Gzip, deflate and brotli are only
+// supported by the http protocol.
+const (
+	CompressionZSTD    = "zstd"
+	CompressionLZ4     = "lz4"
+	CompressionNone    = "none"
+	CompressionGzip    = "gzip"
+	CompressionDeflate = "deflate"
+	CompressionBrotli  = "br"
+)
+
+// Timestamp modes, for tables whose DateTime64 columns are less precise
+// than nanoseconds. Empty means compat.
+const (
+	TimestampCompat = "compat"
+	TimestampStrict = "strict"
+)
+
+// UsesHTTP reports whether the connection uses the http protocol
+func (c *ClickHouseConfig) UsesHTTP() bool {
+	return c.Protocol == ProtocolHTTP
+}
+
+// Prefixes of ClickHouse address entries that are resolved through DNS
+const (
+	ClickHouseDNSPrefix    = "dns+"
+	ClickHouseDNSSRVPrefix = "dnssrv+"
+)
+
+// ClickHouseAddress is a parsed entry of ClickHouseConfig.Addresses
+type ClickHouseAddress struct {
+	Discovery string // "", "dns" or "dnssrv"
+	Host      string // host name, or the full SRV name for dnssrv
+	Port      string // empty for dnssrv; the port comes from the SRV records
+}
+
+// ParseClickHouseAddress parses and checks an addresses entry
+func ParseClickHouseAddress(addr string) (ClickHouseAddress, error) {
+	if name, ok := strings.CutPrefix(addr, ClickHouseDNSSRVPrefix); ok {
+		if name == "" || strings.ContainsAny(name, ":/ ") {
+			return ClickHouseAddress{}, fmt.Errorf("invalid SRV name %q", name)
+		}
+		return ClickHouseAddress{Discovery: "dnssrv", Host: name}, nil
+	}
+	a := ClickHouseAddress{}
+	if rest, ok := strings.CutPrefix(addr, ClickHouseDNSPrefix); ok {
+		a.Discovery = "dns"
+		addr = rest
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return ClickHouseAddress{}, fmt.Errorf("invalid address %q: %w", addr, err)
+	}
+	if host == "" {
+		return ClickHouseAddress{}, fmt.Errorf("invalid address %q: missing host", addr)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+		return ClickHouseAddress{}, fmt.Errorf("invalid address %q: port must be between 1 and 65535", addr)
+	}
+	a.Host, a.Port = host, port
+	return a, nil
+}
+
+// QueryProfiles holds the ClickHouse settings applied to each class of query,
+// so background jobs and exports cannot starve interactive dashboards
+type QueryProfiles struct {
+	Interactive QueryProfile `yaml:"interactive"` // synchronous API requests
+	Background  QueryProfile `yaml:"background"`  // async query jobs
+	Export      QueryProfile `yaml:"export"`      // async jobs submitted as exports
+}
+
+// QueryProfile is a set of per-query ClickHouse settings. Zero values leave
+// the server default in place.
+type QueryProfile struct {
+	MaxThreads       int           `yaml:"max_threads"`
+	MaxMemoryUsage   int64         `yaml:"max_memory_usage"` // bytes
+	MaxExecutionTime time.Duration `yaml:"max_execution_time"`
+	MaxRowsToRead    uint64        `yaml:"max_rows_to_read"` // ClickHouse aborts the query past this
+	Priority         int           `yaml:"priority"`         // lower runs first, 0 disables
+}
+
+// QueryBudget limits how much data a synchronous API query may read, as
+// estimated by EXPLAIN ESTIMATE before it runs
+type QueryBudget struct {
+	MaxRows uint64 `yaml:"max_rows"` // 0 disables the check
+	Action  string `yaml:"action"`   // reject or warn
+}
+
+// QueryGate limits how many synchronous API requests query ClickHouse at
+// once and how long each may take
+type QueryGate struct {
+	MaxConcurrent  int                      `yaml:"max_concurrent"`  // 0 means unlimited
+	MaxQueued      int                      `yaml:"max_queued"`      // requests waiting for a slot; more are rejected
+	QueueTimeout   time.Duration            `yaml:"queue_timeout"`   // longest wait for a slot
+	DefaultTimeout time.Duration            `yaml:"default_timeout"` // per request, 0 means none
+	Timeouts       map[string]time.Duration `yaml:"timeouts"`        // per route, e.g. /api/v1/traces: 10s
+}
+
+// QueryLimits bounds the time range of synchronous trace, metrics and logs
+// queries. Trace lookups by ID are exempt.
+type QueryLimits struct {
+	MaxTimeRange time.Duration `yaml:"max_time_range"` // 0 disables the limits
+	Action       string        `yaml:"action"`         // reject or clamp
 }
 
 // OTLPConfig contains OTLP receiver settings
+
+
 type OTLPConfig struct {
-	GRPCPort      int  `yaml:"grpc_port"`
-	HTTPPort      int  `yaml:"http_port"`
-	EnableGRPC    bool `yaml:"enable_grpc"`
-	EnableHTTP    bool `yaml:"enable_http"`
-	MaxRecvMsgSizeMB int `yaml:"max_recv_msg_size_mb"`
+	GRPCPort             int  `yaml:"grpc_port"`
+	HTTPPort             int  `yaml:"http_port"`
+	EnableGRPC           bool `yaml:"enable_grpc"`
+	EnableHTTP           bool `yaml:"enable_http"`
+	MaxRecvMsgSizeMB     int  `yaml:"max_recv_msg_size_mb"`
+	MaxConcurrentExports int  `yaml:"max_concurrent_exports"` // across gRPC and HTTP, 0 means unlimited
 }
 
 // MonitoringConfig contains monitoring and observability settings
 type MonitoringConfig struct {
-	MetricsPort     int           `yaml:"metrics_port"`
-	MetricsPath     string        `yaml:"metrics_path"`
-	LogLevel        string        `yaml:"log_level"`
-	LogFormat       string        `yaml:"log_format"`
-	HealthCheckPath string        `yaml:"health_check_path"`
-	ReadyCheckPath  string        `yaml:"ready_check_path"`
-	TraceSampleRate float64       `yaml:"trace_sample_rate"`
+	MetricsPort     int     `yaml:"metrics_port"`
+	MetricsPath     string  `yaml:"metrics_path"`
+	LogLevel        string  `yaml:"log_level"`
+	LogFormat       string  `yaml:"log_format"`
+	HealthCheckPath string  `yaml:"health_check_path"`
+	ReadyCheckPath  string  `yaml:"ready_check_path"`
+	TraceSampleRate float64 `yaml:"trace_sample_rate"`
+	// Where the services send their own traces, over OTLP/gRPC. The
+	// standard OTEL_EXPORTER_OTLP_* environment variables override these.
+	OTLPEndpoint string            `yaml:"otlp_endpoint"` // host:port or http(s)://host:port
+	OTLPInsecure bool              `yaml:"otlp_insecure"` // plaintext instead of TLS; an http:// endpoint implies it
+	OTLPHeaders  map[string]string `yaml:"otlp_headers"`  // sent with every export, e.g. authorization
+	// SelfIngest makes the collector feed its own spans and logs into its
+	// pipeline instead of exporting them; the query service ignores it
+	SelfIngest bool `yaml:"self_ingest"`
+	// InstanceID names this instance in the instance_id label of its
+	// metrics, the service.instance.id of its traces and the cluster view;
+	// empty uses $POD_NAME, or the host name
+	InstanceID string `yaml:"instance_id"`
 }
 
 // PerformanceConfig contains performance tuning settings
@@ -74,10 +320,552 @@ type PerformanceConfig struct {
 	RetryInitialInterval time.Duration `yaml:"retry_initial_interval"`
 	RetryMaxInterval     time.Duration `yaml:"retry_max_interval"`
 	CacheTTL             time.Duration `yaml:"cache_ttl"`
+	InsertLatencyTarget  time.Duration `yaml:"insert_latency_target"` // p99 goal for adaptive batch sizing, 0 disables
+	Signals              SignalsTuning `yaml:"signals"`               // per-signal overrides of the batch settings
+}
+
+// SignalsTuning holds the batch settings of each signal that differ from
+// the shared ones, e.g. more workers and a larger queue for logs
+type SignalsTuning struct {
+	Traces  SignalTuning `yaml:"traces"`
+	Metrics SignalTuning `yaml:"metrics"`
+	Logs    SignalTuning `yaml:"logs"`
+}
+
+// SignalTuning overrides the shared batch settings for one signal. Zero
+// fields keep the shared value.
+type SignalTuning struct {
+	WorkerCount  int           `yaml:"worker_count"`
+	QueueSize    int           `yaml:"queue_size"`
+	BatchSize    int           `yaml:"batch_size"`
+	BatchTimeout time.Duration `yaml:"batch_timeout"`
+}
+
+// ForSignal returns the performance settings of one signal ("traces",
+// "metrics" or "logs") with its overrides applied
+func (p PerformanceConfig) ForSignal(signal string) PerformanceConfig {
+	var t SignalTuning
+	switch signal {
+	case "traces":
+		t = p.Signals.Traces
+	case "metrics":
+		t = p.Signals.Metrics
+	case "logs":
+		t = p.Signals.Logs
+	}
+	if t.WorkerCount > 0 {
+		p.WorkerCount = t.WorkerCount
+	}
+	if t.QueueSize > 0 {
+		p.QueueSize = t.QueueSize
+	}
+	if t.BatchSize > 0 {
+		p.BatchSize = t.BatchSize
+	}
+	if t.BatchTimeout > 0 {
+		p.BatchTimeout = t.BatchTimeout
+	}
+	return p
+}
+
+// DiskQueueConfig contains settings for persisting batches on local disk
+// before they are written, so a crash or storage outage does not lose them
+type DiskQueueConfig struct {
+	Enabled        bool   `yaml:"enabled"`
+	Directory      string `yaml:"directory"`
+	MaxSizeMiB     int    `yaml:"max_size_mib"` // per signal, 0 means unlimited
+	SegmentSizeMiB int    `yaml:"segment_size_mib"`
+}
+
+// CaptureConfig contains settings for recording a sample of the received
+// OTLP export requests to files, which the load test replays. A capture
+// runs from POST /api/v1/admin/capture until MaxDuration, MaxRequests or
+// MaxSizeMiB is reached or it is stopped.
+type CaptureConfig struct {
+	Directory   string        `yaml:"directory"`    // where capture files are written; empty disables captures
+	SampleRatio float64       `yaml:"sample_ratio"` // share of the export requests recorded
+	MaxDuration time.Duration `yaml:"max_duration"`
+	MaxRequests int           `yaml:"max_requests"`
+	MaxSizeMiB  int           `yaml:"max_size_mib"` // of a capture file, compressed
+	// KeepAttributes are the attribute keys whose values are recorded as
+	// they are; every other string value, log body and status message is
+	// replaced by a pseudonym of the same length
+	KeepAttributes []string `yaml:"keep_attributes"`
+}
+
+// KafkaConfig contains settings for buffering batches through Kafka between
+// the receivers and ClickHouse
+type KafkaConfig struct {
+	Enabled         bool     `yaml:"enabled"`
+	Brokers         []string `yaml:"brokers"`
+	TopicPrefix     string   `yaml:"topic_prefix"`
+	ConsumerGroup   string   `yaml:"consumer_group"`
+	Mode            string   `yaml:"mode"` // producer, consumer or both
+	Compression     string   `yaml:"compression"`
+	MaxMessageBytes int      `yaml:"max_message_bytes"`
+}
+
+// Produces reports whether received batches are published to Kafka
+func (k *KafkaConfig) Produces() bool {
+	return k.Enabled && k.Mode != "consumer"
+}
+
+// Consumes reports whether batches are read from Kafka and written to ClickHouse
+func (k *KafkaConfig) Consumes() bool {
+	return k.Enabled && k.Mode != "producer"
+}
+
+// RoutingConfig makes a group of collectors route each span to the one
+// owning its trace ID on a consistent-hash ring, so every span of a trace is
+// processed on one instance. The peers are a static list, the ready
+// endpoints of a Kubernetes service, or both.
+type RoutingConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Self is the host:port this instance appears as among the peers;
+	// empty uses $POD_IP, or the host name, with otlp.grpc_port
+	Self            string                  `yaml:"self"`
+	Peers           []string                `yaml:"peers"` // host:port of the OTLP gRPC receivers
+	Kubernetes      RoutingKubernetesConfig `yaml:"kubernetes"`
+	RefreshInterval time.Duration           `yaml:"refresh_interval"` // of the Kubernetes endpoints, default 30s
+	VirtualNodes    int                     `yaml:"virtual_nodes"`    // ring points per peer, default 100
+	Insecure        bool                    `yaml:"insecure"`         // plaintext gRPC between peers
+}
+
+// RoutingKubernetesConfig finds the routing peers from the endpoints of a
+// Kubernetes service, usually a headless service of the collectors
+type RoutingKubernetesConfig struct {
+	Service   string `yaml:"service"`
+	Namespace string `yaml:"namespace"`  // empty for the collector's own namespace
+	Port      int    `yaml:"port"`       // OTLP gRPC port of the endpoints, default otlp.grpc_port
+	APIServer string `yaml:"api_server"` // e.g. a kubectl proxy; empty uses the in-cluster service account
+}
+
+// PipelinesConfig maps pipeline names to their components. A name is a
+// signal, traces, metrics or logs, optionally followed by a slash and a
+// suffix, as in traces/archive, and the pipeline only carries that signal.
+// Without pipelines the collector derives them from kafka.mode.
+type PipelinesConfig map[string]PipelineConfig
+
+// PipelineConfig lists the receivers, processors and exporters of a
+// pipeline by their registered names
+type PipelineConfig struct {
+	Receivers  []string `yaml:"receivers"`
+	Processors []string `yaml:"processors"`
+	Exporters  []string `yaml:"exporters"`
+}
+
+// Pipeline components the collector registers, by kind
+var (
+	PipelineReceivers  = []string{"kafka", "otlp"}
+	PipelineProcessors = []string{}
+	PipelineExporters  = []string{"clickhouse", "kafka", "memory"}
+)
+
+// Names returns the pipeline names in order
+func (p PipelinesConfig) Names() []string {
+	names := make([]string, 0, len(p))
+	for name := range p {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// PipelineSignal returns the signal of a pipeline name, the part before
+// any slash
+func PipelineSignal(name string) string {
+	signal, _, _ := strings.Cut(name, "/")
+	return signal
+}
+
+// SamplingConfig contains trace sampling policies applied by the collector
+type SamplingConfig struct {
+	Enabled           bool             `yaml:"enabled"`
+	Policies          []SamplingPolicy `yaml:"policies"`
+	DecisionCacheSize int              `yaml:"decision_cache_size"` // traces whose decisions are kept for debugging, 0 disables
+	DecisionCacheTTL  time.Duration    `yaml:"decision_cache_ttl"`
+}
+
+// SamplingPolicy samples traces of matching services at a fixed rate. The
+// first policy whose service matches is used; traces matching no policy are kept.
+type SamplingPolicy struct {
+	Name        string            `yaml:"name"`
+	ServiceName string            `yaml:"service_name"` // empty matches every service
+	Rate        float64           `yaml:"rate"`         // fraction of traces kept, 0 to 1
+	Logs        LogSamplingConfig `yaml:"logs"`
+}
+
+// LogSamplingConfig controls what happens to logs whose trace was dropped
+type LogSamplingConfig struct {
+	Action string  `yaml:"action"` // keep, drop or downsample
+	Rate   float64 `yaml:"rate"`   // fraction kept when downsampling
+}
+
+// AttributesConfig controls how span attributes are split between the
+// queryable attributes map and the cold attributes_cold JSON column, and how
+// nested log attributes are stored
+type AttributesConfig struct {
+	MaxHotAttributes int           `yaml:"max_hot_attributes"` // spans with more attributes are tiered, 0 disables
+	HotKeys          []string      `yaml:"hot_keys"`           // attributes kept in the map when tiering
+	FlattenLogs      FlattenConfig `yaml:"flatten_logs"`
+}
+
+// FlattenConfig expands map and array attribute values into one attribute
+// per element under dotted keys, e.g. k8s.pod.labels.app, instead of storing
+// them as one JSON string
+type FlattenConfig struct {
+	Enabled        bool `yaml:"enabled"`
+	MaxDepth       int  `yaml:"max_depth"`        // nesting levels expanded; deeper values are stored as JSON
+	MaxArrayLength int  `yaml:"max_array_length"` // array elements expanded, as key.0, key.1, ...; later ones are dropped
+	MaxValueLength int  `yaml:"max_value_length"` // longer values are cut, 0 keeps them whole
+}
+
+// K8sAttributesConfig adds the Kubernetes metadata of the sending pod to the
+// resource attributes of spans and logs
+type K8sAttributesConfig struct {
+	Enabled   bool     `yaml:"enabled"`
+	APIServer string   `yaml:"api_server"` // e.g. a kubectl proxy; empty uses the in-cluster service account
+	Namespace string   `yaml:"namespace"`  // pods watched, empty for all namespaces
+	Labels    []string `yaml:"labels"`     // pod labels copied, empty for all
+}
+
+// EnrichmentConfig derives attributes of spans and logs from client
+// addresses and user agents in other attributes
+type EnrichmentConfig struct {
+	GeoIP     GeoIPConfig           `yaml:"geoip"`
+	UserAgent []EnrichmentAttribute `yaml:"user_agent"` // attributes holding user agents
+}
+
+// GeoIPConfig locates client addresses in a MaxMind DB
+type GeoIPConfig struct {
+	Database   string                `yaml:"database"`   // GeoLite2 or GeoIP2 City .mmdb file
+	Attributes []EnrichmentAttribute `yaml:"attributes"` // attributes holding client addresses
+}
+
+// EnrichmentAttribute is an attribute other attributes are derived from
+type EnrichmentAttribute struct {
+	Attribute string `yaml:"attribute"` // e.g. client.address
+	Prefix    string `yaml:"prefix"`    // of the derived attributes, default the attribute name
+}
+
+// RetentionConfig sets how long each class of table keeps data. Zero leaves
+// the TTL of that class's tables as created by the schema.
+type RetentionConfig struct {
+	Traces         time.Duration     `yaml:"traces"`     // otel_traces, otel_trace_index, otel_service_operations
+	Logs           time.Duration     `yaml:"logs"`       // otel_logs
+	Metrics        time.Duration     `yaml:"metrics"`    // raw otel_metrics
+	Metrics5m      time.Duration     `yaml:"metrics_5m"` // otel_metrics_5m
+	Rollups        time.Duration     `yaml:"rollups"`    // hourly rollup tables
+	Tenants        []TenantRetention `yaml:"tenants"`
+	ApplyOnStartup bool              `yaml:"apply_on_startup"`
+	PurgeInterval  time.Duration     `yaml:"purge_interval"` // drop expired partitions this often, 0 disables
+	Tiering        TieringConfig     `yaml:"tiering"`
+}
+
+// TieringConfig moves trace and log data past an age to a slower, cheaper
+// volume of the tables' storage policy, such as one on an S3-backed disk,
+// with TTL ... TO VOLUME rules applied along with retention
+type TieringConfig struct {
+	Volume        string        `yaml:"volume"`         // volume data is moved to; empty disables tiering
+	StoragePolicy string        `yaml:"storage_policy"` // set on the tables when applying, if not already theirs
+	Traces        time.Duration `yaml:"traces"`         // age at which trace tables move, 0 keeps them hot
+	Logs          time.Duration `yaml:"logs"`
+}
+
+// Enabled reports whether any data is moved to the slower volume
+func (t *TieringConfig) Enabled() bool {
+	return t.Volume != "" && (t.Traces > 0 || t.Logs > 0)
+}
+
+// RollupsConfig controls the collector's management of the metric rollup
+// tables and the materialized views that fill them
+type RollupsConfig struct {
+	Manage      bool          `yaml:"manage"`       // create missing rollups and verify them at startup
+	LagInterval time.Duration `yaml:"lag_interval"` // how often rollup lag is measured, 0 disables
+}
+
+// AnomaliesConfig controls the query service's detection of p95 latency and
+// error rate anomalies per service, against EWMA baselines
+type AnomaliesConfig struct {
+	Enabled     bool          `yaml:"enabled"`
+	Interval    time.Duration `yaml:"interval"`     // how often services are evaluated
+	Window      time.Duration `yaml:"window"`       // spans evaluated each time, ending now
+	Alpha       float64       `yaml:"alpha"`        // EWMA weight of the newest value
+	Threshold   float64       `yaml:"threshold"`    // standard deviations above the baseline that are anomalous
+	Warmup      int           `yaml:"warmup"`       // evaluations before a service can be flagged
+	MinRequests int           `yaml:"min_requests"` // services with fewer spans in the window are skipped
+}
+
+// SLOConfig lists the service level objectives the query service tracks.
+// Evaluation is off while there are none.
+type SLOConfig struct {
+	Interval    time.Duration   `yaml:"interval"`     // how often objectives are evaluated
+	BurnWindows []time.Duration `yaml:"burn_windows"` // windows burn rates are reported for
+	Objectives  []SLOObjective  `yaml:"objectives"`
+}
+
+// SLOObjective is a target share of good requests to a service over a
+// rolling window. Requests are server and consumer spans; a request is bad
+// when its status is error or, with a latency threshold, it is slower.
+type SLOObjective struct {
+	Name             string        `yaml:"name"`
+	Service          string        `yaml:"service"`
+	Operation        string        `yaml:"operation"`         // span name; empty covers all of the service's requests
+	Target           float64       `yaml:"target"`            // e.g. 0.999
+	LatencyThreshold time.Duration `yaml:"latency_threshold"` // 0 tracks availability only
+	Window           time.Duration `yaml:"window"`            // compliance period, e.g. 720h
+}
+
+// QueryCacheConfig controls the query service's cache of query responses.
+// Entries live for performance.cache_ttl.
+type QueryCacheConfig struct {
+	Enabled           bool   `yaml:"enabled"`
+	MaxSizeMiB        int    `yaml:"max_size_mib"`  // in-process cache size
+	RedisAddress      string `yaml:"redis_address"` // host:port; shares the cache between query instances instead
+	RedisPassword     string `yaml:"redis_password"`
+	RedisPasswordFile string `yaml:"redis_password_file"`
+	RedisDB           int    `yaml:"redis_db"`
+}
+
+// AuthConfig requires query API callers to authenticate with an API key or
+// an OIDC-issued JWT, and grants them scopes that routes require
+type AuthConfig struct {
+	Enabled bool           `yaml:"enabled"`
+	APIKeys []APIKeyConfig `yaml:"api_keys"`
+	OIDC    OIDCConfig     `yaml:"oidc"`
+	// Roles names sets of scopes, granted to API keys and to tokens whose
+	// roles claim lists them
+	Roles map[string][]string `yaml:"roles"`
+	// RouteScopes replaces the scopes a route requires, keyed by its path
+	// template such as /api/v1/usage; an empty list only requires a caller
+	RouteScopes map[string][]string `yaml:"route_scopes"`
+}
+
+// APIKeyConfig is an API key and what it may do. Either the key itself or
+// its hex SHA-256 digest is configured.
+type APIKeyConfig struct {
+	Name      string   `yaml:"name"` // identifies the caller in logs
+	Key       string   `yaml:"key"`
+	KeyFile   string   `yaml:"key_file"`
+	KeySHA256 string   `yaml:"key_sha256"`
+	Scopes    []string `yaml:"scopes"`
+	Roles     []string `yaml:"roles"`
+}
+
+// OIDCConfig validates JWT bearer tokens issued by an OpenID Connect
+// provider against the provider's published signing keys
+type OIDCConfig struct {
+	IssuerURL       string        `yaml:"issuer_url"`       // must match the iss claim; empty disables JWTs
+	Audience        string        `yaml:"audience"`         // required in the aud claim when set
+	JWKSURL         string        `yaml:"jwks_url"`         // defaults to the jwks_uri of the issuer's discovery document
+	ScopesClaim     string        `yaml:"scopes_claim"`     // space-separated string or list of scopes
+	RolesClaim      string        `yaml:"roles_claim"`      // list of role names; dots reach nested claims
+	RefreshInterval time.Duration `yaml:"refresh_interval"` // how often the signing keys are re-read
+	ClockSkew       time.Duration `yaml:"clock_skew"`       // leeway for exp and nbf
+}
+
+// AuditConfig records every query API request, with its caller, filters,
+// rows returned and duration, in the otel_audit table
+type AuditConfig struct {
+	Enabled       bool          `yaml:"enabled"`
+	FlushInterval time.Duration `yaml:"flush_interval"` // how often recorded requests are written
+	BatchSize     int           `yaml:"batch_size"`     // records that trigger an early write
+	QueueSize     int           `yaml:"queue_size"`     // records held while ClickHouse is slow; more are dropped
+}
+
+// ExportConfig sets where export jobs write query results. Each destination
+// is enabled by configuring it.
+type ExportConfig struct {
+	Directory string   `yaml:"directory"` // local directory for export files; empty disables local exports
+	S3        S3Config `yaml:"s3"`
+}
+
+// S3Config is an S3-compatible bucket export files are uploaded to
+type S3Config struct {
+	Endpoint            string `yaml:"endpoint"` // e.g. https://s3.us-east-1.amazonaws.com; empty disables S3 exports
+	Region              string `yaml:"region"`
+	Bucket              string `yaml:"bucket"`
+	Prefix              string `yaml:"prefix"` // prepended to object keys
+	AccessKeyID         string `yaml:"access_key_id"`
+	SecretAccessKey     string `yaml:"secret_access_key"`
+	SecretAccessKeyFile string `yaml:"secret_access_key_file"`
+}
+
+// Enabled reports whether S3 exports are configured
+func (s *S3Config) Enabled() bool {
+	return s.Endpoint != ""
+}
+
+// SpanMetricsConfig controls the request, error and duration metrics the
+// collector derives from received spans, before sampling
+type SpanMetricsConfig struct {
+	Enabled       bool            `yaml:"enabled"`
+	FlushInterval time.Duration   `yaml:"flush_interval"`
+	Namespace     string          `yaml:"namespace"`  // metric name prefix
+	Dimensions    []string        `yaml:"dimensions"` // span attributes added as metric attributes
+	Buckets       []time.Duration `yaml:"buckets"`    // duration histogram bounds
+}
+
+// LogMetricsConfig holds the rules that turn matching log records into
+// metrics written to otel_metrics
+type LogMetricsConfig struct {
+	FlushInterval time.Duration   `yaml:"flush_interval"`
+	Rules         []LogMetricRule `yaml:"rules"`
+}
+
+// SpanNamesConfig normalizes span names at ingest, so IDs in names such as
+// GET /users/123 do not create an operation per request
+type SpanNamesConfig struct {
+	Rules        []SpanNameRule `yaml:"rules"`
+	RawAttribute string         `yaml:"raw_attribute"` // attribute keeping the original of a changed name, empty drops it
+}
+
+// SpanNameRule replaces every match of Pattern in span names. Rules apply in
+// order, each to the result of the previous one.
+type SpanNameRule struct {
+	ServiceName string `yaml:"service_name"` // empty matches every service
+	Pattern     string `yaml:"pattern"`
+	Replacement string `yaml:"replacement"` // may refer to groups as ${1} or ${name}
+}
+
+// QuotasConfig limits the bytes and items each service or tenant may send
+// per day or month. Usage is accounted in the otel_ingest_usage table, so
+// every collector instance enforces the usage of all instances, at most one
+// flush interval late.
+type QuotasConfig struct {
+	Enabled       bool          `yaml:"enabled"`
+	FlushInterval time.Duration `yaml:"flush_interval"` // how often usage is written and totals are reloaded
+	Limits        []Quota       `yaml:"limits"`
+}
+
+// Quota limits one service, or every service of one namespace, per period.
+// Zero leaves a dimension unlimited.
+type Quota struct {
+	ServiceName      string `yaml:"service_name"`
+	ServiceNamespace string `yaml:"service_namespace"` // tenant
+	Period           string `yaml:"period"`            // daily or monthly
+	MaxBytes         int64  `yaml:"max_bytes"`
+	MaxItems         int64  `yaml:"max_items"` // spans, metrics and log records
+}
+
+// Quota periods
+const (
+	QuotaDaily   = "daily"
+	QuotaMonthly = "monthly"
+)
+
+// PeriodStart returns the start of the period containing t, in UTC
+func (q *Quota) PeriodStart(t time.Time) time.Time {
+	t = t.UTC()
+	if q.Period == QuotaMonthly {
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// Matches reports whether the quota covers a service
+func (q *Quota) Matches(serviceName, serviceNamespace string) bool {
+	if q.ServiceName != "" {
+		return q.ServiceName == serviceName
+	}
+	return q.ServiceNamespace == serviceNamespace
+}
+
+// LogMetricRule counts matching log records, or extracts a numeric value from
+// them, per service. Every condition set must match.
+type LogMetricRule struct {
+	Name        string            `yaml:"name"`         // metric name
+	ServiceName string            `yaml:"service_name"` // empty matches every service
+	MinSeverity string            `yaml:"min_severity"` // e.g. WARN or ERROR
+	BodyRegex   string            `yaml:"body_regex"`
+	Attributes  map[string]string `yaml:"attributes"`  // exact attribute values
+	Value       string            `yaml:"value"`       // body_regex group or attribute to extract; empty counts records
+	Aggregation string            `yaml:"aggregation"` // for values: avg (default), sum, min or max
+	Labels      []string          `yaml:"labels"`      // attributes copied to the metric
+}
+
+// TenantRetention overrides raw trace, log and metric retention for one
+// service namespace. Zero keeps the default for that signal.
+type TenantRetention struct {
+	Namespace string        `yaml:"namespace"`
+	Traces    time.Duration `yaml:"traces"`
+	Logs      time.Duration `yaml:"logs"`
+	Metrics   time.Duration `yaml:"metrics"`
+}
+
+// ExporterConfig configures forwarding of received OTLP data to a downstream
+// endpoint. Each exporter has its own queue so a slow or unavailable
+// downstream never blocks ClickHouse ingestion or other exporters.
+type ExporterConfig struct {
+	Name                 string            `yaml:"name"`
+	Endpoint             string            `yaml:"endpoint"`
+	Protocol             string            `yaml:"protocol"` // grpc or http
+	Insecure             bool              `yaml:"insecure"`
+	Headers              map[string]string `yaml:"headers"`
+	Signals              []string          `yaml:"signals"` // traces, metrics, logs; empty means all
+	Timeout              time.Duration     `yaml:"timeout"`
+	QueueSize            int               `yaml:"queue_size"`
+	RetryMaxAttempts     int               `yaml:"retry_max_attempts"`
+	RetryInitialInterval time.Duration     `yaml:"retry_initial_interval"`
+	RetryMaxInterval     time.Duration     `yaml:"retry_max_interval"`
+}
+
+// DefaultExporterTimeout is the timeout of an export request to an
+// exporter without one
+const DefaultExporterTimeout = 10 * time.Second
+
+// WithDefaults returns the exporter settings with the unset ones filled in:
+// the default timeout, and the queue size and retry policy of perf
+func (e ExporterConfig) WithDefaults(perf PerformanceConfig) ExporterConfig {
+	if e.Timeout <= 0 {
+		e.Timeout = DefaultExporterTimeout
+	}
+	if e.QueueSize <= 0 {
+		e.QueueSize = perf.QueueSize
+	}
+	if e.RetryMaxAttempts <= 0 {
+		e.RetryMaxAttempts = perf.RetryMaxAttempts
+	}
+	if e.RetryInitialInterval <= 0 {
+		e.RetryInitialInterval = perf.RetryInitialInterval
+	}
+	if e.RetryMaxInterval <= 0 {
+		e.RetryMaxInterval = perf.RetryMaxInterval
+	}
+	return e
+}
+
+// ExportsSignal reports whether the exporter forwards the given signal
+func (e *ExporterConfig) ExportsSignal(signal string) bool {
+	if len(e.Signals) == 0 {
+		return true
+	}
+	for _, s := range e.Signals {
+		if s == signal {
+			return true
+		}
+	}
+	return false
 }
 
 // LoadConfig loads configuration from a YAML file
 func LoadConfig(path string) (*Config, error) {
+	config, err := load(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Validate configuration
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	return config, nil
+}
+
+// load reads a config file with its environment overrides and secrets,
+// without validating it
+func load(path string) (*Config, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read config file: %w", err)
@@ -88,14 +876,27 @@ func LoadConfig(path string) (*Config, error) {
 		return nil, fmt.Errorf("failed to parse config file: %w", err)
 	}
 
+	config.Path = path
+
 	// Apply environment variable overrides
-	applyEnvOverrides(&config)
+	if err := applyEnvOverrides(&config); err != nil {
+		return nil, fmt.Errorf("invalid environment override: %w", err)
+	}
 
-	// Validate configuration
-	if err := config.Validate(); err != nil {
+	if err := config.applySecretFiles(); err != nil {
 		return nil, fmt.Errorf("invalid configuration: %w", err)
 	}
 
+	// Secrets and secret references, which environment overrides may also
+	// hold, are read last
+	if err := config.resolveSecrets(context.Background()); err != nil {
+		return nil, fmt.Errorf("failed to resolve secrets: %w", err)
+	}
+
+	if config.Server.DrainTimeout <= 0 {
+		config.Server.DrainTimeout = config.Server.ShutdownTimeout
+	}
+
 	return &config, nil
 }
 
@@ -104,6 +905,26 @@ func (c *Config) Validate() error {
 	if len(c.ClickHouse.Addresses) == 0 {
 		return fmt.Errorf("clickhouse addresses cannot be empty")
 	}
+	for _, addr := range c.ClickHouse.Addresses {
+		if _, err := ParseClickHouseAddress(addr); err != nil {
+			return fmt.Errorf("clickhouse addresses: %w", err)
+		}
+	}
+	if c.ClickHouse.DiscoveryInterval < 0 {
+		return fmt.Errorf("clickhouse discovery interval cannot be negative")
+	}
+	if c.Server.GRPCPort < 0 || c.Server.GRPCPort > 65535 {
+		return fmt.Errorf("server grpc port must be between 0 and 65535")
+	}
+	if c.Server.GRPCPort != 0 && c.Server.GRPCPort == c.Server.Port {
+		return fmt.Errorf("server grpc port must differ from the http port")
+	}
+	if c.Server.IdleTimeout < 0 {
+		return fmt.Errorf("server idle timeout cannot be negative")
+	}
+	if c.Server.MaxHeaderBytes < 0 {
+		return fmt.Errorf("server max header bytes cannot be negative")
+	}
 	if c.ClickHouse.Database == "" {
 		return fmt.Errorf("clickhouse database cannot be empty")
 	}
@@ -113,32 +934,763 @@ func (c *Config) Validate() error {
 	if c.Performance.WorkerCount <= 0 {
 		return fmt.Errorf("worker count must be positive")
 	}
+	if c.OTLP.MaxConcurrentExports < 0 {
+		return fmt.Errorf("max concurrent exports cannot be negative")
+	}
+	if c.Performance.InsertLatencyTarget < 0 {
+		return fmt.Errorf("insert latency target cannot be negative")
+	}
+	for signal, t := range map[string]SignalTuning{
+		"traces":  c.Performance.Signals.Traces,
+		"metrics": c.Performance.Signals.Metrics,
+		"logs":    c.Performance.Signals.Logs,
+	} {
+		if t.WorkerCount < 0 || t.QueueSize < 0 || t.BatchSize < 0 || t.BatchTimeout < 0 {
+			return fmt.Errorf("performance signals %s: settings cannot be negative", signal)
+		}
+	}
+	if c.Kafka.Enabled {
+		if len(c.Kafka.Brokers) == 0 {
+			return fmt.Errorf("kafka brokers cannot be empty when kafka is enabled")
+		}
+		switch c.Kafka.Mode {
+		case "", "producer", "consumer", "both":
+		default:
+			return fmt.Errorf("unsupported kafka mode %q", c.Kafka.Mode)
+		}
+	}
+	if c.DiskQueue.Enabled && c.DiskQueue.Directory == "" {
+		return fmt.Errorf("disk queue directory cannot be empty when the disk queue is enabled")
+	}
+	if err := c.Capture.validate(); err != nil {
+		return err
+	}
+	if c.DiskQueue.MaxSizeMiB < 0 || c.DiskQueue.SegmentSizeMiB < 0 {
+		return fmt.Errorf("disk queue sizes cannot be negative")
+	}
+	if c.Sampling.DecisionCacheSize < 0 || c.Sampling.DecisionCacheTTL < 0 {
+		return fmt.Errorf("sampling decision cache settings cannot be negative")
+	}
+	for i, p := range c.Sampling.Policies {
+		if p.Name == "" {
+			return fmt.Errorf("sampling policy %d: name cannot be empty", i)
+		}
+		if p.Rate < 0 || p.Rate > 1 {
+			return fmt.Errorf("sampling policy %q: rate must be between 0 and 1", p.Name)
+		}
+		switch p.Logs.Action {
+		case "", "keep", "drop":
+		case "downsample":
+			if p.Logs.Rate < 0 || p.Logs.Rate > 1 {
+				return fmt.Errorf("sampling policy %q: logs rate must be between 0 and 1", p.Name)
+			}
+		default:
+			return fmt.Errorf("sampling policy %q: unknown logs action %q", p.Name, p.Logs.Action)
+		}
+	}
+	switch c.ClickHouse.QueryBudget.Action {
+	case "", "reject", "warn":
+	default:
+		return fmt.Errorf("unsupported query budget action %q", c.ClickHouse.QueryBudget.Action)
+	}
+	if err := c.ClickHouse.QueryGate.validate(); err != nil {
+		return err
+	}
+	if err := c.ClickHouse.Cluster.validate(); err != nil {
+		return err
+	}
+	if err := c.ClickHouse.Watchdog.validate(); err != nil {
+		return err
+	}
+	if err := c.ClickHouse.validateProtocol(); err != nil {
+		return err
+	}
+	switch c.ClickHouse.TimestampMode {
+	case "", TimestampCompat, TimestampStrict:
+	default:
+		return fmt.Errorf("unsupported clickhouse timestamp mode %q", c.ClickHouse.TimestampMode)
+	}
+	if c.ClickHouse.InsertDeduplication.Enabled && c.ClickHouse.InsertDeduplication.Window <= 0 {
+		return fmt.Errorf("clickhouse insert deduplication window must be positive")
+	}
+	if _, err := logging.ParseLevel(c.Monitoring.LogLevel); err != nil {
+		return err
+	}
+	switch c.Monitoring.LogFormat {
+	case "", "json", "text":
+	default:
+		return fmt.Errorf("unsupported log format %q", c.Monitoring.LogFormat)
+	}
+	if err := ValidateOTLPEndpoint(c.Monitoring.OTLPEndpoint); err != nil {
+		return fmt.Errorf("invalid monitoring otlp_endpoint: %w", err)
+	}
+	if c.ClickHouse.QueryLimits.MaxTimeRange < 0 {
+		return fmt.Errorf("query limits max time range cannot be negative")
+	}
+	switch c.ClickHouse.QueryLimits.Action {
+	case "", "reject", "clamp":
+	default:
+		return fmt.Errorf("unsupported query limits action %q", c.ClickHouse.QueryLimits.Action)
+	}
+	if err := c.Retention.validate(); err != nil {
+		return err
+	}
+	if c.Rollups.LagInterval < 0 {
+		return fmt.Errorf("rollup lag interval cannot be negative")
+	}
+	if err := c.Anomalies.validate(); err != nil {
+		return err
+	}
+	if err := c.SLO.validate(); err != nil {
+		return err
+	}
+	if err := c.Auth.validate(); err != nil {
+		return err
+	}
+	if err := c.Audit.validate(); err != nil {
+		return err
+	}
+	if err := c.Server.CORS.validate(); err != nil {
+		return err
+	}
+	if err := c.QueryCache.validate(); err != nil {
+		return err
+	}
+	if err := c.Export.S3.validate(); err != nil {
+		return err
+	}
+	if err := c.LogMetrics.validate(); err != nil {
+		return err
+	}
+	if err := c.SpanNames.validate(); err != nil {
+		return err
+	}
+	if err := c.SpanMetrics.validate(); err != nil {
+		return err
+	}
+	if err := c.K8sAttributes.validate(); err != nil {
+		return err
+	}
+	if err := c.Routing.validate(); err != nil {
+		return err
+	}
+	if err := c.Enrichment.validate(); err != nil {
+		return err
+	}
+	if err := c.Quotas.validate(); err != nil {
+		return err
+	}
+	if err := c.validatePipelines(); err != nil {
+		return err
+	}
+	if c.Attributes.MaxHotAttributes < 0 {
+		return fmt.Errorf("max hot attributes cannot be negative")
+	}
+	if f := c.Attributes.FlattenLogs; f.Enabled && (f.MaxDepth < 1 || f.MaxArrayLength < 0 || f.MaxValueLength < 0) {
+		return fmt.Errorf("log attribute flattening needs a max depth of at least 1 and non-negative length limits")
+	}
+	for name, p := range map[string]QueryProfile{
+		"interactive": c.ClickHouse.QueryProfiles.Interactive,
+		"background":  c.ClickHouse.QueryProfiles.Background,
+		"export":      c.ClickHouse.QueryProfiles.Export,
+	} {
+		if p.MaxThreads < 0 || p.MaxMemoryUsage < 0 || p.MaxExecutionTime < 0 || p.Priority < 0 {
+			return fmt.Errorf("query profile %q: settings cannot be negative", name)
+		}
+	}
+	names := make(map[string]bool)
+	for i, e := range c.Exporters {
+		if e.Name == "" {
+			return fmt.Errorf("exporter %d: name cannot be empty", i)
+		}
+		if names[e.Name] {
+			return fmt.Errorf("exporter %q: duplicate name", e.Name)
+		}
+		names[e.Name] = true
+		if e.Endpoint == "" {
+			return fmt.Errorf("exporter %q: endpoint cannot be empty", e.Name)
+		}
+		switch e.Protocol {
+		case "", "grpc", "http":
+		default:
+			return fmt.Errorf("exporter %q: unsupported protocol %q", e.Name, e.Protocol)
+		}
+		for _, signal := range e.Signals {
+			switch signal {
+			case "traces", "metrics", "logs":
+			default:
+				return fmt.Errorf("exporter %q: unknown signal %q", e.Name, signal)
+			}
+		}
+	}
+	return nil
+}
+
+// tenantNamespacePattern restricts namespaces to characters that are safe to
+// embed in TTL expressions
+var tenantNamespacePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+func (r *RetentionConfig) validate() error {
+	if r.Traces < 0 || r.Logs < 0 || r.Metrics < 0 || r.Metrics5m < 0 || r.Rollups < 0 || r.PurgeInterval < 0 {
+		return fmt.Errorf("retention periods cannot be negative")
+	}
+	seen := make(map[string]bool)
+	for i, t := range r.Tenants {
+		if !tenantNamespacePattern.MatchString(t.Namespace) {
+			return fmt.Errorf("retention tenant %d: invalid namespace %q", i, t.Namespace)
+		}
+		if seen[t.Namespace] {
+			return fmt.Errorf("retention tenant %q: duplicate namespace", t.Namespace)
+		}
+		seen[t.Namespace] = true
+		if t.Traces < 0 || t.Logs < 0 || t.Metrics < 0 {
+			return fmt.Errorf("retention tenant %q: periods cannot be negative", t.Namespace)
+		}
+	}
+	return r.Tiering.validate(r)
+}
+
+// storageNamePattern restricts volume and storage policy names, which are
+// embedded in DDL
+var storageNamePattern = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+func (t *TieringConfig) validate(r *RetentionConfig) error {
+	if t.Traces < 0 || t.Logs < 0 {
+		return fmt.Errorf("retention tiering ages cannot be negative")
+	}
+	if t.Volume == "" {
+		if t.Traces > 0 || t.Logs > 0 || t.StoragePolicy != "" {
+			return fmt.Errorf("retention tiering needs a volume")
+		}
+		return nil
+	}
+	if !storageNamePattern.MatchString(t.Volume) {
+		return fmt.Errorf("retention tiering: invalid volume %q", t.Volume)
+	}
+	if t.StoragePolicy != "" && !storageNamePattern.MatchString(t.StoragePolicy) {
+		return fmt.Errorf("retention tiering: invalid storage policy %q", t.StoragePolicy)
+	}
+	if r.Traces > 0 && t.Traces >= r.Traces {
+		return fmt.Errorf("retention tiering: traces must move before their retention of %s", r.Traces)
+	}
+	if r.Logs > 0 && t.Logs >= r.Logs {
+		return fmt.Errorf("retention tiering: logs must move before their retention of %s", r.Logs)
+	}
+	return nil
+}
+
+func (a *AnomaliesConfig) validate() error {
+	if !a.Enabled {
+		return nil
+	}
+	if a.Interval <= 0 || a.Window <= 0 {
+		return fmt.Errorf("anomaly interval and window must be positive")
+	}
+	if a.Alpha <= 0 || a.Alpha > 1 {
+		return fmt.Errorf("anomaly alpha must be in (0, 1], got %v", a.Alpha)
+	}
+	if a.Threshold <= 0 {
+		return fmt.Errorf("anomaly threshold must be positive")
+	}
+	if a.Warmup < 0 || a.MinRequests < 0 {
+		return fmt.Errorf("anomaly warmup and min_requests cannot be negative")
+	}
+	return nil
+}
+
+func (s *SLOConfig) validate() error {
+	if len(s.Objectives) == 0 {
+		return nil
+	}
+	if s.Interval <= 0 {
+		return fmt.Errorf("slo interval must be positive")
+	}
+	for _, w := range s.BurnWindows {
+		if w <= 0 {
+			return fmt.Errorf("slo burn windows must be positive")
+		}
+	}
+	names := make(map[string]bool)
+	for i, o := range s.Objectives {
+		if o.Name == "" {
+			return fmt.Errorf("slo objective %d: name cannot be empty", i)
+		}
+		if names[o.Name] {
+			return fmt.Errorf("slo objective %q: duplicate name", o.Name)
+		}
+		names[o.Name] = true
+		if o.Service == "" {
+			return fmt.Errorf("slo objective %q: service cannot be empty", o.Name)
+		}
+		if o.Target <= 0 || o.Target >= 1 {
+			return fmt.Errorf("slo objective %q: target must be in (0, 1), got %v", o.Name, o.Target)
+		}
+		if o.Window <= 0 {
+			return fmt.Errorf("slo objective %q: window must be positive", o.Name)
+		}
+		if o.LatencyThreshold < 0 {
+			return fmt.Errorf("slo objective %q: latency threshold cannot be negative", o.Name)
+		}
+	}
+	return nil
+}
+
+func (g *QueryGate) validate() error {
+	if g.MaxConcurrent < 0 || g.MaxQueued < 0 || g.QueueTimeout < 0 || g.DefaultTimeout < 0 {
+		return fmt.Errorf("query gate settings cannot be negative")
+	}
+	for route, timeout := range g.Timeouts {
+		if !strings.HasPrefix(route, "/") {
+			return fmt.Errorf("query gate timeouts: %q is not a route", route)
+		}
+		if timeout <= 0 {
+			return fmt.Errorf("query gate timeout of %s must be positive", route)
+		}
+	}
+	return nil
+}
+
+func (c *ClickHouseConfig) validateProtocol() error {
+	switch c.Protocol {
+	case "", ProtocolNative, ProtocolHTTP:
+	default:
+		return fmt.Errorf("unsupported clickhouse protocol %q", c.Protocol)
+	}
+	if len(c.HTTPHeaders) > 0 && !c.UsesHTTP() {
+		return fmt.Errorf("clickhouse http_headers need protocol http")
+	}
+	if c.CompressionLevel < 0 || c.CompressionStatsInterval < 0 {
+		return fmt.Errorf("clickhouse compression level and stats interval cannot be negative")
+	}
+	switch c.Compression {
+	case "", CompressionZSTD, CompressionLZ4, CompressionNone:
+	case CompressionGzip, CompressionDeflate, CompressionBrotli:
+		if !c.UsesHTTP() {
+			return fmt.Errorf("clickhouse compression %s needs protocol http", c.Compression)
+		}
+		maxLevel := 9
+		if c.Compression == CompressionBrotli {
+			maxLevel = 11
+		}
+		if c.CompressionLevel > maxLevel {
+			return fmt.Errorf("clickhouse compression level of %s must be at most %d", c.Compression, maxLevel)
+		}
+	default:
+		return fmt.Errorf("unsupported clickhouse compression %q", c.Compression)
+	}
 	return nil
 }
 
-// applyEnvOverrides applies environment variable overrides
-func applyEnvOverrides(config *Config) {
-	if val := os.Getenv("CLICKHOUSE_HOST"); val != "" {
-		config.ClickHouse.Addresses = []string{val}
+func (w *Watchdog) validate() error {
+	if w.Interval < 0 {
+		return fmt.Errorf("clickhouse watchdog interval cannot be negative")
 	}
-	if val := os.Getenv("CLICKHOUSE_DATABASE"); val != "" {
-		config.ClickHouse.Database = val
+	if w.Interval > 0 && (w.Timeout <= 0 || w.FailureThreshold <= 0) {
+		return fmt.Errorf("clickhouse watchdog timeout and failure threshold must be positive")
 	}
-	if val := os.Getenv("CLICKHOUSE_USERNAME"); val != "" {
-		config.ClickHouse.Username = val
+	return nil
+}
+
+// clusterNamePattern restricts cluster names, which are embedded in DDL
+var clusterNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+func (c *ClusterConfig) validate() error {
+	if c.HealthCheckInterval < 0 {
+		return fmt.Errorf("clickhouse cluster health check interval cannot be negative")
 	}
-	if val := os.Getenv("CLICKHOUSE_PASSWORD"); val != "" {
-		config.ClickHouse.Password = val
+	if c.Name != "" && !clusterNamePattern.MatchString(c.Name) {
+		return fmt.Errorf("clickhouse cluster: invalid name %q", c.Name)
 	}
-	if val := os.Getenv("LOG_LEVEL"); val != "" {
-		config.Monitoring.LogLevel = val
+	switch c.InsertMode {
+	case "", InsertDistributed:
+	case InsertLocal:
+		if c.Name == "" {
+			return fmt.Errorf("clickhouse cluster: insert mode local needs a cluster name")
+		}
+	default:
+		return fmt.Errorf("unsupported clickhouse cluster insert mode %q", c.InsertMode)
 	}
-	if val := os.Getenv("OTLP_GRPC_PORT"); val != "" {
-		fmt.Sscanf(val, "%d", &config.OTLP.GRPCPort)
+	return nil
+}
+
+func (s *S3Config) validate() error {
+	if !s.Enabled() {
+		return nil
 	}
-	if val := os.Getenv("OTLP_HTTP_PORT"); val != "" {
-		fmt.Sscanf(val, "%d", &config.OTLP.HTTPPort)
+	u, err := url.Parse(s.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("export s3 endpoint %q must be an http or https URL", s.Endpoint)
 	}
+	if s.Region == "" || s.Bucket == "" {
+		return fmt.Errorf("export s3 region and bucket cannot be empty")
+	}
+	if s.AccessKeyID == "" || s.SecretAccessKey == "" {
+		return fmt.Errorf("export s3 access_key_id and secret_access_key cannot be empty")
+	}
+	return nil
+}
+
+// AuthScopes are the scopes routes can require. admin grants every scope.
+var AuthScopes = []string{"read:traces", "read:metrics", "read:logs", "admin"}
+
+func (a *AuthConfig) validate() error {
+	if !a.Enabled {
+		return nil
+	}
+	if len(a.APIKeys) == 0 && a.OIDC.IssuerURL == "" {
+		return fmt.Errorf("auth needs api_keys or an oidc issuer_url")
+	}
+	for role, scopes := range a.Roles {
+		if err := validateScopes("auth role "+role, scopes); err != nil {
+			return err
+		}
+	}
+	names := make(map[string]bool, len(a.APIKeys))
+	for _, key := range a.APIKeys {
+		if key.Name == "" {
+			return fmt.Errorf("auth api key name cannot be empty")
+		}
+		if names[key.Name] {
+			return fmt.Errorf("duplicate auth api key %q", key.Name)
+		}
+		names[key.Name] = true
+		if (key.Key == "") == (key.KeySHA256 == "") {
+			return fmt.Errorf("auth api key %q needs exactly one of key, key_file and key_sha256", key.Name)
+		}
+		if digest, err := hex.DecodeString(key.KeySHA256); key.KeySHA256 != "" && (err != nil || len(digest) != sha256.Size) {
+			return fmt.Errorf("auth api key %q key_sha256 must be 64 hex digits", key.Name)
+		}
+		if err := validateScopes("auth api key "+key.Name, key.Scopes); err != nil {
+			return err
+		}
+		if err := a.validateRoles("auth api key "+key.Name, key.Roles); err != nil {
+			return err
+		}
+	}
+	if a.OIDC.IssuerURL != "" {
+		if u, err := url.Parse(a.OIDC.IssuerURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return fmt.Errorf("auth oidc issuer_url %q must be an http(s) URL", a.OIDC.IssuerURL)
+		}
+		if a.OIDC.JWKSURL != "" {
+			if u, err := url.Parse(a.OIDC.JWKSURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+				return fmt.Errorf("auth oidc jwks_url %q must be an http(s) URL", a.OIDC.JWKSURL)
+			}
+		}
+		if a.OIDC.RefreshInterval <= 0 {
+			return fmt.Errorf("auth oidc refresh_interval must be positive")
+		}
+		if a.OIDC.ClockSkew < 0 {
+			return fmt.Errorf("auth oidc clock_skew cannot be negative")
+		}
+	}
+	for route, scopes := range a.RouteScopes {
+		if err := validateScopes("auth route "+route, scopes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *AuthConfig) validateRoles(owner string, roles []string) error {
+	for _, role := range roles {
+		if _, ok := a.Roles[role]; !ok {
+			return fmt.Errorf("%s: unknown role %q", owner, role)
+		}
+	}
+	return nil
+}
+
+func validateScopes(owner string, scopes []string) error {
+	for _, scope := range scopes {
+		if !slices.Contains(AuthScopes, scope) {
+			return fmt.Errorf("%s: unknown scope %q (valid scopes: %s)", owner, scope, strings.Join(AuthScopes, ", "))
+		}
+	}
+	return nil
+}
+
+// validatePipelines checks the pipeline names and that every component a
+// pipeline references exists and is configured. OTLP is always served, so
+// one pipeline has to receive it.
+func (c *Config) validatePipelines() error {
+	if len(c.Pipelines) == 0 {
+		return nil
+	}
+	otlp := false
+	for _, name := range c.Pipelines.Names() {
+		p := c.Pipelines[name]
+		switch PipelineSignal(name) {
+		case "traces", "metrics", "logs":
+		default:
+			return fmt.Errorf("pipeline %q: name must start with traces, metrics or logs", name)
+		}
+		if strings.HasSuffix(name, "/") {
+			return fmt.Errorf("pipeline %q: name has an empty suffix", name)
+		}
+		if len(p.Receivers) == 0 {
+			return fmt.Errorf("pipeline %s has no receivers", name)
+		}
+		if len(p.Exporters) == 0 {
+			return fmt.Errorf("pipeline %s has no exporters", name)
+		}
+		for _, ref := range []struct {
+			kind  string
+			names []string
+			known []string
+		}{
+			{"receiver", p.Receivers, PipelineReceivers},
+			{"processor", p.Processors, PipelineProcessors},
+			{"exporter", p.Exporters, PipelineExporters},
+		} {
+			seen := make(map[string]bool)
+			for _, component := range ref.names {
+				if !slices.Contains(ref.known, component) {
+					return fmt.Errorf("pipeline %s: unknown %s %q", name, ref.kind, component)
+				}
+				if seen[component] {
+					return fmt.Errorf("pipeline %s: %s %s listed twice", name, ref.kind, component)
+				}
+				seen[component] = true
+				if component == "kafka" && !c.Kafka.Enabled {
+					return fmt.Errorf("pipeline %s: %s kafka needs kafka.enabled", name, ref.kind)
+				}
+			}
+		}
+		otlp = otlp || slices.Contains(p.Receivers, "otlp")
+	}
+	if !otlp {
+		return fmt.Errorf("no pipeline has the otlp receiver")
+	}
+	return nil
+}
+
+func (a *AuditConfig) validate() error {
+	if !a.Enabled {
+		return nil
+	}
+	if a.FlushInterval <= 0 {
+		return fmt.Errorf("audit flush_interval must be positive")
+	}
+	if a.BatchSize <= 0 || a.QueueSize < a.BatchSize {
+		return fmt.Errorf("audit batch_size must be positive and at most queue_size")
+	}
+	return nil
+}
+
+func (c *CaptureConfig) validate() error {
+	if c.Directory == "" {
+		return nil
+	}
+	if c.SampleRatio <= 0 || c.SampleRatio > 1 {
+		return fmt.Errorf("capture sample_ratio must be above 0 and at most 1")
+	}
+	if c.MaxDuration <= 0 || c.MaxRequests <= 0 || c.MaxSizeMiB <= 0 {
+		return fmt.Errorf("capture max_duration, max_requests and max_size_mib must be positive")
+	}
+	return nil
+}
+
+func (c *CORSConfig) validate() error {
+	for _, origin := range c.AllowedOrigins {
+		if origin == "*" {
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || (u.Path != "" && u.Path != "/") {
+			return fmt.Errorf("cors allowed origin %q must be \"*\" or a scheme and host such as https://dash.example.com", origin)
+		}
+	}
+	if c.MaxAge < 0 {
+		return fmt.Errorf("cors max age cannot be negative")
+	}
+	return nil
+}
+
+func (q *QueryCacheConfig) validate() error {
+	if !q.Enabled {
+		return nil
+	}
+	if q.RedisAddress == "" && q.MaxSizeMiB <= 0 {
+		return fmt.Errorf("query cache max_size_mib must be positive")
+	}
+	if q.RedisAddress != "" {
+		if _, _, err := net.SplitHostPort(q.RedisAddress); err != nil {
+			return fmt.Errorf("query cache redis address: %w", err)
+		}
+	}
+	if q.RedisDB < 0 {
+		return fmt.Errorf("query cache redis db cannot be negative")
+	}
+	return nil
+}
+
+func (s *SpanNamesConfig) validate() error {
+	for i, r := range s.Rules {
+		if r.Pattern == "" {
+			return fmt.Errorf("span name rule %d: pattern cannot be empty", i)
+		}
+		if _, err := regexp.Compile(r.Pattern); err != nil {
+			return fmt.Errorf("span name rule %d: invalid pattern: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func (s *SpanMetricsConfig) validate() error {
+	if !s.Enabled {
+		return nil
+	}
+	if s.FlushInterval <= 0 {
+		return fmt.Errorf("span metrics flush interval must be positive")
+	}
+	if s.Namespace == "" {
+		return fmt.Errorf("span metrics namespace cannot be empty")
+	}
+	for i, b := range s.Buckets {
+		if b <= 0 || (i > 0 && b <= s.Buckets[i-1]) {
+			return fmt.Errorf("span metrics buckets must be positive and increasing")
+		}
+	}
+	return nil
+}
+
+func (r *RoutingConfig) validate() error {
+	if !r.Enabled {
+		return nil
+	}
+	if len(r.Peers) == 0 && r.Kubernetes.Service == "" {
+		return fmt.Errorf("routing needs peers or a kubernetes service")
+	}
+	for _, addr := range append([]string{r.Self}, r.Peers...) {
+		if addr == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return fmt.Errorf("routing peer %q: %w", addr, err)
+		}
+	}
+	if r.RefreshInterval < 0 || r.VirtualNodes < 0 {
+		return fmt.Errorf("routing refresh interval and virtual nodes cannot be negative")
+	}
+	if r.Kubernetes.Port < 0 || r.Kubernetes.Port > 65535 {
+		return fmt.Errorf("routing kubernetes port must be between 0 and 65535")
+	}
+	if r.Kubernetes.APIServer != "" {
+		u, err := url.Parse(r.Kubernetes.APIServer)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("routing kubernetes api_server must be an http or https URL")
+		}
+	}
+	return nil
+}
+
+func (k *K8sAttributesConfig) validate() error {
+	if !k.Enabled || k.APIServer == "" {
+		return nil
+	}
+	u, err := url.Parse(k.APIServer)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("k8s_attributes api_server must be an http or https URL")
+	}
+	return nil
+}
+
+func (q *QuotasConfig) validate() error {
+	if !q.Enabled {
+		return nil
+	}
+	if q.FlushInterval <= 0 {
+		return fmt.Errorf("quotas flush interval must be positive")
+	}
+	for i, l := range q.Limits {
+		if (l.ServiceName == "") == (l.ServiceNamespace == "") {
+			return fmt.Errorf("quota %d: set exactly one of service_name and service_namespace", i)
+		}
+		if l.Period != QuotaDaily && l.Period != QuotaMonthly {
+			return fmt.Errorf("quota %d: period must be daily or monthly", i)
+		}
+		if l.MaxBytes < 0 || l.MaxItems < 0 {
+			return fmt.Errorf("quota %d: limits cannot be negative", i)
+		}
+		if l.MaxBytes == 0 && l.MaxItems == 0 {
+			return fmt.Errorf("quota %d: set max_bytes or max_items", i)
+		}
+	}
+	return nil
+}
+
+func (e *EnrichmentConfig) validate() error {
+	if (e.GeoIP.Database == "") != (len(e.GeoIP.Attributes) == 0) {
+		return fmt.Errorf("geoip enrichment needs both a database and attributes")
+	}
+	for _, attrs := range [][]EnrichmentAttribute{e.GeoIP.Attributes, e.UserAgent} {
+		for _, a := range attrs {
+			if a.Attribute == "" {
+				return fmt.Errorf("enrichment attribute cannot be empty")
+			}
+		}
+	}
+	return nil
+}
+
+func (l *LogMetricsConfig) validate() error {
+	if len(l.Rules) == 0 {
+		return nil
+	}
+	if l.FlushInterval <= 0 {
+		return fmt.Errorf("log metrics flush interval must be positive")
+	}
+	names := make(map[string]bool)
+	for i, r := range l.Rules {
+		if r.Name == "" {
+			return fmt.Errorf("log metric rule %d: name cannot be empty", i)
+		}
+		if names[r.Name] {
+			return fmt.Errorf("log metric rule %q: duplicate name", r.Name)
+		}
+		names[r.Name] = true
+		if r.MinSeverity != "" {
+			if _, ok := models.ParseSeverityText(r.MinSeverity); !ok {
+				return fmt.Errorf("log metric rule %q: unknown severity %q", r.Name, r.MinSeverity)
+			}
+		}
+		if r.BodyRegex != "" {
+			if _, err := regexp.Compile(r.BodyRegex); err != nil {
+				return fmt.Errorf("log metric rule %q: invalid body_regex: %w", r.Name, err)
+			}
+		}
+		switch r.Aggregation {
+		case "", "avg", "sum", "min", "max":
+		default:
+			return fmt.Errorf("log metric rule %q: unsupported aggregation %q", r.Name, r.Aggregation)
+		}
+	}
+	return nil
+}
+
+// ValidateOTLPEndpoint checks an OTLP/gRPC endpoint given as host:port or
+// as an http or https URL. An empty endpoint is valid.
+func ValidateOTLPEndpoint(endpoint string) error {
+	if endpoint == "" {
+		return nil
+	}
+	if strings.Contains(endpoint, "://") {
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return err
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return fmt.Errorf("unsupported scheme %q", u.Scheme)
+		}
+		if u.Host == "" {
+			return fmt.Errorf("%q has no host", endpoint)
+		}
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(endpoint); err != nil {
+		return err
+	}
+	return nil
 }
 
 // DefaultConfig returns a configuration with default values
@@ -150,24 +1702,58 @@ func DefaultConfig() *Config {
 			ReadTimeout:     30 * time.Second,
 			WriteTimeout:    30 * time.Second,
 			ShutdownTimeout: 30 * time.Second,
+			DrainTimeout:    30 * time.Second,
+			IdleTimeout:     2 * time.Minute,
+			MaxHeaderBytes:  1 << 20,
+			CORS: CORSConfig{
+				AllowedMethods: []string{"GET", "POST", "DELETE"},
+				AllowedHeaders: []string{"Content-Type", "Authorization"},
+				MaxAge:         10 * time.Minute,
+			},
 		},
 		ClickHouse: ClickHouseConfig{
-			Addresses:       []string{"localhost:9000"},
-			Database:        "otel",
-			Username:        "default",
-			Password:        "",
-			MaxOpenConns:    50,
-			MaxIdleConns:    5,
-			ConnMaxLifetime: 1 * time.Hour,
-			DialTimeout:     10 * time.Second,
-			Compression:     "zstd",
+			Addresses:         []string{"localhost:9000"},
+			DiscoveryInterval: 30 * time.Second,
+			Database:          "otel",
+			Username:          "default",
+			Password:          "",
+			MaxOpenConns:      50,
+			MaxIdleConns:      5,
+			ConnMaxLifetime:   1 * time.Hour,
+			DialTimeout:       10 * time.Second,
+			Compression:       "zstd",
+			TimestampMode:     TimestampCompat,
+			QueryProfiles: QueryProfiles{
+				Interactive: QueryProfile{MaxExecutionTime: 60 * time.Second},
+				Background:  QueryProfile{MaxExecutionTime: 30 * time.Minute},
+				Export:      QueryProfile{MaxExecutionTime: 30 * time.Minute},
+			},
+			QueryBudget: QueryBudget{Action: "reject"},
+			QueryGate: QueryGate{
+				MaxConcurrent:  32,
+				MaxQueued:      64,
+				QueueTimeout:   5 * time.Second,
+				DefaultTimeout: 30 * time.Second,
+			},
+			QueryLimits: QueryLimits{Action: "reject"},
+			Cluster: ClusterConfig{
+				InsertMode:          InsertDistributed,
+				HealthCheckInterval: 30 * time.Second,
+			},
+			InsertDeduplication: InsertDeduplication{Window: 1000},
+			Watchdog: Watchdog{
+				Interval:         10 * time.Second,
+				Timeout:          5 * time.Second,
+				FailureThreshold: 3,
+			},
 		},
 		OTLP: OTLPConfig{
-			GRPCPort:         4317,
-			HTTPPort:         4318,
-			EnableGRPC:       true,
-			EnableHTTP:       true,
-			MaxRecvMsgSizeMB: 4,
+			GRPCPort:             4317,
+			HTTPPort:             4318,
+			EnableGRPC:           true,
+			EnableHTTP:           true,
+			MaxRecvMsgSizeMB:     4,
+			MaxConcurrentExports: 256,
 		},
 		Monitoring: MonitoringConfig{
 			MetricsPort:     9090,
@@ -177,6 +1763,8 @@ func DefaultConfig() *Config {
 			HealthCheckPath: "/health",
 			ReadyCheckPath:  "/ready",
 			TraceSampleRate: 0.1,
+			OTLPEndpoint:    "localhost:4317",
+			OTLPInsecure:    true,
 		},
 		Performance: PerformanceConfig{
 			BatchSize:            10000,
@@ -191,5 +1779,101 @@ func DefaultConfig() *Config {
 			RetryMaxInterval:     30 * time.Second,
 			CacheTTL:             15 * time.Minute,
 		},
+		Kafka: KafkaConfig{
+			Enabled:         false,
+			TopicPrefix:     "otel",
+			ConsumerGroup:   "otel-collector",
+			Mode:            "both",
+			Compression:     "zstd",
+			MaxMessageBytes: 1024 * 1024,
+		},
+		Retention: RetentionConfig{
+			Traces:    30 * 24 * time.Hour,
+			Logs:      30 * 24 * time.Hour,
+			Metrics:   30 * 24 * time.Hour,
+			Metrics5m: 90 * 24 * time.Hour,
+			Rollups:   365 * 24 * time.Hour,
+		},
+		Sampling: SamplingConfig{
+			DecisionCacheSize: 10000,
+			DecisionCacheTTL:  10 * time.Minute,
+		},
+		Attributes: AttributesConfig{
+			FlattenLogs: FlattenConfig{
+				MaxDepth:       5,
+				MaxArrayLength: 32,
+				MaxValueLength: 4096,
+			},
+		},
+		LogMetrics: LogMetricsConfig{
+			FlushInterval: time.Minute,
+		},
+		Quotas: QuotasConfig{
+			FlushInterval: time.Minute,
+		},
+		SpanNames: SpanNamesConfig{
+			RawAttribute: "span.name.original",
+		},
+		SpanMetrics: SpanMetricsConfig{
+			Enabled:       false,
+			FlushInterval: time.Minute,
+			Namespace:     "traces.span.metrics",
+			Buckets: []time.Duration{
+				2 * time.Millisecond, 4 * time.Millisecond, 6 * time.Millisecond, 8 * time.Millisecond,
+				10 * time.Millisecond, 50 * time.Millisecond, 100 * time.Millisecond, 200 * time.Millisecond,
+				400 * time.Millisecond, 800 * time.Millisecond, time.Second, 1400 * time.Millisecond,
+				2 * time.Second, 5 * time.Second, 10 * time.Second, 15 * time.Second,
+			},
+		},
+		Rollups: RollupsConfig{
+			Manage:      true,
+			LagInterval: time.Minute,
+		},
+		Anomalies: AnomaliesConfig{
+			Interval:    time.Minute,
+			Window:      5 * time.Minute,
+			Alpha:       0.1,
+			Threshold:   3,
+			Warmup:      30,
+			MinRequests: 20,
+		},
+		SLO: SLOConfig{
+			Interval:    5 * time.Minute,
+			BurnWindows: []time.Duration{5 * time.Minute, 30 * time.Minute, time.Hour, 6 * time.Hour, 72 * time.Hour},
+		},
+		QueryCache: QueryCacheConfig{
+			MaxSizeMiB: 64,
+		},
+		Auth: AuthConfig{
+			OIDC: OIDCConfig{
+				ScopesClaim:     "scope",
+				RolesClaim:      "roles",
+				RefreshInterval: time.Hour,
+				ClockSkew:       time.Minute,
+			},
+		},
+		Audit: AuditConfig{
+			FlushInterval: 5 * time.Second,
+			BatchSize:     1000,
+			QueueSize:     10000,
+		},
+		Capture: CaptureConfig{
+			SampleRatio: 0.1,
+			MaxDuration: 10 * time.Minute,
+			MaxRequests: 100000,
+			MaxSizeMiB:  1024,
+			KeepAttributes: []string{
+				"service.name", "service.namespace", "service.version", "deployment.environment",
+				"telemetry.sdk.name", "telemetry.sdk.language", "telemetry.sdk.version",
+				"http.method", "http.request.method", "http.route", "rpc.system", "rpc.service", "rpc.method",
+				"db.system", "messaging.system", "otel.status_code",
+			},
+		},
+		DiskQueue: DiskQueueConfig{
+			Enabled:        false,
+			Directory:      "/var/lib/otel-collector/queue",
+			MaxSizeMiB:     1024,
+			SegmentSizeMiB: 64,
+		},
 	}
 }
\ No newline at end of file
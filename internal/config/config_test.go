@@ -2,8 +2,12 @@ package config
 
 import (
 	"os"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
+
+	"gopkg.in/yaml.v3"
 )
 
 func TestDefaultConfig(t *testing.T) {
@@ -121,6 +125,1008 @@ func TestValidateConfig(t *testing.T) {
 	}
 }
 
+func TestValidateExporters(t *testing.T) {
+	tests := []struct {
+		name      string
+		exporters []ExporterConfig
+		wantErr   bool
+	}{
+		{
+			name:      "valid grpc exporter",
+			exporters: []ExporterConfig{{Name: "vendor", Endpoint: "otel.example.com:4317"}},
+			wantErr:   false,
+		},
+		{
+			name:      "valid http exporter with signals",
+			exporters: []ExporterConfig{{Name: "dr", Endpoint: "http://dr:4318", Protocol: "http", Signals: []string{"traces", "logs"}}},
+			wantErr:   false,
+		},
+		{
+			name:      "missing name",
+			exporters: []ExporterConfig{{Endpoint: "otel.example.com:4317"}},
+			wantErr:   true,
+		},
+		{
+			name:      "duplicate name",
+			exporters: []ExporterConfig{{Name: "a", Endpoint: "x:4317"}, {Name: "a", Endpoint: "y:4317"}},
+			wantErr:   true,
+		},
+		{
+			name:      "missing endpoint",
+			exporters: []ExporterConfig{{Name: "vendor"}},
+			wantErr:   true,
+		},
+		{
+			name:      "unknown protocol",
+			exporters: []ExporterConfig{{Name: "vendor", Endpoint: "x:4317", Protocol: "udp"}},
+			wantErr:   true,
+		},
+		{
+			name:      "unknown signal",
+			exporters: []ExporterConfig{{Name: "vendor", Endpoint: "x:4317", Signals: []string{"profiles"}}},
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.Exporters = tt.exporters
+			err := cfg.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateKafka(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Kafka.Enabled = true
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for kafka without brokers")
+	}
+
+	cfg.Kafka.Brokers = []string{"localhost:9092"}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	cfg.Kafka.Mode = "mirror"
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for unknown kafka mode")
+	}
+}
+
+func TestValidatePipelines(t *testing.T) {
+	var cfg Config
+	data := `
+pipelines:
+  traces:
+    receivers: [otlp]
+    exporters: [clickhouse]
+  traces/archive:
+    receivers: [otlp]
+    exporters: [kafka]
+  logs:
+    receivers: [otlp, kafka]
+    exporters: [memory]
+`
+	if err := yaml.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got := cfg.Pipelines.Names(); !reflect.DeepEqual(got, []string{"logs", "traces", "traces/archive"}) {
+		t.Errorf("Names() = %v", got)
+	}
+	if got := PipelineSignal("traces/archive"); got != "traces" {
+		t.Errorf("PipelineSignal() = %q", got)
+	}
+
+	valid := DefaultConfig()
+	valid.Pipelines = cfg.Pipelines
+	valid.Kafka = KafkaConfig{Enabled: true, Brokers: []string{"localhost:9092"}}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		pipelines PipelinesConfig
+		kafka     bool
+		want      string
+	}{
+		{"signal", PipelinesConfig{"profiles": {Receivers: []string{"otlp"}, Exporters: []string{"clickhouse"}}}, false, "must start with traces, metrics or logs"},
+		{"empty suffix", PipelinesConfig{"traces/": {Receivers: []string{"otlp"}, Exporters: []string{"clickhouse"}}}, false, "empty suffix"},
+		{"no receivers", PipelinesConfig{"traces": {Exporters: []string{"clickhouse"}}}, false, "has no receivers"},
+		{"no exporters", PipelinesConfig{"traces": {Receivers: []string{"otlp"}}}, false, "has no exporters"},
+		{"unknown receiver", PipelinesConfig{"traces": {Receivers: []string{"zipkin"}, Exporters: []string{"clickhouse"}}}, false, `unknown receiver "zipkin"`},
+		{"unknown processor", PipelinesConfig{"traces": {Receivers: []string{"otlp"}, Processors: []string{"redact"}, Exporters: []string{"clickhouse"}}}, false, `unknown processor "redact"`},
+		{"unknown exporter", PipelinesConfig{"traces": {Receivers: []string{"otlp"}, Exporters: []string{"s3"}}}, false, `unknown exporter "s3"`},
+		{"twice", PipelinesConfig{"traces": {Receivers: []string{"otlp"}, Exporters: []string{"clickhouse", "clickhouse"}}}, false, "exporter clickhouse listed twice"},
+		{"kafka disabled", PipelinesConfig{"traces": {Receivers: []string{"otlp"}, Exporters: []string{"kafka"}}}, false, "exporter kafka needs kafka.enabled"},
+		{"no otlp", PipelinesConfig{"traces": {Receivers: []string{"kafka"}, Exporters: []string{"clickhouse"}}}, true, "no pipeline has the otlp receiver"},
+	}
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		cfg.Pipelines = tt.pipelines
+		if tt.kafka {
+			cfg.Kafka = KafkaConfig{Enabled: true, Brokers: []string{"localhost:9092"}}
+		}
+		if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestValidateDiskQueue(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.DiskQueue.Enabled = true
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	cfg.DiskQueue.Directory = ""
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for disk queue without directory")
+	}
+}
+
+func TestValidateCapture(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Capture.Directory = "/var/lib/otel-collector/captures"
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	for name, mutate := range map[string]func(*CaptureConfig){
+		"zero sample ratio":  func(c *CaptureConfig) { c.SampleRatio = 0 },
+		"sample ratio above": func(c *CaptureConfig) { c.SampleRatio = 1.5 },
+		"zero duration":      func(c *CaptureConfig) { c.MaxDuration = 0 },
+		"zero requests":      func(c *CaptureConfig) { c.MaxRequests = 0 },
+		"zero size":          func(c *CaptureConfig) { c.MaxSizeMiB = 0 },
+	} {
+		cfg := DefaultConfig()
+		cfg.Capture.Directory = "/var/lib/otel-collector/captures"
+		mutate(&cfg.Capture)
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+		cfg.Capture.Directory = ""
+		if err := cfg.Validate(); err != nil {
+			t.Errorf("%s: disabled captures are not checked: %v", name, err)
+		}
+	}
+}
+
+func TestValidateAttributes(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Attributes.MaxHotAttributes = -1
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for negative max hot attributes")
+	}
+
+	cfg = DefaultConfig()
+	cfg.Attributes.FlattenLogs.MaxDepth = 0
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("disabled flattening should not be checked: %v", err)
+	}
+	cfg.Attributes.FlattenLogs.Enabled = true
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for a zero flattening depth")
+	}
+}
+
+func TestValidateK8sAttributes(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.K8sAttributes = K8sAttributesConfig{Enabled: true}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("in-cluster enrichment should be valid: %v", err)
+	}
+	cfg.K8sAttributes.APIServer = "http://localhost:8001"
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("API server URL should be valid: %v", err)
+	}
+	cfg.K8sAttributes.APIServer = "localhost:8001"
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for an API server without a scheme")
+	}
+}
+
+func TestValidateRouting(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Routing = RoutingConfig{Enabled: true}
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for routing without peers")
+	}
+	cfg.Routing.Peers = []string{"collector-0.collector:4317", "collector-1.collector:4317"}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("static peers should be valid: %v", err)
+	}
+	cfg.Routing = RoutingConfig{Enabled: true, Kubernetes: RoutingKubernetesConfig{Service: "otel-collector-peers"}}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("kubernetes discovery should be valid: %v", err)
+	}
+
+	invalid := []RoutingConfig{
+		{Enabled: true, Peers: []string{"collector-0"}},
+		{Enabled: true, Peers: []string{"a:4317"}, Self: "b"},
+		{Enabled: true, Peers: []string{"a:4317"}, VirtualNodes: -1},
+		{Enabled: true, Kubernetes: RoutingKubernetesConfig{Service: "s", APIServer: "localhost:8001"}},
+	}
+	for _, r := range invalid {
+		cfg.Routing = r
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("expected error for %+v", r)
+		}
+	}
+}
+
+func TestValidateEnrichment(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Enrichment.UserAgent = []EnrichmentAttribute{{Attribute: "http.user_agent", Prefix: "user_agent"}}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("user agent enrichment should be valid: %v", err)
+	}
+	cfg.Enrichment.GeoIP.Database = "/data/GeoLite2-City.mmdb"
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for a geoip database without attributes")
+	}
+	cfg.Enrichment.GeoIP.Attributes = []EnrichmentAttribute{{}}
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for an empty attribute")
+	}
+}
+
+func TestValidateQuotas(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Quotas.Enabled = true
+	cfg.Quotas.Limits = []Quota{
+		{ServiceName: "checkout", Period: QuotaDaily, MaxBytes: 10 << 30},
+		{ServiceNamespace: "team-a", Period: QuotaMonthly, MaxItems: 1e9},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("valid quotas rejected: %v", err)
+	}
+	invalid := map[string]Quota{
+		"no target":    {Period: QuotaDaily, MaxBytes: 1},
+		"two targets":  {ServiceName: "a", ServiceNamespace: "b", Period: QuotaDaily, MaxBytes: 1},
+		"bad period":   {ServiceName: "a", Period: "weekly", MaxBytes: 1},
+		"no limit":     {ServiceName: "a", Period: QuotaDaily},
+		"negative max": {ServiceName: "a", Period: QuotaDaily, MaxBytes: 1, MaxItems: -1},
+	}
+	for name, q := range invalid {
+		cfg.Quotas.Limits = []Quota{q}
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestQuotaPeriodStart(t *testing.T) {
+	at := time.Date(2024, 3, 15, 23, 30, 0, 0, time.FixedZone("PDT", -7*3600))
+	daily := Quota{Period: QuotaDaily}
+	if got := daily.PeriodStart(at); !got.Equal(time.Date(2024, 3, 16, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("daily PeriodStart() = %v", got)
+	}
+	monthly := Quota{Period: QuotaMonthly}
+	if got := monthly.PeriodStart(at); !got.Equal(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("monthly PeriodStart() = %v", got)
+	}
+}
+
+func TestValidateRetention(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Retention.Tenants = []TenantRetention{{Namespace: "team-a", Traces: 7 * 24 * time.Hour}}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	cfg.Retention.Tenants = append(cfg.Retention.Tenants, TenantRetention{Namespace: "team-a"})
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for duplicate tenant namespace")
+	}
+
+	cfg.Retention.Tenants = []TenantRetention{{Namespace: "a' OR 1=1"}}
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for unsafe tenant namespace")
+	}
+
+	cfg.Retention.Tenants = nil
+	cfg.Retention.Logs = -time.Hour
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for negative retention")
+	}
+}
+
+func TestValidateTiering(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Retention.Tiering = TieringConfig{Volume: "cold", StoragePolicy: "tiered", Traces: 72 * time.Hour}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if !cfg.Retention.Tiering.Enabled() {
+		t.Error("tiering with a volume and an age should be enabled")
+	}
+
+	tests := []struct {
+		name    string
+		tiering TieringConfig
+	}{
+		{"age without volume", TieringConfig{Logs: time.Hour}},
+		{"negative age", TieringConfig{Volume: "cold", Traces: -time.Hour}},
+		{"unsafe volume", TieringConfig{Volume: "cold'", Traces: time.Hour}},
+		{"unsafe storage policy", TieringConfig{Volume: "cold", StoragePolicy: "a b", Traces: time.Hour}},
+		{"move after retention", TieringConfig{Volume: "cold", Logs: cfg.Retention.Logs}},
+	}
+	for _, tt := range tests {
+		cfg.Retention.Tiering = tt.tiering
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("%s: expected error", tt.name)
+		}
+	}
+}
+
+func TestValidateExportS3(t *testing.T) {
+	valid := S3Config{
+		Endpoint:        "https://s3.us-east-1.amazonaws.com",
+		Region:          "us-east-1",
+		Bucket:          "telemetry-exports",
+		AccessKeyID:     "AKIAEXAMPLE",
+		SecretAccessKey: "secret",
+	}
+	cfg := DefaultConfig()
+	cfg.Export.S3 = valid
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*S3Config)
+	}{
+		{"endpoint without scheme", func(s *S3Config) { s.Endpoint = "s3.amazonaws.com" }},
+		{"missing bucket", func(s *S3Config) { s.Bucket = "" }},
+		{"missing region", func(s *S3Config) { s.Region = "" }},
+		{"missing secret", func(s *S3Config) { s.SecretAccessKey = "" }},
+	}
+	for _, tt := range tests {
+		s := valid
+		tt.modify(&s)
+		cfg.Export.S3 = s
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("%s: expected error", tt.name)
+		}
+	}
+}
+
+func TestValidateCluster(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.ClickHouse.Cluster.Name = "otel_cluster"
+	cfg.ClickHouse.Cluster.InsertMode = InsertLocal
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*ClusterConfig)
+	}{
+		{"quote in name", func(c *ClusterConfig) { c.Name = "otel'; DROP" }},
+		{"local without name", func(c *ClusterConfig) { c.Name = "" }},
+		{"unknown insert mode", func(c *ClusterConfig) { c.InsertMode = "random" }},
+		{"negative interval", func(c *ClusterConfig) { c.HealthCheckInterval = -time.Second }},
+	}
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		cfg.ClickHouse.Cluster = ClusterConfig{Name: "otel_cluster", InsertMode: InsertLocal}
+		tt.modify(&cfg.ClickHouse.Cluster)
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("%s: expected error", tt.name)
+		}
+	}
+}
+
+func TestValidateWatchdog(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.ClickHouse.Watchdog = Watchdog{}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("disabled watchdog should be valid: %v", err)
+	}
+	cfg.ClickHouse.Watchdog = Watchdog{Interval: 10 * time.Second, Timeout: 5 * time.Second}
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for a zero failure threshold")
+	}
+	cfg.ClickHouse.Watchdog = Watchdog{Interval: -time.Second}
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for a negative interval")
+	}
+}
+
+func TestValidateCompression(t *testing.T) {
+	cfg := DefaultConfig()
+	for _, method := range []string{"", "zstd", "lz4", "none"} {
+		cfg.ClickHouse.Compression = method
+		if err := cfg.Validate(); err != nil {
+			t.Errorf("compression %q should be valid: %v", method, err)
+		}
+	}
+	cfg.ClickHouse.Compression = "snappy"
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for an unsupported compression method")
+	}
+	cfg.ClickHouse.Compression = "zstd"
+	cfg.ClickHouse.CompressionLevel = -1
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for a negative compression level")
+	}
+}
+
+func TestValidateProtocol(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.ClickHouse.Protocol = "grpc"
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for an unsupported protocol")
+	}
+	cfg.ClickHouse.Protocol = ProtocolNative
+	cfg.ClickHouse.HTTPHeaders = map[string]string{"X-Proxy-Token": "token"}
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for http headers on the native protocol")
+	}
+	cfg.ClickHouse.HTTPHeaders = nil
+	cfg.ClickHouse.Compression = CompressionGzip
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for gzip on the native protocol")
+	}
+
+	cfg.ClickHouse.Protocol = ProtocolHTTP
+	cfg.ClickHouse.HTTPHeaders = map[string]string{"X-Proxy-Token": "token"}
+	cfg.ClickHouse.Settings = map[string]string{"async_insert": "1"}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("gzip over http should be valid: %v", err)
+	}
+	cfg.ClickHouse.CompressionLevel = 10
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for gzip level 10")
+	}
+	cfg.ClickHouse.Compression = CompressionBrotli
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("brotli level 10 should be valid: %v", err)
+	}
+}
+
+func TestValidateInsertDeduplication(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.ClickHouse.InsertDeduplication.Enabled = true
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("default deduplication window should be valid: %v", err)
+	}
+	cfg.ClickHouse.InsertDeduplication.Window = 0
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for a zero deduplication window")
+	}
+}
+
+func TestPerformanceForSignal(t *testing.T) {
+	perf := DefaultConfig().Performance
+	perf.Signals.Logs = SignalTuning{WorkerCount: 16, QueueSize: 500000, BatchTimeout: 2 * time.Second}
+
+	logs := perf.ForSignal("logs")
+	if logs.WorkerCount != 16 || logs.QueueSize != 500000 || logs.BatchTimeout != 2*time.Second || logs.BatchSize != perf.BatchSize {
+		t.Errorf("logs settings = %+v", logs)
+	}
+	if metrics := perf.ForSignal("metrics"); metrics.WorkerCount != perf.WorkerCount || metrics.QueueSize != perf.QueueSize {
+		t.Errorf("metrics should keep the shared settings, got %+v", metrics)
+	}
+
+	cfg := DefaultConfig()
+	cfg.Performance.Signals.Traces.QueueSize = -1
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for a negative signal queue size")
+	}
+}
+
+func TestValidateRollups(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Rollups.LagInterval = -time.Second
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for negative rollup lag interval")
+	}
+}
+
+func TestKafkaModes(t *testing.T) {
+	tests := []struct {
+		mode     string
+		produces bool
+		consumes bool
+	}{
+		{mode: "", produces: true, consumes: true},
+		{mode: "both", produces: true, consumes: true},
+		{mode: "producer", produces: true, consumes: false},
+		{mode: "consumer", produces: false, consumes: true},
+	}
+
+	for _, tt := range tests {
+		k := KafkaConfig{Enabled: true, Mode: tt.mode}
+		if k.Produces() != tt.produces || k.Consumes() != tt.consumes {
+			t.Errorf("mode %q: Produces=%v Consumes=%v, want %v %v", tt.mode, k.Produces(), k.Consumes(), tt.produces, tt.consumes)
+		}
+	}
+
+	disabled := KafkaConfig{Mode: "both"}
+	if disabled.Produces() || disabled.Consumes() {
+		t.Error("disabled kafka should neither produce nor consume")
+	}
+}
+
+func TestValidateSamplingPolicies(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  SamplingPolicy
+		wantErr bool
+	}{
+		{name: "valid", policy: SamplingPolicy{Name: "default", Rate: 0.1}, wantErr: false},
+		{name: "valid downsample", policy: SamplingPolicy{Name: "api", Rate: 0.5, Logs: LogSamplingConfig{Action: "downsample", Rate: 0.1}}, wantErr: false},
+		{name: "missing name", policy: SamplingPolicy{Rate: 0.1}, wantErr: true},
+		{name: "rate above one", policy: SamplingPolicy{Name: "default", Rate: 1.5}, wantErr: true},
+		{name: "negative logs rate", policy: SamplingPolicy{Name: "default", Rate: 0.1, Logs: LogSamplingConfig{Action: "downsample", Rate: -1}}, wantErr: true},
+		{name: "unknown logs action", policy: SamplingPolicy{Name: "default", Rate: 0.1, Logs: LogSamplingConfig{Action: "archive"}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.Sampling.Policies = []SamplingPolicy{tt.policy}
+			err := cfg.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateSamplingDecisionCache(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Sampling.DecisionCacheTTL = -time.Minute
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for negative decision cache TTL")
+	}
+}
+
+func TestValidateSpanMetrics(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.SpanMetrics.Enabled = true
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	cfg.SpanMetrics.Buckets = []time.Duration{time.Second, 100 * time.Millisecond}
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for unsorted buckets")
+	}
+
+	cfg.SpanMetrics.Buckets = nil
+	cfg.SpanMetrics.FlushInterval = 0
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for zero flush interval")
+	}
+}
+
+func TestValidateSpanNames(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.SpanNames.Rules = []SpanNameRule{{Pattern: `/\d+`, Replacement: "/{id}"}}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("valid rule rejected: %v", err)
+	}
+	cfg.SpanNames.Rules = append(cfg.SpanNames.Rules, SpanNameRule{Pattern: "("})
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for an invalid pattern")
+	}
+	cfg.SpanNames.Rules = []SpanNameRule{{Replacement: "x"}}
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for an empty pattern")
+	}
+}
+
+func TestValidateLogMetrics(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.LogMetrics.Rules = []LogMetricRule{
+		{Name: "log.errors", MinSeverity: "ERROR"},
+		{Name: "checkout.latency", BodyRegex: `took (?P<ms>\d+)ms`, Value: "ms", Aggregation: "max"},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	for name, rule := range map[string]LogMetricRule{
+		"missing name":     {MinSeverity: "ERROR"},
+		"duplicate name":   {Name: "log.errors"},
+		"unknown severity": {Name: "x", MinSeverity: "LOUD"},
+		"invalid regex":    {Name: "x", BodyRegex: "("},
+		"bad aggregation":  {Name: "x", Value: "ms", Aggregation: "p99"},
+	} {
+		bad := DefaultConfig()
+		bad.LogMetrics.Rules = []LogMetricRule{cfg.LogMetrics.Rules[0], rule}
+		if err := bad.Validate(); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+
+	cfg.LogMetrics.FlushInterval = 0
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for zero flush interval")
+	}
+}
+
+func TestValidateMaxConcurrentExports(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.OTLP.MaxConcurrentExports = 0
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	cfg.OTLP.MaxConcurrentExports = -1
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for negative max concurrent exports")
+	}
+}
+
+func TestValidateServerGRPCPort(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Server.GRPCPort = 9091
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	cfg.Server.GRPCPort = cfg.Server.Port
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for grpc port equal to the http port")
+	}
+
+	cfg.Server.GRPCPort = 70000
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for out of range grpc port")
+	}
+}
+
+func TestValidateServerLimits(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Server.IdleTimeout = -time.Second
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for negative idle timeout")
+	}
+
+	cfg = DefaultConfig()
+	cfg.Server.MaxHeaderBytes = -1
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for negative max header bytes")
+	}
+}
+
+func TestValidateAnomalies(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Anomalies.Enabled = true
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	cfg.Anomalies.Alpha = 1.5
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for alpha above 1")
+	}
+
+	cfg.Anomalies.Alpha = 0.2
+	cfg.Anomalies.Window = 0
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for zero window")
+	}
+}
+
+func TestValidateSLO(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.SLO.Objectives = []SLOObjective{
+		{Name: "checkout-availability", Service: "checkout", Target: 0.999, Window: 720 * time.Hour},
+		{Name: "checkout-latency", Service: "checkout", Target: 0.99, LatencyThreshold: 300 * time.Millisecond, Window: 720 * time.Hour},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	cfg.SLO.Objectives[1].Name = "checkout-availability"
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for duplicate objective name")
+	}
+
+	cfg.SLO.Objectives[1].Name = "checkout-latency"
+	cfg.SLO.Objectives[1].Target = 1
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for a target of 1, which leaves no error budget")
+	}
+
+	cfg.SLO.Objectives[1].Target = 0.99
+	cfg.SLO.Interval = 0
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for zero interval")
+	}
+}
+
+func TestValidateQueryGate(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.ClickHouse.QueryGate.Timeouts = map[string]time.Duration{"/api/v1/traces": 10 * time.Second}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	cfg.ClickHouse.QueryGate.Timeouts = map[string]time.Duration{"traces": 10 * time.Second}
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for timeout key that is not a route")
+	}
+
+	cfg.ClickHouse.QueryGate.Timeouts = map[string]time.Duration{"/api/v1/traces": 0}
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for zero route timeout")
+	}
+
+	cfg.ClickHouse.QueryGate.Timeouts = nil
+	cfg.ClickHouse.QueryGate.MaxQueued = -1
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for negative max queued")
+	}
+}
+
+func TestValidateLogging(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Monitoring.LogLevel, cfg.Monitoring.LogFormat = "warn", "text"
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	cfg.Monitoring.LogLevel = "verbose"
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for unknown log level")
+	}
+
+	cfg.Monitoring.LogLevel, cfg.Monitoring.LogFormat = "info", "logfmt"
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for unknown log format")
+	}
+}
+
+func TestValidateOTLPEndpoint(t *testing.T) {
+	for _, endpoint := range []string{"", "localhost:4317", "https://otel.example.com:443", "http://10.0.0.1:4317"} {
+		if err := ValidateOTLPEndpoint(endpoint); err != nil {
+			t.Errorf("%q: unexpected error: %v", endpoint, err)
+		}
+	}
+	for _, endpoint := range []string{"localhost", "grpc://otel:4317", "https://"} {
+		if err := ValidateOTLPEndpoint(endpoint); err == nil {
+			t.Errorf("%q: expected error", endpoint)
+		}
+	}
+
+	cfg := DefaultConfig()
+	cfg.Monitoring.OTLPEndpoint = "otel-collector"
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for endpoint without port")
+	}
+}
+
+func TestValidateQueryLimits(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.ClickHouse.QueryLimits = QueryLimits{MaxTimeRange: 7 * 24 * time.Hour, Action: "clamp"}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	cfg.ClickHouse.QueryLimits.Action = "truncate"
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for unknown action")
+	}
+
+	cfg.ClickHouse.QueryLimits = QueryLimits{MaxTimeRange: -time.Hour, Action: "reject"}
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for negative max time range")
+	}
+}
+
+func TestValidateQueryCache(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.QueryCache.Enabled = true
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	cfg.QueryCache.MaxSizeMiB = 0
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for in-process cache without a size")
+	}
+
+	cfg.QueryCache.RedisAddress = "redis:6379"
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("redis cache needs no size: %v", err)
+	}
+
+	cfg.QueryCache.RedisAddress = "redis"
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for redis address without a port")
+	}
+}
+
+func TestValidateAuth(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Auth.Enabled = true
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for auth without keys or issuer")
+	}
+
+	cfg.Auth.Roles = map[string][]string{"reader": {"read:traces", "read:logs"}}
+	cfg.Auth.APIKeys = []APIKeyConfig{
+		{Name: "ci", Key: "secret", Roles: []string{"reader"}},
+		{Name: "ops", KeySHA256: strings.Repeat("ab", 32), Scopes: []string{"admin"}},
+	}
+	cfg.Auth.OIDC.IssuerURL = "https://login.example.com/realms/otel"
+	cfg.Auth.RouteScopes = map[string][]string{"/api/v1/usage": {"read:metrics"}}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*AuthConfig)
+	}{
+		{"unknown scope", func(a *AuthConfig) { a.APIKeys[0].Scopes = []string{"write:traces"} }},
+		{"unknown role", func(a *AuthConfig) { a.APIKeys[0].Roles = []string{"writer"} }},
+		{"unknown role scope", func(a *AuthConfig) { a.Roles["reader"] = []string{"read"} }},
+		{"unknown route scope", func(a *AuthConfig) { a.RouteScopes["/api/v1/usage"] = []string{"usage"} }},
+		{"key and digest", func(a *AuthConfig) { a.APIKeys[1].Key = "secret" }},
+		{"short digest", func(a *AuthConfig) { a.APIKeys[1].KeySHA256 = "abcd" }},
+		{"duplicate name", func(a *AuthConfig) { a.APIKeys[1].Name = "ci" }},
+		{"relative issuer", func(a *AuthConfig) { a.OIDC.IssuerURL = "login.example.com" }},
+		{"no refresh", func(a *AuthConfig) { a.OIDC.RefreshInterval = 0 }},
+	}
+	for _, tt := range tests {
+		c := *cfg
+		c.Auth.APIKeys = append([]APIKeyConfig(nil), cfg.Auth.APIKeys...)
+		c.Auth.Roles = map[string][]string{"reader": {"read:traces"}}
+		c.Auth.RouteScopes = map[string][]string{}
+		tt.modify(&c.Auth)
+		if err := c.Validate(); err == nil {
+			t.Errorf("%s: expected error", tt.name)
+		}
+	}
+}
+
+func TestValidateCORS(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Server.CORS.AllowedOrigins = []string{"*", "https://dash.example.com", "http://localhost:3000"}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	for _, origin := range []string{"dash.example.com", "https://dash.example.com/app", "ftp://files"} {
+		cfg.Server.CORS.AllowedOrigins = []string{origin}
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("%s: expected error", origin)
+		}
+	}
+
+	cfg.Server.CORS.AllowedOrigins = nil
+	cfg.Server.CORS.MaxAge = -time.Second
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for negative max age")
+	}
+}
+
+func TestValidateAudit(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Audit.Enabled = true
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	cfg.Audit.BatchSize = cfg.Audit.QueueSize + 1
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for a batch larger than the queue")
+	}
+
+	cfg.Audit = DefaultConfig().Audit
+	cfg.Audit.Enabled = true
+	cfg.Audit.FlushInterval = 0
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for zero flush interval")
+	}
+}
+
+func TestValidateQueryProfiles(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.ClickHouse.QueryProfiles.Export = QueryProfile{MaxThreads: 2, MaxMemoryUsage: 8 << 30, Priority: 20}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	cfg.ClickHouse.QueryProfiles.Background.MaxThreads = -1
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for negative max_threads")
+	}
+}
+
+func TestValidateTimestampMode(t *testing.T) {
+	cfg := DefaultConfig()
+	for _, mode := range []string{"", TimestampCompat, TimestampStrict} {
+		cfg.ClickHouse.TimestampMode = mode
+		if err := cfg.Validate(); err != nil {
+			t.Errorf("mode %q: unexpected error: %v", mode, err)
+		}
+	}
+	cfg.ClickHouse.TimestampMode = "truncate"
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for an unknown timestamp mode")
+	}
+}
+
+func TestValidateClickHouseAddresses(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.ClickHouse.Addresses = []string{"ch-0:9000", "[::1]:9000", "dns+clickhouse-headless:9000", "dnssrv+_native._tcp.clickhouse.svc"}
+	cfg.ClickHouse.DiscoveryInterval = 30 * time.Second
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	for _, addr := range []string{"clickhouse", "clickhouse:", ":9000", "clickhouse:http", "clickhouse:70000", "dns+clickhouse", "dnssrv+", "dnssrv+clickhouse:9000"} {
+		cfg.ClickHouse.Addresses = []string{addr}
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("expected error for address %q", addr)
+		}
+	}
+
+	cfg = DefaultConfig()
+	cfg.ClickHouse.DiscoveryInterval = -time.Second
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for negative discovery interval")
+	}
+}
+
+func TestParseClickHouseAddress(t *testing.T) {
+	tests := map[string]ClickHouseAddress{
+		"ch-0:9000":                    {Host: "ch-0", Port: "9000"},
+		"dns+clickhouse-headless:9440": {Discovery: "dns", Host: "clickhouse-headless", Port: "9440"},
+		"dnssrv+_native._tcp.ch.svc":   {Discovery: "dnssrv", Host: "_native._tcp.ch.svc"},
+	}
+	for addr, want := range tests {
+		got, err := ParseClickHouseAddress(addr)
+		if err != nil || got != want {
+			t.Errorf("ParseClickHouseAddress(%q) = %+v, %v; want %+v", addr, got, err, want)
+		}
+	}
+}
+
+func TestValidateQueryBudget(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.ClickHouse.QueryBudget = QueryBudget{MaxRows: 1000000, Action: "warn"}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	cfg.ClickHouse.QueryBudget.Action = "ignore"
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for unknown query budget action")
+	}
+}
+
+func TestExporterExportsSignal(t *testing.T) {
+	all := ExporterConfig{}
+	if !all.ExportsSignal("metrics") {
+		t.Error("exporter without signals should export everything")
+	}
+
+	tracesOnly := ExporterConfig{Signals: []string{"traces"}}
+	if !tracesOnly.ExportsSignal("traces") {
+		t.Error("expected traces to be exported")
+	}
+	if tracesOnly.ExportsSignal("logs") {
+		t.Error("expected logs to be skipped")
+	}
+}
+
 func TestLoadConfig(t *testing.T) {
 	// Create a temporary config file
 	tmpfile, err := os.CreateTemp("", "config-*.yaml")
@@ -207,6 +1213,9 @@ performance:
 	if cfg.Performance.WorkerCount != 8 {
 		t.Errorf("Expected worker count 8, got %d", cfg.Performance.WorkerCount)
 	}
+	if cfg.Server.DrainTimeout != 60*time.Second {
+		t.Errorf("Expected drain timeout to default to shutdown timeout, got %v", cfg.Server.DrainTimeout)
+	}
 }
 
 func TestLoadConfigWithInvalidFile(t *testing.T) {
@@ -237,7 +1246,9 @@ func TestApplyEnvOverrides(t *testing.T) {
 	}()
 
 	cfg := DefaultConfig()
-	applyEnvOverrides(cfg)
+	if err := applyEnvOverrides(cfg); err != nil {
+		t.Fatal(err)
+	}
 
 	if cfg.ClickHouse.Addresses[0] != "env-host:9000" {
 		t.Errorf("Expected env-host:9000, got %s", cfg.ClickHouse.Addresses[0])
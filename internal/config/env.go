@@ -0,0 +1,219 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// envVar is an environment variable that overrides a config setting
+type envVar struct {
+	Name    string // e.g. CLICKHOUSE_MAX_OPEN_CONNS
+	Setting string // e.g. clickhouse.max_open_conns
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// envVars lists the environment variables generated from the YAML names of
+// the settings: the path of a setting in upper case, joined by underscores.
+// Lists and maps of settings, such as exporters, have none.
+func envVars() []envVar {
+	var vars []envVar
+	walkEnvSettings(reflect.ValueOf(&Config{}).Elem(), nil, func(path []string, _ reflect.Value, _ func()) {
+		vars = append(vars, envVar{Name: envName(path), Setting: strings.Join(path, ".")})
+	})
+	sort.Slice(vars, func(i, j int) bool { return vars[i].Name < vars[j].Name })
+	return vars
+}
+
+// walkEnvSettings calls fn with every setting of v that an environment
+// variable can set, and a func clearing its companion: the *_file setting of
+// a secret, or the secret of a *_file setting
+func walkEnvSettings(v reflect.Value, path []string, fn func(path []string, field reflect.Value, clearCompanion func())) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := yamlName(f)
+		if name == "" || name == "-" || !f.IsExported() {
+			continue
+		}
+		field := v.Field(i)
+		fieldPath := append(append([]string(nil), path...), name)
+		if field.Kind() == reflect.Struct {
+			walkEnvSettings(field, fieldPath, fn)
+			continue
+		}
+		if !envSettable(field.Type()) {
+			continue
+		}
+		companion := companionField(v, name)
+		fn(fieldPath, field, func() {
+			if companion.IsValid() {
+				companion.Set(reflect.Zero(companion.Type()))
+			}
+		})
+	}
+}
+
+// companionField finds the secret or *_file setting paired with name in
+// the struct v
+func companionField(v reflect.Value, name string) reflect.Value {
+	other := name + "_file"
+	if base, ok := strings.CutSuffix(name, "_file"); ok {
+		other = base
+	}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if yamlName(t.Field(i)) == other {
+			return v.Field(i)
+		}
+	}
+	return reflect.Value{}
+}
+
+func envSettable(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String, reflect.Bool, reflect.Int, reflect.Int64, reflect.Float64:
+		return true
+	case reflect.Slice:
+		return t.Elem().Kind() == reflect.String
+	case reflect.Map:
+		return t.Key().Kind() == reflect.String && t.Elem().Kind() == reflect.String
+	}
+	return false
+}
+
+func envName(path []string) string {
+	return strings.ToUpper(strings.Join(path, "_"))
+}
+
+// setEnvValue parses an environment variable into a setting. Durations are
+// written like in YAML, lists as a,b,c and maps as key=value,key=value.
+func setEnvValue(field reflect.Value, value string) error {
+	switch {
+	case field.Type() == durationType:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+		return nil
+	case field.Kind() == reflect.String:
+		field.SetString(value)
+	case field.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case field.Kind() == reflect.Int || field.Kind() == reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case field.Kind() == reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case field.Kind() == reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		field.Set(reflect.ValueOf(items).Convert(field.Type()))
+	case field.Kind() == reflect.Map:
+		m, err := parseEnvMap(value)
+		if err != nil {
+			return err
+		}
+		field.Set(reflect.ValueOf(m).Convert(field.Type()))
+	}
+	return nil
+}
+
+// parseEnvMap parses key=value,key=value
+func parseEnvMap(value string) (map[string]string, error) {
+	m := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		key, val, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("entry %q is not key=value", pair)
+		}
+		m[strings.TrimSpace(key)] = strings.TrimSpace(val)
+	}
+	return m, nil
+}
+
+// applyEnvOverrides applies environment variable overrides, in increasing
+// precedence: the standard OTEL_* variables, the older short names
+// CLICKHOUSE_HOST and LOG_LEVEL, and the variables of envVars. A variable
+// that sets a secret also clears its *_file setting and vice versa, so the
+// environment wins over either in the file.
+func applyEnvOverrides(config *Config) error {
+	if err := applyOTELEnv(config); err != nil {
+		return err
+	}
+	if val := os.Getenv("CLICKHOUSE_HOST"); val != "" {
+		config.ClickHouse.Addresses = []string{val}
+	}
+	if val := os.Getenv("LOG_LEVEL"); val != "" {
+		config.Monitoring.LogLevel = val
+	}
+
+	var err error
+	walkEnvSettings(reflect.ValueOf(config).Elem(), nil, func(path []string, field reflect.Value, clearCompanion func()) {
+		name := envName(path)
+		val := os.Getenv(name)
+		if val == "" || err != nil {
+			return
+		}
+		if setErr := setEnvValue(field, val); setErr != nil {
+			err = fmt.Errorf("invalid %s %q: %w", name, val, setErr)
+			return
+		}
+		clearCompanion()
+	})
+	return err
+}
+
+// applyOTELEnv applies the standard OpenTelemetry SDK variables that have a
+// setting: OTEL_LOG_LEVEL, and OTEL_TRACES_SAMPLER with
+// OTEL_TRACES_SAMPLER_ARG for the self-trace sample rate. The
+// OTEL_EXPORTER_OTLP_* variables are applied when tracing starts.
+func applyOTELEnv(config *Config) error {
+	if val := os.Getenv("OTEL_LOG_LEVEL"); val != "" {
+		config.Monitoring.LogLevel = val
+	}
+	sampler := os.Getenv("OTEL_TRACES_SAMPLER")
+	switch sampler {
+	case "":
+	case "always_on", "parentbased_always_on":
+		config.Monitoring.TraceSampleRate = 1
+	case "always_off", "parentbased_always_off":
+		config.Monitoring.TraceSampleRate = 0
+	case "traceidratio", "parentbased_traceidratio":
+		rate := 1.0
+		if arg := os.Getenv("OTEL_TRACES_SAMPLER_ARG"); arg != "" {
+			var err error
+			if rate, err = strconv.ParseFloat(arg, 64); err != nil || rate < 0 || rate > 1 {
+				return fmt.Errorf("invalid OTEL_TRACES_SAMPLER_ARG %q: must be between 0 and 1", arg)
+			}
+		}
+		config.Monitoring.TraceSampleRate = rate
+	default:
+		return fmt.Errorf("unsupported OTEL_TRACES_SAMPLER %q", sampler)
+	}
+	return nil
+}
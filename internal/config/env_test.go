@@ -0,0 +1,138 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEnvVars(t *testing.T) {
+	settings := make(map[string]string)
+	for _, v := range envVars() {
+		if prev, ok := settings[v.Name]; ok {
+			t.Errorf("%s names both %s and %s", v.Name, prev, v.Setting)
+		}
+		settings[v.Name] = v.Setting
+	}
+	for name, setting := range map[string]string{
+		"SERVER_PORT":                         "server.port",
+		"CLICKHOUSE_MAX_OPEN_CONNS":           "clickhouse.max_open_conns",
+		"CLICKHOUSE_QUERY_GATE_MAX_QUEUED":    "clickhouse.query_gate.max_queued",
+		"PERFORMANCE_BATCH_SIZE":              "performance.batch_size",
+		"PERFORMANCE_SIGNALS_LOGS_QUEUE_SIZE": "performance.signals.logs.queue_size",
+		"KAFKA_BROKERS":                       "kafka.brokers",
+		"MONITORING_OTLP_HEADERS":             "monitoring.otlp_headers",
+	} {
+		if settings[name] != setting {
+			t.Errorf("%s sets %q, want %q", name, settings[name], setting)
+		}
+	}
+	if _, ok := settings["EXPORTERS"]; ok {
+		t.Error("lists of settings have no variable")
+	}
+}
+
+func TestApplyEnvOverridesGenerated(t *testing.T) {
+	t.Setenv("SERVER_PORT", "9999")
+	t.Setenv("SERVER_READ_TIMEOUT", "45s")
+	t.Setenv("CLICKHOUSE_MAX_OPEN_CONNS", "80")
+	t.Setenv("CLICKHOUSE_ADDRESSES", "ch1:9000, ch2:9000")
+	t.Setenv("CLICKHOUSE_HTTP_HEADERS", "x-tenant=acme,authorization=Bearer abc")
+	t.Setenv("PERFORMANCE_SIGNALS_LOGS_WORKER_COUNT", "12")
+	t.Setenv("KAFKA_ENABLED", "true")
+	t.Setenv("MONITORING_TRACE_SAMPLE_RATE", "0.5")
+	t.Setenv("LOG_LEVEL", "warn")
+	t.Setenv("MONITORING_LOG_LEVEL", "debug")
+
+	cfg := DefaultConfig()
+	if err := applyEnvOverrides(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Server.Port != 9999 || cfg.Server.ReadTimeout != 45*time.Second || cfg.ClickHouse.MaxOpenConns != 80 {
+		t.Errorf("server %+v, max open conns %d", cfg.Server, cfg.ClickHouse.MaxOpenConns)
+	}
+	if strings.Join(cfg.ClickHouse.Addresses, " ") != "ch1:9000 ch2:9000" || cfg.ClickHouse.HTTPHeaders["authorization"] != "Bearer abc" {
+		t.Errorf("addresses %q, headers %v", cfg.ClickHouse.Addresses, cfg.ClickHouse.HTTPHeaders)
+	}
+	if cfg.Performance.Signals.Logs.WorkerCount != 12 || !cfg.Kafka.Enabled || cfg.Monitoring.TraceSampleRate != 0.5 {
+		t.Errorf("logs workers %d, kafka %v, sample rate %v", cfg.Performance.Signals.Logs.WorkerCount, cfg.Kafka.Enabled, cfg.Monitoring.TraceSampleRate)
+	}
+	if cfg.Monitoring.LogLevel != "debug" {
+		t.Errorf("log level %q: MONITORING_LOG_LEVEL should win over LOG_LEVEL", cfg.Monitoring.LogLevel)
+	}
+
+	for name, value := range map[string]string{
+		"SERVER_PORT":             "http",
+		"SERVER_READ_TIMEOUT":     "45",
+		"KAFKA_ENABLED":           "maybe",
+		"CLICKHOUSE_HTTP_HEADERS": "x-tenant",
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(name, value)
+			if err := applyEnvOverrides(DefaultConfig()); err == nil || !strings.Contains(err.Error(), "invalid "+name) {
+				t.Errorf("error = %v", err)
+			}
+		})
+	}
+}
+
+func TestApplyOTELEnv(t *testing.T) {
+	tests := []struct {
+		sampler, arg string
+		want         float64
+		err          bool
+	}{
+		{"always_on", "", 1, false},
+		{"parentbased_always_off", "", 0, false},
+		{"traceidratio", "0.25", 0.25, false},
+		{"parentbased_traceidratio", "", 1, false},
+		{"traceidratio", "2", 0, true},
+		{"jaeger_remote", "", 0, true},
+	}
+	for _, tt := range tests {
+		t.Setenv("OTEL_TRACES_SAMPLER", tt.sampler)
+		t.Setenv("OTEL_TRACES_SAMPLER_ARG", tt.arg)
+		cfg := DefaultConfig()
+		err := applyEnvOverrides(cfg)
+		if (err != nil) != tt.err || (!tt.err && cfg.Monitoring.TraceSampleRate != tt.want) {
+			t.Errorf("%s %q: rate %v, error %v", tt.sampler, tt.arg, cfg.Monitoring.TraceSampleRate, err)
+		}
+	}
+
+	t.Setenv("OTEL_TRACES_SAMPLER", "")
+	t.Setenv("OTEL_LOG_LEVEL", "error")
+	cfg := DefaultConfig()
+	if err := applyEnvOverrides(cfg); err != nil || cfg.Monitoring.LogLevel != "error" {
+		t.Errorf("OTEL_LOG_LEVEL: log level %q, error %v", cfg.Monitoring.LogLevel, err)
+	}
+}
+
+// A secret from the environment replaces a *_file setting of the file, and
+// the other way round
+func TestEnvOverridesSecretFiles(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "password"), []byte("from-file\n"), 0o600)
+
+	t.Setenv("CLICKHOUSE_PASSWORD", "from-env")
+	cfg := DefaultConfig()
+	cfg.ClickHouse.PasswordFile = filepath.Join(dir, "password")
+	if err := applyEnvOverrides(cfg); err != nil || cfg.ClickHouse.PasswordFile != "" || cfg.ClickHouse.Password != "from-env" {
+		t.Errorf("password %q, file %q, error %v", cfg.ClickHouse.Password, cfg.ClickHouse.PasswordFile, err)
+	}
+
+	t.Setenv("CLICKHOUSE_PASSWORD", "")
+	t.Setenv("QUERY_CACHE_REDIS_PASSWORD_FILE", filepath.Join(dir, "password"))
+	cfg = DefaultConfig()
+	cfg.QueryCache.RedisPassword = "in-yaml"
+	if err := applyEnvOverrides(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.applySecretFiles(); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.QueryCache.RedisPassword != "file://"+filepath.Join(dir, "password") {
+		t.Errorf("redis password %q", cfg.QueryCache.RedisPassword)
+	}
+}
@@ -0,0 +1,242 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"otelservices/internal/logging"
+
+	"gopkg.in/yaml.v3"
+)
+
+// redacted replaces secrets in the config shown by the admin endpoint
+const redacted = "<redacted>"
+
+var reloadLog = logging.For("config")
+
+// ReloadResult reports the settings a reload changed, by their YAML path
+// such as performance.batch_size
+type ReloadResult struct {
+	Applied         []string `json:"applied"`          // dynamic settings now in effect
+	RestartRequired []string `json:"restart_required"` // changed in the file but only read at startup
+}
+
+// Reloader re-reads the config file at runtime, on SIGHUP or through its
+// reload handler, and applies the settings that can change without a
+// restart: log level and format, sampling, batch sizing and the query
+// profiles, budget and limits. The new file is validated first; when it is
+// invalid the running config is kept. Other settings keep their startup
+// values until the next restart.
+type Reloader struct {
+	path    string
+	apply   func(*Config)
+	mu      sync.Mutex // serializes reloads
+	current atomic.Pointer[Config]
+}
+
+// NewReloader returns a reloader for the file cfg was loaded from. apply is
+// called with the new effective config after each successful reload.
+func NewReloader(cfg *Config, apply func(*Config)) *Reloader {
+	r := &Reloader{path: cfg.Path, apply: apply}
+	r.current.Store(cfg)
+	return r
+}
+
+// Current returns the effective config
+func (r *Reloader) Current() *Config {
+	return r.current.Load()
+}
+
+// Reload reads and validates the config file and applies its dynamic settings
+func (r *Reloader) Reload() (ReloadResult, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	next, err := LoadConfig(r.path)
+	if err != nil {
+		reloadLog.Error("Config reload failed, keeping the running config", "path", r.path, "error", err)
+		return ReloadResult{}, err
+	}
+	current := r.Current()
+	merged := mergeDynamic(current, next)
+	if err := merged.Validate(); err != nil {
+		err = fmt.Errorf("invalid configuration: %w", err)
+		reloadLog.Error("Config reload failed, keeping the running config", "path", r.path, "error", err)
+		return ReloadResult{}, err
+	}
+
+	result := ReloadResult{
+		Applied:         changedSettings(current, merged),
+		RestartRequired: changedSettings(merged, next),
+	}
+	r.current.Store(merged)
+	if r.apply != nil {
+		r.apply(merged)
+	}
+	reloadLog.Info("Config reloaded", "path", r.path, "applied", result.Applied)
+	if len(result.RestartRequired) > 0 {
+		reloadLog.Warn("Changed settings take effect after a restart", "settings", result.RestartRequired)
+	}
+	return result, nil
+}
+
+// WatchSignals reloads the config on every SIGHUP until ctx is done
+func (r *Reloader) WatchSignals(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			r.Reload()
+		}
+	}
+}
+
+// HandleConfig serves the effective config as JSON, with credentials redacted
+func (r *Reloader) HandleConfig(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	view, err := r.Current().Redacted().toMap()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(view)
+}
+
+// HandleReload reloads the config file and reports what changed. An invalid
+// file is rejected with 422 and leaves the running config in place.
+func (r *Reloader) HandleReload(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	result, err := r.Reload()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// mergeDynamic returns current with the settings that can change at runtime
+// taken from next
+func mergeDynamic(current, next *Config) *Config {
+	merged := *current
+	merged.Monitoring.LogLevel = next.Monitoring.LogLevel
+	merged.Monitoring.LogFormat = next.Monitoring.LogFormat
+	merged.Sampling = next.Sampling
+	merged.Performance.BatchSize = next.Performance.BatchSize
+	merged.Performance.BatchTimeout = next.Performance.BatchTimeout
+	merged.Performance.MaxBatchBytes = next.Performance.MaxBatchBytes
+	merged.Performance.InsertLatencyTarget = next.Performance.InsertLatencyTarget
+	for _, t := range []struct{ merged, next *SignalTuning }{
+		{&merged.Performance.Signals.Traces, &next.Performance.Signals.Traces},
+		{&merged.Performance.Signals.Metrics, &next.Performance.Signals.Metrics},
+		{&merged.Performance.Signals.Logs, &next.Performance.Signals.Logs},
+	} {
+		t.merged.BatchSize = t.next.BatchSize
+		t.merged.BatchTimeout = t.next.BatchTimeout
+	}
+	merged.ClickHouse.QueryProfiles = next.ClickHouse.QueryProfiles
+	merged.ClickHouse.QueryBudget = next.ClickHouse.QueryBudget
+	merged.ClickHouse.QueryLimits = next.ClickHouse.QueryLimits
+	return &merged
+}
+
+// changedSettings lists the settings that differ between two configs, by
+// section and field
+func changedSettings(a, b *Config) []string {
+	changed := []string{}
+	va, vb := reflect.ValueOf(*a), reflect.ValueOf(*b)
+	for i := 0; i < va.NumField(); i++ {
+		section := yamlName(va.Type().Field(i))
+		fa, fb := va.Field(i), vb.Field(i)
+		if fa.Kind() != reflect.Struct {
+			if !reflect.DeepEqual(fa.Interface(), fb.Interface()) {
+				changed = append(changed, section)
+			}
+			continue
+		}
+		for j := 0; j < fa.NumField(); j++ {
+			if !reflect.DeepEqual(fa.Field(j).Interface(), fb.Field(j).Interface()) {
+				changed = append(changed, section+"."+yamlName(fa.Type().Field(j)))
+			}
+		}
+	}
+	return changed
+}
+
+func yamlName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+	return name
+}
+
+// Redacted returns a copy of the config with passwords, secret keys, API
+// keys and header values, which often hold credentials, replaced
+func (c *Config) Redacted() *Config {
+	r := *c
+	r.ClickHouse.Password = redact(r.ClickHouse.Password)
+	r.QueryCache.RedisPassword = redact(r.QueryCache.RedisPassword)
+	r.Export.S3.SecretAccessKey = redact(r.Export.S3.SecretAccessKey)
+	r.Monitoring.OTLPHeaders = redactValues(r.Monitoring.OTLPHeaders)
+	r.ClickHouse.HTTPHeaders = redactValues(r.ClickHouse.HTTPHeaders)
+	r.Auth.APIKeys = append([]APIKeyConfig(nil), c.Auth.APIKeys...)
+	for i := range r.Auth.APIKeys {
+		r.Auth.APIKeys[i].Key = redact(r.Auth.APIKeys[i].Key)
+	}
+	r.Exporters = append([]ExporterConfig(nil), c.Exporters...)
+	for i := range r.Exporters {
+		r.Exporters[i].Headers = redactValues(r.Exporters[i].Headers)
+	}
+	return &r
+}
+
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return redacted
+}
+
+func redactValues(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	out := make(map[string]string, len(m))
+	for k, v := range m {
+		out[k] = redact(v)
+	}
+	return out
+}
+
+// toMap converts the config to a map keyed like the YAML file, so it encodes
+// to JSON with the same names and durations such as "30s"
+func (c *Config) toMap() (map[string]interface{}, error) {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode config: %w", err)
+	}
+	var m map[string]interface{}
+	if err := yaml.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("failed to encode config: %w", err)
+	}
+	return m, nil
+}
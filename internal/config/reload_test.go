@@ -0,0 +1,169 @@
+package config
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// writeConfig writes cfg as YAML to path
+func writeConfig(t *testing.T, path string, cfg *Config) {
+	t.Helper()
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloaderAppliesDynamicSettings(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, DefaultConfig())
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var applied *Config
+	r := NewReloader(cfg, func(c *Config) { applied = c })
+
+	next := DefaultConfig()
+	next.Monitoring.LogLevel = "debug"
+	next.Performance.BatchSize = 500
+	next.ClickHouse.QueryBudget.MaxRows = 1000
+	next.Server.Port = 9090
+	writeConfig(t, path, next)
+
+	result, err := r.Reload()
+	if err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	wantApplied := []string{"clickhouse.query_budget", "monitoring.log_level", "performance.batch_size"}
+	if !reflect.DeepEqual(result.Applied, wantApplied) {
+		t.Errorf("applied = %v, want %v", result.Applied, wantApplied)
+	}
+	if !reflect.DeepEqual(result.RestartRequired, []string{"server.port"}) {
+		t.Errorf("restart required = %v", result.RestartRequired)
+	}
+
+	current := r.Current()
+	if applied != current || current.Performance.BatchSize != 500 || current.Monitoring.LogLevel != "debug" {
+		t.Errorf("dynamic settings not applied: %+v", current.Performance)
+	}
+	if current.Server.Port != cfg.Server.Port {
+		t.Errorf("static setting changed at runtime: port %d", current.Server.Port)
+	}
+	if cfg.Performance.BatchSize == 500 {
+		t.Error("reload modified the startup config in place")
+	}
+}
+
+func TestReloaderSignalTuning(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, DefaultConfig())
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewReloader(cfg, func(*Config) {})
+
+	next := DefaultConfig()
+	next.Performance.Signals.Logs = SignalTuning{BatchSize: 50000, WorkerCount: 16}
+	writeConfig(t, path, next)
+	result, err := r.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	logs := r.Current().Performance.Signals.Logs
+	if logs.BatchSize != 50000 || logs.WorkerCount != 0 {
+		t.Errorf("logs tuning = %+v, want only the batch size applied", logs)
+	}
+	if !reflect.DeepEqual(result.RestartRequired, []string{"performance.signals"}) {
+		t.Errorf("restart required = %v", result.RestartRequired)
+	}
+}
+
+func TestReloaderKeepsConfigOnInvalidFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	cfg := DefaultConfig()
+	cfg.Path = path
+	called := false
+	r := NewReloader(cfg, func(*Config) { called = true })
+
+	invalid := DefaultConfig()
+	invalid.Performance.BatchSize = 0
+	writeConfig(t, path, invalid)
+
+	if _, err := r.Reload(); err == nil {
+		t.Fatal("expected error for invalid config")
+	}
+	if called || r.Current() != cfg {
+		t.Error("invalid config should not be applied")
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.ClickHouse.Password = "secret"
+	cfg.Monitoring.OTLPHeaders = map[string]string{"authorization": "Bearer token"}
+	cfg.Exporters = []ExporterConfig{{Name: "upstream", Headers: map[string]string{"x-api-key": "key"}}}
+	cfg.ClickHouse.HTTPHeaders = map[string]string{"X-Proxy-Token": "token"}
+	cfg.Auth.APIKeys = []APIKeyConfig{{Name: "ci", Key: "api-key"}}
+
+	r := cfg.Redacted()
+	if r.ClickHouse.Password != redacted || r.QueryCache.RedisPassword != "" {
+		t.Errorf("passwords = %q, %q", r.ClickHouse.Password, r.QueryCache.RedisPassword)
+	}
+	if r.Monitoring.OTLPHeaders["authorization"] != redacted || r.Exporters[0].Headers["x-api-key"] != redacted {
+		t.Errorf("header values not redacted: %v %v", r.Monitoring.OTLPHeaders, r.Exporters[0].Headers)
+	}
+	if r.ClickHouse.HTTPHeaders["X-Proxy-Token"] != redacted {
+		t.Errorf("clickhouse header values not redacted: %v", r.ClickHouse.HTTPHeaders)
+	}
+	if r.Auth.APIKeys[0].Key != redacted || r.Auth.APIKeys[0].Name != "ci" {
+		t.Errorf("api key not redacted: %+v", r.Auth.APIKeys[0])
+	}
+	if cfg.ClickHouse.Password != "secret" || cfg.Exporters[0].Headers["x-api-key"] != "key" || cfg.Auth.APIKeys[0].Key != "api-key" {
+		t.Error("Redacted modified the original config")
+	}
+}
+
+func TestHandleConfig(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.ClickHouse.Password = "hunter2"
+	r := NewReloader(cfg, nil)
+
+	rec := httptest.NewRecorder()
+	r.HandleConfig(rec, httptest.NewRequest(http.MethodGet, "/api/v1/admin/config", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d", rec.Code)
+	}
+	if strings.Contains(rec.Body.String(), "hunter2") {
+		t.Error("password shown in effective config")
+	}
+	var view struct {
+		Server     map[string]interface{} `json:"server"`
+		ClickHouse map[string]interface{} `json:"clickhouse"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &view); err != nil {
+		t.Fatal(err)
+	}
+	if view.Server["read_timeout"] != cfg.Server.ReadTimeout.String() || view.ClickHouse["password"] != redacted {
+		t.Errorf("unexpected config view %v %v", view.Server, view.ClickHouse)
+	}
+
+	rec = httptest.NewRecorder()
+	r.HandleReload(rec, httptest.NewRequest(http.MethodPost, "/api/v1/admin/config/reload", nil))
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("reload of a missing file: status = %d", rec.Code)
+	}
+}
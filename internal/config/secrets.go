@@ -0,0 +1,285 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"otelservices/internal/sigv4"
+)
+
+// secretTimeout bounds resolving all secrets of a config
+const secretTimeout = 30 * time.Second
+
+// SecretResolver returns the secret a reference names. ref is the part of
+// the reference after its scheme, e.g. CLICKHOUSE_PASSWORD for
+// env://CLICKHOUSE_PASSWORD.
+type SecretResolver func(ctx context.Context, ref string) (string, error)
+
+var (
+	secretMu        sync.RWMutex
+	secretResolvers = map[string]SecretResolver{
+		"env":   resolveEnv,
+		"file":  resolveFile,
+		"vault": resolveVault,
+		"awssm": resolveAWSSecretsManager,
+	}
+)
+
+// RegisterSecretResolver makes secret references with scheme resolvable,
+// replacing a resolver already registered for it
+func RegisterSecretResolver(scheme string, r SecretResolver) {
+	secretMu.Lock()
+	defer secretMu.Unlock()
+	secretResolvers[scheme] = r
+}
+
+// ResolveSecret returns value, or the secret it names when it is a
+// reference such as file:///run/secrets/password with a registered scheme
+func ResolveSecret(ctx context.Context, value string) (string, error) {
+	scheme, ref, ok := strings.Cut(value, "://")
+	if !ok {
+		return value, nil
+	}
+	secretMu.RLock()
+	resolve, ok := secretResolvers[scheme]
+	secretMu.RUnlock()
+	if !ok {
+		return value, nil
+	}
+	secret, err := resolve(ctx, ref)
+	if err != nil {
+		return "", fmt.Errorf("%s secret: %w", scheme, err)
+	}
+	return secret, nil
+}
+
+// secretFile is a secret setting with a companion *_file setting naming a
+// file that holds it
+type secretFile struct {
+	name  string
+	value *string
+	file  string
+}
+
+func (c *Config) secretFiles() []secretFile {
+	files := []secretFile{
+		{"clickhouse password", &c.ClickHouse.Password, c.ClickHouse.PasswordFile},
+		{"query_cache redis_password", &c.QueryCache.RedisPassword, c.QueryCache.RedisPasswordFile},
+		{"export s3 secret_access_key", &c.Export.S3.SecretAccessKey, c.Export.S3.SecretAccessKeyFile},
+	}
+	for i := range c.Auth.APIKeys {
+		k := &c.Auth.APIKeys[i]
+		files = append(files, secretFile{fmt.Sprintf("auth api key %q", k.Name), &k.Key, k.KeyFile})
+	}
+	return files
+}
+
+// applySecretFiles turns every *_file setting into a file:// reference for
+// its secret. A secret set both ways is an error.
+func (c *Config) applySecretFiles() error {
+	for _, f := range c.secretFiles() {
+		if f.file == "" {
+			continue
+		}
+		if *f.value != "" {
+			return fmt.Errorf("%s is set both directly and from a file", f.name)
+		}
+		*f.value = "file://" + f.file
+	}
+	return nil
+}
+
+// resolveSecrets replaces the secret references in the secret settings and
+// header values with the secrets they name
+func (c *Config) resolveSecrets(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, secretTimeout)
+	defer cancel()
+	for _, f := range c.secretFiles() {
+		secret, err := ResolveSecret(ctx, *f.value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.name, err)
+		}
+		*f.value = secret
+	}
+	headers := []struct {
+		name   string
+		values map[string]string
+	}{
+		{"clickhouse http_headers", c.ClickHouse.HTTPHeaders},
+		{"monitoring otlp_headers", c.Monitoring.OTLPHeaders},
+	}
+	for _, e := range c.Exporters {
+		headers = append(headers, struct {
+			name   string
+			values map[string]string
+		}{fmt.Sprintf("exporter %q headers", e.Name), e.Headers})
+	}
+	for _, h := range headers {
+		for key, value := range h.values {
+			secret, err := ResolveSecret(ctx, value)
+			if err != nil {
+				return fmt.Errorf("%s %s: %w", h.name, key, err)
+			}
+			h.values[key] = secret
+		}
+	}
+	return nil
+}
+
+// resolveEnv reads env://NAME from the environment
+func resolveEnv(_ context.Context, name string) (string, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("%s is not set", name)
+	}
+	return value, nil
+}
+
+// resolveFile reads file:///path, without a trailing newline, as mounted
+// Kubernetes and Docker secrets are
+func resolveFile(_ context.Context, path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// splitKey splits a reference into what names the secret and the #key of
+// one field of it
+func splitKey(ref string) (string, string) {
+	name, key, _ := strings.Cut(ref, "#")
+	return name, key
+}
+
+// resolveVault reads vault://path#key, a field of a HashiCorp Vault secret,
+// from VAULT_ADDR with VAULT_TOKEN. path is the API path after /v1/, e.g.
+// secret/data/otel for the otel secret of a KV version 2 engine at secret/.
+func resolveVault(ctx context.Context, ref string) (string, error) {
+	path, key := splitKey(ref)
+	if key == "" {
+		return "", fmt.Errorf("%s: missing #key", path)
+	}
+	addr := os.Getenv("VAULT_ADDR")
+	if addr == "" {
+		return "", fmt.Errorf("VAULT_ADDR is not set")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(addr, "/")+"/v1/"+strings.TrimLeft(path, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", os.Getenv("VAULT_TOKEN"))
+	if ns := os.Getenv("VAULT_NAMESPACE"); ns != "" {
+		req.Header.Set("X-Vault-Namespace", ns)
+	}
+	var resp struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := doSecretRequest(req, &resp); err != nil {
+		return "", fmt.Errorf("%s: %w", path, err)
+	}
+	fields := resp.Data
+	// KV version 2 nests the fields under data.data
+	if nested, ok := resp.Data["data"]; ok {
+		var v2 map[string]json.RawMessage
+		if json.Unmarshal(nested, &v2) == nil {
+			fields = v2
+		}
+	}
+	raw, ok := fields[key]
+	if !ok {
+		return "", fmt.Errorf("%s has no key %q", path, key)
+	}
+	var value string
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return "", fmt.Errorf("%s key %q is not a string", path, key)
+	}
+	return value, nil
+}
+
+// resolveAWSSecretsManager reads awssm://secret-id, or one #key of a JSON
+// secret, from AWS Secrets Manager. The secret ID is a name or an ARN. The
+// credentials and region come from AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY, AWS_SESSION_TOKEN and AWS_REGION, the region of
+// an ARN taking precedence; AWS_ENDPOINT_URL_SECRETS_MANAGER overrides the
+// endpoint.
+func resolveAWSSecretsManager(ctx context.Context, ref string) (string, error) {
+	id, key := splitKey(ref)
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if arn := strings.Split(id, ":"); len(arn) > 3 && arn[0] == "arn" {
+		region = arn[3]
+	}
+	if region == "" {
+		return "", fmt.Errorf("%s: AWS_REGION is not set", id)
+	}
+	creds := sigv4.Credentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return "", fmt.Errorf("%s: AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are not set", id)
+	}
+	endpoint := os.Getenv("AWS_ENDPOINT_URL_SECRETS_MANAGER")
+	if endpoint == "" {
+		endpoint = "https://secretsmanager." + region + ".amazonaws.com"
+	}
+
+	body, err := json.Marshal(map[string]string{"SecretId": id})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(endpoint, "/")+"/", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	sigv4.Sign(req, sigv4.PayloadHash(body), creds, region, "secretsmanager", time.Now())
+	var resp struct {
+		SecretString *string `json:"SecretString"`
+	}
+	if err := doSecretRequest(req, &resp); err != nil {
+		return "", fmt.Errorf("%s: %w", id, err)
+	}
+	if resp.SecretString == nil {
+		return "", fmt.Errorf("%s is a binary secret", id)
+	}
+	if key == "" {
+		return *resp.SecretString, nil
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(*resp.SecretString), &fields); err != nil {
+		return "", fmt.Errorf("%s is not a JSON secret, so has no key %q", id, key)
+	}
+	value, ok := fields[key].(string)
+	if !ok {
+		return "", fmt.Errorf("%s has no string key %q", id, key)
+	}
+	return value, nil
+}
+
+// doSecretRequest sends a secret store request and decodes its JSON response
+func doSecretRequest(req *http.Request, out interface{}) error {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,166 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolveSecret(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "password")
+	os.WriteFile(path, []byte("from-file\n"), 0o600)
+	t.Setenv("OTEL_TEST_SECRET", "from-env")
+	RegisterSecretResolver("test", func(_ context.Context, ref string) (string, error) { return "custom-" + ref, nil })
+
+	tests := []struct {
+		value, want string
+	}{
+		{"plain", "plain"},
+		{"env://OTEL_TEST_SECRET", "from-env"},
+		{"file://" + path, "from-file"},
+		{"test://name", "custom-name"},
+		{"https://example.com", "https://example.com"}, // not a secret scheme
+	}
+	for _, tt := range tests {
+		if got, err := ResolveSecret(ctx, tt.value); err != nil || got != tt.want {
+			t.Errorf("ResolveSecret(%q) = %q, %v; want %q", tt.value, got, err, tt.want)
+		}
+	}
+	for _, value := range []string{"env://OTEL_TEST_UNSET", "file://" + filepath.Join(dir, "missing")} {
+		if _, err := ResolveSecret(ctx, value); err == nil {
+			t.Errorf("ResolveSecret(%q): expected an error", value)
+		}
+	}
+}
+
+func TestLoadConfigSecrets(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "password"), []byte("s3cret\n"), 0o600)
+	t.Setenv("OTEL_TEST_TOKEN", "Bearer abc")
+	path := filepath.Join(dir, "config.yaml")
+	write := func(content string) {
+		data, err := os.ReadFile("../../configs/collector.yaml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		os.WriteFile(path, append(data, []byte(content)...), 0o600)
+	}
+
+	write(`
+exporters:
+  - name: upstream
+    endpoint: "collector:4317"
+    headers:
+      authorization: env://OTEL_TEST_TOKEN
+`)
+	t.Setenv("CLICKHOUSE_PASSWORD", "file://"+filepath.Join(dir, "password"))
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ClickHouse.Password != "s3cret" || cfg.Exporters[0].Headers["authorization"] != "Bearer abc" {
+		t.Errorf("password %q, header %q", cfg.ClickHouse.Password, cfg.Exporters[0].Headers["authorization"])
+	}
+
+	t.Setenv("CLICKHOUSE_PASSWORD", "")
+	write("\nquery_cache:\n  redis_password_file: " + filepath.Join(dir, "password") + "\n")
+	if cfg, err = LoadConfig(path); err != nil || cfg.QueryCache.RedisPassword != "s3cret" {
+		t.Errorf("redis_password_file: %q, %v", cfg.QueryCache.RedisPassword, err)
+	}
+
+	write("\nquery_cache:\n  redis_password: direct\n  redis_password_file: " + filepath.Join(dir, "password") + "\n")
+	if _, err := LoadConfig(path); err == nil || !strings.Contains(err.Error(), "set both directly and from a file") {
+		t.Errorf("password and password file: error = %v", err)
+	}
+	write("\nquery_cache:\n  redis_password: env://OTEL_TEST_UNSET\n")
+	if _, err := LoadConfig(path); err == nil || !strings.Contains(err.Error(), "query_cache redis_password: env secret") {
+		t.Errorf("unresolvable reference: error = %v", err)
+	}
+}
+
+func TestResolveVault(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "root" {
+			http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/otel":
+			w.Write([]byte(`{"data":{"data":{"password":"kv2"},"metadata":{"version":3}}}`))
+		case "/v1/kv/otel":
+			w.Write([]byte(`{"data":{"password":"kv1"}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	t.Setenv("VAULT_ADDR", srv.URL)
+	t.Setenv("VAULT_TOKEN", "root")
+
+	ctx := context.Background()
+	for ref, want := range map[string]string{"vault://secret/data/otel#password": "kv2", "vault://kv/otel#password": "kv1"} {
+		if got, err := ResolveSecret(ctx, ref); err != nil || got != want {
+			t.Errorf("%s = %q, %v", ref, got, err)
+		}
+	}
+	for ref, want := range map[string]string{
+		"vault://secret/data/otel":          "missing #key",
+		"vault://secret/data/otel#username": `no key "username"`,
+		"vault://secret/data/other#x":       "404",
+	} {
+		if _, err := ResolveSecret(ctx, ref); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: error = %v, want %q", ref, err, want)
+		}
+	}
+	t.Setenv("VAULT_TOKEN", "wrong")
+	if _, err := ResolveSecret(ctx, "vault://kv/otel#password"); err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Errorf("wrong token: error = %v", err)
+	}
+}
+
+func TestResolveAWSSecretsManager(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if r.Header.Get("X-Amz-Target") != "secretsmanager.GetSecretValue" ||
+			!strings.Contains(auth, "Credential=AKID/") || !strings.Contains(auth, "/eu-west-1/secretsmanager/aws4_request") {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		var req struct{ SecretId string }
+		json.NewDecoder(r.Body).Decode(&req)
+		switch req.SecretId {
+		case "otel/clickhouse":
+			w.Write([]byte(`{"SecretString":"{\"password\":\"json-field\"}"}`))
+		case "otel/plain":
+			w.Write([]byte(`{"SecretString":"plain-secret"}`))
+		default:
+			http.Error(w, `{"__type":"ResourceNotFoundException"}`, http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+	t.Setenv("AWS_ENDPOINT_URL_SECRETS_MANAGER", srv.URL)
+	t.Setenv("AWS_REGION", "eu-west-1")
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	ctx := context.Background()
+	for ref, want := range map[string]string{"awssm://otel/clickhouse#password": "json-field", "awssm://otel/plain": "plain-secret"} {
+		if got, err := ResolveSecret(ctx, ref); err != nil || got != want {
+			t.Errorf("%s = %q, %v", ref, got, err)
+		}
+	}
+	if _, err := ResolveSecret(ctx, "awssm://otel/missing"); err == nil || !strings.Contains(err.Error(), "ResourceNotFoundException") {
+		t.Errorf("missing secret: error = %v", err)
+	}
+	// The region of an ARN wins over AWS_REGION
+	if _, err := ResolveSecret(ctx, "awssm://arn:aws:secretsmanager:us-east-1:123456789012:secret:otel/plain"); err == nil {
+		t.Error("expected the ARN region to be signed for")
+	}
+}
@@ -0,0 +1,407 @@
+// Package diskqueue implements a persistent FIFO queue of byte records stored
+// in append-only segment files. Append is safe for concurrent use; Next and
+// Commit belong to a single reader. Records stay on disk until the reader
+// commits past them, so anything not committed before a crash is read again
+// on restart.
+package diskqueue
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	segmentSuffix  = ".seg"
+	checkpointFile = "checkpoint"
+	headerSize     = 8 // uint32 length + uint32 CRC-32C
+)
+
+var (
+	// ErrFull is returned by Append when the record would exceed MaxBytes
+	ErrFull = errors.New("disk queue is full")
+	// ErrCorrupt is returned by Next when a record fails its checksum. The
+	// rest of the segment is skipped.
+	ErrCorrupt = errors.New("disk queue record is corrupt")
+	// ErrClosed is returned after Close
+	ErrClosed = errors.New("disk queue is closed")
+
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// Options bounds the queue size on disk
+type Options struct {
+	SegmentBytes int64 // size at which a new segment file is started
+	MaxBytes     int64 // limit on unread data; 0 means unlimited
+}
+
+// Position identifies the end of a record returned by Next
+type Position struct {
+	Segment uint64
+	Offset  int64
+}
+
+// Queue is a persistent FIFO queue in a directory
+type Queue struct {
+	dir  string
+	opts Options
+
+	mu        sync.Mutex
+	closed    bool
+	segSizes  map[uint64]int64
+	writeSeg  uint64
+	writeFile *os.File
+	committed Position
+	notify    chan struct{}
+
+	// reader state, owned by the goroutine calling Next
+	read     Position
+	readFile *os.File
+}
+
+// Open opens or creates the queue in dir. A record cut short by a crash at
+// the end of the last segment is discarded.
+func Open(dir string, opts Options) (*Queue, error) {
+	if opts.SegmentBytes <= 0 {
+		opts.SegmentBytes = 64 << 20
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create queue directory: %w", err)
+	}
+
+	q := &Queue{
+		dir:      dir,
+		opts:     opts,
+		segSizes: make(map[uint64]int64),
+		notify:   make(chan struct{}, 1),
+	}
+	if err := q.loadCheckpoint(); err != nil {
+		return nil, err
+	}
+
+	segments, err := q.listSegments()
+	if err != nil {
+		return nil, err
+	}
+	for _, seg := range segments {
+		if seg < q.committed.Segment {
+			// Left behind by a crash between checkpoint and cleanup
+			os.Remove(q.segmentPath(seg))
+			continue
+		}
+		info, err := os.Stat(q.segmentPath(seg))
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat segment %d: %w", seg, err)
+		}
+		q.segSizes[seg] = info.Size()
+		q.writeSeg = seg
+	}
+	if len(q.segSizes) == 0 {
+		q.writeSeg = q.committed.Segment
+	}
+
+	if err := q.openWriteSegment(); err != nil {
+		return nil, err
+	}
+	if size := q.segSizes[q.committed.Segment]; q.committed.Offset > size {
+		q.committed.Offset = size
+	}
+	q.read = q.committed
+	return q, nil
+}
+
+func (q *Queue) segmentPath(seg uint64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%020d%s", seg, segmentSuffix))
+}
+
+func (q *Queue) listSegments() ([]uint64, error) {
+	entries, err := os.ReadDir(q.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read queue directory: %w", err)
+	}
+	var segments []uint64
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+		seg, err := strconv.ParseUint(strings.TrimSuffix(name, segmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, seg)
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i] < segments[j] })
+	return segments, nil
+}
+
+func (q *Queue) loadCheckpoint() error {
+	data, err := os.ReadFile(filepath.Join(q.dir, checkpointFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+	if len(data) != 16 {
+		return fmt.Errorf("invalid checkpoint of %d bytes", len(data))
+	}
+	q.committed = Position{
+		Segment: binary.BigEndian.Uint64(data[:8]),
+		Offset:  int64(binary.BigEndian.Uint64(data[8:])),
+	}
+	return nil
+}
+
+// openWriteSegment opens the last segment for appending, truncating a torn
+// record at its end
+func (q *Queue) openWriteSegment() error {
+	f, err := os.OpenFile(q.segmentPath(q.writeSeg), os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open segment %d: %w", q.writeSeg, err)
+	}
+	valid, err := validLength(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if valid != q.segSizes[q.writeSeg] {
+		if err := f.Truncate(valid); err != nil {
+			f.Close()
+			return fmt.Errorf("failed to truncate segment %d: %w", q.writeSeg, err)
+		}
+	}
+	if _, err := f.Seek(valid, io.SeekStart); err != nil {
+		f.Close()
+		return err
+	}
+	q.writeFile = f
+	q.segSizes[q.writeSeg] = valid
+	return nil
+}
+
+// validLength returns the length of the longest prefix of whole records
+func validLength(f *os.File) (int64, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	var off int64
+	header := make([]byte, headerSize)
+	for off+headerSize <= info.Size() {
+		if _, err := f.ReadAt(header, off); err != nil {
+			return 0, err
+		}
+		n := int64(binary.BigEndian.Uint32(header[:4]))
+		if off+headerSize+n > info.Size() {
+			break
+		}
+		data := make([]byte, n)
+		if _, err := f.ReadAt(data, off+headerSize); err != nil {
+			return 0, err
+		}
+		if crc32.Checksum(data, crcTable) != binary.BigEndian.Uint32(header[4:]) {
+			break
+		}
+		off += headerSize + n
+	}
+	return off, nil
+}
+
+// pendingLocked returns the bytes appended but not yet committed
+func (q *Queue) pendingLocked() int64 {
+	var total int64
+	for seg, size := range q.segSizes {
+		if seg >= q.committed.Segment {
+			total += size
+		}
+	}
+	return total - q.committed.Offset
+}
+
+// Size returns the bytes appended but not yet committed
+func (q *Queue) Size() int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.pendingLocked()
+}
+
+// Append writes a record and syncs it to disk
+func (q *Queue) Append(data []byte) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return ErrClosed
+	}
+
+	recordSize := int64(headerSize + len(data))
+	if q.opts.MaxBytes > 0 && q.pendingLocked()+recordSize > q.opts.MaxBytes {
+		return ErrFull
+	}
+
+	if size := q.segSizes[q.writeSeg]; size > 0 && size+recordSize > q.opts.SegmentBytes {
+		if err := q.writeFile.Close(); err != nil {
+			return fmt.Errorf("failed to close segment %d: %w", q.writeSeg, err)
+		}
+		q.writeSeg++
+		if err := q.openWriteSegment(); err != nil {
+			return err
+		}
+	}
+
+	record := make([]byte, recordSize)
+	binary.BigEndian.PutUint32(record[:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.Checksum(data, crcTable))
+	copy(record[headerSize:], data)
+	if _, err := q.writeFile.Write(record); err != nil {
+		q.rollbackLocked()
+		return fmt.Errorf("failed to write record: %w", err)
+	}
+	if err := q.writeFile.Sync(); err != nil {
+		q.rollbackLocked()
+		return fmt.Errorf("failed to sync segment %d: %w", q.writeSeg, err)
+	}
+	q.segSizes[q.writeSeg] += recordSize
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// rollbackLocked drops a partially written record so the segment stays
+// readable
+func (q *Queue) rollbackLocked() {
+	q.writeFile.Truncate(q.segSizes[q.writeSeg])
+	q.writeFile.Seek(q.segSizes[q.writeSeg], io.SeekStart)
+}
+
+// Next returns the next record, blocking until one is available or ctx is
+// done. The returned position is passed to Commit once the record has been
+// handled.
+func (q *Queue) Next(ctx context.Context) ([]byte, Position, error) {
+	for {
+		q.mu.Lock()
+		closed := q.closed
+		writeSeg := q.writeSeg
+		end := q.segSizes[q.read.Segment]
+		q.mu.Unlock()
+		if closed {
+			return nil, Position{}, ErrClosed
+		}
+
+		if q.read.Offset < end {
+			return q.readRecord(end)
+		}
+		if q.read.Segment < writeSeg {
+			// Finished a sealed segment
+			q.advanceSegment()
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, Position{}, ctx.Err()
+		case <-q.notify:
+		}
+	}
+}
+
+func (q *Queue) advanceSegment() {
+	if q.readFile != nil {
+		q.readFile.Close()
+		q.readFile = nil
+	}
+	q.read = Position{Segment: q.read.Segment + 1}
+}
+
+// readRecord reads the record at the read position of a segment whose
+// readable length is end
+func (q *Queue) readRecord(end int64) ([]byte, Position, error) {
+	if q.readFile == nil {
+		f, err := os.Open(q.segmentPath(q.read.Segment))
+		if err != nil {
+			return nil, Position{}, fmt.Errorf("failed to open segment %d: %w", q.read.Segment, err)
+		}
+		q.readFile = f
+	}
+
+	header := make([]byte, headerSize)
+	if _, err := q.readFile.ReadAt(header, q.read.Offset); err != nil {
+		return nil, Position{}, fmt.Errorf("failed to read segment %d: %w", q.read.Segment, err)
+	}
+	n := int64(binary.BigEndian.Uint32(header[:4]))
+	if q.read.Offset+headerSize+n > end {
+		return nil, Position{}, q.skipCorrupt(end)
+	}
+	data := make([]byte, n)
+	if _, err := q.readFile.ReadAt(data, q.read.Offset+headerSize); err != nil {
+		return nil, Position{}, fmt.Errorf("failed to read segment %d: %w", q.read.Segment, err)
+	}
+	if crc32.Checksum(data, crcTable) != binary.BigEndian.Uint32(header[4:]) {
+		return nil, Position{}, q.skipCorrupt(end)
+	}
+
+	q.read.Offset += headerSize + n
+	return data, q.read, nil
+}
+
+// skipCorrupt moves the reader past the rest of the readable segment,
+// since record boundaries after a bad record cannot be trusted
+func (q *Queue) skipCorrupt(end int64) error {
+	err := fmt.Errorf("%w: segment %d offset %d", ErrCorrupt, q.read.Segment, q.read.Offset)
+	q.read.Offset = end
+	return err
+}
+
+// Commit records that everything up to pos has been handled and removes
+// segments that are no longer needed
+func (q *Queue) Commit(pos Position) error {
+	var buf [16]byte
+	binary.BigEndian.PutUint64(buf[:8], pos.Segment)
+	binary.BigEndian.PutUint64(buf[8:], uint64(pos.Offset))
+
+	tmp := filepath.Join(q.dir, checkpointFile+".tmp")
+	if err := os.WriteFile(tmp, buf[:], 0o644); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(q.dir, checkpointFile)); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.committed = pos
+	for seg := range q.segSizes {
+		if seg < pos.Segment {
+			delete(q.segSizes, seg)
+			os.Remove(q.segmentPath(seg))
+		}
+	}
+	return nil
+}
+
+// Close closes the queue files. It must not run concurrently with Next.
+// Uncommitted records are read again by the next Open.
+func (q *Queue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return nil
+	}
+	q.closed = true
+	if q.readFile != nil {
+		q.readFile.Close()
+	}
+	return q.writeFile.Close()
+}
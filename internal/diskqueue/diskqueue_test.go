@@ -0,0 +1,193 @@
+package diskqueue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func openQueue(t *testing.T, dir string, opts Options) *Queue {
+	t.Helper()
+	q, err := Open(dir, opts)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	return q
+}
+
+func next(t *testing.T, q *Queue) ([]byte, Position) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	data, pos, err := q.Next(ctx)
+	if err != nil {
+		t.Fatalf("Next failed: %v", err)
+	}
+	return data, pos
+}
+
+func TestAppendAndNext(t *testing.T) {
+	q := openQueue(t, t.TempDir(), Options{})
+	defer q.Close()
+
+	for i := 0; i < 3; i++ {
+		if err := q.Append([]byte(fmt.Sprintf("record-%d", i))); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		data, pos := next(t, q)
+		if want := fmt.Sprintf("record-%d", i); string(data) != want {
+			t.Errorf("record %d = %q, want %q", i, data, want)
+		}
+		if err := q.Commit(pos); err != nil {
+			t.Fatalf("Commit failed: %v", err)
+		}
+	}
+	if size := q.Size(); size != 0 {
+		t.Errorf("Size() = %d after committing everything, want 0", size)
+	}
+}
+
+func TestNextBlocksUntilAppend(t *testing.T) {
+	q := openQueue(t, t.TempDir(), Options{})
+	defer q.Close()
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		q.Append([]byte("late"))
+	}()
+
+	data, _ := next(t, q)
+	if string(data) != "late" {
+		t.Errorf("got %q, want late", data)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, _, err := q.Next(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Next on empty queue returned %v, want deadline exceeded", err)
+	}
+}
+
+func TestReplayUncommittedAfterReopen(t *testing.T) {
+	dir := t.TempDir()
+	q := openQueue(t, dir, Options{SegmentBytes: 32})
+	for i := 0; i < 5; i++ {
+		if err := q.Append([]byte(fmt.Sprintf("record-%d", i))); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+	_, pos := next(t, q)
+	_, _ = next(t, q) // read but not committed
+	if err := q.Commit(pos); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	q.Close()
+
+	q = openQueue(t, dir, Options{SegmentBytes: 32})
+	defer q.Close()
+	for i := 1; i < 5; i++ {
+		data, _ := next(t, q)
+		if want := fmt.Sprintf("record-%d", i); string(data) != want {
+			t.Errorf("replayed %q, want %q", data, want)
+		}
+	}
+}
+
+func TestCommitRemovesSegments(t *testing.T) {
+	dir := t.TempDir()
+	q := openQueue(t, dir, Options{SegmentBytes: 32})
+	defer q.Close()
+
+	for i := 0; i < 4; i++ {
+		q.Append([]byte("0123456789abcdef"))
+	}
+	var pos Position
+	for i := 0; i < 4; i++ {
+		_, pos = next(t, q)
+	}
+	if err := q.Commit(pos); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+
+	segments, _ := filepath.Glob(filepath.Join(dir, "*"+segmentSuffix))
+	if len(segments) != 1 {
+		t.Errorf("expected only the current segment to remain, got %v", segments)
+	}
+}
+
+func TestAppendFull(t *testing.T) {
+	q := openQueue(t, t.TempDir(), Options{MaxBytes: 40})
+	defer q.Close()
+
+	if err := q.Append(make([]byte, 20)); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	if err := q.Append(make([]byte, 20)); !errors.Is(err, ErrFull) {
+		t.Fatalf("Append over MaxBytes returned %v, want ErrFull", err)
+	}
+
+	_, pos := next(t, q)
+	q.Commit(pos)
+	if err := q.Append(make([]byte, 20)); err != nil {
+		t.Errorf("Append after commit failed: %v", err)
+	}
+}
+
+func TestTornRecordDiscardedOnOpen(t *testing.T) {
+	dir := t.TempDir()
+	q := openQueue(t, dir, Options{})
+	q.Append([]byte("complete"))
+	q.Close()
+
+	// Simulate a crash in the middle of writing the next record
+	f, err := os.OpenFile(filepath.Join(dir, fmt.Sprintf("%020d%s", 0, segmentSuffix)), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0, 0, 0, 100, 1, 2, 3, 4, 'p', 'a', 'r'})
+	f.Close()
+
+	q = openQueue(t, dir, Options{})
+	defer q.Close()
+	data, _ := next(t, q)
+	if string(data) != "complete" {
+		t.Errorf("got %q, want complete", data)
+	}
+	if err := q.Append([]byte("after")); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	if data, _ := next(t, q); string(data) != "after" {
+		t.Errorf("got %q, want after", data)
+	}
+}
+
+func TestCorruptRecordSkipsSegment(t *testing.T) {
+	dir := t.TempDir()
+	q := openQueue(t, dir, Options{SegmentBytes: 40})
+	q.Append([]byte("first"))
+	q.Append([]byte("second"))
+	q.Append([]byte("0123456789abcdefghijklmnop")) // starts segment 1
+	q.Close()
+
+	// Flip a byte in the first record's payload
+	path := filepath.Join(dir, fmt.Sprintf("%020d%s", 0, segmentSuffix))
+	data, _ := os.ReadFile(path)
+	data[headerSize] ^= 0xff
+	os.WriteFile(path, data, 0o644)
+
+	q = openQueue(t, dir, Options{SegmentBytes: 40})
+	defer q.Close()
+	if _, _, err := q.Next(context.Background()); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("Next returned %v, want ErrCorrupt", err)
+	}
+	if got, _ := next(t, q); string(got) != "0123456789abcdefghijklmnop" {
+		t.Errorf("got %q after corrupt segment, want record from segment 1", got)
+	}
+}
@@ -0,0 +1,321 @@
+// Package geoip looks up the location of IP addresses in MaxMind DB (MMDB)
+// files such as GeoLite2 City or GeoIP2 City. The file format is decoded
+// directly rather than through the MaxMind library, so the build has no extra
+// dependencies. Only the country and city of a record are read.
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"sync"
+)
+
+// metadataMarker precedes the metadata map at the end of every MMDB file
+var metadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// dataSectionSeparator is the run of zero bytes between the search tree and
+// the data section
+const dataSectionSeparator = 16
+
+// maxNesting bounds the depth of decoded maps and arrays, so a corrupt file
+// cannot recurse without end
+const maxNesting = 32
+
+// MMDB data field types
+const (
+	typeExtended = 0
+	typePointer  = 1
+	typeString   = 2
+	typeDouble   = 3
+	typeBytes    = 4
+	typeUint16   = 5
+	typeUint32   = 6
+	typeMap      = 7
+	typeInt32    = 8
+	typeUint64   = 9
+	typeUint128  = 10
+	typeArray    = 11
+	typeBool     = 14
+	typeFloat    = 15
+)
+
+// Location is what a database records for an address. Names are English.
+type Location struct {
+	CountryISOCode string
+	CountryName    string
+	CityName       string
+}
+
+// Reader looks up addresses in a database held in memory. It is safe for
+// concurrent use.
+type Reader struct {
+	tree       []byte
+	data       decoder
+	nodeCount  uint
+	recordSize uint // bits per record, two records per node
+	ipVersion  uint
+	ipv4Start  uint // node reached by the 96 zero bits of an IPv4-mapped address
+
+	// Many networks share a record, so decoded records are kept by offset
+	locations sync.Map
+}
+
+// Open reads a database file
+func Open(path string) (*Reader, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return New(data)
+}
+
+// New parses a database held in memory
+func New(data []byte) (*Reader, error) {
+	i := bytes.LastIndex(data, metadataMarker)
+	if i < 0 {
+		return nil, fmt.Errorf("not a MaxMind DB file: no metadata")
+	}
+	raw, _, err := decoder{buf: data[i+len(metadataMarker):]}.decode(0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid metadata: %w", err)
+	}
+	metadata, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid metadata: not a map")
+	}
+	r := &Reader{
+		nodeCount:  uintField(metadata, "node_count"),
+		recordSize: uintField(metadata, "record_size"),
+		ipVersion:  uintField(metadata, "ip_version"),
+	}
+	if r.recordSize != 24 && r.recordSize != 28 && r.recordSize != 32 {
+		return nil, fmt.Errorf("unsupported record size %d", r.recordSize)
+	}
+	if r.ipVersion != 4 && r.ipVersion != 6 {
+		return nil, fmt.Errorf("unsupported IP version %d", r.ipVersion)
+	}
+	treeSize := r.nodeCount * r.recordSize / 4
+	if treeSize+dataSectionSeparator > uint(i) {
+		return nil, fmt.Errorf("search tree of %d nodes exceeds the file", r.nodeCount)
+	}
+	r.tree = data[:treeSize]
+	r.data = decoder{buf: data[treeSize+dataSectionSeparator : i]}
+	if r.ipVersion == 6 {
+		for n := 0; n < 96 && r.ipv4Start < r.nodeCount; n++ {
+			r.ipv4Start = r.record(r.ipv4Start, 0)
+		}
+	}
+	return r, nil
+}
+
+func uintField(m map[string]interface{}, key string) uint {
+	v, _ := m[key].(uint64)
+	return uint(v)
+}
+
+// Lookup returns the location recorded for ip; ok is false when the
+// database has no record for it
+func (r *Reader) Lookup(ip net.IP) (loc Location, ok bool, err error) {
+	node := uint(0)
+	bits := ip.To4()
+	switch {
+	case bits != nil && r.ipVersion == 6:
+		node = r.ipv4Start
+	case bits == nil && r.ipVersion == 4:
+		return Location{}, false, nil
+	case bits == nil:
+		if bits = ip.To16(); bits == nil {
+			return Location{}, false, nil
+		}
+	}
+	for i := 0; i < len(bits)*8 && node < r.nodeCount; i++ {
+		node = r.record(node, uint(bits[i/8]>>(7-i%8))&1)
+	}
+	if node <= r.nodeCount {
+		return Location{}, false, nil
+	}
+
+	offset := node - r.nodeCount - dataSectionSeparator
+	if cached, ok := r.locations.Load(offset); ok {
+		return cached.(Location), true, nil
+	}
+	raw, _, err := r.data.decode(offset, 0)
+	if err != nil {
+		return Location{}, false, err
+	}
+	record, _ := raw.(map[string]interface{})
+	loc = Location{
+		CountryISOCode: stringAt(record, "country", "iso_code"),
+		CountryName:    stringAt(record, "country", "names", "en"),
+		CityName:       stringAt(record, "city", "names", "en"),
+	}
+	r.locations.Store(offset, loc)
+	return loc, true, nil
+}
+
+// record reads the left (bit 0) or right record of a search tree node
+func (r *Reader) record(node, bit uint) uint {
+	base := node * r.recordSize / 4
+	b := r.tree[base:]
+	switch r.recordSize {
+	case 24:
+		b = b[bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		if bit == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	}
+	return uint(binary.BigEndian.Uint32(b[bit*4:]))
+}
+
+// stringAt follows keys through nested maps to a string
+func stringAt(m map[string]interface{}, keys ...string) string {
+	var v interface{} = m
+	for _, key := range keys {
+		inner, ok := v.(map[string]interface{})
+		if !ok {
+			return ""
+		}
+		v = inner[key]
+	}
+	s, _ := v.(string)
+	return s
+}
+
+// decoder reads values of the MMDB data format. Pointers are offsets into
+// buf.
+type decoder struct {
+	buf []byte
+}
+
+func (d decoder) bytes(offset, n uint) ([]byte, error) {
+	if offset+n > uint(len(d.buf)) || offset+n < offset {
+		return nil, fmt.Errorf("field at offset %d runs past the data", offset)
+	}
+	return d.buf[offset : offset+n], nil
+}
+
+// decode returns the value at offset and the offset following it
+func (d decoder) decode(offset uint, depth int) (interface{}, uint, error) {
+	if depth > maxNesting {
+		return nil, 0, fmt.Errorf("values nested too deep")
+	}
+	ctrl, err := d.bytes(offset, 1)
+	if err != nil {
+		return nil, 0, err
+	}
+	offset++
+	typ := uint(ctrl[0] >> 5)
+
+	if typ == typePointer {
+		size := uint(ctrl[0]>>3) & 0x3
+		b, err := d.bytes(offset, size+1)
+		if err != nil {
+			return nil, 0, err
+		}
+		target := uint(0)
+		if size < 3 {
+			target = uint(ctrl[0] & 0x7)
+		}
+		for _, x := range b {
+			target = target<<8 | uint(x)
+		}
+		target += [4]uint{0, 2048, 526336, 0}[size]
+		v, _, err := d.decode(target, depth+1)
+		return v, offset + size + 1, err
+	}
+	if typ == typeExtended {
+		b, err := d.bytes(offset, 1)
+		if err != nil {
+			return nil, 0, err
+		}
+		typ = 7 + uint(b[0])
+		offset++
+	}
+
+	size := uint(ctrl[0] & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		b, err := d.bytes(offset, n)
+		if err != nil {
+			return nil, 0, err
+		}
+		offset += n
+		size = 0
+		for _, x := range b {
+			size = size<<8 | uint(x)
+		}
+		size += [3]uint{29, 285, 65821}[n-1]
+	}
+
+	switch typ {
+	case typeMap:
+		m := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			var key, value interface{}
+			if key, offset, err = d.decode(offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+			if value, offset, err = d.decode(offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+			k, ok := key.(string)
+			if !ok {
+				return nil, 0, fmt.Errorf("map key is not a string")
+			}
+			m[k] = value
+		}
+		return m, offset, nil
+	case typeArray:
+		items := make([]interface{}, size)
+		for i := range items {
+			if items[i], offset, err = d.decode(offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+		}
+		return items, offset, nil
+	case typeBool:
+		return size != 0, offset, nil
+	}
+
+	b, err := d.bytes(offset, size)
+	if err != nil {
+		return nil, 0, err
+	}
+	offset += size
+	switch typ {
+	case typeString:
+		return string(b), offset, nil
+	case typeBytes, typeUint128:
+		return append([]byte(nil), b...), offset, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, fmt.Errorf("double of %d bytes", size)
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), offset, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, fmt.Errorf("float of %d bytes", size)
+		}
+		return math.Float32frombits(binary.BigEndian.Uint32(b)), offset, nil
+	case typeUint16, typeUint32, typeUint64:
+		v := uint64(0)
+		for _, x := range b {
+			v = v<<8 | uint64(x)
+		}
+		return v, offset, nil
+	case typeInt32:
+		v := uint32(0)
+		for _, x := range b {
+			v = v<<8 | uint32(x)
+		}
+		return int32(v), offset, nil
+	}
+	return nil, 0, fmt.Errorf("unknown field type %d", typ)
+}
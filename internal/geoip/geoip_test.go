@@ -0,0 +1,152 @@
+package geoip
+
+import (
+	"bytes"
+	"net"
+	"sort"
+	"testing"
+)
+
+// encoder writes values of the MMDB data format
+type encoder struct {
+	bytes.Buffer
+}
+
+func (e *encoder) header(typ byte, size int) {
+	if typ > 7 {
+		e.WriteByte(byte(size))
+		e.WriteByte(typ - 7)
+		return
+	}
+	e.WriteByte(typ<<5 | byte(size))
+}
+
+func (e *encoder) value(v interface{}) {
+	switch v := v.(type) {
+	case string:
+		e.header(typeString, len(v))
+		e.WriteString(v)
+	case uint16:
+		e.header(typeUint16, 2)
+		e.Write([]byte{byte(v >> 8), byte(v)})
+	case uint32:
+		e.header(typeUint32, 4)
+		e.Write([]byte{byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)})
+	case bool:
+		size := 0
+		if v {
+			size = 1
+		}
+		e.header(typeBool, size)
+	case []interface{}:
+		e.header(typeArray, len(v))
+		for _, item := range v {
+			e.value(item)
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		e.header(typeMap, len(v))
+		for _, k := range keys {
+			e.value(k)
+			e.value(v[k])
+		}
+	}
+}
+
+// buildDatabase writes an IPv4 database with 24-bit records in which every
+// address of network has record and every other address has none
+func buildDatabase(network *net.IPNet, record map[string]interface{}) []byte {
+	ones, _ := network.Mask.Size()
+	ip := network.IP.To4()
+	nodeCount := ones
+	empty := uint32(nodeCount)
+	dataRecord := uint32(nodeCount + dataSectionSeparator) // data offset 0
+
+	var tree bytes.Buffer
+	write := func(v uint32) { tree.Write([]byte{byte(v >> 16), byte(v >> 8), byte(v)}) }
+	for i := 0; i < ones; i++ {
+		next := uint32(i + 1)
+		if i == ones-1 {
+			next = dataRecord
+		}
+		if ip[i/8]>>(7-i%8)&1 == 0 {
+			write(next)
+			write(empty)
+		} else {
+			write(empty)
+			write(next)
+		}
+	}
+
+	var data encoder
+	data.value(record)
+	var metadata encoder
+	metadata.value(map[string]interface{}{
+		"node_count":    uint32(nodeCount),
+		"record_size":   uint16(24),
+		"ip_version":    uint16(4),
+		"database_type": "GeoLite2-City",
+		"languages":     []interface{}{"en"},
+	})
+
+	var file bytes.Buffer
+	file.Write(tree.Bytes())
+	file.Write(make([]byte, dataSectionSeparator))
+	file.Write(data.Bytes())
+	file.Write(metadataMarker)
+	file.Write(metadata.Bytes())
+	return file.Bytes()
+}
+
+func TestLookup(t *testing.T) {
+	_, network, _ := net.ParseCIDR("81.2.69.0/24")
+	db := buildDatabase(network, map[string]interface{}{
+		"city":      map[string]interface{}{"names": map[string]interface{}{"en": "London", "de": "London"}},
+		"country":   map[string]interface{}{"iso_code": "GB", "names": map[string]interface{}{"en": "United Kingdom"}, "is_in_european_union": false},
+		"continent": map[string]interface{}{"code": "EU"},
+	})
+	r, err := New(db)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	want := Location{CountryISOCode: "GB", CountryName: "United Kingdom", CityName: "London"}
+	for i := 0; i < 2; i++ { // the second lookup is served from the cache
+		loc, ok, err := r.Lookup(net.ParseIP("81.2.69.142"))
+		if err != nil || !ok || loc != want {
+			t.Errorf("Lookup() = %+v, %v, %v, want %+v", loc, ok, err, want)
+		}
+	}
+	for _, ip := range []string{"81.2.70.1", "10.0.0.1", "2001:db8::1"} {
+		if loc, ok, err := r.Lookup(net.ParseIP(ip)); ok || err != nil {
+			t.Errorf("Lookup(%s) = %+v, %v, %v, want no record", ip, loc, ok, err)
+		}
+	}
+}
+
+func TestNewRejectsInvalidFiles(t *testing.T) {
+	if _, err := New([]byte("not a database")); err == nil {
+		t.Error("expected error without metadata")
+	}
+	_, network, _ := net.ParseCIDR("81.2.69.0/24")
+	db := buildDatabase(network, map[string]interface{}{})
+	if _, err := New(db[20:]); err == nil {
+		t.Error("expected error for a truncated search tree")
+	}
+}
+
+func TestDecodePointer(t *testing.T) {
+	// A map whose value points back at the string at offset 0
+	buf := []byte{typeString<<5 | 2, 'G', 'B', typeMap<<5 | 1, typeString<<5 | 1, 'k', typePointer << 5, 0}
+	v, next, err := decoder{buf: buf}.decode(3, 0)
+	if err != nil || next != uint(len(buf)) {
+		t.Fatalf("decode() = %v, %d, %v", v, next, err)
+	}
+	if m := v.(map[string]interface{}); m["k"] != "GB" {
+		t.Errorf("decode() = %v", m)
+	}
+}
@@ -0,0 +1,273 @@
+package kafka
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"time"
+
+	"otelservices/internal/config"
+	"otelservices/internal/logging"
+	"otelservices/internal/models"
+	"otelservices/internal/monitoring"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Signal names used as topic suffixes
+const (
+	SignalTraces  = "traces"
+	SignalMetrics = "metrics"
+	SignalLogs    = "logs"
+)
+
+const (
+	retryInitialInterval = 1 * time.Second
+	retryMaxInterval     = 30 * time.Second
+)
+
+// logger is the component logger of the Kafka consumers
+var logger = logging.For("kafka")
+
+// Topic returns the topic name for a signal
+func Topic(cfg *config.KafkaConfig, signal string) string {
+	return cfg.TopicPrefix + "-" + signal
+}
+
+// Producer publishes encoded batches to per-signal topics
+type Producer struct {
+	writer   *kafka.Writer
+	config   *config.KafkaConfig
+	maxBytes int
+}
+
+// NewProducer creates a new Kafka producer
+func NewProducer(cfg *config.KafkaConfig) (*Producer, error) {
+	compression, err := compressionCodec(cfg.Compression)
+	if err != nil {
+		return nil, err
+	}
+
+	maxBytes := cfg.MaxMessageBytes
+	if maxBytes <= 0 {
+		maxBytes = 1024 * 1024
+	}
+
+	return &Producer{
+		writer: &kafka.Writer{
+			Addr:         kafka.TCP(cfg.Brokers...),
+			Balancer:     &kafka.LeastBytes{},
+			RequiredAcks: kafka.RequireAll,
+			Compression:  compression,
+			BatchBytes:   int64(maxBytes),
+			BatchTimeout: 10 * time.Millisecond,
+		},
+		config:   cfg,
+		maxBytes: maxBytes,
+	}, nil
+}
+
+func compressionCodec(name string) (kafka.Compression, error) {
+	switch name {
+	case "", "none":
+		return 0, nil
+	case "gzip":
+		return kafka.Gzip, nil
+	case "snappy":
+		return kafka.Snappy, nil
+	case "lz4":
+		return kafka.Lz4, nil
+	case "zstd":
+		return kafka.Zstd, nil
+	}
+	return 0, fmt.Errorf("unsupported kafka compression %q", name)
+}
+
+// Ping checks that one of the brokers answers a metadata request
+func Ping(ctx context.Context, brokers []string) error {
+	err := fmt.Errorf("no brokers configured")
+	for _, broker := range brokers {
+		var conn *kafka.Conn
+		conn, err = kafka.DialContext(ctx, "tcp", broker)
+		if err != nil {
+			continue
+		}
+		if deadline, ok := ctx.Deadline(); ok {
+			conn.SetDeadline(deadline)
+		}
+		_, err = conn.Brokers()
+		conn.Close()
+		if err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("no Kafka broker answered: %w", err)
+}
+
+// Close flushes pending messages and closes the producer
+func (p *Producer) Close() error {
+	return p.writer.Close()
+}
+
+// PublishSpans publishes a batch of spans
+func (p *Producer) PublishSpans(ctx context.Context, spans []models.Span) error {
+	return publish(ctx, p, SignalTraces, spans)
+}
+
+// PublishMetrics publishes a batch of metrics
+func (p *Producer) PublishMetrics(ctx context.Context, metrics []models.Metric) error {
+	return publish(ctx, p, SignalMetrics, metrics)
+}
+
+// PublishLogs publishes a batch of log records
+func (p *Producer) PublishLogs(ctx context.Context, logs []models.LogRecord) error {
+	return publish(ctx, p, SignalLogs, logs)
+}
+
+func publish[T any](ctx context.Context, p *Producer, signal string, items []T) error {
+	if len(items) == 0 {
+		return nil
+	}
+
+	payloads, err := encodeBatch(items, p.maxBytes)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s batch: %w", signal, err)
+	}
+
+	topic := Topic(p.config, signal)
+	msgs := make([]kafka.Message, len(payloads))
+	for i, payload := range payloads {
+		msgs[i] = kafka.Message{Topic: topic, Value: payload}
+	}
+
+	if err := p.writer.WriteMessages(ctx, msgs...); err != nil {
+		monitoring.KafkaMessages.WithLabelValues(topic, "publish_error").Add(float64(len(msgs)))
+		return fmt.Errorf("failed to publish to %s: %w", topic, err)
+	}
+	monitoring.KafkaMessages.WithLabelValues(topic, "published").Add(float64(len(msgs)))
+	return nil
+}
+
+// encodeBatch gob-encodes items (gob, unlike JSON, preserves NaN and Inf
+// metric values), splitting the batch until every message
+// fits in maxBytes. A single item larger than maxBytes is still returned on
+// its own and left for the broker to reject.
+func encodeBatch[T any](items []T, maxBytes int) ([][]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(items); err != nil {
+		return nil, err
+	}
+	data := buf.Bytes()
+	if len(data) <= maxBytes || len(items) == 1 {
+		return [][]byte{data}, nil
+	}
+
+	mid := len(items) / 2
+	left, err := encodeBatch(items[:mid], maxBytes)
+	if err != nil {
+		return nil, err
+	}
+	right, err := encodeBatch(items[mid:], maxBytes)
+	if err != nil {
+		return nil, err
+	}
+	return append(left, right...), nil
+}
+
+// DecodeSpans decodes a message published by PublishSpans
+func DecodeSpans(data []byte) ([]models.Span, error) {
+	var spans []models.Span
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&spans)
+	return spans, err
+}
+
+// DecodeMetrics decodes a message published by PublishMetrics
+func DecodeMetrics(data []byte) ([]models.Metric, error) {
+	var metrics []models.Metric
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&metrics)
+	return metrics, err
+}
+
+// DecodeLogs decodes a message published by PublishLogs
+func DecodeLogs(data []byte) ([]models.LogRecord, error) {
+	var logs []models.LogRecord
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&logs)
+	return logs, err
+}
+
+// Consumer reads batches for one signal as part of the consumer group
+type Consumer struct {
+	reader *kafka.Reader
+	topic  string
+}
+
+// NewConsumer creates a consumer for the given signal's topic
+func NewConsumer(cfg *config.KafkaConfig, signal string) *Consumer {
+	topic := Topic(cfg, signal)
+	maxBytes := cfg.MaxMessageBytes
+	if maxBytes <= 0 {
+		maxBytes = 1024 * 1024
+	}
+	return &Consumer{
+		reader: kafka.NewReader(kafka.ReaderConfig{
+			Brokers:  cfg.Brokers,
+			GroupID:  cfg.ConsumerGroup,
+			Topic:    topic,
+			MinBytes: 1,
+			MaxBytes: maxBytes * 2,
+			MaxWait:  500 * time.Millisecond,
+		}),
+		topic: topic,
+	}
+}
+
+// Run fetches messages until ctx is cancelled. A message is committed only
+// after handle succeeds; failures are retried with backoff so a ClickHouse
+// outage leaves data in Kafka instead of dropping it.
+func (c *Consumer) Run(ctx context.Context, handle func(ctx context.Context, value []byte) error) error {
+	for {
+		msg, err := c.reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to fetch from %s: %w", c.topic, err)
+		}
+		monitoring.KafkaConsumerLag.WithLabelValues(c.topic).Set(float64(msg.HighWaterMark - msg.Offset - 1))
+
+		backoff := retryInitialInterval
+		for {
+			err := handle(ctx, msg.Value)
+			if err == nil {
+				break
+			}
+			monitoring.KafkaMessages.WithLabelValues(c.topic, "consume_error").Inc()
+			logger.Error("Error handling message", "topic", c.topic, "partition", msg.Partition,
+				"offset", msg.Offset, "retry_in", backoff, "error", err)
+
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+			if backoff > retryMaxInterval {
+				backoff = retryMaxInterval
+			}
+		}
+
+		if err := c.reader.CommitMessages(ctx, msg); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to commit offset on %s: %w", c.topic, err)
+		}
+		monitoring.KafkaMessages.WithLabelValues(c.topic, "consumed").Inc()
+	}
+}
+
+// Close closes the underlying reader
+func (c *Consumer) Close() error {
+	return c.reader.Close()
+}
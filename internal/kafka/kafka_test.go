@@ -0,0 +1,104 @@
+package kafka
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"otelservices/internal/config"
+	"otelservices/internal/models"
+)
+
+func TestTopic(t *testing.T) {
+	cfg := &config.KafkaConfig{TopicPrefix: "otel"}
+	if got := Topic(cfg, SignalTraces); got != "otel-traces" {
+		t.Errorf("Topic() = %q, want otel-traces", got)
+	}
+}
+
+func TestCompressionCodec(t *testing.T) {
+	for _, name := range []string{"", "none", "gzip", "snappy", "lz4", "zstd"} {
+		if _, err := compressionCodec(name); err != nil {
+			t.Errorf("compressionCodec(%q) unexpected error: %v", name, err)
+		}
+	}
+	if _, err := compressionCodec("brotli"); err == nil {
+		t.Error("expected error for unsupported codec")
+	}
+}
+
+func TestEncodeBatchSplitsLargeBatches(t *testing.T) {
+	spans := make([]models.Span, 100)
+	for i := range spans {
+		spans[i] = models.Span{
+			TraceID:     "0af7651916cd43dd8448eb211c80319c",
+			SpanName:    "GET /api/users",
+			ServiceName: "frontend",
+			StartTime:   time.Unix(1700000000, 0).UTC(),
+		}
+	}
+
+	payloads, err := encodeBatch(spans, 4096)
+	if err != nil {
+		t.Fatalf("encodeBatch failed: %v", err)
+	}
+	if len(payloads) < 2 {
+		t.Fatalf("expected batch to be split, got %d payload(s)", len(payloads))
+	}
+
+	total := 0
+	for _, p := range payloads {
+		if len(p) > 4096 {
+			t.Errorf("payload of %d bytes exceeds limit", len(p))
+		}
+		decoded, err := DecodeSpans(p)
+		if err != nil {
+			t.Fatalf("DecodeSpans failed: %v", err)
+		}
+		total += len(decoded)
+	}
+	if total != len(spans) {
+		t.Errorf("decoded %d spans, want %d", total, len(spans))
+	}
+}
+
+func TestEncodeBatchPreservesNaN(t *testing.T) {
+	metrics := []models.Metric{{MetricName: "gauge", Value: math.NaN()}, {MetricName: "gauge", Value: math.Inf(1)}}
+
+	payloads, err := encodeBatch(metrics, 1024*1024)
+	if err != nil {
+		t.Fatalf("encodeBatch failed: %v", err)
+	}
+	decoded, err := DecodeMetrics(payloads[0])
+	if err != nil {
+		t.Fatalf("DecodeMetrics failed: %v", err)
+	}
+	if !math.IsNaN(decoded[0].Value) || !math.IsInf(decoded[1].Value, 1) {
+		t.Errorf("unexpected values: %v, %v", decoded[0].Value, decoded[1].Value)
+	}
+}
+
+func TestEncodeBatchRoundTrip(t *testing.T) {
+	logs := []models.LogRecord{{
+		Timestamp:   time.Unix(1700000000, 0).UTC(),
+		Body:        "hello",
+		ServiceName: "api",
+		Attributes:  map[string]string{"k": "v"},
+	}}
+
+	payloads, err := encodeBatch(logs, 1024*1024)
+	if err != nil {
+		t.Fatalf("encodeBatch failed: %v", err)
+	}
+	if len(payloads) != 1 {
+		t.Fatalf("expected one payload, got %d", len(payloads))
+	}
+
+	decoded, err := DecodeLogs(payloads[0])
+	if err != nil {
+		t.Fatalf("DecodeLogs failed: %v", err)
+	}
+	if len(decoded) != 1 || decoded[0].Body != "hello" || decoded[0].Attributes["k"] != "v" || !decoded[0].Timestamp.Equal(logs[0].Timestamp) {
+		t.Errorf("unexpected decoded logs: %+v", decoded)
+	}
+}
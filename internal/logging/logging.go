@@ -0,0 +1,117 @@
+// Package logging sets up the structured logs of the collector and query
+// service. Components log through loggers from For, which tag each record
+// with the component and follow the level and format installed by Setup.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Setup installs the default logger, writing to stderr at the given level
+// (debug, info, warn or error) as JSON or text, and to the sink set with
+// SetSink. Output of the standard log package goes through it as well, at
+// info level.
+func Setup(level, format string) error {
+	return setup(os.Stderr, level, format)
+}
+
+func setup(w io.Writer, level, format string) error {
+	lvl, err := ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+	var out slog.Handler
+	switch format {
+	case "json":
+		out = slog.NewJSONHandler(w, opts)
+	case "", "text":
+		out = slog.NewTextHandler(w, opts)
+	default:
+		return fmt.Errorf("unsupported log format %q", format)
+	}
+	slog.SetDefault(slog.New(newSinkHandler(out)))
+	return nil
+}
+
+// ParseLevel parses a log level name; an empty name is info
+func ParseLevel(name string) (slog.Level, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q", name)
+}
+
+// For returns the logger of a component. It can be created before Setup,
+// e.g. in a package variable, as records go to the default logger of the
+// time they are written.
+func For(component string) *slog.Logger {
+	return slog.New(&defaultHandler{}).With("component", component)
+}
+
+// Fatal logs an error and exits, for failures the process cannot run with
+func Fatal(logger *slog.Logger, msg string, args ...any) {
+	logger.Error(msg, args...)
+	os.Exit(1)
+}
+
+// defaultHandler forwards records to the current default handler, adding the
+// attributes and groups it was derived with
+type defaultHandler struct {
+	ops []handlerOp
+}
+
+// handlerOp is a WithAttrs (attrs set) or WithGroup (group set) call
+type handlerOp struct {
+	attrs []slog.Attr
+	group string
+}
+
+func (h *defaultHandler) target() slog.Handler {
+	t := slog.Default().Handler()
+	for _, op := range h.ops {
+		if op.group != "" {
+			t = t.WithGroup(op.group)
+		} else {
+			t = t.WithAttrs(op.attrs)
+		}
+	}
+	return t
+}
+
+func (h *defaultHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return slog.Default().Handler().Enabled(ctx, level)
+}
+
+func (h *defaultHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.target().Handle(ctx, r)
+}
+
+func (h *defaultHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(handlerOp{attrs: attrs})
+}
+
+func (h *defaultHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return h.with(handlerOp{group: name})
+}
+
+func (h *defaultHandler) with(op handlerOp) *defaultHandler {
+	ops := make([]handlerOp, len(h.ops), len(h.ops)+1)
+	copy(ops, h.ops)
+	return &defaultHandler{ops: append(ops, op)}
+}
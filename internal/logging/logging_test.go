@@ -0,0 +1,62 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestSetupJSON(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+
+	// Created before Setup, as package loggers are
+	logger := For("query")
+
+	var buf bytes.Buffer
+	if err := setup(&buf, "warn", "json"); err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("dropped by level")
+	logger.Warn("Query cache lookup failed", "error", "timeout")
+
+	var record map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("expected one JSON record: %v: %q", err, buf.String())
+	}
+	if record["msg"] != "Query cache lookup failed" || record["level"] != "WARN" || record["component"] != "query" || record["error"] != "timeout" {
+		t.Errorf("unexpected record %v", record)
+	}
+}
+
+func TestSetupText(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+
+	var buf bytes.Buffer
+	if err := setup(&buf, "debug", "text"); err != nil {
+		t.Fatal(err)
+	}
+	For("clickhouse").WithGroup("discovery").Debug("resolved", "addresses", 3)
+	log.Printf("from the standard logger")
+
+	out := buf.String()
+	if !strings.Contains(out, "level=DEBUG msg=resolved component=clickhouse discovery.addresses=3") {
+		t.Errorf("unexpected debug line: %q", out)
+	}
+	if !strings.Contains(out, `msg="from the standard logger"`) {
+		t.Errorf("standard log output should go through the handler: %q", out)
+	}
+}
+
+func TestSetupErrors(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+
+	if err := setup(&bytes.Buffer{}, "verbose", "json"); err == nil {
+		t.Error("expected error for unknown level")
+	}
+	if err := setup(&bytes.Buffer{}, "info", "xml"); err == nil {
+		t.Error("expected error for unknown format")
+	}
+}
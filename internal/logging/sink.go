@@ -0,0 +1,101 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// Record is a log record handed to a sink. Attributes are flattened into
+// string values under dotted keys, groups included.
+type Record struct {
+	Time       time.Time
+	Level      slog.Level
+	Message    string
+	Attributes map[string]string
+	// Context is the context the record was logged with, e.g. to correlate
+	// it with the active span
+	Context context.Context
+}
+
+// sink receives every record that passes the level installed by Setup
+var sink atomic.Pointer[func(Record)]
+
+// SetSink makes every logged record also go to fn, besides the output
+// installed by Setup, e.g. so a service can ingest its own logs. fn runs on
+// the logging goroutine and must not block. A nil fn removes the sink.
+func SetSink(fn func(Record)) {
+	if fn == nil {
+		sink.Store(nil)
+		return
+	}
+	sink.Store(&fn)
+}
+
+// sinkHandler forwards records to the output handler and, when one is set,
+// the sink, keeping the attributes the handler was derived with for it
+type sinkHandler struct {
+	out    slog.Handler
+	attrs  map[string]string
+	prefix string // open groups, dotted
+}
+
+func newSinkHandler(out slog.Handler) *sinkHandler {
+	return &sinkHandler{out: out, attrs: map[string]string{}}
+}
+
+func (h *sinkHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.out.Enabled(ctx, level)
+}
+
+func (h *sinkHandler) Handle(ctx context.Context, r slog.Record) error {
+	if fn := sink.Load(); fn != nil {
+		rec := Record{Time: r.Time, Level: r.Level, Message: r.Message, Context: ctx, Attributes: make(map[string]string, len(h.attrs)+r.NumAttrs())}
+		for k, v := range h.attrs {
+			rec.Attributes[k] = v
+		}
+		r.Attrs(func(a slog.Attr) bool {
+			flattenAttr(rec.Attributes, h.prefix, a)
+			return true
+		})
+		(*fn)(rec)
+	}
+	return h.out.Handle(ctx, r)
+}
+
+func (h *sinkHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	derived := &sinkHandler{out: h.out.WithAttrs(attrs), attrs: make(map[string]string, len(h.attrs)+len(attrs)), prefix: h.prefix}
+	for k, v := range h.attrs {
+		derived.attrs[k] = v
+	}
+	for _, a := range attrs {
+		flattenAttr(derived.attrs, h.prefix, a)
+	}
+	return derived
+}
+
+func (h *sinkHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return &sinkHandler{out: h.out.WithGroup(name), attrs: h.attrs, prefix: h.prefix + name + "."}
+}
+
+// flattenAttr stores an attribute under its dotted key, expanding groups
+func flattenAttr(dst map[string]string, prefix string, a slog.Attr) {
+	v := a.Value.Resolve()
+	if v.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, member := range v.Group() {
+			flattenAttr(dst, prefix, member)
+		}
+		return
+	}
+	if a.Key == "" {
+		return
+	}
+	dst[prefix+a.Key] = v.String()
+}
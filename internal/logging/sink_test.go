@@ -0,0 +1,50 @@
+package logging
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestSetSink(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+	defer SetSink(nil)
+
+	var buf bytes.Buffer
+	if err := setup(&buf, "info", "text"); err != nil {
+		t.Fatal(err)
+	}
+	var records []Record
+	SetSink(func(r Record) { records = append(records, r) })
+
+	logger := For("collector").WithGroup("batch")
+	logger.Debug("dropped by level")
+	logger.Warn("Insert failed", "rows", 10, slog.Group("retry", "attempt", 2))
+
+	if len(records) != 1 {
+		t.Fatalf("sink got %d records", len(records))
+	}
+	r := records[0]
+	if r.Message != "Insert failed" || r.Level != slog.LevelWarn || r.Time.IsZero() || r.Context == nil {
+		t.Errorf("unexpected record %+v", r)
+	}
+	want := map[string]string{"component": "collector", "batch.rows": "10", "batch.retry.attempt": "2"}
+	if len(r.Attributes) != len(want) {
+		t.Errorf("attributes = %v, want %v", r.Attributes, want)
+	}
+	for k, v := range want {
+		if r.Attributes[k] != v {
+			t.Errorf("attribute %s = %q, want %q", k, r.Attributes[k], v)
+		}
+	}
+	if !strings.Contains(buf.String(), "msg=\"Insert failed\"") {
+		t.Errorf("record should still reach the output: %q", buf.String())
+	}
+
+	SetSink(nil)
+	logger.Warn("after removal")
+	if len(records) != 1 {
+		t.Error("removed sink still called")
+	}
+}
@@ -0,0 +1,258 @@
+// Package memstore keeps telemetry in memory. It stands in for ClickHouse
+// where none is available, in the collector's tests and local demos: it
+// accepts the same writes as the ClickHouse client, keeps the most recent
+// items of each signal up to a limit, and reads them back with the basic
+// filters of the query API. Nothing survives a restart.
+package memstore
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"otelservices/internal/models"
+)
+
+// DefaultMaxItems is the number of items kept per signal when New is given
+// no limit
+const DefaultMaxItems = 100000
+
+// Filter selects the items returned by a read. Zero fields match
+// everything.
+type Filter struct {
+	TraceID     string
+	ServiceName string
+	Name        string // span or metric name
+	Start       time.Time
+	End         time.Time
+	Attributes  map[string]string // every one must match
+	Limit       int               // 0 returns every match
+}
+
+// Store is an in-memory store of spans, metrics, logs and the records the
+// collector and query service keep about them. It is safe for concurrent
+// use.
+type Store struct {
+	maxItems int
+
+	mu         sync.RWMutex
+	spans      []models.Span
+	metrics    []models.Metric
+	logs       []models.LogRecord
+	operations map[models.ServiceOperation]struct{}
+	usage      []models.IngestUsage
+	audit      []models.AuditRecord
+}
+
+// New creates a store keeping at most maxItems of each signal. Once full,
+// the oldest items are evicted first.
+func New(maxItems int) *Store {
+	if maxItems <= 0 {
+		maxItems = DefaultMaxItems
+	}
+	return &Store{maxItems: maxItems, operations: make(map[models.ServiceOperation]struct{})}
+}
+
+// appendBounded appends add to items and evicts the oldest items beyond
+// max, copying so the evicted ones can be collected
+func appendBounded[T any](items, add []T, max int) []T {
+	items = append(items, add...)
+	if over := len(items) - max; over > 0 {
+		items = append(items[:0:0], items[over:]...)
+	}
+	return items
+}
+
+func (s *Store) InsertSpans(_ context.Context, spans []models.Span) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.spans = appendBounded(s.spans, spans, s.maxItems)
+	return nil
+}
+
+func (s *Store) InsertMetrics(_ context.Context, metrics []models.Metric) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.metrics = appendBounded(s.metrics, metrics, s.maxItems)
+	return nil
+}
+
+func (s *Store) InsertLogs(_ context.Context, logs []models.LogRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.logs = appendBounded(s.logs, logs, s.maxItems)
+	return nil
+}
+
+// InsertServiceOperations records operations like the dictionary table,
+// keeping each one once
+func (s *Store) InsertServiceOperations(_ context.Context, ops []models.ServiceOperation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, op := range ops {
+		op.Timestamp = time.Time{}
+		s.operations[op] = struct{}{}
+	}
+	return nil
+}
+
+func (s *Store) InsertIngestUsage(_ context.Context, usage []models.IngestUsage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.usage = append(s.usage, usage...)
+	return nil
+}
+
+func (s *Store) InsertAuditRecords(_ context.Context, records []models.AuditRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.audit = appendBounded(s.audit, records, s.maxItems)
+	return nil
+}
+
+// IngestUsageSince sums the usage recorded from the hour of since on for a
+// service, or for every service of a namespace when serviceName is empty
+func (s *Store) IngestUsageSince(_ context.Context, since time.Time, serviceName, serviceNamespace string) (bytes, items uint64, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	since = since.UTC().Truncate(time.Hour)
+	for _, u := range s.usage {
+		if u.Timestamp.Before(since) {
+			continue
+		}
+		if serviceName != "" && u.ServiceName != serviceName {
+			continue
+		}
+		if serviceName == "" && u.ServiceNamespace != serviceNamespace {
+			continue
+		}
+		bytes += u.Bytes
+		items += u.Items
+	}
+	return bytes, items, nil
+}
+
+// Spans returns the spans matching f, newest first
+func (s *Store) Spans(f Filter) []models.Span {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return find(s.spans, f, func(sp *models.Span) bool {
+		return f.matches(sp.Timestamp, sp.ServiceName, sp.TraceID, sp.SpanName, sp.Attributes)
+	}, func(sp *models.Span) time.Time { return sp.Timestamp })
+}
+
+// Metrics returns the metric points matching f, newest first. Metrics
+// carry no trace ID, so a filter with one matches none.
+func (s *Store) Metrics(f Filter) []models.Metric {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return find(s.metrics, f, func(m *models.Metric) bool {
+		return f.matches(m.Timestamp, m.ServiceName, "", m.MetricName, m.Attributes)
+	}, func(m *models.Metric) time.Time { return m.Timestamp })
+}
+
+// Logs returns the log records matching f, newest first. Logs have no
+// name, so a filter with one matches none.
+func (s *Store) Logs(f Filter) []models.LogRecord {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return find(s.logs, f, func(l *models.LogRecord) bool {
+		return f.matches(l.Timestamp, l.ServiceName, l.TraceID, "", l.Attributes)
+	}, func(l *models.LogRecord) time.Time { return l.Timestamp })
+}
+
+// ServiceOperations returns the recorded operations ordered by service,
+// span name and kind
+func (s *Store) ServiceOperations() []models.ServiceOperation {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ops := make([]models.ServiceOperation, 0, len(s.operations))
+	for op := range s.operations {
+		ops = append(ops, op)
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		if ops[i].ServiceName != ops[j].ServiceName {
+			return ops[i].ServiceName < ops[j].ServiceName
+		}
+		if ops[i].SpanName != ops[j].SpanName {
+			return ops[i].SpanName < ops[j].SpanName
+		}
+		return ops[i].SpanKind < ops[j].SpanKind
+	})
+	return ops
+}
+
+// AuditRecords returns the recorded query API requests, oldest first
+func (s *Store) AuditRecords() []models.AuditRecord {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]models.AuditRecord(nil), s.audit...)
+}
+
+// Services returns the names of the services any span, metric or log came
+// from, in order
+func (s *Store) Services() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	seen := make(map[string]bool)
+	for i := range s.spans {
+		seen[s.spans[i].ServiceName] = true
+	}
+	for i := range s.metrics {
+		seen[s.metrics[i].ServiceName] = true
+	}
+	for i := range s.logs {
+		seen[s.logs[i].ServiceName] = true
+	}
+	services := make([]string, 0, len(seen))
+	for name := range seen {
+		services = append(services, name)
+	}
+	sort.Strings(services)
+	return services
+}
+
+func (f Filter) matches(ts time.Time, service, traceID, name string, attrs map[string]string) bool {
+	if f.TraceID != "" && traceID != f.TraceID {
+		return false
+	}
+	if f.ServiceName != "" && service != f.ServiceName {
+		return false
+	}
+	if f.Name != "" && name != f.Name {
+		return false
+	}
+	if !f.Start.IsZero() && ts.Before(f.Start) {
+		return false
+	}
+	if !f.End.IsZero() && ts.After(f.End) {
+		return false
+	}
+	for k, v := range f.Attributes {
+		if got, ok := attrs[k]; !ok || got != v {
+			return false
+		}
+	}
+	return true
+}
+
+// find returns copies of the matching items, newest first and cut to
+// f.Limit
+func find[T any](items []T, f Filter, match func(*T) bool, timestamp func(*T) time.Time) []T {
+	var out []T
+	// Newest last in insertion order, so a stable sort keeps later inserts
+	// first among equal timestamps
+	for i := len(items) - 1; i >= 0; i-- {
+		if match(&items[i]) {
+			out = append(out, items[i])
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		return timestamp(&out[i]).After(timestamp(&out[j]))
+	})
+	if f.Limit > 0 && len(out) > f.Limit {
+		out = out[:f.Limit]
+	}
+	return out
+}
@@ -0,0 +1,113 @@
+package memstore
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"otelservices/internal/models"
+)
+
+var base = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func spanNames(spans []models.Span) []string {
+	var names []string
+	for _, s := range spans {
+		names = append(names, s.SpanName)
+	}
+	return names
+}
+
+func TestSpans(t *testing.T) {
+	s := New(0)
+	ctx := context.Background()
+	s.InsertSpans(ctx, []models.Span{
+		{Timestamp: base, TraceID: "t1", SpanName: "checkout", ServiceName: "shop", Attributes: map[string]string{"http.method": "POST"}},
+		{Timestamp: base.Add(time.Minute), TraceID: "t1", SpanName: "charge", ServiceName: "payments"},
+		{Timestamp: base.Add(2 * time.Minute), TraceID: "t2", SpanName: "browse", ServiceName: "shop", Attributes: map[string]string{"http.method": "GET"}},
+	})
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   []string
+	}{
+		{"everything newest first", Filter{}, []string{"browse", "charge", "checkout"}},
+		{"trace", Filter{TraceID: "t1"}, []string{"charge", "checkout"}},
+		{"service", Filter{ServiceName: "shop"}, []string{"browse", "checkout"}},
+		{"name", Filter{Name: "charge"}, []string{"charge"}},
+		{"range", Filter{Start: base.Add(30 * time.Second), End: base.Add(time.Minute)}, []string{"charge"}},
+		{"attributes", Filter{Attributes: map[string]string{"http.method": "POST"}}, []string{"checkout"}},
+		{"limit", Filter{Limit: 2}, []string{"browse", "charge"}},
+		{"no match", Filter{ServiceName: "unknown"}, nil},
+	}
+	for _, tt := range tests {
+		if got := spanNames(s.Spans(tt.filter)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	if got := s.Services(); !reflect.DeepEqual(got, []string{"payments", "shop"}) {
+		t.Errorf("Services() = %v", got)
+	}
+}
+
+func TestEvictsOldest(t *testing.T) {
+	s := New(2)
+	ctx := context.Background()
+	for i, name := range []string{"a", "b", "c"} {
+		s.InsertSpans(ctx, []models.Span{{Timestamp: base.Add(time.Duration(i) * time.Second), SpanName: name}})
+	}
+	if got := spanNames(s.Spans(Filter{})); !reflect.DeepEqual(got, []string{"c", "b"}) {
+		t.Errorf("kept %v", got)
+	}
+	s.InsertLogs(ctx, []models.LogRecord{{Body: "1"}, {Body: "2"}, {Body: "3"}})
+	if logs := s.Logs(Filter{}); len(logs) != 2 || logs[0].Body != "3" {
+		t.Errorf("kept logs %+v", logs)
+	}
+}
+
+func TestMetricsAndLogs(t *testing.T) {
+	s := New(0)
+	ctx := context.Background()
+	s.InsertMetrics(ctx, []models.Metric{
+		{Timestamp: base, MetricName: "requests", ServiceName: "shop", Value: 1},
+		{Timestamp: base, MetricName: "latency", ServiceName: "shop", Value: 2},
+	})
+	s.InsertLogs(ctx, []models.LogRecord{
+		{Timestamp: base, TraceID: "t1", ServiceName: "shop", Body: "paid"},
+		{Timestamp: base, ServiceName: "shop", Body: "idle"},
+	})
+	if m := s.Metrics(Filter{Name: "requests"}); len(m) != 1 || m[0].Value != 1 {
+		t.Errorf("metrics %+v", m)
+	}
+	if m := s.Metrics(Filter{TraceID: "t1"}); len(m) != 0 {
+		t.Errorf("metrics matched a trace ID: %+v", m)
+	}
+	if l := s.Logs(Filter{TraceID: "t1"}); len(l) != 1 || l[0].Body != "paid" {
+		t.Errorf("logs %+v", l)
+	}
+}
+
+func TestUsageAndOperations(t *testing.T) {
+	s := New(0)
+	ctx := context.Background()
+	s.InsertIngestUsage(ctx, []models.IngestUsage{
+		{Timestamp: base, ServiceName: "shop", ServiceNamespace: "retail", Bytes: 100, Items: 1},
+		{Timestamp: base.Add(time.Hour), ServiceName: "cart", ServiceNamespace: "retail", Bytes: 50, Items: 2},
+		{Timestamp: base.Add(-time.Hour), ServiceName: "shop", ServiceNamespace: "retail", Bytes: 1000, Items: 10},
+	})
+	// The hour of since counts in full, like the hourly usage table
+	if bytes, items, _ := s.IngestUsageSince(ctx, base.Add(30*time.Minute), "shop", ""); bytes != 100 || items != 1 {
+		t.Errorf("service usage %d bytes, %d items", bytes, items)
+	}
+	if bytes, items, _ := s.IngestUsageSince(ctx, base, "", "retail"); bytes != 150 || items != 3 {
+		t.Errorf("namespace usage %d bytes, %d items", bytes, items)
+	}
+
+	op := models.ServiceOperation{ServiceName: "shop", SpanName: "checkout", SpanKind: "server"}
+	s.InsertServiceOperations(ctx, []models.ServiceOperation{op, {Timestamp: base, ServiceName: "shop", SpanName: "checkout", SpanKind: "server"}})
+	if ops := s.ServiceOperations(); !reflect.DeepEqual(ops, []models.ServiceOperation{op}) {
+		t.Errorf("operations %+v", ops)
+	}
+}
@@ -0,0 +1,408 @@
+package models
+
+import (
+	"sync"
+	"time"
+)
+
+// SpanColumns is a batch of spans laid out one slice per otel_traces
+// column, so an insert walks typed slices instead of boxing every field of
+// every row. Batches are reused through AcquireSpanColumns and
+// ReleaseSpanColumns.
+type SpanColumns struct {
+	Timestamp                   []time.Time
+	TraceID                     []string
+	SpanID                      []string
+	ParentSpanID                []string
+	TraceState                  []string
+	SpanName                    []string
+	SpanKind                    []string
+	StartTime                   []time.Time
+	EndTime                     []time.Time
+	DurationNs                  []uint64
+	StatusCode                  []string
+	StatusMessage               []string
+	ServiceName                 []string
+	ServiceNamespace            []string
+	ServiceInstanceID           []string
+	DeploymentEnvironment       []string
+	Attributes                  []map[string]string
+	ColdAttributes              []map[string]string
+	IntAttributes               []map[string]int64
+	DoubleAttributes            []map[string]float64
+	BoolAttributes              []map[string]bool
+	ResourceAttributes          []map[string]string
+	Events                      [][]SpanEvent
+	Links                       [][]SpanLink
+	DroppedAttributesCount      []uint32
+	DroppedEventsCount          []uint32
+	DroppedLinksCount           []uint32
+	InstrumentationScopeName    []string
+	InstrumentationScopeVersion []string
+	ScopeAttributes             []map[string]string
+}
+
+var spanColumnsPool = sync.Pool{
+	New: func() any { return new(SpanColumns) },
+}
+
+// AcquireSpanColumns returns an empty batch with room for at least n spans
+func AcquireSpanColumns(n int) *SpanColumns {
+	c := spanColumnsPool.Get().(*SpanColumns)
+	c.grow(n)
+	return c
+}
+
+// ReleaseSpanColumns empties c and returns it to the pool. c must not be
+// used afterwards.
+func ReleaseSpanColumns(c *SpanColumns) {
+	c.Reset()
+	spanColumnsPool.Put(c)
+}
+
+// Len returns the number of spans in the batch
+func (c *SpanColumns) Len() int {
+	return len(c.TraceID)
+}
+
+// Append adds a span to the batch. Maps and slices are shared with s, not
+// copied.
+func (c *SpanColumns) Append(s *Span) {
+	c.Timestamp = append(c.Timestamp, s.Timestamp)
+	c.TraceID = append(c.TraceID, s.TraceID)
+	c.SpanID = append(c.SpanID, s.SpanID)
+	c.ParentSpanID = append(c.ParentSpanID, s.ParentSpanID)
+	c.TraceState = append(c.TraceState, s.TraceState)
+	c.SpanName = append(c.SpanName, s.SpanName)
+	c.SpanKind = append(c.SpanKind, s.SpanKind)
+	c.StartTime = append(c.StartTime, s.StartTime)
+	c.EndTime = append(c.EndTime, s.EndTime)
+	c.DurationNs = append(c.DurationNs, s.DurationNs)
+	c.StatusCode = append(c.StatusCode, s.StatusCode)
+	c.StatusMessage = append(c.StatusMessage, s.StatusMessage)
+	c.ServiceName = append(c.ServiceName, s.ServiceName)
+	c.ServiceNamespace = append(c.ServiceNamespace, s.ServiceNamespace)
+	c.ServiceInstanceID = append(c.ServiceInstanceID, s.ServiceInstanceID)
+	c.DeploymentEnvironment = append(c.DeploymentEnvironment, s.DeploymentEnvironment)
+	c.Attributes = append(c.Attributes, s.Attributes)
+	c.IntAttributes = append(c.IntAttributes, s.TypedAttributes.Int)
+	c.DoubleAttributes = append(c.DoubleAttributes, s.TypedAttributes.Double)
+	c.BoolAttributes = append(c.BoolAttributes, s.TypedAttributes.Bool)
+	c.ColdAttributes = append(c.ColdAttributes, s.ColdAttributes)
+	c.ResourceAttributes = append(c.ResourceAttributes, s.ResourceAttributes)
+	c.Events = append(c.Events, s.Events)
+	c.Links = append(c.Links, s.Links)
+	c.DroppedAttributesCount = append(c.DroppedAttributesCount, s.DroppedAttributesCount)
+	c.DroppedEventsCount = append(c.DroppedEventsCount, s.DroppedEventsCount)
+	c.DroppedLinksCount = append(c.DroppedLinksCount, s.DroppedLinksCount)
+	c.InstrumentationScopeName = append(c.InstrumentationScopeName, s.InstrumentationScopeName)
+	c.InstrumentationScopeVersion = append(c.InstrumentationScopeVersion, s.InstrumentationScopeVersion)
+	c.ScopeAttributes = append(c.ScopeAttributes, s.ScopeAttributes)
+}
+
+// Reset empties the batch, keeping its capacity. References to the spans'
+// strings and maps are cleared so a pooled batch does not keep them alive.
+func (c *SpanColumns) Reset() {
+	c.Timestamp = resetColumn(c.Timestamp)
+	c.TraceID = resetColumn(c.TraceID)
+	c.SpanID = resetColumn(c.SpanID)
+	c.ParentSpanID = resetColumn(c.ParentSpanID)
+	c.TraceState = resetColumn(c.TraceState)
+	c.SpanName = resetColumn(c.SpanName)
+	c.SpanKind = resetColumn(c.SpanKind)
+	c.StartTime = resetColumn(c.StartTime)
+	c.EndTime = resetColumn(c.EndTime)
+	c.DurationNs = resetColumn(c.DurationNs)
+	c.StatusCode = resetColumn(c.StatusCode)
+	c.StatusMessage = resetColumn(c.StatusMessage)
+	c.ServiceName = resetColumn(c.ServiceName)
+	c.ServiceNamespace = resetColumn(c.ServiceNamespace)
+	c.ServiceInstanceID = resetColumn(c.ServiceInstanceID)
+	c.DeploymentEnvironment = resetColumn(c.DeploymentEnvironment)
+	c.Attributes = resetColumn(c.Attributes)
+	c.IntAttributes = resetColumn(c.IntAttributes)
+	c.DoubleAttributes = resetColumn(c.DoubleAttributes)
+	c.BoolAttributes = resetColumn(c.BoolAttributes)
+	c.ColdAttributes = resetColumn(c.ColdAttributes)
+	c.ResourceAttributes = resetColumn(c.ResourceAttributes)
+	c.Events = resetColumn(c.Events)
+	c.Links = resetColumn(c.Links)
+	c.DroppedAttributesCount = resetColumn(c.DroppedAttributesCount)
+	c.DroppedEventsCount = resetColumn(c.DroppedEventsCount)
+	c.DroppedLinksCount = resetColumn(c.DroppedLinksCount)
+	c.InstrumentationScopeName = resetColumn(c.InstrumentationScopeName)
+	c.InstrumentationScopeVersion = resetColumn(c.InstrumentationScopeVersion)
+	c.ScopeAttributes = resetColumn(c.ScopeAttributes)
+}
+
+// grow makes room for n spans in every column
+func (c *SpanColumns) grow(n int) {
+	c.Timestamp = growColumn(c.Timestamp, n)
+	c.TraceID = growColumn(c.TraceID, n)
+	c.SpanID = growColumn(c.SpanID, n)
+	c.ParentSpanID = growColumn(c.ParentSpanID, n)
+	c.TraceState = growColumn(c.TraceState, n)
+	c.SpanName = growColumn(c.SpanName, n)
+	c.SpanKind = growColumn(c.SpanKind, n)
+	c.StartTime = growColumn(c.StartTime, n)
+	c.EndTime = growColumn(c.EndTime, n)
+	c.DurationNs = growColumn(c.DurationNs, n)
+	c.StatusCode = growColumn(c.StatusCode, n)
+	c.StatusMessage = growColumn(c.StatusMessage, n)
+	c.ServiceName = growColumn(c.ServiceName, n)
+	c.ServiceNamespace = growColumn(c.ServiceNamespace, n)
+	c.ServiceInstanceID = growColumn(c.ServiceInstanceID, n)
+	c.DeploymentEnvironment = growColumn(c.DeploymentEnvironment, n)
+	c.Attributes = growColumn(c.Attributes, n)
+	c.IntAttributes = growColumn(c.IntAttributes, n)
+	c.DoubleAttributes = growColumn(c.DoubleAttributes, n)
+	c.BoolAttributes = growColumn(c.BoolAttributes, n)
+	c.ColdAttributes = growColumn(c.ColdAttributes, n)
+	c.ResourceAttributes = growColumn(c.ResourceAttributes, n)
+	c.Events = growColumn(c.Events, n)
+	c.Links = growColumn(c.Links, n)
+	c.DroppedAttributesCount = growColumn(c.DroppedAttributesCount, n)
+	c.DroppedEventsCount = growColumn(c.DroppedEventsCount, n)
+	c.DroppedLinksCount = growColumn(c.DroppedLinksCount, n)
+	c.InstrumentationScopeName = growColumn(c.InstrumentationScopeName, n)
+	c.InstrumentationScopeVersion = growColumn(c.InstrumentationScopeVersion, n)
+	c.ScopeAttributes = growColumn(c.ScopeAttributes, n)
+}
+
+// MetricColumns is a batch of metrics laid out one slice per otel_metrics column
+type MetricColumns struct {
+	Timestamp                   []time.Time
+	MetricName                  []string
+	MetricType                  []string
+	Value                       []float64
+	ServiceName                 []string
+	ServiceNamespace            []string
+	ServiceInstanceID           []string
+	DeploymentEnvironment       []string
+	Attributes                  []map[string]string
+	ResourceAttributes          []map[string]string
+	BucketCounts                [][]uint64
+	ExplicitBounds              [][]float64
+	InstrumentationScopeName    []string
+	InstrumentationScopeVersion []string
+}
+
+var metricColumnsPool = sync.Pool{
+	New: func() any { return new(MetricColumns) },
+}
+
+// AcquireMetricColumns returns an empty batch with room for at least n metrics
+func AcquireMetricColumns(n int) *MetricColumns {
+	c := metricColumnsPool.Get().(*MetricColumns)
+	c.grow(n)
+	return c
+}
+
+// ReleaseMetricColumns empties c and returns it to the pool. c must not be used
+// afterwards.
+func ReleaseMetricColumns(c *MetricColumns) {
+	c.Reset()
+	metricColumnsPool.Put(c)
+}
+
+// Len returns the number of metrics in the batch
+func (c *MetricColumns) Len() int {
+	return len(c.Timestamp)
+}
+
+// Append adds a metric to the batch, sharing its maps and slices
+func (c *MetricColumns) Append(m *Metric) {
+	c.Timestamp = append(c.Timestamp, m.Timestamp)
+	c.MetricName = append(c.MetricName, m.MetricName)
+	c.MetricType = append(c.MetricType, m.MetricType)
+	c.Value = append(c.Value, m.Value)
+	c.ServiceName = append(c.ServiceName, m.ServiceName)
+	c.ServiceNamespace = append(c.ServiceNamespace, m.ServiceNamespace)
+	c.ServiceInstanceID = append(c.ServiceInstanceID, m.ServiceInstanceID)
+	c.DeploymentEnvironment = append(c.DeploymentEnvironment, m.DeploymentEnvironment)
+	c.Attributes = append(c.Attributes, m.Attributes)
+	c.ResourceAttributes = append(c.ResourceAttributes, m.ResourceAttributes)
+	c.BucketCounts = append(c.BucketCounts, m.BucketCounts)
+	c.ExplicitBounds = append(c.ExplicitBounds, m.ExplicitBounds)
+	c.InstrumentationScopeName = append(c.InstrumentationScopeName, m.InstrumentationScopeName)
+	c.InstrumentationScopeVersion = append(c.InstrumentationScopeVersion, m.InstrumentationScopeVersion)
+}
+
+// Reset empties the batch, keeping its capacity
+func (c *MetricColumns) Reset() {
+	c.Timestamp = resetColumn(c.Timestamp)
+	c.MetricName = resetColumn(c.MetricName)
+	c.MetricType = resetColumn(c.MetricType)
+	c.Value = resetColumn(c.Value)
+	c.ServiceName = resetColumn(c.ServiceName)
+	c.ServiceNamespace = resetColumn(c.ServiceNamespace)
+	c.ServiceInstanceID = resetColumn(c.ServiceInstanceID)
+	c.DeploymentEnvironment = resetColumn(c.DeploymentEnvironment)
+	c.Attributes = resetColumn(c.Attributes)
+	c.ResourceAttributes = resetColumn(c.ResourceAttributes)
+	c.BucketCounts = resetColumn(c.BucketCounts)
+	c.ExplicitBounds = resetColumn(c.ExplicitBounds)
+	c.InstrumentationScopeName = resetColumn(c.InstrumentationScopeName)
+	c.InstrumentationScopeVersion = resetColumn(c.InstrumentationScopeVersion)
+}
+
+func (c *MetricColumns) grow(n int) {
+	c.Timestamp = growColumn(c.Timestamp, n)
+	c.MetricName = growColumn(c.MetricName, n)
+	c.MetricType = growColumn(c.MetricType, n)
+	c.Value = growColumn(c.Value, n)
+	c.ServiceName = growColumn(c.ServiceName, n)
+	c.ServiceNamespace = growColumn(c.ServiceNamespace, n)
+	c.ServiceInstanceID = growColumn(c.ServiceInstanceID, n)
+	c.DeploymentEnvironment = growColumn(c.DeploymentEnvironment, n)
+	c.Attributes = growColumn(c.Attributes, n)
+	c.ResourceAttributes = growColumn(c.ResourceAttributes, n)
+	c.BucketCounts = growColumn(c.BucketCounts, n)
+	c.ExplicitBounds = growColumn(c.ExplicitBounds, n)
+	c.InstrumentationScopeName = growColumn(c.InstrumentationScopeName, n)
+	c.InstrumentationScopeVersion = growColumn(c.InstrumentationScopeVersion, n)
+}
+
+// LogColumns is a batch of log records laid out one slice per otel_logs column
+type LogColumns struct {
+	Timestamp                   []time.Time
+	ObservedTimestamp           []time.Time
+	SeverityNumber              []uint8
+	SeverityText                []string
+	Body                        []string
+	BodyType                    []string
+	ServiceName                 []string
+	ServiceNamespace            []string
+	ServiceInstanceID           []string
+	DeploymentEnvironment       []string
+	HostName                    []string
+	TraceID                     []string
+	SpanID                      []string
+	TraceFlags                  []uint8
+	Attributes                  []map[string]string
+	IntAttributes               []map[string]int64
+	DoubleAttributes            []map[string]float64
+	BoolAttributes              []map[string]bool
+	DroppedAttributesCount      []uint32
+	ResourceAttributes          []map[string]string
+	InstrumentationScopeName    []string
+	InstrumentationScopeVersion []string
+	ScopeAttributes             []map[string]string
+}
+
+var logColumnsPool = sync.Pool{
+	New: func() any { return new(LogColumns) },
+}
+
+// AcquireLogColumns returns an empty batch with room for at least n log records
+func AcquireLogColumns(n int) *LogColumns {
+	c := logColumnsPool.Get().(*LogColumns)
+	c.grow(n)
+	return c
+}
+
+// ReleaseLogColumns empties c and returns it to the pool. c must not be used
+// afterwards.
+func ReleaseLogColumns(c *LogColumns) {
+	c.Reset()
+	logColumnsPool.Put(c)
+}
+
+// Len returns the number of log records in the batch
+func (c *LogColumns) Len() int {
+	return len(c.Timestamp)
+}
+
+// Append adds a log record to the batch, sharing its maps and slices
+func (c *LogColumns) Append(l *LogRecord) {
+	c.Timestamp = append(c.Timestamp, l.Timestamp)
+	c.ObservedTimestamp = append(c.ObservedTimestamp, l.ObservedTimestamp)
+	c.SeverityNumber = append(c.SeverityNumber, l.SeverityNumber)
+	c.SeverityText = append(c.SeverityText, l.SeverityText)
+	c.Body = append(c.Body, l.Body)
+	c.BodyType = append(c.BodyType, l.BodyType)
+	c.ServiceName = append(c.ServiceName, l.ServiceName)
+	c.ServiceNamespace = append(c.ServiceNamespace, l.ServiceNamespace)
+	c.ServiceInstanceID = append(c.ServiceInstanceID, l.ServiceInstanceID)
+	c.DeploymentEnvironment = append(c.DeploymentEnvironment, l.DeploymentEnvironment)
+	c.HostName = append(c.HostName, l.HostName)
+	c.TraceID = append(c.TraceID, l.TraceID)
+	c.SpanID = append(c.SpanID, l.SpanID)
+	c.TraceFlags = append(c.TraceFlags, l.TraceFlags)
+	c.Attributes = append(c.Attributes, l.Attributes)
+	c.IntAttributes = append(c.IntAttributes, l.TypedAttributes.Int)
+	c.DoubleAttributes = append(c.DoubleAttributes, l.TypedAttributes.Double)
+	c.BoolAttributes = append(c.BoolAttributes, l.TypedAttributes.Bool)
+	c.DroppedAttributesCount = append(c.DroppedAttributesCount, l.DroppedAttributesCount)
+	c.ResourceAttributes = append(c.ResourceAttributes, l.ResourceAttributes)
+	c.InstrumentationScopeName = append(c.InstrumentationScopeName, l.InstrumentationScopeName)
+	c.InstrumentationScopeVersion = append(c.InstrumentationScopeVersion, l.InstrumentationScopeVersion)
+	c.ScopeAttributes = append(c.ScopeAttributes, l.ScopeAttributes)
+}
+
+// Reset empties the batch, keeping its capacity
+func (c *LogColumns) Reset() {
+	c.Timestamp = resetColumn(c.Timestamp)
+	c.ObservedTimestamp = resetColumn(c.ObservedTimestamp)
+	c.SeverityNumber = resetColumn(c.SeverityNumber)
+	c.SeverityText = resetColumn(c.SeverityText)
+	c.Body = resetColumn(c.Body)
+	c.BodyType = resetColumn(c.BodyType)
+	c.ServiceName = resetColumn(c.ServiceName)
+	c.ServiceNamespace = resetColumn(c.ServiceNamespace)
+	c.ServiceInstanceID = resetColumn(c.ServiceInstanceID)
+	c.DeploymentEnvironment = resetColumn(c.DeploymentEnvironment)
+	c.HostName = resetColumn(c.HostName)
+	c.TraceID = resetColumn(c.TraceID)
+	c.SpanID = resetColumn(c.SpanID)
+	c.TraceFlags = resetColumn(c.TraceFlags)
+	c.Attributes = resetColumn(c.Attributes)
+	c.IntAttributes = resetColumn(c.IntAttributes)
+	c.DoubleAttributes = resetColumn(c.DoubleAttributes)
+	c.BoolAttributes = resetColumn(c.BoolAttributes)
+	c.DroppedAttributesCount = resetColumn(c.DroppedAttributesCount)
+	c.ResourceAttributes = resetColumn(c.ResourceAttributes)
+	c.InstrumentationScopeName = resetColumn(c.InstrumentationScopeName)
+	c.InstrumentationScopeVersion = resetColumn(c.InstrumentationScopeVersion)
+	c.ScopeAttributes = resetColumn(c.ScopeAttributes)
+}
+
+func (c *LogColumns) grow(n int) {
+	c.Timestamp = growColumn(c.Timestamp, n)
+	c.ObservedTimestamp = growColumn(c.ObservedTimestamp, n)
+	c.SeverityNumber = growColumn(c.SeverityNumber, n)
+	c.SeverityText = growColumn(c.SeverityText, n)
+	c.Body = growColumn(c.Body, n)
+	c.BodyType = growColumn(c.BodyType, n)
+	c.ServiceName = growColumn(c.ServiceName, n)
+	c.ServiceNamespace = growColumn(c.ServiceNamespace, n)
+	c.ServiceInstanceID = growColumn(c.ServiceInstanceID, n)
+	c.DeploymentEnvironment = growColumn(c.DeploymentEnvironment, n)
+	c.HostName = growColumn(c.HostName, n)
+	c.TraceID = growColumn(c.TraceID, n)
+	c.SpanID = growColumn(c.SpanID, n)
+	c.TraceFlags = growColumn(c.TraceFlags, n)
+	c.Attributes = growColumn(c.Attributes, n)
+	c.IntAttributes = growColumn(c.IntAttributes, n)
+	c.DoubleAttributes = growColumn(c.DoubleAttributes, n)
+	c.BoolAttributes = growColumn(c.BoolAttributes, n)
+	c.DroppedAttributesCount = growColumn(c.DroppedAttributesCount, n)
+	c.ResourceAttributes = growColumn(c.ResourceAttributes, n)
+	c.InstrumentationScopeName = growColumn(c.InstrumentationScopeName, n)
+	c.InstrumentationScopeVersion = growColumn(c.InstrumentationScopeVersion, n)
+	c.ScopeAttributes = growColumn(c.ScopeAttributes, n)
+}
+
+func resetColumn[T any](col []T) []T {
+	clear(col)
+	return col[:0]
+}
+
+func growColumn[T any](col []T, n int) []T {
+	if cap(col)-len(col) >= n {
+		return col
+	}
+	grown := make([]T, len(col), len(col)+n)
+	copy(grown, col)
+	return grown
+}
@@ -0,0 +1,104 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func testSpan(i int) Span {
+	now := time.Now()
+	return Span{
+		Timestamp:   now,
+		TraceID:     "0af7651916cd43dd8448eb211c80319c",
+		SpanID:      "b7ad6b7169203331",
+		SpanName:    "GET /users",
+		SpanKind:    "server",
+		StartTime:   now,
+		EndTime:     now.Add(time.Duration(i) * time.Millisecond),
+		DurationNs:  uint64(i) * uint64(time.Millisecond),
+		StatusCode:  "ok",
+		ServiceName: "api",
+		Attributes:  map[string]string{"http.method": "GET"},
+		Events:      []SpanEvent{{Timestamp: now, Name: "retry"}},
+	}
+}
+
+func TestSpanColumnsAppend(t *testing.T) {
+	cols := AcquireSpanColumns(4)
+	defer ReleaseSpanColumns(cols)
+
+	for i := 0; i < 3; i++ {
+		s := testSpan(i)
+		cols.Append(&s)
+	}
+	if cols.Len() != 3 {
+		t.Fatalf("Expected 3 spans, got %d", cols.Len())
+	}
+	if cols.DurationNs[2] != uint64(2*time.Millisecond) {
+		t.Errorf("Expected duration of the third span, got %d", cols.DurationNs[2])
+	}
+	if cols.Attributes[1]["http.method"] != "GET" {
+		t.Errorf("Expected attributes to be shared with the span, got %v", cols.Attributes[1])
+	}
+	if len(cols.Events[0]) != 1 || cols.Events[0][0].Name != "retry" {
+		t.Errorf("Expected the span's events, got %v", cols.Events[0])
+	}
+}
+
+func TestSpanColumnsReset(t *testing.T) {
+	cols := AcquireSpanColumns(2)
+	s := testSpan(1)
+	cols.Append(&s)
+	cols.Append(&s)
+	capacity := cap(cols.TraceID)
+
+	cols.Reset()
+	if cols.Len() != 0 {
+		t.Fatalf("Expected an empty batch, got %d spans", cols.Len())
+	}
+	if cap(cols.TraceID) != capacity {
+		t.Errorf("Expected capacity %d to be kept, got %d", capacity, cap(cols.TraceID))
+	}
+	if cols.Attributes[:1][0] != nil {
+		t.Error("Expected reset to drop references to the span's maps")
+	}
+	ReleaseSpanColumns(cols)
+
+	cols = AcquireSpanColumns(10)
+	defer ReleaseSpanColumns(cols)
+	if cols.Len() != 0 || cap(cols.ServiceName) < 10 {
+		t.Errorf("Expected an empty batch with room for 10 spans, got len %d cap %d", cols.Len(), cap(cols.ServiceName))
+	}
+}
+
+func TestMetricAndLogColumns(t *testing.T) {
+	metrics := AcquireMetricColumns(1)
+	defer ReleaseMetricColumns(metrics)
+	metrics.Append(&Metric{MetricName: "latency", Value: 1.5, BucketCounts: []uint64{1, 2}})
+	if metrics.Len() != 1 || metrics.Value[0] != 1.5 || len(metrics.BucketCounts[0]) != 2 {
+		t.Errorf("Expected the metric in every column, got %+v", metrics)
+	}
+
+	logs := AcquireLogColumns(1)
+	defer ReleaseLogColumns(logs)
+	logs.Append(&LogRecord{Body: "boom", SeverityNumber: SeverityError})
+	if logs.Len() != 1 || logs.Body[0] != "boom" || logs.SeverityNumber[0] != SeverityError {
+		t.Errorf("Expected the log record in every column, got %+v", logs)
+	}
+}
+
+func BenchmarkSpanColumns(b *testing.B) {
+	spans := make([]Span, 1000)
+	for i := range spans {
+		spans[i] = testSpan(i)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cols := AcquireSpanColumns(len(spans))
+		for j := range spans {
+			cols.Append(&spans[j])
+		}
+		ReleaseSpanColumns(cols)
+	}
+}
@@ -39,9 +39,12 @@ type LogRecord struct {
 	SpanID                      string
 	TraceFlags                  uint8
 	Attributes                  map[string]string
+	TypedAttributes             TypedAttributes
+	DroppedAttributesCount      uint32
 	ResourceAttributes          map[string]string
 	InstrumentationScopeName    string
 	InstrumentationScopeVersion string
+	ScopeAttributes             map[string]string
 }
 
 // Span represents an OpenTelemetry trace span
@@ -50,6 +53,7 @@ type Span struct {
 	TraceID                     string
 	SpanID                      string
 	ParentSpanID                string
+	TraceState                  string
 	SpanName                    string
 	SpanKind                    string
 	StartTime                   time.Time
@@ -62,11 +66,41 @@ type Span struct {
 	ServiceInstanceID           string
 	DeploymentEnvironment       string
 	Attributes                  map[string]string
+	ColdAttributes              map[string]string // stored as JSON in attributes_cold
+	TypedAttributes             TypedAttributes
 	ResourceAttributes          map[string]string
 	Events                      []SpanEvent
 	Links                       []SpanLink
+	DroppedAttributesCount      uint32
+	DroppedEventsCount          uint32
+	DroppedLinksCount           uint32
 	InstrumentationScopeName    string
 	InstrumentationScopeVersion string
+	ScopeAttributes             map[string]string
+}
+
+// TypedAttributes holds the integer, double and boolean attributes of a
+// span or log record with their OTLP types, so queries can compare them as
+// numbers. Attributes still holds every value as a string.
+type TypedAttributes struct {
+	Int    map[string]int64
+	Double map[string]float64
+	Bool   map[string]bool
+}
+
+// size approximates the bytes the typed attributes add to an insert batch
+func (t *TypedAttributes) size() int {
+	n := 8*(len(t.Int)+len(t.Double)) + len(t.Bool)
+	for k := range t.Int {
+		n += len(k)
+	}
+	for k := range t.Double {
+		n += len(k)
+	}
+	for k := range t.Bool {
+		n += len(k)
+	}
+	return n
 }
 
 // SpanEvent represents an event within a span
@@ -84,6 +118,52 @@ type SpanLink struct {
 	Attributes map[string]string
 }
 
+// fixedFieldBytes approximates the encoded size of a record's timestamps,
+// numbers and enum columns
+const fixedFieldBytes = 64
+
+func mapSize(m map[string]string) int {
+	n := 0
+	for k, v := range m {
+		n += len(k) + len(v)
+	}
+	return n
+}
+
+// EstimatedSize approximates the number of bytes the metric adds to an insert batch
+func (m *Metric) EstimatedSize() int {
+	return fixedFieldBytes + len(m.MetricName) + len(m.MetricType) +
+		len(m.ServiceName) + len(m.ServiceNamespace) + len(m.ServiceInstanceID) + len(m.DeploymentEnvironment) +
+		mapSize(m.Attributes) + mapSize(m.ResourceAttributes) +
+		8*(len(m.BucketCounts)+len(m.ExplicitBounds)) +
+		len(m.InstrumentationScopeName) + len(m.InstrumentationScopeVersion)
+}
+
+// EstimatedSize approximates the number of bytes the log record adds to an insert batch
+func (l *LogRecord) EstimatedSize() int {
+	return fixedFieldBytes + len(l.SeverityText) + len(l.Body) + len(l.BodyType) +
+		len(l.ServiceName) + len(l.ServiceNamespace) + len(l.ServiceInstanceID) + len(l.DeploymentEnvironment) +
+		len(l.HostName) + len(l.TraceID) + len(l.SpanID) +
+		mapSize(l.Attributes) + l.TypedAttributes.size() + mapSize(l.ResourceAttributes) +
+		len(l.InstrumentationScopeName) + len(l.InstrumentationScopeVersion) + mapSize(l.ScopeAttributes)
+}
+
+// EstimatedSize approximates the number of bytes the span adds to an insert batch
+func (s *Span) EstimatedSize() int {
+	n := fixedFieldBytes + len(s.TraceID) + len(s.SpanID) + len(s.ParentSpanID) + len(s.TraceState) +
+		len(s.SpanName) + len(s.SpanKind) + len(s.StatusCode) + len(s.StatusMessage) +
+		len(s.ServiceName) + len(s.ServiceNamespace) + len(s.ServiceInstanceID) + len(s.DeploymentEnvironment) +
+		mapSize(s.Attributes) + mapSize(s.ColdAttributes) + s.TypedAttributes.size() + mapSize(s.ResourceAttributes) +
+		len(s.InstrumentationScopeName) + len(s.InstrumentationScopeVersion) + mapSize(s.ScopeAttributes)
+	for _, e := range s.Events {
+		n += 8 + len(e.Name) + mapSize(e.Attributes)
+	}
+	for _, l := range s.Links {
+		n += len(l.TraceID) + len(l.SpanID) + len(l.TraceState) + mapSize(l.Attributes)
+	}
+	return n
+}
+
 // TraceIndex represents metadata about a complete trace
 type TraceIndex struct {
 	TraceID          string
@@ -95,4 +175,39 @@ type TraceIndex struct {
 	DurationNs       uint64
 	SpanCount        uint32
 	HasErrors        bool
-}
\ No newline at end of file
+}
+
+// ServiceOperation represents a service/operation pair seen during one hour,
+// stored in the otel_service_operations dictionary table
+type ServiceOperation struct {
+	Timestamp   time.Time
+	ServiceName string
+	SpanName    string
+	SpanKind    string
+}
+
+// IngestUsage is the data one service sent for one signal during one hour,
+// stored in the otel_ingest_usage accounting table
+type IngestUsage struct {
+	Timestamp        time.Time
+	ServiceName      string
+	ServiceNamespace string
+	Signal           string
+	Bytes            uint64
+	Items            uint64
+}
+
+// AuditRecord is one query API request, stored in the otel_audit table
+type AuditRecord struct {
+	Timestamp  time.Time
+	RequestID  string
+	Principal  string // API key name or token subject; empty with auth disabled
+	AuthMethod string
+	Method     string // HTTP method, or GRPC
+	Route      string // path template
+	Filters    string // normalized JSON of the request's parameters and body
+	Status     int
+	Rows       uint64
+	Duration   time.Duration
+	RemoteAddr string
+}
@@ -240,3 +240,27 @@ func TestSpanWithNoEventsOrLinks(t *testing.T) {
 		t.Errorf("Expected empty links, got %d items", len(span.Links))
 	}
 }
+
+func TestEstimatedSize(t *testing.T) {
+	span := Span{TraceID: "0af7651916cd43dd8448eb211c80319c", SpanName: "GET /"}
+	base := span.EstimatedSize()
+	if base <= len(span.TraceID)+len(span.SpanName) {
+		t.Errorf("span estimate %d should include fixed fields", base)
+	}
+
+	span.Attributes = map[string]string{"http.method": "GET"}
+	span.Events = []SpanEvent{{Name: "exception", Attributes: map[string]string{"exception.type": "Error"}}}
+	if got, want := span.EstimatedSize(), base+len("http.method")+len("GET")+8+len("exception")+len("exception.type")+len("Error"); got != want {
+		t.Errorf("span estimate = %d, want %d", got, want)
+	}
+
+	metric := Metric{MetricName: "latency", BucketCounts: []uint64{1, 2}, ExplicitBounds: []float64{0.5}}
+	if got, want := metric.EstimatedSize(), fixedFieldBytes+len("latency")+24; got != want {
+		t.Errorf("metric estimate = %d, want %d", got, want)
+	}
+
+	logRecord := LogRecord{Body: "hello"}
+	if got, want := logRecord.EstimatedSize(), fixedFieldBytes+len("hello"); got != want {
+		t.Errorf("log estimate = %d, want %d", got, want)
+	}
+}
@@ -0,0 +1,103 @@
+package models
+
+import "strings"
+
+// Base OpenTelemetry severity numbers. Each level spans four numbers, e.g.
+// WARN is 13 and WARN2 to WARN4 are 14 to 16.
+const (
+	SeverityTrace uint8 = 1
+	SeverityDebug uint8 = 5
+	SeverityInfo  uint8 = 9
+	SeverityWarn  uint8 = 13
+	SeverityError uint8 = 17
+	SeverityFatal uint8 = 21
+	SeverityMax   uint8 = 24
+)
+
+var severityLevels = []struct {
+	number uint8
+	text   string
+}{
+	{SeverityTrace, "TRACE"},
+	{SeverityDebug, "DEBUG"},
+	{SeverityInfo, "INFO"},
+	{SeverityWarn, "WARN"},
+	{SeverityError, "ERROR"},
+	{SeverityFatal, "FATAL"},
+}
+
+// severityAliases maps lowercase severity texts seen in the wild to a
+// base severity number
+var severityAliases = map[string]uint8{
+	"trace":       SeverityTrace,
+	"debug":       SeverityDebug,
+	"info":        SeverityInfo,
+	"information": SeverityInfo,
+	"notice":      SeverityInfo + 1,
+	"warn":        SeverityWarn,
+	"warning":     SeverityWarn,
+	"error":       SeverityError,
+	"err":         SeverityError,
+	"fatal":       SeverityFatal,
+	"critical":    SeverityFatal,
+	"crit":        SeverityFatal,
+	"alert":       SeverityFatal + 1,
+	"emergency":   SeverityFatal + 2,
+	"panic":       SeverityFatal + 2,
+}
+
+// ParseSeverityText returns the severity number for a severity text such as
+// "warning", "WARN" or "ERROR2". It reports false for unknown texts.
+func ParseSeverityText(text string) (uint8, bool) {
+	text = strings.ToLower(strings.TrimSpace(text))
+	if n, ok := severityAliases[text]; ok {
+		return n, true
+	}
+	// Sub-levels: INFO2 to INFO4 and so on
+	if len(text) > 1 {
+		last := text[len(text)-1]
+		if n, ok := severityAliases[text[:len(text)-1]]; ok && last >= '2' && last <= '4' && n == baseSeverity(n) {
+			return n + last - '1', true
+		}
+	}
+	return 0, false
+}
+
+// SeverityText returns the canonical text of a severity number, such as
+// "WARN" for 13 to 16, or an empty string for 0 and out of range numbers
+func SeverityText(number uint8) string {
+	if number == 0 || number > SeverityMax {
+		return ""
+	}
+	return severityLevels[(number-1)/4].text
+}
+
+// SeverityRange returns the severity numbers covered by the level of a
+// severity text, e.g. 13 to 16 for "warning"
+func SeverityRange(text string) (min, max uint8, ok bool) {
+	n, ok := ParseSeverityText(text)
+	if !ok {
+		return 0, 0, false
+	}
+	base := baseSeverity(n)
+	return base, base + 3, true
+}
+
+// NormalizeSeverity fills in a missing severity number from the text and
+// replaces the text with the canonical level name, so that "warning", "WARN"
+// and severity number 13 are stored alike. Unknown texts without a number
+// are kept as is.
+func NormalizeSeverity(number uint8, text string) (uint8, string) {
+	if number == 0 || number > SeverityMax {
+		n, ok := ParseSeverityText(text)
+		if !ok {
+			return number, text
+		}
+		number = n
+	}
+	return number, SeverityText(number)
+}
+
+func baseSeverity(n uint8) uint8 {
+	return (n-1)/4*4 + 1
+}
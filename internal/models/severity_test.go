@@ -0,0 +1,65 @@
+package models
+
+import "testing"
+
+func TestParseSeverityText(t *testing.T) {
+	tests := []struct {
+		text string
+		want uint8
+		ok   bool
+	}{
+		{"WARN", 13, true},
+		{"warning", 13, true},
+		{" Error ", 17, true},
+		{"INFO3", 11, true},
+		{"fatal4", 24, true},
+		{"critical", 21, true},
+		{"notice2", 0, false},
+		{"WARN5", 0, false},
+		{"verbose", 0, false},
+		{"", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := ParseSeverityText(tt.text)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("ParseSeverityText(%q) = %d, %v; want %d, %v", tt.text, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestSeverityRange(t *testing.T) {
+	min, max, ok := SeverityRange("warning")
+	if !ok || min != 13 || max != 16 {
+		t.Errorf("SeverityRange(warning) = %d, %d, %v", min, max, ok)
+	}
+	min, max, ok = SeverityRange("ERROR3")
+	if !ok || min != 17 || max != 20 {
+		t.Errorf("SeverityRange(ERROR3) = %d, %d, %v", min, max, ok)
+	}
+	if _, _, ok := SeverityRange("loud"); ok {
+		t.Error("expected unknown text to have no range")
+	}
+}
+
+func TestNormalizeSeverity(t *testing.T) {
+	tests := []struct {
+		number   uint8
+		text     string
+		wantNum  uint8
+		wantText string
+	}{
+		{13, "", 13, "WARN"},
+		{0, "warning", 13, "WARN"},
+		{14, "Warn", 14, "WARN"},
+		{17, "whatever", 17, "ERROR"},
+		{0, "verbose", 0, "verbose"},
+		{0, "", 0, ""},
+	}
+	for _, tt := range tests {
+		num, text := NormalizeSeverity(tt.number, tt.text)
+		if num != tt.wantNum || text != tt.wantText {
+			t.Errorf("NormalizeSeverity(%d, %q) = %d, %q; want %d, %q",
+				tt.number, tt.text, num, text, tt.wantNum, tt.wantText)
+		}
+	}
+}
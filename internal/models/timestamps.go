@@ -0,0 +1,46 @@
+package models
+
+import (
+	"strconv"
+	"time"
+)
+
+// TimestampPrecision is the number of fractional second digits stored by
+// the DateTime64 columns of the schema: nanoseconds
+const TimestampPrecision = 9
+
+// Span attributes holding the exact start and end of a span, in Unix
+// nanoseconds, when the time columns of otel_traces are less precise
+const (
+	StartTimeAttribute = "otel.span.start_time_unix_nano"
+	EndTimeAttribute   = "otel.span.end_time_unix_nano"
+)
+
+// TruncateTime drops the digits of t that a DateTime64(precision) column
+// does not store, as ClickHouse does on insert
+func TruncateTime(t time.Time, precision int) time.Time {
+	if precision >= TimestampPrecision || precision < 0 {
+		return t
+	}
+	unit := time.Nanosecond
+	for i := precision; i < TimestampPrecision; i++ {
+		unit *= 10
+	}
+	return t.Truncate(unit)
+}
+
+// ExactTime returns the time held by attrs[key] in Unix nanoseconds, or t
+// when the attribute is missing or not a number. Spans stored in tables
+// with less precise time columns carry their exact times in
+// StartTimeAttribute and EndTimeAttribute.
+func ExactTime(t time.Time, attrs map[string]string, key string) time.Time {
+	v, ok := attrs[key]
+	if !ok {
+		return t
+	}
+	ns, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return t
+	}
+	return time.Unix(0, ns).In(t.Location())
+}
@@ -0,0 +1,38 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTruncateTime(t *testing.T) {
+	ts := time.Unix(1700000000, 123456789)
+	tests := []struct {
+		precision int
+		want      int
+	}{
+		{9, 123456789},
+		{6, 123456000},
+		{3, 123000000},
+		{0, 0},
+	}
+	for _, tt := range tests {
+		if got := TruncateTime(ts, tt.precision); got.Nanosecond() != tt.want || got.Unix() != 1700000000 {
+			t.Errorf("TruncateTime(%d) = %v, want %d ns", tt.precision, got, tt.want)
+		}
+	}
+}
+
+func TestExactTime(t *testing.T) {
+	stored := time.Unix(1700000000, 123000000).UTC()
+	attrs := map[string]string{StartTimeAttribute: "1700000000123456789", EndTimeAttribute: "soon"}
+	if got := ExactTime(stored, attrs, StartTimeAttribute); got.UnixNano() != 1700000000123456789 || got.Location() != time.UTC {
+		t.Errorf("exact start = %v", got)
+	}
+	if got := ExactTime(stored, attrs, EndTimeAttribute); !got.Equal(stored) {
+		t.Errorf("malformed attribute should keep the stored time, got %v", got)
+	}
+	if got := ExactTime(stored, nil, StartTimeAttribute); !got.Equal(stored) {
+		t.Errorf("missing attribute should keep the stored time, got %v", got)
+	}
+}
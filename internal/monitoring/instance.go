@@ -0,0 +1,62 @@
+package monitoring
+
+import (
+	"os"
+	"sort"
+
+	"otelservices/internal/config"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// InstanceLabel is the label naming the instance on every metric a service
+// exposes, so replicas behind one scrape job can be told apart
+const InstanceLabel = "instance_id"
+
+// InstanceID returns the name of this instance: monitoring.instance_id,
+// else $POD_NAME, else the host name
+func InstanceID(cfg config.MonitoringConfig) string {
+	if cfg.InstanceID != "" {
+		return cfg.InstanceID
+	}
+	if pod := os.Getenv("POD_NAME"); pod != "" {
+		return pod
+	}
+	if host, err := os.Hostname(); err == nil && host != "" {
+		return host
+	}
+	return "unknown"
+}
+
+// instanceGatherer adds the instance label to the metrics of a gatherer.
+// Metrics that already carry the label keep theirs.
+type instanceGatherer struct {
+	prometheus.Gatherer
+	instanceID string
+}
+
+// Gather implements prometheus.Gatherer
+func (g instanceGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := g.Gatherer.Gather()
+	name := InstanceLabel
+	for _, mf := range families {
+		for _, m := range mf.Metric {
+			if hasLabel(m.Label, name) {
+				continue
+			}
+			m.Label = append(m.Label, &dto.LabelPair{Name: &name, Value: &g.instanceID})
+			sort.Slice(m.Label, func(i, j int) bool { return m.Label[i].GetName() < m.Label[j].GetName() })
+		}
+	}
+	return families, err
+}
+
+func hasLabel(labels []*dto.LabelPair, name string) bool {
+	for _, l := range labels {
+		if l.GetName() == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,54 @@
+package monitoring
+
+import (
+	"testing"
+
+	"otelservices/internal/config"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestInstanceID(t *testing.T) {
+	if got := InstanceID(config.MonitoringConfig{InstanceID: "collector-a"}); got != "collector-a" {
+		t.Errorf("configured instance ID %q", got)
+	}
+	t.Setenv("POD_NAME", "otel-collector-7d9f")
+	if got := InstanceID(config.MonitoringConfig{}); got != "otel-collector-7d9f" {
+		t.Errorf("pod instance ID %q", got)
+	}
+	t.Setenv("POD_NAME", "")
+	if got := InstanceID(config.MonitoringConfig{}); got == "" {
+		t.Error("no instance ID without a pod name")
+	}
+}
+
+func TestInstanceGatherer(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_requests_total", Help: "Requests"}, []string{"status"})
+	reg.MustRegister(requests)
+	requests.WithLabelValues("ok").Inc()
+	labelled := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test_labelled", Help: "Already labelled"}, []string{InstanceLabel})
+	reg.MustRegister(labelled)
+	labelled.WithLabelValues("other").Set(1)
+
+	families, err := instanceGatherer{Gatherer: reg, instanceID: "collector-a"}.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"test_requests_total": "instance_id=collector-a,status=ok",
+		"test_labelled":       "instance_id=other",
+	}
+	for _, mf := range families {
+		var got string
+		for i, l := range mf.Metric[0].Label {
+			if i > 0 {
+				got += ","
+			}
+			got += l.GetName() + "=" + l.GetValue()
+		}
+		if got != want[mf.GetName()] {
+			t.Errorf("%s labels %s, want %s", mf.GetName(), got, want[mf.GetName()])
+		}
+	}
+}
@@ -4,12 +4,20 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"otelservices/internal/config"
+
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
 	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/sdk/resource"
@@ -43,6 +51,31 @@ var (
 		[]string{"service"},
 	)
 
+	// Error counts per service, for health alerting without querying storage
+	ErrorSpans = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "otel_error_spans_total",
+			Help: "Total number of received spans with status ERROR",
+		},
+		[]string{"service"},
+	)
+
+	ErrorLogs = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "otel_error_logs_total",
+			Help: "Total number of received logs with severity ERROR or FATAL",
+		},
+		[]string{"service", "severity"},
+	)
+
+	SamplingDecisions = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "otel_sampling_decisions_total",
+			Help: "Total number of sampling decisions by signal, policy and outcome",
+		},
+		[]string{"signal_type", "policy", "decision"},
+	)
+
 	// Metrics for storage operations
 	StorageWrites = promauto.NewCounterVec(
 		prometheus.CounterOpts{
@@ -61,6 +94,30 @@ var (
 		[]string{"table"},
 	)
 
+	StorageRowsWritten = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "otel_storage_rows_written_total",
+			Help: "Total number of rows written to storage",
+		},
+		[]string{"table"},
+	)
+
+	StorageDeduplicatedBlocks = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "otel_storage_deduplicated_blocks_total",
+			Help: "Total number of inserted blocks ClickHouse dropped as duplicates of an earlier insert",
+		},
+		[]string{"table"},
+	)
+
+	LastSuccessfulInsert = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "otel_storage_last_successful_insert_timestamp_seconds",
+			Help: "Unix time of the last successful insert of each signal",
+		},
+		[]string{"signal_type"},
+	)
+
 	BatchSize = promauto.NewHistogramVec(
 		prometheus.HistogramOpts{
 			Name:    "otel_batch_size",
@@ -70,6 +127,98 @@ var (
 		[]string{"signal_type"},
 	)
 
+	BatchTargetSize = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "otel_batch_target_size",
+			Help: "Number of items at which batches are flushed, adapted to insert latency",
+		},
+		[]string{"signal_type"},
+	)
+
+	ClickHouseAddresses = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "otel_clickhouse_addresses",
+			Help: "Number of ClickHouse addresses the client is connected to after discovery",
+		},
+	)
+
+	ClickHouseUp = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "otel_clickhouse_up",
+			Help: "Whether the ClickHouse watchdog's last pings succeeded (1) or reached the failure threshold (0)",
+		},
+	)
+
+	ClickHousePingFailures = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "otel_clickhouse_ping_failures_total",
+			Help: "Total number of failed ClickHouse watchdog pings",
+		},
+	)
+
+	ClickHouseReconnects = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "otel_clickhouse_reconnects_total",
+			Help: "Total number of reconnects after failed ClickHouse pings",
+		},
+		[]string{"status"},
+	)
+
+	ClickHouseInsertWireBytes = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "otel_clickhouse_insert_wire_bytes_total",
+			Help: "Bytes of inserts received by ClickHouse over the network, from system.query_log",
+		},
+		[]string{"table"},
+	)
+
+	ClickHouseInsertUncompressedBytes = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "otel_clickhouse_insert_uncompressed_bytes_total",
+			Help: "Uncompressed bytes written by inserts, from system.query_log",
+		},
+		[]string{"table"},
+	)
+
+	ClickHouseInsertCompressionRatio = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "otel_clickhouse_insert_compression_ratio",
+			Help: "Uncompressed to on-the-wire bytes of inserts over the last stats interval",
+		},
+		[]string{"table"},
+	)
+
+	DependencyUp = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "otel_dependency_up",
+			Help: "Whether each dependency passed its last readiness check",
+		},
+		[]string{"dependency"},
+	)
+
+	ClickHouseShardReplicas = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "otel_clickhouse_shard_replicas",
+			Help: "Number of replicas of each shard of the ClickHouse cluster",
+		},
+		[]string{"shard"},
+	)
+
+	ClickHouseShardReplicasUp = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "otel_clickhouse_shard_replicas_up",
+			Help: "Number of replicas of each shard that answered the last health check",
+		},
+		[]string{"shard"},
+	)
+
+	ClickHouseDiscoveryErrors = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "otel_clickhouse_discovery_errors_total",
+			Help: "Total number of failed ClickHouse address discovery refreshes",
+		},
+	)
+
 	// Metrics for queries
 	QueryDuration = promauto.NewHistogramVec(
 		prometheus.HistogramOpts{
@@ -88,6 +237,234 @@ var (
 		[]string{"query_type"},
 	)
 
+	QueryAuthFailures = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "otel_query_auth_failures_total",
+			Help: "Total number of query API requests rejected for missing or invalid credentials or a missing scope",
+		},
+		[]string{"reason"},
+	)
+
+	QueryAuditDropped = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "otel_query_audit_dropped_total",
+			Help: "Total number of query audit records dropped because the audit queue was full or could not be written",
+		},
+	)
+
+	QueryBudgetExceeded = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "otel_query_budget_exceeded_total",
+			Help: "Total number of queries whose estimated cost exceeded the query budget",
+		},
+		[]string{"query_type", "action"},
+	)
+
+	QueryLimitsApplied = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "otel_query_limits_applied_total",
+			Help: "Total number of queries rejected or clamped by a query limit",
+		},
+		[]string{"query_type", "limit", "action"},
+	)
+
+	TieredQueries = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "otel_query_tiered_total",
+			Help: "Total number of queries reaching data moved to the tiering volume",
+		},
+		[]string{"query_type"},
+	)
+
+	ExportedBytes = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "otel_query_export_bytes_total",
+			Help: "Total bytes of export files written by export jobs",
+		},
+		[]string{"format", "destination"},
+	)
+
+	RetentionPartitionsDropped = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "otel_retention_partitions_dropped_total",
+			Help: "Total number of expired partitions dropped by the retention purge",
+		},
+		[]string{"table"},
+	)
+
+	// Metrics for anomaly detection
+	AnomalyScore = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "otel_anomaly_score",
+			Help: "Standard deviations between the latest value and the service's baseline",
+		},
+		[]string{"service", "signal"},
+	)
+
+	AnomalyActive = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "otel_anomaly_active",
+			Help: "Whether the service's signal is currently anomalous (1) or not (0)",
+		},
+		[]string{"service", "signal"},
+	)
+
+	AnomaliesDetected = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "otel_anomalies_detected_total",
+			Help: "Total number of anomalies detected, counted when they start",
+		},
+		[]string{"service", "signal"},
+	)
+
+	// Metrics for service level objectives
+	SLOCompliance = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "otel_slo_compliance",
+			Help: "Fraction of good requests over the objective's window",
+		},
+		[]string{"slo", "service"},
+	)
+
+	SLOErrorBudgetRemaining = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "otel_slo_error_budget_remaining",
+			Help: "Fraction of the objective's error budget left over its window, negative when overspent",
+		},
+		[]string{"slo", "service"},
+	)
+
+	SLOBurnRate = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "otel_slo_burn_rate",
+			Help: "Rate the error budget is spent at over the burn window, 1 spending it exactly over the objective's window",
+		},
+		[]string{"slo", "service", "window"},
+	)
+
+	CacheRequests = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "otel_query_cache_requests_total",
+			Help: "Total number of query cache lookups",
+		},
+		[]string{"cache", "result"},
+	)
+
+	QueriesInFlight = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "otel_query_requests_in_flight",
+			Help: "Number of query API requests holding a query gate slot",
+		},
+	)
+
+	QueriesQueued = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "otel_query_requests_queued",
+			Help: "Number of query API requests waiting for a query gate slot",
+		},
+	)
+
+	QueriesRejected = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "otel_query_requests_rejected_total",
+			Help: "Total number of query API requests rejected because the query gate was saturated",
+		},
+		[]string{"route", "reason"},
+	)
+
+	// Metrics for downstream OTLP exporters
+	ExporterRequests = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "otel_exporter_requests_total",
+			Help: "Total number of export requests sent to downstream endpoints",
+		},
+		[]string{"exporter", "signal_type", "status"},
+	)
+
+	ExporterDropped = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "otel_exporter_dropped_total",
+			Help: "Total number of export requests dropped due to a full queue or exhausted retries",
+		},
+		[]string{"exporter", "signal_type", "reason"},
+	)
+
+	ExporterSendDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "otel_exporter_send_duration_seconds",
+			Help:    "Duration of export requests to downstream endpoints",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"exporter"},
+	)
+
+	ExporterQueueSize = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "otel_exporter_queue_size",
+			Help: "Current number of requests waiting in an exporter queue",
+		},
+		[]string{"exporter"},
+	)
+
+	ExporterUp = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "otel_exporter_up",
+			Help: "Whether the last export request to the downstream endpoint succeeded (1) or failed (0)",
+		},
+		[]string{"exporter"},
+	)
+
+	// Metrics for Kafka buffering
+	KafkaMessages = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "otel_kafka_messages_total",
+			Help: "Total number of Kafka messages published or consumed",
+		},
+		[]string{"topic", "status"},
+	)
+
+	KafkaConsumerLag = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "otel_kafka_consumer_lag",
+			Help: "Number of messages behind the partition high-water mark at the last fetch",
+		},
+		[]string{"topic"},
+	)
+
+	// Metrics for the disk queue
+	DiskQueueBatches = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "otel_disk_queue_batches_total",
+			Help: "Total number of batches queued, written, rejected or skipped as corrupt by the disk queue",
+		},
+		[]string{"signal_type", "status"},
+	)
+
+	DiskQueueBytes = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "otel_disk_queue_bytes",
+			Help: "Bytes in the disk queue not yet written to storage",
+		},
+		[]string{"signal_type"},
+	)
+
+	// Metrics for the metric rollups
+	RollupLag = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "otel_rollup_lag_seconds",
+			Help: "How far the newest rollup window trails the newest raw metric window",
+		},
+		[]string{"table"},
+	)
+
+	RollupErrors = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "otel_rollup_errors_total",
+			Help: "Total number of failed rollup lag measurements",
+		},
+		[]string{"table"},
+	)
+
 	// System metrics
 	MemoryUsage = promauto.NewGauge(
 		prometheus.GaugeOpts{
@@ -96,6 +473,43 @@ var (
 		},
 	)
 
+	MemoryLimiterState = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "otel_memory_limiter_state",
+			Help: "Memory limiter state: 0 normal, 1 over soft limit, 2 over hard limit",
+		},
+	)
+
+	MemoryLimiterRefused = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "otel_memory_limiter_refused_total",
+			Help: "Total number of export requests refused by the memory limiter",
+		},
+		[]string{"signal_type"},
+	)
+
+	ExportsInFlight = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "otel_exports_in_flight",
+			Help: "Number of Export requests currently being handled",
+		},
+	)
+
+	ExportsRejected = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "otel_exports_rejected_total",
+			Help: "Total number of Export requests rejected because max_concurrent_exports were in flight",
+		},
+		[]string{"protocol"},
+	)
+
+	MemoryLimiterForcedGC = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "otel_memory_limiter_forced_gc_total",
+			Help: "Total number of garbage collections forced by the memory limiter",
+		},
+	)
+
 	QueueSize = promauto.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "otel_queue_size",
@@ -103,28 +517,130 @@ var (
 		},
 		[]string{"signal_type"},
 	)
+
+	K8sPods = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "otel_k8s_pods",
+			Help: "Number of Kubernetes pods known to attribute enrichment",
+		},
+	)
+
+	K8sEnrichedResources = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "otel_k8s_enriched_resources_total",
+			Help: "Total number of resources looked up for Kubernetes attribute enrichment, by whether a pod matched",
+		},
+		[]string{"result"},
+	)
+
+	K8sWatchErrors = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "otel_k8s_watch_errors_total",
+			Help: "Total number of failed Kubernetes pod lists and watches",
+		},
+	)
+
+	RoutedSpans = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "otel_routing_spans_total",
+			Help: "Total number of received spans by where trace routing sent them: local or forwarded to the peer owning the trace",
+		},
+		[]string{"destination"},
+	)
+
+	RoutingPeers = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "otel_routing_peers",
+			Help: "Number of collectors on the trace routing ring, including this one",
+		},
+	)
+
+	RoutingDiscoveryErrors = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "otel_routing_discovery_errors_total",
+			Help: "Total number of failed reads of the Kubernetes endpoints of the routing peers",
+		},
+	)
+
+	EnrichmentLookups = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "otel_enrichment_lookups_total",
+			Help: "Total number of GeoIP and user agent enrichment lookups, by whether they matched",
+		},
+		[]string{"enrichment", "result"},
+	)
+
+	QuotaRejectedItems = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "otel_quota_rejected_items_total",
+			Help: "Total number of spans, metrics and log records rejected because their service was over its ingest quota",
+		},
+		[]string{"signal", "service_name"},
+	)
+
+	QuotaUsageErrors = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "otel_quota_usage_errors_total",
+			Help: "Total number of failed writes and reads of the ingest usage table",
+		},
+	)
+
+	TraceContextInvalid = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "otel_trace_context_invalid_total",
+			Help: "Total number of invalid trace, span and parent span IDs repaired or cleared at ingest",
+		},
+		[]string{"signal", "service_name", "field", "problem"},
+	)
+
+	TimestampPrecision = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "otel_clickhouse_timestamp_precision_digits",
+			Help: "Fractional second digits stored by each time column, as found at startup; below 9 truncates nanoseconds",
+		},
+		[]string{"table", "column"},
+	)
 )
 
-// InitTracing initializes OpenTelemetry tracing
-func InitTracing(serviceName, serviceVersion string, sampleRate float64) (func(context.Context) error, error) {
+// InitTracing initializes OpenTelemetry tracing, exporting the services' own
+// spans over OTLP/gRPC to the endpoint chosen by resolveOTLPTarget
+func InitTracing(serviceName, serviceVersion string, cfg config.MonitoringConfig) (func(context.Context) error, error) {
+	target, err := resolveOTLPTarget(cfg, os.Getenv)
+	if err != nil {
+		return nil, err
+	}
+	opts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(target.endpoint)}
+	if target.insecure {
+		opts = append(opts, otlptracegrpc.WithInsecure())
+	}
+	if len(target.headers) > 0 {
+		opts = append(opts, otlptracegrpc.WithHeaders(target.headers))
+	}
+	return InitTracingClient(serviceName, serviceVersion, cfg, otlptracegrpc.NewClient(opts...))
+}
+
+// InitTracingClient is InitTracing with the spans handed to client instead,
+// e.g. one that feeds them to the collector's own pipeline. The endpoint
+// settings of cfg are ignored.
+func InitTracingClient(serviceName, serviceVersion string, cfg config.MonitoringConfig, client otlptrace.Client) (func(context.Context) error, error) {
 	ctx := context.Background()
 
-	// Create resource
+	// Create resource; OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES
+	// override and add to the attributes
 	res, err := resource.New(ctx,
 		resource.WithAttributes(
 			semconv.ServiceName(serviceName),
 			semconv.ServiceVersion(serviceVersion),
+			semconv.ServiceInstanceID(InstanceID(cfg)),
 		),
+		resource.WithFromEnv(),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create resource: %w", err)
 	}
 
 	// Create OTLP trace exporter
-	traceExporter, err := otlptracegrpc.New(ctx,
-		otlptracegrpc.WithInsecure(),
-		otlptracegrpc.WithEndpoint("localhost:4317"),
-	)
+	traceExporter, err := otlptrace.New(ctx, client)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create trace exporter: %w", err)
 	}
@@ -133,7 +649,7 @@ func InitTracing(serviceName, serviceVersion string, sampleRate float64) (func(c
 	tp := sdktrace.NewTracerProvider(
 		sdktrace.WithBatcher(traceExporter),
 		sdktrace.WithResource(res),
-		sdktrace.WithSampler(sdktrace.TraceIDRatioBased(sampleRate)),
+		sdktrace.WithSampler(sdktrace.TraceIDRatioBased(cfg.TraceSampleRate)),
 	)
 
 	otel.SetTracerProvider(tp)
@@ -145,10 +661,75 @@ func InitTracing(serviceName, serviceVersion string, sampleRate float64) (func(c
 	return tp.Shutdown, nil
 }
 
-// StartMetricsServer starts the Prometheus metrics HTTP server
-func StartMetricsServer(port int, path string) *http.Server {
+// defaultOTLPEndpoint is used when neither the config nor the environment
+// name an endpoint
+const defaultOTLPEndpoint = "localhost:4317"
+
+// otlpTarget is where and how self-traces are exported
+type otlpTarget struct {
+	endpoint string // host:port
+	insecure bool
+	headers  map[string]string
+}
+
+// resolveOTLPTarget combines the monitoring config with the standard
+// OTEL_EXPORTER_OTLP_* variables, which take precedence; the _TRACES_
+// variants win over the generic ones. Headers from the environment are
+// added to the configured headers.
+func resolveOTLPTarget(cfg config.MonitoringConfig, getenv func(string) string) (otlpTarget, error) {
+	env := func(name string) string {
+		if v := getenv("OTEL_EXPORTER_OTLP_TRACES_" + name); v != "" {
+			return v
+		}
+		return getenv("OTEL_EXPORTER_OTLP_" + name)
+	}
+
+	target := otlpTarget{endpoint: cfg.OTLPEndpoint, insecure: cfg.OTLPInsecure, headers: map[string]string{}}
+	for k, v := range cfg.OTLPHeaders {
+		target.headers[k] = v
+	}
+	if v := env("ENDPOINT"); v != "" {
+		target.endpoint = v
+	}
+	if target.endpoint == "" {
+		target.endpoint = defaultOTLPEndpoint
+	}
+	if err := config.ValidateOTLPEndpoint(target.endpoint); err != nil {
+		return otlpTarget{}, fmt.Errorf("invalid OTLP endpoint: %w", err)
+	}
+	// A URL's scheme decides between TLS and plaintext, unless overridden
+	if u, err := url.Parse(target.endpoint); err == nil && u.Host != "" && strings.Contains(target.endpoint, "://") {
+		target.endpoint = u.Host
+		target.insecure = u.Scheme == "http"
+	}
+	if v := env("INSECURE"); v != "" {
+		insecure, err := strconv.ParseBool(v)
+		if err != nil {
+			return otlpTarget{}, fmt.Errorf("invalid OTEL_EXPORTER_OTLP_INSECURE %q: %w", v, err)
+		}
+		target.insecure = insecure
+	}
+	if v := env("HEADERS"); v != "" {
+		for _, pair := range strings.Split(v, ",") {
+			key, value, ok := strings.Cut(pair, "=")
+			if !ok {
+				return otlpTarget{}, fmt.Errorf("invalid OTEL_EXPORTER_OTLP_HEADERS entry %q", pair)
+			}
+			if unescaped, err := url.QueryUnescape(strings.TrimSpace(value)); err == nil {
+				value = unescaped
+			}
+			target.headers[strings.TrimSpace(key)] = value
+		}
+	}
+	return target, nil
+}
+
+// StartMetricsServer starts the Prometheus metrics HTTP server, which labels
+// every metric with the instance ID
+func StartMetricsServer(port int, path, instanceID string) *http.Server {
 	mux := http.NewServeMux()
-	mux.Handle(path, promhttp.Handler())
+	gatherer := instanceGatherer{Gatherer: prometheus.DefaultGatherer, instanceID: instanceID}
+	mux.Handle(path, promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{})))
 
 	srv := &http.Server{
 		Addr:         fmt.Sprintf(":%d", port),
@@ -166,19 +747,96 @@ func StartMetricsServer(port int, path string) *http.Server {
 	return srv
 }
 
-// HealthCheck represents a health check handler
+// HealthState is the lifecycle state reported by HealthCheck
+type HealthState int
+
+const (
+	// StateStarting means the service is initializing and not serving yet
+	StateStarting HealthState = iota
+	// StateReady means the service is fully operational
+	StateReady
+	// StateDegraded means the service is serving but a dependency is impaired
+	StateDegraded
+	// StateDraining means the service is shutting down and should not get new traffic
+	StateDraining
+	// StateUnavailable means a dependency the service cannot work without is down
+	StateUnavailable
+)
+
+// String returns the state name
+func (s HealthState) String() string {
+	switch s {
+	case StateStarting:
+		return "starting"
+	case StateReady:
+		return "ready"
+	case StateDegraded:
+		return "degraded"
+	case StateDraining:
+		return "draining"
+	case StateUnavailable:
+		return "unavailable"
+	}
+	return "unknown"
+}
+
+// HealthCheck tracks service state for the liveness and readiness probes.
+// It is safe for concurrent use.
 type HealthCheck struct {
-	ready bool
+	mu           sync.RWMutex
+	state        HealthState
+	reason       string
+	dependencies []Dependency
 }
 
-// NewHealthCheck creates a new health check handler
+// NewHealthCheck creates a new health check handler in the starting state
 func NewHealthCheck() *HealthCheck {
-	return &HealthCheck{ready: false}
+	return &HealthCheck{state: StateStarting}
+}
+
+// SetState updates the state and the reason reported with it
+func (h *HealthCheck) SetState(state HealthState, reason string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.state = state
+	h.reason = reason
 }
 
-// SetReady marks the service as ready
+// SetReady marks the service as ready, or back to starting
 func (h *HealthCheck) SetReady(ready bool) {
-	h.ready = ready
+	if ready {
+		h.SetState(StateReady, "")
+	} else {
+		h.SetState(StateStarting, "")
+	}
+}
+
+// SetAvailable moves a serving service to unavailable when a required
+// dependency fails, and back to ready when it recovers. Starting and
+// draining services keep their state.
+func (h *HealthCheck) SetAvailable(available bool, reason string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	switch {
+	case !available && (h.state == StateReady || h.state == StateDegraded):
+		h.state, h.reason = StateUnavailable, reason
+	case available && h.state == StateUnavailable:
+		h.state, h.reason = StateReady, ""
+	}
+}
+
+// State returns the current state and reason
+func (h *HealthCheck) State() (HealthState, string) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.state, h.reason
+}
+
+// IsReady reports whether the service should receive traffic. Degraded
+// services still serve, so they count as ready.
+func (h *HealthCheck) IsReady() bool {
+	state, _ := h.State()
+	return state == StateReady || state == StateDegraded
 }
 
 // LivenessHandler handles liveness probe requests
@@ -186,14 +844,3 @@ func (h *HealthCheck) LivenessHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("OK"))
 }
-
-// ReadinessHandler handles readiness probe requests
-func (h *HealthCheck) ReadinessHandler(w http.ResponseWriter, r *http.Request) {
-	if h.ready {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("Ready"))
-	} else {
-		w.WriteHeader(http.StatusServiceUnavailable)
-		w.Write([]byte("Not Ready"))
-	}
-}
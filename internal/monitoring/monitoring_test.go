@@ -2,10 +2,13 @@ package monitoring
 
 import (
 	"context"
+	"encoding/json"
 	"io"
 	"net/http"
 	"testing"
 	"time"
+
+	"otelservices/internal/config"
 )
 
 func TestNewHealthCheck(t *testing.T) {
@@ -13,9 +16,12 @@ func TestNewHealthCheck(t *testing.T) {
 	if hc == nil {
 		t.Fatal("NewHealthCheck() returned nil")
 	}
-	if hc.ready {
+	if hc.IsReady() {
 		t.Error("Expected ready to be false initially")
 	}
+	if state, _ := hc.State(); state != StateStarting {
+		t.Errorf("Expected initial state starting, got %s", state)
+	}
 }
 
 func TestHealthCheckSetReady(t *testing.T) {
@@ -23,13 +29,13 @@ func TestHealthCheckSetReady(t *testing.T) {
 
 	// Test setting ready to true
 	hc.SetReady(true)
-	if !hc.ready {
+	if !hc.IsReady() {
 		t.Error("Expected ready to be true after SetReady(true)")
 	}
 
 	// Test setting ready to false
 	hc.SetReady(false)
-	if hc.ready {
+	if hc.IsReady() {
 		t.Error("Expected ready to be false after SetReady(false)")
 	}
 }
@@ -85,13 +91,13 @@ func TestHealthCheckReadinessHandler(t *testing.T) {
 			name:           "not ready",
 			ready:          false,
 			expectedStatus: http.StatusServiceUnavailable,
-			expectedBody:   "Not Ready",
+			expectedBody:   "starting",
 		},
 		{
 			name:           "ready",
 			ready:          true,
 			expectedStatus: http.StatusOK,
-			expectedBody:   "Ready",
+			expectedBody:   "ready",
 		},
 	}
 
@@ -114,13 +120,101 @@ func TestHealthCheckReadinessHandler(t *testing.T) {
 				t.Errorf("Expected status %d, got %d", tt.expectedStatus, rr.statusCode)
 			}
 
-			if string(rr.body) != tt.expectedBody {
-				t.Errorf("Expected body '%s', got '%s'", tt.expectedBody, string(rr.body))
+			var report ReadinessReport
+			if err := json.Unmarshal(rr.body, &report); err != nil {
+				t.Fatalf("invalid report %q: %v", rr.body, err)
+			}
+			if report.Status != tt.expectedBody {
+				t.Errorf("Expected status '%s', got '%s'", tt.expectedBody, report.Status)
+			}
+		})
+	}
+}
+
+func TestHealthCheckStates(t *testing.T) {
+	hc := NewHealthCheck()
+
+	tests := []struct {
+		state          HealthState
+		reason         string
+		ready          bool
+		expectedStatus int
+		expectedBody   string
+	}{
+		{StateStarting, "warming caches", false, http.StatusServiceUnavailable, "starting: warming caches"},
+		{StateReady, "", true, http.StatusOK, "ready: "},
+		{StateDegraded, "clickhouse slow", true, http.StatusOK, "degraded: clickhouse slow"},
+		{StateDraining, "shutting down", false, http.StatusServiceUnavailable, "draining: shutting down"},
+		{StateUnavailable, "clickhouse unreachable", false, http.StatusServiceUnavailable, "unavailable: clickhouse unreachable"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.state.String(), func(t *testing.T) {
+			hc.SetState(tt.state, tt.reason)
+
+			if hc.IsReady() != tt.ready {
+				t.Errorf("IsReady() = %v, want %v", hc.IsReady(), tt.ready)
+			}
+
+			rr := &testResponseWriter{header: make(http.Header)}
+			hc.ReadinessHandler(rr, &http.Request{})
+
+			if rr.statusCode != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, rr.statusCode)
+			}
+			var report ReadinessReport
+			if err := json.Unmarshal(rr.body, &report); err != nil {
+				t.Fatalf("invalid report %q: %v", rr.body, err)
+			}
+			if got := report.Status + ": " + report.Reason; got != tt.expectedBody {
+				t.Errorf("Expected '%s', got '%s'", tt.expectedBody, got)
 			}
 		})
 	}
 }
 
+func TestHealthCheckSetAvailable(t *testing.T) {
+	hc := NewHealthCheck()
+	hc.SetAvailable(false, "clickhouse unreachable")
+	if state, _ := hc.State(); state != StateStarting {
+		t.Errorf("starting service became %s", state)
+	}
+
+	hc.SetReady(true)
+	hc.SetAvailable(false, "clickhouse unreachable")
+	if state, reason := hc.State(); state != StateUnavailable || reason != "clickhouse unreachable" {
+		t.Errorf("state = %s (%s), want unavailable", state, reason)
+	}
+	hc.SetAvailable(true, "")
+	if state, _ := hc.State(); state != StateReady {
+		t.Errorf("recovered service is %s, want ready", state)
+	}
+
+	hc.SetState(StateDraining, "shutting down")
+	hc.SetAvailable(false, "clickhouse unreachable")
+	hc.SetAvailable(true, "")
+	if state, _ := hc.State(); state != StateDraining {
+		t.Errorf("draining service became %s", state)
+	}
+}
+
+func TestHealthCheckConcurrentAccess(t *testing.T) {
+	hc := NewHealthCheck()
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			hc.SetState(HealthState(i%4), "reason")
+		}
+	}()
+	for i := 0; i < 1000; i++ {
+		hc.IsReady()
+		hc.State()
+	}
+	<-done
+}
+
 func TestPrometheusMetrics(t *testing.T) {
 	// Increment some metrics to verify they work
 	ReceivedSpans.WithLabelValues("test-service").Inc()
@@ -144,7 +238,7 @@ func TestPrometheusMetrics(t *testing.T) {
 
 func TestStartMetricsServer(t *testing.T) {
 	// Start metrics server
-	srv := StartMetricsServer(0, "/metrics") // Use random port
+	srv := StartMetricsServer(0, "/metrics", "test-instance") // Use random port
 	if srv == nil {
 		t.Fatal("StartMetricsServer returned nil")
 	}
@@ -246,3 +340,42 @@ func TestStorageWriteDurationHistogram(t *testing.T) {
 
 	t.Log("Storage write duration observations successful")
 }
+
+func TestResolveOTLPTarget(t *testing.T) {
+	cfg := config.MonitoringConfig{
+		OTLPEndpoint: "collector:4317",
+		OTLPInsecure: true,
+		OTLPHeaders:  map[string]string{"x-tenant": "ops"},
+	}
+	env := map[string]string{}
+	getenv := func(k string) string { return env[k] }
+
+	target, err := resolveOTLPTarget(cfg, getenv)
+	if err != nil || target.endpoint != "collector:4317" || !target.insecure || target.headers["x-tenant"] != "ops" {
+		t.Errorf("config only: %+v, %v", target, err)
+	}
+
+	env["OTEL_EXPORTER_OTLP_ENDPOINT"] = "http://generic:4317"
+	env["OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"] = "https://traces.example.com:443"
+	env["OTEL_EXPORTER_OTLP_HEADERS"] = "authorization=Bearer%20abc, x-tenant=prod"
+	target, err = resolveOTLPTarget(cfg, getenv)
+	if err != nil || target.endpoint != "traces.example.com:443" || target.insecure {
+		t.Errorf("env endpoint: %+v, %v", target, err)
+	}
+	if target.headers["authorization"] != "Bearer abc" || target.headers["x-tenant"] != "prod" {
+		t.Errorf("env headers: %v", target.headers)
+	}
+
+	env["OTEL_EXPORTER_OTLP_INSECURE"] = "true"
+	if target, _ = resolveOTLPTarget(cfg, getenv); !target.insecure {
+		t.Error("OTEL_EXPORTER_OTLP_INSECURE should override the scheme")
+	}
+	env["OTEL_EXPORTER_OTLP_INSECURE"] = "maybe"
+	if _, err := resolveOTLPTarget(cfg, getenv); err == nil {
+		t.Error("expected error for invalid insecure flag")
+	}
+
+	if target, _ := resolveOTLPTarget(config.MonitoringConfig{}, func(string) string { return "" }); target.endpoint != defaultOTLPEndpoint {
+		t.Errorf("default endpoint = %q", target.endpoint)
+	}
+}
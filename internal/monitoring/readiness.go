@@ -0,0 +1,121 @@
+package monitoring
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// dependencyCheckTimeout bounds each dependency check of a readiness probe
+const dependencyCheckTimeout = 2 * time.Second
+
+// Dependency is something the service needs to serve. Check returns nil when
+// it is usable. A failing critical dependency makes the service unavailable;
+// any other failing dependency makes it degraded.
+type Dependency struct {
+	Name     string
+	Critical bool
+	Check    func(ctx context.Context) error
+}
+
+// DependencyStatus is the result of one dependency check
+type DependencyStatus struct {
+	Name      string  `json:"name"`
+	Status    string  `json:"status"` // up or down
+	Critical  bool    `json:"critical"`
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// ReadinessReport is the body of the readiness probe
+type ReadinessReport struct {
+	Status       string             `json:"status"` // a HealthState name
+	Reason       string             `json:"reason,omitempty"`
+	Dependencies []DependencyStatus `json:"dependencies"`
+}
+
+// AddDependency registers a dependency that every readiness probe checks
+func (h *HealthCheck) AddDependency(d Dependency) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.dependencies = append(h.dependencies, d)
+}
+
+// Report checks the dependencies concurrently and combines them with the
+// lifecycle state. Dependencies only change the status of a ready or
+// degraded service.
+func (h *HealthCheck) Report(ctx context.Context) ReadinessReport {
+	h.mu.RLock()
+	state, reason := h.state, h.reason
+	deps := append([]Dependency(nil), h.dependencies...)
+	h.mu.RUnlock()
+
+	statuses := make([]DependencyStatus, len(deps))
+	var wg sync.WaitGroup
+	for i, d := range deps {
+		wg.Add(1)
+		go func(i int, d Dependency) {
+			defer wg.Done()
+			statuses[i] = checkDependency(ctx, d)
+		}(i, d)
+	}
+	wg.Wait()
+
+	if state == StateReady || state == StateDegraded {
+		for _, s := range statuses {
+			switch {
+			case s.Status == "up":
+			case s.Critical:
+				state, reason = StateUnavailable, s.Name+": "+s.Error
+			case state == StateReady:
+				state, reason = StateDegraded, s.Name+": "+s.Error
+			}
+			if state == StateUnavailable {
+				break
+			}
+		}
+	}
+	return ReadinessReport{Status: state.String(), Reason: reason, Dependencies: statuses}
+}
+
+func checkDependency(ctx context.Context, d Dependency) DependencyStatus {
+	ctx, cancel := context.WithTimeout(ctx, dependencyCheckTimeout)
+	defer cancel()
+	start := time.Now()
+	err := d.Check(ctx)
+	s := DependencyStatus{
+		Name:      d.Name,
+		Status:    "up",
+		Critical:  d.Critical,
+		LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		s.Status, s.Error = "down", err.Error()
+	}
+	DependencyUp.WithLabelValues(d.Name).Set(boolToFloat(err == nil))
+	return s
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// ReadinessHandler handles readiness probe requests with a JSON report.
+// The status is 200 when the service is ready or degraded and 503
+// otherwise.
+func (h *HealthCheck) ReadinessHandler(w http.ResponseWriter, r *http.Request) {
+	report := h.Report(r.Context())
+	status := http.StatusServiceUnavailable
+	if report.Status == StateReady.String() || report.Status == StateDegraded.String() {
+		status = http.StatusOK
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
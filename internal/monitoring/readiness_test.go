@@ -0,0 +1,58 @@
+package monitoring
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReadinessDependencies(t *testing.T) {
+	var queueErr, clickhouseErr error
+	hc := NewHealthCheck()
+	hc.AddDependency(Dependency{Name: "clickhouse", Critical: true, Check: func(ctx context.Context) error { return clickhouseErr }})
+	hc.AddDependency(Dependency{Name: "queues", Check: func(ctx context.Context) error { return queueErr }})
+	hc.SetReady(true)
+
+	probe := func() (int, ReadinessReport) {
+		rec := httptest.NewRecorder()
+		hc.ReadinessHandler(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+		var report ReadinessReport
+		if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+			t.Fatalf("invalid report %q: %v", rec.Body.String(), err)
+		}
+		return rec.Code, report
+	}
+
+	code, report := probe()
+	if code != http.StatusOK || report.Status != "ready" || len(report.Dependencies) != 2 {
+		t.Fatalf("code = %d, report = %+v", code, report)
+	}
+	if d := report.Dependencies[0]; d.Name != "clickhouse" || d.Status != "up" || !d.Critical || d.LatencyMs < 0 {
+		t.Errorf("unexpected dependency %+v", d)
+	}
+
+	queueErr = errors.New("traces queue 95% full")
+	code, report = probe()
+	if code != http.StatusOK || report.Status != "degraded" || report.Reason != "queues: traces queue 95% full" {
+		t.Errorf("non-critical failure: code = %d, report = %+v", code, report)
+	}
+
+	clickhouseErr = errors.New("connection refused")
+	code, report = probe()
+	if code != http.StatusServiceUnavailable || report.Status != "unavailable" || report.Reason != "clickhouse: connection refused" {
+		t.Errorf("critical failure: code = %d, report = %+v", code, report)
+	}
+	if d := report.Dependencies[0]; d.Status != "down" || d.Error != "connection refused" {
+		t.Errorf("unexpected dependency %+v", d)
+	}
+
+	// The lifecycle state wins over healthy dependencies
+	clickhouseErr, queueErr = nil, nil
+	hc.SetState(StateDraining, "shutting down")
+	if code, report = probe(); code != http.StatusServiceUnavailable || report.Status != "draining" {
+		t.Errorf("draining: code = %d, report = %+v", code, report)
+	}
+}
@@ -0,0 +1,122 @@
+package monitoring
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"otelservices/internal/logging"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RequestIDHeader carries the ID of an HTTP request, in both directions
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds request IDs taken from clients
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+// RequestID returns the ID of the request ctx belongs to, or ""
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// WithRequestID returns a context carrying a request ID
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// newRequestID returns a random 128-bit ID
+func newRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// validRequestID accepts IDs of printable ASCII without spaces, so client
+// IDs can be logged and echoed as is
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// accessLog is the logger of completed HTTP requests
+var accessLog = logging.For("http")
+
+// RequestLogger is middleware that gives each request an ID, keeping a valid
+// X-Request-ID sent by the client, and logs the request when it completes.
+// The ID is set on the response, the request context (see RequestID) and the
+// active span, and is appended to plain text error responses.
+func RequestLogger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("http.request_id", id))
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(WithRequestID(r.Context(), id)))
+		if rec.status >= 400 && strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+			n, _ := fmt.Fprintf(w, "request_id: %s\n", id)
+			rec.bytes += int64(n)
+		}
+
+		accessLog.Info("request",
+			"request_id", id,
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"duration_ms", float64(time.Since(start).Microseconds())/1000,
+			"bytes", rec.bytes,
+			"remote_addr", r.RemoteAddr,
+		)
+	})
+}
+
+// statusRecorder remembers the status and size of a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += int64(n)
+	return n, err
+}
+
+// Flush lets streaming handlers flush through the recorder
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
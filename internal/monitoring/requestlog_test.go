@@ -0,0 +1,74 @@
+package monitoring
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// captureLogs sends the default logger to a buffer for the test
+func captureLogs(t *testing.T, handler func(*bytes.Buffer) slog.Handler) *bytes.Buffer {
+	var buf bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(handler(&buf)))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+	return &buf
+}
+
+func TestRequestLogger(t *testing.T) {
+	buf := captureLogs(t, func(b *bytes.Buffer) slog.Handler { return slog.NewJSONHandler(b, nil) })
+
+	var seen string
+	handler := RequestLogger(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = RequestID(r.Context())
+		http.Error(w, "bad query", http.StatusBadRequest)
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/logs", nil)
+	req.Header.Set(RequestIDHeader, "client-id-1")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if seen != "client-id-1" || rec.Header().Get(RequestIDHeader) != "client-id-1" {
+		t.Errorf("client ID not propagated: context %q, header %q", seen, rec.Header().Get(RequestIDHeader))
+	}
+	if rec.Body.String() != "bad query\nrequest_id: client-id-1\n" {
+		t.Errorf("error body = %q", rec.Body.String())
+	}
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("access log is not JSON: %v: %q", err, buf.String())
+	}
+	if entry["component"] != "http" || entry["request_id"] != "client-id-1" || entry["method"] != "POST" ||
+		entry["path"] != "/api/v1/logs" || entry["status"] != float64(400) {
+		t.Errorf("unexpected entry %v", entry)
+	}
+}
+
+func TestRequestLoggerGeneratesID(t *testing.T) {
+	buf := captureLogs(t, func(b *bytes.Buffer) slog.Handler { return slog.NewTextHandler(b, nil) })
+
+	handler := RequestLogger(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/health", nil)
+	req.Header.Set(RequestIDHeader, "has spaces")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	id := rec.Header().Get(RequestIDHeader)
+	if len(id) != 32 || id == "has spaces" {
+		t.Errorf("invalid client ID should be replaced, got %q", id)
+	}
+	if rec.Body.String() != "ok" {
+		t.Errorf("successful responses should not change, got %q", rec.Body.String())
+	}
+	if line := buf.String(); !strings.Contains(line, "request_id="+id+" method=GET path=/health status=200") {
+		t.Errorf("unexpected log line %q", line)
+	}
+}
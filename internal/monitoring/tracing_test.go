@@ -0,0 +1,56 @@
+package monitoring
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestHTTPTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	prevTP, prevProp := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer func() {
+		otel.SetTracerProvider(prevTP)
+		otel.SetTextMapPropagator(prevProp)
+	}()
+
+	handler := HTTPTracing(RequestLogger(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		SetRoute(r, "/api/v1/jobs/{id}")
+		http.Error(w, "boom", http.StatusInternalServerError)
+	})))
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/jobs/42", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	req.Header.Set(RequestIDHeader, "req-1")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(spans))
+	}
+	span := spans[0]
+	if span.Name() != "GET /api/v1/jobs/{id}" {
+		t.Errorf("span name = %q", span.Name())
+	}
+	if span.Parent().TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("span should continue the caller's trace, parent = %v", span.Parent())
+	}
+	if span.Status().Code != codes.Error {
+		t.Errorf("status = %v, want error", span.Status())
+	}
+	attrs := map[string]string{}
+	for _, kv := range span.Attributes() {
+		attrs[string(kv.Key)] = kv.Value.Emit()
+	}
+	if attrs["http.status_code"] != "500" || attrs["http.route"] != "/api/v1/jobs/{id}" || attrs["http.request_id"] != "req-1" {
+		t.Errorf("unexpected attributes %v", attrs)
+	}
+}
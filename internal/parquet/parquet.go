@@ -0,0 +1,254 @@
+// Package parquet writes flat tables as Apache Parquet files. The file
+// metadata is encoded with the Thrift compact protocol directly rather than
+// through a Parquet library, so the build has no extra dependencies. Files
+// have a single row group, required columns, plain encoding and no
+// compression, which every Parquet reader supports.
+package parquet
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// magic starts and ends every Parquet file
+const magic = "PAR1"
+
+// createdBy is recorded in the file metadata
+const createdBy = "otelservices"
+
+// ColumnType is the type of a column's values
+type ColumnType int
+
+const (
+	String    ColumnType = iota // string, stored as UTF-8 BYTE_ARRAY
+	Int64                       // int64
+	Double                      // float64
+	Timestamp                   // time.Time, stored as INT64 microseconds since the epoch, UTC
+)
+
+// Column describes one column of a table
+type Column struct {
+	Name string
+	Type ColumnType
+}
+
+// Parquet physical types, converted types and enum values of the format
+const (
+	typeInt64     = 2
+	typeDouble    = 5
+	typeByteArray = 6
+
+	convertedUTF8            = 0
+	convertedTimestampMicros = 10
+
+	repetitionRequired = 0
+	encodingPlain      = 0
+	encodingRLE        = 3
+	codecUncompressed  = 0
+	pageTypeData       = 0
+)
+
+func (t ColumnType) physical() int32 {
+	switch t {
+	case Int64, Timestamp:
+		return typeInt64
+	case Double:
+		return typeDouble
+	default:
+		return typeByteArray
+	}
+}
+
+// Write encodes rows as a Parquet file. Each row holds one value per column,
+// of the Go type matching the column type.
+func Write(w io.Writer, columns []Column, rows [][]interface{}) error {
+	if len(columns) == 0 {
+		return fmt.Errorf("parquet: no columns")
+	}
+	for i, row := range rows {
+		if len(row) != len(columns) {
+			return fmt.Errorf("parquet: row %d has %d values, want %d", i, len(row), len(columns))
+		}
+	}
+
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+	cw.Write([]byte(magic))
+
+	var chunks []columnChunk
+	if len(rows) > 0 {
+		for i, col := range columns {
+			data, err := encodeValues(col, rows, i)
+			if err != nil {
+				return err
+			}
+			header := pageHeader(len(rows), len(data))
+			chunk := columnChunk{
+				column: col,
+				offset: cw.n,
+				size:   int64(len(header) + len(data)),
+			}
+			cw.Write(header)
+			cw.Write(data)
+			chunks = append(chunks, chunk)
+		}
+	}
+
+	footer := fileMetadata(columns, int64(len(rows)), chunks)
+	cw.Write(footer)
+	var length [4]byte
+	binary.LittleEndian.PutUint32(length[:], uint32(len(footer)))
+	cw.Write(length[:])
+	cw.Write([]byte(magic))
+	if cw.err != nil {
+		return cw.err
+	}
+	return cw.w.Flush()
+}
+
+// encodeValues plain-encodes column i of rows
+func encodeValues(col Column, rows [][]interface{}, i int) ([]byte, error) {
+	var buf []byte
+	for r, row := range rows {
+		switch col.Type {
+		case String:
+			v, ok := row[i].(string)
+			if !ok {
+				return nil, valueError(col, r, row[i])
+			}
+			buf = binary.LittleEndian.AppendUint32(buf, uint32(len(v)))
+			buf = append(buf, v...)
+		case Int64:
+			v, ok := row[i].(int64)
+			if !ok {
+				return nil, valueError(col, r, row[i])
+			}
+			buf = binary.LittleEndian.AppendUint64(buf, uint64(v))
+		case Double:
+			v, ok := row[i].(float64)
+			if !ok {
+				return nil, valueError(col, r, row[i])
+			}
+			buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(v))
+		case Timestamp:
+			v, ok := row[i].(time.Time)
+			if !ok {
+				return nil, valueError(col, r, row[i])
+			}
+			buf = binary.LittleEndian.AppendUint64(buf, uint64(v.UnixMicro()))
+		default:
+			return nil, fmt.Errorf("parquet: column %q has unknown type %d", col.Name, col.Type)
+		}
+	}
+	return buf, nil
+}
+
+func valueError(col Column, row int, v interface{}) error {
+	return fmt.Errorf("parquet: row %d: column %q cannot hold %T", row, col.Name, v)
+}
+
+type columnChunk struct {
+	column Column
+	offset int64 // of the page header
+	size   int64 // page header and data
+}
+
+// pageHeader encodes the PageHeader of a data page. Required columns at the
+// top level have no repetition or definition levels, so the page holds only
+// the values.
+func pageHeader(numValues, size int) []byte {
+	var e encoder
+	e.i32(1, pageTypeData)
+	e.i32(2, int32(size))
+	e.i32(3, int32(size))
+	e.beginStruct(5)
+	e.i32(1, int32(numValues))
+	e.i32(2, encodingPlain)
+	e.i32(3, encodingRLE)
+	e.i32(4, encodingRLE)
+	e.endStruct()
+	e.stop()
+	return e.buf
+}
+
+// fileMetadata encodes the FileMetaData footer
+func fileMetadata(columns []Column, numRows int64, chunks []columnChunk) []byte {
+	var e encoder
+	e.i32(1, 1) // version
+
+	e.list(2, typeStruct, len(columns)+1)
+	e.beginElem()
+	e.binary(4, "schema")
+	e.i32(5, int32(len(columns)))
+	e.endElem()
+	for _, col := range columns {
+		e.beginElem()
+		e.i32(1, col.Type.physical())
+		e.i32(3, repetitionRequired)
+		e.binary(4, col.Name)
+		switch col.Type {
+		case String:
+			e.i32(6, convertedUTF8)
+		case Timestamp:
+			e.i32(6, convertedTimestampMicros)
+		}
+		e.endElem()
+	}
+
+	e.i64(3, numRows)
+
+	if len(chunks) == 0 {
+		e.list(4, typeStruct, 0)
+	} else {
+		var total int64
+		for _, c := range chunks {
+			total += c.size
+		}
+		e.list(4, typeStruct, 1)
+		e.beginElem()
+		e.list(1, typeStruct, len(chunks))
+		for _, c := range chunks {
+			e.beginElem()
+			e.i64(2, c.offset)
+			e.beginStruct(3)
+			e.i32(1, c.column.Type.physical())
+			e.list(2, typeI32, 1)
+			e.varint(zigzag(encodingPlain))
+			e.list(3, typeBinary, 1)
+			e.str(c.column.Name)
+			e.i32(4, codecUncompressed)
+			e.i64(5, numRows)
+			e.i64(6, c.size)
+			e.i64(7, c.size)
+			e.i64(9, c.offset)
+			e.endStruct()
+			e.endElem()
+		}
+		e.i64(2, total)
+		e.i64(3, numRows)
+		e.endElem()
+	}
+
+	e.binary(6, createdBy)
+	e.stop()
+	return e.buf
+}
+
+// countingWriter tracks the file offset and keeps the first write error
+type countingWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (c *countingWriter) Write(p []byte) {
+	if c.err != nil {
+		return
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+}
@@ -0,0 +1,202 @@
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+)
+
+// decoder reads compact protocol structs into maps keyed by field id, so
+// the tests can check the footer without a Parquet library
+type decoder struct {
+	buf []byte
+	pos int
+}
+
+func (d *decoder) uvarint() uint64 {
+	v, n := binary.Uvarint(d.buf[d.pos:])
+	if n <= 0 {
+		panic("bad varint")
+	}
+	d.pos += n
+	return v
+}
+
+func (d *decoder) zigzag() int64 {
+	v := d.uvarint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (d *decoder) value(typ byte) interface{} {
+	switch typ {
+	case typeI32, typeI64:
+		return d.zigzag()
+	case typeBinary:
+		n := int(d.uvarint())
+		s := string(d.buf[d.pos : d.pos+n])
+		d.pos += n
+		return s
+	case typeList:
+		h := d.buf[d.pos]
+		d.pos++
+		size, elem := int(h>>4), h&0x0f
+		if size == 15 {
+			size = int(d.uvarint())
+		}
+		list := make([]interface{}, size)
+		for i := range list {
+			list[i] = d.value(elem)
+		}
+		return list
+	case typeStruct:
+		return d.structure()
+	}
+	panic(fmt.Sprintf("unexpected type %d", typ))
+}
+
+func (d *decoder) structure() map[int16]interface{} {
+	fields := make(map[int16]interface{})
+	var last int16
+	for {
+		h := d.buf[d.pos]
+		d.pos++
+		if h == 0 {
+			return fields
+		}
+		id := last + int16(h>>4)
+		if h>>4 == 0 {
+			id = int16(d.zigzag())
+		}
+		fields[id] = d.value(h & 0x0f)
+		last = id
+	}
+}
+
+// readFile checks the file framing and returns the decoded footer
+func readFile(t *testing.T, data []byte) map[int16]interface{} {
+	t.Helper()
+	if !bytes.HasPrefix(data, []byte(magic)) || !bytes.HasSuffix(data, []byte(magic)) {
+		t.Fatal("missing PAR1 magic")
+	}
+	length := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	d := &decoder{buf: data[len(data)-8-length : len(data)-8]}
+	footer := d.structure()
+	if d.pos != length {
+		t.Fatalf("footer decoded %d of %d bytes", d.pos, length)
+	}
+	return footer
+}
+
+func TestWrite(t *testing.T) {
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 6000, time.UTC)
+	columns := []Column{
+		{Name: "service_name", Type: String},
+		{Name: "duration_ns", Type: Int64},
+		{Name: "value", Type: Double},
+		{Name: "timestamp", Type: Timestamp},
+	}
+	rows := [][]interface{}{
+		{"checkout", int64(1500), 0.5, ts},
+		{"", int64(-1), math.Inf(1), ts.Add(time.Second)},
+	}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, columns, rows); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	footer := readFile(t, data)
+
+	if footer[1] != int64(1) || footer[3] != int64(2) || footer[6] != createdBy {
+		t.Errorf("unexpected footer fields %v", footer)
+	}
+	schema := footer[2].([]interface{})
+	if len(schema) != 5 || schema[0].(map[int16]interface{})[5] != int64(4) {
+		t.Fatalf("unexpected schema %v", schema)
+	}
+	ts0 := schema[4].(map[int16]interface{})
+	if ts0[4] != "timestamp" || ts0[1] != int64(typeInt64) || ts0[6] != int64(convertedTimestampMicros) {
+		t.Errorf("unexpected timestamp column %v", ts0)
+	}
+
+	groups := footer[4].([]interface{})
+	if len(groups) != 1 {
+		t.Fatalf("want 1 row group, got %d", len(groups))
+	}
+	chunks := groups[0].(map[int16]interface{})[1].([]interface{})
+	if len(chunks) != len(columns) {
+		t.Fatalf("want %d column chunks, got %d", len(columns), len(chunks))
+	}
+
+	// Read each column's page back
+	var values [][]byte
+	for i, c := range chunks {
+		meta := c.(map[int16]interface{})[3].(map[int16]interface{})
+		if path := meta[3].([]interface{}); path[0] != columns[i].Name {
+			t.Errorf("chunk %d path = %v", i, path)
+		}
+		d := &decoder{buf: data, pos: int(meta[9].(int64))}
+		page := d.structure()
+		header := page[5].(map[int16]interface{})
+		if header[1] != int64(len(rows)) {
+			t.Errorf("column %s: page has %v values", columns[i].Name, header[1])
+		}
+		size := int(page[3].(int64))
+		values = append(values, data[d.pos:d.pos+size])
+		if want := int64(d.pos+size) - meta[9].(int64); meta[7] != want {
+			t.Errorf("column %s: chunk size %v, want %d", columns[i].Name, meta[7], want)
+		}
+	}
+
+	wantStrings := append(binary.LittleEndian.AppendUint32(nil, 8), "checkout"...)
+	wantStrings = binary.LittleEndian.AppendUint32(wantStrings, 0)
+	if !bytes.Equal(values[0], wantStrings) {
+		t.Errorf("string column = %v", values[0])
+	}
+	if v := int64(binary.LittleEndian.Uint64(values[1][8:])); v != -1 {
+		t.Errorf("int64 value = %d", v)
+	}
+	if v := math.Float64frombits(binary.LittleEndian.Uint64(values[2])); v != 0.5 {
+		t.Errorf("double value = %v", v)
+	}
+	if v := int64(binary.LittleEndian.Uint64(values[3])); v != ts.UnixMicro() {
+		t.Errorf("timestamp value = %d, want %d", v, ts.UnixMicro())
+	}
+}
+
+func TestWriteEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, []Column{{Name: "body", Type: String}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	footer := readFile(t, buf.Bytes())
+	if footer[3] != int64(0) || len(footer[4].([]interface{})) != 0 {
+		t.Errorf("unexpected footer for an empty table %v", footer)
+	}
+}
+
+func TestWriteRejectsMismatchedValues(t *testing.T) {
+	columns := []Column{{Name: "count", Type: Int64}}
+	var buf bytes.Buffer
+	if err := Write(&buf, columns, [][]interface{}{{"1"}}); err == nil {
+		t.Error("expected error for a string in an int64 column")
+	}
+	if err := Write(&buf, columns, [][]interface{}{{int64(1), int64(2)}}); err == nil {
+		t.Error("expected error for a row with too many values")
+	}
+}
+
+func TestEncoderLongFieldDelta(t *testing.T) {
+	var e encoder
+	e.i32(1, 1)
+	e.i32(20, -2)
+	e.stop()
+	d := &decoder{buf: e.buf}
+	fields := d.structure()
+	if fields[1] != int64(1) || fields[20] != int64(-2) {
+		t.Errorf("decoded %v", fields)
+	}
+}
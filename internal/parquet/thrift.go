@@ -0,0 +1,103 @@
+package parquet
+
+import "encoding/binary"
+
+// Thrift compact protocol type ids
+const (
+	typeI32    = 5
+	typeI64    = 6
+	typeBinary = 8
+	typeList   = 9
+	typeStruct = 12
+)
+
+// encoder writes Thrift structs with the compact protocol. Field ids within
+// a struct are delta encoded, so the last id of every open struct is kept on
+// a stack.
+type encoder struct {
+	buf  []byte
+	last []int16
+}
+
+func (e *encoder) fieldHeader(id int16, typ byte) {
+	if len(e.last) == 0 {
+		e.last = append(e.last, 0)
+	}
+	top := len(e.last) - 1
+	if delta := id - e.last[top]; delta > 0 && delta <= 15 {
+		e.buf = append(e.buf, byte(delta)<<4|typ)
+	} else {
+		e.buf = append(e.buf, typ)
+		e.varint(zigzag(int64(id)))
+	}
+	e.last[top] = id
+}
+
+func (e *encoder) varint(v uint64) {
+	e.buf = binary.AppendUvarint(e.buf, v)
+}
+
+func zigzag(v int64) uint64 {
+	return uint64((v << 1) ^ (v >> 63))
+}
+
+func (e *encoder) i32(id int16, v int32) {
+	e.fieldHeader(id, typeI32)
+	e.varint(zigzag(int64(v)))
+}
+
+func (e *encoder) i64(id int16, v int64) {
+	e.fieldHeader(id, typeI64)
+	e.varint(zigzag(v))
+}
+
+func (e *encoder) binary(id int16, v string) {
+	e.fieldHeader(id, typeBinary)
+	e.str(v)
+}
+
+// str writes a string value without a field header, as in lists
+func (e *encoder) str(v string) {
+	e.varint(uint64(len(v)))
+	e.buf = append(e.buf, v...)
+}
+
+// list writes a list field header; the elements follow
+func (e *encoder) list(id int16, elemType byte, size int) {
+	e.fieldHeader(id, typeList)
+	if size < 15 {
+		e.buf = append(e.buf, byte(size)<<4|elemType)
+	} else {
+		e.buf = append(e.buf, 0xf0|elemType)
+		e.varint(uint64(size))
+	}
+}
+
+// beginStruct opens a struct field; endStruct closes it
+func (e *encoder) beginStruct(id int16) {
+	e.fieldHeader(id, typeStruct)
+	e.beginElem()
+}
+
+func (e *encoder) endStruct() {
+	e.endElem()
+}
+
+// beginElem opens a struct element of a list; endElem closes it
+func (e *encoder) beginElem() {
+	if len(e.last) == 0 {
+		e.last = append(e.last, 0)
+	}
+	e.last = append(e.last, 0)
+}
+
+func (e *encoder) endElem() {
+	e.buf = append(e.buf, 0)
+	e.last = e.last[:len(e.last)-1]
+}
+
+// stop ends the top-level struct
+func (e *encoder) stop() {
+	e.buf = append(e.buf, 0)
+	e.last = nil
+}
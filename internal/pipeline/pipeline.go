@@ -0,0 +1,168 @@
+// Package pipeline composes the collector from receivers, which accept
+// telemetry from outside, processors, which transform batches of it, and
+// exporters, which write the batches out. A Pipeline connects them in that
+// order and is built by a Registry from the names of its components, so a
+// new component only has to be registered to become available.
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"otelservices/internal/models"
+)
+
+// Signals a pipeline can be restricted to
+const (
+	SignalTraces  = "traces"
+	SignalMetrics = "metrics"
+	SignalLogs    = "logs"
+)
+
+// Batch is a set of telemetry moving through a pipeline. Batches built by
+// the collector carry a single signal, but processors and exporters handle
+// any combination.
+type Batch struct {
+	Spans   []models.Span
+	Metrics []models.Metric
+	Logs    []models.LogRecord
+}
+
+// Len returns the number of items in the batch
+func (b Batch) Len() int {
+	return len(b.Spans) + len(b.Metrics) + len(b.Logs)
+}
+
+// Only returns the part of the batch of one signal. An empty signal keeps
+// the whole batch.
+func (b Batch) Only(signal string) Batch {
+	switch signal {
+	case SignalTraces:
+		return Batch{Spans: b.Spans}
+	case SignalMetrics:
+		return Batch{Metrics: b.Metrics}
+	case SignalLogs:
+		return Batch{Logs: b.Logs}
+	}
+	return b
+}
+
+// Consumer accepts batches. A Pipeline is the Consumer its receivers are
+// started with.
+type Consumer interface {
+	Consume(ctx context.Context, batch Batch) error
+}
+
+// ConsumerFunc adapts a function to a Consumer
+type ConsumerFunc func(ctx context.Context, batch Batch) error
+
+func (f ConsumerFunc) Consume(ctx context.Context, batch Batch) error {
+	return f(ctx, batch)
+}
+
+// Fanout hands each batch to all of its consumers, even when one of them
+// fails; the failures are returned together
+type Fanout []Consumer
+
+func (f Fanout) Consume(ctx context.Context, batch Batch) error {
+	var errs []error
+	for _, c := range f {
+		if err := c.Consume(ctx, batch); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Receiver accepts telemetry from outside the collector and hands it to
+// the next consumer until it is shut down
+type Receiver interface {
+	Start(ctx context.Context, next Consumer) error
+	Shutdown(ctx context.Context) error
+}
+
+// Processor transforms a batch. Returning an empty batch drops it.
+type Processor interface {
+	Process(ctx context.Context, batch Batch) (Batch, error)
+}
+
+// ProcessorFunc adapts a function to a Processor
+type ProcessorFunc func(ctx context.Context, batch Batch) (Batch, error)
+
+func (f ProcessorFunc) Process(ctx context.Context, batch Batch) (Batch, error) {
+	return f(ctx, batch)
+}
+
+// Exporter writes batches out of the collector
+type Exporter interface {
+	Export(ctx context.Context, batch Batch) error
+}
+
+// Pipeline passes the batches of its receivers through its processors, in
+// order, and then to each of its exporters. A pipeline with a Signal only
+// passes on that signal's part of a batch.
+type Pipeline struct {
+	Name       string
+	Signal     string
+	receivers  []Receiver
+	processors []Processor
+	exporters  []Exporter
+	started    []Receiver
+}
+
+// New creates a pipeline for every signal from its components
+func New(name string, receivers []Receiver, processors []Processor, exporters []Exporter) *Pipeline {
+	return &Pipeline{Name: name, receivers: receivers, processors: processors, exporters: exporters}
+}
+
+// Consume runs the processors on batch and exports what is left. A batch
+// is given to every exporter even when one of them fails; the failures are
+// returned together.
+func (p *Pipeline) Consume(ctx context.Context, batch Batch) error {
+	if batch = batch.Only(p.Signal); batch.Len() == 0 {
+		return nil
+	}
+	for _, proc := range p.processors {
+		var err error
+		if batch, err = proc.Process(ctx, batch); err != nil {
+			return fmt.Errorf("pipeline %s: %w", p.Name, err)
+		}
+		if batch.Len() == 0 {
+			return nil
+		}
+	}
+	var errs []error
+	for _, e := range p.exporters {
+		if err := e.Export(ctx, batch); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Start starts the receivers with the pipeline as their consumer. If one
+// fails to start, those already started are shut down again.
+func (p *Pipeline) Start(ctx context.Context) error {
+	for _, r := range p.receivers {
+		if err := r.Start(ctx, p); err != nil {
+			p.Shutdown(ctx)
+			return fmt.Errorf("pipeline %s: %w", p.Name, err)
+		}
+		p.started = append(p.started, r)
+	}
+	return nil
+}
+
+// Shutdown stops the started receivers in reverse order. Batches already
+// handed to the pipeline are left to whoever holds them.
+func (p *Pipeline) Shutdown(ctx context.Context) error {
+	var errs []error
+	for i := len(p.started) - 1; i >= 0; i-- {
+		if err := p.started[i].Shutdown(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	p.started = nil
+	return errors.Join(errs...)
+}
@@ -0,0 +1,223 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"otelservices/internal/models"
+)
+
+// recorder is an exporter that keeps the spans it is given
+type recorder struct {
+	spans []string
+	err   error
+}
+
+func (r *recorder) Export(_ context.Context, batch Batch) error {
+	for _, s := range batch.Spans {
+		r.spans = append(r.spans, s.SpanName)
+	}
+	return r.err
+}
+
+// fakeReceiver hands its spans to the pipeline on Start
+type fakeReceiver struct {
+	spans   []models.Span
+	err     error
+	events  *[]string
+	name    string
+	stopped bool
+}
+
+func (r *fakeReceiver) Start(ctx context.Context, next Consumer) error {
+	*r.events = append(*r.events, "start "+r.name)
+	if r.err != nil {
+		return r.err
+	}
+	return next.Consume(ctx, Batch{Spans: r.spans})
+}
+
+func (r *fakeReceiver) Shutdown(context.Context) error {
+	*r.events = append(*r.events, "stop "+r.name)
+	r.stopped = true
+	return nil
+}
+
+func TestPipelineConsume(t *testing.T) {
+	drop := ProcessorFunc(func(_ context.Context, b Batch) (Batch, error) {
+		var kept []models.Span
+		for _, s := range b.Spans {
+			if s.SpanName != "health" {
+				kept = append(kept, s)
+			}
+		}
+		b.Spans = kept
+		return b, nil
+	})
+	rename := ProcessorFunc(func(_ context.Context, b Batch) (Batch, error) {
+		for i := range b.Spans {
+			b.Spans[i].SpanName = strings.ToUpper(b.Spans[i].SpanName)
+		}
+		return b, nil
+	})
+	first, second := &recorder{err: errors.New("unavailable")}, &recorder{}
+	p := New("traces", nil, []Processor{drop, rename}, []Exporter{first, second})
+
+	err := p.Consume(context.Background(), Batch{Spans: []models.Span{{SpanName: "checkout"}, {SpanName: "health"}}})
+	if err == nil || !strings.Contains(err.Error(), "unavailable") {
+		t.Errorf("Consume() error = %v", err)
+	}
+	// A failing exporter does not keep the batch from the others
+	for _, r := range []*recorder{first, second} {
+		if !reflect.DeepEqual(r.spans, []string{"CHECKOUT"}) {
+			t.Errorf("exported %v", r.spans)
+		}
+	}
+
+	// Batches a processor empties are not exported
+	if err := p.Consume(context.Background(), Batch{Spans: []models.Span{{SpanName: "health"}}}); err != nil || len(second.spans) != 1 {
+		t.Errorf("dropped batch: err %v, exported %v", err, second.spans)
+	}
+}
+
+func TestPipelineStartShutdown(t *testing.T) {
+	var events []string
+	exported := &recorder{}
+	a := &fakeReceiver{name: "a", events: &events, spans: []models.Span{{SpanName: "op"}}}
+	b := &fakeReceiver{name: "b", events: &events}
+	p := New("traces", []Receiver{a, b}, nil, []Exporter{exported})
+	if err := p.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(exported.spans) != 1 {
+		t.Errorf("receiver batch not exported: %v", exported.spans)
+	}
+	p.Shutdown(context.Background())
+	if want := []string{"start a", "start b", "stop b", "stop a"}; !reflect.DeepEqual(events, want) {
+		t.Errorf("events %v, want %v", events, want)
+	}
+
+	// Receivers started before one that fails are shut down again
+	events = nil
+	a.stopped = false
+	failing := &fakeReceiver{name: "c", events: &events, err: errors.New("address in use")}
+	p = New("traces", []Receiver{a, failing}, nil, []Exporter{exported})
+	if err := p.Start(context.Background()); err == nil || !strings.Contains(err.Error(), "address in use") {
+		t.Errorf("Start() error = %v", err)
+	}
+	if !a.stopped || failing.stopped {
+		t.Errorf("after a failed start: events %v", events)
+	}
+}
+
+func TestPipelineSignal(t *testing.T) {
+	exported := &recorder{}
+	p := New("logs", nil, nil, []Exporter{exported})
+	p.Signal = SignalLogs
+	if err := p.Consume(context.Background(), Batch{Spans: []models.Span{{SpanName: "op"}}}); err != nil || len(exported.spans) != 0 {
+		t.Errorf("logs pipeline exported spans: err %v, %v", err, exported.spans)
+	}
+	mixed := Batch{Spans: make([]models.Span, 1), Metrics: make([]models.Metric, 2), Logs: make([]models.LogRecord, 3)}
+	for signal, want := range map[string]int{SignalTraces: 1, SignalMetrics: 2, SignalLogs: 3, "": 6} {
+		if got := mixed.Only(signal).Len(); got != want {
+			t.Errorf("Only(%q) kept %d items, want %d", signal, got, want)
+		}
+	}
+}
+
+func TestRegistryBuild(t *testing.T) {
+	var r Registry
+	var receivedBy []string
+	r.RegisterReceiver("otlp", func(pipelines []string) (Receiver, error) {
+		receivedBy = pipelines
+		return &fakeReceiver{events: new([]string)}, nil
+	})
+	r.RegisterProcessor("noop", func() (Processor, error) {
+		return ProcessorFunc(func(_ context.Context, b Batch) (Batch, error) { return b, nil }), nil
+	})
+	r.RegisterExporter("memory", func() (Exporter, error) { return &recorder{}, nil })
+	r.RegisterExporter("broken", func() (Exporter, error) { return nil, errors.New("no brokers") })
+
+	pipelines, err := r.Build([]Spec{{Name: "ingest", Signal: SignalTraces, Receivers: []string{"otlp"}, Processors: []string{"noop"}, Exporters: []string{"memory"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := pipelines[0]
+	if p.Name != "ingest" || p.Signal != SignalTraces || !reflect.DeepEqual(receivedBy, []string{"ingest"}) ||
+		len(p.receivers) != 1 || len(p.processors) != 1 || len(p.exporters) != 1 {
+		t.Errorf("built %+v", p)
+	}
+	if got := r.Exporters(); !reflect.DeepEqual(got, []string{"broken", "memory"}) {
+		t.Errorf("Exporters() = %v", got)
+	}
+
+	for _, tt := range []struct {
+		specs []Spec
+		want  string
+	}{
+		{[]Spec{{Name: "a"}}, "has no exporters"},
+		{[]Spec{{Name: "a", Exporters: []string{"memory"}}, {Name: "a", Exporters: []string{"memory"}}}, "pipeline a defined twice"},
+		{[]Spec{{Name: "b", Receivers: []string{"zipkin"}, Exporters: []string{"memory"}}}, `unknown receiver "zipkin"`},
+		{[]Spec{{Name: "c", Processors: []string{"redact"}, Exporters: []string{"memory"}}}, `unknown processor "redact"`},
+		{[]Spec{{Name: "d", Exporters: []string{"broken"}}}, "exporter broken: no brokers"},
+	} {
+		if _, err := r.Build(tt.specs); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error = %v, want %q", tt.specs[0].Name, err, tt.want)
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a name twice did not panic")
+		}
+	}()
+	r.RegisterExporter("memory", func() (Exporter, error) { return &recorder{}, nil })
+}
+
+// A receiver in several pipelines is created, started and stopped once and
+// feeds each pipeline its signal
+func TestRegistryBuildSharedReceiver(t *testing.T) {
+	var r Registry
+	var events []string
+	created := 0
+	recv := &fakeReceiver{name: "otlp", events: &events, spans: []models.Span{{SpanName: "op"}}}
+	r.RegisterReceiver("otlp", func(pipelines []string) (Receiver, error) {
+		created++
+		if !reflect.DeepEqual(pipelines, []string{"traces", "logs", "traces/archive"}) {
+			t.Errorf("receiver built for %v", pipelines)
+		}
+		return recv, nil
+	})
+	exporters := map[string]*recorder{}
+	for _, name := range []string{"store", "archive", "logs"} {
+		e := &recorder{}
+		exporters[name] = e
+		r.RegisterExporter(name, func() (Exporter, error) { return e, nil })
+	}
+
+	pipelines, err := r.Build([]Spec{
+		{Name: "traces", Signal: SignalTraces, Receivers: []string{"otlp"}, Exporters: []string{"store"}},
+		{Name: "logs", Signal: SignalLogs, Receivers: []string{"otlp"}, Exporters: []string{"logs"}},
+		{Name: "traces/archive", Signal: SignalTraces, Receivers: []string{"otlp"}, Exporters: []string{"archive"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range pipelines {
+		if err := p.Start(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, p := range pipelines {
+		p.Shutdown(context.Background())
+	}
+	if created != 1 || !reflect.DeepEqual(events, []string{"start otlp", "stop otlp"}) {
+		t.Errorf("created %d times, events %v", created, events)
+	}
+	if len(exporters["store"].spans) != 1 || len(exporters["archive"].spans) != 1 || len(exporters["logs"].spans) != 0 {
+		t.Errorf("exported store %v, archive %v, logs %v", exporters["store"].spans, exporters["archive"].spans, exporters["logs"].spans)
+	}
+}
@@ -0,0 +1,184 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// Factories create a component for a pipeline. A receiver is created once
+// however many pipelines it is in, and its factory is given their names.
+type (
+	ReceiverFactory  func(pipelines []string) (Receiver, error)
+	ProcessorFactory func() (Processor, error)
+	ExporterFactory  func() (Exporter, error)
+)
+
+// Spec names the components of a pipeline and the signal it carries,
+// empty for all of them
+type Spec struct {
+	Name       string
+	Signal     string
+	Receivers  []string
+	Processors []string
+	Exporters  []string
+}
+
+// Registry holds the component factories pipelines are built from. Its
+// zero value is empty and ready to use.
+type Registry struct {
+	receivers  map[string]ReceiverFactory
+	processors map[string]ProcessorFactory
+	exporters  map[string]ExporterFactory
+}
+
+// RegisterReceiver makes a receiver available under name. Registering a
+// name twice is a programming error and panics.
+func (r *Registry) RegisterReceiver(name string, f ReceiverFactory) {
+	r.receivers = register(r.receivers, "receiver", name, f)
+}
+
+// RegisterProcessor makes a processor available under name
+func (r *Registry) RegisterProcessor(name string, f ProcessorFactory) {
+	r.processors = register(r.processors, "processor", name, f)
+}
+
+// RegisterExporter makes an exporter available under name
+func (r *Registry) RegisterExporter(name string, f ExporterFactory) {
+	r.exporters = register(r.exporters, "exporter", name, f)
+}
+
+func register[F any](m map[string]F, kind, name string, f F) map[string]F {
+	if m == nil {
+		m = make(map[string]F)
+	}
+	if _, ok := m[name]; ok {
+		panic(fmt.Sprintf("pipeline: %s %q registered twice", kind, name))
+	}
+	m[name] = f
+	return m
+}
+
+// Receivers returns the registered receiver names in order
+func (r *Registry) Receivers() []string { return names(r.receivers) }
+
+// Processors returns the registered processor names in order
+func (r *Registry) Processors() []string { return names(r.processors) }
+
+// Exporters returns the registered exporter names in order
+func (r *Registry) Exporters() []string { return names(r.exporters) }
+
+func names[F any](m map[string]F) []string {
+	out := make([]string, 0, len(m))
+	for name := range m {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Build creates the pipelines described by specs and connects their
+// components. A receiver in several pipelines is created and started once
+// and hands each batch to all of them. A pipeline needs at least one
+// exporter; one without receivers is fed by calling Consume directly.
+func (r *Registry) Build(specs []Spec) ([]*Pipeline, error) {
+	feeds := make(map[string][]string) // receiver name -> pipeline names
+	var order []string
+	names := make(map[string]bool)
+	for _, spec := range specs {
+		if names[spec.Name] {
+			return nil, fmt.Errorf("pipeline %s defined twice", spec.Name)
+		}
+		names[spec.Name] = true
+		if len(spec.Exporters) == 0 {
+			return nil, fmt.Errorf("pipeline %s has no exporters", spec.Name)
+		}
+		for _, name := range spec.Receivers {
+			if _, ok := feeds[name]; !ok {
+				order = append(order, name)
+			}
+			feeds[name] = append(feeds[name], spec.Name)
+		}
+	}
+
+	receivers := make(map[string]*sharedReceiver, len(order))
+	for _, name := range order {
+		f, ok := r.receivers[name]
+		if !ok {
+			return nil, fmt.Errorf("pipeline %s: unknown receiver %q", feeds[name][0], name)
+		}
+		recv, err := f(feeds[name])
+		if err != nil {
+			return nil, fmt.Errorf("pipeline %s: receiver %s: %w", feeds[name][0], name, err)
+		}
+		receivers[name] = &sharedReceiver{Receiver: recv}
+	}
+
+	pipelines := make([]*Pipeline, 0, len(specs))
+	for _, spec := range specs {
+		processors, err := build(r.processors, "processor", spec, spec.Processors, func(f ProcessorFactory) (Processor, error) { return f() })
+		if err != nil {
+			return nil, err
+		}
+		exporters, err := build(r.exporters, "exporter", spec, spec.Exporters, func(f ExporterFactory) (Exporter, error) { return f() })
+		if err != nil {
+			return nil, err
+		}
+		p := New(spec.Name, nil, processors, exporters)
+		p.Signal = spec.Signal
+		for _, name := range spec.Receivers {
+			receivers[name].next = append(receivers[name].next, p)
+			p.receivers = append(p.receivers, receivers[name])
+		}
+		pipelines = append(pipelines, p)
+	}
+	return pipelines, nil
+}
+
+// sharedReceiver is a receiver in one or more pipelines. The first
+// pipeline to start it starts it with all of them as its consumer, and the
+// first to shut it down stops it.
+type sharedReceiver struct {
+	Receiver
+	next    Fanout
+	started bool
+}
+
+func (s *sharedReceiver) Start(ctx context.Context, _ Consumer) error {
+	if s.started {
+		return nil
+	}
+	var next Consumer = s.next
+	if len(s.next) == 1 {
+		next = s.next[0]
+	}
+	if err := s.Receiver.Start(ctx, next); err != nil {
+		return err
+	}
+	s.started = true
+	return nil
+}
+
+func (s *sharedReceiver) Shutdown(ctx context.Context) error {
+	if !s.started {
+		return nil
+	}
+	s.started = false
+	return s.Receiver.Shutdown(ctx)
+}
+
+func build[F, T any](m map[string]F, kind string, spec Spec, names []string, create func(F) (T, error)) ([]T, error) {
+	out := make([]T, 0, len(names))
+	for _, name := range names {
+		f, ok := m[name]
+		if !ok {
+			return nil, fmt.Errorf("pipeline %s: unknown %s %q", spec.Name, kind, name)
+		}
+		c, err := create(f)
+		if err != nil {
+			return nil, fmt.Errorf("pipeline %s: %s %s: %w", spec.Name, kind, name, err)
+		}
+		out = append(out, c)
+	}
+	return out, nil
+}
@@ -0,0 +1,29 @@
+package sigv4
+
+import (
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Example from the AWS Signature Version 4 documentation
+func TestSigningKey(t *testing.T) {
+	key := SigningKey("wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "20120215", "us-east-1", "iam")
+	if got := hex.EncodeToString(key); got != "f4780e2d9f65fa895f9c67b32ce1baf0b0d8a43505a000a1a9e090d414db404d" {
+		t.Errorf("signing key = %s", got)
+	}
+}
+
+func TestSignSessionToken(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodPost, "https://secretsmanager.eu-west-1.amazonaws.com/", nil)
+	Sign(req, PayloadHash(nil), Credentials{AccessKeyID: "AKID", SecretAccessKey: "secret", SessionToken: "token"}, "eu-west-1", "secretsmanager", time.Now())
+	if req.Header.Get("X-Amz-Security-Token") != "token" {
+		t.Error("session token header not set")
+	}
+	auth := req.Header.Get("Authorization")
+	if !strings.Contains(auth, "/eu-west-1/secretsmanager/aws4_request") || !strings.Contains(auth, "x-amz-security-token") {
+		t.Errorf("Authorization = %s", auth)
+	}
+}
@@ -0,0 +1,79 @@
+// Package timeutil parses the times clients give query ranges in: RFC 3339
+// or relative to now, such as "now-15m" or "now-7d", and fills in the
+// default range when a request gives none.
+package timeutil
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultWindow is the range queried when a request gives neither start nor
+// end: the last hour
+const DefaultWindow = time.Hour
+
+// IsRelative reports whether s is a time relative to now
+func IsRelative(s string) bool {
+	return s == "now" || strings.HasPrefix(s, "now-")
+}
+
+// Parse parses an RFC 3339 time or one relative to now: "now" or "now-"
+// followed by a duration such as 15m, 1h30m, 7d or 2w
+func Parse(s string, now time.Time) (time.Time, error) {
+	if s == "now" {
+		return now, nil
+	}
+	if ago, ok := strings.CutPrefix(s, "now-"); ok {
+		d, err := ParseDuration(ago)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return now.Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is neither RFC 3339 nor relative like now-1h", s)
+	}
+	return t, nil
+}
+
+// ParseDuration parses a positive Go duration, or a whole number of days
+// (d) or weeks (w), which Go durations lack
+func ParseDuration(s string) (time.Duration, error) {
+	var unit time.Duration
+	if n, ok := strings.CutSuffix(s, "d"); ok {
+		s, unit = n, 24*time.Hour
+	} else if n, ok := strings.CutSuffix(s, "w"); ok {
+		s, unit = n, 7*24*time.Hour
+	}
+	var d time.Duration
+	var err error
+	if unit != 0 {
+		var n int
+		n, err = strconv.Atoi(s)
+		d = time.Duration(n) * unit
+	} else {
+		d, err = time.ParseDuration(s)
+	}
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid relative duration %q", s)
+	}
+	return d, nil
+}
+
+// DefaultRange fills the missing bounds of a range: end defaults to now and
+// start to window before end. It reports whether either bound was set.
+func DefaultRange(start, end *time.Time, now time.Time, window time.Duration) bool {
+	if !start.IsZero() && !end.IsZero() {
+		return false
+	}
+	if end.IsZero() {
+		*end = now
+	}
+	if start.IsZero() {
+		*start = end.Add(-window)
+	}
+	return true
+}
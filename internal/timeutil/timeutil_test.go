@@ -0,0 +1,69 @@
+package timeutil
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		s       string
+		want    time.Time
+		wantErr bool
+	}{
+		{"now", now, false},
+		{"now-15m", now.Add(-15 * time.Minute), false},
+		{"now-1h30m", now.Add(-90 * time.Minute), false},
+		{"now-7d", now.AddDate(0, 0, -7), false},
+		{"now-2w", now.AddDate(0, 0, -14), false},
+		{"2024-01-01T00:00:00.5Z", time.Date(2024, 1, 1, 0, 0, 0, 5e8, time.UTC), false},
+		{"now-", time.Time{}, true},
+		{"now-0s", time.Time{}, true},
+		{"now--1h", time.Time{}, true},
+		{"now-xd", time.Time{}, true},
+		{"now+1h", time.Time{}, true},
+		{"yesterday", time.Time{}, true},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.s, now)
+		if (err != nil) != tt.wantErr || !got.Equal(tt.want) {
+			t.Errorf("Parse(%q) = %v, %v", tt.s, got, err)
+		}
+	}
+}
+
+func TestIsRelative(t *testing.T) {
+	for s, want := range map[string]bool{"now": true, "now-1h": true, "nowhere": false, "2024-01-01T00:00:00Z": false, "": false} {
+		if got := IsRelative(s); got != want {
+			t.Errorf("IsRelative(%q) = %v", s, got)
+		}
+	}
+}
+
+func TestDefaultRange(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	given := now.Add(-3 * time.Hour)
+
+	var start, end time.Time
+	if !DefaultRange(&start, &end, now, DefaultWindow) || !start.Equal(now.Add(-time.Hour)) || !end.Equal(now) {
+		t.Errorf("empty range = %v - %v", start, end)
+	}
+
+	start, end = time.Time{}, given
+	DefaultRange(&start, &end, now, DefaultWindow)
+	if !start.Equal(given.Add(-time.Hour)) || !end.Equal(given) {
+		t.Errorf("range without start = %v - %v", start, end)
+	}
+
+	start, end = given, time.Time{}
+	DefaultRange(&start, &end, now, DefaultWindow)
+	if !start.Equal(given) || !end.Equal(now) {
+		t.Errorf("range without end = %v - %v", start, end)
+	}
+
+	start, end = given, now
+	if DefaultRange(&start, &end, now, DefaultWindow) {
+		t.Error("a full range should be left alone")
+	}
+}
@@ -0,0 +1,810 @@
+// Package queryv1 contains the protobuf encoding of the query API messages
+// described by query.proto. Messages are encoded with protowire directly
+// rather than generated code so the build does not depend on protoc.
+package queryv1
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// ContentType is the media type used for protobuf encoded responses
+const ContentType = "application/x-protobuf"
+
+// Span mirrors the Span message
+type Span struct {
+	TraceID                string
+	SpanID                 string
+	ParentSpanID           string
+	SpanName               string
+	SpanKind               string
+	StartTimeUnixNano      uint64
+	EndTimeUnixNano        uint64
+	DurationNs             uint64
+	StatusCode             string
+	StatusMessage          string
+	ServiceName            string
+	Attributes             map[string]string
+	Trace                  *TraceSummary
+	Events                 []*SpanEvent
+	Links                  []*SpanLink
+	TraceState             string
+	DroppedAttributesCount uint32
+	DroppedEventsCount     uint32
+	DroppedLinksCount      uint32
+	Scope                  *InstrumentationScope
+}
+
+// InstrumentationScope mirrors the InstrumentationScope message
+type InstrumentationScope struct {
+	Name       string
+	Version    string
+	Attributes map[string]string
+}
+
+// SpanEvent mirrors the SpanEvent message
+type SpanEvent struct {
+	TimeUnixNano uint64
+	Name         string
+	Attributes   map[string]string
+}
+
+// SpanLink mirrors the SpanLink message
+type SpanLink struct {
+	TraceID    string
+	SpanID     string
+	TraceState string
+	Attributes map[string]string
+}
+
+// TraceSummary mirrors the TraceSummary message
+type TraceSummary struct {
+	RootServiceName   string
+	RootSpanName      string
+	StartTimeUnixNano uint64
+	EndTimeUnixNano   uint64
+	DurationNs        uint64
+	SpanCount         uint64
+	HasErrors         bool
+	ServiceNames      []string
+}
+
+// TraceQueryResponse mirrors the TraceQueryResponse message
+type TraceQueryResponse struct {
+	Spans []*Span
+	Total int64
+}
+
+// MetricDataPoint mirrors the MetricDataPoint message
+type MetricDataPoint struct {
+	TimestampUnixNano uint64
+	Value             float64
+	Labels            map[string]string
+}
+
+// MetricSeries mirrors the MetricSeries message
+type MetricSeries struct {
+	Labels     map[string]string
+	DataPoints []*MetricDataPoint
+}
+
+// MetricsQueryResponse mirrors the MetricsQueryResponse message
+type MetricsQueryResponse struct {
+	MetricName string
+	DataPoints []*MetricDataPoint
+	Series     []*MetricSeries
+}
+
+// LogRecord mirrors the LogRecord message
+type LogRecord struct {
+	TimestampUnixNano      uint64
+	SeverityNumber         uint32
+	SeverityText           string
+	Body                   string
+	ServiceName            string
+	TraceID                string
+	SpanID                 string
+	Attributes             map[string]string
+	DroppedAttributesCount uint32
+	Scope                  *InstrumentationScope
+}
+
+// LogsQueryResponse mirrors the LogsQueryResponse message
+type LogsQueryResponse struct {
+	Logs  []*LogRecord
+	Total int64
+}
+
+// ServiceStat mirrors the ServiceStat message
+type ServiceStat struct {
+	ServiceName   string
+	SpanCount     uint64
+	AvgDurationNs float64
+	P95DurationNs float64
+	ErrorCount    uint64
+}
+
+// ServiceStatsResponse mirrors the ServiceStatsResponse message
+type ServiceStatsResponse struct {
+	Stats []*ServiceStat
+}
+
+// Marshal encodes the span
+func (m *Span) Marshal() []byte {
+	return m.appendTo(nil)
+}
+
+func (m *Span) appendTo(b []byte) []byte {
+	b = appendString(b, 1, m.TraceID)
+	b = appendString(b, 2, m.SpanID)
+	b = appendString(b, 3, m.ParentSpanID)
+	b = appendString(b, 4, m.SpanName)
+	b = appendString(b, 5, m.SpanKind)
+	b = appendFixed64(b, 6, m.StartTimeUnixNano)
+	b = appendFixed64(b, 7, m.EndTimeUnixNano)
+	b = appendVarint(b, 8, m.DurationNs)
+	b = appendString(b, 9, m.StatusCode)
+	b = appendString(b, 10, m.StatusMessage)
+	b = appendString(b, 11, m.ServiceName)
+	b = appendStringMap(b, 12, m.Attributes)
+	if m.Trace != nil {
+		b = appendMessage(b, 13, m.Trace.appendTo(nil))
+	}
+	for _, event := range m.Events {
+		b = appendMessage(b, 14, event.appendTo(nil))
+	}
+	for _, link := range m.Links {
+		b = appendMessage(b, 15, link.appendTo(nil))
+	}
+	b = appendString(b, 16, m.TraceState)
+	b = appendVarint(b, 17, uint64(m.DroppedAttributesCount))
+	b = appendVarint(b, 18, uint64(m.DroppedEventsCount))
+	b = appendVarint(b, 19, uint64(m.DroppedLinksCount))
+	if m.Scope != nil {
+		b = appendMessage(b, 20, m.Scope.appendTo(nil))
+	}
+	return b
+}
+
+// Unmarshal decodes a span
+func (m *Span) Unmarshal(b []byte) error {
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch num {
+		case 1:
+			return consumeString(b, typ, &m.TraceID)
+		case 2:
+			return consumeString(b, typ, &m.SpanID)
+		case 3:
+			return consumeString(b, typ, &m.ParentSpanID)
+		case 4:
+			return consumeString(b, typ, &m.SpanName)
+		case 5:
+			return consumeString(b, typ, &m.SpanKind)
+		case 6:
+			return consumeFixed64(b, typ, &m.StartTimeUnixNano)
+		case 7:
+			return consumeFixed64(b, typ, &m.EndTimeUnixNano)
+		case 8:
+			return consumeVarint(b, typ, &m.DurationNs)
+		case 9:
+			return consumeString(b, typ, &m.StatusCode)
+		case 10:
+			return consumeString(b, typ, &m.StatusMessage)
+		case 11:
+			return consumeString(b, typ, &m.ServiceName)
+		case 12:
+			return consumeMapEntry(b, typ, &m.Attributes)
+		case 13:
+			m.Trace = &TraceSummary{}
+			return consumeMessage(b, typ, m.Trace.Unmarshal)
+		case 14:
+			event := &SpanEvent{}
+			n, err := consumeMessage(b, typ, event.Unmarshal)
+			if err == nil {
+				m.Events = append(m.Events, event)
+			}
+			return n, err
+		case 15:
+			link := &SpanLink{}
+			n, err := consumeMessage(b, typ, link.Unmarshal)
+			if err == nil {
+				m.Links = append(m.Links, link)
+			}
+			return n, err
+		case 16:
+			return consumeString(b, typ, &m.TraceState)
+		case 17:
+			return consumeUint32(b, typ, &m.DroppedAttributesCount)
+		case 18:
+			return consumeUint32(b, typ, &m.DroppedEventsCount)
+		case 19:
+			return consumeUint32(b, typ, &m.DroppedLinksCount)
+		case 20:
+			m.Scope = &InstrumentationScope{}
+			return consumeMessage(b, typ, m.Scope.Unmarshal)
+		}
+		return skipField(num, typ, b)
+	})
+}
+
+func (m *InstrumentationScope) appendTo(b []byte) []byte {
+	b = appendString(b, 1, m.Name)
+	b = appendString(b, 2, m.Version)
+	b = appendStringMap(b, 3, m.Attributes)
+	return b
+}
+
+// Unmarshal decodes an instrumentation scope
+func (m *InstrumentationScope) Unmarshal(b []byte) error {
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch num {
+		case 1:
+			return consumeString(b, typ, &m.Name)
+		case 2:
+			return consumeString(b, typ, &m.Version)
+		case 3:
+			return consumeMapEntry(b, typ, &m.Attributes)
+		}
+		return skipField(num, typ, b)
+	})
+}
+
+func (m *SpanEvent) appendTo(b []byte) []byte {
+	b = appendFixed64(b, 1, m.TimeUnixNano)
+	b = appendString(b, 2, m.Name)
+	b = appendStringMap(b, 3, m.Attributes)
+	return b
+}
+
+// Unmarshal decodes a span event
+func (m *SpanEvent) Unmarshal(b []byte) error {
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch num {
+		case 1:
+			return consumeFixed64(b, typ, &m.TimeUnixNano)
+		case 2:
+			return consumeString(b, typ, &m.Name)
+		case 3:
+			return consumeMapEntry(b, typ, &m.Attributes)
+		}
+		return skipField(num, typ, b)
+	})
+}
+
+func (m *SpanLink) appendTo(b []byte) []byte {
+	b = appendString(b, 1, m.TraceID)
+	b = appendString(b, 2, m.SpanID)
+	b = appendString(b, 3, m.TraceState)
+	b = appendStringMap(b, 4, m.Attributes)
+	return b
+}
+
+// Unmarshal decodes a span link
+func (m *SpanLink) Unmarshal(b []byte) error {
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch num {
+		case 1:
+			return consumeString(b, typ, &m.TraceID)
+		case 2:
+			return consumeString(b, typ, &m.SpanID)
+		case 3:
+			return consumeString(b, typ, &m.TraceState)
+		case 4:
+			return consumeMapEntry(b, typ, &m.Attributes)
+		}
+		return skipField(num, typ, b)
+	})
+}
+
+func (m *TraceSummary) appendTo(b []byte) []byte {
+	b = appendString(b, 1, m.RootServiceName)
+	b = appendString(b, 2, m.RootSpanName)
+	b = appendFixed64(b, 3, m.StartTimeUnixNano)
+	b = appendFixed64(b, 4, m.EndTimeUnixNano)
+	b = appendVarint(b, 5, m.DurationNs)
+	b = appendVarint(b, 6, m.SpanCount)
+	if m.HasErrors {
+		b = appendVarint(b, 7, 1)
+	}
+	for _, name := range m.ServiceNames {
+		b = protowire.AppendTag(b, 8, protowire.BytesType)
+		b = protowire.AppendString(b, name)
+	}
+	return b
+}
+
+// Unmarshal decodes a trace summary
+func (m *TraceSummary) Unmarshal(b []byte) error {
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch num {
+		case 1:
+			return consumeString(b, typ, &m.RootServiceName)
+		case 2:
+			return consumeString(b, typ, &m.RootSpanName)
+		case 3:
+			return consumeFixed64(b, typ, &m.StartTimeUnixNano)
+		case 4:
+			return consumeFixed64(b, typ, &m.EndTimeUnixNano)
+		case 5:
+			return consumeVarint(b, typ, &m.DurationNs)
+		case 6:
+			return consumeVarint(b, typ, &m.SpanCount)
+		case 7:
+			var v uint64
+			n, err := consumeVarint(b, typ, &v)
+			m.HasErrors = v != 0
+			return n, err
+		case 8:
+			var name string
+			n, err := consumeString(b, typ, &name)
+			if err == nil {
+				m.ServiceNames = append(m.ServiceNames, name)
+			}
+			return n, err
+		}
+		return skipField(num, typ, b)
+	})
+}
+
+// Marshal encodes the trace query response
+func (m *TraceQueryResponse) Marshal() []byte {
+	var b []byte
+	for _, span := range m.Spans {
+		b = appendMessage(b, 1, span.appendTo(nil))
+	}
+	b = appendVarint(b, 2, uint64(m.Total))
+	return b
+}
+
+// Unmarshal decodes a trace query response
+func (m *TraceQueryResponse) Unmarshal(b []byte) error {
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch num {
+		case 1:
+			span := &Span{}
+			n, err := consumeMessage(b, typ, span.Unmarshal)
+			if err == nil {
+				m.Spans = append(m.Spans, span)
+			}
+			return n, err
+		case 2:
+			var total uint64
+			n, err := consumeVarint(b, typ, &total)
+			m.Total = int64(total)
+			return n, err
+		}
+		return skipField(num, typ, b)
+	})
+}
+
+func (m *MetricDataPoint) appendTo(b []byte) []byte {
+	b = appendFixed64(b, 1, m.TimestampUnixNano)
+	if m.Value != 0 {
+		b = protowire.AppendTag(b, 2, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, math.Float64bits(m.Value))
+	}
+	b = appendStringMap(b, 3, m.Labels)
+	return b
+}
+
+// Unmarshal decodes a metric data point
+func (m *MetricDataPoint) Unmarshal(b []byte) error {
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch num {
+		case 1:
+			return consumeFixed64(b, typ, &m.TimestampUnixNano)
+		case 2:
+			var bits uint64
+			n, err := consumeFixed64(b, typ, &bits)
+			m.Value = math.Float64frombits(bits)
+			return n, err
+		case 3:
+			return consumeMapEntry(b, typ, &m.Labels)
+		}
+		return skipField(num, typ, b)
+	})
+}
+
+func (m *MetricSeries) appendTo(b []byte) []byte {
+	b = appendStringMap(b, 1, m.Labels)
+	for _, dp := range m.DataPoints {
+		b = appendMessage(b, 2, dp.appendTo(nil))
+	}
+	return b
+}
+
+// Unmarshal decodes a metric series
+func (m *MetricSeries) Unmarshal(b []byte) error {
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch num {
+		case 1:
+			return consumeMapEntry(b, typ, &m.Labels)
+		case 2:
+			dp := &MetricDataPoint{}
+			n, err := consumeMessage(b, typ, dp.Unmarshal)
+			if err == nil {
+				m.DataPoints = append(m.DataPoints, dp)
+			}
+			return n, err
+		}
+		return skipField(num, typ, b)
+	})
+}
+
+// Marshal encodes the metrics query response
+func (m *MetricsQueryResponse) Marshal() []byte {
+	var b []byte
+	b = appendString(b, 1, m.MetricName)
+	for _, dp := range m.DataPoints {
+		b = appendMessage(b, 2, dp.appendTo(nil))
+	}
+	for _, series := range m.Series {
+		b = appendMessage(b, 3, series.appendTo(nil))
+	}
+	return b
+}
+
+// Unmarshal decodes a metrics query response
+func (m *MetricsQueryResponse) Unmarshal(b []byte) error {
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch num {
+		case 1:
+			return consumeString(b, typ, &m.MetricName)
+		case 2:
+			dp := &MetricDataPoint{}
+			n, err := consumeMessage(b, typ, dp.Unmarshal)
+			if err == nil {
+				m.DataPoints = append(m.DataPoints, dp)
+			}
+			return n, err
+		case 3:
+			series := &MetricSeries{}
+			n, err := consumeMessage(b, typ, series.Unmarshal)
+			if err == nil {
+				m.Series = append(m.Series, series)
+			}
+			return n, err
+		}
+		return skipField(num, typ, b)
+	})
+}
+
+func (m *LogRecord) appendTo(b []byte) []byte {
+	b = appendFixed64(b, 1, m.TimestampUnixNano)
+	b = appendVarint(b, 2, uint64(m.SeverityNumber))
+	b = appendString(b, 3, m.SeverityText)
+	b = appendString(b, 4, m.Body)
+	b = appendString(b, 5, m.ServiceName)
+	b = appendString(b, 6, m.TraceID)
+	b = appendString(b, 7, m.SpanID)
+	b = appendStringMap(b, 8, m.Attributes)
+	b = appendVarint(b, 9, uint64(m.DroppedAttributesCount))
+	if m.Scope != nil {
+		b = appendMessage(b, 10, m.Scope.appendTo(nil))
+	}
+	return b
+}
+
+// Unmarshal decodes a log record
+func (m *LogRecord) Unmarshal(b []byte) error {
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch num {
+		case 1:
+			return consumeFixed64(b, typ, &m.TimestampUnixNano)
+		case 2:
+			return consumeUint32(b, typ, &m.SeverityNumber)
+		case 3:
+			return consumeString(b, typ, &m.SeverityText)
+		case 4:
+			return consumeString(b, typ, &m.Body)
+		case 5:
+			return consumeString(b, typ, &m.ServiceName)
+		case 6:
+			return consumeString(b, typ, &m.TraceID)
+		case 7:
+			return consumeString(b, typ, &m.SpanID)
+		case 8:
+			return consumeMapEntry(b, typ, &m.Attributes)
+		case 9:
+			return consumeUint32(b, typ, &m.DroppedAttributesCount)
+		case 10:
+			m.Scope = &InstrumentationScope{}
+			return consumeMessage(b, typ, m.Scope.Unmarshal)
+		}
+		return skipField(num, typ, b)
+	})
+}
+
+// Marshal encodes the logs query response
+func (m *LogsQueryResponse) Marshal() []byte {
+	var b []byte
+	for _, logRecord := range m.Logs {
+		b = appendMessage(b, 1, logRecord.appendTo(nil))
+	}
+	b = appendVarint(b, 2, uint64(m.Total))
+	return b
+}
+
+// Unmarshal decodes a logs query response
+func (m *LogsQueryResponse) Unmarshal(b []byte) error {
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch num {
+		case 1:
+			logRecord := &LogRecord{}
+			n, err := consumeMessage(b, typ, logRecord.Unmarshal)
+			if err == nil {
+				m.Logs = append(m.Logs, logRecord)
+			}
+			return n, err
+		case 2:
+			var total uint64
+			n, err := consumeVarint(b, typ, &total)
+			m.Total = int64(total)
+			return n, err
+		}
+		return skipField(num, typ, b)
+	})
+}
+
+func (m *ServiceStat) appendTo(b []byte) []byte {
+	b = appendString(b, 1, m.ServiceName)
+	b = appendVarint(b, 2, m.SpanCount)
+	b = appendDouble(b, 3, m.AvgDurationNs)
+	b = appendDouble(b, 4, m.P95DurationNs)
+	b = appendVarint(b, 5, m.ErrorCount)
+	return b
+}
+
+// Unmarshal decodes a service stat
+func (m *ServiceStat) Unmarshal(b []byte) error {
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch num {
+		case 1:
+			return consumeString(b, typ, &m.ServiceName)
+		case 2:
+			return consumeVarint(b, typ, &m.SpanCount)
+		case 3:
+			return consumeDouble(b, typ, &m.AvgDurationNs)
+		case 4:
+			return consumeDouble(b, typ, &m.P95DurationNs)
+		case 5:
+			return consumeVarint(b, typ, &m.ErrorCount)
+		}
+		return skipField(num, typ, b)
+	})
+}
+
+// Marshal encodes the service stats response
+func (m *ServiceStatsResponse) Marshal() []byte {
+	var b []byte
+	for _, stat := range m.Stats {
+		b = appendMessage(b, 1, stat.appendTo(nil))
+	}
+	return b
+}
+
+// Unmarshal decodes a service stats response
+func (m *ServiceStatsResponse) Unmarshal(b []byte) error {
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		if num == 1 {
+			stat := &ServiceStat{}
+			n, err := consumeMessage(b, typ, stat.Unmarshal)
+			if err == nil {
+				m.Stats = append(m.Stats, stat)
+			}
+			return n, err
+		}
+		return skipField(num, typ, b)
+	})
+}
+
+// Encoding helpers. Zero values are omitted, matching proto3 semantics.
+
+func appendString(b []byte, num protowire.Number, v string) []byte {
+	if v == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, v)
+}
+
+func appendVarint(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+func appendFixed64(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, v)
+}
+
+func appendDouble(b []byte, num protowire.Number, v float64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, math.Float64bits(v))
+}
+
+func appendBool(b []byte, num protowire.Number, v bool) []byte {
+	if !v {
+		return b
+	}
+	return appendVarint(b, num, 1)
+}
+
+func appendStrings(b []byte, num protowire.Number, values []string) []byte {
+	for _, v := range values {
+		b = protowire.AppendTag(b, num, protowire.BytesType)
+		b = protowire.AppendString(b, v)
+	}
+	return b
+}
+
+func appendMessage(b []byte, num protowire.Number, msg []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, msg)
+}
+
+// appendStringMap encodes a map<string, string> field with sorted keys so the
+// output is deterministic
+func appendStringMap(b []byte, num protowire.Number, m map[string]string) []byte {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		var entry []byte
+		entry = protowire.AppendTag(entry, 1, protowire.BytesType)
+		entry = protowire.AppendString(entry, k)
+		entry = protowire.AppendTag(entry, 2, protowire.BytesType)
+		entry = protowire.AppendString(entry, m[k])
+		b = appendMessage(b, num, entry)
+	}
+	return b
+}
+
+// Decoding helpers
+
+type fieldFunc func(num protowire.Number, typ protowire.Type, b []byte) (int, error)
+
+func consumeFields(b []byte, fn fieldFunc) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		n, err := fn(num, typ, b)
+		if err != nil {
+			return err
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+func checkType(typ, want protowire.Type) error {
+	if typ != want {
+		return fmt.Errorf("unexpected wire type %d, want %d", typ, want)
+	}
+	return nil
+}
+
+func consumeString(b []byte, typ protowire.Type, v *string) (int, error) {
+	if err := checkType(typ, protowire.BytesType); err != nil {
+		return 0, err
+	}
+	s, n := protowire.ConsumeString(b)
+	if n < 0 {
+		return 0, protowire.ParseError(n)
+	}
+	*v = s
+	return n, nil
+}
+
+func consumeVarint(b []byte, typ protowire.Type, v *uint64) (int, error) {
+	if err := checkType(typ, protowire.VarintType); err != nil {
+		return 0, err
+	}
+	x, n := protowire.ConsumeVarint(b)
+	if n < 0 {
+		return 0, protowire.ParseError(n)
+	}
+	*v = x
+	return n, nil
+}
+
+func consumeFixed64(b []byte, typ protowire.Type, v *uint64) (int, error) {
+	if err := checkType(typ, protowire.Fixed64Type); err != nil {
+		return 0, err
+	}
+	x, n := protowire.ConsumeFixed64(b)
+	if n < 0 {
+		return 0, protowire.ParseError(n)
+	}
+	*v = x
+	return n, nil
+}
+
+func consumeUint32(b []byte, typ protowire.Type, v *uint32) (int, error) {
+	var x uint64
+	n, err := consumeVarint(b, typ, &x)
+	*v = uint32(x)
+	return n, err
+}
+
+func consumeBool(b []byte, typ protowire.Type, v *bool) (int, error) {
+	var x uint64
+	n, err := consumeVarint(b, typ, &x)
+	*v = x != 0
+	return n, err
+}
+
+func consumeDouble(b []byte, typ protowire.Type, v *float64) (int, error) {
+	var bits uint64
+	n, err := consumeFixed64(b, typ, &bits)
+	*v = math.Float64frombits(bits)
+	return n, err
+}
+
+func consumeRepeatedString(b []byte, typ protowire.Type, values *[]string) (int, error) {
+	var v string
+	n, err := consumeString(b, typ, &v)
+	if err == nil {
+		*values = append(*values, v)
+	}
+	return n, err
+}
+
+func consumeMessage(b []byte, typ protowire.Type, unmarshal func([]byte) error) (int, error) {
+	if err := checkType(typ, protowire.BytesType); err != nil {
+		return 0, err
+	}
+	msg, n := protowire.ConsumeBytes(b)
+	if n < 0 {
+		return 0, protowire.ParseError(n)
+	}
+	return n, unmarshal(msg)
+}
+
+func consumeMapEntry(b []byte, typ protowire.Type, m *map[string]string) (int, error) {
+	var key, value string
+	n, err := consumeMessage(b, typ, func(entry []byte) error {
+		return consumeFields(entry, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+			switch num {
+			case 1:
+				return consumeString(b, typ, &key)
+			case 2:
+				return consumeString(b, typ, &value)
+			}
+			return skipField(num, typ, b)
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+	if *m == nil {
+		*m = make(map[string]string)
+	}
+	(*m)[key] = value
+	return n, nil
+}
+
+func skipField(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+	n := protowire.ConsumeFieldValue(num, typ, b)
+	if n < 0 {
+		return 0, protowire.ParseError(n)
+	}
+	return n, nil
+}
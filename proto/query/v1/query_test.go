@@ -0,0 +1,398 @@
+package queryv1
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+func TestTraceQueryResponseRoundTrip(t *testing.T) {
+	in := &TraceQueryResponse{
+		Spans: []*Span{
+			{
+				TraceID:           "0af7651916cd43dd8448eb211c80319c",
+				SpanID:            "b7ad6b7169203331",
+				SpanName:          "GET /api/users",
+				SpanKind:          "server",
+				StartTimeUnixNano: 1700000000000000000,
+				EndTimeUnixNano:   1700000000250000000,
+				DurationNs:        250000000,
+				StatusCode:        "ok",
+				ServiceName:       "frontend",
+				Attributes:        map[string]string{"http.method": "GET", "http.status_code": "200"},
+				Trace: &TraceSummary{
+					RootServiceName:   "frontend",
+					RootSpanName:      "GET /api/users",
+					StartTimeUnixNano: 1700000000000000000,
+					EndTimeUnixNano:   1700000000250000000,
+					DurationNs:        250000000,
+					SpanCount:         2,
+					HasErrors:         true,
+					ServiceNames:      []string{"frontend", "users"},
+				},
+				Events: []*SpanEvent{
+					{TimeUnixNano: 1700000000100000000, Name: "exception", Attributes: map[string]string{"exception.type": "IOError"}},
+				},
+				Links: []*SpanLink{
+					{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", SpanID: "00f067aa0ba902b7", TraceState: "vendor=1"},
+				},
+				TraceState:             "congo=t61rcWkgMzE",
+				DroppedAttributesCount: 3,
+				DroppedEventsCount:     1,
+				Scope: &InstrumentationScope{
+					Name:       "io.opentelemetry.http",
+					Version:    "1.2.0",
+					Attributes: map[string]string{"library.language": "go"},
+				},
+			},
+			{TraceID: "0af7651916cd43dd8448eb211c80319c", SpanID: "00f067aa0ba902b7", ParentSpanID: "b7ad6b7169203331"},
+		},
+		Total: 2,
+	}
+
+	var out TraceQueryResponse
+	if err := out.Unmarshal(in.Marshal()); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if !reflect.DeepEqual(in, &out) {
+		t.Errorf("round trip mismatch:\n got %+v\nwant %+v", out, in)
+	}
+}
+
+func TestMetricsQueryResponseRoundTrip(t *testing.T) {
+	in := &MetricsQueryResponse{
+		MetricName: "http_requests_total",
+		DataPoints: []*MetricDataPoint{
+			{TimestampUnixNano: 1700000000000000000, Value: 42.5},
+			{TimestampUnixNano: 1700000060000000000, Value: -1.25},
+		},
+		Series: []*MetricSeries{
+			{
+				Labels:     map[string]string{"service_name": "api"},
+				DataPoints: []*MetricDataPoint{{TimestampUnixNano: 1700000000000000000, Value: 10}},
+			},
+		},
+	}
+
+	var out MetricsQueryResponse
+	if err := out.Unmarshal(in.Marshal()); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if !reflect.DeepEqual(in, &out) {
+		t.Errorf("round trip mismatch:\n got %+v\nwant %+v", out, in)
+	}
+}
+
+func TestLogsQueryResponseRoundTrip(t *testing.T) {
+	in := &LogsQueryResponse{
+		Logs: []*LogRecord{
+			{
+				TimestampUnixNano:      1700000000000000000,
+				SeverityNumber:         17,
+				SeverityText:           "ERROR",
+				Body:                   "connection refused",
+				ServiceName:            "api",
+				TraceID:                "0af7651916cd43dd8448eb211c80319c",
+				SpanID:                 "b7ad6b7169203331",
+				Attributes:             map[string]string{"peer.service": "db"},
+				DroppedAttributesCount: 2,
+				Scope:                  &InstrumentationScope{Name: "app.db"},
+			},
+			{Body: "started"},
+		},
+		Total: 2,
+	}
+
+	var out LogsQueryResponse
+	if err := out.Unmarshal(in.Marshal()); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if !reflect.DeepEqual(in, &out) {
+		t.Errorf("round trip mismatch:\n got %+v\nwant %+v", out, in)
+	}
+}
+
+func TestServiceStatsResponseRoundTrip(t *testing.T) {
+	in := &ServiceStatsResponse{Stats: []*ServiceStat{
+		{ServiceName: "api", SpanCount: 1200, AvgDurationNs: 1.5e6, P95DurationNs: 9.25e6, ErrorCount: 3},
+		{ServiceName: "worker"},
+	}}
+
+	var out ServiceStatsResponse
+	if err := out.Unmarshal(in.Marshal()); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if !reflect.DeepEqual(in, &out) {
+		t.Errorf("round trip mismatch:\n got %+v\nwant %+v", out, in)
+	}
+}
+
+func TestMarshalIsDeterministic(t *testing.T) {
+	span := &Span{Attributes: map[string]string{"a": "1", "b": "2", "c": "3", "d": "4"}}
+	first := span.Marshal()
+	for i := 0; i < 10; i++ {
+		if !reflect.DeepEqual(first, span.Marshal()) {
+			t.Fatal("Marshal output differs between calls")
+		}
+	}
+}
+
+func TestUnmarshalSkipsUnknownFields(t *testing.T) {
+	b := (&MetricsQueryResponse{MetricName: "cpu"}).Marshal()
+	// field 15, varint 1
+	b = append(b, 15<<3, 1)
+
+	var out MetricsQueryResponse
+	if err := out.Unmarshal(b); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if out.MetricName != "cpu" {
+		t.Errorf("MetricName = %q, want cpu", out.MetricName)
+	}
+}
+
+func TestUnmarshalTruncated(t *testing.T) {
+	b := (&Span{TraceID: "abc"}).Marshal()
+	var out Span
+	if err := out.Unmarshal(b[:len(b)-1]); err == nil {
+		t.Error("expected error for truncated input")
+	}
+}
+
+// TestMessagesMatchProtoFile checks the hand-maintained types against
+// query.proto: every field of every message is set through the descriptor,
+// decoded into the Go type, encoded again and compared with the original.
+func TestMessagesMatchProtoFile(t *testing.T) {
+	file := loadProtoFile(t, "query.proto")
+
+	// Messages with Marshal; the others are covered as fields of these
+	types := map[string]interface {
+		Marshal() []byte
+		Unmarshal([]byte) error
+	}{
+		"TraceQueryResponse":   &TraceQueryResponse{},
+		"MetricsQueryResponse": &MetricsQueryResponse{},
+		"LogsQueryResponse":    &LogsQueryResponse{},
+		"ServiceStatsResponse": &ServiceStatsResponse{},
+		"TraceQueryRequest":    &TraceQueryRequest{},
+		"MetricsQueryRequest":  &MetricsQueryRequest{},
+		"LogsQueryRequest":     &LogsQueryRequest{},
+		"ServiceStatsRequest":  &ServiceStatsRequest{},
+	}
+
+	covered := make(map[protoreflect.FullName]bool)
+	var cover func(md protoreflect.MessageDescriptor)
+	cover = func(md protoreflect.MessageDescriptor) {
+		covered[md.FullName()] = true
+		for i := 0; i < md.Fields().Len(); i++ {
+			if fd := md.Fields().Get(i); fd.Message() != nil && !fd.IsMap() {
+				cover(fd.Message())
+			}
+		}
+	}
+
+	for name, m := range types {
+		md := file.Messages().ByName(protoreflect.Name(name))
+		if md == nil {
+			t.Errorf("%s is not in query.proto", name)
+			continue
+		}
+		cover(md)
+
+		want := dynamicpb.NewMessage(md)
+		n := 0
+		fillMessage(want, &n)
+		b, err := proto.Marshal(want)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := m.Unmarshal(b); err != nil {
+			t.Errorf("%s: Unmarshal failed: %v", name, err)
+			continue
+		}
+		got := dynamicpb.NewMessage(md)
+		if err := proto.Unmarshal(m.Marshal(), got); err != nil {
+			t.Errorf("%s: decoding Marshal output failed: %v", name, err)
+			continue
+		}
+		if !proto.Equal(got, want) {
+			t.Errorf("%s round trip:\ngot  %v\nwant %v", name, got, want)
+		}
+	}
+
+	for i := 0; i < file.Messages().Len(); i++ {
+		if md := file.Messages().Get(i); !covered[md.FullName()] {
+			t.Errorf("%s is not covered by the round trip", md.Name())
+		}
+	}
+}
+
+// fillMessage sets every field of m to a distinct non-zero value, with two
+// elements in repeated fields and maps
+func fillMessage(m protoreflect.Message, n *int) {
+	fields := m.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		switch {
+		case fd.IsMap():
+			mp := m.Mutable(fd).Map()
+			for j := 0; j < 2; j++ {
+				*n++
+				mp.Set(protoreflect.ValueOfString(fmt.Sprintf("key%d", *n)).MapKey(), fieldValue(fd.MapValue(), n))
+			}
+		case fd.IsList():
+			list := m.Mutable(fd).List()
+			for j := 0; j < 2; j++ {
+				if fd.Message() != nil {
+					el := list.NewElement()
+					fillMessage(el.Message(), n)
+					list.Append(el)
+				} else {
+					list.Append(fieldValue(fd, n))
+				}
+			}
+		case fd.Message() != nil:
+			fillMessage(m.Mutable(fd).Message(), n)
+		default:
+			m.Set(fd, fieldValue(fd, n))
+		}
+	}
+}
+
+func fieldValue(fd protoreflect.FieldDescriptor, n *int) protoreflect.Value {
+	*n++
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString(fmt.Sprintf("%s-%d", fd.Name(), *n))
+	case protoreflect.BoolKind:
+		return protoreflect.ValueOfBool(true)
+	case protoreflect.DoubleKind:
+		return protoreflect.ValueOfFloat64(float64(*n) + 0.5)
+	case protoreflect.Int64Kind:
+		return protoreflect.ValueOfInt64(int64(*n) << 40)
+	case protoreflect.Uint32Kind:
+		return protoreflect.ValueOfUint32(uint32(*n))
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return protoreflect.ValueOfUint64(uint64(*n) << 40)
+	}
+	panic(fmt.Sprintf("no test value for %s fields", fd.Kind()))
+}
+
+var (
+	protoComment = regexp.MustCompile(`//.*`)
+	protoMessage = regexp.MustCompile(`message\s+(\w+)\s*\{([^}]*)\}`)
+	protoField   = regexp.MustCompile(`^(repeated\s+)?(map<\s*string\s*,\s*string\s*>|[\w.]+)\s+(\w+)\s*=\s*(\d+)$`)
+)
+
+// protoScalars are the scalar types used in query.proto
+var protoScalars = map[string]descriptorpb.FieldDescriptorProto_Type{
+	"string":  descriptorpb.FieldDescriptorProto_TYPE_STRING,
+	"bool":    descriptorpb.FieldDescriptorProto_TYPE_BOOL,
+	"double":  descriptorpb.FieldDescriptorProto_TYPE_DOUBLE,
+	"int64":   descriptorpb.FieldDescriptorProto_TYPE_INT64,
+	"uint32":  descriptorpb.FieldDescriptorProto_TYPE_UINT32,
+	"uint64":  descriptorpb.FieldDescriptorProto_TYPE_UINT64,
+	"fixed64": descriptorpb.FieldDescriptorProto_TYPE_FIXED64,
+}
+
+// loadProtoFile builds the descriptor of the messages of a proto file. It
+// reads the subset of proto3 query.proto uses: top-level messages of scalar,
+// message and map<string, string> fields.
+func loadProtoFile(t *testing.T, path string) protoreflect.FileDescriptor {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := protoComment.ReplaceAllString(string(data), "")
+	pkg := regexp.MustCompile(`package\s+([\w.]+)\s*;`).FindStringSubmatch(src)
+	if pkg == nil {
+		t.Fatalf("%s has no package", path)
+	}
+
+	fdp := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String(path),
+		Package: proto.String(pkg[1]),
+		Syntax:  proto.String("proto3"),
+	}
+	for _, msg := range protoMessage.FindAllStringSubmatch(src, -1) {
+		dp := &descriptorpb.DescriptorProto{Name: proto.String(msg[1])}
+		for _, decl := range strings.Split(msg[2], ";") {
+			decl = strings.TrimSpace(decl)
+			if decl == "" {
+				continue
+			}
+			m := protoField.FindStringSubmatch(decl)
+			if m == nil {
+				t.Fatalf("%s: cannot parse field %q of %s", path, decl, msg[1])
+			}
+			var num int32
+			fmt.Sscan(m[4], &num)
+			field := &descriptorpb.FieldDescriptorProto{
+				Name:   proto.String(m[3]),
+				Number: proto.Int32(num),
+				Label:  descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			}
+			if m[1] != "" {
+				field.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+			}
+			switch typ := m[2]; {
+			case strings.HasPrefix(typ, "map<"):
+				entry := mapEntryName(m[3])
+				dp.NestedType = append(dp.NestedType, &descriptorpb.DescriptorProto{
+					Name: proto.String(entry),
+					Field: []*descriptorpb.FieldDescriptorProto{
+						scalarField("key", 1), scalarField("value", 2),
+					},
+					Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+				})
+				field.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+				field.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+				field.TypeName = proto.String("." + pkg[1] + "." + msg[1] + "." + entry)
+			case protoScalars[typ] != 0:
+				field.Type = protoScalars[typ].Enum()
+			default:
+				field.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+				field.TypeName = proto.String("." + pkg[1] + "." + typ)
+			}
+			dp.Field = append(dp.Field, field)
+		}
+		fdp.MessageType = append(fdp.MessageType, dp)
+	}
+
+	file, err := protodesc.NewFile(fdp, nil)
+	if err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+	return file
+}
+
+func scalarField(name string, num int32) *descriptorpb.FieldDescriptorProto {
+	return &descriptorpb.FieldDescriptorProto{
+		Name:   proto.String(name),
+		Number: proto.Int32(num),
+		Label:  descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		Type:   descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+	}
+}
+
+// mapEntryName returns the name protoc gives the entry message of a map
+// field, e.g. AttributesEntry for attributes
+func mapEntryName(field string) string {
+	var b strings.Builder
+	for _, part := range strings.Split(field, "_") {
+		if part != "" {
+			b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+		}
+	}
+	return b.String() + "Entry"
+}
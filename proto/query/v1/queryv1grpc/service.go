@@ -0,0 +1,245 @@
+// Package queryv1grpc contains the gRPC QueryService described by
+// query.proto. Like the messages in queryv1 it is hand-maintained rather
+// than generated, and the messages are encoded with their own Marshal and
+// Unmarshal methods through Codec.
+package queryv1grpc
+
+import (
+	"context"
+	"fmt"
+
+	queryv1 "otelservices/proto/query/v1"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+)
+
+// ServiceName is the fully qualified name of the QueryService
+const ServiceName = "otelservices.query.v1.QueryService"
+
+// Full method names of the QueryService RPCs
+const (
+	TraceQueryMethod   = "/" + ServiceName + "/TraceQuery"
+	MetricsQueryMethod = "/" + ServiceName + "/MetricsQuery"
+	LogsQueryMethod    = "/" + ServiceName + "/LogsQuery"
+	ServiceStatsMethod = "/" + ServiceName + "/ServiceStats"
+)
+
+// message is implemented by every queryv1 message
+type message interface {
+	Marshal() []byte
+	Unmarshal([]byte) error
+}
+
+// Codec encodes queryv1 messages on the wire; other protobuf messages fall
+// back to the protobuf runtime. It is only used through ForceCodec and
+// ForceServerCodec, never registered, so it does not replace the "proto"
+// codec of the process. Calls still send the "proto" content subtype, so
+// clients and servers generated from query.proto interoperate.
+type Codec struct{}
+
+// Marshal encodes v
+func (Codec) Marshal(v interface{}) ([]byte, error) {
+	switch m := v.(type) {
+	case message:
+		return m.Marshal(), nil
+	case proto.Message:
+		return proto.Marshal(m)
+	}
+	return nil, fmt.Errorf("cannot marshal %T", v)
+}
+
+// Unmarshal decodes data into v
+func (Codec) Unmarshal(data []byte, v interface{}) error {
+	switch m := v.(type) {
+	case message:
+		return m.Unmarshal(data)
+	case proto.Message:
+		return proto.Unmarshal(data, m)
+	}
+	return fmt.Errorf("cannot unmarshal into %T", v)
+}
+
+// Name returns the name of the codec
+func (Codec) Name() string {
+	return "queryv1"
+}
+
+// callOptions are prepended to the options of every call
+func callOptions(opts []grpc.CallOption) []grpc.CallOption {
+	return append([]grpc.CallOption{grpc.ForceCodec(Codec{}), grpc.CallContentSubtype("proto")}, opts...)
+}
+
+// QueryServiceServer is implemented by the query service
+type QueryServiceServer interface {
+	TraceQuery(*queryv1.TraceQueryRequest, TraceQueryStream) error
+	MetricsQuery(*queryv1.MetricsQueryRequest, MetricsQueryStream) error
+	LogsQuery(*queryv1.LogsQueryRequest, LogsQueryStream) error
+	ServiceStats(context.Context, *queryv1.ServiceStatsRequest) (*queryv1.ServiceStatsResponse, error)
+}
+
+// TraceQueryStream sends the batches of a trace query result
+type TraceQueryStream interface {
+	Send(*queryv1.TraceQueryResponse) error
+	grpc.ServerStream
+}
+
+// MetricsQueryStream sends the batches of a metrics query result
+type MetricsQueryStream interface {
+	Send(*queryv1.MetricsQueryResponse) error
+	grpc.ServerStream
+}
+
+// LogsQueryStream sends the batches of a logs query result
+type LogsQueryStream interface {
+	Send(*queryv1.LogsQueryResponse) error
+	grpc.ServerStream
+}
+
+// RegisterQueryServiceServer registers srv with s. The server must be
+// created with grpc.ForceServerCodec(Codec{}).
+func RegisterQueryServiceServer(s grpc.ServiceRegistrar, srv QueryServiceServer) {
+	s.RegisterService(&serviceDesc, srv)
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*QueryServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "ServiceStats", Handler: serviceStatsHandler},
+	},
+	Streams: []grpc.StreamDesc{
+		{StreamName: "TraceQuery", Handler: traceQueryHandler, ServerStreams: true},
+		{StreamName: "MetricsQuery", Handler: metricsQueryHandler, ServerStreams: true},
+		{StreamName: "LogsQuery", Handler: logsQueryHandler, ServerStreams: true},
+	},
+	Metadata: "query.proto",
+}
+
+func serviceStatsHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(queryv1.ServiceStatsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(QueryServiceServer).ServiceStats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: ServiceStatsMethod}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(QueryServiceServer).ServiceStats(ctx, req.(*queryv1.ServiceStatsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// serverStream sends messages of type T on a server stream
+type serverStream[T any] struct {
+	grpc.ServerStream
+}
+
+func (s serverStream[T]) Send(m *T) error {
+	return s.ServerStream.SendMsg(m)
+}
+
+func traceQueryHandler(srv interface{}, stream grpc.ServerStream) error {
+	in := new(queryv1.TraceQueryRequest)
+	if err := stream.RecvMsg(in); err != nil {
+		return err
+	}
+	return srv.(QueryServiceServer).TraceQuery(in, serverStream[queryv1.TraceQueryResponse]{stream})
+}
+
+func metricsQueryHandler(srv interface{}, stream grpc.ServerStream) error {
+	in := new(queryv1.MetricsQueryRequest)
+	if err := stream.RecvMsg(in); err != nil {
+		return err
+	}
+	return srv.(QueryServiceServer).MetricsQuery(in, serverStream[queryv1.MetricsQueryResponse]{stream})
+}
+
+func logsQueryHandler(srv interface{}, stream grpc.ServerStream) error {
+	in := new(queryv1.LogsQueryRequest)
+	if err := stream.RecvMsg(in); err != nil {
+		return err
+	}
+	return srv.(QueryServiceServer).LogsQuery(in, serverStream[queryv1.LogsQueryResponse]{stream})
+}
+
+// QueryServiceClient calls the QueryService. Streaming calls return a
+// receiver whose Recv returns io.EOF after the last batch.
+type QueryServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+// NewQueryServiceClient creates a client on cc. Calls use Codec, so cc
+// needs no codec options.
+func NewQueryServiceClient(cc grpc.ClientConnInterface) *QueryServiceClient {
+	return &QueryServiceClient{cc: cc}
+}
+
+// Receiver receives the batches of a streamed query result
+type Receiver[T any] struct {
+	grpc.ClientStream
+}
+
+// Recv returns the next batch
+func (r *Receiver[T]) Recv() (*T, error) {
+	m := new(T)
+	if err := r.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// TraceQuery streams the spans matching in
+func (c *QueryServiceClient) TraceQuery(ctx context.Context, in *queryv1.TraceQueryRequest, opts ...grpc.CallOption) (*Receiver[queryv1.TraceQueryResponse], error) {
+	stream, err := c.stream(ctx, 0, TraceQueryMethod, in, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Receiver[queryv1.TraceQueryResponse]{stream}, nil
+}
+
+// MetricsQuery streams the data points matching in
+func (c *QueryServiceClient) MetricsQuery(ctx context.Context, in *queryv1.MetricsQueryRequest, opts ...grpc.CallOption) (*Receiver[queryv1.MetricsQueryResponse], error) {
+	stream, err := c.stream(ctx, 1, MetricsQueryMethod, in, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Receiver[queryv1.MetricsQueryResponse]{stream}, nil
+}
+
+// LogsQuery streams the logs matching in
+func (c *QueryServiceClient) LogsQuery(ctx context.Context, in *queryv1.LogsQueryRequest, opts ...grpc.CallOption) (*Receiver[queryv1.LogsQueryResponse], error) {
+	stream, err := c.stream(ctx, 2, LogsQueryMethod, in, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Receiver[queryv1.LogsQueryResponse]{stream}, nil
+}
+
+// ServiceStats returns the span statistics of every service
+func (c *QueryServiceClient) ServiceStats(ctx context.Context, in *queryv1.ServiceStatsRequest, opts ...grpc.CallOption) (*queryv1.ServiceStatsResponse, error) {
+	out := new(queryv1.ServiceStatsResponse)
+	opts = callOptions(opts)
+	if err := c.cc.Invoke(ctx, ServiceStatsMethod, in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// stream opens the server stream described by serviceDesc.Streams[i] and
+// sends the request
+func (c *QueryServiceClient) stream(ctx context.Context, i int, method string, in interface{}, opts []grpc.CallOption) (grpc.ClientStream, error) {
+	opts = callOptions(opts)
+	stream, err := c.cc.NewStream(ctx, &serviceDesc.Streams[i], method, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := stream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
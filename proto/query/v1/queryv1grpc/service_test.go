@@ -0,0 +1,118 @@
+package queryv1grpc
+
+import (
+	"context"
+	"net"
+	"os"
+	"regexp"
+	"testing"
+	"time"
+
+	queryv1 "otelservices/proto/query/v1"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestCodec(t *testing.T) {
+	var codec Codec
+	data, err := codec.Marshal(&queryv1.TraceQueryRequest{ServiceName: "api", Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var req queryv1.TraceQueryRequest
+	if err := codec.Unmarshal(data, &req); err != nil || req.ServiceName != "api" || req.Limit != 10 {
+		t.Errorf("round trip = %+v, %v", req, err)
+	}
+
+	// Other protobuf messages use the protobuf runtime
+	data, err = codec.Marshal(wrapperspb.String("api"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s wrapperspb.StringValue
+	if err := codec.Unmarshal(data, &s); err != nil || s.Value != "api" {
+		t.Errorf("round trip = %q, %v", s.Value, err)
+	}
+
+	if _, err := codec.Marshal("api"); err == nil {
+		t.Error("expected error for a non-message")
+	}
+}
+
+func TestServiceDescMatchesProtoFile(t *testing.T) {
+	data, err := os.ReadFile("../query.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rpc := regexp.MustCompile(`rpc\s+(\w+)\s*\(\s*(\w+)\s*\)\s*returns\s*\(\s*(stream\s+)?(\w+)\s*\)`)
+	want := make(map[string]bool) // method name -> server streaming
+	for _, m := range rpc.FindAllStringSubmatch(string(data), -1) {
+		want[m[1]] = m[3] != ""
+	}
+
+	got := make(map[string]bool)
+	for _, m := range serviceDesc.Methods {
+		got[m.MethodName] = false
+	}
+	for _, s := range serviceDesc.Streams {
+		if s.ClientStreams {
+			t.Errorf("%s is client streaming", s.StreamName)
+		}
+		got[s.StreamName] = s.ServerStreams
+	}
+	if len(got) != len(want) {
+		t.Errorf("methods = %v, query.proto has %v", got, want)
+	}
+	for name, streaming := range want {
+		if s, ok := got[name]; !ok || s != streaming {
+			t.Errorf("%s: server streaming = %v (defined %v), query.proto has %v", name, s, ok, streaming)
+		}
+	}
+}
+
+type statsServer struct {
+	QueryServiceServer
+	contentType []string
+}
+
+func (s *statsServer) ServiceStats(ctx context.Context, _ *queryv1.ServiceStatsRequest) (*queryv1.ServiceStatsResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	s.contentType = md.Get("content-type")
+	return &queryv1.ServiceStatsResponse{Stats: []*queryv1.ServiceStat{{ServiceName: "api", SpanCount: 3}}}, nil
+}
+
+// Calls are sent as application/grpc+proto, like those of generated clients
+func TestClientSendsProtoContentSubtype(t *testing.T) {
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer(grpc.ForceServerCodec(Codec{}))
+	stats := &statsServer{}
+	RegisterQueryServiceServer(srv, stats)
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, "bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	resp, err := NewQueryServiceClient(conn).ServiceStats(ctx, &queryv1.ServiceStatsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Stats) != 1 || resp.Stats[0].ServiceName != "api" || resp.Stats[0].SpanCount != 3 {
+		t.Errorf("ServiceStats = %+v", resp.Stats)
+	}
+	if len(stats.contentType) != 1 || stats.contentType[0] != "application/grpc+proto" {
+		t.Errorf("content-type = %v, want application/grpc+proto", stats.contentType)
+	}
+}
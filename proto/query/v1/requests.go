@@ -0,0 +1,181 @@
+package queryv1
+
+import "google.golang.org/protobuf/encoding/protowire"
+
+// TraceQueryRequest mirrors the TraceQueryRequest message
+type TraceQueryRequest struct {
+	TraceID               string
+	ServiceName           string
+	StartTimeUnixNano     uint64
+	EndTimeUnixNano       uint64
+	MinDurationNs         uint64
+	MaxDurationNs         uint64
+	Limit                 uint32
+	IncludeColdAttributes bool
+}
+
+// MetricsQueryRequest mirrors the MetricsQueryRequest message
+type MetricsQueryRequest struct {
+	MetricName        string
+	ServiceName       string
+	StartTimeUnixNano uint64
+	EndTimeUnixNano   uint64
+	Aggregation       string
+	GroupBy           []string
+	Filters           map[string]string
+	Step              string
+	Timezone          string
+}
+
+// LogsQueryRequest mirrors the LogsQueryRequest message
+type LogsQueryRequest struct {
+	ServiceName       string
+	StartTimeUnixNano uint64
+	EndTimeUnixNano   uint64
+	Severity          string
+	SeverityMin       uint32
+	SeverityMax       uint32
+	SearchText        string
+	TraceID           string
+	Filters           map[string]string
+	Limit             uint32
+}
+
+// ServiceStatsRequest mirrors the empty ServiceStatsRequest message
+type ServiceStatsRequest struct{}
+
+// Marshal encodes the trace query request
+func (m *TraceQueryRequest) Marshal() []byte {
+	var b []byte
+	b = appendString(b, 1, m.TraceID)
+	b = appendString(b, 2, m.ServiceName)
+	b = appendFixed64(b, 3, m.StartTimeUnixNano)
+	b = appendFixed64(b, 4, m.EndTimeUnixNano)
+	b = appendVarint(b, 5, m.MinDurationNs)
+	b = appendVarint(b, 6, m.MaxDurationNs)
+	b = appendVarint(b, 7, uint64(m.Limit))
+	b = appendBool(b, 8, m.IncludeColdAttributes)
+	return b
+}
+
+// Unmarshal decodes a trace query request
+func (m *TraceQueryRequest) Unmarshal(b []byte) error {
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch num {
+		case 1:
+			return consumeString(b, typ, &m.TraceID)
+		case 2:
+			return consumeString(b, typ, &m.ServiceName)
+		case 3:
+			return consumeFixed64(b, typ, &m.StartTimeUnixNano)
+		case 4:
+			return consumeFixed64(b, typ, &m.EndTimeUnixNano)
+		case 5:
+			return consumeVarint(b, typ, &m.MinDurationNs)
+		case 6:
+			return consumeVarint(b, typ, &m.MaxDurationNs)
+		case 7:
+			return consumeUint32(b, typ, &m.Limit)
+		case 8:
+			return consumeBool(b, typ, &m.IncludeColdAttributes)
+		}
+		return skipField(num, typ, b)
+	})
+}
+
+// Marshal encodes the metrics query request
+func (m *MetricsQueryRequest) Marshal() []byte {
+	var b []byte
+	b = appendString(b, 1, m.MetricName)
+	b = appendString(b, 2, m.ServiceName)
+	b = appendFixed64(b, 3, m.StartTimeUnixNano)
+	b = appendFixed64(b, 4, m.EndTimeUnixNano)
+	b = appendString(b, 5, m.Aggregation)
+	b = appendStrings(b, 6, m.GroupBy)
+	b = appendStringMap(b, 7, m.Filters)
+	b = appendString(b, 8, m.Step)
+	b = appendString(b, 9, m.Timezone)
+	return b
+}
+
+// Unmarshal decodes a metrics query request
+func (m *MetricsQueryRequest) Unmarshal(b []byte) error {
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch num {
+		case 1:
+			return consumeString(b, typ, &m.MetricName)
+		case 2:
+			return consumeString(b, typ, &m.ServiceName)
+		case 3:
+			return consumeFixed64(b, typ, &m.StartTimeUnixNano)
+		case 4:
+			return consumeFixed64(b, typ, &m.EndTimeUnixNano)
+		case 5:
+			return consumeString(b, typ, &m.Aggregation)
+		case 6:
+			return consumeRepeatedString(b, typ, &m.GroupBy)
+		case 7:
+			return consumeMapEntry(b, typ, &m.Filters)
+		case 8:
+			return consumeString(b, typ, &m.Step)
+		case 9:
+			return consumeString(b, typ, &m.Timezone)
+		}
+		return skipField(num, typ, b)
+	})
+}
+
+// Marshal encodes the logs query request
+func (m *LogsQueryRequest) Marshal() []byte {
+	var b []byte
+	b = appendString(b, 1, m.ServiceName)
+	b = appendFixed64(b, 2, m.StartTimeUnixNano)
+	b = appendFixed64(b, 3, m.EndTimeUnixNano)
+	b = appendString(b, 4, m.Severity)
+	b = appendVarint(b, 5, uint64(m.SeverityMin))
+	b = appendVarint(b, 6, uint64(m.SeverityMax))
+	b = appendString(b, 7, m.SearchText)
+	b = appendString(b, 8, m.TraceID)
+	b = appendStringMap(b, 9, m.Filters)
+	b = appendVarint(b, 10, uint64(m.Limit))
+	return b
+}
+
+// Unmarshal decodes a logs query request
+func (m *LogsQueryRequest) Unmarshal(b []byte) error {
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch num {
+		case 1:
+			return consumeString(b, typ, &m.ServiceName)
+		case 2:
+			return consumeFixed64(b, typ, &m.StartTimeUnixNano)
+		case 3:
+			return consumeFixed64(b, typ, &m.EndTimeUnixNano)
+		case 4:
+			return consumeString(b, typ, &m.Severity)
+		case 5:
+			return consumeUint32(b, typ, &m.SeverityMin)
+		case 6:
+			return consumeUint32(b, typ, &m.SeverityMax)
+		case 7:
+			return consumeString(b, typ, &m.SearchText)
+		case 8:
+			return consumeString(b, typ, &m.TraceID)
+		case 9:
+			return consumeMapEntry(b, typ, &m.Filters)
+		case 10:
+			return consumeUint32(b, typ, &m.Limit)
+		}
+		return skipField(num, typ, b)
+	})
+}
+
+// Marshal encodes the service stats request, which has no fields
+func (m *ServiceStatsRequest) Marshal() []byte {
+	return nil
+}
+
+// Unmarshal decodes a service stats request, skipping any fields
+func (m *ServiceStatsRequest) Unmarshal(b []byte) error {
+	return consumeFields(b, skipField)
+}
@@ -0,0 +1,72 @@
+package queryv1
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRequestsRoundTrip(t *testing.T) {
+	tests := []struct {
+		in  interface{ Marshal() []byte }
+		out interface{ Unmarshal([]byte) error }
+	}{
+		{
+			in: &TraceQueryRequest{
+				TraceID:               "0af7651916cd43dd8448eb211c80319c",
+				ServiceName:           "api",
+				StartTimeUnixNano:     1700000000000000000,
+				EndTimeUnixNano:       1700003600000000000,
+				MinDurationNs:         1000000,
+				MaxDurationNs:         5000000000,
+				Limit:                 500,
+				IncludeColdAttributes: true,
+			},
+			out: &TraceQueryRequest{},
+		},
+		{
+			in: &MetricsQueryRequest{
+				MetricName:        "http.server.duration",
+				ServiceName:       "api",
+				StartTimeUnixNano: 1700000000000000000,
+				EndTimeUnixNano:   1700003600000000000,
+				Aggregation:       "max",
+				GroupBy:           []string{"service_name", "http.route"},
+				Filters:           map[string]string{"http.method": "GET"},
+				Step:              "1m",
+				Timezone:          "Europe/Berlin",
+			},
+			out: &MetricsQueryRequest{},
+		},
+		{
+			in: &LogsQueryRequest{
+				ServiceName:       "api",
+				StartTimeUnixNano: 1700000000000000000,
+				EndTimeUnixNano:   1700003600000000000,
+				Severity:          "WARN+",
+				SeverityMin:       13,
+				SeverityMax:       24,
+				SearchText:        "timeout",
+				TraceID:           "0af7651916cd43dd8448eb211c80319c",
+				Filters:           map[string]string{"k8s.pod.name": "api-0"},
+				Limit:             50,
+			},
+			out: &LogsQueryRequest{},
+		},
+		{in: &ServiceStatsRequest{}, out: &ServiceStatsRequest{}},
+	}
+	for _, tt := range tests {
+		if err := tt.out.Unmarshal(tt.in.Marshal()); err != nil {
+			t.Fatalf("%T: Unmarshal failed: %v", tt.in, err)
+		}
+		if !reflect.DeepEqual(tt.in, tt.out) {
+			t.Errorf("round trip mismatch:\n got %+v\nwant %+v", tt.out, tt.in)
+		}
+	}
+}
+
+func TestServiceStatsRequestSkipsFields(t *testing.T) {
+	// field 1, varint 1
+	if err := (&ServiceStatsRequest{}).Unmarshal([]byte{1 << 3, 1}); err != nil {
+		t.Errorf("Unmarshal failed: %v", err)
+	}
+}
@@ -34,25 +34,32 @@ ORDER BY (timestamp, metric_name, service_name)
 TTL toDateTime(timestamp) + INTERVAL 30 DAY
 SETTINGS index_granularity = 8192;
 
+-- Rollup tables
+-- Materialized views aggregate each insert block separately, so late or
+-- split inserts produce several partial rows per window. The rollups keep
+-- mergeable partials (min/max/sum/count) that AggregatingMergeTree combines
+-- at merge time; queries must re-aggregate them (avg = sum(value_sum) /
+-- sum(value_count)) to stay correct before merges have happened.
+
 -- 5-minute rollup table
 CREATE TABLE IF NOT EXISTS otel_metrics_5m (
     timestamp DateTime CODEC(Delta, ZSTD(3)),
     metric_name LowCardinality(String) CODEC(ZSTD(3)),
     service_name LowCardinality(String) CODEC(ZSTD(3)),
     metric_type Enum8('gauge' = 1, 'counter' = 2, 'histogram' = 3, 'summary' = 4) CODEC(ZSTD(3)),
+    attributes_hash UInt64 CODEC(ZSTD(3)),
 
     -- Aggregated values
-    value_avg Float64 CODEC(ZSTD(3)),
-    value_min Float64 CODEC(ZSTD(3)),
-    value_max Float64 CODEC(ZSTD(3)),
-    value_sum Float64 CODEC(ZSTD(3)),
-    value_count UInt64 CODEC(ZSTD(3)),
+    value_min SimpleAggregateFunction(min, Float64) CODEC(ZSTD(3)),
+    value_max SimpleAggregateFunction(max, Float64) CODEC(ZSTD(3)),
+    value_sum SimpleAggregateFunction(sum, Float64) CODEC(ZSTD(3)),
+    value_count SimpleAggregateFunction(sum, UInt64) CODEC(ZSTD(3)),
 
-    attributes Map(String, String) CODEC(ZSTD(3))
+    attributes SimpleAggregateFunction(any, Map(String, String)) CODEC(ZSTD(3))
 )
-ENGINE = MergeTree()
+ENGINE = AggregatingMergeTree()
 PARTITION BY toYYYYMM(timestamp)
-ORDER BY (timestamp, metric_name, service_name)
+ORDER BY (timestamp, metric_name, service_name, metric_type, attributes_hash)
 TTL timestamp + INTERVAL 90 DAY
 SETTINGS index_granularity = 8192;
 
@@ -62,19 +69,19 @@ CREATE TABLE IF NOT EXISTS otel_metrics_1h (
     metric_name LowCardinality(String) CODEC(ZSTD(3)),
     service_name LowCardinality(String) CODEC(ZSTD(3)),
     metric_type Enum8('gauge' = 1, 'counter' = 2, 'histogram' = 3, 'summary' = 4) CODEC(ZSTD(3)),
+    attributes_hash UInt64 CODEC(ZSTD(3)),
 
     -- Aggregated values
-    value_avg Float64 CODEC(ZSTD(3)),
-    value_min Float64 CODEC(ZSTD(3)),
-    value_max Float64 CODEC(ZSTD(3)),
-    value_sum Float64 CODEC(ZSTD(3)),
-    value_count UInt64 CODEC(ZSTD(3)),
+    value_min SimpleAggregateFunction(min, Float64) CODEC(ZSTD(3)),
+    value_max SimpleAggregateFunction(max, Float64) CODEC(ZSTD(3)),
+    value_sum SimpleAggregateFunction(sum, Float64) CODEC(ZSTD(3)),
+    value_count SimpleAggregateFunction(sum, UInt64) CODEC(ZSTD(3)),
 
-    attributes Map(String, String) CODEC(ZSTD(3))
+    attributes SimpleAggregateFunction(any, Map(String, String)) CODEC(ZSTD(3))
 )
-ENGINE = MergeTree()
+ENGINE = AggregatingMergeTree()
 PARTITION BY toYYYYMM(timestamp)
-ORDER BY (timestamp, metric_name, service_name)
+ORDER BY (timestamp, metric_name, service_name, metric_type, attributes_hash)
 TTL timestamp + INTERVAL 1 YEAR
 SETTINGS index_granularity = 8192;
 
@@ -86,14 +93,14 @@ AS SELECT
     metric_name,
     service_name,
     metric_type,
-    avg(value) AS value_avg,
+    cityHash64(mapKeys(attributes), mapValues(attributes)) AS attributes_hash,
     min(value) AS value_min,
     max(value) AS value_max,
     sum(value) AS value_sum,
     count() AS value_count,
-    attributes
+    any(attributes) AS attributes
 FROM otel_metrics
-GROUP BY timestamp, metric_name, service_name, metric_type, attributes;
+GROUP BY timestamp, metric_name, service_name, metric_type, attributes_hash;
 
 -- Materialized view for 1-hour rollups
 CREATE MATERIALIZED VIEW IF NOT EXISTS otel_metrics_1h_mv
@@ -103,11 +110,11 @@ AS SELECT
     metric_name,
     service_name,
     metric_type,
-    avg(value) AS value_avg,
+    cityHash64(mapKeys(attributes), mapValues(attributes)) AS attributes_hash,
     min(value) AS value_min,
     max(value) AS value_max,
     sum(value) AS value_sum,
     count() AS value_count,
-    attributes
+    any(attributes) AS attributes
 FROM otel_metrics
-GROUP BY timestamp, metric_name, service_name, metric_type, attributes;
\ No newline at end of file
+GROUP BY timestamp, metric_name, service_name, metric_type, attributes_hash;
@@ -27,11 +27,19 @@ CREATE TABLE IF NOT EXISTS otel_logs (
 
     -- Attributes
     attributes Map(String, String) CODEC(ZSTD(3)),
+    -- Integer, double and boolean attributes with their types, for numeric
+    -- comparisons; attributes holds them as strings too
+    attributes_int Map(String, Int64) CODEC(ZSTD(3)),
+    attributes_double Map(String, Float64) CODEC(ZSTD(3)),
+    attributes_bool Map(String, Bool) CODEC(ZSTD(3)),
     resource_attributes Map(String, String) CODEC(ZSTD(3)),
+    -- Attributes the SDK dropped before export, per OTLP
+    dropped_attributes_count UInt32 CODEC(ZSTD(3)),
 
     -- Metadata
     instrumentation_scope_name LowCardinality(String) CODEC(ZSTD(3)),
     instrumentation_scope_version String CODEC(ZSTD(3)),
+    scope_attributes Map(String, String) CODEC(ZSTD(3)),
 
     INDEX idx_service_name service_name TYPE bloom_filter(0.01) GRANULARITY 4,
     INDEX idx_severity severity_text TYPE set(0) GRANULARITY 4,
@@ -6,6 +6,7 @@ CREATE TABLE IF NOT EXISTS otel_traces (
     trace_id String CODEC(ZSTD(3)),
     span_id String CODEC(ZSTD(3)),
     parent_span_id String CODEC(ZSTD(3)),
+    trace_state String CODEC(ZSTD(3)),
 
     -- Span details
     span_name LowCardinality(String) CODEC(ZSTD(3)),
@@ -27,6 +28,14 @@ CREATE TABLE IF NOT EXISTS otel_traces (
     -- Attributes
     attributes Map(String, String) CODEC(ZSTD(3)),
     resource_attributes Map(String, String) CODEC(ZSTD(3)),
+    -- Attributes beyond the hot set of spans with many attributes, as JSON.
+    -- Only read when a query asks for them.
+    attributes_cold String CODEC(ZSTD(6)),
+    -- Integer, double and boolean attributes with their types, for numeric
+    -- comparisons; attributes holds them as strings too
+    attributes_int Map(String, Int64) CODEC(ZSTD(3)),
+    attributes_double Map(String, Float64) CODEC(ZSTD(3)),
+    attributes_bool Map(String, Bool) CODEC(ZSTD(3)),
 
     -- Events
     events Array(Tuple(
@@ -43,9 +52,15 @@ CREATE TABLE IF NOT EXISTS otel_traces (
         attributes Map(String, String)
     )) CODEC(ZSTD(3)),
 
+    -- What the SDK dropped before export, per OTLP
+    dropped_attributes_count UInt32 CODEC(ZSTD(3)),
+    dropped_events_count UInt32 CODEC(ZSTD(3)),
+    dropped_links_count UInt32 CODEC(ZSTD(3)),
+
     -- Metadata
     instrumentation_scope_name LowCardinality(String) CODEC(ZSTD(3)),
     instrumentation_scope_version String CODEC(ZSTD(3)),
+    scope_attributes Map(String, String) CODEC(ZSTD(3)),
 
     INDEX idx_trace_id trace_id TYPE bloom_filter(0.01) GRANULARITY 4,
     INDEX idx_service_name service_name TYPE bloom_filter(0.01) GRANULARITY 4,
@@ -0,0 +1,14 @@
+-- Service/operation dictionary
+-- Small table refreshed by the collector so discovery queries avoid DISTINCT scans over otel_traces
+
+CREATE TABLE IF NOT EXISTS otel_service_operations (
+    timestamp DateTime CODEC(Delta, ZSTD(3)),
+    service_name LowCardinality(String) CODEC(ZSTD(3)),
+    span_name LowCardinality(String) CODEC(ZSTD(3)),
+    span_kind Enum8('internal' = 1, 'server' = 2, 'client' = 3, 'producer' = 4, 'consumer' = 5) CODEC(ZSTD(3))
+)
+ENGINE = ReplacingMergeTree()
+PARTITION BY toYYYYMM(timestamp)
+ORDER BY (service_name, span_name, span_kind, timestamp)
+TTL timestamp + INTERVAL 30 DAY
+SETTINGS index_granularity = 8192;
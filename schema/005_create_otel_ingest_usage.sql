@@ -0,0 +1,18 @@
+-- Ingest usage accounting
+-- Bytes and items received per service, signal and hour, written by the
+-- collector when quotas are enabled. SummingMergeTree adds up the rows every
+-- collector instance writes for the same hour; always read with sum().
+
+CREATE TABLE IF NOT EXISTS otel_ingest_usage (
+    timestamp DateTime CODEC(Delta, ZSTD(3)),
+    service_name LowCardinality(String) CODEC(ZSTD(3)),
+    service_namespace LowCardinality(String) CODEC(ZSTD(3)),
+    signal LowCardinality(String) CODEC(ZSTD(3)),
+    bytes UInt64 CODEC(ZSTD(3)),
+    items UInt64 CODEC(ZSTD(3))
+)
+ENGINE = SummingMergeTree()
+PARTITION BY toYYYYMM(timestamp)
+ORDER BY (service_name, service_namespace, signal, timestamp)
+TTL timestamp + INTERVAL 400 DAY
+SETTINGS index_granularity = 8192;
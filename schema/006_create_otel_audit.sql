@@ -0,0 +1,23 @@
+-- Query audit log
+-- One row per query API request, written by the query service when auditing
+-- is enabled: who asked, which route, the normalized filters, the rows
+-- returned and how long it took. Kept for compliance reviews.
+
+CREATE TABLE IF NOT EXISTS otel_audit (
+    timestamp DateTime64(3) CODEC(Delta, ZSTD(3)),
+    request_id String CODEC(ZSTD(3)),
+    principal LowCardinality(String) CODEC(ZSTD(3)),
+    auth_method LowCardinality(String) CODEC(ZSTD(3)),
+    method LowCardinality(String) CODEC(ZSTD(3)),
+    route LowCardinality(String) CODEC(ZSTD(3)),
+    filters String CODEC(ZSTD(3)),
+    status UInt16 CODEC(ZSTD(3)),
+    rows UInt64 CODEC(ZSTD(3)),
+    duration_ns UInt64 CODEC(ZSTD(3)),
+    remote_addr String CODEC(ZSTD(3))
+)
+ENGINE = MergeTree()
+PARTITION BY toYYYYMM(timestamp)
+ORDER BY (principal, timestamp)
+TTL toDateTime(timestamp) + INTERVAL 400 DAY
+SETTINGS index_granularity = 8192;
@@ -0,0 +1,308 @@
+//go:build soak
+// +build soak
+
+package soak
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"otelservices/tests/testutil"
+)
+
+const (
+	composeFile       = "deployments/docker/docker-compose.yaml"
+	collectorName     = "otel-collector"
+	clickhouseName    = "otel-clickhouse"
+	collectorReadyURL = "http://localhost:8080/ready"
+)
+
+// soakConfig holds the tunables of a soak run, read from SOAK_* environment variables
+type soakConfig struct {
+	Duration        time.Duration
+	Rate            int
+	Workers         int
+	RestartInterval time.Duration
+	MaxLossPercent  float64
+	SettleTimeout   time.Duration
+	KeepEnv         bool
+}
+
+func loadSoakConfig(t *testing.T) soakConfig {
+	t.Helper()
+
+	// The defaults of the Makefile and tests/README.md
+	cfg := soakConfig{
+		Duration:        10 * time.Minute,
+		Rate:            10000,
+		Workers:         10,
+		RestartInterval: 3 * time.Minute,
+		MaxLossPercent:  0.1,
+		SettleTimeout:   2 * time.Minute,
+	}
+
+	var err error
+	if val := os.Getenv("SOAK_DURATION"); val != "" {
+		if cfg.Duration, err = time.ParseDuration(val); err != nil {
+			t.Fatalf("Invalid SOAK_DURATION: %v", err)
+		}
+	}
+	if val := os.Getenv("SOAK_RATE"); val != "" {
+		if cfg.Rate, err = strconv.Atoi(val); err != nil {
+			t.Fatalf("Invalid SOAK_RATE: %v", err)
+		}
+	}
+	if val := os.Getenv("SOAK_WORKERS"); val != "" {
+		if cfg.Workers, err = strconv.Atoi(val); err != nil {
+			t.Fatalf("Invalid SOAK_WORKERS: %v", err)
+		}
+	}
+	if val := os.Getenv("SOAK_RESTART_INTERVAL"); val != "" {
+		if cfg.RestartInterval, err = time.ParseDuration(val); err != nil {
+			t.Fatalf("Invalid SOAK_RESTART_INTERVAL: %v", err)
+		}
+	}
+	if val := os.Getenv("SOAK_MAX_LOSS_PERCENT"); val != "" {
+		if cfg.MaxLossPercent, err = strconv.ParseFloat(val, 64); err != nil {
+			t.Fatalf("Invalid SOAK_MAX_LOSS_PERCENT: %v", err)
+		}
+	}
+	if val := os.Getenv("SOAK_SETTLE_TIMEOUT"); val != "" {
+		if cfg.SettleTimeout, err = time.ParseDuration(val); err != nil {
+			t.Fatalf("Invalid SOAK_SETTLE_TIMEOUT: %v", err)
+		}
+	}
+	cfg.KeepEnv = os.Getenv("SOAK_KEEP_ENV") != ""
+
+	return cfg
+}
+
+// TestSoakIngestionLoss runs the collector and ClickHouse in containers, drives
+// the load generator against them (optionally restarting the collector along
+// the way) and asserts that the share of acknowledged spans missing from
+// ClickHouse stays below the configured threshold.
+func TestSoakIngestionLoss(t *testing.T) {
+	cfg := loadSoakConfig(t)
+	root := projectRoot(t)
+
+	t.Logf("Soak config: duration=%s rate=%d workers=%d restart_interval=%s max_loss=%.3f%%",
+		cfg.Duration, cfg.Rate, cfg.Workers, cfg.RestartInterval, cfg.MaxLossPercent)
+
+	run(t, root, nil, "docker-compose", "-f", composeFile, "up", "-d", "--build", "clickhouse", collectorName)
+	if !cfg.KeepEnv {
+		defer run(t, root, nil, "docker-compose", "-f", composeFile, "down")
+	}
+
+	initSchema(t, root)
+	waitForReady(t, collectorReadyURL, 2*time.Minute)
+
+	client := testutil.CreateTestClickHouseClient(t)
+	defer client.Close()
+	testutil.CleanupTestData(t, client)
+
+	loadTest := filepath.Join(t.TempDir(), "load_test")
+	run(t, filepath.Join(root, "benchmarks"), nil, "go", "build", "-o", loadTest, ".")
+	resultsPath := filepath.Join(t.TempDir(), "results.json")
+
+	var output bytes.Buffer
+	bench := exec.Command(loadTest,
+		"-endpoint", "localhost:4317",
+		"-duration", cfg.Duration.String(),
+		"-rate", strconv.Itoa(cfg.Rate),
+		"-workers", strconv.Itoa(cfg.Workers),
+		"-output", resultsPath,
+	)
+	bench.Stdout = &output
+	bench.Stderr = os.Stderr
+	if err := bench.Start(); err != nil {
+		t.Fatalf("Failed to start load generator: %v", err)
+	}
+
+	// Chaos: restart the collector periodically while load is running
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	restarts := 0
+	if cfg.RestartInterval > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ticker := time.NewTicker(cfg.RestartInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					t.Logf("Restarting %s", collectorName)
+					if out, err := exec.Command("docker", "restart", collectorName).CombinedOutput(); err != nil {
+						t.Logf("Restart failed: %v: %s", err, out)
+						continue
+					}
+					restarts++
+				}
+			}
+		}()
+	}
+
+	benchErr := bench.Wait()
+	cancel()
+	wg.Wait()
+	if benchErr != nil {
+		t.Fatalf("Load generator failed: %v\n%s", benchErr, output.String())
+	}
+
+	acknowledged, err := readSucceededSpans(resultsPath)
+	if err != nil {
+		t.Fatalf("Failed to read load generator results: %v\n%s", err, output.String())
+	}
+	if acknowledged == 0 {
+		t.Fatal("Load generator did not get any spans acknowledged")
+	}
+
+	stored := waitForStableCount(t, func() (uint64, error) {
+		var count uint64
+		err := client.QueryRow(context.Background(), "SELECT count() FROM otel_traces").Scan(&count)
+		return count, err
+	}, cfg.SettleTimeout)
+
+	lost := int64(acknowledged) - int64(stored)
+	lossPercent := float64(lost) / float64(acknowledged) * 100
+
+	t.Logf("Acknowledged: %d | Stored: %d | Lost: %d (%.4f%%) | Collector restarts: %d",
+		acknowledged, stored, lost, lossPercent, restarts)
+
+	if lossPercent > cfg.MaxLossPercent {
+		t.Errorf("Loss %.4f%% exceeds threshold %.4f%%", lossPercent, cfg.MaxLossPercent)
+	}
+}
+
+// projectRoot returns the repository root relative to this package
+func projectRoot(t *testing.T) string {
+	t.Helper()
+
+	root, err := filepath.Abs(filepath.Join("..", ".."))
+	if err != nil {
+		t.Fatalf("Failed to resolve project root: %v", err)
+	}
+	return root
+}
+
+// run executes a command in dir and fails the test if it does not succeed
+func run(t *testing.T, dir string, stdin *os.File, name string, args ...string) {
+	t.Helper()
+
+	cmd := exec.Command(name, args...)
+	cmd.Dir = dir
+	if stdin != nil {
+		cmd.Stdin = stdin
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%s %s failed: %v\n%s", name, strings.Join(args, " "), err, out)
+	}
+}
+
+// initSchema applies the schema files to the ClickHouse container
+func initSchema(t *testing.T, root string) {
+	t.Helper()
+
+	files, err := filepath.Glob(filepath.Join(root, "schema", "*.sql"))
+	if err != nil {
+		t.Fatalf("Failed to list schema files: %v", err)
+	}
+
+	deadline := time.Now().Add(2 * time.Minute)
+	for exec.Command("docker", "exec", clickhouseName, "clickhouse-client", "--query", "SELECT 1").Run() != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("Timeout waiting for ClickHouse")
+		}
+		time.Sleep(2 * time.Second)
+	}
+
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			t.Fatalf("Failed to open %s: %v", file, err)
+		}
+		run(t, root, f, "docker", "exec", "-i", clickhouseName, "clickhouse-client", "--multiquery")
+		f.Close()
+	}
+}
+
+// waitForReady polls a readiness endpoint until it returns 200
+func waitForReady(t *testing.T, url string, timeout time.Duration) {
+	t.Helper()
+
+	testutil.WaitForCondition(t, func() bool {
+		resp, err := http.Get(url)
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return resp.StatusCode == http.StatusOK
+	}, timeout, fmt.Sprintf("%s to become ready", url))
+}
+
+// loadResults is the part of the load generator's -output JSON results the
+// soak test reads
+type loadResults struct {
+	Signals []struct {
+		Signal    string `json:"signal"`
+		Succeeded uint64 `json:"succeeded"`
+	} `json:"signals"`
+}
+
+// readSucceededSpans reads the acknowledged span count from the load
+// generator's results file
+func readSucceededSpans(path string) (uint64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	var results loadResults
+	if err := json.Unmarshal(data, &results); err != nil {
+		return 0, fmt.Errorf("%s: %w", path, err)
+	}
+	for _, r := range results.Signals {
+		if r.Signal == "traces" {
+			return r.Succeeded, nil
+		}
+	}
+	return 0, fmt.Errorf("%s: no traces in the results", path)
+}
+
+// waitForStableCount polls count until it stops changing between two polls,
+// giving the collector time to flush its queues after the load stops
+func waitForStableCount(t *testing.T, count func() (uint64, error), timeout time.Duration) uint64 {
+	t.Helper()
+
+	deadline := time.Now().Add(timeout)
+	var last uint64
+	for {
+		time.Sleep(15 * time.Second)
+
+		current, err := count()
+		if err != nil {
+			t.Logf("Count failed: %v", err)
+		} else if current == last && current > 0 {
+			return current
+		} else {
+			last = current
+		}
+
+		if time.Now().After(deadline) {
+			t.Logf("Count did not settle within %s, using last value", timeout)
+			return last
+		}
+	}
+}